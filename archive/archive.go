@@ -0,0 +1,148 @@
+// Package archive writes closed channels, with their payments and
+// revocation secrets, to batches in object storage, so that they can be
+// deleted from the receiver's database.
+//
+// A batch is the gzipped JSON encoding of a Batch. Batches are written once,
+// under keys that sort by the time they were written, and never modified.
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+// Version is the version of the batch format written by Encode.
+const Version = 1
+
+var ErrNotFound = errors.New("archive: object not found")
+
+// Channel is an archived channel.
+type Channel struct {
+	// Record is the channel's record when it was archived.
+	Record storage.Record
+
+	Payments          []storage.StoredPayment
+	RevocationSecrets [][]byte
+}
+
+// Batch is the content of an archive object.
+type Batch struct {
+	Version  int
+	Net      string
+	Created  time.Time
+	Channels []Channel
+}
+
+// Find returns the channel with the ID, or nil if it isn't in the batch.
+func (b *Batch) Find(id string) *Channel {
+	for i := range b.Channels {
+		if b.Channels[i].Record.ID == id {
+			return &b.Channels[i]
+		}
+	}
+	return nil
+}
+
+// Encode returns the compressed encoding of b.
+func Encode(b Batch) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a batch written by Encode.
+func Decode(data []byte) (*Batch, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	buf, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	var b Batch
+	if err := json.Unmarshal(buf, &b); err != nil {
+		return nil, err
+	}
+	if b.Version != Version {
+		return nil, fmt.Errorf("archive: unsupported batch version %d", b.Version)
+	}
+	return &b, nil
+}
+
+// Key returns a new key for a batch written at t. Keys are grouped by UTC
+// day and sort by time within it.
+func Key(t time.Time) (string, error) {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	t = t.UTC()
+	return fmt.Sprintf("%s/%020d-%s.json.gz", t.Format("2006/01/02"),
+		t.UnixNano(), hex.EncodeToString(buf)), nil
+}
+
+// Store is the object storage that batches are written to.
+type Store interface {
+	// Put writes the object with the key, replacing any object with it.
+	Put(ctx context.Context, key string, data []byte) error
+
+	// Get reads the object with the key, returning ErrNotFound if there is
+	// none.
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// Open returns the store at rawurl, which is one of:
+//
+//	file:///path          a directory, such as a mounted bucket
+//	s3://bucket/prefix    an Amazon S3 bucket, or any S3 compatible store
+//	                      with the endpoint query parameter
+//	gs://bucket/prefix    a Google Cloud Storage bucket, through its S3
+//	                      compatible API with an HMAC key
+//
+// S3 and GCS credentials are read like the AWS SDK's, from the environment
+// and the shared config files. The region query parameter overrides the
+// configured region.
+func Open(ctx context.Context, rawurl string) (Store, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	switch u.Scheme {
+	case "file":
+		return NewDir(u.Path)
+	case "s3":
+		return openS3(ctx, u.Query().Get("endpoint"), u.Host, prefix, u.Query().Get("region"))
+	case "gs":
+		region := u.Query().Get("region")
+		if region == "" {
+			region = "auto"
+		}
+		return openS3(ctx, gcsEndpoint, u.Host, prefix, region)
+	default:
+		return nil, fmt.Errorf("archive: unsupported store %q", rawurl)
+	}
+}
@@ -0,0 +1,136 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	b := Batch{
+		Version: Version,
+		Net:     "testnet3",
+		Created: time.Unix(1600000000, 0).UTC(),
+		Channels: []Channel{{
+			Record:            storage.Record{ID: "a"},
+			Payments:          []storage.StoredPayment{{ChannelID: "a", Payment: []byte("p1")}},
+			RevocationSecrets: [][]byte{[]byte("s1")},
+		}, {
+			Record: storage.Record{ID: "b"},
+		}},
+	}
+	data, err := Encode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Net != b.Net || !got.Created.Equal(b.Created) || len(got.Channels) != 2 {
+		t.Errorf("Expected %+v, got %+v", b, got)
+	}
+	c := got.Find("a")
+	if c == nil || len(c.Payments) != 1 || !bytes.Equal(c.Payments[0].Payment, []byte("p1")) {
+		t.Errorf("Expected channel a's payment, got %+v", c)
+	}
+	if got.Find("c") != nil {
+		t.Errorf("Expected no channel c")
+	}
+
+	if _, err := Decode([]byte("not gzip")); err == nil {
+		t.Errorf("Expected an error for an invalid batch")
+	}
+}
+
+func TestKey(t *testing.T) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	a, err := Key(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Key(now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(a, "2021/03/04/") || !strings.HasSuffix(a, ".json.gz") {
+		t.Errorf("Unexpected key %q", a)
+	}
+	if a >= b {
+		t.Errorf("Expected %q to sort before %q", a, b)
+	}
+}
+
+func TestDir(t *testing.T) {
+	ctx := context.Background()
+	s, err := Open(ctx, "file://"+t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "2021/03/04/a.json.gz"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := s.Put(ctx, "2021/03/04/a.json.gz", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.Get(ctx, "2021/03/04/a.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Errorf("Expected data, got %q", data)
+	}
+}
+
+func TestS3(t *testing.T) {
+	ctx := context.Background()
+	objects := make(map[string][]byte)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		}
+	}))
+	defer srv.Close()
+
+	creds := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "key", SecretAccessKey: "secret"}, nil
+	})
+	s := NewS3(srv.URL, "bucket", "archive/", "us-east-1", creds)
+	if err := s.Put(ctx, "2021/03/04/a.json.gz", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := objects["/bucket/archive/2021/03/04/a.json.gz"]; !ok {
+		t.Errorf("Expected the object under the bucket and prefix, got %v", objects)
+	}
+	data, err := s.Get(ctx, "2021/03/04/a.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Errorf("Expected data, got %q", data)
+	}
+	if _, err := s.Get(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
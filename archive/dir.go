@@ -0,0 +1,56 @@
+package archive
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Dir is a store of files in a directory.
+type Dir struct {
+	path string
+}
+
+// NewDir returns a store in the directory at path, creating it if it
+// doesn't exist.
+func NewDir(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+	return &Dir{path: path}, nil
+}
+
+// Put writes the object to a temporary file that's synced and then renamed,
+// so that a batch is either written entirely or not at all.
+func (d *Dir) Put(ctx context.Context, key string, data []byte) error {
+	path := filepath.Join(d.path, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (d *Dir) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(d.path, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
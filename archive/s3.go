@@ -0,0 +1,131 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// gcsEndpoint is the endpoint of Google Cloud Storage's S3 compatible API.
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// S3 is a store of objects in a bucket, accessed with path-style requests
+// signed with AWS Signature Version 4. Only PUT and GET are used, which
+// every S3 compatible store supports.
+type S3 struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+	prefix   string
+	region   string
+	creds    aws.CredentialsProvider
+	signer   *v4.Signer
+}
+
+// NewS3 returns a store of the objects in the bucket whose keys start with
+// prefix. If endpoint is empty, it's Amazon S3's in the region.
+func NewS3(endpoint, bucket, prefix, region string, creds aws.CredentialsProvider) *S3 {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	return &S3{
+		client:   &http.Client{Timeout: time.Minute},
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		bucket:   bucket,
+		prefix:   prefix,
+		region:   region,
+		creds:    creds,
+		signer:   v4.NewSigner(),
+	}
+}
+
+// openS3 returns a store with the credentials, and the region unless one is
+// given, of the default AWS configuration.
+func openS3(ctx context.Context, endpoint, bucket, prefix, region string) (*S3, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("archive: missing bucket")
+	}
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("archive: no region configured for bucket %s", bucket)
+	}
+	return NewS3(endpoint, bucket, prefix, cfg.Region, cfg.Credentials), nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	default:
+		return nil, responseError(resp)
+	}
+}
+
+// do makes a signed request for the object.
+func (s *S3) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	u := s.endpoint + "/" + s.bucket + "/" + (&url.URL{Path: s.prefix + key}).EscapedPath()
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/gzip")
+	}
+
+	creds, err := s.creds.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", hash)
+	if err := s.signer.SignHTTP(ctx, creds, req, hash, "s3", s.region, time.Now()); err != nil {
+		return nil, err
+	}
+	return s.client.Do(req)
+}
+
+// responseError returns the status and the start of the error document.
+func responseError(resp *http.Response) error {
+	buf, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("archive: %s %s: %s: %s", resp.Request.Method,
+		resp.Request.URL.Path, resp.Status, bytes.TrimSpace(buf))
+}
@@ -0,0 +1,138 @@
+// Package backup reads and writes encrypted archives of a receiver's stored
+// state.
+//
+// An archive is a magic header followed by a random nonce and the gzipped
+// JSON encoding of an Archive, sealed with AES-256-GCM. The header is
+// authenticated too, so any change to an archive is detected when it's read.
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Version is the version of the archive format written by Write.
+const Version = 1
+
+// KeySize is the size of the key archives are encrypted with.
+const KeySize = 32
+
+var magic = []byte("moonbeam-backup\n")
+
+var (
+	ErrInvalidKey = errors.New("backup: key must be 32 bytes")
+
+	// ErrCorrupt is returned for archives that weren't written with the
+	// key or have been modified since.
+	ErrCorrupt = errors.New("backup: archive is corrupt or encrypted with another key")
+)
+
+// Manifest describes the state in an archive.
+type Manifest struct {
+	Version int
+	Net     string
+	Created time.Time
+
+	Channels       int
+	Payments       int
+	KeyPathCounter int
+}
+
+// Archive is the content of a backup.
+type Archive struct {
+	Manifest
+
+	// State is the storage's encoding of its entire state.
+	State []byte
+}
+
+// ParseKey decodes a hex encoded key, such as one generated with
+// openssl rand -hex 32.
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil || len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Write writes a to w, encrypted with key.
+func Write(w io.Writer, key []byte, a Archive) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(a); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	out := append(append([]byte(nil), magic...), nonce...)
+	out = gcm.Seal(out, nonce, buf.Bytes(), magic)
+	_, err = w.Write(out)
+	return err
+}
+
+// Read reads an archive written by Write with key.
+func Read(r io.Reader, key []byte) (*Archive, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(buf, magic) || len(buf) < len(magic)+gcm.NonceSize() {
+		return nil, errors.New("backup: not an archive")
+	}
+	buf = buf[len(magic):]
+	nonce, sealed := buf[:gcm.NonceSize()], buf[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, sealed, magic)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return nil, err
+	}
+	var a Archive
+	if err := json.NewDecoder(zr).Decode(&a); err != nil {
+		return nil, err
+	}
+	if a.Version != Version {
+		return nil, errors.New("backup: unsupported archive version")
+	}
+	return &a, nil
+}
@@ -0,0 +1,61 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	a := Archive{
+		Manifest: Manifest{
+			Version:  Version,
+			Net:      "testnet3",
+			Created:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			Channels: 2,
+		},
+		State: []byte(`{"Channels":{}}`),
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, key, a); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("testnet3")) {
+		t.Error("Expected archive to be encrypted")
+	}
+
+	got, err := Read(bytes.NewReader(buf.Bytes()), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Net != a.Net || !got.Created.Equal(a.Created) || got.Channels != 2 ||
+		!bytes.Equal(got.State, a.State) {
+		t.Errorf("Read %+v, expected %+v", got, a)
+	}
+
+	other := bytes.Repeat([]byte{2}, KeySize)
+	if _, err := Read(bytes.NewReader(buf.Bytes()), other); err != ErrCorrupt {
+		t.Errorf("Expected ErrCorrupt with another key, got %v", err)
+	}
+
+	tampered := append([]byte(nil), buf.Bytes()...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := Read(bytes.NewReader(tampered), key); err != ErrCorrupt {
+		t.Errorf("Expected ErrCorrupt for modified archive, got %v", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	if _, err := ParseKey("00ff"); err != ErrInvalidKey {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+	key, err := ParseKey("0101010101010101010101010101010101010101010101010101010101010101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, bytes.Repeat([]byte{1}, KeySize)) {
+		t.Errorf("Unexpected key %x", key)
+	}
+}
@@ -0,0 +1,85 @@
+// Package chain provides access to the blockchain through a full node or
+// another backend.
+package chain
+
+import (
+	"context"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// TxOut is an unspent transaction output.
+type TxOut struct {
+	Value         int64
+	PkScript      []byte
+	Confirmations int
+	Coinbase      bool
+
+	// BestBlock is the hash of the chain tip that Confirmations is counted
+	// from.
+	BestBlock string
+}
+
+// TxStatus is the confirmation status of a transaction.
+type TxStatus struct {
+	Confirmations int
+
+	// BlockHash and BlockHeight identify the confirming block, if any.
+	BlockHash   string
+	BlockHeight int64
+}
+
+// Backend is a source of blockchain data.
+type Backend interface {
+	// GetTxOut returns an unspent output, or nil if the output is spent or
+	// doesn't exist. If includeMempool is set, outputs of unconfirmed
+	// transactions are returned and outputs spent by them are not.
+	GetTxOut(ctx context.Context, txid string, vout uint32, includeMempool bool) (*TxOut, error)
+
+	// GetHeight returns the height of the block with the given hash, or of
+	// the chain tip if hash is empty.
+	GetHeight(ctx context.Context, hash string) (int64, error)
+
+	// GetTxStatus returns the status of a transaction, or nil if the
+	// transaction isn't in the mempool or the chain.
+	GetTxStatus(ctx context.Context, txid string) (*TxStatus, error)
+
+	// Broadcast submits the transaction to the network and returns its txid.
+	Broadcast(ctx context.Context, tx *wire.MsgTx) (string, error)
+
+	// SubscribeBlocks sends the height of the chain tip, and then the new
+	// height whenever it changes, until ctx is cancelled.
+	SubscribeBlocks(ctx context.Context) (<-chan int64, error)
+}
+
+// UTXO is an unspent output found by address.
+type UTXO struct {
+	TxID string
+	Vout uint32
+	TxOut
+}
+
+// AddressScanner is implemented by backends that can find outputs by
+// address.
+type AddressScanner interface {
+	// ListUnspent returns the confirmed unspent outputs paying to any of
+	// addrs.
+	ListUnspent(ctx context.Context, addrs []string) ([]UTXO, error)
+}
+
+// SpendFinder is implemented by backends that can find the transaction
+// spending an output.
+type SpendFinder interface {
+	// GetSpender returns the txid of the transaction spending an output, or
+	// an empty string if the output is unspent or its spender isn't known.
+	GetSpender(ctx context.Context, txid string, vout uint32) (string, error)
+}
+
+// ScriptWatcher is implemented by backends that only know about outputs
+// paying to scripts they have been asked to watch.
+type ScriptWatcher interface {
+	// WatchScript makes outputs paying to pkScript in blocks from height
+	// on, and their spends, visible. A height of zero means blocks after
+	// the current tip.
+	WatchScript(pkScript []byte, height int64)
+}
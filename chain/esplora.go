@@ -0,0 +1,268 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/luno/moonbeam/logging"
+)
+
+// Esplora is a Backend that uses an Esplora compatible REST API, such as
+// the one run by blockstream.info.
+type Esplora struct {
+	url    string
+	client *http.Client
+
+	// PollInterval is how often SubscribeBlocks polls for new blocks.
+	PollInterval time.Duration
+}
+
+// NewEsplora returns a backend for the API at url, for example
+// https://blockstream.info/testnet/api.
+func NewEsplora(url string) *Esplora {
+	return &Esplora{
+		url:          strings.TrimSuffix(url, "/"),
+		client:       &http.Client{Timeout: 30 * time.Second},
+		PollInterval: DefaultPollInterval,
+	}
+}
+
+type esploraStatus struct {
+	Confirmed   bool   `json:"confirmed"`
+	BlockHeight int64  `json:"block_height"`
+	BlockHash   string `json:"block_hash"`
+}
+
+type esploraTx struct {
+	Vin []struct {
+		IsCoinbase bool `json:"is_coinbase"`
+	} `json:"vin"`
+	Vout []struct {
+		ScriptPubKey string `json:"scriptpubkey"`
+		Value        int64  `json:"value"`
+	} `json:"vout"`
+	Status esploraStatus `json:"status"`
+}
+
+type esploraOutspend struct {
+	Spent  bool          `json:"spent"`
+	TxID   string        `json:"txid"`
+	Status esploraStatus `json:"status"`
+}
+
+type esploraBlock struct {
+	Height int64 `json:"height"`
+}
+
+var errNotFound = errors.New("not found")
+
+func (e *Esplora) do(ctx context.Context, method, path string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, e.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("esplora %s %s: %s: %s", method, path,
+			resp.Status, strings.TrimSpace(string(buf)))
+	}
+	return buf, nil
+}
+
+func (e *Esplora) get(ctx context.Context, path string, res interface{}) error {
+	buf, err := e.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, res)
+}
+
+func (e *Esplora) getText(ctx context.Context, path string) (string, error) {
+	buf, err := e.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+func (e *Esplora) GetTxOut(ctx context.Context, txid string, vout uint32, includeMempool bool) (*TxOut, error) {
+	var tx esploraTx
+	err := e.get(ctx, "/tx/"+txid, &tx)
+	if err == errNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if int(vout) >= len(tx.Vout) {
+		return nil, nil
+	}
+	if !tx.Status.Confirmed && !includeMempool {
+		return nil, nil
+	}
+
+	var spend esploraOutspend
+	if err := e.get(ctx, fmt.Sprintf("/tx/%s/outspend/%d", txid, vout), &spend); err != nil {
+		return nil, err
+	}
+	if spend.Spent && (includeMempool || spend.Status.Confirmed) {
+		return nil, nil
+	}
+
+	tip, err := e.getText(ctx, "/blocks/tip/hash")
+	if err != nil {
+		return nil, err
+	}
+	height, err := e.GetHeight(ctx, tip)
+	if err != nil {
+		return nil, err
+	}
+
+	out := tx.Vout[vout]
+	pkscript, err := hex.DecodeString(out.ScriptPubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var conf int
+	if tx.Status.Confirmed {
+		conf = int(height - tx.Status.BlockHeight + 1)
+	}
+
+	return &TxOut{
+		Value:         out.Value,
+		PkScript:      pkscript,
+		Confirmations: conf,
+		Coinbase:      len(tx.Vin) > 0 && tx.Vin[0].IsCoinbase,
+		BestBlock:     tip,
+	}, nil
+}
+
+func (e *Esplora) GetHeight(ctx context.Context, hash string) (int64, error) {
+	if hash == "" {
+		s, err := e.getText(ctx, "/blocks/tip/height")
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(s, 10, 64)
+	}
+	var b esploraBlock
+	if err := e.get(ctx, "/block/"+hash, &b); err != nil {
+		return 0, err
+	}
+	return b.Height, nil
+}
+
+func (e *Esplora) GetBlockHash(ctx context.Context, height int64) (string, error) {
+	return e.getText(ctx, fmt.Sprintf("/block-height/%d", height))
+}
+
+func (e *Esplora) GetTxStatus(ctx context.Context, txid string) (*TxStatus, error) {
+	var st esploraStatus
+	err := e.get(ctx, "/tx/"+txid+"/status", &st)
+	if err == errNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !st.Confirmed {
+		return &TxStatus{}, nil
+	}
+
+	height, err := e.GetHeight(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return &TxStatus{
+		Confirmations: int(height - st.BlockHeight + 1),
+		BlockHash:     st.BlockHash,
+		BlockHeight:   st.BlockHeight,
+	}, nil
+}
+
+func (e *Esplora) Broadcast(ctx context.Context, tx *wire.MsgTx) (string, error) {
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return "", err
+	}
+	body := strings.NewReader(hex.EncodeToString(buf.Bytes()))
+	res, err := e.do(ctx, http.MethodPost, "/tx", body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(res)), nil
+}
+
+func (e *Esplora) GetSpender(ctx context.Context, txid string, vout uint32) (string, error) {
+	var spend esploraOutspend
+	err := e.get(ctx, fmt.Sprintf("/tx/%s/outspend/%d", txid, vout), &spend)
+	if err == errNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if !spend.Spent {
+		return "", nil
+	}
+	return spend.TxID, nil
+}
+
+type esploraUTXO struct {
+	TxID   string        `json:"txid"`
+	Vout   uint32        `json:"vout"`
+	Status esploraStatus `json:"status"`
+}
+
+func (e *Esplora) ListUnspent(ctx context.Context, addrs []string) ([]UTXO, error) {
+	var utxos []UTXO
+	for _, addr := range addrs {
+		var res []esploraUTXO
+		if err := e.get(ctx, "/address/"+addr+"/utxo", &res); err != nil {
+			return nil, err
+		}
+		for _, u := range res {
+			if !u.Status.Confirmed {
+				continue
+			}
+			out, err := e.GetTxOut(ctx, u.TxID, u.Vout, false)
+			if err != nil {
+				return nil, err
+			} else if out == nil {
+				continue
+			}
+			utxos = append(utxos, UTXO{TxID: u.TxID, Vout: u.Vout, TxOut: *out})
+		}
+	}
+	return utxos, nil
+}
+
+func (e *Esplora) SubscribeBlocks(ctx context.Context) (<-chan int64, error) {
+	return pollBlocks(ctx, e.PollInterval, func() (int64, error) {
+		return e.GetHeight(ctx, "")
+	}), nil
+}
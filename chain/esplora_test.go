@@ -0,0 +1,151 @@
+package chain
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testTxID = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+
+func newTestEsplora(t *testing.T, spent string) *Esplora {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tx/"+testTxID, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"vin":[{"is_coinbase":false}],"vout":[` +
+			`{"scriptpubkey":"0014aa","value":1000},` +
+			`{"scriptpubkey":"a914bb87","value":2000}],` +
+			`"status":{"confirmed":true,"block_height":98}}`))
+	})
+	mux.HandleFunc("/tx/"+testTxID+"/outspend/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(spent))
+	})
+	mux.HandleFunc("/tx/"+testTxID+"/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"confirmed":true,"block_height":98,"block_hash":"blockhash"}`))
+	})
+	mux.HandleFunc("/blocks/tip/hash", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tiphash"))
+	})
+	mux.HandleFunc("/block/tiphash", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"height":100}`))
+	})
+	mux.HandleFunc("/blocks/tip/height", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("100\n"))
+	})
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return NewEsplora(s.URL + "/")
+}
+
+func TestEsploraGetTxOut(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEsplora(t, `{"spent":false}`)
+
+	out, err := e.GetTxOut(ctx, testTxID, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out == nil {
+		t.Fatalf("Expected output")
+	}
+	if out.Value != 2000 || out.Confirmations != 3 || out.BestBlock != "tiphash" ||
+		len(out.PkScript) != 4 || out.Coinbase {
+		t.Errorf("Unexpected output %+v", out)
+	}
+
+	out, err = e.GetTxOut(ctx, testTxID, 2, false)
+	if err != nil || out != nil {
+		t.Errorf("Expected missing output, got %+v %v", out, err)
+	}
+
+	out, err = e.GetTxOut(ctx, "00"+testTxID[2:], 0, false)
+	if err != nil || out != nil {
+		t.Errorf("Expected missing tx, got %+v %v", out, err)
+	}
+
+	h, err := e.GetHeight(ctx, "")
+	if err != nil || h != 100 {
+		t.Errorf("Expected height 100, got %d %v", h, err)
+	}
+}
+
+func TestEsploraGetTxStatus(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEsplora(t, `{"spent":false}`)
+
+	st, err := e.GetTxStatus(ctx, testTxID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := TxStatus{Confirmations: 3, BlockHash: "blockhash", BlockHeight: 98}
+	if st == nil || *st != expected {
+		t.Errorf("Expected %+v, got %+v", expected, st)
+	}
+
+	st, err = e.GetTxStatus(ctx, "00"+testTxID[2:])
+	if err != nil || st != nil {
+		t.Errorf("Expected missing tx, got %+v %v", st, err)
+	}
+}
+
+func TestEsploraGetTxOutSpent(t *testing.T) {
+	ctx := context.Background()
+
+	// Spent in the mempool.
+	e := newTestEsplora(t, `{"spent":true,"status":{"confirmed":false}}`)
+	if out, err := e.GetTxOut(ctx, testTxID, 1, true); err != nil || out != nil {
+		t.Errorf("Expected spent output, got %+v %v", out, err)
+	}
+	if out, err := e.GetTxOut(ctx, testTxID, 1, false); err != nil || out == nil {
+		t.Errorf("Expected unspent output, got %+v %v", out, err)
+	}
+
+	// Spent in a block.
+	e = newTestEsplora(t, `{"spent":true,"status":{"confirmed":true}}`)
+	if out, err := e.GetTxOut(ctx, testTxID, 1, false); err != nil || out != nil {
+		t.Errorf("Expected spent output, got %+v %v", out, err)
+	}
+}
+
+func TestEsploraGetSpender(t *testing.T) {
+	ctx := context.Background()
+
+	e := newTestEsplora(t, `{"spent":false}`)
+	if txid, err := e.GetSpender(ctx, testTxID, 1); err != nil || txid != "" {
+		t.Errorf("Expected no spender, got %q %v", txid, err)
+	}
+
+	e = newTestEsplora(t, `{"spent":true,"txid":"spender","status":{"confirmed":true}}`)
+	if txid, err := e.GetSpender(ctx, testTxID, 1); err != nil || txid != "spender" {
+		t.Errorf("Expected spender, got %q %v", txid, err)
+	}
+}
+
+type fakeBackend struct {
+	Backend
+	out *TxOut
+}
+
+func (f fakeBackend) GetTxOut(ctx context.Context, txid string, vout uint32, includeMempool bool) (*TxOut, error) {
+	return f.out, nil
+}
+
+func TestVerified(t *testing.T) {
+	ctx := context.Background()
+	out := &TxOut{Value: 1000, PkScript: []byte{1}, Confirmations: 6}
+
+	v := Verified{fakeBackend{out: out}, fakeBackend{out: out}}
+	if res, err := v.GetTxOut(ctx, testTxID, 0, false); err != nil || res != out {
+		t.Errorf("Expected output, got %+v %v", res, err)
+	}
+
+	v.Secondary = fakeBackend{out: &TxOut{Value: 999, PkScript: []byte{1}}}
+	if _, err := v.GetTxOut(ctx, testTxID, 0, false); err != ErrMismatch {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+
+	v.Secondary = fakeBackend{}
+	if _, err := v.GetTxOut(ctx, testTxID, 0, false); err != ErrMismatch {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// ErrUnavailable is returned when every backend's circuit breaker is open.
+var ErrUnavailable = errors.New("no chain backend available")
+
+const (
+	// DefaultCallTimeout is how long a backend call may take before it's
+	// counted as a failure.
+	DefaultCallTimeout = 10 * time.Second
+
+	// breakerThreshold is the number of consecutive failures that trips a
+	// backend's circuit breaker.
+	breakerThreshold = 3
+
+	// breakerCooldown is how long a tripped backend is skipped before it's
+	// tried again.
+	breakerCooldown = 30 * time.Second
+)
+
+// breaker is a circuit breaker for a single backend.
+type breaker struct {
+	b Backend
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// allow reports whether the backend may be called. Once the cooldown has
+// passed a single trial call is allowed, which closes the breaker if it
+// succeeds and reopens it if it fails.
+func (br *breaker) allow(now time.Time) bool {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if br.failures < breakerThreshold {
+		return true
+	}
+	if now.Before(br.openUntil) {
+		return false
+	}
+	br.openUntil = now.Add(breakerCooldown)
+	return true
+}
+
+func (br *breaker) record(now time.Time, err error) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if err == nil {
+		br.failures = 0
+		return
+	}
+	br.failures++
+	if br.failures >= breakerThreshold {
+		br.openUntil = now.Add(breakerCooldown)
+	}
+}
+
+func (br *breaker) open() bool {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	return br.failures >= breakerThreshold
+}
+
+// Failover is a Backend that calls the first of several backends whose
+// circuit breaker is closed, moving on to the next if a call fails or times
+// out. Backends that keep failing are skipped until they recover, so that
+// callers fail fast instead of waiting on a dead node.
+type Failover struct {
+	backends []*breaker
+
+	// Timeout limits each backend call.
+	Timeout time.Duration
+
+	// PollInterval is how often SubscribeBlocks polls for new blocks.
+	PollInterval time.Duration
+}
+
+// NewFailover returns a backend that prefers the backends in the order
+// given.
+func NewFailover(backends ...Backend) *Failover {
+	f := &Failover{
+		Timeout:      DefaultCallTimeout,
+		PollInterval: DefaultPollInterval,
+	}
+	for _, b := range backends {
+		f.backends = append(f.backends, &breaker{b: b})
+	}
+	return f
+}
+
+type result struct {
+	v   interface{}
+	err error
+}
+
+// withTimeout calls fn, giving up when the timeout expires even if fn
+// doesn't respect ctx.
+func withTimeout(ctx context.Context, timeout time.Duration, fn func(context.Context) (interface{}, error)) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	resc := make(chan result, 1)
+	go func() {
+		v, err := fn(ctx)
+		resc <- result{v, err}
+	}()
+
+	select {
+	case res := <-resc:
+		return res.v, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f *Failover) call(ctx context.Context, fn func(context.Context, Backend) (interface{}, error)) (interface{}, error) {
+	err := ErrUnavailable
+	for i, br := range f.backends {
+		if !br.allow(time.Now()) {
+			continue
+		}
+		b := br.b
+		var v interface{}
+		v, err = withTimeout(ctx, f.Timeout, func(ctx context.Context) (interface{}, error) {
+			return fn(ctx, b)
+		})
+		if ctx.Err() != nil {
+			// The caller gave up, which says nothing about the backend.
+			return nil, err
+		}
+		if err == ErrUnsupported {
+			continue
+		}
+		br.record(time.Now(), err)
+		if err == nil {
+			return v, nil
+		}
+		slog.Warn("chain backend call failed", "backend", i, "err", err)
+	}
+	return nil, err
+}
+
+func (f *Failover) GetTxOut(ctx context.Context, txid string, vout uint32, includeMempool bool) (*TxOut, error) {
+	v, err := f.call(ctx, func(ctx context.Context, b Backend) (interface{}, error) {
+		return b.GetTxOut(ctx, txid, vout, includeMempool)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*TxOut), nil
+}
+
+func (f *Failover) GetHeight(ctx context.Context, hash string) (int64, error) {
+	v, err := f.call(ctx, func(ctx context.Context, b Backend) (interface{}, error) {
+		return b.GetHeight(ctx, hash)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return v.(int64), nil
+}
+
+func (f *Failover) GetTxStatus(ctx context.Context, txid string) (*TxStatus, error) {
+	v, err := f.call(ctx, func(ctx context.Context, b Backend) (interface{}, error) {
+		return b.GetTxStatus(ctx, txid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*TxStatus), nil
+}
+
+// Broadcast submits the transaction through the first available backend.
+// Any backend will relay it to the network.
+func (f *Failover) Broadcast(ctx context.Context, tx *wire.MsgTx) (string, error) {
+	v, err := f.call(ctx, func(ctx context.Context, b Backend) (interface{}, error) {
+		return b.Broadcast(ctx, tx)
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+func (f *Failover) ListUnspent(ctx context.Context, addrs []string) ([]UTXO, error) {
+	v, err := f.call(ctx, func(ctx context.Context, b Backend) (interface{}, error) {
+		s, ok := b.(AddressScanner)
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		return s.ListUnspent(ctx, addrs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]UTXO), nil
+}
+
+func (f *Failover) GetSpender(ctx context.Context, txid string, vout uint32) (string, error) {
+	v, err := f.call(ctx, func(ctx context.Context, b Backend) (interface{}, error) {
+		s, ok := b.(SpendFinder)
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		return s.GetSpender(ctx, txid, vout)
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+func (f *Failover) SubscribeBlocks(ctx context.Context) (<-chan int64, error) {
+	return pollBlocks(ctx, f.PollInterval, func() (int64, error) {
+		return f.GetHeight(ctx, "")
+	}), nil
+}
+
+// Healthy reports, in order, whether each backend's circuit breaker is
+// closed.
+func (f *Failover) Healthy() []bool {
+	res := make([]bool, len(f.backends))
+	for i, br := range f.backends {
+		res[i] = !br.open()
+	}
+	return res
+}
+
+// HealthCheck probes backends whose circuit breaker is open every interval
+// until ctx is cancelled, so that they're restored as soon as they recover
+// rather than on the next call after the cooldown.
+func (f *Failover) HealthCheck(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		for i, br := range f.backends {
+			if !br.open() {
+				continue
+			}
+			b := br.b
+			_, err := withTimeout(ctx, f.Timeout, func(ctx context.Context) (interface{}, error) {
+				return b.GetHeight(ctx, "")
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				slog.Info("chain backend recovered", "backend", i)
+			}
+			br.record(time.Now(), err)
+		}
+	}
+}
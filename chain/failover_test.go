@@ -0,0 +1,81 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type heightBackend struct {
+	Backend
+	height int64
+	err    error
+	delay  time.Duration
+	calls  int
+}
+
+func (h *heightBackend) GetHeight(ctx context.Context, hash string) (int64, error) {
+	h.calls++
+	time.Sleep(h.delay)
+	return h.height, h.err
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+	primary := &heightBackend{height: 100, err: errors.New("down")}
+	secondary := &heightBackend{height: 101}
+	f := NewFailover(primary, secondary)
+
+	for i := 0; i < breakerThreshold+2; i++ {
+		h, err := f.GetHeight(ctx, "")
+		if err != nil || h != 101 {
+			t.Fatalf("Expected height 101, got %d %v", h, err)
+		}
+	}
+
+	// The primary's breaker trips and it isn't called again until the
+	// cooldown passes.
+	if primary.calls != breakerThreshold {
+		t.Errorf("Expected %d primary calls, got %d", breakerThreshold, primary.calls)
+	}
+	if healthy := f.Healthy(); healthy[0] || !healthy[1] {
+		t.Errorf("Unexpected health %v", healthy)
+	}
+
+	f.backends[0].openUntil = time.Now()
+	primary.err = nil
+	if h, err := f.GetHeight(ctx, ""); err != nil || h != 100 {
+		t.Errorf("Expected height 100 after recovery, got %d %v", h, err)
+	}
+	if healthy := f.Healthy(); !healthy[0] {
+		t.Errorf("Expected primary to be healthy")
+	}
+}
+
+func TestFailoverTimeout(t *testing.T) {
+	ctx := context.Background()
+	primary := &heightBackend{height: 100, delay: time.Second}
+	secondary := &heightBackend{height: 101}
+	f := NewFailover(primary, secondary)
+	f.Timeout = 10 * time.Millisecond
+
+	if h, err := f.GetHeight(ctx, ""); err != nil || h != 101 {
+		t.Errorf("Expected height 101, got %d %v", h, err)
+	}
+}
+
+func TestFailoverUnavailable(t *testing.T) {
+	ctx := context.Background()
+	down := errors.New("down")
+	f := NewFailover(&heightBackend{err: down})
+
+	for i := 0; i < breakerThreshold; i++ {
+		if _, err := f.GetHeight(ctx, ""); err != down {
+			t.Fatalf("Expected backend error, got %v", err)
+		}
+	}
+	if _, err := f.GetHeight(ctx, ""); err != ErrUnavailable {
+		t.Errorf("Expected ErrUnavailable, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// BlockIndex is implemented by backends that can look up blocks by height.
+type BlockIndex interface {
+	// GetBlockHash returns the hash of the block at height in the best
+	// chain.
+	GetBlockHash(ctx context.Context, height int64) (string, error)
+}
+
+// knownNets are the networks DetectNet recognises.
+var knownNets = []*chaincfg.Params{
+	&chaincfg.MainNetParams,
+	&chaincfg.TestNet3Params,
+	&chaincfg.RegressionNetParams,
+	&chaincfg.SimNetParams,
+}
+
+// DetectNet returns the network the backend is on, identified by its genesis
+// block. All the backends wrapped by a Failover or Verified backend must be
+// on the same network.
+func DetectNet(ctx context.Context, b Backend) (*chaincfg.Params, error) {
+	var net *chaincfg.Params
+	for _, b := range leaves(b) {
+		bi, ok := b.(BlockIndex)
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		hash, err := bi.GetBlockHash(ctx, 0)
+		if err != nil {
+			return nil, err
+		}
+		n := netForGenesis(hash)
+		if n == nil {
+			return nil, fmt.Errorf("chain backend has unknown genesis block %s", hash)
+		}
+		if net != nil && n != net {
+			return nil, fmt.Errorf("chain backends are on different networks: %s and %s",
+				net.Name, n.Name)
+		}
+		net = n
+	}
+	return net, nil
+}
+
+// CheckNet returns an error unless the backend is on net, so that a
+// misconfigured receiver can't open channels against the wrong network.
+func CheckNet(ctx context.Context, b Backend, net *chaincfg.Params) error {
+	n, err := DetectNet(ctx, b)
+	if err != nil {
+		return err
+	}
+	if n.Name != net.Name {
+		return fmt.Errorf("chain backend is on %s, not %s", n.Name, net.Name)
+	}
+	return nil
+}
+
+func netForGenesis(hash string) *chaincfg.Params {
+	for _, n := range knownNets {
+		if n.GenesisHash.String() == hash {
+			return n
+		}
+	}
+	return nil
+}
+
+// leaves returns the backends wrapped by b, or b itself.
+func leaves(b Backend) []Backend {
+	switch b := b.(type) {
+	case *Failover:
+		var res []Backend
+		for _, br := range b.backends {
+			res = append(res, leaves(br.b)...)
+		}
+		return res
+	case Verified:
+		return append(leaves(b.Backend), leaves(b.Secondary)...)
+	default:
+		return []Backend{b}
+	}
+}
@@ -0,0 +1,65 @@
+package chain
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+type genesisBackend struct {
+	Backend
+	hash string
+}
+
+func (g genesisBackend) GetBlockHash(ctx context.Context, height int64) (string, error) {
+	return g.hash, nil
+}
+
+func TestDetectNet(t *testing.T) {
+	ctx := context.Background()
+	main := genesisBackend{hash: chaincfg.MainNetParams.GenesisHash.String()}
+	test := genesisBackend{hash: chaincfg.TestNet3Params.GenesisHash.String()}
+
+	net, err := DetectNet(ctx, test)
+	if err != nil || net != &chaincfg.TestNet3Params {
+		t.Errorf("Expected testnet3, got %v %v", net, err)
+	}
+	if err := CheckNet(ctx, test, &chaincfg.TestNet3Params); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := CheckNet(ctx, test, &chaincfg.MainNetParams); err == nil {
+		t.Errorf("Expected mismatch to be refused")
+	}
+
+	if _, err := DetectNet(ctx, NewFailover(main, test)); err == nil ||
+		!strings.Contains(err.Error(), "different networks") {
+		t.Errorf("Expected failover backends on different networks to be refused, got %v", err)
+	}
+	if _, err := DetectNet(ctx, Verified{Backend: main, Secondary: test}); err == nil {
+		t.Errorf("Expected verified backends on different networks to be refused")
+	}
+	if _, err := DetectNet(ctx, genesisBackend{hash: "unknown"}); err == nil {
+		t.Errorf("Expected unknown genesis block to be refused")
+	}
+	if _, err := DetectNet(ctx, &heightBackend{}); err != ErrUnsupported {
+		t.Errorf("Expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestEsploraDetectNet(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/block-height/0", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(chaincfg.MainNetParams.GenesisHash.String()))
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	net, err := DetectNet(context.Background(), NewEsplora(s.URL))
+	if err != nil || net != &chaincfg.MainNetParams {
+		t.Errorf("Expected mainnet, got %v %v", net, err)
+	}
+}
@@ -0,0 +1,917 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil/gcs"
+	"github.com/btcsuite/btcutil/gcs/builder"
+)
+
+// ErrNotSynced is returned by Neutrino until it has synced to the tip of
+// the chain, and while it rescans blocks for newly watched scripts.
+var ErrNotSynced = errors.New("neutrino: not synced")
+
+const (
+	// DefaultSyncInterval is how often Neutrino polls its peers for new
+	// headers.
+	DefaultSyncInterval = 30 * time.Second
+
+	// DefaultMempoolExpiry is how long Neutrino reports a transaction it
+	// broadcast as unconfirmed.
+	DefaultMempoolExpiry = time.Hour
+
+	// maxHeaders, maxCFHeaders and maxCFilters are the most headers, filter
+	// headers and filters a peer returns for one request.
+	maxHeaders   = 2000
+	maxCFHeaders = 2000
+	maxCFilters  = 1000
+)
+
+// blockHeader is what Neutrino keeps of each block header.
+type blockHeader struct {
+	hash      chainhash.Hash
+	bits      uint32
+	timestamp int64
+
+	// filter is the block's filter header, once it's been synced.
+	filter chainhash.Hash
+}
+
+// watchedOutput is an output paying to a watched script.
+type watchedOutput struct {
+	TxOut
+	height int64
+
+	spent   bool
+	spender chainhash.Hash
+	spentAt int64
+}
+
+// broadcastTx is a transaction Neutrino broadcast that hasn't been seen in
+// a block.
+type broadcastTx struct {
+	tx   *wire.MsgTx
+	sent time.Time
+}
+
+// filterPeer is a peer serving headers, compact block filters and blocks.
+type filterPeer interface {
+	getHeaders(ctx context.Context, locator []*chainhash.Hash) ([]*wire.BlockHeader, error)
+	getCFHeaders(ctx context.Context, start int64, stop chainhash.Hash) (*wire.MsgCFHeaders, error)
+	getCFilters(ctx context.Context, start int64, stop chainhash.Hash) ([]*wire.MsgCFilter, error)
+	getBlock(ctx context.Context, hash chainhash.Hash) (*wire.MsgBlock, error)
+	sendTx(tx *wire.MsgTx)
+	connected() bool
+	close()
+}
+
+// Neutrino is a Backend that follows the chain as a light client of the
+// bitcoin network, using the compact block filters of BIP 157 and 158
+// served by its peers. It checks the proof of work of every header and
+// every filter against the filter header chain, so that confirmations can
+// be verified without trusting a server, at the cost of downloading the
+// filters. If several peers are given, their filter headers must agree.
+//
+// Filters only commit to output scripts, so Neutrino only sees outputs
+// paying to scripts passed to WatchScript and the transactions spending
+// them. It can't see the mempool either: outputs are only returned once
+// they're confirmed, and transactions it broadcast are reported as
+// unconfirmed until MempoolExpiry has passed.
+//
+// Headers are kept in memory from the genesis block and synced again on
+// startup, which takes a few minutes on mainnet.
+type Neutrino struct {
+	params *chaincfg.Params
+	addrs  []string
+	dial   func(ctx context.Context, addr string) (filterPeer, error)
+
+	// SyncInterval is how often peers are polled for new headers. Blocks
+	// announced by peers are synced immediately.
+	SyncInterval time.Duration
+
+	// PollInterval is how often SubscribeBlocks polls for new blocks.
+	PollInterval time.Duration
+
+	// MempoolExpiry is how long a broadcast transaction is reported as
+	// unconfirmed. GetTxStatus then returns nil, so that it's broadcast
+	// again if it was dropped.
+	MempoolExpiry time.Duration
+
+	kick   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// peers, headers and filterTip are only changed by the sync goroutine,
+	// with mu held.
+	peers     map[string]filterPeer
+	headers   []blockHeader
+	filterTip int64
+
+	mu        sync.Mutex
+	scanned   int64
+	rewind    int64
+	ready     bool
+	scripts   map[string]int64
+	outputs   map[wire.OutPoint]*watchedOutput
+	txs       map[chainhash.Hash]int64
+	broadcast map[chainhash.Hash]broadcastTx
+}
+
+// NewNeutrino returns a backend that syncs from the peers at addrs, given
+// as host or host:port. It starts syncing in the background until
+// Shutdown is called.
+func NewNeutrino(params *chaincfg.Params, addrs []string) *Neutrino {
+	n := newNeutrino(params, addrs)
+	n.dial = func(ctx context.Context, addr string) (filterPeer, error) {
+		return dialPeer(ctx, addr, params, n.kickSync)
+	}
+	n.start()
+	return n
+}
+
+func newNeutrino(params *chaincfg.Params, addrs []string) *Neutrino {
+	return &Neutrino{
+		params:        params,
+		addrs:         addrs,
+		SyncInterval:  DefaultSyncInterval,
+		PollInterval:  DefaultPollInterval,
+		MempoolExpiry: DefaultMempoolExpiry,
+		kick:          make(chan struct{}, 1),
+		peers:         make(map[string]filterPeer),
+		headers: []blockHeader{{
+			hash:      *params.GenesisHash,
+			bits:      params.GenesisBlock.Header.Bits,
+			timestamp: params.GenesisBlock.Header.Timestamp.Unix(),
+		}},
+		filterTip: -1,
+		scanned:   -1,
+		rewind:    math.MaxInt64,
+		scripts:   make(map[string]int64),
+		outputs:   make(map[wire.OutPoint]*watchedOutput),
+		txs:       make(map[chainhash.Hash]int64),
+		broadcast: make(map[chainhash.Hash]broadcastTx),
+	}
+}
+
+func (n *Neutrino) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	n.cancel = cancel
+	n.done = make(chan struct{})
+	go func() {
+		defer close(n.done)
+		n.run(ctx)
+	}()
+}
+
+// Shutdown stops syncing and disconnects from the peers.
+func (n *Neutrino) Shutdown() {
+	if n.cancel == nil {
+		return
+	}
+	n.cancel()
+	<-n.done
+	for _, p := range n.peers {
+		p.close()
+	}
+}
+
+func (n *Neutrino) kickSync() {
+	select {
+	case n.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Neutrino) run(ctx context.Context) {
+	t := time.NewTicker(n.SyncInterval)
+	defer t.Stop()
+	for {
+		if err := n.sync(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("neutrino sync failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-n.kick:
+		}
+	}
+}
+
+// sync connects to the peers and syncs headers, filter headers and the
+// watched scripts' outputs up to the tip.
+func (n *Neutrino) sync(ctx context.Context) error {
+	n.connect(ctx)
+	if len(n.peers) == 0 {
+		return errors.New("no peers connected")
+	}
+	if err := n.syncHeaders(ctx); err != nil {
+		return err
+	}
+	if err := n.syncFilterHeaders(ctx); err != nil {
+		return err
+	}
+	return n.scan(ctx)
+}
+
+func (n *Neutrino) connect(ctx context.Context) {
+	for _, addr := range n.addrs {
+		if p, ok := n.peers[addr]; ok && p.connected() {
+			continue
+		}
+		p, err := n.dial(ctx, addr)
+		if err != nil {
+			slog.Warn("neutrino connect failed", "peer", addr, "err", err)
+			continue
+		}
+		n.mu.Lock()
+		n.peers[addr] = p
+		n.mu.Unlock()
+	}
+}
+
+// dropPeer disconnects a peer that failed a request or served invalid
+// data. It's connected again on the next sync.
+func (n *Neutrino) dropPeer(addr string, err error) {
+	slog.Warn("neutrino peer dropped", "peer", addr, "err", err)
+	n.peers[addr].close()
+	n.mu.Lock()
+	delete(n.peers, addr)
+	n.mu.Unlock()
+}
+
+func (n *Neutrino) tip() int64 {
+	return int64(len(n.headers) - 1)
+}
+
+// locator returns the hashes of blocks from the tip back to the genesis
+// block, dense at first and then exponentially sparser.
+func (n *Neutrino) locator() []*chainhash.Hash {
+	var hashes []*chainhash.Hash
+	step := int64(1)
+	for h := n.tip(); h > 0; h -= step {
+		hashes = append(hashes, &n.headers[h].hash)
+		if len(hashes) >= 10 {
+			step *= 2
+		}
+	}
+	return append(hashes, &n.headers[0].hash)
+}
+
+func (n *Neutrino) syncHeaders(ctx context.Context) error {
+	for addr, p := range n.peers {
+		for {
+			headers, err := p.getHeaders(ctx, n.locator())
+			if err != nil {
+				n.dropPeer(addr, err)
+				break
+			}
+			added, err := n.addHeaders(headers)
+			if err != nil {
+				n.dropPeer(addr, err)
+				break
+			}
+			if !added || len(headers) < maxHeaders {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// addHeaders connects headers to the chain. If they fork from it, they
+// replace the blocks after the fork only if they have more work. It
+// returns whether the chain changed.
+func (n *Neutrino) addHeaders(headers []*wire.BlockHeader) (bool, error) {
+	if len(headers) == 0 {
+		return false, nil
+	}
+	fork := n.find(headers[0].PrevBlock)
+	if fork < 0 {
+		return false, errors.New("headers don't connect to the chain")
+	}
+
+	// Skip the headers we already have.
+	for len(headers) > 0 && fork < n.tip() && headers[0].BlockHash() == n.headers[fork+1].hash {
+		headers = headers[1:]
+		fork++
+	}
+	if len(headers) == 0 {
+		return false, nil
+	}
+
+	chain := n.headers[: fork+1 : fork+1]
+	for i, h := range headers {
+		if err := n.checkHeader(chain, h); err != nil {
+			return false, fmt.Errorf("header %d: %v", fork+int64(i)+1, err)
+		}
+		chain = append(chain, blockHeader{
+			hash:      h.BlockHash(),
+			bits:      h.Bits,
+			timestamp: h.Timestamp.Unix(),
+		})
+	}
+
+	if fork < n.tip() {
+		if work(chain[fork+1:]).Cmp(work(n.headers[fork+1:])) <= 0 {
+			return false, nil
+		}
+		slog.Warn("neutrino reorg", "fork", fork, "old_tip", n.tip(), "new_tip", len(chain)-1)
+		n.rollback(fork)
+	}
+
+	n.mu.Lock()
+	n.headers = chain
+	if n.filterTip > fork {
+		n.filterTip = fork
+	}
+	n.mu.Unlock()
+	return true, nil
+}
+
+// find returns the height of the block with the given hash, or -1 if it's
+// not in the chain. Blocks near the tip are found fastest.
+func (n *Neutrino) find(hash chainhash.Hash) int64 {
+	for h := n.tip(); h >= 0; h-- {
+		if n.headers[h].hash == hash {
+			return h
+		}
+	}
+	return -1
+}
+
+func work(headers []blockHeader) *big.Int {
+	w := new(big.Int)
+	for _, h := range headers {
+		w.Add(w, blockchain.CalcWork(h.bits))
+	}
+	return w
+}
+
+// checkHeader checks that h extends chain with valid proof of work. On
+// networks without minimum difficulty blocks, difficulty changes are
+// checked too.
+func (n *Neutrino) checkHeader(chain []blockHeader, h *wire.BlockHeader) error {
+	prev := chain[len(chain)-1]
+	if h.PrevBlock != prev.hash {
+		return errors.New("doesn't extend the chain")
+	}
+
+	target := blockchain.CompactToBig(h.Bits)
+	if target.Sign() <= 0 || target.Cmp(n.params.PowLimit) > 0 {
+		return fmt.Errorf("target %064x out of range", target)
+	}
+	hash := h.BlockHash()
+	if blockchain.HashToBig(&hash).Cmp(target) > 0 {
+		return errors.New("insufficient proof of work")
+	}
+
+	if n.params.ReduceMinDifficulty {
+		return nil
+	}
+	interval := int64(n.params.TargetTimespan / n.params.TargetTimePerBlock)
+	height := int64(len(chain))
+	want := prev.bits
+	if height%interval == 0 {
+		want = n.nextBits(chain[height-interval], prev)
+	}
+	if h.Bits != want {
+		return fmt.Errorf("difficulty bits %08x, expected %08x", h.Bits, want)
+	}
+	return nil
+}
+
+// nextBits returns the difficulty after a retarget period that started
+// with first and ended with last.
+func (n *Neutrino) nextBits(first, last blockHeader) uint32 {
+	timespan := int64(n.params.TargetTimespan / time.Second)
+	adjustment := n.params.RetargetAdjustmentFactor
+	actual := last.timestamp - first.timestamp
+	if actual < timespan/adjustment {
+		actual = timespan / adjustment
+	} else if actual > timespan*adjustment {
+		actual = timespan * adjustment
+	}
+
+	target := blockchain.CompactToBig(last.bits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(timespan))
+	if target.Cmp(n.params.PowLimit) > 0 {
+		target.Set(n.params.PowLimit)
+	}
+	return blockchain.BigToCompact(target)
+}
+
+// rollback forgets what was seen in blocks after fork.
+func (n *Neutrino) rollback(fork int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for op, o := range n.outputs {
+		if o.height > fork {
+			delete(n.outputs, op)
+		} else if o.spent && o.spentAt > fork {
+			o.spent = false
+		}
+	}
+	for txid, h := range n.txs {
+		if h > fork {
+			delete(n.txs, txid)
+		}
+	}
+	if n.scanned > fork {
+		n.scanned = fork
+		n.ready = false
+	}
+}
+
+func (n *Neutrino) syncFilterHeaders(ctx context.Context) error {
+	for n.filterTip < n.tip() {
+		start := n.filterTip + 1
+		stop := start + maxCFHeaders - 1
+		if stop > n.tip() {
+			stop = n.tip()
+		}
+		prev := chainhash.Hash{}
+		if start > 0 {
+			prev = n.headers[start-1].filter
+		}
+
+		var filters []chainhash.Hash
+		var from string
+		for addr, p := range n.peers {
+			msg, err := p.getCFHeaders(ctx, start, n.headers[stop].hash)
+			if err != nil {
+				n.dropPeer(addr, err)
+				continue
+			}
+			headers, err := filterHeaders(msg, prev, int(stop-start+1))
+			if err != nil {
+				n.dropPeer(addr, err)
+				continue
+			}
+			if filters == nil {
+				filters, from = headers, addr
+				continue
+			}
+			for i := range filters {
+				if filters[i] != headers[i] {
+					return fmt.Errorf("peers %s and %s disagree on the filter header at height %d",
+						from, addr, start+int64(i))
+				}
+			}
+		}
+		if filters == nil {
+			return errors.New("no peer served filter headers")
+		}
+
+		n.mu.Lock()
+		for i, f := range filters {
+			n.headers[start+int64(i)].filter = f
+		}
+		n.filterTip = stop
+		n.mu.Unlock()
+	}
+	return nil
+}
+
+// filterHeaders returns the filter headers committed to by msg, which must
+// follow prev and cover count blocks.
+func filterHeaders(msg *wire.MsgCFHeaders, prev chainhash.Hash, count int) ([]chainhash.Hash, error) {
+	if msg.FilterType != wire.GCSFilterRegular {
+		return nil, errors.New("wrong filter type")
+	}
+	if msg.PrevFilterHeader != prev {
+		return nil, errors.New("filter headers don't connect")
+	}
+	if len(msg.FilterHashes) != count {
+		return nil, fmt.Errorf("got %d filter headers, expected %d", len(msg.FilterHashes), count)
+	}
+	headers := make([]chainhash.Hash, count)
+	for i, fh := range msg.FilterHashes {
+		prev = filterHeader(*fh, prev)
+		headers[i] = prev
+	}
+	return headers, nil
+}
+
+func filterHeader(filterHash, prev chainhash.Hash) chainhash.Hash {
+	return chainhash.DoubleHashH(append(filterHash[:], prev[:]...))
+}
+
+// scan matches the filters of the blocks after the last scanned one
+// against the watched scripts, and looks for outputs paying to them and
+// their spends in the blocks that match.
+func (n *Neutrino) scan(ctx context.Context) error {
+	for {
+		n.mu.Lock()
+		if n.rewind <= n.scanned {
+			n.scanned = n.rewind - 1
+		}
+		n.rewind = math.MaxInt64
+		start := n.scanned + 1
+		if start > n.filterTip {
+			n.ready = n.scanned >= 0
+			n.mu.Unlock()
+			return nil
+		}
+		// Skip the filters of blocks before any watched script's.
+		first := n.filterTip + 1
+		for _, from := range n.scripts {
+			if from < first {
+				first = from
+			}
+		}
+		if start < first {
+			n.scanned = first - 1
+			n.mu.Unlock()
+			continue
+		}
+		n.mu.Unlock()
+
+		stop := start + maxCFilters - 1
+		if stop > n.filterTip {
+			stop = n.filterTip
+		}
+		filters, err := n.getCFilters(ctx, start, stop)
+		if err != nil {
+			return err
+		}
+		for i, f := range filters {
+			ok, err := n.scanBlock(ctx, start+int64(i), f)
+			if err != nil {
+				return err
+			} else if !ok {
+				// A script was watched from an earlier block.
+				break
+			}
+		}
+	}
+}
+
+// getCFilters fetches the filters of the blocks from start to stop from
+// any peer, checking them against the filter headers.
+func (n *Neutrino) getCFilters(ctx context.Context, start, stop int64) ([]*gcs.Filter, error) {
+	for addr, p := range n.peers {
+		msgs, err := p.getCFilters(ctx, start, n.headers[stop].hash)
+		if err != nil {
+			n.dropPeer(addr, err)
+			continue
+		}
+		filters, err := n.checkFilters(msgs, start, stop)
+		if err != nil {
+			n.dropPeer(addr, err)
+			continue
+		}
+		return filters, nil
+	}
+	return nil, errors.New("no peer served filters")
+}
+
+func (n *Neutrino) checkFilters(msgs []*wire.MsgCFilter, start, stop int64) ([]*gcs.Filter, error) {
+	if int64(len(msgs)) != stop-start+1 {
+		return nil, fmt.Errorf("got %d filters, expected %d", len(msgs), stop-start+1)
+	}
+	filters := make([]*gcs.Filter, len(msgs))
+	for i, msg := range msgs {
+		h := start + int64(i)
+		if msg.BlockHash != n.headers[h].hash {
+			return nil, fmt.Errorf("filter for wrong block at height %d", h)
+		}
+		prev := chainhash.Hash{}
+		if h > 0 {
+			prev = n.headers[h-1].filter
+		}
+		if filterHeader(chainhash.DoubleHashH(msg.Data), prev) != n.headers[h].filter {
+			return nil, fmt.Errorf("filter at height %d doesn't match its header", h)
+		}
+		f, err := gcs.FromNBytes(builder.DefaultP, builder.DefaultM, msg.Data)
+		if err != nil {
+			return nil, err
+		}
+		filters[i] = f
+	}
+	return filters, nil
+}
+
+// scanBlock matches the filter of the block at height h against the
+// watched scripts, fetching and processing the block if it matches. It
+// returns false without marking the block scanned if a script was watched
+// from it or an earlier block in the meantime.
+func (n *Neutrino) scanBlock(ctx context.Context, h int64, f *gcs.Filter) (bool, error) {
+	n.mu.Lock()
+	var scripts [][]byte
+	for s, from := range n.scripts {
+		if from <= h {
+			scripts = append(scripts, []byte(s))
+		}
+	}
+	n.mu.Unlock()
+
+	var block *wire.MsgBlock
+	if len(scripts) > 0 && f.N() > 0 {
+		hash := n.headers[h].hash
+		match, err := f.MatchAny(builder.DeriveKey(&hash), scripts)
+		if err != nil {
+			return false, err
+		}
+		if match {
+			block, err = n.getBlock(ctx, hash)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.rewind <= h {
+		n.scanned = n.rewind - 1
+		n.rewind = math.MaxInt64
+		return false, nil
+	}
+	if block != nil {
+		n.processBlock(h, block)
+	}
+	n.scanned = h
+	return true, nil
+}
+
+// getBlock fetches a block from any peer, checking it against its header.
+func (n *Neutrino) getBlock(ctx context.Context, hash chainhash.Hash) (*wire.MsgBlock, error) {
+	for addr, p := range n.peers {
+		block, err := p.getBlock(ctx, hash)
+		if err != nil {
+			n.dropPeer(addr, err)
+			continue
+		}
+		if block.BlockHash() != hash {
+			n.dropPeer(addr, errors.New("got the wrong block"))
+			continue
+		}
+		if merkleRoot(block.Transactions) != block.Header.MerkleRoot {
+			n.dropPeer(addr, errors.New("block doesn't match its merkle root"))
+			continue
+		}
+		return block, nil
+	}
+	return nil, errors.New("no peer served block " + hash.String())
+}
+
+func merkleRoot(txs []*wire.MsgTx) chainhash.Hash {
+	level := make([]chainhash.Hash, len(txs))
+	for i, tx := range txs {
+		level[i] = tx.TxHash()
+	}
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([]chainhash.Hash, len(level)/2)
+		for i := range next {
+			next[i] = chainhash.DoubleHashH(append(level[2*i][:], level[2*i+1][:]...))
+		}
+		level = next
+	}
+	if len(level) == 0 {
+		return chainhash.Hash{}
+	}
+	return level[0]
+}
+
+// processBlock records the outputs paying to watched scripts in the block
+// at height h and the transactions spending them. It must be called with
+// mu held.
+func (n *Neutrino) processBlock(h int64, block *wire.MsgBlock) {
+	for _, tx := range block.Transactions {
+		txid := tx.TxHash()
+		var relevant bool
+		for _, in := range tx.TxIn {
+			if o, ok := n.outputs[in.PreviousOutPoint]; ok {
+				o.spent, o.spender, o.spentAt = true, txid, h
+				relevant = true
+			}
+		}
+		coinbase := blockchain.IsCoinBaseTx(tx)
+		for i, out := range tx.TxOut {
+			from, ok := n.scripts[string(out.PkScript)]
+			if !ok || from > h {
+				continue
+			}
+			n.outputs[wire.OutPoint{Hash: txid, Index: uint32(i)}] = &watchedOutput{
+				TxOut: TxOut{
+					Value:    out.Value,
+					PkScript: out.PkScript,
+					Coinbase: coinbase,
+				},
+				height: h,
+			}
+			relevant = true
+		}
+		if relevant {
+			n.txs[txid] = h
+		}
+		delete(n.broadcast, txid)
+	}
+}
+
+// WatchScript makes outputs paying to pkScript in blocks from height on,
+// and the transactions spending them, visible to the backend. A height of
+// zero watches blocks after the current tip. Blocks that were already
+// scanned are scanned again, during which calls fail with ErrNotSynced.
+// Scripts that are already watched aren't watched from an earlier height.
+func (n *Neutrino) WatchScript(pkScript []byte, height int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.scripts[string(pkScript)]; ok {
+		return
+	}
+	if height <= 0 {
+		height = n.tip() + 1
+	}
+	n.scripts[string(pkScript)] = height
+	if height <= n.scanned+1 && height < n.rewind {
+		// Blocks being or already scanned have to be scanned again.
+		n.rewind = height
+	}
+	if height <= n.scanned {
+		n.ready = false
+		n.kickSync()
+	}
+}
+
+// synced returns the height and hash of the last scanned block, or
+// ErrNotSynced. It must be called with mu held.
+func (n *Neutrino) synced() (int64, chainhash.Hash, error) {
+	if !n.ready {
+		return 0, chainhash.Hash{}, ErrNotSynced
+	}
+	return n.scanned, n.headers[n.scanned].hash, nil
+}
+
+// GetTxOut returns an output paying to a watched script if it's confirmed
+// and unspent. Outputs spent by transactions broadcast by the backend
+// aren't returned if includeMempool is set.
+func (n *Neutrino) GetTxOut(ctx context.Context, txid string, vout uint32, includeMempool bool) (*TxOut, error) {
+	hash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return nil, err
+	}
+	op := wire.OutPoint{Hash: *hash, Index: vout}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	tip, tipHash, err := n.synced()
+	if err != nil {
+		return nil, err
+	}
+	o, ok := n.outputs[op]
+	if !ok || o.spent {
+		return nil, nil
+	}
+	if includeMempool && n.spentByBroadcast(op) {
+		return nil, nil
+	}
+	out := o.TxOut
+	out.PkScript = append([]byte(nil), o.PkScript...)
+	out.Confirmations = int(tip - o.height + 1)
+	out.BestBlock = tipHash.String()
+	return &out, nil
+}
+
+func (n *Neutrino) spentByBroadcast(op wire.OutPoint) bool {
+	for _, b := range n.broadcast {
+		if time.Since(b.sent) > n.MempoolExpiry {
+			continue
+		}
+		for _, in := range b.tx.TxIn {
+			if in.PreviousOutPoint == op {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetHeight returns the height of a block in the chain, or of the last
+// scanned block if hash is empty.
+func (n *Neutrino) GetHeight(ctx context.Context, hash string) (int64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if hash == "" {
+		tip, _, err := n.synced()
+		return tip, err
+	}
+	bh, err := chainhash.NewHashFromStr(hash)
+	if err != nil {
+		return 0, err
+	}
+	h := n.find(*bh)
+	if h < 0 {
+		return 0, errors.New("block not found")
+	}
+	return h, nil
+}
+
+// GetBlockHash returns the hash of the block at height in the synced
+// headers.
+func (n *Neutrino) GetBlockHash(ctx context.Context, height int64) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if height < 0 || height > n.tip() {
+		return "", errors.New("block not found")
+	}
+	return n.headers[height].hash.String(), nil
+}
+
+// GetTxStatus returns the status of a transaction that pays to or spends
+// an output of a watched script, or that the backend broadcast.
+func (n *Neutrino) GetTxStatus(ctx context.Context, txid string) (*TxStatus, error) {
+	hash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	tip, _, err := n.synced()
+	if err != nil {
+		return nil, err
+	}
+	if h, ok := n.txs[*hash]; ok {
+		return &TxStatus{
+			Confirmations: int(tip - h + 1),
+			BlockHash:     n.headers[h].hash.String(),
+			BlockHeight:   h,
+		}, nil
+	}
+	if b, ok := n.broadcast[*hash]; ok && time.Since(b.sent) <= n.MempoolExpiry {
+		return &TxStatus{}, nil
+	}
+	return nil, nil
+}
+
+// GetSpender returns the transaction spending an output of a watched
+// script, if it's confirmed.
+func (n *Neutrino) GetSpender(ctx context.Context, txid string, vout uint32) (string, error) {
+	hash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return "", err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, _, err := n.synced(); err != nil {
+		return "", err
+	}
+	o, ok := n.outputs[wire.OutPoint{Hash: *hash, Index: vout}]
+	if !ok || !o.spent {
+		return "", nil
+	}
+	return o.spender.String(), nil
+}
+
+// Broadcast sends the transaction to every connected peer. Peers don't
+// report whether they accepted it.
+func (n *Neutrino) Broadcast(ctx context.Context, tx *wire.MsgTx) (string, error) {
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return "", err
+	}
+	txid := tx.TxHash()
+
+	n.mu.Lock()
+	n.broadcast[txid] = broadcastTx{tx: tx, sent: time.Now()}
+	peers := make([]filterPeer, 0, len(n.peers))
+	for _, p := range n.peers {
+		peers = append(peers, p)
+	}
+	n.mu.Unlock()
+
+	if len(peers) == 0 {
+		return "", errors.New("no peers connected")
+	}
+	for _, p := range peers {
+		p.sendTx(tx)
+	}
+	return txid.String(), nil
+}
+
+func (n *Neutrino) SubscribeBlocks(ctx context.Context) (<-chan int64, error) {
+	return pollBlocks(ctx, n.PollInterval, func() (int64, error) {
+		return n.GetHeight(ctx, "")
+	}), nil
+}
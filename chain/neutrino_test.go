@@ -0,0 +1,585 @@
+package chain
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil/gcs/builder"
+)
+
+var (
+	watchedScript = []byte{0x00, 0x14, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	otherScript   = []byte{0x00, 0x14, 20, 19, 18, 17, 16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+)
+
+// testChain is a regtest chain with compact block filters.
+type testChain struct {
+	blocks  []*wire.MsgBlock
+	filters [][]byte
+	scripts map[wire.OutPoint][]byte
+}
+
+func newTestChain(t *testing.T) *testChain {
+	c := &testChain{scripts: make(map[wire.OutPoint][]byte)}
+	c.add(t, chaincfg.RegressionNetParams.GenesisBlock)
+	return c
+}
+
+func (c *testChain) add(t *testing.T, block *wire.MsgBlock) {
+	var prevScripts [][]byte
+	for _, tx := range block.Transactions {
+		if !blockchain.IsCoinBaseTx(tx) {
+			for _, in := range tx.TxIn {
+				prevScripts = append(prevScripts, c.scripts[in.PreviousOutPoint])
+			}
+		}
+		for i, out := range tx.TxOut {
+			c.scripts[wire.OutPoint{Hash: tx.TxHash(), Index: uint32(i)}] = out.PkScript
+		}
+	}
+	f, err := builder.BuildBasicFilter(block, prevScripts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := f.NBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.blocks = append(c.blocks, block)
+	c.filters = append(c.filters, data)
+}
+
+// mine adds a block with txs, whose coinbase pays to otherScript.
+func (c *testChain) mine(t *testing.T, txs ...*wire.MsgTx) *wire.MsgBlock {
+	height := len(c.blocks)
+	coinbase := wire.NewMsgTx(1)
+	sig := make([]byte, 8)
+	binary.LittleEndian.PutUint64(sig, uint64(height))
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), sig, nil))
+	coinbase.AddTxOut(wire.NewTxOut(5000000000, otherScript))
+
+	prev := c.blocks[height-1]
+	block := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:   4,
+			PrevBlock: prev.BlockHash(),
+			Timestamp: prev.Header.Timestamp.Add(10 * time.Minute),
+			Bits:      chaincfg.RegressionNetParams.PowLimitBits,
+		},
+		Transactions: append([]*wire.MsgTx{coinbase}, txs...),
+	}
+	block.Header.MerkleRoot = merkleRoot(block.Transactions)
+	target := blockchain.CompactToBig(block.Header.Bits)
+	for {
+		hash := block.BlockHash()
+		if blockchain.HashToBig(&hash).Cmp(target) <= 0 {
+			break
+		}
+		block.Header.Nonce++
+	}
+	c.add(t, block)
+	return block
+}
+
+// fork returns a copy of the chain up to height.
+func (c *testChain) fork(t *testing.T, height int) *testChain {
+	f := newTestChain(t)
+	for _, b := range c.blocks[1 : height+1] {
+		f.add(t, b)
+	}
+	return f
+}
+
+func (c *testChain) height(hash chainhash.Hash) int {
+	for h, b := range c.blocks {
+		if b.BlockHash() == hash {
+			return h
+		}
+	}
+	return -1
+}
+
+func (c *testChain) filterHashes(start, stop int) (chainhash.Hash, []*chainhash.Hash) {
+	var prev chainhash.Hash
+	var hashes []*chainhash.Hash
+	for h := 0; h <= stop; h++ {
+		fh := chainhash.DoubleHashH(c.filters[h])
+		if h >= start {
+			hashes = append(hashes, &fh)
+		} else {
+			prev = filterHeader(fh, prev)
+		}
+	}
+	return prev, hashes
+}
+
+// fakePeer serves a testChain.
+type fakePeer struct {
+	chain *testChain
+	sent  []*wire.MsgTx
+
+	// lie, if set, makes the peer serve wrong filters.
+	lie bool
+}
+
+func (p *fakePeer) getHeaders(ctx context.Context, locator []*chainhash.Hash) ([]*wire.BlockHeader, error) {
+	for _, hash := range locator {
+		h := p.chain.height(*hash)
+		if h < 0 {
+			continue
+		}
+		var headers []*wire.BlockHeader
+		for _, b := range p.chain.blocks[h+1:] {
+			header := b.Header
+			headers = append(headers, &header)
+		}
+		return headers, nil
+	}
+	return nil, errors.New("unknown locator")
+}
+
+func (p *fakePeer) getCFHeaders(ctx context.Context, start int64, stop chainhash.Hash) (*wire.MsgCFHeaders, error) {
+	h := p.chain.height(stop)
+	if h < 0 {
+		return nil, errors.New("unknown stop hash")
+	}
+	prev, hashes := p.chain.filterHashes(int(start), h)
+	if p.lie {
+		hashes[len(hashes)-1] = &chainhash.Hash{1}
+	}
+	return &wire.MsgCFHeaders{
+		FilterType:       wire.GCSFilterRegular,
+		StopHash:         stop,
+		PrevFilterHeader: prev,
+		FilterHashes:     hashes,
+	}, nil
+}
+
+func (p *fakePeer) getCFilters(ctx context.Context, start int64, stop chainhash.Hash) ([]*wire.MsgCFilter, error) {
+	h := p.chain.height(stop)
+	if h < 0 {
+		return nil, errors.New("unknown stop hash")
+	}
+	var msgs []*wire.MsgCFilter
+	for i := int(start); i <= h; i++ {
+		data := p.chain.filters[i]
+		if p.lie {
+			data = p.chain.filters[0]
+		}
+		hash := p.chain.blocks[i].BlockHash()
+		msgs = append(msgs, wire.NewMsgCFilter(wire.GCSFilterRegular, &hash, data))
+	}
+	return msgs, nil
+}
+
+func (p *fakePeer) getBlock(ctx context.Context, hash chainhash.Hash) (*wire.MsgBlock, error) {
+	h := p.chain.height(hash)
+	if h < 0 {
+		return nil, errors.New("block not found")
+	}
+	return p.chain.blocks[h], nil
+}
+
+func (p *fakePeer) sendTx(tx *wire.MsgTx) {
+	p.sent = append(p.sent, tx)
+}
+
+func (p *fakePeer) connected() bool { return true }
+func (p *fakePeer) close()          {}
+
+func newTestNeutrino(peers map[string]filterPeer) *Neutrino {
+	var addrs []string
+	for addr := range peers {
+		addrs = append(addrs, addr)
+	}
+	n := newNeutrino(&chaincfg.RegressionNetParams, addrs)
+	n.dial = func(ctx context.Context, addr string) (filterPeer, error) {
+		return peers[addr], nil
+	}
+	return n
+}
+
+// payTo returns a transaction paying value to script.
+func payTo(prev wire.OutPoint, value int64, script []byte) *wire.MsgTx {
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&prev, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(value, script))
+	return tx
+}
+
+func mustSync(t *testing.T, n *Neutrino) {
+	if err := n.sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNeutrinoFunding(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t)
+	cb := c.mine(t)
+	n := newTestNeutrino(map[string]filterPeer{"peer": &fakePeer{chain: c}})
+
+	if _, err := n.GetTxOut(ctx, testTxID, 0, false); err != ErrNotSynced {
+		t.Errorf("Expected ErrNotSynced before syncing, got %v", err)
+	}
+	mustSync(t, n)
+	n.WatchScript(watchedScript, 0)
+
+	funding := payTo(wire.OutPoint{Hash: cb.Transactions[0].TxHash()}, 1000, watchedScript)
+	c.mine(t, funding)
+	c.mine(t)
+	c.mine(t)
+	mustSync(t, n)
+
+	txid := funding.TxHash().String()
+	out, err := n.GetTxOut(ctx, txid, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out == nil || out.Value != 1000 || out.Confirmations != 3 || out.Coinbase ||
+		out.BestBlock != c.blocks[4].BlockHash().String() {
+		t.Fatalf("Unexpected output %+v", out)
+	}
+	if h, err := n.GetHeight(ctx, out.BestBlock); err != nil || h != 4 {
+		t.Errorf("Expected height 4, got %d %v", h, err)
+	}
+	if h, err := n.GetHeight(ctx, ""); err != nil || h != 4 {
+		t.Errorf("Expected tip height 4, got %d %v", h, err)
+	}
+	if err := CheckNet(ctx, n, &chaincfg.RegressionNetParams); err != nil {
+		t.Errorf("Expected regtest, got %v", err)
+	}
+
+	st, err := n.GetTxStatus(ctx, txid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st == nil || st.Confirmations != 3 || st.BlockHeight != 2 ||
+		st.BlockHash != c.blocks[2].BlockHash().String() {
+		t.Errorf("Unexpected status %+v", st)
+	}
+
+	// The coinbase doesn't pay to a watched script.
+	out, err = n.GetTxOut(ctx, cb.Transactions[0].TxHash().String(), 0, false)
+	if err != nil || out != nil {
+		t.Errorf("Expected unwatched output to be missing, got %+v %v", out, err)
+	}
+}
+
+func TestNeutrinoSpend(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t)
+	cb := c.mine(t)
+	n := newTestNeutrino(map[string]filterPeer{"peer": &fakePeer{chain: c}})
+	mustSync(t, n)
+	n.WatchScript(watchedScript, 0)
+
+	funding := payTo(wire.OutPoint{Hash: cb.Transactions[0].TxHash()}, 1000, watchedScript)
+	c.mine(t, funding)
+	spend := payTo(wire.OutPoint{Hash: funding.TxHash()}, 900, otherScript)
+	c.mine(t, spend)
+	mustSync(t, n)
+
+	txid := funding.TxHash().String()
+	if out, err := n.GetTxOut(ctx, txid, 0, false); err != nil || out != nil {
+		t.Errorf("Expected spent output to be missing, got %+v %v", out, err)
+	}
+	spender, err := n.GetSpender(ctx, txid, 0)
+	if err != nil || spender != spend.TxHash().String() {
+		t.Errorf("Expected spender %s, got %q %v", spend.TxHash(), spender, err)
+	}
+	st, err := n.GetTxStatus(ctx, spender)
+	if err != nil || st == nil || st.Confirmations != 1 {
+		t.Errorf("Expected confirmed spender, got %+v %v", st, err)
+	}
+}
+
+func TestNeutrinoRescan(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t)
+	cb := c.mine(t)
+	funding := payTo(wire.OutPoint{Hash: cb.Transactions[0].TxHash()}, 1000, watchedScript)
+	c.mine(t, funding)
+	n := newTestNeutrino(map[string]filterPeer{"peer": &fakePeer{chain: c}})
+	mustSync(t, n)
+
+	txid := funding.TxHash().String()
+	if out, err := n.GetTxOut(ctx, txid, 0, false); err != nil || out != nil {
+		t.Errorf("Expected unwatched output to be missing, got %+v %v", out, err)
+	}
+
+	n.WatchScript(watchedScript, 1)
+	if _, err := n.GetTxOut(ctx, txid, 0, false); err != ErrNotSynced {
+		t.Errorf("Expected ErrNotSynced during rescan, got %v", err)
+	}
+	mustSync(t, n)
+	out, err := n.GetTxOut(ctx, txid, 0, false)
+	if err != nil || out == nil || out.Confirmations != 1 {
+		t.Errorf("Expected output after rescan, got %+v %v", out, err)
+	}
+}
+
+func TestNeutrinoReorg(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t)
+	cb := c.mine(t)
+	p := &fakePeer{chain: c}
+	n := newTestNeutrino(map[string]filterPeer{"peer": p})
+	mustSync(t, n)
+	n.WatchScript(watchedScript, 0)
+
+	funding := payTo(wire.OutPoint{Hash: cb.Transactions[0].TxHash()}, 1000, watchedScript)
+	c.mine(t, funding)
+	c.mine(t)
+	mustSync(t, n)
+	txid := funding.TxHash().String()
+
+	// A shorter fork without the funding is ignored.
+	short := c.fork(t, 1)
+	short.mine(t)
+	p.chain = short
+	mustSync(t, n)
+	if out, err := n.GetTxOut(ctx, txid, 0, false); err != nil || out == nil {
+		t.Errorf("Expected output to survive a shorter fork, got %+v %v", out, err)
+	}
+
+	// A longer fork that double spends the funding's input replaces it.
+	long := c.fork(t, 1)
+	long.mine(t, payTo(wire.OutPoint{Hash: cb.Transactions[0].TxHash()}, 1000, otherScript))
+	long.mine(t)
+	long.mine(t)
+	p.chain = long
+	mustSync(t, n)
+	if out, err := n.GetTxOut(ctx, txid, 0, false); err != nil || out != nil {
+		t.Errorf("Expected reorged output to be missing, got %+v %v", out, err)
+	}
+	if st, err := n.GetTxStatus(ctx, txid); err != nil || st != nil {
+		t.Errorf("Expected reorged tx to be missing, got %+v %v", st, err)
+	}
+	if h, err := n.GetHeight(ctx, ""); err != nil || h != 4 {
+		t.Errorf("Expected tip height 4, got %d %v", h, err)
+	}
+}
+
+func TestNeutrinoInvalidFilters(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t)
+	c.mine(t)
+
+	n := newTestNeutrino(map[string]filterPeer{"liar": &fakePeer{chain: c, lie: true}})
+	n.WatchScript(watchedScript, 1)
+	if err := n.sync(ctx); err == nil {
+		t.Errorf("Expected sync to fail with only a lying peer")
+	}
+
+	n = newTestNeutrino(map[string]filterPeer{
+		"honest": &fakePeer{chain: c},
+		"liar":   &fakePeer{chain: c, lie: true},
+	})
+	err := n.sync(ctx)
+	if err == nil || !strings.Contains(err.Error(), "disagree") {
+		t.Errorf("Expected peers to disagree, got %v", err)
+	}
+	if _, err := n.GetTxOut(ctx, testTxID, 0, false); err != ErrNotSynced {
+		t.Errorf("Expected ErrNotSynced, got %v", err)
+	}
+}
+
+func TestNeutrinoInvalidHeaders(t *testing.T) {
+	c := newTestChain(t)
+	block := c.mine(t)
+	n := newTestNeutrino(nil)
+
+	header := block.Header
+	header.Bits = 0x1d00ffff
+	if _, err := n.addHeaders([]*wire.BlockHeader{&header}); err == nil {
+		t.Errorf("Expected insufficient proof of work to be rejected")
+	}
+	header = block.Header
+	header.PrevBlock = chainhash.Hash{1}
+	if _, err := n.addHeaders([]*wire.BlockHeader{&header}); err == nil {
+		t.Errorf("Expected unconnected header to be rejected")
+	}
+	header = block.Header
+	if ok, err := n.addHeaders([]*wire.BlockHeader{&header}); err != nil || !ok {
+		t.Errorf("Expected valid header to be added, got %v %v", ok, err)
+	}
+}
+
+func TestNeutrinoNextBits(t *testing.T) {
+	n := newNeutrino(&chaincfg.MainNetParams, nil)
+	first := blockHeader{bits: 0x1d00ffff, timestamp: 0}
+
+	tests := []struct {
+		timespan int64
+		bits     uint32
+	}{
+		{14 * 24 * 60 * 60, 0x1d00ffff},
+		{7 * 24 * 60 * 60, 0x1c7fff80},
+		// Adjustments are limited to a factor of four.
+		{24 * 60 * 60, 0x1c3fffc0},
+		// Targets are limited to the proof of work limit.
+		{28 * 24 * 60 * 60, 0x1d00ffff},
+	}
+	for _, test := range tests {
+		last := blockHeader{bits: 0x1d00ffff, timestamp: test.timespan}
+		if got := n.nextBits(first, last); got != test.bits {
+			t.Errorf("%d: expected %08x, got %08x", test.timespan, test.bits, got)
+		}
+	}
+}
+
+func TestNeutrinoBroadcast(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t)
+	cb := c.mine(t)
+	p := &fakePeer{chain: c}
+	n := newTestNeutrino(map[string]filterPeer{"peer": p})
+	mustSync(t, n)
+	n.WatchScript(watchedScript, 0)
+
+	funding := payTo(wire.OutPoint{Hash: cb.Transactions[0].TxHash()}, 1000, watchedScript)
+	c.mine(t, funding)
+	mustSync(t, n)
+
+	spend := payTo(wire.OutPoint{Hash: funding.TxHash()}, 900, otherScript)
+	txid, err := n.Broadcast(ctx, spend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txid != spend.TxHash().String() || len(p.sent) != 1 {
+		t.Errorf("Expected tx to be sent to the peer, got %s %d", txid, len(p.sent))
+	}
+	if st, err := n.GetTxStatus(ctx, txid); err != nil || st == nil || st.Confirmations != 0 {
+		t.Errorf("Expected unconfirmed status, got %+v %v", st, err)
+	}
+	fid := funding.TxHash().String()
+	if out, err := n.GetTxOut(ctx, fid, 0, true); err != nil || out != nil {
+		t.Errorf("Expected output spent in the mempool to be missing, got %+v %v", out, err)
+	}
+	if out, err := n.GetTxOut(ctx, fid, 0, false); err != nil || out == nil {
+		t.Errorf("Expected confirmed output, got %+v %v", out, err)
+	}
+
+	n.MempoolExpiry = 0
+	if st, err := n.GetTxStatus(ctx, txid); err != nil || st != nil {
+		t.Errorf("Expected expired tx to be missing, got %+v %v", st, err)
+	}
+	if out, err := n.GetTxOut(ctx, fid, 0, true); err != nil || out == nil {
+		t.Errorf("Expected output once the broadcast expired, got %+v %v", out, err)
+	}
+
+	c.mine(t, spend)
+	mustSync(t, n)
+	if st, err := n.GetTxStatus(ctx, txid); err != nil || st == nil || st.Confirmations != 1 {
+		t.Errorf("Expected confirmed status, got %+v %v", st, err)
+	}
+}
+
+// serveTestChain serves c to a single connection over the bitcoin P2P
+// protocol.
+func serveTestChain(t *testing.T, c *testChain) (string, <-chan *wire.MsgTx) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	fp := &fakePeer{chain: c}
+	txs := make(chan *wire.MsgTx, 1)
+	cfg := &peer.Config{
+		ChainParams:    &chaincfg.RegressionNetParams,
+		AllowSelfConns: true,
+		Services:       wire.SFNodeNetwork | wire.SFNodeWitness | wire.SFNodeCF,
+		Listeners: peer.MessageListeners{
+			OnGetHeaders: func(p *peer.Peer, msg *wire.MsgGetHeaders) {
+				headers, _ := fp.getHeaders(context.Background(), msg.BlockLocatorHashes)
+				resp := wire.NewMsgHeaders()
+				for _, h := range headers {
+					resp.AddBlockHeader(h)
+				}
+				p.QueueMessage(resp, nil)
+			},
+			OnGetCFHeaders: func(p *peer.Peer, msg *wire.MsgGetCFHeaders) {
+				resp, _ := fp.getCFHeaders(context.Background(), int64(msg.StartHeight), msg.StopHash)
+				p.QueueMessage(resp, nil)
+			},
+			OnGetCFilters: func(p *peer.Peer, msg *wire.MsgGetCFilters) {
+				filters, _ := fp.getCFilters(context.Background(), int64(msg.StartHeight), msg.StopHash)
+				for _, f := range filters {
+					p.QueueMessage(f, nil)
+				}
+			},
+			OnGetData: func(p *peer.Peer, msg *wire.MsgGetData) {
+				for _, iv := range msg.InvList {
+					b, err := fp.getBlock(context.Background(), iv.Hash)
+					if err != nil {
+						nf := wire.NewMsgNotFound()
+						nf.AddInvVect(iv)
+						p.QueueMessage(nf, nil)
+						continue
+					}
+					p.QueueMessage(b, nil)
+				}
+			},
+			OnTx: func(p *peer.Peer, msg *wire.MsgTx) {
+				txs <- msg
+			},
+		},
+	}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		p := peer.NewInboundPeer(cfg)
+		p.AssociateConnection(conn)
+		t.Cleanup(p.Disconnect)
+	}()
+	return l.Addr().String(), txs
+}
+
+func TestNeutrinoP2P(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t)
+	cb := c.mine(t)
+	funding := payTo(wire.OutPoint{Hash: cb.Transactions[0].TxHash()}, 1000, watchedScript)
+	c.mine(t, funding)
+	addr, txs := serveTestChain(t, c)
+
+	n := newNeutrino(&chaincfg.RegressionNetParams, []string{addr})
+	n.dial = func(ctx context.Context, addr string) (filterPeer, error) {
+		return dialPeer(ctx, addr, &chaincfg.RegressionNetParams, nil)
+	}
+	defer n.Shutdown()
+	n.WatchScript(watchedScript, 1)
+	mustSync(t, n)
+
+	out, err := n.GetTxOut(ctx, funding.TxHash().String(), 0, false)
+	if err != nil || out == nil || out.Value != 1000 || out.Confirmations != 1 {
+		t.Fatalf("Expected output, got %+v %v", out, err)
+	}
+
+	spend := payTo(wire.OutPoint{Hash: funding.TxHash()}, 900, otherScript)
+	if _, err := n.Broadcast(ctx, spend); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case tx := <-txs:
+		if tx.TxHash() != spend.TxHash() {
+			t.Errorf("Peer got the wrong tx")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Peer didn't get the tx")
+	}
+}
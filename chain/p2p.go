@@ -0,0 +1,237 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// p2pTimeout limits the handshake with a peer and each of its responses.
+const p2pTimeout = 30 * time.Second
+
+// p2pPeer is a connection to a bitcoin node serving compact block filters.
+// Requests are made one at a time and a peer that times out is
+// disconnected, so that late responses can't be mistaken for those of the
+// next request.
+type p2pPeer struct {
+	p *peer.Peer
+
+	mu        sync.Mutex
+	verack    chan struct{}
+	headers   chan *wire.MsgHeaders
+	cfheaders chan *wire.MsgCFHeaders
+	cfilters  chan *wire.MsgCFilter
+	blocks    chan *wire.MsgBlock
+	notFound  chan struct{}
+}
+
+// dialPeer connects to the node at addr, using the network's default port
+// if addr doesn't have one. onBlock is called when the node announces a
+// block.
+func dialPeer(ctx context.Context, addr string, params *chaincfg.Params, onBlock func()) (*p2pPeer, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, params.DefaultPort)
+	}
+	d := net.Dialer{Timeout: p2pTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return newP2PPeer(ctx, conn, addr, params, onBlock)
+}
+
+func newP2PPeer(ctx context.Context, conn net.Conn, addr string, params *chaincfg.Params, onBlock func()) (*p2pPeer, error) {
+	pp := &p2pPeer{
+		verack:    make(chan struct{}),
+		headers:   make(chan *wire.MsgHeaders, 1),
+		cfheaders: make(chan *wire.MsgCFHeaders, 1),
+		cfilters:  make(chan *wire.MsgCFilter, maxCFilters),
+		blocks:    make(chan *wire.MsgBlock, 1),
+		notFound:  make(chan struct{}, 1),
+	}
+	cfg := &peer.Config{
+		UserAgentName:    "moonbeam",
+		UserAgentVersion: "1.0",
+		ChainParams:      params,
+		DisableRelayTx:   true,
+		// We never accept connections, so can't be connected to ourselves.
+		AllowSelfConns: true,
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				close(pp.verack)
+			},
+			// Unsolicited responses are dropped rather than blocking
+			// the peer's input handler.
+			OnHeaders: func(p *peer.Peer, msg *wire.MsgHeaders) {
+				select {
+				case pp.headers <- msg:
+				default:
+				}
+			},
+			OnCFHeaders: func(p *peer.Peer, msg *wire.MsgCFHeaders) {
+				select {
+				case pp.cfheaders <- msg:
+				default:
+				}
+			},
+			OnCFilter: func(p *peer.Peer, msg *wire.MsgCFilter) {
+				select {
+				case pp.cfilters <- msg:
+				default:
+				}
+			},
+			OnBlock: func(p *peer.Peer, msg *wire.MsgBlock, buf []byte) {
+				select {
+				case pp.blocks <- msg:
+				default:
+				}
+			},
+			OnNotFound: func(p *peer.Peer, msg *wire.MsgNotFound) {
+				select {
+				case pp.notFound <- struct{}{}:
+				default:
+				}
+			},
+			OnInv: func(p *peer.Peer, msg *wire.MsgInv) {
+				for _, iv := range msg.InvList {
+					if iv.Type == wire.InvTypeBlock && onBlock != nil {
+						onBlock()
+						return
+					}
+				}
+			},
+		},
+	}
+	p, err := peer.NewOutboundPeer(cfg, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	pp.p = p
+	p.AssociateConnection(conn)
+
+	disconnected := make(chan struct{})
+	go func() {
+		p.WaitForDisconnect()
+		close(disconnected)
+	}()
+	select {
+	case <-pp.verack:
+	case <-disconnected:
+		return nil, errors.New("peer disconnected during handshake")
+	case <-time.After(p2pTimeout):
+		p.Disconnect()
+		return nil, errors.New("handshake timed out")
+	case <-ctx.Done():
+		p.Disconnect()
+		return nil, ctx.Err()
+	}
+	if p.Services()&wire.SFNodeCF == 0 {
+		p.Disconnect()
+		return nil, errors.New("peer doesn't serve compact block filters")
+	}
+	return pp, nil
+}
+
+// fail disconnects the peer after a request failed, returning why.
+func (pp *p2pPeer) fail(ctx context.Context) error {
+	pp.p.Disconnect()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.New("request timed out")
+}
+
+func (pp *p2pPeer) getHeaders(ctx context.Context, locator []*chainhash.Hash) ([]*wire.BlockHeader, error) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	msg := wire.NewMsgGetHeaders()
+	for _, h := range locator {
+		if err := msg.AddBlockLocatorHash(h); err != nil {
+			return nil, err
+		}
+	}
+	pp.p.QueueMessage(msg, nil)
+	select {
+	case resp := <-pp.headers:
+		return resp.Headers, nil
+	case <-time.After(p2pTimeout):
+	case <-ctx.Done():
+	}
+	return nil, pp.fail(ctx)
+}
+
+func (pp *p2pPeer) getCFHeaders(ctx context.Context, start int64, stop chainhash.Hash) (*wire.MsgCFHeaders, error) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	pp.p.QueueMessage(wire.NewMsgGetCFHeaders(wire.GCSFilterRegular, uint32(start), &stop), nil)
+	select {
+	case msg := <-pp.cfheaders:
+		return msg, nil
+	case <-time.After(p2pTimeout):
+	case <-ctx.Done():
+	}
+	return nil, pp.fail(ctx)
+}
+
+func (pp *p2pPeer) getCFilters(ctx context.Context, start int64, stop chainhash.Hash) ([]*wire.MsgCFilter, error) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	pp.p.QueueMessage(wire.NewMsgGetCFilters(wire.GCSFilterRegular, uint32(start), &stop), nil)
+	var filters []*wire.MsgCFilter
+	for {
+		select {
+		case msg := <-pp.cfilters:
+			filters = append(filters, msg)
+			if msg.BlockHash == stop {
+				return filters, nil
+			}
+		case <-time.After(p2pTimeout):
+			return nil, pp.fail(ctx)
+		case <-ctx.Done():
+			return nil, pp.fail(ctx)
+		}
+	}
+}
+
+func (pp *p2pPeer) getBlock(ctx context.Context, hash chainhash.Hash) (*wire.MsgBlock, error) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	msg := wire.NewMsgGetData()
+	if err := msg.AddInvVect(wire.NewInvVect(wire.InvTypeWitnessBlock, &hash)); err != nil {
+		return nil, err
+	}
+	pp.p.QueueMessage(msg, nil)
+	select {
+	case block := <-pp.blocks:
+		return block, nil
+	case <-pp.notFound:
+		return nil, errors.New("block not found")
+	case <-time.After(p2pTimeout):
+	case <-ctx.Done():
+	}
+	return nil, pp.fail(ctx)
+}
+
+func (pp *p2pPeer) sendTx(tx *wire.MsgTx) {
+	pp.p.QueueMessage(tx, nil)
+}
+
+func (pp *p2pPeer) connected() bool {
+	return pp.p.Connected()
+}
+
+func (pp *p2pPeer) close() {
+	pp.p.Disconnect()
+}
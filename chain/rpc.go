@@ -0,0 +1,324 @@
+package chain
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcrpcclient"
+)
+
+// DefaultPollInterval is how often RPC backends check for new blocks.
+const DefaultPollInterval = 10 * time.Second
+
+// RPCConfig configures a connection to a node's JSON-RPC interface.
+type RPCConfig struct {
+	Host string
+	User string
+	Pass string
+
+	// CookieFile is the path of Bitcoin Core's .cookie file. If set, it's
+	// used instead of User and Pass.
+	CookieFile string
+
+	// Certificates are the PEM encoded certificates used to verify btcd's
+	// TLS certificate. If empty, TLS is disabled.
+	Certificates []byte
+}
+
+// RPC is a Backend that uses a node's JSON-RPC interface. Only chain and
+// mempool calls are used, so Bitcoin Core may run with -disablewallet.
+type RPC struct {
+	c *btcrpcclient.Client
+
+	// PollInterval is how often SubscribeBlocks polls for new blocks.
+	PollInterval time.Duration
+}
+
+// ReadCookie returns the user and password from a Bitcoin Core cookie file.
+func ReadCookie(path string) (string, string, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	user, pass, ok := strings.Cut(strings.TrimSpace(string(buf)), ":")
+	if !ok {
+		return "", "", errors.New("invalid cookie file")
+	}
+	return user, pass, nil
+}
+
+func newRPC(c RPCConfig, tls bool) (*RPC, error) {
+	connCfg := &btcrpcclient.ConnConfig{
+		Host:         c.Host,
+		User:         c.User,
+		Pass:         c.Pass,
+		HTTPPostMode: true,
+		DisableTLS:   !tls,
+		Certificates: c.Certificates,
+	}
+	if c.CookieFile != "" {
+		user, pass, err := ReadCookie(c.CookieFile)
+		if err != nil {
+			return nil, err
+		}
+		connCfg.User = user
+		connCfg.Pass = pass
+	}
+	bc, err := btcrpcclient.New(connCfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &RPC{c: bc, PollInterval: DefaultPollInterval}, nil
+}
+
+// NewBitcoinCore returns a backend connected to Bitcoin Core.
+func NewBitcoinCore(c RPCConfig) (*RPC, error) {
+	return newRPC(c, false)
+}
+
+// NewBtcd returns a backend connected to btcd, over TLS if certificates are
+// given.
+func NewBtcd(c RPCConfig) (*RPC, error) {
+	return newRPC(c, len(c.Certificates) > 0)
+}
+
+// Client returns the underlying RPC client.
+func (b *RPC) Client() *btcrpcclient.Client {
+	return b.c
+}
+
+func (b *RPC) Shutdown() {
+	b.c.Shutdown()
+}
+
+func (b *RPC) GetTxOut(ctx context.Context, txid string, vout uint32, includeMempool bool) (*TxOut, error) {
+	txhash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return nil, err
+	}
+	res, err := b.c.GetTxOut(txhash, vout, includeMempool)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return nil, nil
+	}
+	pkscript, err := hex.DecodeString(res.ScriptPubKey.Hex)
+	if err != nil {
+		return nil, err
+	}
+	return &TxOut{
+		// yuck
+		Value:         int64(res.Value * 1e8),
+		PkScript:      pkscript,
+		Confirmations: int(res.Confirmations),
+		Coinbase:      res.Coinbase,
+		BestBlock:     res.BestBlock,
+	}, nil
+}
+
+func (b *RPC) GetHeight(ctx context.Context, hash string) (int64, error) {
+	if hash == "" {
+		return b.c.GetBlockCount()
+	}
+	bh, err := chainhash.NewHashFromStr(hash)
+	if err != nil {
+		return 0, err
+	}
+	header, err := b.c.GetBlockHeaderVerbose(bh)
+	if err != nil {
+		return 0, err
+	}
+	return int64(header.Height), nil
+}
+
+func (b *RPC) GetBlockHash(ctx context.Context, height int64) (string, error) {
+	h, err := b.c.GetBlockHash(height)
+	if err != nil {
+		return "", err
+	}
+	return h.String(), nil
+}
+
+// GetTxStatus looks up the transaction with getrawtransaction, so
+// confirmed transactions are only found if the node has -txindex.
+func (b *RPC) GetTxStatus(ctx context.Context, txid string) (*TxStatus, error) {
+	txhash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return nil, err
+	}
+	res, err := b.c.GetRawTransactionVerbose(txhash)
+	if isNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	st := &TxStatus{Confirmations: int(res.Confirmations)}
+	if res.BlockHash != "" {
+		st.BlockHash = res.BlockHash
+		st.BlockHeight, err = b.GetHeight(ctx, res.BlockHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// isNotFound reports whether err is bitcoind's "No such mempool or
+// blockchain transaction" error.
+func isNotFound(err error) bool {
+	var rpcErr *btcjson.RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == btcjson.ErrRPCInvalidAddressOrKey
+}
+
+func (b *RPC) Broadcast(ctx context.Context, tx *wire.MsgTx) (string, error) {
+	txid, err := b.c.SendRawTransaction(tx, false)
+	if err != nil {
+		return "", err
+	}
+	return txid.String(), nil
+}
+
+type spendingPrevOut struct {
+	TxID string `json:"txid"`
+	Vout uint32 `json:"vout"`
+}
+
+type spendingResult struct {
+	SpendingTxID string `json:"spendingtxid"`
+}
+
+// GetSpender finds the spender with Bitcoin Core's gettxspendingprevout,
+// which only searches the mempool. It isn't supported by btcd.
+func (b *RPC) GetSpender(ctx context.Context, txid string, vout uint32) (string, error) {
+	outputs, err := json.Marshal([]spendingPrevOut{{TxID: txid, Vout: vout}})
+	if err != nil {
+		return "", err
+	}
+	buf, err := b.c.RawRequest("gettxspendingprevout", []json.RawMessage{outputs})
+	if err != nil {
+		return "", err
+	}
+	var res []spendingResult
+	if err := json.Unmarshal(buf, &res); err != nil {
+		return "", err
+	}
+	if len(res) == 0 {
+		return "", nil
+	}
+	return res[0].SpendingTxID, nil
+}
+
+type scanResult struct {
+	Success   bool   `json:"success"`
+	Height    int64  `json:"height"`
+	BestBlock string `json:"bestblock"`
+	Unspents  []struct {
+		TxID         string  `json:"txid"`
+		Vout         uint32  `json:"vout"`
+		ScriptPubKey string  `json:"scriptPubKey"`
+		Amount       float64 `json:"amount"`
+		Height       int64   `json:"height"`
+		Coinbase     bool    `json:"coinbase"`
+	} `json:"unspents"`
+}
+
+// ListUnspent scans the UTXO set with Bitcoin Core's scantxoutset, which
+// doesn't need a wallet. It isn't supported by btcd.
+func (b *RPC) ListUnspent(ctx context.Context, addrs []string) ([]UTXO, error) {
+	descs := make([]string, len(addrs))
+	for i, a := range addrs {
+		descs[i] = "addr(" + a + ")"
+	}
+	action, err := json.Marshal("start")
+	if err != nil {
+		return nil, err
+	}
+	objects, err := json.Marshal(descs)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := b.c.RawRequest("scantxoutset", []json.RawMessage{action, objects})
+	if err != nil {
+		return nil, err
+	}
+	var res scanResult
+	if err := json.Unmarshal(buf, &res); err != nil {
+		return nil, err
+	}
+	if !res.Success {
+		return nil, errors.New("scantxoutset failed")
+	}
+
+	var utxos []UTXO
+	for _, u := range res.Unspents {
+		pkscript, err := hex.DecodeString(u.ScriptPubKey)
+		if err != nil {
+			return nil, err
+		}
+		utxos = append(utxos, UTXO{
+			TxID: u.TxID,
+			Vout: u.Vout,
+			TxOut: TxOut{
+				// yuck
+				Value:         int64(u.Amount * 1e8),
+				PkScript:      pkscript,
+				Confirmations: int(res.Height - u.Height + 1),
+				Coinbase:      u.Coinbase,
+				BestBlock:     res.BestBlock,
+			},
+		})
+	}
+	return utxos, nil
+}
+
+func (b *RPC) SubscribeBlocks(ctx context.Context) (<-chan int64, error) {
+	return pollBlocks(ctx, b.PollInterval, func() (int64, error) {
+		return b.GetHeight(ctx, "")
+	}), nil
+}
+
+// pollBlocks calls height every interval and sends its result whenever it
+// changes. The channel is closed when ctx is cancelled.
+func pollBlocks(ctx context.Context, interval time.Duration, height func() (int64, error)) <-chan int64 {
+	ch := make(chan int64, 1)
+	go func() {
+		defer close(ch)
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		var last int64
+		for {
+			h, err := height()
+			if err != nil {
+				slog.Warn("poll block height failed", "err", err)
+			} else if h != last {
+				select {
+				case ch <- h:
+					last = h
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,54 @@
+package chain
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadCookie(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".cookie")
+	if err := os.WriteFile(path, []byte("__cookie__:abc:def\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	user, pass, err := ReadCookie(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "__cookie__" || pass != "abc:def" {
+		t.Errorf("Unexpected credentials %q %q", user, pass)
+	}
+
+	if err := os.WriteFile(path, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReadCookie(path); err == nil {
+		t.Errorf("Expected error for invalid cookie")
+	}
+}
+
+func TestPollBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	heights := []int64{100, 100, 101, 101, 103}
+	var i int
+	ch := pollBlocks(ctx, time.Millisecond, func() (int64, error) {
+		h := heights[i]
+		if i < len(heights)-1 {
+			i++
+		}
+		return h, nil
+	})
+
+	for _, expected := range []int64{100, 101, 103} {
+		if h := <-ch; h != expected {
+			t.Errorf("Expected height %d, got %d", expected, h)
+		}
+	}
+
+	cancel()
+	for range ch {
+	}
+}
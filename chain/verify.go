@@ -0,0 +1,73 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// ErrUnsupported is returned by wrapping backends when the wrapped backend
+// doesn't support an operation.
+var ErrUnsupported = errors.New("not supported by chain backend")
+
+// ErrMismatch is returned when backends disagree about a confirmed output.
+var ErrMismatch = errors.New("chain backends disagree")
+
+// Verified is a Backend that checks confirmed outputs returned by Primary
+// against Secondary, for example a block explorer, so that a single
+// compromised or faulty node can't fake a channel's funding. All other calls
+// go to Primary only.
+type Verified struct {
+	Backend
+	Secondary Backend
+}
+
+func (v Verified) GetTxOut(ctx context.Context, txid string, vout uint32, includeMempool bool) (*TxOut, error) {
+	out, err := v.Backend.GetTxOut(ctx, txid, vout, includeMempool)
+	if err != nil || out == nil || out.Confirmations == 0 {
+		return out, err
+	}
+
+	if err := v.verify(ctx, txid, vout, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (v Verified) verify(ctx context.Context, txid string, vout uint32, out *TxOut) error {
+	check, err := v.Secondary.GetTxOut(ctx, txid, vout, false)
+	if err != nil {
+		return err
+	}
+	if check == nil || check.Value != out.Value || !bytes.Equal(check.PkScript, out.PkScript) {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// ListUnspent lists outputs using Primary and verifies each of them.
+func (v Verified) ListUnspent(ctx context.Context, addrs []string) ([]UTXO, error) {
+	s, ok := v.Backend.(AddressScanner)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	utxos, err := s.ListUnspent(ctx, addrs)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range utxos {
+		if err := v.verify(ctx, u.TxID, u.Vout, &u.TxOut); err != nil {
+			return nil, err
+		}
+	}
+	return utxos, nil
+}
+
+// GetSpender finds the spender using Primary. The result isn't verified.
+func (v Verified) GetSpender(ctx context.Context, txid string, vout uint32) (string, error) {
+	s, ok := v.Backend.(SpendFinder)
+	if !ok {
+		return "", ErrUnsupported
+	}
+	return s.GetSpender(ctx, txid, vout)
+}
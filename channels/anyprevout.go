@@ -0,0 +1,95 @@
+//go:build anyprevout
+// +build anyprevout
+
+package channels
+
+import (
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const anyPrevOutEnabled = true
+
+// SigHashAnyPrevOut is the BIP 118 sighash flag. Update signatures carry it so
+// that they can't be mistaken for ordinary signatures.
+const SigHashAnyPrevOut txscript.SigHashType = 0x41
+
+var ErrInvalidUpdateSig = errors.New("invalid update signature")
+
+// anyPrevOutSigHash returns the digest signed by an update signature. It
+// covers the closure transaction outputs and the funding script but not the
+// funding outpoint, so the same signature can be rebound to any output with
+// the same script.
+//
+// Consensus doesn't support this yet outside of experimental signets, so
+// these signatures are only verified by VerifyUpdate and never broadcast.
+func (s *SharedState) anyPrevOutSigHash(balance int64, hash [32]byte) ([]byte, error) {
+	tx, err := s.GetClosureTx(balance, hash)
+	if err != nil {
+		return nil, err
+	}
+	tx.TxIn[0].PreviousOutPoint = wire.OutPoint{}
+
+	script, _, err := s.GetFundingScript()
+	if err != nil {
+		return nil, err
+	}
+
+	return txscript.CalcSignatureHash(script,
+		txscript.SigHashAll|txscript.SigHashAnyOneCanPay, tx, 0)
+}
+
+// SignUpdate returns the sender's rebindable signature for the state with the
+// given balance and paymentsHash.
+func (s *Sender) SignUpdate(balance int64, hash [32]byte) ([]byte, error) {
+	if s.config.UpdateMode != UpdateModeAnyPrevOut {
+		return nil, ErrUpdateModeUnsupported
+	}
+
+	digest, err := s.State.anyPrevOutSigHash(balance, hash)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := s.privKey.Sign(digest)
+	if err != nil {
+		return nil, err
+	}
+	return append(sig.Serialize(), byte(SigHashAnyPrevOut)), nil
+}
+
+// VerifyUpdate checks a rebindable update signature from the sender. Since
+// the signature doesn't commit to the funding outpoint, it remains valid if
+// the channel is moved to a new funding output with the same script.
+func (s *SharedState) VerifyUpdate(balance int64, hash [32]byte, sig []byte) error {
+	if len(sig) < 2 || txscript.SigHashType(sig[len(sig)-1]) != SigHashAnyPrevOut {
+		return ErrInvalidUpdateSig
+	}
+
+	pubKey, err := btcec.ParsePubKey(s.SenderPubKey, btcec.S256())
+	if err != nil {
+		return err
+	}
+	signature, err := btcec.ParseDERSignature(sig[:len(sig)-1], btcec.S256())
+	if err != nil {
+		return ErrInvalidUpdateSig
+	}
+
+	ss := *s
+	if ss.FundingTxID == "" {
+		// The outpoint isn't signed, but GetClosureTx needs one.
+		ss.FundingTxID = chainhash.Hash{}.String()
+	}
+	digest, err := ss.anyPrevOutSigHash(balance, hash)
+	if err != nil {
+		return err
+	}
+
+	if !signature.Verify(digest, pubKey) {
+		return ErrInvalidUpdateSig
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+//go:build !anyprevout
+// +build !anyprevout
+
+package channels
+
+const anyPrevOutEnabled = false
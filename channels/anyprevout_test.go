@@ -0,0 +1,53 @@
+//go:build anyprevout
+// +build anyprevout
+
+package channels
+
+import (
+	"testing"
+)
+
+func TestUpdateRebind(t *testing.T) {
+	_, senderWIF, _ := setUp(t)
+	_, r := setUpChannel(t, testCapacity)
+
+	c := DefaultSenderConfig
+	c.UpdateMode = UpdateModeAnyPrevOut
+	s, err := LoadSender(c, r.State, senderWIF.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const balance = 1000
+	sig, err := s.SignUpdate(balance, s.State.PaymentsHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.State.VerifyUpdate(balance, r.State.PaymentsHash, sig); err != nil {
+		t.Errorf("VerifyUpdate error: %v", err)
+	}
+
+	// The signature is still valid for a different funding outpoint.
+	moved := r.State
+	moved.FundingTxID = "a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	moved.FundingVout = 3
+	if err := moved.VerifyUpdate(balance, moved.PaymentsHash, sig); err != nil {
+		t.Errorf("Expected rebound signature to be valid: %v", err)
+	}
+
+	// But not for a different balance.
+	if err := r.State.VerifyUpdate(balance+1, r.State.PaymentsHash, sig); err != ErrInvalidUpdateSig {
+		t.Errorf("Expected ErrInvalidUpdateSig, got: %v", err)
+	}
+}
+
+func TestUpdateModeDefault(t *testing.T) {
+	_, senderWIF, _ := setUp(t)
+	s, err := NewSender(DefaultSenderConfig, senderWIF.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SignUpdate(0, s.State.PaymentsHash); err != ErrUpdateModeUnsupported {
+		t.Errorf("Expected ErrUpdateModeUnsupported, got: %v", err)
+	}
+}
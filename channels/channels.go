@@ -3,7 +3,6 @@ package channels
 import (
 	"errors"
 
-	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcutil"
 )
@@ -15,6 +14,11 @@ const (
 	StatusOpen    = 2
 	StatusClosing = 3
 	StatusClosed  = 4
+
+	// StatusOpenUnconfirmed is an open channel whose funding transaction
+	// hasn't confirmed yet. It was accepted under a zero-conf policy and the
+	// funding can still be double spent.
+	StatusOpenUnconfirmed = 5
 )
 
 func (s Status) String() string {
@@ -23,6 +27,8 @@ func (s Status) String() string {
 		return "CREATED"
 	case StatusOpen:
 		return "OPEN"
+	case StatusOpenUnconfirmed:
+		return "OPEN_UNCONFIRMED"
 	case StatusClosing:
 		return "CLOSING"
 	case StatusClosed:
@@ -32,17 +38,69 @@ func (s Status) String() string {
 	}
 }
 
+// ParseStatus returns the status with the given name, as returned by String.
+func ParseStatus(name string) (Status, bool) {
+	for _, s := range []Status{StatusCreated, StatusOpen, StatusOpenUnconfirmed,
+		StatusClosing, StatusClosed} {
+		if s.String() == name {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// IsOpen returns whether the channel accepts payments.
+func (s Status) IsOpen() bool {
+	return s == StatusOpen || s == StatusOpenUnconfirmed
+}
+
+// rank orders the statuses in the sequence a channel moves through them.
+func (s Status) rank() int {
+	switch s {
+	case StatusCreated:
+		return 1
+	case StatusOpenUnconfirmed:
+		return 2
+	case StatusOpen:
+		return 3
+	case StatusClosing:
+		return 4
+	case StatusClosed:
+		return 5
+	default:
+		return 0
+	}
+}
+
 const (
 	Version = 1
 )
 
 var ErrAmountTooSmall = errors.New("amount is too small")
+var ErrAmountTooLarge = errors.New("amount is too large")
 var ErrInsufficientCapacity = errors.New("amount exceeds channel capacity")
+var ErrInvalidPayment = errors.New("invalid payment")
+var ErrInvalidSenderSig = errors.New("invalid sender signature")
+
+// Available returns the largest payment the channel can still accept.
+func (ss *SharedState) Available() int64 {
+	a := ss.Capacity - ss.Balance - ss.Held() - ss.Fee
+	if a < 0 {
+		return 0
+	}
+	return a
+}
 
 func (ss *SharedState) validateAmount(amount int64) (int64, error) {
 	if amount <= 0 {
 		return ss.Balance, ErrAmountTooSmall
 	}
+	if ss.MinPayment > 0 && amount < ss.MinPayment {
+		return ss.Balance, ErrAmountTooSmall
+	}
+	if ss.MaxPayment > 0 && amount > ss.MaxPayment {
+		return ss.Balance, ErrAmountTooLarge
+	}
 	if amount > ss.Capacity {
 		return ss.Balance, ErrInsufficientCapacity
 	}
@@ -53,7 +111,7 @@ func (ss *SharedState) validateAmount(amount int64) (int64, error) {
 		return ss.Balance, ErrAmountTooSmall
 	}
 
-	if newBalance+ss.Fee > ss.Capacity {
+	if newBalance+ss.Held()+ss.Fee > ss.Capacity {
 		return ss.Balance, ErrInsufficientCapacity
 	}
 
@@ -82,9 +140,18 @@ func checkSupportedAddress(net *chaincfg.Params, addr string) error {
 	return ErrInvalidAddress
 }
 
-func derivePubKey(privKey *btcec.PrivateKey, net *chaincfg.Params) (*btcutil.AddressPubKey, error) {
-	pk := (*btcec.PublicKey)(&privKey.PublicKey)
-	return btcutil.NewAddressPubKey(pk.SerializeCompressed(), net)
+func validatePaymentLimits(minPayment, maxPayment int64) error {
+	if minPayment < 0 || maxPayment < 0 {
+		return errors.New("invalid payment limits")
+	}
+	if maxPayment > 0 && maxPayment < minPayment {
+		return errors.New("invalid payment limits")
+	}
+	return nil
+}
+
+func derivePubKey(signer Signer, net *chaincfg.Params) (*btcutil.AddressPubKey, error) {
+	return btcutil.NewAddressPubKey(signer.PubKey().SerializeCompressed(), net)
 }
 
 var ErrNotStatusCreated = errors.New("channel is not in state created")
@@ -1,10 +1,13 @@
 package channels
 
 import (
+	"bytes"
 	"encoding/hex"
 	"testing"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 
@@ -36,18 +39,36 @@ func setUp(t *testing.T) (*chaincfg.Params, *btcutil.WIF, *btcutil.WIF) {
 }
 
 func setUpChannel(t *testing.T, capacity int64) (*Sender, *Receiver) {
+	return setUpChannelWithAppData(t, capacity, nil)
+}
+
+func setUpChannelWithAppData(t *testing.T, capacity int64, appData []byte) (*Sender, *Receiver) {
+	return setUpChannelWithOptions(t, capacity, appData, false)
+}
+
+func setUpChannelWithOptions(t *testing.T, capacity int64, appData []byte, revocable bool) (*Sender, *Receiver) {
 	_, senderWIF, receiverWIF := setUp(t)
 
 	s, err := NewSender(DefaultSenderConfig, senderWIF.PrivKey)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if err := s.SetAppData(appData); err != nil {
+		t.Fatal(err)
+	}
+	if revocable {
+		if err := s.SetRevocable(); err != nil {
+			t.Fatal(err)
+		}
+	}
 	createReq, err := s.GetCreateRequest(addr1)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	r, err := NewReceiver(DefaultReceiverConfig, addr2, receiverWIF.PrivKey)
+	rc := DefaultReceiverConfig
+	rc.AllowRevocable = revocable
+	r, err := NewReceiver(rc, addr2, receiverWIF.PrivKey)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -115,6 +136,75 @@ func TestImmediateClose(t *testing.T) {
 	closeChannels(t, s, r)
 }
 
+func TestStaleClose(t *testing.T) {
+	s, r := setUpChannel(t, testCapacity)
+	send(t, s, r, 1000)
+
+	old := *r
+	old.State.FeeSigs = append([]FeeSig(nil), r.State.FeeSigs...)
+	send(t, s, r, 2000)
+
+	closeReq, err := s.GetCloseRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeResp, err := old.Close(closeReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GotCloseResponse(closeResp); err != ErrStaleCloseTx {
+		t.Errorf("Expected ErrStaleCloseTx, got %v", err)
+	}
+
+	closeResp, err = r.Close(closeReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GotCloseResponse(closeResp); err != nil {
+		t.Errorf("Expected current closure to be accepted, got %v", err)
+	}
+}
+
+func TestAppData(t *testing.T) {
+	appData := []byte(`{"user":"1234"}`)
+
+	s, r := setUpChannelWithAppData(t, testCapacity, appData)
+
+	if !bytes.Equal(r.State.AppData, appData) {
+		t.Errorf("Unexpected receiver app data: %x", r.State.AppData)
+	}
+	if r.State.PaymentsHash != s.State.PaymentsHash {
+		t.Errorf("PaymentsHash differs")
+	}
+	if r.State.PaymentsHash == [32]byte{} {
+		t.Errorf("Expected PaymentsHash to commit to app data")
+	}
+
+	status, err := r.Status(&models.StatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(status.AppData, appData) {
+		t.Errorf("Unexpected status app data: %x", status.AppData)
+	}
+
+	send(t, s, r, 1000)
+
+	closeChannels(t, s, r)
+}
+
+func TestAppDataTooLarge(t *testing.T) {
+	_, senderWIF, _ := setUp(t)
+
+	s, err := NewSender(DefaultSenderConfig, senderWIF.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetAppData(make([]byte, maxAppDataSize+1)); err != ErrAppDataTooLarge {
+		t.Errorf("Expected ErrAppDataTooLarge, got: %v", err)
+	}
+}
+
 func TestRefund(t *testing.T) {
 	s, r := setUpChannel(t, testCapacity)
 
@@ -276,3 +366,677 @@ func TestValidateAmount(t *testing.T) {
 		t.Errorf("Expected ErrInsufficientCapacity, got: %v", err)
 	}
 }
+
+func TestValidateAmountLimits(t *testing.T) {
+	var s SharedState
+	s.Balance = 1000
+	s.Capacity = 100000
+	s.Fee = 100
+	s.MinPayment = 50
+	s.MaxPayment = 5000
+
+	if _, err := s.validateAmount(49); err != ErrAmountTooSmall {
+		t.Errorf("Expected ErrAmountTooSmall, got: %v", err)
+	}
+
+	if nb, err := s.validateAmount(50); nb != 1050 || err != nil {
+		t.Errorf("Unexpected result: %d", nb)
+	}
+
+	if nb, err := s.validateAmount(5000); nb != 6000 || err != nil {
+		t.Errorf("Unexpected result: %d", nb)
+	}
+
+	if _, err := s.validateAmount(5001); err != ErrAmountTooLarge {
+		t.Errorf("Expected ErrAmountTooLarge, got: %v", err)
+	}
+}
+
+func TestNegotiatedPaymentLimits(t *testing.T) {
+	_, senderWIF, receiverWIF := setUp(t)
+
+	s, err := NewSender(DefaultSenderConfig, senderWIF.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createReq, err := s.GetCreateRequest(addr1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc := DefaultReceiverConfig
+	rc.MinPayment = 1000
+	rc.MaxPayment = 10000
+	r, err := NewReceiver(rc, addr2, receiverWIF.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createResp, err := r.Create(createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GotCreateResponse(createResp); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.State.MinPayment != rc.MinPayment || s.State.MaxPayment != rc.MaxPayment {
+		t.Errorf("Unexpected sender payment limits: %+v", s.State)
+	}
+}
+
+func TestClosureTxState(t *testing.T) {
+	s, r := setUpChannel(t, testCapacity)
+
+	const amount = 1000
+	hash := chainHash(s.State.PaymentsHash, testPayment)
+
+	tx, err := s.State.GetClosureTx(amount, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance, h, err := r.State.closureTxState(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != amount || h != hash {
+		t.Errorf("Unexpected closure tx state: %d %x", balance, h)
+	}
+
+	// Steal from the sender by increasing the fee.
+	tx.TxOut[2].Value -= 1000
+	if _, _, err := r.State.closureTxState(tx); err == nil {
+		t.Errorf("Expected error due to wrong fee")
+	}
+
+	// Redirect the receiver's output to the sender's address.
+	tx, err = s.State.GetClosureTx(amount, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.TxOut[1].PkScript = tx.TxOut[2].PkScript
+	if _, _, err := r.State.closureTxState(tx); err == nil {
+		t.Errorf("Expected error due to wrong destination")
+	}
+}
+
+func TestCheckTransition(t *testing.T) {
+	s, r := setUpChannel(t, testCapacity)
+
+	prev := r.State
+	sendReq, err := s.GetSendRequest(1000, testPayment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Send(1000, sendReq); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckTransition(prev, r.State); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err := CheckTransition(r.State, prev); err == nil {
+		t.Errorf("Expected error due to count decrease")
+	}
+
+	next := r.State
+	next.Status = StatusCreated
+	if _, ok := CheckTransition(r.State, next).(InvariantError); !ok {
+		t.Errorf("Expected InvariantError due to status transition")
+	}
+
+	next = r.State
+	next.Balance = next.Capacity
+	next.Count++
+	if err := CheckTransition(r.State, next); err == nil {
+		t.Errorf("Expected error due to balance exceeding capacity")
+	}
+
+	next = r.State
+	// There is no point on the curve with x = 5.
+	next.SenderPubKey = make([]byte, 33)
+	next.SenderPubKey[0] = 2
+	next.SenderPubKey[32] = 5
+	if err := CheckTransition(r.State, next); err == nil {
+		t.Errorf("Expected error due to invalid pubkey")
+	}
+}
+
+type fakeChain struct {
+	height int64
+	sent   []*wire.MsgTx
+}
+
+func (c *fakeChain) GetBlockCount() (int64, error) {
+	c.height++
+	return c.height, nil
+}
+
+func (c *fakeChain) SendRawTransaction(tx *wire.MsgTx, allowHighFees bool) (*chainhash.Hash, error) {
+	c.sent = append(c.sent, tx)
+	h := tx.TxHash()
+	return &h, nil
+}
+
+func TestRefundMaturity(t *testing.T) {
+	var s SharedState
+	s.Timeout = 144
+
+	if _, err := s.RefundMaturity(1000); err != ErrUnknownBlockHeight {
+		t.Errorf("Expected ErrUnknownBlockHeight, got: %v", err)
+	}
+
+	s.BlockHeight = 1000
+	if n, err := s.RefundMaturity(1000); n != 143 || err != nil {
+		t.Errorf("Unexpected result: %d %v", n, err)
+	}
+	if n, err := s.RefundMaturity(1143); n != 0 || err != nil {
+		t.Errorf("Unexpected result: %d %v", n, err)
+	}
+	if n, err := s.RefundMaturity(2000); n != 0 || err != nil {
+		t.Errorf("Unexpected result: %d %v", n, err)
+	}
+}
+
+func TestWaitForRefund(t *testing.T) {
+	s, _ := setUpChannel(t, testCapacity)
+	s.State.BlockHeight = 100
+
+	RefundPollInterval = 0
+	bc := &fakeChain{height: 100}
+	txid, err := s.WaitForRefund(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bc.height != 100+s.State.Timeout-1 {
+		t.Errorf("Broadcast at unexpected height %d", bc.height)
+	}
+	if len(bc.sent) != 1 || bc.sent[0].TxHash() != *txid {
+		t.Errorf("Expected refund tx to be broadcast")
+	}
+}
+
+func send(t *testing.T, s *Sender, r *Receiver, amount int64) *models.SendRequest {
+	sendReq, err := s.GetSendRequest(amount, testPayment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendResp, err := r.Send(amount, sendReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GotSendResponse(amount, testPayment, sendResp); err != nil {
+		t.Fatal(err)
+	}
+	return sendReq
+}
+
+func decodeTx(t *testing.T, rawTx []byte) *wire.MsgTx {
+	var tx wire.MsgTx
+	if err := tx.BtcDecode(bytes.NewReader(rawTx), 2); err != nil {
+		t.Fatal(err)
+	}
+	return &tx
+}
+
+func execute(t *testing.T, prevTx, tx *wire.MsgTx) error {
+	prevOut := prevTx.TxOut[tx.TxIn[0].PreviousOutPoint.Index]
+	engine, err := txscript.NewEngine(prevOut.PkScript, tx, 0, txscript.StandardVerifyFlags, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine.Execute()
+}
+
+func TestRevocable(t *testing.T) {
+	_, _, receiverWIF := setUp(t)
+	s, r := setUpChannelWithOptions(t, testCapacity, nil, true)
+
+	if len(s.State.CommitmentSig) == 0 {
+		t.Fatalf("Expected commitment signature after open")
+	}
+	if _, err := s.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	const amount = 1000
+
+	send(t, s, r, amount)
+	rawCommit1, err := s.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.State.validateTx(rawCommit1); err != nil {
+		t.Errorf("Invalid commitment tx: %v", err)
+	}
+	commit1 := decodeTx(t, rawCommit1)
+
+	send(t, s, r, amount)
+	req := send(t, s, r, amount)
+	secret1 := req.RevocationSecret
+
+	if !bytes.Equal(r.State.RevocationHash, s.State.RevocationHash) {
+		t.Errorf("RevocationHash differs")
+	}
+
+	// The receiver can claim the sender's output of a revoked commitment.
+	rawPenalty, err := r.State.GetPenaltyTx(commit1, secret1, receiverWIF.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := execute(t, commit1, decodeTx(t, rawPenalty)); err != nil {
+		t.Errorf("Invalid penalty tx: %v", err)
+	}
+
+	// But not with the secret of another state.
+	secret0 := RevocationSecret(s.privKey, 0)
+	if _, err := r.State.GetPenaltyTx(commit1, secret0, receiverWIF.PrivKey); err == nil {
+		t.Errorf("Expected error for wrong revocation secret")
+	}
+
+	// The sender can sweep its output of the latest commitment after the
+	// revocation delay.
+	rawCommit3, err := s.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit3 := decodeTx(t, rawCommit3)
+	rawSweep, err := s.State.GetCommitmentSweepTx(commit3, s.privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := execute(t, commit3, decodeTx(t, rawSweep)); err != nil {
+		t.Errorf("Invalid sweep tx: %v", err)
+	}
+
+	// A wrong revocation secret is rejected.
+	badReq, err := s.GetSendRequest(amount, testPayment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badReq.RevocationSecret = secret0
+	if _, err := r.Send(amount, badReq); err != ErrInvalidRevocationSecret {
+		t.Errorf("Expected ErrInvalidRevocationSecret, got: %v", err)
+	}
+
+	closeChannels(t, s, r)
+}
+
+func TestReplay(t *testing.T) {
+	_, _, receiverWIF := setUp(t)
+	s, r := setUpChannelWithOptions(t, testCapacity, nil, true)
+	opened := r.State
+
+	var reqs []*models.SendRequest
+	for _, amount := range []int64{1000, 2000} {
+		reqs = append(reqs, send(t, s, r, amount))
+	}
+
+	rr, err := LoadReceiver(DefaultReceiverConfig, opened, receiverWIF.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, amount := range []int64{1000, 2000} {
+		if err := rr.Replay(amount, reqs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rr.State.Balance != r.State.Balance ||
+		rr.State.Count != r.State.Count ||
+		rr.State.PaymentsHash != r.State.PaymentsHash ||
+		!bytes.Equal(rr.State.CommitmentSig, r.State.CommitmentSig) {
+		t.Errorf("Replayed state differs: %+v", rr.State)
+	}
+
+	// A payment replayed with the wrong amount doesn't match the sender's
+	// signature.
+	rr, err = LoadReceiver(DefaultReceiverConfig, opened, receiverWIF.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rr.Replay(999, reqs[0]); err == nil {
+		t.Error("Expected invalid signature")
+	}
+}
+
+func TestRevocableNotAllowed(t *testing.T) {
+	_, senderWIF, receiverWIF := setUp(t)
+
+	s, err := NewSender(DefaultSenderConfig, senderWIF.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetRevocable(); err != nil {
+		t.Fatal(err)
+	}
+	createReq, err := s.GetCreateRequest(addr1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReceiver(DefaultReceiverConfig, addr2, receiverWIF.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Create(createReq); err == nil {
+		t.Errorf("Expected error for revocable channel")
+	}
+}
+
+func TestHoldCapture(t *testing.T) {
+	s, r := setUpChannel(t, testCapacity)
+
+	hold := func(id string, amount int64) error {
+		req, err := s.GetHoldRequest(id, amount)
+		if err != nil {
+			return err
+		}
+		resp, err := r.Hold(req)
+		if err != nil {
+			return err
+		}
+		return s.GotHoldResponse(req, resp)
+	}
+
+	if err := hold("meter", 800000); err != nil {
+		t.Fatal(err)
+	}
+	if r.State.Held() != 800000 || s.State.Held() != 800000 {
+		t.Errorf("Unexpected held amount")
+	}
+	if a := r.State.Available(); a != testCapacity-r.State.Balance-800000-r.State.Fee {
+		t.Errorf("Unexpected available capacity %d", a)
+	}
+	if err := hold("meter", 1000); err != ErrHoldExists {
+		t.Errorf("Expected ErrHoldExists, got: %v", err)
+	}
+
+	// The held capacity can't be used by other payments.
+	if _, err := s.GetSendRequest(100000, testPayment); err != ErrInsufficientCapacity {
+		t.Errorf("Expected ErrInsufficientCapacity, got: %v", err)
+	}
+	if err := hold("other", 100000); err != ErrInsufficientCapacity {
+		t.Errorf("Expected ErrInsufficientCapacity, got: %v", err)
+	}
+
+	if _, err := s.GetCaptureRequest("meter", 900000, testPayment); err != ErrCaptureExceedsHold {
+		t.Errorf("Expected ErrCaptureExceedsHold, got: %v", err)
+	}
+
+	const amount = 500000
+	req, err := s.GetCaptureRequest("meter", amount, testPayment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := r.Send(amount, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GotCaptureResponse("meter", amount, testPayment, resp); err != nil {
+		t.Fatal(err)
+	}
+	if r.State.Balance != amount || s.State.Balance != amount {
+		t.Errorf("Unexpected balance")
+	}
+	if r.State.Held() != 0 || s.State.Held() != 0 {
+		t.Errorf("Expected capture to release the hold")
+	}
+
+	if err := hold("other", 100000); err != nil {
+		t.Fatal(err)
+	}
+	relReq, err := s.GetReleaseRequest("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	relResp, err := r.Release(relReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GotReleaseResponse(relReq, relResp); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.State.Holds) != 0 || len(s.State.Holds) != 0 {
+		t.Errorf("Expected no holds after release")
+	}
+
+	closeChannels(t, s, r)
+}
+
+func TestPrepareSend(t *testing.T) {
+	s, r := setUpChannel(t, testCapacity)
+
+	const amount = 1000
+
+	req1, err := s.PrepareSend(amount, testPayment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req2, err := s.PrepareSend(amount, testPayment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(req1.SenderSig, req2.SenderSig) {
+		t.Errorf("Expected retry to return the identical signature")
+	}
+	if req1.PaymentID == "" || req1.PaymentID != req2.PaymentID {
+		t.Errorf("Expected retry to return the same payment ID")
+	}
+	if s.State.Balance != 0 || s.State.Count != 0 {
+		t.Errorf("Preparing must not change the state")
+	}
+	if _, err := s.PrepareSend(2*amount, testPayment); err != ErrSendPending {
+		t.Errorf("Expected ErrSendPending, got: %v", err)
+	}
+
+	resp, err := r.Send(amount, req2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ConfirmSend(resp); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ConfirmSend(resp); err != ErrNoPendingSend {
+		t.Errorf("Expected ErrNoPendingSend, got: %v", err)
+	}
+	if s.State.Balance != amount || r.State.Balance != amount {
+		t.Errorf("Unexpected balance")
+	}
+
+	if _, err := s.PrepareSend(amount, testPayment); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AbortSend(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PrepareSend(2*amount, testPayment); err != nil {
+		t.Errorf("Expected new payment after abort, got: %v", err)
+	}
+
+	closeChannels(t, s, r)
+}
+
+func TestOpenUnconfirmed(t *testing.T) {
+	s, r := setUpChannel(t, testCapacity)
+	r.State.Status = StatusOpenUnconfirmed
+
+	// Payments are accepted before the funding confirms.
+	send(t, s, r, 1000)
+
+	prev := r.State
+	if err := r.Confirmed(); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckTransition(prev, r.State); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := CheckTransition(r.State, prev); err == nil {
+		t.Errorf("Expected error for transition back to unconfirmed")
+	}
+	if err := r.Confirmed(); err == nil {
+		t.Errorf("Expected error for confirming an open channel")
+	}
+
+	closeChannels(t, s, r)
+}
+
+func TestRequestSig(t *testing.T) {
+	s, _ := setUpChannel(t, 1000)
+	id := s.State.FundingTxID + "-0"
+	body := []byte(`{"vout":0}`)
+
+	sig, err := s.SignRequest(id, "send", "1.a", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := s.State.SenderPubKey
+	if err := VerifyRequest(pk, id, "send", "1.a", body, sig); err != nil {
+		t.Errorf("Expected valid signature, got %v", err)
+	}
+
+	bad := []struct {
+		id, method, nonce string
+		body              []byte
+	}{
+		{"other-0", "send", "1.a", body},
+		{id, "status", "1.a", body},
+		{id, "send", "1.b", body},
+		{id, "send", "1.a", []byte(`{"vout":1}`)},
+	}
+	for _, b := range bad {
+		if VerifyRequest(pk, b.id, b.method, b.nonce, b.body, sig) != ErrInvalidRequestSig {
+			t.Errorf("Expected %+v to be rejected", b)
+		}
+	}
+	if VerifyRequest(s.State.ReceiverPubKey, id, "send", "1.a", body, sig) != ErrInvalidRequestSig {
+		t.Errorf("Expected signature by other key to be rejected")
+	}
+}
+
+func TestReceipt(t *testing.T) {
+	s, r := setUpChannel(t, testCapacity)
+
+	const amount = 1000
+	send(t, s, r, amount)
+
+	id := s.State.FundingTxID + "-0"
+	rc, err := r.SignReceipt(id, amount, "target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckReceipt(*rc); err != nil {
+		t.Errorf("Expected valid receipt, got %v", err)
+	}
+
+	bad := []func(rc *models.Receipt){
+		func(rc *models.Receipt) { rc.ChannelID = "other-0" },
+		func(rc *models.Receipt) { rc.Amount++ },
+		func(rc *models.Receipt) { rc.Target = "other" },
+		func(rc *models.Receipt) { rc.Count++ },
+		func(rc *models.Receipt) { rc.ReceiverPubKey = s.State.SenderPubKey },
+	}
+	for i, f := range bad {
+		c := *rc
+		f(&c)
+		if VerifyReceipt(c) != ErrInvalidReceipt {
+			t.Errorf("%d: expected receipt to be rejected", i)
+		}
+	}
+
+	send(t, s, r, amount)
+	if s.CheckReceipt(*rc) != ErrInvalidReceipt {
+		t.Errorf("Expected stale receipt to be rejected by sender")
+	}
+}
+
+func TestEstimateClosure(t *testing.T) {
+	s, r := setUpChannel(t, testCapacity)
+	send(t, s, r, 1000)
+
+	est, err := r.State.EstimateClosure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est.Fee != r.State.Fee {
+		t.Errorf("Expected fee %d, got %d", r.State.Fee, est.Fee)
+	}
+
+	closeReq, err := s.GetCloseRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeResp, err := r.Close(closeReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(closeResp.CloseTx)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tx.TxOut) != len(est.Tx.TxOut) {
+		t.Fatalf("Expected %d outputs, got %d", len(tx.TxOut), len(est.Tx.TxOut))
+	}
+	for i, out := range tx.TxOut {
+		if out.Value != est.Tx.TxOut[i].Value || !bytes.Equal(out.PkScript, est.Tx.TxOut[i].PkScript) {
+			t.Errorf("Output %d differs from estimate", i)
+		}
+	}
+	if size := tx.SerializeSize(); size > est.VSize || size < est.VSize-4 {
+		t.Errorf("Expected size close to %d, got %d", est.VSize, size)
+	}
+}
+
+func TestFeeSigs(t *testing.T) {
+	s, r := setUpChannel(t, testCapacity)
+	s.config.FeeTiers = []int64{200, 400, 600, 5000}
+
+	const amount = 1000
+	req, err := s.GetSendRequest(amount, testPayment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The tier below the channel's fee and the one the sender's output
+	// can't pay are skipped.
+	if len(req.FeeSigs) != 2 {
+		t.Fatalf("Expected 2 fee signatures, got %d", len(req.FeeSigs))
+	}
+
+	bad := *req
+	bad.FeeSigs = []models.FeeSig{{Fee: req.FeeSigs[1].Fee, Sig: req.FeeSigs[0].Sig}}
+	if _, err := r.Send(amount, &bad); err != ErrInvalidFeeSig {
+		t.Errorf("Expected ErrInvalidFeeSig, got %v", err)
+	}
+
+	resp, err := r.Send(amount, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GotSendResponse(amount, testPayment, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		feeRate int64
+		fee     int64
+	}{
+		{0, r.State.Fee},
+		{300, r.State.Fee},
+		{350, 400 * typicalCloseTxSize},
+		{600, 600 * typicalCloseTxSize},
+		{1000, r.State.Fee},
+	}
+	for _, test := range tests {
+		c := *r
+		c.State.FeeSigs = append([]FeeSig(nil), r.State.FeeSigs...)
+		closeResp, err := c.Close(&models.CloseRequest{FeeRate: test.feeRate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if closeResp.Fee != test.fee {
+			t.Errorf("%d: expected fee %d, got %d", test.feeRate, test.fee, closeResp.Fee)
+		}
+		if err := s.validateCloseTx(closeResp.CloseTx); err != nil {
+			t.Errorf("%d: expected sender to accept closure, got %v", test.feeRate, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package channels
+
+import (
+	"bytes"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// maxSigLen is the length of the largest DER signature with its sighash
+// type byte.
+const maxSigLen = 73
+
+// ClosureEstimate is an unsigned closure transaction and its fee.
+type ClosureEstimate struct {
+	Tx *wire.MsgTx
+
+	// Fee is the capacity not paid to any output, including dust outputs
+	// that were dropped.
+	Fee int64
+
+	// VSize is the size of the transaction once signed. Since signatures
+	// vary in length, it's an upper bound.
+	VSize int
+}
+
+// EstimateClosure builds the closure transaction for the current balance
+// without signing it.
+func (s *SharedState) EstimateClosure() (*ClosureEstimate, error) {
+	tx, err := s.GetClosureTx(s.Balance, s.PaymentsHash)
+	if err != nil {
+		return nil, err
+	}
+
+	script, _, err := s.GetFundingScript()
+	if err != nil {
+		return nil, err
+	}
+
+	// Measure the transaction with placeholder signatures of maximum length
+	// in the same signature script as GetClosureTxSigned.
+	sig := bytes.Repeat([]byte{0}, maxSigLen)
+	b := txscript.NewScriptBuilder()
+	b.AddOp(txscript.OP_FALSE)
+	b.AddData(sig)
+	b.AddData(sig)
+	b.AddOp(txscript.OP_TRUE)
+	b.AddData(script)
+	sigScript, err := b.Script()
+	if err != nil {
+		return nil, err
+	}
+
+	signed := tx.Copy()
+	signed.TxIn[0].SignatureScript = sigScript
+
+	fee := s.Capacity
+	for _, out := range tx.TxOut {
+		fee -= out.Value
+	}
+
+	return &ClosureEstimate{
+		Tx:    tx,
+		Fee:   fee,
+		VSize: signed.SerializeSize(),
+	}, nil
+}
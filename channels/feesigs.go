@@ -0,0 +1,139 @@
+package channels
+
+import (
+	"errors"
+
+	"github.com/luno/moonbeam/models"
+)
+
+// FeeSig is the sender's signature of the current state's closure
+// transaction paying a higher fee than the channel's Fee. The receiver can
+// use it instead of SenderSig to get a settlement confirmed faster. The
+// extra fee is paid out of the sender's output.
+type FeeSig struct {
+	Fee int64
+	Sig []byte
+}
+
+// maxFeeSigs bounds the number of fee tiers a sender can sign.
+const maxFeeSigs = 8
+
+var ErrInvalidFeeSig = errors.New("invalid fee signature")
+
+// ErrStaleCloseTx is returned for a closure transaction that doesn't settle
+// the channel's current state.
+var ErrStaleCloseTx = errors.New("closure tx doesn't settle the current state")
+
+// withFee returns a copy of the state whose closure transaction pays fee.
+func (s SharedState) withFee(fee int64) SharedState {
+	s.Fee = fee
+	return s
+}
+
+// feeTiers returns the fees of the fee tiers the sender signs for a state
+// with the balance. Tiers that don't exceed the channel's fee or that the
+// sender's output can't pay for are skipped.
+func (s *Sender) feeTiers(balance int64) []int64 {
+	var fees []int64
+	for _, rate := range s.config.FeeTiers {
+		fee := rate * typicalCloseTxSize
+		if fee <= s.State.Fee || balance+fee > s.State.Capacity {
+			continue
+		}
+		fees = append(fees, fee)
+		if len(fees) == maxFeeSigs {
+			break
+		}
+	}
+	return fees
+}
+
+// signFeeTiers signs the closure transaction of the state with the balance
+// and hash at each of the sender's fee tiers.
+func (s *Sender) signFeeTiers(balance int64, hash [32]byte) ([]models.FeeSig, error) {
+	var res []models.FeeSig
+	for _, fee := range s.feeTiers(balance) {
+		ss := s.State.withFee(fee)
+		tx, err := ss.GetClosureTx(balance, hash)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := s.signTx(tx)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, models.FeeSig{Fee: fee, Sig: sig})
+	}
+	return res, nil
+}
+
+// validateFeeSigs checks the sender's fee tier signatures of the state with
+// the balance and hash.
+func (r *Receiver) validateFeeSigs(balance int64, hash [32]byte, sigs []models.FeeSig) ([]FeeSig, error) {
+	if len(sigs) > maxFeeSigs {
+		return nil, ErrInvalidFeeSig
+	}
+	var res []FeeSig
+	for _, fs := range sigs {
+		if fs.Fee <= r.State.Fee || balance+fs.Fee > r.State.Capacity {
+			return nil, ErrInvalidFeeSig
+		}
+		ss := r.State.withFee(fs.Fee)
+		ss.Balance = balance
+		ss.PaymentsHash = hash
+		ss.SenderSig = fs.Sig
+		if err := validateSenderSig(ss, r.signer); err != nil {
+			return nil, ErrInvalidFeeSig
+		}
+		res = append(res, FeeSig{Fee: fs.Fee, Sig: fs.Sig})
+	}
+	return res, nil
+}
+
+// validateCloseTx checks a closure transaction paying either the channel's
+// fee or one of the sender's fee tiers. The transaction must settle the
+// current state, so that the receiver can't close with an older one that
+// the sender also signed.
+func (s *Sender) validateCloseTx(rawTx []byte) error {
+	balance, hash, err := s.State.validateClosureTx(rawTx)
+	if err != nil {
+		for _, fee := range s.feeTiers(s.State.Balance) {
+			ss := s.State.withFee(fee)
+			b, h, terr := ss.validateClosureTx(rawTx)
+			if terr == nil {
+				balance, hash, err = b, h, nil
+				break
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if balance != s.State.Balance || hash != s.State.PaymentsHash {
+		return ErrStaleCloseTx
+	}
+	return nil
+}
+
+// closeSig returns the sender signature and fee to close the channel with
+// at feeRate. It's the cheapest fee tier paying at least feeRate, or the
+// channel's own fee and SenderSig if there is none.
+func (s *SharedState) closeSig(feeRate int64) (int64, []byte) {
+	fee, sig := s.Fee, s.SenderSig
+	if feeRate <= 0 || s.Fee >= feeRate*typicalCloseTxSize {
+		return fee, sig
+	}
+	var best *FeeSig
+	for i, fs := range s.FeeSigs {
+		if fs.Fee < feeRate*typicalCloseTxSize {
+			continue
+		}
+		if best == nil || fs.Fee < best.Fee {
+			best = &s.FeeSigs[i]
+		}
+	}
+	if best == nil {
+		return fee, sig
+	}
+	return best.Fee, best.Sig
+}
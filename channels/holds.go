@@ -0,0 +1,92 @@
+package channels
+
+import (
+	"errors"
+)
+
+// Hold reserves channel capacity for a payment whose final amount isn't known
+// yet, e.g. metered usage. Held capacity can't be used by other payments until
+// the hold is captured or released. Holds don't transfer any funds.
+type Hold struct {
+	ID     string
+	Amount int64
+}
+
+const (
+	maxHolds     = 32
+	maxHoldIDLen = 64
+)
+
+var ErrHoldNotFound = errors.New("hold not found")
+var ErrHoldExists = errors.New("hold already exists")
+var ErrTooManyHolds = errors.New("too many holds")
+var ErrCaptureExceedsHold = errors.New("capture amount exceeds hold")
+
+// Held returns the total amount of capacity reserved by holds.
+func (ss *SharedState) Held() int64 {
+	var total int64
+	for _, h := range ss.Holds {
+		total += h.Amount
+	}
+	return total
+}
+
+func (ss *SharedState) findHold(id string) int {
+	for i, h := range ss.Holds {
+		if h.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (ss *SharedState) addHold(id string, amount int64) error {
+	if id == "" || len(id) > maxHoldIDLen {
+		return errors.New("invalid hold id")
+	}
+	if amount <= 0 {
+		return ErrAmountTooSmall
+	}
+	if ss.findHold(id) >= 0 {
+		return ErrHoldExists
+	}
+	if len(ss.Holds) >= maxHolds {
+		return ErrTooManyHolds
+	}
+	if ss.Balance+ss.Held()+amount+ss.Fee > ss.Capacity {
+		return ErrInsufficientCapacity
+	}
+
+	holds := make([]Hold, len(ss.Holds), len(ss.Holds)+1)
+	copy(holds, ss.Holds)
+	ss.Holds = append(holds, Hold{ID: id, Amount: amount})
+	return nil
+}
+
+func (ss *SharedState) removeHold(id string) (Hold, error) {
+	i := ss.findHold(id)
+	if i < 0 {
+		return Hold{}, ErrHoldNotFound
+	}
+	h := ss.Holds[i]
+
+	holds := make([]Hold, 0, len(ss.Holds)-1)
+	holds = append(holds, ss.Holds[:i]...)
+	holds = append(holds, ss.Holds[i+1:]...)
+	ss.Holds = holds
+	return h, nil
+}
+
+// captureHold removes the hold so that amount can be paid from its capacity.
+// Any part of the hold that isn't captured is released.
+func (ss *SharedState) captureHold(id string, amount int64) error {
+	i := ss.findHold(id)
+	if i < 0 {
+		return ErrHoldNotFound
+	}
+	if amount > ss.Holds[i].Amount {
+		return ErrCaptureExceedsHold
+	}
+	_, err := ss.removeHold(id)
+	return err
+}
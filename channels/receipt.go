@@ -0,0 +1,80 @@
+package channels
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+
+	"github.com/luno/moonbeam/models"
+)
+
+var ErrInvalidReceipt = errors.New("invalid receipt")
+
+// ReceiptDigest returns the hash signed by the receiver in a receipt. It
+// commits to every field of the receipt except the signature.
+func ReceiptDigest(rc models.Receipt) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("moonbeam receipt")
+	buf.WriteByte(0)
+	buf.WriteString(rc.ChannelID)
+	buf.WriteByte(0)
+	binary.Write(&buf, binary.BigEndian, int64(rc.Count))
+	binary.Write(&buf, binary.BigEndian, rc.Amount)
+	buf.WriteString(rc.Target)
+	buf.WriteByte(0)
+	buf.Write(rc.PaymentsHash)
+	buf.Write(rc.ReceiverPubKey)
+
+	return chainhash.DoubleHashB(buf.Bytes())
+}
+
+// SignReceipt returns a receipt for the payment of amount to target that was
+// last accepted on the channel.
+func (r *Receiver) SignReceipt(channelID string, amount int64, target string) (*models.Receipt, error) {
+	rc := models.Receipt{
+		ChannelID:      channelID,
+		Count:          r.State.Count,
+		Amount:         amount,
+		Target:         target,
+		PaymentsHash:   append([]byte(nil), r.State.PaymentsHash[:]...),
+		ReceiverPubKey: r.State.ReceiverPubKey,
+	}
+	sig, err := signAsReceiver(SignRequest{Kind: SignReceipt, Receipt: &rc}, r.signer)
+	if err != nil {
+		return nil, err
+	}
+	rc.Signature = sig.Serialize()
+	return &rc, nil
+}
+
+// VerifyReceipt checks the receipt's signature by its receiver key. Callers
+// must also check that the receiver key is one they trust, e.g. the
+// channel's receiver key.
+func VerifyReceipt(rc models.Receipt) error {
+	pk, err := btcec.ParsePubKey(rc.ReceiverPubKey, btcec.S256())
+	if err != nil {
+		return ErrInvalidReceipt
+	}
+	sig, err := btcec.ParseDERSignature(rc.Signature, btcec.S256())
+	if err != nil {
+		return ErrInvalidReceipt
+	}
+	if !sig.Verify(ReceiptDigest(rc), pk) {
+		return ErrInvalidReceipt
+	}
+	return nil
+}
+
+// CheckReceipt checks that the receipt was signed by the channel's receiver
+// for the sender's current state.
+func (s *Sender) CheckReceipt(rc models.Receipt) error {
+	if !bytes.Equal(rc.ReceiverPubKey, s.State.ReceiverPubKey) ||
+		rc.Count != s.State.Count ||
+		!bytes.Equal(rc.PaymentsHash, s.State.PaymentsHash[:]) {
+		return ErrInvalidReceipt
+	}
+	return VerifyReceipt(rc)
+}
@@ -18,6 +18,16 @@ type ReceiverConfig struct {
 	Net     string
 	Timeout int64
 	FeeRate int64
+
+	// MinPayment and MaxPayment bound the amount of a single payment.
+	// Zero means no bound.
+	MinPayment int64
+	MaxPayment int64
+
+	// AllowRevocable enables the revocable channel variant.
+	AllowRevocable bool
+
+	UpdateMode UpdateMode
 }
 
 var DefaultReceiverConfig = ReceiverConfig{
@@ -27,13 +37,26 @@ var DefaultReceiverConfig = ReceiverConfig{
 }
 
 type Receiver struct {
-	config  ReceiverConfig
-	privKey *btcec.PrivateKey
-	net     *chaincfg.Params
-	State   SharedState
+	config ReceiverConfig
+	signer Signer
+	net    *chaincfg.Params
+	State  SharedState
 }
 
 func NewReceiver(c ReceiverConfig, receiverOutput string, privKey *btcec.PrivateKey) (*Receiver, error) {
+	if privKey == nil {
+		return nil, errors.New("invalid privKey")
+	}
+	return NewReceiverWithSigner(c, receiverOutput, privKey)
+}
+
+// NewReceiverWithSigner is like NewReceiver but signs with signer, which
+// needn't hold the private key itself.
+func NewReceiverWithSigner(c ReceiverConfig, receiverOutput string, signer Signer) (*Receiver, error) {
+	if err := checkUpdateMode(c.UpdateMode); err != nil {
+		return nil, err
+	}
+
 	state := SharedState{
 		Net:    c.Net,
 		Status: StatusCreated,
@@ -43,10 +66,7 @@ func NewReceiver(c ReceiverConfig, receiverOutput string, privKey *btcec.Private
 		return nil, err
 	}
 
-	if privKey == nil {
-		return nil, errors.New("invalid privKey")
-	}
-	pubKey, err := derivePubKey(privKey, net)
+	pubKey, err := derivePubKey(signer, net)
 	if err != nil {
 		return nil, err
 	}
@@ -58,27 +78,35 @@ func NewReceiver(c ReceiverConfig, receiverOutput string, privKey *btcec.Private
 	state.ReceiverOutput = receiverOutput
 
 	return &Receiver{
-		config:  c,
-		privKey: privKey,
-		net:     net,
-		State:   state,
+		config: c,
+		signer: signer,
+		net:    net,
+		State:  state,
 	}, nil
 }
 
 func LoadReceiver(c ReceiverConfig, state SharedState, privKey *btcec.PrivateKey) (*Receiver, error) {
+	if privKey == nil {
+		return nil, errors.New("invalid privKey")
+	}
+	return LoadReceiverWithSigner(c, state, privKey)
+}
+
+// LoadReceiverWithSigner is like LoadReceiver but signs with signer.
+func LoadReceiverWithSigner(c ReceiverConfig, state SharedState, signer Signer) (*Receiver, error) {
 	if c.Net != state.Net {
 		return nil, errors.New("state net differs from config net")
 	}
+	if err := checkUpdateMode(c.UpdateMode); err != nil {
+		return nil, err
+	}
 
 	net, err := state.GetNet()
 	if err != nil {
 		return nil, err
 	}
 
-	if privKey == nil {
-		return nil, errors.New("invalid privKey")
-	}
-	pubKey, err := derivePubKey(privKey, net)
+	pubKey, err := derivePubKey(signer, net)
 	if err != nil {
 		return nil, err
 	}
@@ -92,10 +120,10 @@ func LoadReceiver(c ReceiverConfig, state SharedState, privKey *btcec.PrivateKey
 	}
 
 	return &Receiver{
-		config:  c,
-		privKey: privKey,
-		net:     net,
-		State:   state,
+		config: c,
+		signer: signer,
+		net:    net,
+		State:  state,
 	}, nil
 }
 
@@ -116,13 +144,23 @@ func (r *Receiver) Create(req *models.CreateRequest) (*models.CreateResponse, er
 	if _, err := btcutil.NewAddressPubKey(req.SenderPubKey, r.net); err != nil {
 		return nil, errors.New("invalid senderPubKey")
 	}
+	if err := validateAppData(req.AppData); err != nil {
+		return nil, err
+	}
+	if req.Revocable && !r.config.AllowRevocable {
+		return nil, errors.New("revocable channels not supported")
+	}
 
 	s := r.State
 	s.Version = Version
 	s.Timeout = r.config.Timeout
 	s.Fee = r.config.FeeRate * typicalCloseTxSize
+	s.MinPayment = r.config.MinPayment
+	s.MaxPayment = r.config.MaxPayment
 	s.SenderOutput = req.SenderOutput
 	s.SenderPubKey = req.SenderPubKey
+	s.AppData = req.AppData
+	s.Revocable = req.Revocable
 
 	_, fundingAddr, err := s.GetFundingScript()
 	if err != nil {
@@ -136,9 +174,13 @@ func (r *Receiver) Create(req *models.CreateRequest) (*models.CreateResponse, er
 		Net:            s.Net,
 		Timeout:        s.Timeout,
 		Fee:            s.Fee,
+		MinPayment:     s.MinPayment,
+		MaxPayment:     s.MaxPayment,
 		ReceiverPubKey: s.ReceiverPubKey,
 		ReceiverOutput: s.ReceiverOutput,
 		FundingAddress: fundingAddr,
+		AppData:        s.AppData,
+		Revocable:      s.Revocable,
 	}, nil
 }
 
@@ -166,22 +208,42 @@ func (r *Receiver) Open(txout *wire.TxOut, req *models.OpenRequest) (*models.Ope
 	if req.ReceiverOutput != r.State.ReceiverOutput {
 		return nil, errors.New("wrong receiverOutput")
 	}
+	if err := validateAppData(req.AppData); err != nil {
+		return nil, err
+	}
+	if req.MinPayment != r.config.MinPayment || req.MaxPayment != r.config.MaxPayment {
+		return nil, errors.New("wrong payment limits")
+	}
+	if req.Revocable && !r.config.AllowRevocable {
+		return nil, errors.New("revocable channels not supported")
+	}
+	if req.Revocable && len(req.RevocationHash) != 20 {
+		return nil, errors.New("invalid revocationHash")
+	}
 
 	s := SharedState{
 		Version:        req.Version,
 		Net:            req.Net,
 		Timeout:        req.Timeout,
 		Fee:            req.Fee,
+		MinPayment:     req.MinPayment,
+		MaxPayment:     req.MaxPayment,
+		Revocable:      req.Revocable,
 		Status:         StatusOpen,
 		SenderPubKey:   req.SenderPubKey,
 		ReceiverPubKey: req.ReceiverPubKey,
 		SenderOutput:   req.SenderOutput,
 		ReceiverOutput: req.ReceiverOutput,
+		AppData:        req.AppData,
 		FundingTxID:    req.TxID,
 		FundingVout:    req.Vout,
 		Capacity:       txout.Value,
+		PaymentsHash:   initialPaymentsHash(req.AppData),
 		SenderSig:      req.SenderSig,
 	}
+	if s.Revocable {
+		s.RevocationHash = req.RevocationHash
+	}
 
 	// Make sure txout.PkScript matches the funding address.
 	script, _, err := s.GetFundingScript()
@@ -200,10 +262,18 @@ func (r *Receiver) Open(txout *wire.TxOut, req *models.OpenRequest) (*models.Ope
 		return nil, errors.New("mismatched funding address")
 	}
 
-	if err := validateSenderSig(s, r.privKey); err != nil {
+	if err := validateSenderSig(s, r.signer); err != nil {
 		return nil, err
 	}
 
+	if s.Revocable {
+		sig, err := s.signCommitment(s.Balance, s.PaymentsHash, s.RevocationHash, r.signer)
+		if err != nil {
+			return nil, err
+		}
+		s.CommitmentSig = sig
+	}
+
 	minFee := r.config.FeeRate * typicalCloseTxSize
 
 	acceptable := s.Version == Version &&
@@ -216,38 +286,85 @@ func (r *Receiver) Open(txout *wire.TxOut, req *models.OpenRequest) (*models.Ope
 
 	r.State = s
 
-	return &models.OpenResponse{}, nil
+	return &models.OpenResponse{CommitmentSig: s.CommitmentSig}, nil
 }
 
 func (r *Receiver) Validate(amount int64, payment []byte) (bool, error) {
-	if r.State.Status != StatusOpen {
-		return false, ErrNotStatusOpen
+	return validatePayment(r.State, amount, payment)
+}
+
+// ValidateCapture is like Validate for a payment captured from a hold.
+func (r *Receiver) ValidateCapture(holdID string, amount int64, payment []byte) (bool, error) {
+	if err := r.CheckCapture(holdID, amount, payment); err == ErrNotStatusOpen {
+		return false, err
+	} else if err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// CheckPayment is like Validate but returns why the payment is invalid:
+// ErrNotStatusOpen, ErrInvalidPayment or one of the errors of the amount
+// checks, such as ErrInsufficientCapacity.
+func (r *Receiver) CheckPayment(amount int64, payment []byte) error {
+	return checkPayment(r.State, amount, payment)
+}
+
+// CheckCapture is like CheckPayment for a payment captured from a hold. It
+// also returns ErrHoldNotFound and ErrCaptureExceedsHold.
+func (r *Receiver) CheckCapture(holdID string, amount int64, payment []byte) error {
+	s := r.State
+	if !s.Status.IsOpen() {
+		return ErrNotStatusOpen
+	}
+	if err := s.captureHold(holdID, amount); err != nil {
+		return err
 	}
+	return checkPayment(s, amount, payment)
+}
 
-	if _, err := r.State.validateAmount(amount); err != nil {
+func validatePayment(s SharedState, amount int64, payment []byte) (bool, error) {
+	if err := checkPayment(s, amount, payment); err == ErrNotStatusOpen {
+		return false, err
+	} else if err != nil {
 		return false, nil
 	}
+	return true, nil
+}
+
+func checkPayment(s SharedState, amount int64, payment []byte) error {
+	if !s.Status.IsOpen() {
+		return ErrNotStatusOpen
+	}
+
+	if _, err := s.validateAmount(amount); err != nil {
+		return err
+	}
 
 	if !validatePaymentSize(len(payment)) {
-		return false, nil
+		return ErrInvalidPayment
 	}
 
-	return true, nil
+	return nil
 }
 
 func (r *Receiver) Send(amount int64, req *models.SendRequest) (*models.SendResponse, error) {
-	if r.State.Status != StatusOpen {
+	if !r.State.Status.IsOpen() {
 		return nil, ErrNotStatusOpen
 	}
-	valid, err := r.Validate(amount, req.Payment)
-	if err != nil {
-		return nil, err
+
+	s := r.State
+	if req.HoldID != "" {
+		if err := s.captureHold(req.HoldID, amount); err != nil {
+			return nil, err
+		}
 	}
-	if !valid {
-		return nil, errors.New("invalid payment")
+
+	if err := checkPayment(s, amount, req.Payment); err != nil {
+		return nil, err
 	}
 
-	newBalance, err := r.State.validateAmount(amount)
+	newBalance, err := s.validateAmount(amount)
 	if err != nil {
 		return nil, err
 	}
@@ -257,20 +374,108 @@ func (r *Receiver) Send(amount int64, req *models.SendRequest) (*models.SendResp
 	if err := r.validateSenderSig(newBalance, newHash, req.SenderSig); err != nil {
 		return nil, err
 	}
+	feeSigs, err := r.validateFeeSigs(newBalance, newHash, req.FeeSigs)
+	if err != nil {
+		return nil, err
+	}
 
+	var commitmentSig []byte
+	if r.State.Revocable {
+		if err := r.State.checkRevocation(req.RevocationSecret); err != nil {
+			return nil, err
+		}
+		commitmentSig, err = r.State.signCommitment(
+			newBalance, newHash, req.RevocationHash, r.signer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.State.Holds = s.Holds
 	r.State.Count++
 	r.State.Balance = newBalance
 	r.State.PaymentsHash = newHash
 	r.State.SenderSig = req.SenderSig
-	return &models.SendResponse{}, nil
+	r.State.FeeSigs = feeSigs
+	if r.State.Revocable {
+		r.State.PrevRevocationHash = r.State.RevocationHash
+		r.State.RevocationHash = req.RevocationHash
+		r.State.CommitmentSig = commitmentSig
+	}
+	return &models.SendResponse{CommitmentSig: commitmentSig}, nil
+}
+
+// Replay applies a payment accepted earlier, e.g. to rebuild a channel's
+// state after the receiver's database was lost. The sender's signatures are
+// checked as in Send, but holds aren't, since they aren't recovered, and
+// neither is the revocation secret.
+func (r *Receiver) Replay(amount int64, req *models.SendRequest) error {
+	newBalance, err := r.State.validateAmount(amount)
+	if err != nil {
+		return err
+	}
+	newHash := chainHash(r.State.PaymentsHash, req.Payment)
+
+	if err := r.validateSenderSig(newBalance, newHash, req.SenderSig); err != nil {
+		return err
+	}
+	feeSigs, err := r.validateFeeSigs(newBalance, newHash, req.FeeSigs)
+	if err != nil {
+		return err
+	}
+
+	var commitmentSig []byte
+	if r.State.Revocable {
+		commitmentSig, err = r.State.signCommitment(
+			newBalance, newHash, req.RevocationHash, r.signer)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.State.Count++
+	r.State.Balance = newBalance
+	r.State.PaymentsHash = newHash
+	r.State.SenderSig = req.SenderSig
+	r.State.FeeSigs = feeSigs
+	if r.State.Revocable {
+		r.State.PrevRevocationHash = r.State.RevocationHash
+		r.State.RevocationHash = req.RevocationHash
+		r.State.CommitmentSig = commitmentSig
+	}
+	return nil
+}
+
+// Hold reserves channel capacity for a later capture.
+func (r *Receiver) Hold(req *models.HoldRequest) (*models.HoldResponse, error) {
+	if !r.State.Status.IsOpen() {
+		return nil, ErrNotStatusOpen
+	}
+	if err := r.State.addHold(req.HoldID, req.Amount); err != nil {
+		return nil, err
+	}
+	return &models.HoldResponse{}, nil
+}
+
+// Release releases a hold without capturing it.
+func (r *Receiver) Release(req *models.ReleaseRequest) (*models.ReleaseResponse, error) {
+	if !r.State.Status.IsOpen() {
+		return nil, ErrNotStatusOpen
+	}
+	if _, err := r.State.removeHold(req.HoldID); err != nil {
+		return nil, err
+	}
+	return &models.ReleaseResponse{}, nil
 }
 
 func (r *Receiver) Close(req *models.CloseRequest) (*models.CloseResponse, error) {
-	if r.State.Status != StatusOpen && r.State.Status != StatusClosing {
+	if !r.State.Status.IsOpen() && r.State.Status != StatusClosing {
 		return nil, ErrNotStatusOpen
 	}
 
-	rawTx, err := r.State.GetClosureTxSigned(r.State.Balance, r.State.PaymentsHash, r.State.SenderSig, r.privKey)
+	fee, senderSig := r.State.closeSig(req.FeeRate)
+	ss := r.State.withFee(fee)
+	rawTx, err := ss.GetClosureTxSigned(r.State.Balance, r.State.PaymentsHash, senderSig, r.signer)
 	if err != nil {
 		return nil, err
 	}
@@ -279,6 +484,7 @@ func (r *Receiver) Close(req *models.CloseRequest) (*models.CloseResponse, error
 
 	return &models.CloseResponse{
 		CloseTx: rawTx,
+		Fee:     fee,
 	}, nil
 }
 
@@ -287,9 +493,23 @@ func (r *Receiver) Status(req *models.StatusRequest) (*models.StatusResponse, er
 		Status:       int(r.State.Status),
 		Balance:      r.State.Balance,
 		PaymentsHash: r.State.PaymentsHash[:],
+		AppData:      r.State.AppData,
+		Held:         r.State.Held(),
+
+		CommitmentSig: r.State.CommitmentSig,
 	}, nil
 }
 
+// Confirmed marks a zero-conf channel as open once its funding transaction
+// has confirmed.
+func (r *Receiver) Confirmed() error {
+	if r.State.Status != StatusOpenUnconfirmed {
+		return errors.New("channel is not unconfirmed")
+	}
+	r.State.Status = StatusOpen
+	return nil
+}
+
 func (r *Receiver) CloseMined() error {
 	if r.State.Status != StatusClosing {
 		return ErrNotStatusClosing
@@ -303,5 +523,5 @@ func (r *Receiver) validateSenderSig(balance int64, hash [32]byte, senderSig []b
 	ss.Balance = balance
 	ss.PaymentsHash = hash
 	ss.SenderSig = senderSig
-	return validateSenderSig(ss, r.privKey)
+	return validateSenderSig(ss, r.signer)
 }
@@ -0,0 +1,82 @@
+package channels
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+var ErrUnknownBlockHeight = errors.New("funding block height is unknown")
+
+// RefundHeight returns the height of the first block that can include the
+// refund transaction. The refund input's relative lock time is counted from
+// the block that confirmed the funding transaction.
+func (ss *SharedState) RefundHeight() (int64, error) {
+	if ss.BlockHeight <= 0 {
+		return 0, ErrUnknownBlockHeight
+	}
+	return int64(ss.BlockHeight) + ss.Timeout, nil
+}
+
+// RefundMaturity reports how many more blocks must be mined after the block
+// at currentHeight before the refund transaction can be broadcast. It returns
+// zero if the refund transaction can be broadcast now.
+func (ss *SharedState) RefundMaturity(currentHeight int64) (int64, error) {
+	h, err := ss.RefundHeight()
+	if err != nil {
+		return 0, err
+	}
+	// The refund can be relayed once it's valid in the next block.
+	remaining := h - (currentHeight + 1)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, nil
+}
+
+// RefundChain is the subset of the bitcoind RPC client needed to broadcast a
+// refund transaction.
+type RefundChain interface {
+	GetBlockCount() (int64, error)
+	SendRawTransaction(tx *wire.MsgTx, allowHighFees bool) (*chainhash.Hash, error)
+}
+
+// RefundPollInterval is how often WaitForRefund checks the block height.
+var RefundPollInterval = time.Minute
+
+// WaitForRefund blocks until the refund transaction can be broadcast and then
+// broadcasts it. The funding block height must be set in the state.
+func (s *Sender) WaitForRefund(bc RefundChain) (*chainhash.Hash, error) {
+	if _, err := s.State.RefundHeight(); err != nil {
+		return nil, err
+	}
+
+	rawTx, err := s.Refund()
+	if err != nil {
+		return nil, err
+	}
+	var tx wire.MsgTx
+	if err := tx.BtcDecode(bytes.NewReader(rawTx), 2); err != nil {
+		return nil, err
+	}
+
+	for {
+		height, err := bc.GetBlockCount()
+		if err != nil {
+			return nil, err
+		}
+		remaining, err := s.State.RefundMaturity(height)
+		if err != nil {
+			return nil, err
+		}
+		if remaining == 0 {
+			break
+		}
+		time.Sleep(RefundPollInterval)
+	}
+
+	return bc.SendRawTransaction(&tx, false)
+}
@@ -0,0 +1,53 @@
+package channels
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+var ErrInvalidRequestSig = errors.New("invalid request signature")
+
+// RequestDigest returns the hash signed by the sender to authenticate an RPC
+// call. It commits to the channel ID, the call, a nonce chosen by the sender
+// and the SHA-256 hash of the request body.
+func RequestDigest(channelID, method, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+
+	var buf []byte
+	for _, s := range []string{channelID, method, nonce} {
+		buf = append(buf, s...)
+		buf = append(buf, 0)
+	}
+	buf = append(buf, bodyHash[:]...)
+
+	return chainhash.DoubleHashB(buf)
+}
+
+// SignRequest signs an RPC call with the sender's channel key.
+func (s *Sender) SignRequest(channelID, method, nonce string, body []byte) ([]byte, error) {
+	sig, err := s.privKey.Sign(RequestDigest(channelID, method, nonce, body))
+	if err != nil {
+		return nil, err
+	}
+	return sig.Serialize(), nil
+}
+
+// VerifyRequest checks that sig is the signature of an RPC call by the
+// holder of pubKey.
+func VerifyRequest(pubKey []byte, channelID, method, nonce string, body, sig []byte) error {
+	pk, err := btcec.ParsePubKey(pubKey, btcec.S256())
+	if err != nil {
+		return ErrInvalidRequestSig
+	}
+	s, err := btcec.ParseDERSignature(sig, btcec.S256())
+	if err != nil {
+		return ErrInvalidRequestSig
+	}
+	if !s.Verify(RequestDigest(channelID, method, nonce, body), pk) {
+		return ErrInvalidRequestSig
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package channels
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// Revocable channels are an optional variant where the sender holds a
+// commitment transaction for the latest state, co-signed by the receiver.
+// The sender can broadcast it at any time to close the channel without
+// waiting for the refund timeout. The sender's output of each commitment
+// transaction can only be spent by the sender after revocationDelay blocks,
+// or immediately by the receiver if it knows the revocation secret for that
+// state. The sender reveals the secret of each state once it has been
+// superseded, so broadcasting an old commitment forfeits the sender's output.
+
+// revocationDelay is the number of blocks that the sender must wait before
+// spending its output of a commitment transaction.
+const revocationDelay = 144
+
+var ErrNotRevocable = errors.New("channel is not revocable")
+var ErrInvalidRevocationSecret = errors.New("invalid revocation secret")
+
+// RevocationSecret derives the sender's revocation secret for the state with
+// the given payment count. Deriving it from the sender's key means the sender
+// doesn't have to store its secrets.
+func RevocationSecret(privKey *btcec.PrivateKey, count int) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(count))
+
+	mac := hmac.New(sha256.New, privKey.Serialize())
+	mac.Write([]byte("moonbeam revocation"))
+	mac.Write(buf[:])
+	return mac.Sum(nil)
+}
+
+// RevocationHash returns the hash of a revocation secret as committed to in
+// the commitment transaction.
+func RevocationHash(secret []byte) []byte {
+	return btcutil.Hash160(secret)
+}
+
+func revocableOutputScript(revocationHash []byte, senderPubKey, receiverPubKey *btcutil.AddressPubKey) ([]byte, error) {
+	b := txscript.NewScriptBuilder()
+	b.AddOp(txscript.OP_IF)
+	b.AddOp(txscript.OP_HASH160)
+	b.AddData(revocationHash)
+	b.AddOp(txscript.OP_EQUALVERIFY)
+	b.AddData(receiverPubKey.ScriptAddress())
+	b.AddOp(txscript.OP_ELSE)
+	b.AddInt64(revocationDelay)
+	b.AddOp(txscript.OP_CHECKSEQUENCEVERIFY)
+	b.AddOp(txscript.OP_DROP)
+	b.AddData(senderPubKey.ScriptAddress())
+	b.AddOp(txscript.OP_ENDIF)
+	b.AddOp(txscript.OP_CHECKSIG)
+	return b.Script()
+}
+
+func (s *SharedState) getRevocableOutputScript(revocationHash []byte) ([]byte, error) {
+	if len(revocationHash) != 20 {
+		return nil, errors.New("invalid revocation hash")
+	}
+	senderPubKey, err := s.SenderAddressPubKey()
+	if err != nil {
+		return nil, err
+	}
+	receiverPubKey, err := s.ReceiverAddressPubKey()
+	if err != nil {
+		return nil, err
+	}
+	return revocableOutputScript(revocationHash, senderPubKey, receiverPubKey)
+}
+
+// GetCommitmentTx returns the unsigned commitment transaction for the given
+// state. It is the same as the closure transaction except that the sender's
+// output is revocable.
+func (s *SharedState) GetCommitmentTx(balance int64, hash [32]byte, revocationHash []byte) (*wire.MsgTx, error) {
+	net, err := s.GetNet()
+	if err != nil {
+		return nil, err
+	}
+
+	script, err := s.getRevocableOutputScript(revocationHash)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := btcutil.NewAddressScriptHash(script, net)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.spendFundingTx()
+	if err != nil {
+		return nil, err
+	}
+
+	dataout, err := getDataOutput(byte(s.Version), hash)
+	if err != nil {
+		return nil, err
+	}
+	tx.AddTxOut(dataout)
+
+	receiveAmount := balance
+	senderAmount := s.Capacity - balance - s.Fee
+
+	if receiveAmount >= dustThreshold {
+		txout, err := sendToAddress(net, receiveAmount, s.ReceiverOutput)
+		if err != nil {
+			return nil, err
+		}
+		tx.AddTxOut(txout)
+	}
+
+	if senderAmount >= dustThreshold {
+		txout, err := sendToAddress(net, senderAmount, addr.String())
+		if err != nil {
+			return nil, err
+		}
+		tx.AddTxOut(txout)
+	}
+
+	return tx, nil
+}
+
+func (s *SharedState) signFunding(tx *wire.MsgTx, signer Signer) ([]byte, error) {
+	script, _, err := s.GetFundingScript()
+	if err != nil {
+		return nil, err
+	}
+	return signTxIn(tx, 0, script, signer)
+}
+
+// signMultisig sets the input script of tx to spend the funding output with
+// both signatures.
+func (s *SharedState) signMultisig(tx *wire.MsgTx, senderSig, receiverSig []byte) ([]byte, error) {
+	script, _, err := s.GetFundingScript()
+	if err != nil {
+		return nil, err
+	}
+
+	b := txscript.NewScriptBuilder()
+	b.AddOp(txscript.OP_FALSE)
+	b.AddData(senderSig)
+	b.AddData(receiverSig)
+	b.AddOp(txscript.OP_TRUE)
+	b.AddData(script)
+	finalScript, err := b.Script()
+	if err != nil {
+		return nil, err
+	}
+	tx.TxIn[0].SignatureScript = finalScript
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// signCommitment returns the receiver's signature for the sender's
+// commitment transaction.
+func (s *SharedState) signCommitment(balance int64, hash [32]byte, revocationHash []byte, signer Signer) ([]byte, error) {
+	tx, err := s.GetCommitmentTx(balance, hash, revocationHash)
+	if err != nil {
+		return nil, err
+	}
+	return signTxAsReceiver(SignRequest{
+		Kind:           SignCommitment,
+		Tx:             tx,
+		SenderPubKey:   s.SenderPubKey,
+		Timeout:        s.Timeout,
+		RevocationHash: revocationHash,
+	}, signer)
+}
+
+// GetCommitmentTxSigned returns the fully signed commitment transaction for
+// the latest state. It is called by the sender.
+func (s *SharedState) GetCommitmentTxSigned(privKey *btcec.PrivateKey) ([]byte, error) {
+	if !s.Revocable {
+		return nil, ErrNotRevocable
+	}
+	if len(s.CommitmentSig) == 0 {
+		return nil, errors.New("missing commitment signature")
+	}
+
+	tx, err := s.GetCommitmentTx(s.Balance, s.PaymentsHash, s.RevocationHash)
+	if err != nil {
+		return nil, err
+	}
+	senderSig, err := s.signFunding(tx, privKey)
+	if err != nil {
+		return nil, err
+	}
+	return s.signMultisig(tx, senderSig, s.CommitmentSig)
+}
+
+// validateCommitmentSig checks the receiver's signature for the sender's
+// commitment transaction of the given state.
+func (s *SharedState) validateCommitmentSig(balance int64, hash [32]byte, revocationHash []byte, commitmentSig []byte, privKey *btcec.PrivateKey) error {
+	tx, err := s.GetCommitmentTx(balance, hash, revocationHash)
+	if err != nil {
+		return err
+	}
+	senderSig, err := s.signFunding(tx, privKey)
+	if err != nil {
+		return err
+	}
+	rawTx, err := s.signMultisig(tx, senderSig, commitmentSig)
+	if err != nil {
+		return err
+	}
+	return s.validateTx(rawTx)
+}
+
+// findRevocableOutput returns the index of the output of a commitment tx
+// that pays to the revocable script.
+func findRevocableOutput(tx *wire.MsgTx, addr *btcutil.AddressScriptHash) (int, error) {
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return 0, err
+	}
+	for i, txout := range tx.TxOut {
+		if bytes.Equal(txout.PkScript, pkScript) {
+			return i, nil
+		}
+	}
+	return 0, errors.New("commitment tx has no revocable output")
+}
+
+func (s *SharedState) spendRevocableOutput(commitTx *wire.MsgTx, revocationHash []byte, sequence uint32, dest string) (*wire.MsgTx, []byte, error) {
+	net, err := s.GetNet()
+	if err != nil {
+		return nil, nil, err
+	}
+	script, err := s.getRevocableOutputScript(revocationHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	addr, err := btcutil.NewAddressScriptHash(script, net)
+	if err != nil {
+		return nil, nil, err
+	}
+	vout, err := findRevocableOutput(commitTx, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	value := commitTx.TxOut[vout].Value - s.Fee
+	if value < dustThreshold {
+		return nil, nil, errors.New("revocable output too small to spend")
+	}
+	txout, err := sendToAddress(net, value, dest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: commitTx.TxHash(), Index: uint32(vout)},
+		Sequence:         sequence,
+	})
+	tx.AddTxOut(txout)
+
+	return tx, script, nil
+}
+
+// GetPenaltyTx returns a transaction that sweeps the sender's output of a
+// revoked commitment transaction to the receiver. It is called by the
+// receiver with the revealed revocation secret for that state.
+func (s *SharedState) GetPenaltyTx(commitTx *wire.MsgTx, secret []byte, signer Signer) ([]byte, error) {
+	revocationHash := RevocationHash(secret)
+	tx, script, err := s.spendRevocableOutput(
+		commitTx, revocationHash, wire.MaxTxInSequenceNum, s.ReceiverOutput)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := signTxAsReceiver(SignRequest{
+		Kind:           SignPenalty,
+		Tx:             tx,
+		SenderPubKey:   s.SenderPubKey,
+		RevocationHash: revocationHash,
+	}, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	b := txscript.NewScriptBuilder()
+	b.AddData(sig)
+	b.AddData(secret)
+	b.AddOp(txscript.OP_TRUE)
+	b.AddData(script)
+	sigScript, err := b.Script()
+	if err != nil {
+		return nil, err
+	}
+	tx.TxIn[0].SignatureScript = sigScript
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GetCommitmentSweepTx returns a transaction that spends the sender's output
+// of its commitment transaction after the revocation delay.
+func (s *SharedState) GetCommitmentSweepTx(commitTx *wire.MsgTx, privKey *btcec.PrivateKey) ([]byte, error) {
+	tx, script, err := s.spendRevocableOutput(
+		commitTx, s.RevocationHash, revocationDelay, s.SenderOutput)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := txscript.RawTxInSignature(tx, 0, script, txscript.SigHashAll, privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	b := txscript.NewScriptBuilder()
+	b.AddData(sig)
+	b.AddOp(txscript.OP_FALSE)
+	b.AddData(script)
+	sigScript, err := b.Script()
+	if err != nil {
+		return nil, err
+	}
+	tx.TxIn[0].SignatureScript = sigScript
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// checkRevocation checks a revealed secret against the revocation hash of
+// the state before the current one.
+func (s *SharedState) checkRevocation(secret []byte) error {
+	if len(s.PrevRevocationHash) == 0 {
+		if len(secret) != 0 {
+			return ErrInvalidRevocationSecret
+		}
+		return nil
+	}
+	if !bytes.Equal(RevocationHash(secret), s.PrevRevocationHash) {
+		return ErrInvalidRevocationSecret
+	}
+	return nil
+}
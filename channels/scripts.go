@@ -154,7 +154,7 @@ func (s *SharedState) GetClosureTx(balance int64, hash [32]byte) (*wire.MsgTx, e
 	return tx, nil
 }
 
-func (s *SharedState) GetClosureTxSigned(balance int64, hash [32]byte, senderSig []byte, privKey *btcec.PrivateKey) ([]byte, error) {
+func (s *SharedState) GetClosureTxSigned(balance int64, hash [32]byte, senderSig []byte, signer Signer) ([]byte, error) {
 	tx, err := s.GetClosureTx(balance, hash)
 	if err != nil {
 		return nil, err
@@ -165,8 +165,12 @@ func (s *SharedState) GetClosureTxSigned(balance int64, hash [32]byte, senderSig
 		return nil, err
 	}
 
-	receiverSig, err := txscript.RawTxInSignature(
-		tx, 0, script, txscript.SigHashAll, privKey)
+	receiverSig, err := signTxAsReceiver(SignRequest{
+		Kind:         SignClosure,
+		Tx:           tx,
+		SenderPubKey: s.SenderPubKey,
+		Timeout:      s.Timeout,
+	}, signer)
 	if err != nil {
 		return nil, err
 	}
@@ -321,10 +325,101 @@ func (s *SharedState) validateTx(rawTx []byte) error {
 	return nil
 }
 
-func validateSenderSig(ss SharedState, privKey *btcec.PrivateKey) error {
-	rawTx, err := ss.GetClosureTxSigned(ss.Balance, ss.PaymentsHash, ss.SenderSig, privKey)
+// closureTxState recovers the balance and paymentsHash from the outputs of a
+// closure transaction. It checks that the outputs are exactly those of the
+// closure transaction for that state: the right destination scripts, the right
+// amounts and a fee equal to the channel fee (plus any omitted dust outputs).
+func (s *SharedState) closureTxState(tx *wire.MsgTx) (int64, [32]byte, error) {
+	var hash [32]byte
+
+	net, err := s.GetNet()
+	if err != nil {
+		return 0, hash, err
+	}
+
+	if len(tx.TxOut) == 0 {
+		return 0, hash, errors.New("missing data output")
+	}
+	pushes, err := txscript.PushedData(tx.TxOut[0].PkScript)
+	if err != nil {
+		return 0, hash, err
+	}
+	if txscript.GetScriptClass(tx.TxOut[0].PkScript) != txscript.NullDataTy ||
+		len(pushes) != 1 || len(pushes[0]) != 1+len(hash) {
+		return 0, hash, errors.New("invalid data output")
+	}
+	if pushes[0][0] != byte(s.Version) {
+		return 0, hash, errors.New("wrong version in data output")
+	}
+	copy(hash[:], pushes[0][1:])
+
+	receiverOut, err := sendToAddress(net, 0, s.ReceiverOutput)
+	if err != nil {
+		return 0, hash, err
+	}
+	senderOut, err := sendToAddress(net, 0, s.SenderOutput)
+	if err != nil {
+		return 0, hash, err
+	}
+
+	// The receiver output comes before the sender output. Either one may have
+	// been omitted if its amount was below the dust threshold.
+	var balance int64
+	outs := tx.TxOut[1:]
+	if len(outs) > 0 && bytes.Equal(outs[0].PkScript, receiverOut.PkScript) {
+		balance = outs[0].Value
+	} else if len(outs) > 0 && bytes.Equal(outs[0].PkScript, senderOut.PkScript) {
+		balance = s.Capacity - s.Fee - outs[0].Value
+	} else if len(outs) > 0 {
+		return 0, hash, errors.New("unexpected closure tx output")
+	}
+	if balance < 0 || (balance > 0 && balance+s.Fee > s.Capacity) {
+		return 0, hash, errors.New("invalid closure tx balance")
+	}
+
+	expected, err := s.GetClosureTx(balance, hash)
+	if err != nil {
+		return 0, hash, err
+	}
+	if len(expected.TxOut) != len(tx.TxOut) {
+		return 0, hash, errors.New("unexpected closure tx outputs")
+	}
+	for i, txout := range tx.TxOut {
+		exp := expected.TxOut[i]
+		if txout.Value != exp.Value || !bytes.Equal(txout.PkScript, exp.PkScript) {
+			return 0, hash, errors.New("unexpected closure tx outputs")
+		}
+	}
+
+	return balance, hash, nil
+}
+
+// validateClosureTx validates a signed closure transaction and returns the
+// balance and paymentsHash that it settles.
+func (s *SharedState) validateClosureTx(rawTx []byte) (int64, [32]byte, error) {
+	if err := s.validateTx(rawTx); err != nil {
+		return 0, [32]byte{}, err
+	}
+
+	var tx wire.MsgTx
+	if err := tx.BtcDecode(bytes.NewReader(rawTx), 2); err != nil {
+		return 0, [32]byte{}, err
+	}
+
+	return s.closureTxState(&tx)
+}
+
+// validateSenderSig returns ErrInvalidSenderSig if the sender's signature
+// of the closure transaction is invalid, or the signer's error if signing
+// it failed. The transaction is built from ss itself, so only its scripts
+// need to be executed.
+func validateSenderSig(ss SharedState, signer Signer) error {
+	rawTx, err := ss.GetClosureTxSigned(ss.Balance, ss.PaymentsHash, ss.SenderSig, signer)
 	if err != nil {
 		return err
 	}
-	return ss.validateTx(rawTx)
+	if err := ss.validateTx(rawTx); err != nil {
+		return ErrInvalidSenderSig
+	}
+	return nil
 }
@@ -2,12 +2,15 @@ package channels
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 
 	"github.com/luno/moonbeam/models"
@@ -21,6 +24,13 @@ type SenderConfig struct {
 
 	MinFeeRate int64
 	MaxFeeRate int64
+
+	// FeeTiers are fee rates at which the sender also signs the closure
+	// transaction of each payment, so that the receiver can settle faster
+	// when fees rise. The extra fee is paid by the sender.
+	FeeTiers []int64
+
+	UpdateMode UpdateMode
 }
 
 var DefaultSenderConfig = SenderConfig{
@@ -36,9 +46,28 @@ type Sender struct {
 	privKey *btcec.PrivateKey
 	net     *chaincfg.Params
 	State   SharedState
+
+	// Pending is the payment prepared by PrepareSend that hasn't been
+	// confirmed or aborted yet. It should be persisted with State.
+	Pending *PendingSend
+}
+
+// PendingSend is a prepared payment together with the exact request that was
+// generated for it.
+type PendingSend struct {
+	Amount  int64
+	Payment []byte
+	Request models.SendRequest
 }
 
+var ErrSendPending = errors.New("another payment is pending")
+var ErrNoPendingSend = errors.New("no pending payment")
+
 func NewSender(c SenderConfig, privKey *btcec.PrivateKey) (*Sender, error) {
+	if err := checkUpdateMode(c.UpdateMode); err != nil {
+		return nil, err
+	}
+
 	state := SharedState{
 		Net:    c.Net,
 		Status: StatusCreated,
@@ -71,6 +100,9 @@ func LoadSender(c SenderConfig, state SharedState, privKey *btcec.PrivateKey) (*
 	if c.Net != state.Net {
 		return nil, errors.New("state net differs from config net")
 	}
+	if err := checkUpdateMode(c.UpdateMode); err != nil {
+		return nil, err
+	}
 
 	net, err := state.GetNet()
 	if err != nil {
@@ -101,6 +133,30 @@ func LoadSender(c SenderConfig, state SharedState, privKey *btcec.PrivateKey) (*
 	}, nil
 }
 
+// SetAppData sets opaque application data to be bound to the channel. It must
+// be called before GetCreateRequest.
+func (s *Sender) SetAppData(appData []byte) error {
+	if s.State.Status != StatusCreated {
+		return ErrNotStatusCreated
+	}
+	if err := validateAppData(appData); err != nil {
+		return err
+	}
+	s.State.AppData = appData
+	s.State.PaymentsHash = initialPaymentsHash(appData)
+	return nil
+}
+
+// SetRevocable requests a revocable channel. It must be called before
+// GetCreateRequest.
+func (s *Sender) SetRevocable() error {
+	if s.State.Status != StatusCreated {
+		return ErrNotStatusCreated
+	}
+	s.State.Revocable = true
+	return nil
+}
+
 func (s *Sender) GetCreateRequest(outputAddr string) (*models.CreateRequest, error) {
 	if s.State.Status != StatusCreated {
 		return nil, ErrNotStatusCreated
@@ -117,6 +173,8 @@ func (s *Sender) GetCreateRequest(outputAddr string) (*models.CreateRequest, err
 		Net:          s.State.Net,
 		SenderPubKey: s.State.SenderPubKey,
 		SenderOutput: s.State.SenderOutput,
+		AppData:      s.State.AppData,
+		Revocable:    s.State.Revocable,
 	}, nil
 }
 
@@ -152,11 +210,22 @@ func (s *Sender) GotCreateResponse(resp *models.CreateResponse) error {
 	if _, err := btcutil.NewAddressPubKey(resp.ReceiverPubKey, s.net); err != nil {
 		return errors.New("invalid receiverPubKey")
 	}
+	if !bytes.Equal(resp.AppData, s.State.AppData) {
+		return errors.New("app data mismatch")
+	}
+	if err := validatePaymentLimits(resp.MinPayment, resp.MaxPayment); err != nil {
+		return err
+	}
+	if resp.Revocable != s.State.Revocable {
+		return errors.New("revocable mismatch")
+	}
 
 	newState := s.State
 	newState.Version = resp.Version
 	newState.Timeout = resp.Timeout
 	newState.Fee = resp.Fee
+	newState.MinPayment = resp.MinPayment
+	newState.MaxPayment = resp.MaxPayment
 	newState.ReceiverPubKey = resp.ReceiverPubKey
 	newState.ReceiverOutput = resp.ReceiverOutput
 
@@ -177,7 +246,11 @@ func (s *Sender) signBalance(balance int64, hash [32]byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	return s.signTx(tx)
+}
 
+// signTx signs a transaction spending the funding output.
+func (s *Sender) signTx(tx *wire.MsgTx) ([]byte, error) {
 	script, _, err := s.State.GetFundingScript()
 	if err != nil {
 		return nil, err
@@ -208,18 +281,30 @@ func (s *Sender) GetOpenRequest(txid string, vout uint32, amount int64) (*models
 		return nil, err
 	}
 
+	if s.State.Revocable {
+		s.State.RevocationHash = RevocationHash(RevocationSecret(s.privKey, 0))
+	}
+
 	return &models.OpenRequest{
 		Version: s.State.Version,
 		Net:     s.State.Net,
 		Timeout: s.State.Timeout,
 		Fee:     s.State.Fee,
 
+		MinPayment: s.State.MinPayment,
+		MaxPayment: s.State.MaxPayment,
+
 		SenderPubKey: s.State.SenderPubKey,
 		SenderOutput: s.State.SenderOutput,
 
 		ReceiverPubKey: s.State.ReceiverPubKey,
 		ReceiverOutput: s.State.ReceiverOutput,
 
+		AppData: s.State.AppData,
+
+		Revocable:      s.State.Revocable,
+		RevocationHash: s.State.RevocationHash,
+
 		TxID:      txid,
 		Vout:      vout,
 		SenderSig: sig,
@@ -233,16 +318,43 @@ func (s *Sender) GotOpenResponse(resp *models.OpenResponse) error {
 	if s.State.FundingTxID == "" {
 		return errors.New("fundingTxID is missing")
 	}
+	if s.State.Revocable {
+		err := s.State.validateCommitmentSig(0, s.State.PaymentsHash,
+			s.State.RevocationHash, resp.CommitmentSig, s.privKey)
+		if err != nil {
+			return err
+		}
+		s.State.CommitmentSig = resp.CommitmentSig
+	}
 	s.State.Status = StatusOpen
 	return nil
 }
 
 func (s *Sender) GetSendRequest(amount int64, payment []byte) (*models.SendRequest, error) {
+	return s.getSendRequest(s.State, amount, payment)
+}
+
+// GetCaptureRequest returns a send request for a payment of amount captured
+// from the hold. The rest of the hold is released.
+func (s *Sender) GetCaptureRequest(holdID string, amount int64, payment []byte) (*models.SendRequest, error) {
+	state := s.State
+	if err := state.captureHold(holdID, amount); err != nil {
+		return nil, err
+	}
+	req, err := s.getSendRequest(state, amount, payment)
+	if err != nil {
+		return nil, err
+	}
+	req.HoldID = holdID
+	return req, nil
+}
+
+func (s *Sender) getSendRequest(state SharedState, amount int64, payment []byte) (*models.SendRequest, error) {
 	if s.State.Status != StatusOpen {
 		return nil, ErrNotStatusOpen
 	}
 
-	newBalance, err := s.State.validateAmount(amount)
+	newBalance, err := state.validateAmount(amount)
 	if err != nil {
 		return nil, err
 	}
@@ -258,12 +370,33 @@ func (s *Sender) GetSendRequest(amount int64, payment []byte) (*models.SendReque
 		return nil, err
 	}
 
-	return &models.SendRequest{
+	feeSigs, err := s.signFeeTiers(newBalance, newHash)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &models.SendRequest{
 		TxID:      s.State.FundingTxID,
 		Vout:      s.State.FundingVout,
 		Payment:   payment,
 		SenderSig: sig,
-	}, nil
+		FeeSigs:   feeSigs,
+	}
+
+	if s.State.Revocable {
+		// Revoking the previous state is only safe once we hold a
+		// commitment for the current one.
+		if len(s.State.CommitmentSig) == 0 {
+			return nil, errors.New("missing commitment signature")
+		}
+		req.RevocationHash = RevocationHash(
+			RevocationSecret(s.privKey, s.State.Count+1))
+		if s.State.Count > 0 {
+			req.RevocationSecret = RevocationSecret(s.privKey, s.State.Count-1)
+		}
+	}
+
+	return req, nil
 }
 
 func (s *Sender) GotSendResponse(amount int64, payment []byte, resp *models.SendResponse) error {
@@ -277,9 +410,156 @@ func (s *Sender) GotSendResponse(amount int64, payment []byte, resp *models.Send
 	s.State.Balance += amount
 	s.State.PaymentsHash = newHash
 
+	if s.State.Revocable {
+		s.State.PrevRevocationHash = s.State.RevocationHash
+		s.State.RevocationHash = RevocationHash(
+			RevocationSecret(s.privKey, s.State.Count))
+		s.State.CommitmentSig = nil
+
+		// Without a response, the commitment signature can be fetched later
+		// from the status and passed to GotCommitmentSig.
+		if resp != nil && len(resp.CommitmentSig) > 0 {
+			return s.GotCommitmentSig(resp.CommitmentSig)
+		}
+	}
+
+	return nil
+}
+
+// GotCommitmentSig stores the receiver's signature for the commitment
+// transaction of the current state of a revocable channel.
+func (s *Sender) GotCommitmentSig(commitmentSig []byte) error {
+	if !s.State.Revocable {
+		return ErrNotRevocable
+	}
+	err := s.State.validateCommitmentSig(s.State.Balance, s.State.PaymentsHash,
+		s.State.RevocationHash, commitmentSig, s.privKey)
+	if err != nil {
+		return err
+	}
+	s.State.CommitmentSig = commitmentSig
+	return nil
+}
+
+// PrepareSend returns the send request for a payment and remembers it until
+// ConfirmSend or AbortSend is called. Preparing the same payment again returns
+// the identical request, so that retries after a network failure can't
+// compound the balance.
+func (s *Sender) PrepareSend(amount int64, payment []byte) (*models.SendRequest, error) {
+	if p := s.Pending; p != nil {
+		if p.Amount != amount || !bytes.Equal(p.Payment, payment) {
+			return nil, ErrSendPending
+		}
+		req := p.Request
+		return &req, nil
+	}
+
+	req, err := s.GetSendRequest(amount, payment)
+	if err != nil {
+		return nil, err
+	}
+	req.PaymentID, err = newPaymentID()
+	if err != nil {
+		return nil, err
+	}
+	s.Pending = &PendingSend{
+		Amount:  amount,
+		Payment: payment,
+		Request: *req,
+	}
+	return req, nil
+}
+
+// newPaymentID returns a random ID that makes retries of a prepared payment
+// idempotent.
+func newPaymentID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// ConfirmSend applies the pending payment once the receiver has accepted it.
+func (s *Sender) ConfirmSend(resp *models.SendResponse) error {
+	p := s.Pending
+	if p == nil {
+		return ErrNoPendingSend
+	}
+	if err := s.GotSendResponse(p.Amount, p.Payment, resp); err != nil {
+		return err
+	}
+	s.Pending = nil
 	return nil
 }
 
+// AbortSend discards the pending payment. It must only be called if the
+// receiver is known not to have accepted it, e.g. because Validate failed.
+func (s *Sender) AbortSend() error {
+	if s.Pending == nil {
+		return ErrNoPendingSend
+	}
+	s.Pending = nil
+	return nil
+}
+
+// GotCaptureResponse is like GotSendResponse for a payment captured from a
+// hold.
+func (s *Sender) GotCaptureResponse(holdID string, amount int64, payment []byte, resp *models.SendResponse) error {
+	if s.State.Status != StatusOpen {
+		return ErrNotStatusOpen
+	}
+	if err := s.State.captureHold(holdID, amount); err != nil {
+		return err
+	}
+	return s.GotSendResponse(amount, payment, resp)
+}
+
+func (s *Sender) GetHoldRequest(holdID string, amount int64) (*models.HoldRequest, error) {
+	if s.State.Status != StatusOpen {
+		return nil, ErrNotStatusOpen
+	}
+	state := s.State
+	if err := state.addHold(holdID, amount); err != nil {
+		return nil, err
+	}
+	return &models.HoldRequest{
+		TxID:   s.State.FundingTxID,
+		Vout:   s.State.FundingVout,
+		HoldID: holdID,
+		Amount: amount,
+	}, nil
+}
+
+func (s *Sender) GotHoldResponse(req *models.HoldRequest, resp *models.HoldResponse) error {
+	if s.State.Status != StatusOpen {
+		return ErrNotStatusOpen
+	}
+	return s.State.addHold(req.HoldID, req.Amount)
+}
+
+func (s *Sender) GetReleaseRequest(holdID string) (*models.ReleaseRequest, error) {
+	if s.State.Status != StatusOpen {
+		return nil, ErrNotStatusOpen
+	}
+	if s.State.findHold(holdID) < 0 {
+		return nil, ErrHoldNotFound
+	}
+	return &models.ReleaseRequest{
+		TxID:   s.State.FundingTxID,
+		Vout:   s.State.FundingVout,
+		HoldID: holdID,
+	}, nil
+}
+
+func (s *Sender) GotReleaseResponse(req *models.ReleaseRequest, resp *models.ReleaseResponse) error {
+	if s.State.Status != StatusOpen {
+		return ErrNotStatusOpen
+	}
+	_, err := s.State.removeHold(req.HoldID)
+	return err
+}
+
 func (s *Sender) GetCloseRequest() (*models.CloseRequest, error) {
 	if s.State.Status != StatusOpen && s.State.Status != StatusClosing {
 		return nil, ErrNotStatusOpen
@@ -296,7 +576,7 @@ func (s *Sender) GotCloseResponse(resp *models.CloseResponse) error {
 		return ErrNotStatusOpen
 	}
 
-	if err := s.State.validateTx(resp.CloseTx); err != nil {
+	if err := s.validateCloseTx(resp.CloseTx); err != nil {
 		return err
 	}
 
@@ -311,6 +591,13 @@ func (s *Sender) Refund() ([]byte, error) {
 	return s.State.GetRefundTxSigned(s.privKey)
 }
 
+// Commit returns the signed commitment transaction for the current state of
+// a revocable channel. Unlike the refund transaction, it can be broadcast
+// immediately.
+func (s *Sender) Commit() ([]byte, error) {
+	return s.State.GetCommitmentTxSigned(s.privKey)
+}
+
 func (s *Sender) CloseMined() error {
 	if s.State.Status != StatusClosing {
 		return ErrNotStatusClosing
@@ -0,0 +1,193 @@
+package channels
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/luno/moonbeam/models"
+)
+
+// Signer signs digests with a channel key. A *btcec.PrivateKey is a Signer,
+// but the receiver's key may also be held by a separate signing service.
+type Signer interface {
+	PubKey() *btcec.PublicKey
+	Sign(hash []byte) (*btcec.Signature, error)
+}
+
+// SignKind is the kind of message the receiver signs with a channel key.
+type SignKind string
+
+const (
+	// SignClosure and SignCommitment sign a closure or commitment
+	// transaction spending the funding output.
+	SignClosure    SignKind = "closure"
+	SignCommitment SignKind = "commitment"
+
+	// SignPenalty signs a penalty transaction spending the sender's
+	// revocable output of a revoked commitment transaction.
+	SignPenalty SignKind = "penalty"
+
+	// SignReceipt signs a payment receipt.
+	SignReceipt SignKind = "receipt"
+)
+
+// SignRequest is a message the receiver signs with a channel key, with the
+// channel parameters needed to compute its digest.
+type SignRequest struct {
+	Kind SignKind
+
+	// Tx is the transaction of the closure, commitment and penalty kinds.
+	Tx *wire.MsgTx
+
+	// SenderPubKey and Timeout are the channel's, from which the script
+	// spent by Tx is built.
+	SenderPubKey []byte
+	Timeout      int64
+
+	// RevocationHash is the commitment's, for the commitment and penalty
+	// kinds.
+	RevocationHash []byte
+
+	// Receipt is the receipt of the receipt kind.
+	Receipt *models.Receipt
+}
+
+// RequestSigner is implemented by Signers that sign SignRequests rather
+// than digests, such as a signing service that checks what it signs.
+// The receiver signs with it instead of Sign if it is implemented.
+type RequestSigner interface {
+	SignRequest(req SignRequest) (*btcec.Signature, error)
+}
+
+var errInvalidSignRequest = errors.New("invalid sign request")
+
+// pubKeyAddr returns pubKey as an address. Scripts don't depend on the
+// address's network.
+func pubKeyAddr(pubKey []byte) (*btcutil.AddressPubKey, error) {
+	return btcutil.NewAddressPubKey(pubKey, &chaincfg.MainNetParams)
+}
+
+// Digest checks that req is well-formed for the receiver key pubKey and
+// returns the digest to sign. Transactions must spend a single output of
+// the script the kind implies, so a signature can't spend anything else.
+func (req SignRequest) Digest(pubKey *btcec.PublicKey) ([]byte, error) {
+	receiverPubKey := pubKey.SerializeCompressed()
+	if req.Kind == SignReceipt {
+		rc := req.Receipt
+		if rc == nil || !bytes.Equal(rc.ReceiverPubKey, receiverPubKey) {
+			return nil, errInvalidSignRequest
+		}
+		return ReceiptDigest(*rc), nil
+	}
+
+	tx := req.Tx
+	if tx == nil || len(tx.TxIn) != 1 {
+		return nil, errInvalidSignRequest
+	}
+	sender, err := pubKeyAddr(req.SenderPubKey)
+	if err != nil {
+		return nil, errInvalidSignRequest
+	}
+	receiver, err := pubKeyAddr(receiverPubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var script []byte
+	switch req.Kind {
+	case SignClosure, SignCommitment:
+		// The data output, and the receiver's and sender's outputs
+		// unless they are dust.
+		if len(tx.TxOut) < 1 || len(tx.TxOut) > 3 ||
+			txscript.GetScriptClass(tx.TxOut[0].PkScript) != txscript.NullDataTy {
+			return nil, errInvalidSignRequest
+		}
+		if req.Kind == SignCommitment {
+			if err := checkCommitmentOutputs(tx, req.RevocationHash, sender, receiver); err != nil {
+				return nil, err
+			}
+		}
+		script, err = fundingTxScript(sender, receiver, req.Timeout)
+	case SignPenalty:
+		if len(tx.TxOut) != 1 || len(req.RevocationHash) != 20 {
+			return nil, errInvalidSignRequest
+		}
+		script, err = revocableOutputScript(req.RevocationHash, sender, receiver)
+	default:
+		return nil, fmt.Errorf("unknown sign request kind %q", req.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return txscript.CalcSignatureHash(script, txscript.SigHashAll, tx, 0)
+}
+
+// checkCommitmentOutputs checks that a commitment transaction with three
+// outputs pays the sender to the revocable output.
+func checkCommitmentOutputs(tx *wire.MsgTx, revocationHash []byte, sender, receiver *btcutil.AddressPubKey) error {
+	if len(revocationHash) != 20 {
+		return errInvalidSignRequest
+	}
+	if len(tx.TxOut) < 3 {
+		return nil
+	}
+	script, err := revocableOutputScript(revocationHash, sender, receiver)
+	if err != nil {
+		return err
+	}
+	b := txscript.NewScriptBuilder()
+	b.AddOp(txscript.OP_HASH160)
+	b.AddData(btcutil.Hash160(script))
+	b.AddOp(txscript.OP_EQUAL)
+	pkScript, err := b.Script()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(tx.TxOut[2].PkScript, pkScript) {
+		return errInvalidSignRequest
+	}
+	return nil
+}
+
+// signAsReceiver signs req with the receiver's key.
+func signAsReceiver(req SignRequest, signer Signer) (*btcec.Signature, error) {
+	if rs, ok := signer.(RequestSigner); ok {
+		return rs.SignRequest(req)
+	}
+	digest, err := req.Digest(signer.PubKey())
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(digest)
+}
+
+// signTxAsReceiver returns the receiver's signature of the transaction in
+// req, like signTxIn.
+func signTxAsReceiver(req SignRequest, signer Signer) ([]byte, error) {
+	sig, err := signAsReceiver(req, signer)
+	if err != nil {
+		return nil, err
+	}
+	return append(sig.Serialize(), byte(txscript.SigHashAll)), nil
+}
+
+// signTxIn returns the signature of the transaction's input spending
+// script, like txscript.RawTxInSignature.
+func signTxIn(tx *wire.MsgTx, idx int, script []byte, signer Signer) ([]byte, error) {
+	hash, err := txscript.CalcSignatureHash(script, txscript.SigHashAll, tx, idx)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.Sign(hash)
+	if err != nil {
+		return nil, err
+	}
+	return append(sig.Serialize(), byte(txscript.SigHashAll)), nil
+}
@@ -4,7 +4,9 @@ import (
 	"crypto/sha256"
 	"errors"
 
+	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcutil"
 )
 
@@ -14,6 +16,15 @@ type SharedState struct {
 	Timeout int64
 	Fee     int64
 
+	// MinPayment and MaxPayment bound the amount of a single payment.
+	// Zero means no bound.
+	MinPayment int64
+	MaxPayment int64
+
+	// Revocable channels give the sender a commitment transaction for each
+	// state. See revocable.go.
+	Revocable bool
+
 	Status Status
 
 	SenderPubKey   []byte
@@ -22,6 +33,8 @@ type SharedState struct {
 	SenderOutput   string
 	ReceiverOutput string
 
+	AppData []byte
+
 	FundingTxID string
 	FundingVout uint32
 	Capacity    int64
@@ -31,6 +44,20 @@ type SharedState struct {
 	Count        int
 	PaymentsHash [32]byte
 	SenderSig    []byte
+
+	// FeeSigs are the sender's signatures of the current state at higher
+	// fees. See FeeSig.
+	FeeSigs []FeeSig
+
+	Holds []Hold
+
+	// RevocationHash commits to the sender's revocation secret for the
+	// current state and PrevRevocationHash to the one for the previous
+	// state. CommitmentSig is the receiver's signature for the sender's
+	// commitment transaction of the current state.
+	RevocationHash     []byte
+	PrevRevocationHash []byte
+	CommitmentSig      []byte
 }
 
 func (ss *SharedState) GetNet() (*chaincfg.Params, error) {
@@ -59,8 +86,120 @@ func (ss *SharedState) ReceiverAddressPubKey() (*btcutil.AddressPubKey, error) {
 	return btcutil.NewAddressPubKey(ss.ReceiverPubKey, net)
 }
 
+// InvariantError indicates that a channel state is internally inconsistent.
+// A channel with such a state must not be signed for or broadcast.
+type InvariantError struct {
+	msg string
+}
+
+func invariantErr(msg string) InvariantError {
+	return InvariantError{msg: msg}
+}
+
+func (e InvariantError) Error() string {
+	return "channel invariant violated: " + e.msg
+}
+
+func checkPubKey(pubKey []byte) bool {
+	_, err := btcec.ParsePubKey(pubKey, btcec.S256())
+	return err == nil
+}
+
 func (ss *SharedState) sanityCheck() error {
-	// TODO: check that the right fields are filled in for the status
+	if ss.Status.rank() == 0 {
+		return invariantErr("unknown status")
+	}
+	if _, err := ss.GetNet(); err != nil {
+		return invariantErr("unknown net")
+	}
+	if len(ss.SenderPubKey) > 0 && !checkPubKey(ss.SenderPubKey) {
+		return invariantErr("senderPubKey is not on curve")
+	}
+	if len(ss.ReceiverPubKey) > 0 && !checkPubKey(ss.ReceiverPubKey) {
+		return invariantErr("receiverPubKey is not on curve")
+	}
+	if ss.Balance < 0 || ss.Count < 0 || ss.Fee < 0 {
+		return invariantErr("negative balance, count or fee")
+	}
+	if ss.Count == 0 && ss.Balance != 0 {
+		return invariantErr("balance without payments")
+	}
+	if err := validatePaymentLimits(ss.MinPayment, ss.MaxPayment); err != nil {
+		return invariantErr("invalid payment limits")
+	}
+	if err := validateAppData(ss.AppData); err != nil {
+		return invariantErr("app data is too large")
+	}
+
+	if ss.Status == StatusCreated {
+		return nil
+	}
+
+	if len(ss.SenderPubKey) == 0 || len(ss.ReceiverPubKey) == 0 {
+		return invariantErr("missing pubkey")
+	}
+	if _, err := chainhash.NewHashFromStr(ss.FundingTxID); err != nil {
+		return invariantErr("invalid fundingTxID")
+	}
+	if ss.Capacity <= 0 {
+		return invariantErr("invalid capacity")
+	}
+	if ss.Balance > 0 && ss.Balance+ss.Fee > ss.Capacity {
+		return invariantErr("balance exceeds capacity minus fee")
+	}
+	if len(ss.SenderSig) == 0 {
+		return invariantErr("missing senderSig")
+	}
+	for _, h := range ss.Holds {
+		if h.Amount <= 0 {
+			return invariantErr("invalid hold amount")
+		}
+	}
+	if len(ss.Holds) > 0 && ss.Balance+ss.Held()+ss.Fee > ss.Capacity {
+		return invariantErr("holds exceed capacity")
+	}
+	if ss.Revocable && len(ss.RevocationHash) != 20 {
+		return invariantErr("missing revocationHash")
+	}
+
+	return nil
+}
+
+// CheckTransition checks that next is a legal successor state of prev.
+func CheckTransition(prev, next SharedState) error {
+	if err := next.sanityCheck(); err != nil {
+		return err
+	}
+
+	if next.Status.rank() < prev.Status.rank() {
+		return invariantErr("illegal status transition from " +
+			prev.Status.String() + " to " + next.Status.String())
+	}
+	if prev.Status != StatusCreated {
+		if next.FundingTxID != prev.FundingTxID ||
+			next.FundingVout != prev.FundingVout ||
+			next.Capacity != prev.Capacity ||
+			next.Fee != prev.Fee ||
+			next.Timeout != prev.Timeout ||
+			next.Revocable != prev.Revocable {
+			return invariantErr("channel parameters changed")
+		}
+	}
+
+	if next.Count < prev.Count {
+		return invariantErr("count decreased")
+	}
+	if next.Balance < prev.Balance {
+		return invariantErr("balance decreased")
+	}
+	if next.Count == prev.Count &&
+		(next.Balance != prev.Balance || next.PaymentsHash != prev.PaymentsHash) {
+		return invariantErr("balance changed without a payment")
+	}
+	if next.Count > prev.Count && !prev.Status.IsOpen() {
+		return invariantErr("payment on channel that is not open")
+	}
+
 	return nil
 }
 
@@ -91,3 +230,26 @@ func validatePaymentSize(size int) bool {
 func chainHash(prevHash [32]byte, payment []byte) [32]byte {
 	return sha256.Sum256(append(payment, prevHash[:]...))
 }
+
+// maxAppDataSize is the maximum size of the application data that can be
+// bound to a channel.
+const maxAppDataSize = 512
+
+var ErrAppDataTooLarge = errors.New("app data is too large")
+
+func validateAppData(appData []byte) error {
+	if len(appData) > maxAppDataSize {
+		return ErrAppDataTooLarge
+	}
+	return nil
+}
+
+// initialPaymentsHash returns the paymentsHash that the channel starts with.
+// If the channel has application data, the hash commits to it so that the
+// sender's signature over the closure transaction covers the data.
+func initialPaymentsHash(appData []byte) [32]byte {
+	if len(appData) == 0 {
+		return [32]byte{}
+	}
+	return sha256.Sum256(appData)
+}
@@ -0,0 +1,34 @@
+package channels
+
+import (
+	"errors"
+)
+
+// UpdateMode selects how the sender authorizes new channel states.
+type UpdateMode int
+
+const (
+	// UpdateModeSignature is the default mode where the sender signs the
+	// closure transaction for every new balance.
+	UpdateModeSignature UpdateMode = 0
+
+	// UpdateModeAnyPrevOut is an experimental mode where the sender's update
+	// signatures don't commit to the funding outpoint, in the style of
+	// SIGHASH_ANYPREVOUT. It is only available in builds with the anyprevout
+	// tag and is intended for signet experimentation.
+	UpdateModeAnyPrevOut UpdateMode = 1
+)
+
+var ErrUpdateModeUnsupported = errors.New("update mode not supported by this build")
+
+func checkUpdateMode(m UpdateMode) error {
+	switch m {
+	case UpdateModeSignature:
+		return nil
+	case UpdateModeAnyPrevOut:
+		if anyPrevOutEnabled {
+			return nil
+		}
+	}
+	return ErrUpdateModeUnsupported
+}
@@ -2,6 +2,9 @@ package client
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -9,16 +12,28 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/luno/moonbeam/models"
 )
 
 var debugRPC = flag.Bool("debug_rpc", true, "Debug RPC")
 
+// Signer signs requests with the sender's channel key. It is implemented by
+// channels.Sender.
+type Signer interface {
+	SignRequest(channelID, method, nonce string, body []byte) ([]byte, error)
+}
+
 type Client struct {
 	endpoint string
 	c        *http.Client
+
+	// Signer, if set, signs open, send and status calls for receivers that
+	// require sender signatures.
+	Signer Signer
 }
 
 func NewClient(c *http.Client, endpoint string) (*Client, error) {
@@ -32,6 +47,30 @@ func NewClient(c *http.Client, endpoint string) (*Client, error) {
 	}, nil
 }
 
+func newNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	return ts + "." + hex.EncodeToString(buf), nil
+}
+
+// sign adds the sender's signature of the call on the channel to hreq.
+func (c *Client) sign(hreq *http.Request, channelID, call string, body []byte) error {
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+	sig, err := c.Signer.SignRequest(channelID, call, nonce, body)
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("X-Moonbeam-Nonce", nonce)
+	hreq.Header.Set("X-Moonbeam-Signature", base64.StdEncoding.EncodeToString(sig))
+	return nil
+}
+
 func (c *Client) do(method, path string, authToken string, req, resp interface{}) error {
 	url := c.endpoint + path
 
@@ -51,6 +90,14 @@ func (c *Client) do(method, path string, authToken string, req, resp interface{}
 	if authToken != "" {
 		hreq.Header.Add("Authorization", "Bearer "+authToken)
 	}
+	if c.Signer != nil {
+		call, id := splitPath(path)
+		if call == "open" || call == "send" || call == "status" {
+			if err := c.sign(hreq, id, call, buf); err != nil {
+				return err
+			}
+		}
+	}
 
 	hresp, err := c.c.Do(hreq)
 	if err != nil {
@@ -69,16 +116,44 @@ func (c *Client) do(method, path string, authToken string, req, resp interface{}
 	}
 
 	if hresp.StatusCode != http.StatusOK {
-		if len(respBuf) > 256 {
-			respBuf = respBuf[:256]
-		}
-		return fmt.Errorf("moonchan/client: http error code %d: %s",
-			hresp.StatusCode, string(respBuf))
+		return newError(hresp, respBuf)
 	}
 
 	return json.Unmarshal(respBuf, resp)
 }
 
+// Error is returned for calls that the receiver responded to with an
+// error.
+type Error struct {
+	StatusCode int
+	Message    string
+
+	// Reason is set if the receiver rejected a payment. The receiver isn't
+	// trusted, so it's only a hint of what to do next.
+	Reason models.Reason
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("moonchan/client: http error code %d: %s",
+		e.StatusCode, e.Message)
+}
+
+func newError(hresp *http.Response, body []byte) *Error {
+	e := &Error{StatusCode: hresp.StatusCode}
+	var er models.ErrorResponse
+	if strings.HasPrefix(hresp.Header.Get("Content-Type"), "application/json") &&
+		json.Unmarshal(body, &er) == nil {
+		e.Message = er.Error
+		e.Reason = er.Reason
+	} else {
+		e.Message = string(body)
+	}
+	if len(e.Message) > 256 {
+		e.Message = e.Message[:256]
+	}
+	return e
+}
+
 func (c *Client) Create(req models.CreateRequest) (*models.CreateResponse, error) {
 	var resp models.CreateResponse
 	if err := c.do(http.MethodPost, "/create", "", req, &resp); err != nil {
@@ -87,6 +162,15 @@ func (c *Client) Create(req models.CreateRequest) (*models.CreateResponse, error
 	return &resp, nil
 }
 
+// splitPath splits a call path of the form /<call>/<channelID>.
+func splitPath(path string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if len(parts) != 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
 func getChannelID(txid string, vout uint32) string {
 	return fmt.Sprintf("%s-%d", txid, vout)
 }
@@ -118,6 +202,24 @@ func (c *Client) Send(req models.SendRequest, authToken string) (*models.SendRes
 	return &resp, nil
 }
 
+func (c *Client) Hold(req models.HoldRequest, authToken string) (*models.HoldResponse, error) {
+	path := "/hold/" + getChannelID(req.TxID, req.Vout)
+	var resp models.HoldResponse
+	if err := c.do(http.MethodPost, path, authToken, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) Release(req models.ReleaseRequest, authToken string) (*models.ReleaseResponse, error) {
+	path := "/release/" + getChannelID(req.TxID, req.Vout)
+	var resp models.ReleaseResponse
+	if err := c.do(http.MethodPost, path, authToken, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 func (c *Client) Close(req models.CloseRequest, authToken string) (*models.CloseResponse, error) {
 	path := "/close/" + getChannelID(req.TxID, req.Vout)
 	var resp models.CloseResponse
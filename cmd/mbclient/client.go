@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
 	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
@@ -77,10 +78,22 @@ func getHttpClient() *http.Client {
 	}
 }
 
-func getClient(id string) (*client.Client, error) {
+func getClient(id string, sender *channels.Sender) (*client.Client, error) {
 	host := globalState.Channels[id].Host
-	c := getHttpClient()
-	return client.NewClient(c, host)
+	c, err := client.NewClient(getHttpClient(), host)
+	if err != nil {
+		return nil, err
+	}
+	c.Signer = sender
+	return c, nil
+}
+
+func genNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
 }
 
 func getResolver() *resolver.Resolver {
@@ -98,12 +111,10 @@ func create(args []string) error {
 	domain := args[0]
 	outputAddr := args[1]
 
-	r := getResolver()
-	hostURL, err := r.Resolve(domain)
+	host, err := selectReceiver(getResolver(), domain)
 	if err != nil {
 		return err
 	}
-	host := hostURL.String()
 
 	n := globalState.NextKey()
 	privkey, _, err := loadkey(globalState, n)
@@ -126,7 +137,12 @@ func create(args []string) error {
 	if err != nil {
 		return err
 	}
-	resp, err := c.Create(*req)
+	var resp *models.CreateResponse
+	err = track(host, func() error {
+		var err error
+		resp, err = c.Create(*req)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -187,11 +203,16 @@ func fund(args []string) error {
 	}
 	req.ReceiverData = ch.ReceiverData
 
-	c, err := getClient(id)
+	c, err := getClient(id, sender)
 	if err != nil {
 		return err
 	}
-	resp, err := c.Open(*req)
+	var resp *models.OpenResponse
+	err = track(ch.Host, func() error {
+		var err error
+		resp, err = c.Open(*req)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -229,21 +250,23 @@ func send(args []string) error {
 		id = ids[0]
 	}
 
-	p := models.Payment{
-		Amount: amount,
-		Target: target,
-	}
-	payment, err := json.Marshal(p)
+	ch, sender, err := getChannel(id)
 	if err != nil {
 		return err
 	}
 
-	ch, sender, err := getChannel(id)
+	nonce, err := genNonce()
 	if err != nil {
 		return err
 	}
-
-	if _, err := sender.GetSendRequest(p.Amount, payment); err != nil {
+	p := models.Payment{
+		Amount:  amount,
+		Target:  target,
+		Nonce:   nonce,
+		Counter: sender.State.Count + 1,
+	}
+	payment, err := models.EncodePayment(p)
+	if err != nil {
 		return err
 	}
 
@@ -251,7 +274,11 @@ func send(args []string) error {
 		return errors.New("there is already a pending payment")
 	}
 
-	c, err := getClient(id)
+	if _, err := sender.PrepareSend(p.Amount, payment); err != nil {
+		return err
+	}
+
+	c, err := getClient(id, sender)
 	if err != nil {
 		return err
 	}
@@ -260,15 +287,24 @@ func send(args []string) error {
 		Vout:    ch.State.FundingVout,
 		Payment: payment,
 	}
-	resp, err := c.Validate(req, ch.AuthToken)
+	var resp *models.ValidateResponse
+	err = track(ch.Host, func() error {
+		var err error
+		resp, err = c.Validate(req, ch.AuthToken)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 	if !resp.Valid {
+		sender.AbortSend()
+		if resp.Reason != "" {
+			return fmt.Errorf("payment rejected by server: %s", resp.Reason)
+		}
 		return errors.New("payment rejected by server")
 	}
 
-	if err := storePendingPayment(id, sender.State, payment); err != nil {
+	if err := storePendingPayment(id, sender, payment); err != nil {
 		return err
 	}
 	if err := save(getNet(), globalState); err != nil {
@@ -290,19 +326,20 @@ func flush(id string) error {
 		return nil
 	}
 
-	var p models.Payment
-	if err := json.Unmarshal(payment, &p); err != nil {
+	p, err := models.DecodePayment(payment)
+	if err != nil {
 		return err
 	}
 
-	sendReq, err := sender.GetSendRequest(p.Amount, payment)
+	// This returns the identical request if it was prepared before.
+	sendReq, err := sender.PrepareSend(p.Amount, payment)
 	if err != nil {
 		return err
 	}
 
 	// Either the payment has been sent or it hasn't. Find out which one.
 
-	c, err := getClient(id)
+	c, err := getClient(id, sender)
 	if err != nil {
 		return err
 	}
@@ -310,7 +347,12 @@ func flush(id string) error {
 		TxID: ch.State.FundingTxID,
 		Vout: ch.State.FundingVout,
 	}
-	resp, err := c.Status(req, ch.AuthToken)
+	var resp *models.StatusResponse
+	err = track(ch.Host, func() error {
+		var err error
+		resp, err = c.Status(req, ch.AuthToken)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -320,30 +362,57 @@ func flush(id string) error {
 	if serverBal == sender.State.Balance {
 		// Pending payment doesn't reflect yet. We have to retry.
 
-		if _, err := c.Send(*sendReq, ch.AuthToken); err != nil {
+		var sendResp *models.SendResponse
+		err := track(ch.Host, func() error {
+			var err error
+			sendResp, err = c.Send(*sendReq, ch.AuthToken)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := sender.ConfirmSend(nil); err != nil {
 			return err
 		}
 
-		if err := sender.GotSendResponse(p.Amount, payment, nil); err != nil {
+		if err := storePendingPayment(id, sender, nil); err != nil {
 			return err
 		}
 
-		return storePendingPayment(id, sender.State, nil)
+		return printReceipt(sender, sendResp.Receipt)
 
 	} else if serverBal == sender.State.Balance+p.Amount {
 		// Pending payment reflects. Finalize our side.
 
-		if err := sender.GotSendResponse(p.Amount, payment, nil); err != nil {
+		if err := sender.ConfirmSend(nil); err != nil {
 			return err
 		}
 
-		return storePendingPayment(id, sender.State, nil)
+		return storePendingPayment(id, sender, nil)
 
 	} else {
 		return errors.New("unexpected remote channel balance")
 	}
 }
 
+// printReceipt checks the payment's receipt and prints it so that it can be
+// presented as proof of payment.
+func printReceipt(sender *channels.Sender, rc *models.Receipt) error {
+	if rc == nil {
+		return nil
+	}
+	if err := sender.CheckReceipt(*rc); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(rc)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Receipt: %s\n", buf)
+	return nil
+}
+
 func flushAction(args []string) error {
 	return flush(args[0])
 }
@@ -361,16 +430,21 @@ func closeAction(args []string) error {
 		return err
 	}
 
-	c, err := getClient(id)
+	c, err := getClient(id, sender)
 	if err != nil {
 		return err
 	}
-	resp, err := c.Close(*req, ch.AuthToken)
-	if err != nil {
+	var resp *models.CloseResponse
+	err = track(ch.Host, func() error {
+		var err error
+		resp, err = c.Close(*req, ch.AuthToken)
+		if err == nil {
+			err = sender.GotCloseResponse(resp)
+		}
 		return err
-	}
-
-	if err := sender.GotCloseResponse(resp); err != nil {
+	})
+	getHealth(ch.Host).recordClose(err)
+	if err != nil {
 		return err
 	}
 
@@ -391,7 +465,7 @@ func status(args []string) error {
 		return err
 	}
 
-	c, err := getClient(id)
+	c, err := getClient(id, sender)
 	if err != nil {
 		return err
 	}
@@ -399,7 +473,12 @@ func status(args []string) error {
 		TxID: ch.State.FundingTxID,
 		Vout: ch.State.FundingVout,
 	}
-	resp, err := c.Status(req, ch.AuthToken)
+	var resp *models.StatusResponse
+	err = track(ch.Host, func() error {
+		var err error
+		resp, err = c.Status(req, ch.AuthToken)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -520,6 +599,11 @@ var commands = map[string]func(args []string) error{
 	"show":   show,
 	"status": status,
 	"flush":  flushAction,
+
+	"receivers": receiversAction,
+	"pin":       pinAction,
+	"ban":       banAction,
+	"reset":     resetAction,
 }
 
 var helps = map[string]string{
@@ -533,6 +617,11 @@ var helps = map[string]string{
 	"status": "Get status from server",
 	"flush":  "Flush any pending payment",
 	"help":   "Show help",
+
+	"receivers": "List known receivers and their health",
+	"pin":       "Always prefer a receiver URL",
+	"ban":       "Never use a receiver URL",
+	"reset":     "Clear pin/ban overrides for a receiver URL",
 }
 
 func main() {
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/luno/moonbeam/resolver"
+)
+
+// ReceiverHealth tracks how well a remote receiver endpoint has behaved.
+type ReceiverHealth struct {
+	URL    string
+	Domain string
+
+	Pinned bool
+	Banned bool
+
+	Requests int
+	Errors   int
+
+	// LatencyMillis is an exponentially weighted moving average of the
+	// request latency.
+	LatencyMillis float64
+
+	Closes       int
+	FailedCloses int
+
+	AdvertisedUptime float64
+
+	LastError string
+	LastSeen  time.Time
+}
+
+const latencyWeight = 0.2
+
+func (h *ReceiverHealth) record(d time.Duration, err error) {
+	ms := float64(d) / float64(time.Millisecond)
+	if h.Requests == 0 {
+		h.LatencyMillis = ms
+	} else {
+		h.LatencyMillis = (1-latencyWeight)*h.LatencyMillis + latencyWeight*ms
+	}
+	h.Requests++
+	h.LastSeen = time.Now()
+	if err != nil {
+		h.Errors++
+		h.LastError = err.Error()
+	}
+}
+
+func (h *ReceiverHealth) recordClose(err error) {
+	h.Closes++
+	if err != nil {
+		h.FailedCloses++
+	}
+}
+
+// Score returns a number between 0 and 1 indicating how healthy the receiver
+// is. Receivers we have no history for get the benefit of the doubt.
+func (h *ReceiverHealth) Score() float64 {
+	if h.Banned {
+		return 0
+	}
+
+	score := 1.0
+
+	if h.Requests > 0 {
+		// Add one failure and one success so that a single request doesn't
+		// dominate.
+		score *= 1 - float64(h.Errors+1)/float64(h.Requests+2)
+	}
+
+	if h.Closes > 0 {
+		score *= 1 - float64(h.FailedCloses)/float64(h.Closes+1)
+	}
+
+	if h.AdvertisedUptime > 0 && h.AdvertisedUptime <= 1 {
+		score *= h.AdvertisedUptime
+	}
+
+	// Penalize slow receivers: 1s average latency halves the score.
+	score *= 1000 / (1000 + h.LatencyMillis)
+
+	return score
+}
+
+func getHealth(url string) *ReceiverHealth {
+	if globalState.Receivers == nil {
+		globalState.Receivers = make(map[string]*ReceiverHealth)
+	}
+	h, ok := globalState.Receivers[url]
+	if !ok {
+		h = &ReceiverHealth{URL: url}
+		globalState.Receivers[url] = h
+	}
+	return h
+}
+
+// track calls f and records its outcome against the receiver at url.
+func track(url string, f func() error) error {
+	start := time.Now()
+	err := f()
+	getHealth(url).record(time.Since(start), err)
+	return err
+}
+
+// selectReceiver returns the healthiest receiver URL for the domain. Pinned
+// receivers are always preferred and banned receivers are never returned.
+func selectReceiver(r *resolver.Resolver, domain string) (string, error) {
+	receivers, err := r.ResolveAll(domain)
+	if err != nil {
+		return "", err
+	}
+
+	var hs []*ReceiverHealth
+	for _, dr := range receivers {
+		h := getHealth(dr.URL)
+		h.Domain = domain
+		h.AdvertisedUptime = dr.Uptime
+		hs = append(hs, h)
+	}
+	candidates := rankReceivers(hs)
+	if len(candidates) == 0 {
+		return "", errors.New("all receivers for domain are banned")
+	}
+	return candidates[0].URL, nil
+}
+
+// rankReceivers returns the receivers that aren't banned, pinned ones first
+// and then by descending score. Receivers with equal scores keep their
+// order.
+func rankReceivers(hs []*ReceiverHealth) []*ReceiverHealth {
+	var candidates []*ReceiverHealth
+	for _, h := range hs {
+		if !h.Banned {
+			candidates = append(candidates, h)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Pinned != candidates[j].Pinned {
+			return candidates[i].Pinned
+		}
+		return candidates[i].Score() > candidates[j].Score()
+	})
+	return candidates
+}
+
+func receiversAction(args []string) error {
+	var urls []string
+	for url := range globalState.Receivers {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	fmt.Printf("URL\tDomain\tScore\tRequests\tErrors\tLatency\tFlags\n")
+	for _, url := range urls {
+		h := globalState.Receivers[url]
+		flags := ""
+		if h.Pinned {
+			flags += "pinned "
+		}
+		if h.Banned {
+			flags += "banned"
+		}
+		fmt.Printf("%s\t%s\t%.3f\t%d\t%d\t%.0fms\t%s\n",
+			url, h.Domain, h.Score(), h.Requests, h.Errors, h.LatencyMillis, flags)
+	}
+	return nil
+}
+
+func setReceiverFlag(args []string, f func(h *ReceiverHealth)) error {
+	if len(args) == 0 {
+		return errors.New("receiver url is required")
+	}
+	f(getHealth(args[0]))
+	return nil
+}
+
+func pinAction(args []string) error {
+	return setReceiverFlag(args, func(h *ReceiverHealth) {
+		h.Pinned = true
+		h.Banned = false
+	})
+}
+
+func banAction(args []string) error {
+	return setReceiverFlag(args, func(h *ReceiverHealth) {
+		h.Banned = true
+		h.Pinned = false
+	})
+}
+
+func resetAction(args []string) error {
+	return setReceiverFlag(args, func(h *ReceiverHealth) {
+		h.Banned = false
+		h.Pinned = false
+	})
+}
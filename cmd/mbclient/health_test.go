@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name   string
+		h      ReceiverHealth
+		better ReceiverHealth
+	}{
+		{"errors", ReceiverHealth{Requests: 10, Errors: 5}, ReceiverHealth{Requests: 10}},
+		{"failed closes", ReceiverHealth{Closes: 2, FailedCloses: 1}, ReceiverHealth{Closes: 2}},
+		{"latency", ReceiverHealth{Requests: 1, LatencyMillis: 1000}, ReceiverHealth{Requests: 1, LatencyMillis: 10}},
+		{"uptime", ReceiverHealth{AdvertisedUptime: 0.5}, ReceiverHealth{AdvertisedUptime: 0.99}},
+		{"banned", ReceiverHealth{Banned: true}, ReceiverHealth{Requests: 10, Errors: 9}},
+	}
+	for _, test := range tests {
+		if s, b := test.h.Score(), test.better.Score(); s >= b {
+			t.Errorf("%s: expected score %f below %f", test.name, s, b)
+		}
+	}
+
+	if s := (&ReceiverHealth{}).Score(); s != 1 {
+		t.Errorf("Expected a new receiver to score 1, got %f", s)
+	}
+	if s := (&ReceiverHealth{Banned: true}).Score(); s != 0 {
+		t.Errorf("Expected a banned receiver to score 0, got %f", s)
+	}
+}
+
+func TestRankReceivers(t *testing.T) {
+	healthy := &ReceiverHealth{URL: "healthy"}
+	slow := &ReceiverHealth{URL: "slow", Requests: 5, LatencyMillis: 2000}
+	failing := &ReceiverHealth{URL: "failing", Requests: 5, Errors: 5}
+	banned := &ReceiverHealth{URL: "banned", Banned: true}
+	pinned := &ReceiverHealth{URL: "pinned", Pinned: true, Requests: 5, Errors: 4}
+	tieA := &ReceiverHealth{URL: "a", Requests: 2, LatencyMillis: 50}
+	tieB := &ReceiverHealth{URL: "b", Requests: 2, LatencyMillis: 50}
+
+	tests := []struct {
+		name string
+		in   []*ReceiverHealth
+		out  []string
+	}{
+		{"by score", []*ReceiverHealth{failing, slow, healthy}, []string{"healthy", "slow", "failing"}},
+		{"ties keep order", []*ReceiverHealth{tieB, tieA}, []string{"b", "a"}},
+		{"ties keep order reversed", []*ReceiverHealth{tieA, tieB}, []string{"a", "b"}},
+		{"banned excluded", []*ReceiverHealth{banned, failing}, []string{"failing"}},
+		{"all banned", []*ReceiverHealth{banned}, nil},
+		{"pinned first", []*ReceiverHealth{healthy, pinned}, []string{"pinned", "healthy"}},
+	}
+	for _, test := range tests {
+		var urls []string
+		for _, h := range rankReceivers(test.in) {
+			urls = append(urls, h.URL)
+		}
+		if !reflect.DeepEqual(urls, test.out) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.out, urls)
+		}
+	}
+}
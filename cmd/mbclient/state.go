@@ -22,6 +22,7 @@ type Channel struct {
 	AuthToken    string
 
 	PendingPayment []byte
+	PendingSend    *channels.PendingSend
 
 	State channels.SharedState
 
@@ -33,6 +34,7 @@ type State struct {
 	XPrivKey       string
 	KeyPathCounter int
 	Channels       map[string]Channel
+	Receivers      map[string]*ReceiverHealth
 }
 
 func (s *State) NextKey() int {
@@ -137,6 +139,7 @@ func getChannel(id string) (*Channel, *channels.Sender, error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	sender.Pending = s.PendingSend
 
 	return &s, sender, nil
 }
@@ -151,12 +154,13 @@ func storeChannel(id string, state channels.SharedState) error {
 	return nil
 }
 
-func storePendingPayment(id string, state channels.SharedState, p []byte) error {
+func storePendingPayment(id string, sender *channels.Sender, p []byte) error {
 	c, ok := globalState.Channels[id]
 	if !ok {
 		return errors.New("channel does not exist")
 	}
-	c.State = state
+	c.State = sender.State
+	c.PendingSend = sender.Pending
 	if p == nil {
 		c.Payments = append(c.Payments, c.PendingPayment)
 		c.PendingPayment = nil
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+var chainConfigFile = flag.String("chain_config", "", "JSON file configuring the chain backend, replacing --chain_backend, the --bitcoind_* and fallback flags, --btcd_cert, --esplora_url, --neutrino_peers and --zmq; mbserver chain-config translates those flags into one")
+
+// chainConfig is the backend-agnostic format of --chain_config. A backend
+// is a node reached over RPC, bitcoind or btcd, an Esplora API, or
+// neutrino, a light client of nodes serving compact block filters.
+type chainConfig struct {
+	// Backend is bitcoind, btcd, esplora or neutrino.
+	Backend string `json:"backend"`
+
+	// Host and the credentials are those of an RPC backend. Cookie is the
+	// path of bitcoind's .cookie file, used instead of Username and
+	// Password, and Cert btcd's RPC certificate.
+	Host     string `json:"host,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Cookie   string `json:"cookie,omitempty"`
+	Cert     string `json:"cert,omitempty"`
+
+	// ZMQ is bitcoind's address publishing rawblock and rawtx.
+	ZMQ string `json:"zmq,omitempty"`
+
+	// URL is the Esplora API's.
+	URL string `json:"url,omitempty"`
+
+	// Verify is an Esplora API that channel funding is checked against.
+	Verify string `json:"verify,omitempty"`
+
+	// Peers are the nodes a neutrino backend syncs from.
+	Peers []string `json:"peers,omitempty"`
+
+	// Fallbacks are failed over to, in order, for reads.
+	Fallbacks []chainConfig `json:"fallbacks,omitempty"`
+}
+
+func (c chainConfig) validate() error {
+	switch c.Backend {
+	case "bitcoind", "btcd":
+		if c.Host == "" {
+			return fmt.Errorf("%s backend is missing host", c.Backend)
+		}
+	case "esplora":
+		if c.URL == "" {
+			return errors.New("esplora backend is missing url")
+		}
+	case "neutrino":
+		// The receiver's funding scripts are only watched by the
+		// neutrino backend itself.
+		if len(c.Peers) == 0 {
+			return errors.New("neutrino backend is missing peers")
+		}
+		if len(c.Fallbacks) > 0 || c.Verify != "" {
+			return errors.New("neutrino backend can't have fallbacks or verify")
+		}
+	default:
+		return fmt.Errorf("unknown chain backend %q", c.Backend)
+	}
+	for _, f := range c.Fallbacks {
+		if len(f.Fallbacks) > 0 {
+			return errors.New("fallbacks can't have fallbacks")
+		}
+		if f.Backend == "neutrino" {
+			return errors.New("neutrino backend can't be a fallback")
+		}
+		if err := f.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chainConfigFromFlags translates the chain backend flags into a
+// chainConfig.
+func chainConfigFromFlags() chainConfig {
+	c := chainConfig{Backend: *chainBackend}
+	if c.Backend == "neutrino" {
+		for _, p := range strings.Split(*neutrinoPeers, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				c.Peers = append(c.Peers, p)
+			}
+		}
+		return c
+	}
+	if c.Backend == "esplora" {
+		c.URL = *esploraURL
+	} else {
+		c.Host = *bitcoindHost
+		c.Username = *bitcoindUsername
+		c.Password = *bitcoindPassword
+		c.Cookie = *bitcoindCookie
+		c.ZMQ = *zmqAddr
+		c.Verify = *esploraURL
+		if c.Backend == "btcd" {
+			c.Cert = *btcdCert
+		}
+	}
+	for _, host := range strings.Split(*fallbackHosts, ",") {
+		if host = strings.TrimSpace(host); host == "" {
+			continue
+		}
+		c.Fallbacks = append(c.Fallbacks, chainConfig{
+			Backend:  *chainBackend,
+			Host:     host,
+			Username: *bitcoindUsername,
+			Password: *bitcoindPassword,
+			Cookie:   *bitcoindCookie,
+			Cert:     c.Cert,
+		})
+	}
+	if *fallbackEsplora != "" {
+		c.Fallbacks = append(c.Fallbacks, chainConfig{Backend: "esplora", URL: *fallbackEsplora})
+	}
+	return c
+}
+
+// loadChainConfig returns --chain_config, or the chain backend flags'
+// translation if it isn't set.
+func loadChainConfig() (chainConfig, error) {
+	if *chainConfigFile == "" {
+		return chainConfigFromFlags(), nil
+	}
+	buf, err := ioutil.ReadFile(*chainConfigFile)
+	if err != nil {
+		return chainConfig{}, err
+	}
+	var c chainConfig
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return chainConfig{}, fmt.Errorf("%s: %v", *chainConfigFile, err)
+	}
+	if err := c.validate(); err != nil {
+		return chainConfig{}, fmt.Errorf("%s: %v", *chainConfigFile, err)
+	}
+	return c, nil
+}
+
+// runChainConfig writes the chain backend flags' translation to w, for
+// --chain_config, and reports to diag whether each backend in it can be
+// reached and has the capabilities the server uses.
+func runChainConfig(w, diag io.Writer, net *chaincfg.Params) error {
+	c := chainConfigFromFlags()
+	if err := c.validate(); err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%s\n", buf); err != nil {
+		return err
+	}
+	if !diagnoseChainConfig(diag, c, net) {
+		return errors.New("chain backend diagnostics failed")
+	}
+	return nil
+}
+
+// diagnoseChainConfig runs the diagnostics of each RPC backend in c. It
+// returns false if any of them can't be used.
+func diagnoseChainConfig(w io.Writer, c chainConfig, net *chaincfg.Params) bool {
+	ok := true
+	for _, bc := range append([]chainConfig{c}, c.Fallbacks...) {
+		if bc.Backend == "esplora" || bc.Backend == "neutrino" {
+			continue
+		}
+		fmt.Fprintf(w, "%s at %s\n", bc.Backend, bc.Host)
+		cb, err := bitcoinClient(bc)
+		if err != nil {
+			fmt.Fprintf(w, "%-4s %-16s %s\n", "FAIL", "connectivity", err)
+			ok = false
+			continue
+		}
+		if !runDiagnostics(w, cb.Client(), net) {
+			ok = false
+		}
+		cb.Shutdown()
+	}
+	return ok
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+
+	"github.com/luno/moonbeam/config"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/dynamodb"
+	"github.com/luno/moonbeam/storage/encrypted"
+	"github.com/luno/moonbeam/storage/filesystem"
+	"github.com/luno/moonbeam/storage/leveldb"
+	"github.com/luno/moonbeam/storage/redis"
+)
+
+var configFile = flag.String("config", "", "TOML file of flag values, e.g. testnet = false; flags can also be set with MOONBEAM_<FLAG> environment variables")
+var storageDSN = flag.String("storage", "", "Where the state is stored, e.g. file:/var/lib/moonbeam/state.json, sqlite:/var/lib/moonbeam/state.db, leveldb:/var/lib/moonbeam/state, postgres://moonbeam@localhost/moonbeam, redis://localhost:6379/0 or dynamodb:moonbeam, empty for mbserver-state.<net>.json")
+
+// envPrefix is the prefix of environment variables that set flags.
+const envPrefix = "MOONBEAM_"
+
+// loadConfig sets the flags that weren't given on the command line from the
+// environment and --config.
+func loadConfig() error {
+	path := *configFile
+	if path == "" {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	return config.Load(flag.CommandLine, path, os.Environ(), envPrefix)
+}
+
+// validateFlags checks the flags needed to serve, reporting every problem at
+// once.
+func validateFlags() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(*destination != "" || *destinationXPub != "",
+		"--destination or --destination_xpub is required")
+	check(*xprivkey != "" || *signerURL != "",
+		"--xprivkey or --signer_url is required")
+	check(*signerURL == "" || *signerSecret != "",
+		"--signer_secret is required with --signer_url")
+	check(*authToken != "", "--auth_token is required")
+	check(*chainConfigFile != "" || *chainBackend == "bitcoind" || *chainBackend == "btcd" ||
+		*chainBackend == "esplora" || *chainBackend == "neutrino",
+		"--chain_backend must be bitcoind, btcd, esplora or neutrino")
+	check(*chainConfigFile != "" || *chainBackend != "esplora" || *esploraURL != "",
+		"--esplora_url is required with --chain_backend=esplora")
+	check(*chainConfigFile != "" || *chainBackend != "neutrino" || *neutrinoPeers != "",
+		"--neutrino_peers is required with --chain_backend=neutrino")
+	check(*webhookURL == "" || *webhookSecret != "",
+		"--webhook_secret is required with --webhook_url")
+	check(*hookURL == "" || *hookSecret != "",
+		"--hook_secret is required with --hook_url")
+	check(*minFunding >= 0 && *maxFunding >= 0, "--min_funding and --max_funding can't be negative")
+	check(*maxFunding == 0 || *maxFunding >= *minFunding, "--max_funding is below --min_funding")
+	check(*commissionRate >= 0 && *commissionRate <= 10000, "--commission_bps must be between 0 and 10000")
+	check(*zeroConfMaxValue >= 0, "--zeroconf_max_value can't be negative")
+	check(*utilizationThreshold >= 0 && *utilizationThreshold <= 1,
+		"--utilization_threshold must be between 0 and 1")
+	check(*maxChannelsPerSender >= 0 && *maxCreatesPerIP >= 0,
+		"--max_channels_per_sender and --max_creates_per_ip can't be negative")
+	check(*channelRate >= 0 && *ipRate >= 0, "--channel_rate and --ip_rate can't be negative")
+	check(*keyGapLimit >= 0 && *prederiveKeys >= 0, "--key_gap_limit and --prederive_keys can't be negative")
+	check(*readTimeout > 0 && *writeTimeout > 0, "--read_timeout and --write_timeout must be positive")
+	check(*archiveAfter >= 0 && *archiveInterval > 0,
+		"--archive_after can't be negative and --archive_interval must be positive")
+	check(*purgePaymentsAfter >= 0 && *purgeInterval > 0,
+		"--purge_payments_after can't be negative and --purge_interval must be positive")
+	check(*archiveURL == "" || *purgePaymentsAfter == 0,
+		"--purge_payments_after can't be used with --archive_url, which already removes payments from the state")
+	check(*grpcListen == "" || !(*rpcRequireKey || *requireSenderSig),
+		"--grpc_listen can't be used with --rpc_require_api_key or --require_sender_sig, which gRPC calls can't carry")
+	check(*encryptionKey != "" || *extraEncryptionKeys == "",
+		"--encryption_key is required with --extra_encryption_keys")
+	if *encryptionKey != "" {
+		for _, s := range encryptionKeys() {
+			if strings.HasPrefix(s, "kms:") {
+				continue
+			}
+			key, err := hex.DecodeString(s)
+			check(err == nil && len(key) == encrypted.KeySize,
+				"encryption keys must be %d hex encoded bytes or kms:<base64 ciphertext>", encrypted.KeySize)
+		}
+	}
+	if *storageDSN != "" {
+		_, _, err := parseStorageDSN(*storageDSN)
+		check(err == nil, "%v", err)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  " + strings.Join(errs, "\n  "))
+}
+
+// parseStorageDSN returns the kind of storage of a DSN, file, sqlite,
+// leveldb, dynamodb, postgres or redis, and the path of the others than
+// postgres and redis, which is the table for dynamodb.
+func parseStorageDSN(dsn string) (string, string, error) {
+	for _, kind := range []string{"file", "sqlite", "leveldb", "dynamodb"} {
+		if path := strings.TrimPrefix(dsn, kind+":"); path != dsn && path != "" {
+			return kind, path, nil
+		}
+	}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return "postgres", "", nil
+	}
+	if strings.HasPrefix(dsn, "redis://") || strings.HasPrefix(dsn, "rediss://") {
+		return "redis", "", nil
+	}
+	return "", "", fmt.Errorf("unsupported --storage %q, expected file:<path>, sqlite:<path>, leveldb:<dir>, dynamodb:<table>, postgres://... or redis://...", dsn)
+}
+
+// openStorage opens the state of the network selected by --storage,
+// encrypted with --encryption_key.
+func openStorage(net *chaincfg.Params) (storage.Storage, error) {
+	db, err := openBackend(net)
+	if err != nil {
+		return nil, err
+	}
+	return encryptStorage(context.Background(), db)
+}
+
+// openBackend opens the storage backend selected by --storage.
+func openBackend(net *chaincfg.Params) (storage.Storage, error) {
+	if *storageDSN == "" {
+		path := fmt.Sprintf("mbserver-state.%s.json", net.Name)
+		return filesystem.NewFilesystemStorage(path), nil
+	}
+	kind, path, err := parseStorageDSN(*storageDSN)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "sqlite", "postgres":
+		db, err := openMigrated(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case "leveldb":
+		db, err := leveldb.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case "dynamodb":
+		db, err := dynamodb.Open(context.Background(), path)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case "redis":
+		// Networks sharing a database are kept apart by the prefix.
+		prefix := fmt.Sprintf("moonbeam:%s:", net.Name)
+		db, err := redis.Open(context.Background(), *storageDSN, prefix)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	}
+	return filesystem.NewFilesystemStorage(path), nil
+}
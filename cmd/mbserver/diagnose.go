@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+var diagnose = flag.Bool("diagnose", false,
+	"Check the chain backends' connectivity and capabilities, then exit")
+
+// rpcClient is the part of btcrpcclient.Client the diagnostics use.
+type rpcClient interface {
+	GetBlockCount() (int64, error)
+	RawRequest(method string, params []json.RawMessage) (json.RawMessage, error)
+}
+
+type checkResult struct {
+	Name   string
+	OK     bool
+	Fatal  bool
+	Detail string
+	Advice string
+}
+
+func rawCall(bc rpcClient, method string, params []json.RawMessage, res interface{}) error {
+	buf, err := bc.RawRequest(method, params)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, res)
+}
+
+// chainName returns the name bitcoind uses for the network.
+func chainName(net *chaincfg.Params) string {
+	switch net.Name {
+	case chaincfg.MainNetParams.Name:
+		return "main"
+	case chaincfg.TestNet3Params.Name:
+		return "test"
+	case chaincfg.RegressionNetParams.Name:
+		return "regtest"
+	default:
+		return net.Name
+	}
+}
+
+func checkConnectivity(bc rpcClient) checkResult {
+	r := checkResult{Name: "connectivity", Fatal: true}
+	n, err := bc.GetBlockCount()
+	if err != nil {
+		r.Detail = err.Error()
+		r.Advice = "Check --bitcoind_host, and --bitcoind_cookie or " +
+			"--bitcoind_username and --bitcoind_password, and that " +
+			"bitcoind has server=1 and " +
+			"rpcallowip set for this host."
+		return r
+	}
+	r.OK = true
+	r.Detail = fmt.Sprintf("block count %d", n)
+	return r
+}
+
+func checkChain(bc rpcClient, net *chaincfg.Params) checkResult {
+	r := checkResult{Name: "chain", Fatal: true}
+	var info struct {
+		Chain                string `json:"chain"`
+		Blocks               int64  `json:"blocks"`
+		Headers              int64  `json:"headers"`
+		InitialBlockDownload bool   `json:"initialblockdownload"`
+	}
+	if err := rawCall(bc, "getblockchaininfo", nil, &info); err != nil {
+		r.Detail = err.Error()
+		r.Advice = "The node must support getblockchaininfo."
+		return r
+	}
+	if info.Chain != chainName(net) {
+		r.Detail = fmt.Sprintf("node is on %q but configured for %q",
+			info.Chain, chainName(net))
+		r.Advice = "Point --bitcoind_host at a node for the right network " +
+			"or fix --testnet."
+		return r
+	}
+	if info.InitialBlockDownload || info.Blocks < info.Headers {
+		r.Detail = fmt.Sprintf("node is still syncing (%d/%d blocks)",
+			info.Blocks, info.Headers)
+		r.Advice = "Wait for the node to finish syncing before opening channels."
+		return r
+	}
+	r.OK = true
+	r.Detail = fmt.Sprintf("%s, %d blocks", info.Chain, info.Blocks)
+	return r
+}
+
+func checkTxIndex(bc rpcClient) checkResult {
+	r := checkResult{Name: "txindex"}
+	var info map[string]struct {
+		Synced bool `json:"synced"`
+	}
+	if err := rawCall(bc, "getindexinfo", nil, &info); err != nil {
+		r.Detail = err.Error()
+		r.Advice = "Unable to determine whether txindex is enabled. " +
+			"It is needed for looking up closure and refund transactions."
+		return r
+	}
+	idx, ok := info["txindex"]
+	if !ok {
+		r.Detail = "txindex is disabled"
+		r.Advice = "Set txindex=1 in bitcoin.conf and restart bitcoind."
+		return r
+	}
+	if !idx.Synced {
+		r.Detail = "txindex is still building"
+		r.Advice = "Wait for the index to finish building."
+		return r
+	}
+	r.OK = true
+	r.Detail = "enabled"
+	return r
+}
+
+func checkFeeEstimation(bc rpcClient) checkResult {
+	r := checkResult{Name: "estimatesmartfee"}
+	var res struct {
+		FeeRate float64  `json:"feerate"`
+		Errors  []string `json:"errors"`
+	}
+	params := []json.RawMessage{json.RawMessage("6")}
+	if err := rawCall(bc, "estimatesmartfee", params, &res); err != nil {
+		r.Detail = err.Error()
+		r.Advice = "The node must support estimatesmartfee for dynamic fees."
+		return r
+	}
+	if res.FeeRate <= 0 {
+		r.Detail = fmt.Sprintf("no estimate available %v", res.Errors)
+		r.Advice = "The node needs to observe more blocks before it can " +
+			"estimate fees."
+		return r
+	}
+	r.OK = true
+	r.Detail = fmt.Sprintf("%.8f BTC/kB", res.FeeRate)
+	return r
+}
+
+func checkZMQ(bc rpcClient) checkResult {
+	r := checkResult{Name: "zmq"}
+	var res []struct {
+		Type    string `json:"type"`
+		Address string `json:"address"`
+	}
+	if err := rawCall(bc, "getzmqnotifications", nil, &res); err != nil {
+		r.Detail = err.Error()
+		r.Advice = "The node doesn't support ZMQ notifications. Block " +
+			"and transaction updates will be polled."
+		return r
+	}
+	have := make(map[string]string)
+	for _, n := range res {
+		have[n.Type] = n.Address
+	}
+	for _, t := range []string{"pubrawblock", "pubrawtx"} {
+		if _, ok := have[t]; !ok {
+			r.Detail = t + " is not enabled"
+			r.Advice = "Set zmq" + t + "=tcp://127.0.0.1:28332 in " +
+				"bitcoin.conf to receive notifications instead of polling."
+			return r
+		}
+	}
+	r.OK = true
+	r.Detail = fmt.Sprintf("rawblock %s, rawtx %s",
+		have["pubrawblock"], have["pubrawtx"])
+	return r
+}
+
+// runDiagnostics checks the bitcoind connection and writes the results to
+// w. It returns false if any check failed that prevents the server from
+// running.
+func runDiagnostics(w io.Writer, bc rpcClient, net *chaincfg.Params) bool {
+	results := []checkResult{checkConnectivity(bc)}
+	if results[0].OK {
+		results = append(results,
+			checkChain(bc, net),
+			checkTxIndex(bc),
+			checkFeeEstimation(bc),
+			checkZMQ(bc))
+	}
+
+	ok := true
+	for _, r := range results {
+		status := "OK"
+		if !r.OK && r.Fatal {
+			status = "FAIL"
+			ok = false
+		} else if !r.OK {
+			status = "WARN"
+		}
+		fmt.Fprintf(w, "%-4s %-16s %s\n", status, r.Name, r.Detail)
+		if !r.OK {
+			fmt.Fprintf(w, "     %-16s %s\n", "", r.Advice)
+		}
+	}
+	return ok
+}
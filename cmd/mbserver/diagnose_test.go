@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// fakeRPC answers the diagnostics' calls from canned responses. Methods
+// without one fail like a node that doesn't have them.
+type fakeRPC struct {
+	down      bool
+	responses map[string]string
+}
+
+func (f *fakeRPC) GetBlockCount() (int64, error) {
+	if f.down {
+		return 0, errors.New("connection refused")
+	}
+	return 100, nil
+}
+
+func (f *fakeRPC) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	if f.down {
+		return nil, errors.New("connection refused")
+	}
+	res, ok := f.responses[method]
+	if !ok {
+		return nil, errors.New("-32601: Method not found")
+	}
+	return json.RawMessage(res), nil
+}
+
+func healthyRPC() *fakeRPC {
+	return &fakeRPC{responses: map[string]string{
+		"getblockchaininfo":   `{"chain":"test","blocks":100,"headers":100}`,
+		"getindexinfo":        `{"txindex":{"synced":true}}`,
+		"estimatesmartfee":    `{"feerate":0.0001}`,
+		"getzmqnotifications": `[{"type":"pubrawblock","address":"tcp://127.0.0.1:28332"},{"type":"pubrawtx","address":"tcp://127.0.0.1:28333"}]`,
+	}}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(f *fakeRPC)
+		ok     bool
+		output string
+	}{
+		{"healthy", func(f *fakeRPC) {}, true, "OK   zmq"},
+		{"unreachable", func(f *fakeRPC) { f.down = true }, false, "FAIL connectivity"},
+		{"wrong network", func(f *fakeRPC) {
+			f.responses["getblockchaininfo"] = `{"chain":"main","blocks":100,"headers":100}`
+		}, false, `node is on "main" but configured for "test"`},
+		{"syncing", func(f *fakeRPC) {
+			f.responses["getblockchaininfo"] = `{"chain":"test","blocks":10,"headers":100}`
+		}, false, "still syncing"},
+		{"no txindex", func(f *fakeRPC) {
+			f.responses["getindexinfo"] = `{}`
+		}, true, "WARN txindex          txindex is disabled"},
+		{"no fee estimation", func(f *fakeRPC) {
+			delete(f.responses, "estimatesmartfee")
+		}, true, "WARN estimatesmartfee"},
+		{"no zmq", func(f *fakeRPC) {
+			f.responses["getzmqnotifications"] = `[{"type":"pubrawblock","address":"tcp://127.0.0.1:28332"}]`
+		}, true, "pubrawtx is not enabled"},
+	}
+	for _, test := range tests {
+		f := healthyRPC()
+		test.modify(f)
+		var buf bytes.Buffer
+		ok := runDiagnostics(&buf, f, &chaincfg.TestNet3Params)
+		if ok != test.ok {
+			t.Errorf("%s: expected ok %v, got %v", test.name, test.ok, ok)
+		}
+		if !strings.Contains(buf.String(), test.output) {
+			t.Errorf("%s: expected %q in output:\n%s", test.name, test.output, buf.String())
+		}
+	}
+}
+
+func TestChainConfigFromFlags(t *testing.T) {
+	defer func(backend, host, user, fallbacks, fallbackEsp, esp, zmq string) {
+		*chainBackend, *bitcoindHost, *bitcoindUsername = backend, host, user
+		*fallbackHosts, *fallbackEsplora, *esploraURL, *zmqAddr = fallbacks, fallbackEsp, esp, zmq
+	}(*chainBackend, *bitcoindHost, *bitcoindUsername, *fallbackHosts, *fallbackEsplora, *esploraURL, *zmqAddr)
+
+	*chainBackend = "bitcoind"
+	*bitcoindHost = "node:18332"
+	*bitcoindUsername = "user"
+	*fallbackHosts = "a:18332, b:18332"
+	*fallbackEsplora = "https://esplora/api"
+	*esploraURL = "https://verify/api"
+	*zmqAddr = "tcp://node:28332"
+
+	c := chainConfigFromFlags()
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Backend != "bitcoind" || c.Host != "node:18332" || c.Username != "user" ||
+		c.Verify != "https://verify/api" || c.ZMQ != "tcp://node:28332" {
+		t.Errorf("Unexpected primary %+v", c)
+	}
+	if len(c.Fallbacks) != 3 || c.Fallbacks[1].Host != "b:18332" ||
+		c.Fallbacks[1].Username != "user" || c.Fallbacks[2].URL != "https://esplora/api" {
+		t.Errorf("Unexpected fallbacks %+v", c.Fallbacks)
+	}
+
+	// The translation round trips through the --chain_config format.
+	buf, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c2 chainConfig
+	if err := json.Unmarshal(buf, &c2); err != nil {
+		t.Fatal(err)
+	}
+	if c2.Fallbacks[0].Host != "a:18332" || c2.ZMQ != c.ZMQ {
+		t.Errorf("Unexpected decoded config %+v", c2)
+	}
+
+	*chainBackend = "esplora"
+	*fallbackHosts = ""
+	if c := chainConfigFromFlags(); c.URL != "https://verify/api" || c.Host != "" || c.validate() != nil {
+		t.Errorf("Unexpected esplora config %+v", c)
+	}
+
+	for _, bad := range []chainConfig{
+		{Backend: "electrum"},
+		{Backend: "bitcoind"},
+		{Backend: "esplora"},
+		{Backend: "esplora", URL: "u", Fallbacks: []chainConfig{{Backend: "btcd"}}},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/btcsuite/btcd/chaincfg"
+
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/encrypted"
+)
+
+var encryptionKey = flag.String("encryption_key", "",
+	"Hex encoded 32 byte master key to encrypt channel states and payments at rest, or kms:<base64 ciphertext> of a key to decrypt with AWS KMS, empty to disable")
+var extraEncryptionKeys = flag.String("extra_encryption_keys", "",
+	"Comma-separated earlier values of --encryption_key, kept until mbserver rewrap has rewrapped the data keys they wrap")
+
+// loadEncryptionKey returns a key given with --encryption_key or
+// --extra_encryption_keys, decrypting it with KMS if needed.
+func loadEncryptionKey(ctx context.Context, s string) ([]byte, error) {
+	if blob := strings.TrimPrefix(s, "kms:"); blob != s {
+		ciphertext, err := base64.StdEncoding.DecodeString(blob)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %v", err)
+		}
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out, err := kms.NewFromConfig(cfg).Decrypt(ctx, &kms.DecryptInput{
+			CiphertextBlob: ciphertext,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("decrypting encryption key with KMS: %v", err)
+		}
+		return out.Plaintext, nil
+	}
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %v", err)
+	}
+	return key, nil
+}
+
+// encryptionKeys returns the values of --encryption_key and
+// --extra_encryption_keys, the current one first.
+func encryptionKeys() []string {
+	keys := []string{*encryptionKey}
+	for _, s := range strings.Split(*extraEncryptionKeys, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			keys = append(keys, s)
+		}
+	}
+	return keys
+}
+
+// encrypt wraps db to encrypt it with the master keys.
+func encrypt(ctx context.Context, db storage.Storage) (*encrypted.Storage, error) {
+	var keys [][]byte
+	for _, s := range encryptionKeys() {
+		key, err := loadEncryptionKey(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return encrypted.New(db, keys...)
+}
+
+// encryptStorage wraps db to encrypt it with --encryption_key, if set.
+func encryptStorage(ctx context.Context, db storage.Storage) (storage.Storage, error) {
+	if *encryptionKey == "" {
+		return db, nil
+	}
+	return encrypt(ctx, db)
+}
+
+// runRewrap handles mbserver rewrap, which wraps every channel's data key
+// with --encryption_key, so that the keys in --extra_encryption_keys can be
+// retired.
+func runRewrap(w io.Writer, net *chaincfg.Params) error {
+	if *encryptionKey == "" {
+		return errors.New("--encryption_key is required")
+	}
+	ctx := context.Background()
+	db, err := openBackend(net)
+	if err != nil {
+		return err
+	}
+	es, err := encrypt(ctx, db)
+	if err != nil {
+		return err
+	}
+	n, err := es.Rewrap(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Rewrapped the data keys of %d channels with key %s\n",
+		n, es.CurrentKeyID())
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/postgres"
+	"github.com/luno/moonbeam/storage/sqlite"
+)
+
+var autoMigrate = flag.Bool("auto_migrate", true,
+	"Migrate SQL storage to the current schema on startup; when false, refuse to start until it's migrated with mbserver migrate")
+
+// schemaStorage is a storage with a versioned schema.
+type schemaStorage interface {
+	storage.Storage
+	Migrate(ctx context.Context) error
+	MigrateTo(ctx context.Context, version int) error
+	SchemaVersion(ctx context.Context) (int, int, error)
+	CheckSchema(ctx context.Context) error
+	Close() error
+}
+
+// openSchema opens the SQL storage selected by --storage without migrating
+// it.
+func openSchema(ctx context.Context) (schemaStorage, error) {
+	kind, path, err := parseStorageDSN(*storageDSN)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "sqlite":
+		return sqlite.OpenUnmigrated(ctx, path)
+	case "postgres":
+		return postgres.OpenUnmigrated(ctx, *storageDSN)
+	}
+	return nil, fmt.Errorf("--storage %s has no schema to migrate", kind)
+}
+
+// openMigrated opens the SQL storage selected by --storage, migrating it if
+// --auto_migrate is set and otherwise checking that it's been migrated.
+func openMigrated(ctx context.Context) (schemaStorage, error) {
+	db, err := openSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if *autoMigrate {
+		err = db.Migrate(ctx)
+	} else {
+		err = db.CheckSchema(ctx)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// runMigrate handles mbserver migrate [version], which migrates the schema
+// of the SQL storage up or down to the version, or the latest one.
+func runMigrate(w io.Writer, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: mbserver migrate [version]")
+	}
+	ctx := context.Background()
+	db, err := openSchema(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	from, latest, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	to := latest
+	if len(args) == 1 {
+		to, err = strconv.Atoi(args[0])
+		if err != nil || to < 0 || to > latest {
+			return fmt.Errorf("invalid version %q, expected 0 to %d", args[0], latest)
+		}
+	}
+	if err := db.MigrateTo(ctx, to); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Migrated schema from version %d to %d of %d\n", from, to, latest)
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/chain"
+	"github.com/luno/moonbeam/receiver"
+	"github.com/luno/moonbeam/storage/filesystem"
+)
+
+// networkJSON is an entry of --networks, an additional network served by
+// the same process under server.NetworkPath(Net), with its own key, chain
+// backend and state file.
+type networkJSON struct {
+	// Net is mainnet or testnet3.
+	Net string `json:"net"`
+
+	XPrivKey        string `json:"xprivkey"`
+	Destination     string `json:"destination"`
+	DestinationXPub string `json:"destinationXPub"`
+
+	// The backend is bitcoind at BitcoindHost, or Esplora at EsploraURL
+	// if BitcoindHost is empty.
+	BitcoindHost     string `json:"bitcoindHost"`
+	BitcoindUsername string `json:"bitcoindUsername"`
+	BitcoindPassword string `json:"bitcoindPassword"`
+	BitcoindCookie   string `json:"bitcoindCookie"`
+	EsploraURL       string `json:"esploraURL"`
+}
+
+// network is a receiver serving an additional network.
+type network struct {
+	r        *receiver.Receiver
+	cb       chain.Backend
+	shutdown func()
+}
+
+func parseNet(name string) (*chaincfg.Params, error) {
+	switch name {
+	case chaincfg.MainNetParams.Name:
+		return &chaincfg.MainNetParams, nil
+	case chaincfg.TestNet3Params.Name:
+		return &chaincfg.TestNet3Params, nil
+	default:
+		return nil, fmt.Errorf("unknown network %q", name)
+	}
+}
+
+// loadNetworks sets up the receivers of the networks listed in path. They
+// share the primary network's settings, auth token and webhooks.
+func loadNetworks(path string, primary *chaincfg.Params, settings receiver.Settings, logger *slog.Logger) ([]*network, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var nets []networkJSON
+	if err := json.Unmarshal(buf, &nets); err != nil {
+		return nil, err
+	}
+
+	var res []*network
+	seen := map[string]bool{primary.Name: true}
+	for _, nj := range nets {
+		if seen[nj.Net] {
+			shutdownNetworks(context.Background(), res)
+			return nil, fmt.Errorf("network %s is already served", nj.Net)
+		}
+		seen[nj.Net] = true
+		n, err := newNetwork(nj, settings, logger)
+		if err != nil {
+			shutdownNetworks(context.Background(), res)
+			return nil, fmt.Errorf("network %s: %v", nj.Net, err)
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+func newNetwork(nj networkJSON, settings receiver.Settings, logger *slog.Logger) (*network, error) {
+	net, err := parseNet(nj.Net)
+	if err != nil {
+		return nil, err
+	}
+	if nj.Destination == "" && nj.DestinationXPub == "" {
+		return nil, errors.New("destination or destinationXPub is required")
+	}
+	ek, err := hdkeychain.NewKeyFromString(nj.XPrivKey)
+	if err != nil {
+		return nil, err
+	}
+	if !ek.IsForNet(net) {
+		return nil, errors.New("xprivkey is for wrong network")
+	}
+
+	n := &network{shutdown: func() {}}
+	if nj.BitcoindHost != "" {
+		bc, err := chain.NewBitcoinCore(chain.RPCConfig{
+			Host:       nj.BitcoindHost,
+			User:       nj.BitcoindUsername,
+			Pass:       nj.BitcoindPassword,
+			CookieFile: nj.BitcoindCookie,
+		})
+		if err != nil {
+			return nil, err
+		}
+		n.cb = bc
+		n.shutdown = bc.Shutdown
+	} else if nj.EsploraURL != "" {
+		n.cb = chain.NewEsplora(nj.EsploraURL)
+	} else {
+		return nil, errors.New("bitcoindHost or esploraURL is required")
+	}
+	if err := chain.CheckNet(context.Background(), n.cb, net); err != nil {
+		n.shutdown()
+		return nil, err
+	}
+
+	path := fmt.Sprintf("mbserver-state.%s.json", net.Name)
+	s := receiver.NewReceiver(net, ek, n.cb, filesystem.NewFilesystemStorage(path),
+		settings.Directory, nj.Destination, *authToken)
+	s.SetLogger(logger.With("net", net.Name))
+	if nj.DestinationXPub != "" {
+		if err := s.SetDestinationXPub(nj.DestinationXPub); err != nil {
+			n.shutdown()
+			return nil, err
+		}
+	}
+	s.SetAllowRevocable(*allowRevocable)
+	s.SetMaxBlockAge(*maxBlockAge)
+	s.SetExpiryWarning(*expiryWarning)
+	if err := s.Reload(settings); err != nil {
+		n.shutdown()
+		return nil, err
+	}
+	if err := s.LoadKeyGenerations(context.Background()); err != nil {
+		n.shutdown()
+		return nil, err
+	}
+	n.r = s
+	return n, nil
+}
+
+// run runs the network's background workers until ctx is cancelled.
+func (n *network) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		n.r.Watch(ctx, time.Minute)
+	}()
+	go func() {
+		defer wg.Done()
+		n.r.Rebroadcast(ctx, *rebroadcastInterval)
+	}()
+	wg.Wait()
+}
+
+// handler returns the network's RPC, admin and health handlers, to be
+// mounted with server.MountNetwork.
+func (n *network) handler(logger *slog.Logger) http.Handler {
+	mux := http.NewServeMux()
+	registerAPI(mux, n.r, logger)
+	return mux
+}
+
+func shutdownNetworks(ctx context.Context, nets []*network) error {
+	var first error
+	for _, n := range nets {
+		if err := n.r.Shutdown(ctx); err != nil && first == nil {
+			first = err
+		}
+		n.shutdown()
+	}
+	return first
+}
+
+func receivers(nets []*network) []*receiver.Receiver {
+	var res []*receiver.Receiver
+	for _, n := range nets {
+		res = append(res, n.r)
+	}
+	return res
+}
@@ -1,34 +1,140 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg"
-	"github.com/btcsuite/btcrpcclient"
 	"github.com/btcsuite/btcutil/hdkeychain"
 
+	"github.com/luno/moonbeam/archive"
+	"github.com/luno/moonbeam/backup"
+	"github.com/luno/moonbeam/chain"
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/logging"
+	"github.com/luno/moonbeam/nats"
 	"github.com/luno/moonbeam/receiver"
 	"github.com/luno/moonbeam/resolver"
-	"github.com/luno/moonbeam/storage/filesystem"
+	"github.com/luno/moonbeam/server"
+	"github.com/luno/moonbeam/signer"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/trace"
 )
 
 var testnet = flag.Bool("testnet", true, "Use testnet")
+var detectNetwork = flag.Bool("detect_network", false, "Use the network of the chain backend instead of --testnet when serving")
 var destination = flag.String("destination", "", "Destination address")
+var destinationXPub = flag.String("destination_xpub", "", "Extended public key from which a fresh destination address is derived for each channel")
 var xprivkey = flag.String("xprivkey", "", "Key chain extended private key")
+var extraXprivkeys = flag.String("extra_xprivkeys", "", "Comma-separated extended private keys of earlier key generations and of the standby key to rotate to")
+var signerURL = flag.String("signer_url", "", "URL of an mbsigner daemon holding the extended private key, used instead of --xprivkey")
+var signerSecret = flag.String("signer_secret", "", "Secret shared with the mbsigner daemon")
 var bitcoindHost = flag.String("bitcoind_host", "localhost:18332", "")
 var bitcoindUsername = flag.String("bitcoind_username", "username", "")
 var bitcoindPassword = flag.String("bitcoind_password", "password", "")
+var bitcoindCookie = flag.String("bitcoind_cookie", "", "Path of bitcoind's .cookie file, used instead of --bitcoind_username and --bitcoind_password")
+var chainBackend = flag.String("chain_backend", "bitcoind", "Blockchain backend: bitcoind, btcd, esplora or neutrino")
+var esploraURL = flag.String("esplora_url", "", "Esplora API URL, used as the backend with --chain_backend=esplora and otherwise to verify channel funding")
+var fallbackHosts = flag.String("fallback_bitcoind_hosts", "", "Comma-separated bitcoind hosts to fail over to, using the same credentials")
+var fallbackEsplora = flag.String("fallback_esplora_url", "", "Esplora API URL to fail over to")
+var btcdCert = flag.String("btcd_cert", "", "btcd RPC certificate, TLS is disabled if empty")
+var neutrinoPeers = flag.String("neutrino_peers", "", "Comma-separated nodes serving compact block filters, used as the backend with --chain_backend=neutrino")
+var zmqAddr = flag.String("zmq", "", "bitcoind ZMQ address publishing rawblock and rawtx, e.g. tcp://127.0.0.1:28332")
 var listenAddr = flag.String("listen", ":3211", "Address to listen on")
+var grpcListen = flag.String("grpc_listen", "", "Address to serve the receiver API over gRPC on, with the same TLS certificate, empty to disable")
 var externalURL = flag.String("external_url", "https://example.com:3211", "External server URL")
 var domain = flag.String("domain", "example.com", "Domain to accept payments for")
 var tlsCert = flag.String("tls_cert", "tls/cert.pem", "TLS certificate")
 var tlsKey = flag.String("tls_key", "tls/key.pem", "TLS key")
+var tlsClientCA = flag.String("tls_client_ca", "", "CA certificates used to verify client certificates")
+var adminClientCert = flag.Bool("admin_client_cert", false, "Require a client certificate verified by --tls_client_ca for the admin API")
 var authToken = flag.String("auth_token", "", "Secret used to issue auth tokens, generate with openssl rand -hex 32")
+var allowRevocable = flag.Bool("allow_revocable", false, "Allow senders to open revocable channels")
+var zeroConfMaxValue = flag.Int64("zeroconf_max_value", 0, "Largest channel in satoshis accepted before the funding confirms, 0 to disable")
+var policyFile = flag.String("policy_file", "", "JSON file of acceptance rules: payment minimums, per-target price floors, per-sender throttles and funding bounds")
+var commissionRate = flag.Int64("commission_bps", 0, "Commission kept from each payment, in basis points")
+var commissionTargets = flag.String("commission_targets", "", "Comma-separated target=bps commission overrides")
+var zeroConfSenders = flag.String("zeroconf_senders", "", "Comma-separated hex sender pubkeys allowed to open zero-conf channels")
+var adminDebug = flag.Bool("admin_debug", false, "Serve pprof profiles, expvar counters and goroutine dumps under /admin/debug to callers with the admin:write scope")
+var lockProfileRate = flag.Int("lock_profile_rate", 0, "Sample 1 in this many mutex contention and blocking events for the mutex and block profiles, 0 to disable")
+var adminToken = flag.String("admin_token", "", "Token required to use the admin API under /admin, empty to only accept API keys")
+var rpcRequireKey = flag.Bool("rpc_require_api_key", false, "Require an API key with the rpc scope for the receiver API")
+var requireSenderSig = flag.Bool("require_sender_sig", false, "Require open, send and status calls to be signed by the channel's sender key")
+var apiKeyAccount = flag.String("api_key_account", "", "Restrict the key created by --create_api_key to this account's channels")
+var accountsFile = flag.String("accounts", "", "JSON file listing additional merchant accounts, each with its own destination, domain and key index")
+var createAPIKey = flag.String("create_api_key", "", "Create an API key with these comma-separated scopes (rpc, admin:read, admin:write), print it and exit")
+var export = flag.String("export", "", "Write channels or payments from the state file to stdout and exit")
+var exportFormat = flag.String("export_format", "csv", "Format of --export: csv or json, which writes an object per line")
+var exportStatus = flag.String("export_status", "", "Only export channels with this status, e.g. OPEN, and their payments")
+var eventsToken = flag.String("events_token", "", "Token required to stream channel events from /events, empty to disable")
+var webhookURL = flag.String("webhook_url", "", "URL to POST channel events to")
+var webhookSecret = flag.String("webhook_secret", "", "Secret used to sign webhook requests")
+var hookExec = flag.String("hook_exec", "", "Command run with sh -c before and after each payment, given the payment as JSON on stdin; exit status 1 rejects it")
+var hookURL = flag.String("hook_url", "", "URL to POST each payment to before and after it's accepted, signed with --hook_secret; status 403 rejects it")
+var hookSecret = flag.String("hook_secret", "", "Secret used to sign --hook_url requests")
+var hookTimeout = flag.Duration("hook_timeout", receiver.DefaultHookTimeout, "Timeout of --hook_exec and --hook_url calls")
+var hookFailOpen = flag.Bool("hook_fail_open", false, "Accept payments if --hook_exec or --hook_url fails instead of rejecting them")
+var hookNotifyOnly = flag.Bool("hook_notify_only", false, "Only call --hook_exec and --hook_url after payments are accepted")
+var natsAddr = flag.String("nats_addr", "", "NATS server address, e.g. localhost:4222, to publish channel events and payments to through JetStream, empty to disable")
+var natsToken = flag.String("nats_token", "", "Token used to authenticate with the NATS server")
+var natsSubject = flag.String("nats_subject", "moonbeam.events", "Subject prefix of published events, followed by the event type")
+var metricsListen = flag.String("metrics_listen", "", "Address to serve Prometheus metrics on, empty to disable")
+var traceSlow = flag.Duration("trace_slow", 0, "Log a trace of requests slower than this, 0 to disable")
+var logLevel = flag.String("log_level", "info", "Log level: debug, info, warn or error")
+var logRequests = flag.Bool("log_requests", true, "Log every request with its ID, channel, status and latency")
+var logJSON = flag.Bool("log_json", false, "Log as JSON instead of text")
+var monitorFunding = flag.Bool("monitor_funding", false, "Watch the funding addresses of created channels so that Open needn't query the backend, requires scantxoutset or Esplora")
+var utilizationThreshold = flag.Float64("utilization_threshold", 0, "Fraction of channel capacity at which a channel is flagged as exhausted, 0 to disable")
+var utilizationClose = flag.Bool("utilization_close", false, "Close channels that reach --utilization_threshold")
+var settleMaxBalance = flag.Int64("settle_max_balance", 0, "Close channels whose balance reaches this many satoshis, 0 to disable")
+var settleMaxAge = flag.Duration("settle_max_age", 0, "Close channels that have been open this long, 0 to disable")
+var settleDailyAt = flag.String("settle_daily_at", "", "Close channels with a balance every day at this UTC time, e.g. 02:00, empty to disable")
+var maxChannelsPerSender = flag.Int("max_channels_per_sender", 0, "Maximum number of channels that aren't closed per sender pubkey, 0 for no limit")
+var maxCreatesPerIP = flag.Int("max_creates_per_ip", 0, "Maximum number of create and open calls per client IP per hour, 0 for no limit")
+var minFunding = flag.Int64("min_funding", 0, "Smallest channel funding amount in satoshis accepted by Open, 0 for no minimum")
+var maxFunding = flag.Int64("max_funding", 0, "Largest channel funding amount in satoshis accepted by Open, 0 for no maximum")
+var maxBlockAge = flag.Duration("max_block_age", receiver.DefaultMaxBlockAge, "How long without a new block before /readyz fails")
+var channelRate = flag.Float64("channel_rate", 0, "Calls per second allowed on each channel, 0 for no limit")
+var channelBurst = flag.Int("channel_burst", 10, "Calls allowed in a burst on each channel with --channel_rate")
+var ipRate = flag.Float64("ip_rate", 0, "Channel calls per second allowed from each client IP, 0 for no limit")
+var ipBurst = flag.Int("ip_burst", 50, "Channel calls allowed in a burst from each client IP with --ip_rate")
+var debugServerRPC = flag.Bool("debug_server_rpc", false, "Log server RPC requests, same as --log_level=debug")
+var expiryWarning = flag.Int64("expiry_warning_blocks", 0, "Warn when a channel is still open this many blocks before its timeout, 0 for the network's close window")
+var rebroadcastInterval = flag.Duration("rebroadcast_interval", 10*time.Minute, "How often closure transactions missing from the mempool are resubmitted")
+var readTimeout = flag.Duration("read_timeout", 10*time.Second, "Maximum duration for reading a request")
+var writeTimeout = flag.Duration("write_timeout", 30*time.Second, "Maximum duration for writing a response")
+var keyGapLimit = flag.Int("key_gap_limit", 0, "Derive each channel's key from a new key path, reusing unopened paths once this many are outstanding, 0 to use a single key path")
+var prederiveKeys = flag.Int("prederive_keys", 100, "Number of upcoming channel keys to derive on startup, with --key_gap_limit")
+var instanceID = flag.String("instance_id", "", "Unique ID of this instance when several share the state, enables channel leases and leader election of background workers, empty for a single instance")
+var leaseTTL = flag.Duration("lease_ttl", receiver.DefaultLeaseTTL, "How long channel leases of a crashed instance block other instances, with --instance_id")
+var journalPath = flag.String("journal", "", "File to record opened channels and payments in, on different storage from the state file, so that --recover can rebuild them")
+var recoverFrom = flag.String("recover", "", "Comma-separated journal files to rebuild lost channels from into the state file, then exit")
+var backupKey = flag.String("backup_key", "", "Key that backups taken through the admin API are encrypted with, generate with openssl rand -hex 32, empty to disable backups")
+var archiveURL = flag.String("archive_url", "", "Object storage that closed channels and their payments are archived to: file:///<dir>, s3://<bucket>/<prefix> or gs://<bucket>/<prefix>, empty to disable archiving")
+var archiveAfter = flag.Duration("archive_after", 90*24*time.Hour, "How long after its last payment a closed channel is archived, with --archive_url")
+var archiveInterval = flag.Duration("archive_interval", time.Hour, "How often closed channels are archived, with --archive_url")
+var purgePaymentsAfter = flag.Duration("purge_payments_after", 0, "How long after a channel closed its payments and revocation secrets are deleted, keeping its record and the totals, 0 to keep them forever")
+var purgeInterval = flag.Duration("purge_interval", time.Hour, "How often payments are purged, with --purge_payments_after")
+var purgeDryRun = flag.Bool("purge_dry_run", false, "Only log what --purge_payments_after would delete")
+var networksFile = flag.String("networks", "", "JSON file listing additional networks to serve under /<net>, each with its own key, chain backend and state file")
+var shutdownTimeout = flag.Duration("shutdown_timeout", 30*time.Second, "How long to wait for in-flight requests on shutdown")
 
 func getnet() *chaincfg.Params {
 	if *testnet {
@@ -37,6 +143,56 @@ func getnet() *chaincfg.Params {
 	return &chaincfg.MainNetParams
 }
 
+// addExtraKeys adds the keys of --extra_xprivkeys.
+func addExtraKeys(s *receiver.Receiver) error {
+	for _, k := range strings.Split(*extraXprivkeys, ",") {
+		if k = strings.TrimSpace(k); k == "" {
+			continue
+		}
+		ek, err := hdkeychain.NewKeyFromString(k)
+		if err != nil {
+			return err
+		}
+		if err := s.AddExtendedKey(ek); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runRecover rebuilds the channels recorded in the --recover journals.
+func runRecover(s *receiver.Receiver, w io.Writer) error {
+	var entries []receiver.JournalEntry
+	for _, path := range strings.Split(*recoverFrom, ",") {
+		f, err := os.Open(strings.TrimSpace(path))
+		if err != nil {
+			return err
+		}
+		e, err := receiver.ReadJournal(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		entries = append(entries, e...)
+	}
+
+	res, err := s.Recover(context.Background(), entries)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, rc := range res {
+		if rc.Err != nil {
+			failed++
+			fmt.Fprintf(w, "%s: %v\n", rc.ID, rc.Err)
+			continue
+		}
+		fmt.Fprintf(w, "%s: %s, %d payments, balance %d\n", rc.ID, rc.Status, rc.Payments, rc.Balance)
+	}
+	fmt.Fprintf(w, "recovered %d of %d channels\n", len(res)-failed, len(res))
+	return nil
+}
+
 func loadkey(net *chaincfg.Params) (*hdkeychain.ExtendedKey, error) {
 	ek, err := hdkeychain.NewKeyFromString(*xprivkey)
 	if err != nil {
@@ -50,30 +206,300 @@ func loadkey(net *chaincfg.Params) (*hdkeychain.ExtendedKey, error) {
 	return ek, nil
 }
 
-func bitcoinClient() (*btcrpcclient.Client, error) {
-	connCfg := &btcrpcclient.ConnConfig{
-		Host:         *bitcoindHost,
-		User:         *bitcoindUsername,
-		Pass:         *bitcoindPassword,
-		HTTPPostMode: true,
-		DisableTLS:   true,
+// bitcoinClient connects to the RPC backend configured by bc.
+func bitcoinClient(bc chainConfig) (*chain.RPC, error) {
+	c := chain.RPCConfig{
+		Host:       bc.Host,
+		User:       bc.Username,
+		Pass:       bc.Password,
+		CookieFile: bc.Cookie,
+	}
+
+	var cb *chain.RPC
+	var err error
+	switch bc.Backend {
+	case "bitcoind":
+		cb, err = chain.NewBitcoinCore(c)
+	case "btcd":
+		if bc.Cert != "" {
+			c.Certificates, err = os.ReadFile(bc.Cert)
+			if err != nil {
+				return nil, err
+			}
+		}
+		cb, err = chain.NewBtcd(c)
+	default:
+		return nil, fmt.Errorf("unknown RPC chain backend %q", bc.Backend)
 	}
-	bc, err := btcrpcclient.New(connCfg, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	blockCount, _ := bc.GetBlockCount()
-	log.Printf("Connected to bitcoind. Block count = %d", blockCount)
+	blockCount, _ := cb.Client().GetBlockCount()
+	log.Printf("Connected to %s at %s. Block count = %d", bc.Backend, bc.Host, blockCount)
 
-	return bc, nil
+	return cb, nil
+}
+
+// newChainBackend returns the backend configured by c on net and a
+// function that releases it.
+func newChainBackend(c chainConfig, net *chaincfg.Params) (chain.Backend, func(), error) {
+	var clients []*chain.RPC
+	shutdown := func() {
+		for _, c := range clients {
+			c.Shutdown()
+		}
+	}
+	if c.Backend == "neutrino" {
+		n := chain.NewNeutrino(net, c.Peers)
+		log.Printf("Syncing compact block filters from %s", strings.Join(c.Peers, ", "))
+		return n, n.Shutdown, nil
+	}
+	open := func(c chainConfig) (chain.Backend, error) {
+		if c.Backend == "esplora" {
+			return chain.NewEsplora(c.URL), nil
+		}
+		bc, err := bitcoinClient(c)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, bc)
+		if c.Verify != "" {
+			return chain.Verified{Backend: bc, Secondary: chain.NewEsplora(c.Verify)}, nil
+		}
+		return bc, nil
+	}
+
+	primary, err := open(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	backends := []chain.Backend{primary}
+	for _, f := range c.Fallbacks {
+		b, err := open(f)
+		if err != nil {
+			shutdown()
+			return nil, nil, err
+		}
+		backends = append(backends, b)
+	}
+
+	if len(backends) == 1 {
+		return primary, shutdown, nil
+	}
+	return chain.NewFailover(backends...), shutdown, nil
+}
+
+// natsPublisher publishes events to JetStream subjects named after their
+// type.
+type natsPublisher struct {
+	c       *nats.Client
+	subject string
+}
+
+func (p natsPublisher) Publish(ctx context.Context, id string, t receiver.EventType, event []byte) error {
+	return p.c.Publish(ctx, p.subject+"."+string(t), id, event)
 }
 
 type ServerState struct {
-	BC       *btcrpcclient.Client
 	Receiver *receiver.Receiver
 }
 
+func parseZeroConfPolicy(maxValue int64, senders string) (receiver.ZeroConfPolicy, error) {
+	p := receiver.ZeroConfPolicy{MaxValue: maxValue}
+	for _, h := range strings.Split(senders, ",") {
+		if h == "" {
+			continue
+		}
+		pk, err := hex.DecodeString(strings.TrimSpace(h))
+		if err != nil {
+			return p, errors.New("invalid --zeroconf_senders pubkey")
+		}
+		p.Senders = append(p.Senders, pk)
+	}
+	return p, nil
+}
+
+// settlementJSON is the JSON encoding of a settlement policy.
+type settlementJSON struct {
+	MaxBalance int64  `json:"maxBalance"`
+	MaxAge     string `json:"maxAge"`
+	DailyAt    string `json:"dailyAt"`
+}
+
+func parseSettlementPolicy(maxBalance int64, maxAge time.Duration, dailyAt string) (receiver.SettlementPolicy, error) {
+	p := receiver.SettlementPolicy{MaxBalance: maxBalance, MaxAge: maxAge}
+	if dailyAt != "" {
+		t, err := time.Parse("15:04", dailyAt)
+		if err != nil {
+			return p, errors.New("invalid daily settlement time")
+		}
+		p.Daily = true
+		p.DailyAt = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return p, nil
+}
+
+// accountJSON is an entry of --accounts. Settlement, if set, overrides the
+// settlement flags for the account's channels.
+type accountJSON struct {
+	receiver.Account
+	Settlement *settlementJSON
+}
+
+func loadAccounts(r *receiver.Receiver, path string) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var accounts []accountJSON
+	if err := json.Unmarshal(buf, &accounts); err != nil {
+		return err
+	}
+	for _, a := range accounts {
+		if err := r.AddAccount(a.Account); err != nil {
+			return err
+		}
+		if a.Settlement == nil {
+			continue
+		}
+		var maxAge time.Duration
+		if a.Settlement.MaxAge != "" {
+			maxAge, err = time.ParseDuration(a.Settlement.MaxAge)
+			if err != nil {
+				return errors.New("invalid settlement max age")
+			}
+		}
+		sp, err := parseSettlementPolicy(a.Settlement.MaxBalance, maxAge, a.Settlement.DailyAt)
+		if err != nil {
+			return err
+		}
+		if err := r.SetAccountSettlementPolicy(a.ID, sp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseCommissionPolicy(rate int64, targets string) (receiver.CommissionPolicy, error) {
+	p := receiver.CommissionPolicy{Rate: rate, Targets: make(map[string]int64)}
+	for _, t := range strings.Split(targets, ",") {
+		if t == "" {
+			continue
+		}
+		i := strings.LastIndex(t, "=")
+		if i < 0 {
+			return p, errors.New("invalid --commission_targets entry")
+		}
+		bps, err := strconv.ParseInt(t[i+1:], 10, 64)
+		if err != nil {
+			return p, errors.New("invalid --commission_targets rate")
+		}
+		p.Targets[strings.TrimSpace(t[:i])] = bps
+	}
+	return p, nil
+}
+
+// runExport writes the channels or payments selected by the export flags.
+func runExport(w io.Writer) error {
+	format, ok := receiver.ParseExportFormat(*exportFormat)
+	if !ok {
+		return errors.New("invalid --export_format")
+	}
+	var f storage.ListFilter
+	if *exportStatus != "" {
+		s, ok := channels.ParseStatus(*exportStatus)
+		if !ok {
+			return errors.New("invalid --export_status")
+		}
+		f.Status = s
+	}
+
+	net := getnet()
+	db, err := openStorage(net)
+	if err != nil {
+		return err
+	}
+	r := receiver.NewReceiver(net, nil, nil, db, nil, "", "")
+
+	ctx := context.Background()
+	switch *export {
+	case "channels":
+		return r.ExportChannels(ctx, w, format, f)
+	case "payments":
+		return r.ExportPayments(ctx, w, format, f, receiver.PaymentQuery{})
+	default:
+		return errors.New("--export must be channels or payments")
+	}
+}
+
+// registerAPI registers the health, RPC and admin handlers of the receiver.
+func registerAPI(mux *http.ServeMux, s *receiver.Receiver, logger *slog.Logger) {
+	health := server.NewHealth(s)
+	health.Log = logger
+	health.Register(mux)
+
+	rpc := server.NewRPC(s)
+	rpc.Log = logger
+	if *rpcRequireKey {
+		rpc.Keys = s
+	}
+	if *channelRate > 0 {
+		rpc.ChannelLimit = server.NewRateLimiter(*channelRate, *channelBurst)
+	}
+	if *ipRate > 0 {
+		rpc.IPLimit = server.NewRateLimiter(*ipRate, *ipBurst)
+	}
+	if *maxCreatesPerIP > 0 {
+		rpc.CreateLimit = server.NewIPLimiter(*maxCreatesPerIP, time.Hour)
+	}
+	if *requireSenderSig {
+		rpc.Signatures = server.NewSigVerifier(s)
+	}
+	rpc.Register(mux)
+
+	admin := server.NewAdmin(s, *adminToken)
+	admin.Keys = s
+	admin.RequireClientCert = *adminClientCert
+	admin.Log = logger
+	admin.Reload = func() error { return reload(s) }
+	admin.Debug = *adminDebug
+	admin.Register(mux)
+}
+
+// serveGRPC serves the receiver API on --grpc_listen until ctx is cancelled.
+// The returned channel is closed once in-flight calls have finished.
+func serveGRPC(ctx context.Context, s *receiver.Receiver, logger *slog.Logger) <-chan struct{} {
+	done := make(chan struct{})
+	if *grpcListen == "" {
+		close(done)
+		return done
+	}
+	g := server.NewGRPC(s)
+	g.Log = logger
+	if *channelRate > 0 {
+		g.ChannelLimit = server.NewRateLimiter(*channelRate, *channelBurst)
+	}
+	if *ipRate > 0 {
+		g.IPLimit = server.NewRateLimiter(*ipRate, *ipBurst)
+	}
+	if *maxCreatesPerIP > 0 {
+		g.CreateLimit = server.NewIPLimiter(*maxCreatesPerIP, time.Hour)
+	}
+	c := server.DefaultConfig(*grpcListen)
+	c.TLSCert = *tlsCert
+	c.TLSKey = *tlsKey
+	c.ShutdownTimeout = *shutdownTimeout
+	log.Printf("Serving gRPC on %s", *grpcListen)
+	go func() {
+		if err := server.ServeGRPC(ctx, c, g); err != nil {
+			log.Fatal(err)
+		}
+		close(done)
+	}()
+	return done
+}
+
 func wrap(s *ServerState, h func(*ServerState, http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		h(s, w, r)
@@ -82,49 +508,314 @@ func wrap(s *ServerState, h func(*ServerState, http.ResponseWriter, *http.Reques
 
 func main() {
 	flag.Parse()
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Config: %v", err)
+	}
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *debugServerRPC {
+		level = slog.LevelDebug
+	}
+	logger := logging.New(os.Stderr, level, *logJSON)
+	slog.SetDefault(logger)
+
+	if args := flag.Args(); len(args) > 0 {
+		var err error
+		switch args[0] {
+		case "migrate":
+			err = runMigrate(os.Stdout, args[1:])
+		case "rewrap":
+			err = runRewrap(os.Stdout, getnet())
+		case "chain-config":
+			err = runChainConfig(os.Stdout, os.Stderr, getnet())
+		default:
+			err = fmt.Errorf("unknown command %q", args[0])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	if *destination == "" {
-		log.Fatalf("--destination is required")
+	chainCfg, err := loadChainConfig()
+	if err != nil {
+		log.Fatalf("Config: %v", err)
 	}
-	if *xprivkey == "" {
-		log.Fatalf("--xprivkey is required")
+
+	if *diagnose {
+		if !diagnoseChainConfig(os.Stdout, chainCfg, getnet()) {
+			os.Exit(1)
+		}
+		return
 	}
-	if *authToken == "" {
-		log.Fatalf("--auth_token is required")
+
+	if *createAPIKey != "" {
+		db, err := openStorage(getnet())
+		if err != nil {
+			log.Fatal(err)
+		}
+		key, err := receiver.CreateAPIKey(context.Background(), db,
+			*apiKeyAccount, strings.Split(*createAPIKey, ","))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(key)
+		return
+	}
+
+	if *export != "" {
+		if err := runExport(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
+	if err := validateFlags(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *lockProfileRate > 0 {
+		runtime.SetMutexProfileFraction(*lockProfileRate)
+		runtime.SetBlockProfileRate(*lockProfileRate)
+	}
+
+	if *traceSlow > 0 {
+		trace.SetTracer(&trace.LogTracer{Threshold: *traceSlow})
+	}
+
+	if chainCfg.Backend == "neutrino" && *detectNetwork {
+		log.Fatal("--detect_network can't be used with the neutrino backend")
+	}
+	cb, shutdown, err := newChainBackend(chainCfg, getnet())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdown()
+
 	net := getnet()
+	if *detectNetwork {
+		net, err = chain.DetectNet(context.Background(), cb)
+		if err != nil {
+			log.Fatalf("Detecting network: %v", err)
+		}
+		logger.Info("detected network from chain backend", "net", net.Name)
+	} else if err := chain.CheckNet(context.Background(), cb, net); err != nil {
+		log.Fatalf("Chain backend: %v", err)
+	}
+
+	var ek *hdkeychain.ExtendedKey
+	if *signerURL == "" {
+		ek, err = loadkey(net)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	db, err := openStorage(net)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	ek, err := loadkey(net)
+	settings, err := loadSettings()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	path := fmt.Sprintf("mbserver-state.%s.json", net.Name)
-	storage := filesystem.NewFilesystemStorage(path)
+	s := receiver.NewReceiver(net, ek, cb, db, settings.Directory, *destination, *authToken)
+	s.SetLogger(logger)
+	if *destinationXPub != "" {
+		if err := s.SetDestinationXPub(*destinationXPub); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *accountsFile != "" {
+		if err := loadAccounts(s, *accountsFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *signerURL != "" {
+		sc := signer.NewClient(*signerURL, *signerSecret)
+		if err := s.SetRemoteSigner(context.Background(), sc); err != nil {
+			log.Fatalf("Remote signer: %v", err)
+		}
+	} else {
+		if err := addExtraKeys(s); err != nil {
+			log.Fatal(err)
+		}
+	}
+	s.SetAllowRevocable(*allowRevocable)
+	s.SetFundingMonitor(*monitorFunding)
+	s.SetMaxBlockAge(*maxBlockAge)
+	s.SetExpiryWarning(*expiryWarning)
+	s.SetKeyGapLimit(*keyGapLimit)
+	if *instanceID != "" {
+		s.SetInstance(*instanceID, *leaseTTL)
+	}
+	if err := s.Reload(settings); err != nil {
+		log.Fatal(err)
+	}
+
+	if *recoverFrom != "" {
+		if err := runRecover(s, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *backupKey != "" {
+		key, err := backup.ParseKey(*backupKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := s.SetBackupKey(key); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *archiveURL != "" {
+		store, err := archive.Open(context.Background(), *archiveURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.SetArchive(store, *archiveAfter)
+	}
+	if err := s.SetRetentionPolicy(receiver.RetentionPolicy{
+		Payments: *purgePaymentsAfter,
+		DryRun:   *purgeDryRun,
+	}); err != nil {
+		log.Fatal(err)
+	}
+	if *journalPath != "" {
+		j, err := receiver.OpenFileJournal(*journalPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer j.Close()
+		s.SetJournal(j)
+	}
+	if err := s.LoadKeyGenerations(context.Background()); err != nil {
+		log.Fatal(err)
+	}
 
-	bc, err := bitcoinClient()
+	zc, err := parseZeroConfPolicy(*zeroConfMaxValue, *zeroConfSenders)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer bc.Shutdown()
+	s.SetZeroConfPolicy(zc)
 
-	dir := receiver.NewDirectory(*domain)
-	s := receiver.NewReceiver(net, ek, bc, storage, dir, *destination, *authToken)
+	sp, err := parseSettlementPolicy(*settleMaxBalance, *settleMaxAge, *settleDailyAt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := s.SetSettlementPolicy(sp); err != nil {
+		log.Fatal(err)
+	}
 
-	go s.WatchBlockchainForever()
+	s.SetUtilizationPolicy(receiver.UtilizationPolicy{
+		Threshold: *utilizationThreshold,
+		Close:     *utilizationClose,
+	})
 
-	ss := &ServerState{bc, s}
+	if *webhookURL != "" {
+		s.AddWebhook(receiver.Webhook{URL: *webhookURL, Secret: *webhookSecret})
+	}
 
-	http.HandleFunc("/", wrap(ss, indexHandler))
-	http.HandleFunc("/details", wrap(ss, detailsHandler))
+	for _, h := range []receiver.ExternalHook{
+		{Command: *hookExec},
+		{URL: *hookURL, Secret: *hookSecret},
+	} {
+		if h.Command == "" && h.URL == "" {
+			continue
+		}
+		h.Timeout = *hookTimeout
+		h.FailOpen = *hookFailOpen
+		h.NotifyOnly = *hookNotifyOnly
+		if err := s.AddExternalHook(h); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var nets []*network
+	if *networksFile != "" {
+		nets, err = loadNetworks(*networksFile, net, settings, logger)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var nc *nats.Client
+	if *natsAddr != "" {
+		nc = nats.NewClient(*natsAddr, *natsToken)
+		defer nc.Close()
+		s.SetPublisher(natsPublisher{nc, *natsSubject})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		if err := s.PrederiveKeys(ctx, *prederiveKeys); err != nil {
+			logger.Error("prederive keys failed", "err", err)
+		}
+	}()
+	go s.Lead(ctx, func(ctx context.Context) {
+		var wg sync.WaitGroup
+		run := func(f func()) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f()
+			}()
+		}
+		run(func() { s.Watch(ctx, time.Minute) })
+		run(func() { s.Rebroadcast(ctx, *rebroadcastInterval) })
+		if *archiveURL != "" {
+			run(func() { s.Archive(ctx, *archiveInterval) })
+		}
+		if *purgePaymentsAfter > 0 {
+			run(func() { s.RunRetention(ctx, *purgeInterval) })
+		}
+		if nc != nil {
+			run(func() { s.RunPublisher(ctx, 30*time.Second) })
+		}
+		if chainCfg.ZMQ != "" {
+			run(func() { s.SubscribeZMQ(ctx, chainCfg.ZMQ) })
+		}
+		wg.Wait()
+	})
+	if f, ok := cb.(*chain.Failover); ok {
+		go f.HealthCheck(ctx, 10*time.Second)
+	}
+	for _, n := range nets {
+		go n.r.Lead(ctx, n.run)
+	}
+
+	if *metricsListen != "" {
+		mm := http.NewServeMux()
+		mm.Handle("/metrics", s.Metrics())
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsListen, mm))
+		}()
+	}
+
+	ss := &ServerState{s}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", wrap(ss, indexHandler))
+	mux.HandleFunc("/details", wrap(ss, detailsHandler))
 
 	if *externalURL != "" {
-		http.HandleFunc(resolver.MoonbeamPath, domainHandler)
+		mux.HandleFunc(resolver.MoonbeamPath, wrap(ss, domainHandler))
+	}
+
+	registerAPI(mux, s, logger)
+	grpcDone := serveGRPC(ctx, s, logger)
+	for _, n := range nets {
+		server.MountNetwork(mux, n.r.Net.Name, n.handler(logger))
 	}
 
-	http.HandleFunc(rpcPath, wrap(ss, rpcHandler))
-	http.HandleFunc(rpcPath+"/", wrap(ss, rpcHandler))
+	if *eventsToken != "" {
+		mux.Handle("/events", server.NewEventStream(s, *eventsToken))
+	}
 
 	fullAddr := *listenAddr
 	if strings.HasPrefix(fullAddr, ":") {
@@ -132,9 +823,50 @@ func main() {
 	}
 	log.Printf("Listening on https://%s", fullAddr)
 
-	if *tlsCert == "" {
-		log.Fatal(http.ListenAndServe(*listenAddr, nil))
-	} else {
-		log.Fatal(http.ListenAndServeTLS(*listenAddr, *tlsCert, *tlsKey, nil))
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		log.Printf("Shutting down")
+		cancel()
+	}()
+
+	hupc := make(chan os.Signal, 1)
+	signal.Notify(hupc, syscall.SIGHUP)
+	go func() {
+		for range hupc {
+			for _, r := range append([]*receiver.Receiver{s}, receivers(nets)...) {
+				if err := reload(r); err != nil {
+					log.Printf("Reload of %s failed, keeping current settings: %v", r.Net.Name, err)
+				}
+			}
+		}
+	}()
+
+	c := server.DefaultConfig(*listenAddr)
+	c.TLSCert = *tlsCert
+	c.TLSKey = *tlsKey
+	c.ClientCAs = *tlsClientCA
+	c.ReadTimeout = *readTimeout
+	c.WriteTimeout = *writeTimeout
+	c.ShutdownTimeout = *shutdownTimeout
+	var h http.Handler = mux
+	if *logRequests {
+		h = server.LogRequests(logger, mux)
+	}
+	if err := server.ListenAndServe(ctx, c, h); err != nil {
+		log.Fatal(err)
+	}
+	<-grpcDone
+
+	// In-flight requests have finished. Wait for the remaining work, such
+	// as webhook deliveries and the watcher's current pass.
+	sctx, scancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer scancel()
+	if err := s.Shutdown(sctx); err != nil {
+		log.Fatalf("Shutdown: %v", err)
+	}
+	if err := shutdownNetworks(sctx, nets); err != nil {
+		log.Fatalf("Shutdown: %v", err)
 	}
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"time"
+
+	"github.com/luno/moonbeam/receiver"
+)
+
+var settingsFile = flag.String("settings_file", "", "JSON file of settings that override the equivalent flags and are reloaded, with --policy_file, on SIGHUP or POST /admin/reload")
+
+// settingsFileJSON is the format of --settings_file. Fields that are
+// omitted keep the value of their flag.
+type settingsFileJSON struct {
+	Domain               *string          `json:"domain"`
+	MinFunding           *int64           `json:"minFunding"`
+	MaxFunding           *int64           `json:"maxFunding"`
+	MaxChannelsPerSender *int             `json:"maxChannelsPerSender"`
+	CommissionBps        *int64           `json:"commissionBps"`
+	CommissionTargets    map[string]int64 `json:"commissionTargets"`
+	ChannelQuotas        []quotaJSON      `json:"channelQuotas"`
+	SenderQuotas         []quotaJSON      `json:"senderQuotas"`
+}
+
+// quotaJSON is the format of a receiver.Quota in --settings_file, e.g.
+// {"amount": 100000, "window": "24h"} or {"payments": 60, "window": "1m"}.
+type quotaJSON struct {
+	Amount   int64  `json:"amount"`
+	Payments int    `json:"payments"`
+	Window   string `json:"window"`
+}
+
+func parseQuotas(qs []quotaJSON) ([]receiver.Quota, error) {
+	var res []receiver.Quota
+	for _, q := range qs {
+		w, err := time.ParseDuration(q.Window)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, receiver.Quota{Amount: q.Amount, Payments: q.Payments, Window: w})
+	}
+	return res, nil
+}
+
+// loadSettings builds the receiver's reloadable settings from the flags,
+// --settings_file and --policy_file. The files are read again on every
+// call.
+func loadSettings() (receiver.Settings, error) {
+	var s receiver.Settings
+
+	cp, err := parseCommissionPolicy(*commissionRate, *commissionTargets)
+	if err != nil {
+		return s, err
+	}
+	dom := *domain
+	s.Funding = receiver.FundingPolicy{Min: *minFunding, Max: *maxFunding}
+	s.MaxChannelsPerSender = *maxChannelsPerSender
+
+	if *settingsFile != "" {
+		buf, err := ioutil.ReadFile(*settingsFile)
+		if err != nil {
+			return s, err
+		}
+		var f settingsFileJSON
+		if err := json.Unmarshal(buf, &f); err != nil {
+			return s, err
+		}
+		if f.Domain != nil {
+			dom = *f.Domain
+		}
+		if f.MinFunding != nil {
+			s.Funding.Min = *f.MinFunding
+		}
+		if f.MaxFunding != nil {
+			s.Funding.Max = *f.MaxFunding
+		}
+		if f.MaxChannelsPerSender != nil {
+			s.MaxChannelsPerSender = *f.MaxChannelsPerSender
+		}
+		if f.CommissionBps != nil {
+			cp.Rate = *f.CommissionBps
+		}
+		if f.CommissionTargets != nil {
+			cp.Targets = f.CommissionTargets
+		}
+		if s.Quotas.Channel, err = parseQuotas(f.ChannelQuotas); err != nil {
+			return s, err
+		}
+		if s.Quotas.Sender, err = parseQuotas(f.SenderQuotas); err != nil {
+			return s, err
+		}
+	}
+	s.Directory = receiver.NewDirectory(dom)
+	s.Commission = cp
+
+	if *policyFile != "" {
+		rules, err := receiver.LoadRules(*policyFile)
+		if err != nil {
+			return s, err
+		}
+		s.Acceptance = rules
+	}
+	return s, nil
+}
+
+// reload loads the settings again and applies them if they're valid.
+func reload(r *receiver.Receiver) error {
+	s, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	return r.Reload(s)
+}
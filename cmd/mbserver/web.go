@@ -1,14 +1,19 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
-	"sort"
+	"strings"
 
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
 	"github.com/luno/moonbeam/resolver"
+	"github.com/luno/moonbeam/server"
 	"github.com/luno/moonbeam/storage"
 )
 
@@ -71,7 +76,7 @@ off-chain payments between untrusted parties using payment channels.</p>
 {{range .ChanItems}}
 <tr>
 <td><a href="/details?id={{.ID}}">{{.ID}}</a></td>
-<td>{{.SharedState.Status}}</td>
+<td>{{.SharedState.Status}}{{if .Frozen}} (frozen){{else if .Suspended}} (suspended){{end}}</td>
 <td>{{.SharedState.Capacity}}</td>
 <td>{{.SharedState.Balance}}</td>
 <td>{{.SharedState.Count}}</td>
@@ -80,6 +85,10 @@ off-chain payments between untrusted parties using payment channels.</p>
 </tbody>
 </table>
 
+{{if .Next}}
+<p><a href="/?status={{.Status}}&amp;cursor={{.Next}}">Next</a></p>
+{{end}}
+
 ` + footer))
 
 func indexHandler(ss *ServerState, w http.ResponseWriter, r *http.Request) {
@@ -88,32 +97,45 @@ func indexHandler(ss *ServerState, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	recs, err := ss.Receiver.List()
+	var f storage.ListFilter
+	status := r.FormValue("status")
+	if status != "" {
+		s, ok := channels.ParseStatus(status)
+		if !ok {
+			http.Error(w, "invalid status", http.StatusBadRequest)
+			return
+		}
+		f.Status = s
+	}
+	if _, ok := r.Form["account"]; ok {
+		f.ByAccount, f.Account = true, r.FormValue("account")
+	}
+	for _, l := range r.Form["label"] {
+		k, v, ok := strings.Cut(l, "=")
+		if !ok {
+			http.Error(w, "invalid label", http.StatusBadRequest)
+			return
+		}
+		if f.Labels == nil {
+			f.Labels = make(map[string]string)
+		}
+		f.Labels[k] = v
+	}
+
+	recs, next, err := ss.Receiver.List(r.Context(), f, r.FormValue("cursor"), 0)
 	if err != nil {
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
 
-	sort.Sort(chanItems(recs))
-
 	c := struct {
 		ChanItems []storage.Record
-	}{recs}
+		Status    string
+		Next      string
+	}{recs, status, next}
 	render(indexT, w, c)
 }
 
-type chanItems []storage.Record
-
-func (items chanItems) Len() int {
-	return len(items)
-}
-func (items chanItems) Less(i, j int) bool {
-	return items[i].ID > items[j].ID
-}
-func (items chanItems) Swap(i, j int) {
-	items[i], items[j] = items[j], items[i]
-}
-
 var detailsT = template.Must(template.New("index").Parse(header + `
 <h1>Channel details</h1>
 
@@ -131,6 +153,9 @@ var detailsT = template.Must(template.New("index").Parse(header + `
 <tr><td><code>{{.}}</code></td></tr>
 {{end}}
 </table>
+{{if .Next}}
+<p><a href="/details?id={{.ID}}&amp;cursor={{.Next}}">Next</a></p>
+{{end}}
 {{else}}
 None
 {{end}}
@@ -138,27 +163,33 @@ None
 ` + footer))
 
 func detailsHandler(ss *ServerState, w http.ResponseWriter, r *http.Request) {
-	txid, vout, ok := splitTxIDVout(r.FormValue("id"))
+	txid, vout, ok := server.ParseChannelID(r.FormValue("id"))
 	if !ok {
 		http.NotFound(w, r)
 		return
 	}
 
-	s := ss.Receiver.Get(txid, vout)
+	s := ss.Receiver.Get(r.Context(), txid, vout)
 	if s == nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	payments, err := ss.Receiver.ListPayments(txid, vout)
+	payments, next, err := ss.Receiver.ListPaymentsPage(r.Context(), txid, vout, r.FormValue("cursor"), 0)
 	if err != nil {
 		log.Printf("error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
 	var pl []string
-	for _, p := range payments {
-		pl = append(pl, string(p))
+	for _, sp := range payments {
+		p, err := models.DecodeStoredPayment(sp.Payment)
+		if err != nil {
+			pl = append(pl, hex.EncodeToString(sp.Payment))
+			continue
+		}
+		pj, _ := json.Marshal(p)
+		pl = append(pl, string(pj))
 	}
 
 	buf, err := json.MarshalIndent(s, "", "   ")
@@ -171,14 +202,26 @@ func detailsHandler(ss *ServerState, w http.ResponseWriter, r *http.Request) {
 		ID        string
 		StateJSON string
 		Payments  []string
-	}{fmt.Sprintf("%s-%d", txid, vout), string(buf), pl}
+		Next      string
+	}{fmt.Sprintf("%s-%d", txid, vout), string(buf), pl, next}
 	render(detailsT, w, c)
 }
 
-func domainHandler(w http.ResponseWriter, r *http.Request) {
+func domainHandler(ss *ServerState, w http.ResponseWriter, r *http.Request) {
+	// Accounts' domains point at this server too, so route their senders to
+	// the account's endpoint.
+	path := server.RPCPath
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if id, ok := ss.Receiver.AccountForDomain(host); ok {
+		path = server.AccountPath(id)
+	}
+
 	d := resolver.Domain{
 		Receivers: []resolver.DomainReceiver{
-			{URL: *externalURL + rpcPath},
+			{URL: *externalURL + path},
 		},
 	}
 	json.NewEncoder(w).Encode(d)
@@ -0,0 +1,80 @@
+// Command mbsigner holds a receiver's extended private key and signs for
+// mbserver instances started with --signer_url, so that the key needn't be
+// on the host serving the API.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/logging"
+	"github.com/luno/moonbeam/server"
+	"github.com/luno/moonbeam/signer"
+)
+
+var testnet = flag.Bool("testnet", true, "Use testnet")
+var xprivkey = flag.String("xprivkey", "", "Key chain extended private key")
+var listenAddr = flag.String("listen", "127.0.0.1:3212", "Address to listen on, which should be a loopback address unless --tls_cert is set")
+var secret = flag.String("secret", "", "Secret shared with mbserver's --signer_secret, generate with openssl rand -hex 32")
+var tlsCert = flag.String("tls_cert", "", "TLS certificate, plain HTTP if empty")
+var tlsKey = flag.String("tls_key", "", "TLS key")
+var logLevel = flag.String("log_level", "info", "Log level: debug, info, warn or error")
+var logJSON = flag.Bool("log_json", false, "Log as JSON instead of text")
+
+func main() {
+	flag.Parse()
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := logging.New(os.Stderr, level, *logJSON)
+
+	if *xprivkey == "" {
+		log.Fatalf("--xprivkey is required")
+	}
+	if *secret == "" {
+		log.Fatalf("--secret is required")
+	}
+
+	net := &chaincfg.MainNetParams
+	if *testnet {
+		net = &chaincfg.TestNet3Params
+	}
+	ek, err := hdkeychain.NewKeyFromString(*xprivkey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ek.IsPrivate() {
+		log.Fatalf("--xprivkey is not a private key")
+	}
+	if !ek.IsForNet(net) {
+		log.Fatalf("xprivkey is for wrong network")
+	}
+
+	s := signer.NewServer(ek, *secret)
+	s.Log = logger
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		cancel()
+	}()
+
+	c := server.DefaultConfig(*listenAddr)
+	c.TLSCert = *tlsCert
+	c.TLSKey = *tlsKey
+	logger.Info("signer listening", "addr", *listenAddr)
+	if err := server.ListenAndServe(ctx, c, s); err != nil {
+		log.Fatal(err)
+	}
+}
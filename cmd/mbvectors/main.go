@@ -0,0 +1,15 @@
+// Command mbvectors prints the channel test vectors as JSON.
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/luno/moonbeam/testvectors"
+)
+
+func main() {
+	if err := testvectors.WriteJSON(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
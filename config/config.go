@@ -0,0 +1,260 @@
+// Package config loads a program's flags from a config file and the
+// environment, so that deployments needn't pass dozens of flags.
+//
+// The file is a flat subset of TOML. Each line sets a flag:
+//
+//	# Comments start with a hash.
+//	testnet = false
+//	listen = ":3211"
+//	zeroconf_senders = ["02aa...", "03bb..."]
+//
+//	[bitcoind]
+//	host = "localhost:8332"
+//	cookie = "/var/lib/bitcoind/.cookie"
+//
+// Keys in a [section] are prefixed with the section name, so the last two
+// lines set --bitcoind_host and --bitcoind_cookie. Dashes in keys are read
+// as underscores. Arrays are joined with commas, for flags that take
+// comma-separated lists.
+//
+// Each flag can also be set with an environment variable named after it in
+// upper case with a prefix, e.g. MOONBEAM_BITCOIND_PASSWORD. Flags given on
+// the command line take precedence over the environment, which takes
+// precedence over the file.
+//
+// Loading is strict: unknown keys and variables, keys set twice and invalid
+// values are errors that name the file and line or the variable.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Load sets the flags of fs that weren't set on the command line from env,
+// a list of key=value pairs as returned by os.Environ, and the file at path,
+// if it isn't empty. Only variables starting with envPrefix are considered.
+func Load(fs *flag.FlagSet, path string, env []string, envPrefix string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	fromEnv, err := parseEnv(fs, env, envPrefix)
+	if err != nil {
+		return err
+	}
+
+	var fromFile []setting
+	if path != "" {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fromFile, err = parse(path, string(buf))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, s := range fromFile {
+		if fs.Lookup(s.key) == nil {
+			return fmt.Errorf("%s: unknown setting %q", s.pos, s.key)
+		}
+		if explicit[s.key] || fromEnv[s.key] != nil {
+			continue
+		}
+		if err := fs.Set(s.key, s.value); err != nil {
+			return fmt.Errorf("%s: invalid value for %s: %v", s.pos, s.key, err)
+		}
+	}
+	for _, s := range fromEnv {
+		if explicit[s.key] {
+			continue
+		}
+		if err := fs.Set(s.key, s.value); err != nil {
+			return fmt.Errorf("%s: invalid value: %v", s.pos, err)
+		}
+	}
+	return nil
+}
+
+// setting is a flag value read from the file or the environment. pos
+// identifies where it was read, for errors.
+type setting struct {
+	key   string
+	value string
+	pos   string
+}
+
+// parseEnv returns the settings of the environment variables starting with
+// prefix, by flag name.
+func parseEnv(fs *flag.FlagSet, env []string, prefix string) (map[string]*setting, error) {
+	res := make(map[string]*setting)
+	if prefix == "" {
+		return res, nil
+	}
+	for _, kv := range env {
+		i := strings.Index(kv, "=")
+		if i < 0 || !strings.HasPrefix(kv[:i], prefix) {
+			continue
+		}
+		name := strings.ToLower(kv[len(prefix):i])
+		if fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown environment variable %s", kv[:i])
+		}
+		res[name] = &setting{key: name, value: kv[i+1:], pos: kv[:i]}
+	}
+	return res, nil
+}
+
+// parse parses a config file's contents. name is used in errors.
+func parse(name, content string) ([]setting, error) {
+	var res []setting
+	seen := make(map[string]bool)
+	var section string
+	for i, line := range strings.Split(content, "\n") {
+		pos := fmt.Sprintf("%s:%d", name, i+1)
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			j := strings.Index(line, "]")
+			if j < 0 || !isComment(line[j+1:]) {
+				return nil, fmt.Errorf("%s: invalid section", pos)
+			}
+			section = normalizeKey(strings.TrimSpace(line[1:j]))
+			if section == "" {
+				return nil, fmt.Errorf("%s: invalid section", pos)
+			}
+			continue
+		}
+
+		j := strings.Index(line, "=")
+		if j < 0 {
+			return nil, fmt.Errorf("%s: expected key = value", pos)
+		}
+		key := normalizeKey(strings.TrimSpace(line[:j]))
+		if key == "" {
+			return nil, fmt.Errorf("%s: missing key", pos)
+		}
+		if section != "" {
+			key = section + "_" + key
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("%s: %s is set twice", pos, key)
+		}
+		seen[key] = true
+
+		value, err := parseValue(strings.TrimSpace(line[j+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pos, err)
+		}
+		res = append(res, setting{key: key, value: value, pos: pos})
+	}
+	return res, nil
+}
+
+func normalizeKey(k string) string {
+	k = strings.Trim(k, `"`)
+	return strings.ReplaceAll(k, "-", "_")
+}
+
+func isComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == '#'
+}
+
+// parseValue parses a string, number, boolean or array of them, followed
+// by an optional comment.
+func parseValue(s string) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("missing value")
+	}
+	if s[0] == '[' {
+		return parseArray(s)
+	}
+	v, rest, err := scanScalar(s)
+	if err != nil {
+		return "", err
+	}
+	if !isComment(rest) {
+		return "", fmt.Errorf("unexpected %q after value", strings.TrimSpace(rest))
+	}
+	return v, nil
+}
+
+func parseArray(s string) (string, error) {
+	var items []string
+	rest := strings.TrimSpace(s[1:])
+	for {
+		if rest == "" {
+			return "", fmt.Errorf("unterminated array")
+		}
+		if rest[0] == ']' {
+			rest = rest[1:]
+			break
+		}
+		v, r, err := scanScalar(rest)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, v)
+		rest = strings.TrimSpace(r)
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+		} else if !strings.HasPrefix(rest, "]") {
+			return "", fmt.Errorf("expected , or ] in array")
+		}
+	}
+	if !isComment(rest) {
+		return "", fmt.Errorf("unexpected %q after value", strings.TrimSpace(rest))
+	}
+	return strings.Join(items, ","), nil
+}
+
+// scanScalar reads a quoted string or a bare number or boolean from the
+// start of s and returns it and the rest of s.
+func scanScalar(s string) (string, string, error) {
+	switch s[0] {
+	case '"':
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+				continue
+			}
+			if s[i] == '"' {
+				v, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("invalid string %s", s[:i+1])
+				}
+				return v, s[i+1:], nil
+			}
+		}
+		return "", "", fmt.Errorf("unterminated string")
+	case '\'':
+		i := strings.Index(s[1:], "'")
+		if i < 0 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : i+1], s[i+2:], nil
+	}
+
+	end := strings.IndexAny(s, " \t,]#")
+	if end < 0 {
+		end = len(s)
+	}
+	v := s[:end]
+	if v != "true" && v != "false" {
+		if _, err := strconv.ParseFloat(strings.ReplaceAll(v, "_", ""), 64); err != nil {
+			return "", "", fmt.Errorf("invalid value %q, strings must be quoted", v)
+		}
+		v = strings.ReplaceAll(v, "_", "")
+	}
+	return v, s[end:], nil
+}
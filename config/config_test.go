@@ -0,0 +1,113 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testFlags struct {
+	fs       *flag.FlagSet
+	testnet  *bool
+	listen   *string
+	host     *string
+	password *string
+	senders  *string
+	maxValue *int64
+	timeout  *time.Duration
+}
+
+func newTestFlags() testFlags {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	return testFlags{
+		fs:       fs,
+		testnet:  fs.Bool("testnet", true, ""),
+		listen:   fs.String("listen", ":3211", ""),
+		host:     fs.String("bitcoind_host", "localhost:18332", ""),
+		password: fs.String("bitcoind_password", "password", ""),
+		senders:  fs.String("zeroconf_senders", "", ""),
+		maxValue: fs.Int64("zeroconf_max_value", 0, ""),
+		timeout:  fs.Duration("read_timeout", 10*time.Second, ""),
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "mbserver.toml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `
+# Production receiver.
+testnet = false
+listen = ":443" # all interfaces
+zeroconf-senders = ["02aa", '03bb']
+zeroconf_max_value = 100_000
+read_timeout = "5s"
+
+[bitcoind]
+host = "node:8332"
+password = "from file"
+`)
+	f := newTestFlags()
+	if err := f.fs.Parse([]string{"--listen=:8443"}); err != nil {
+		t.Fatal(err)
+	}
+	env := []string{"HOME=/root", "MOONBEAM_BITCOIND_PASSWORD=from env"}
+	if err := Load(f.fs, path, env, "MOONBEAM_"); err != nil {
+		t.Fatal(err)
+	}
+
+	if *f.testnet {
+		t.Errorf("Expected testnet to be set from file")
+	}
+	if *f.listen != ":8443" {
+		t.Errorf("Expected command line to take precedence, got %q", *f.listen)
+	}
+	if *f.host != "node:8332" {
+		t.Errorf("Expected section key, got %q", *f.host)
+	}
+	if *f.password != "from env" {
+		t.Errorf("Expected environment to take precedence over file, got %q", *f.password)
+	}
+	if *f.senders != "02aa,03bb" {
+		t.Errorf("Expected array to be joined, got %q", *f.senders)
+	}
+	if *f.maxValue != 100000 || *f.timeout != 5*time.Second {
+		t.Errorf("Unexpected values %d %v", *f.maxValue, *f.timeout)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		env     []string
+		err     string
+	}{
+		{"unknown key", "foo = 1", nil, `mbserver.toml:1: unknown setting "foo"`},
+		{"unknown section key", "\n[bitcoind]\nuser = \"x\"", nil, `mbserver.toml:3: unknown setting "bitcoind_user"`},
+		{"bad value", "zeroconf_max_value = \"lots\"", nil, "mbserver.toml:1: invalid value for zeroconf_max_value"},
+		{"unquoted string", "listen = :443", nil, "mbserver.toml:1: invalid value \":443\""},
+		{"duplicate", "testnet = true\ntestnet = false", nil, "mbserver.toml:2: testnet is set twice"},
+		{"missing equals", "testnet", nil, "mbserver.toml:1: expected key = value"},
+		{"unterminated", `listen = ":443`, nil, "unterminated string"},
+		{"trailing", `listen = ":443" x`, nil, "unexpected"},
+		{"unknown env", "", []string{"MOONBEAM_LISTN=:1"}, "unknown environment variable MOONBEAM_LISTN"},
+		{"bad env", "", []string{"MOONBEAM_TESTNET=maybe"}, "MOONBEAM_TESTNET: invalid value"},
+	}
+	for _, test := range tests {
+		path := writeConfig(t, test.content)
+		f := newTestFlags()
+		err := Load(f.fs, path, test.env, "MOONBEAM_")
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+// Package keytest provides deterministic keys, wallets and channel fixtures
+// for tests and examples. None of the keys must ever be used for real funds.
+package keytest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
+)
+
+// Key returns the n-th deterministic private key.
+func Key(n int) *btcec.PrivateKey {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(n))
+	seed := sha256.Sum256(append([]byte("moonbeam keytest"), buf[:]...))
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), seed[:])
+	return privKey
+}
+
+// PubKey returns the compressed public key of the n-th key.
+func PubKey(n int) []byte {
+	return Key(n).PubKey().SerializeCompressed()
+}
+
+// WIF returns the n-th key encoded for net.
+func WIF(n int, net *chaincfg.Params) string {
+	wif, err := btcutil.NewWIF(Key(n), net, true)
+	if err != nil {
+		panic(err)
+	}
+	return wif.String()
+}
+
+// Address returns the P2PKH address of the n-th key for net.
+func Address(n int, net *chaincfg.Params) string {
+	addr, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(PubKey(n)), net)
+	if err != nil {
+		panic(err)
+	}
+	return addr.String()
+}
+
+// UTXO is an unspent output owned by a Wallet.
+type UTXO struct {
+	OutPoint wire.OutPoint
+	Value    int64
+	PkScript []byte
+}
+
+// Wallet is a regtest-style wallet holding fake, pre-funded outputs. The
+// transactions it creates are properly signed, but its initial outputs don't
+// exist on any chain.
+type Wallet struct {
+	Key   *btcec.PrivateKey
+	Net   *chaincfg.Params
+	UTXOs []UTXO
+}
+
+// NewWallet returns a wallet for the n-th key with one output per amount.
+func NewWallet(n int, net *chaincfg.Params, amounts ...int64) *Wallet {
+	addr, err := btcutil.DecodeAddress(Address(n, net), net)
+	if err != nil {
+		panic(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		panic(err)
+	}
+
+	w := &Wallet{Key: Key(n), Net: net}
+	for i, amount := range amounts {
+		hash := chainhash.HashH([]byte(addr.String() + string(rune(i))))
+		w.UTXOs = append(w.UTXOs, UTXO{
+			OutPoint: wire.OutPoint{Hash: hash, Index: uint32(i)},
+			Value:    amount,
+			PkScript: pkScript,
+		})
+	}
+	return w
+}
+
+// Fund returns a signed transaction that spends the wallet's first output
+// paying amount to addr at output 0, and any change back to the wallet at
+// output 1. The change output replaces the spent output in the wallet.
+func (w *Wallet) Fund(t testing.TB, addr string, amount, fee int64) *wire.MsgTx {
+	t.Helper()
+
+	if len(w.UTXOs) == 0 {
+		t.Fatal("keytest: wallet has no outputs")
+	}
+	utxo := w.UTXOs[0]
+	if utxo.Value < amount+fee {
+		t.Fatal("keytest: insufficient wallet funds")
+	}
+
+	dest, err := btcutil.DecodeAddress(addr, w.Net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	destScript, err := txscript.PayToAddrScript(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := wire.NewMsgTx(1)
+	tx.AddTxIn(wire.NewTxIn(&utxo.OutPoint, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(amount, destScript))
+	change := utxo.Value - amount - fee
+	if change > 0 {
+		tx.AddTxOut(wire.NewTxOut(change, utxo.PkScript))
+	}
+
+	sigScript, err := txscript.SignatureScript(
+		tx, 0, utxo.PkScript, txscript.SigHashAll, w.Key, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.TxIn[0].SignatureScript = sigScript
+
+	w.UTXOs = w.UTXOs[1:]
+	if change > 0 {
+		w.UTXOs = append(w.UTXOs, UTXO{
+			OutPoint: wire.OutPoint{Hash: tx.TxHash(), Index: 1},
+			Value:    change,
+			PkScript: utxo.PkScript,
+		})
+	}
+
+	return tx
+}
+
+// Key indexes used by the channel fixtures.
+const (
+	SenderKey   = 0
+	ReceiverKey = 1
+	WalletKey   = 2
+)
+
+// Channel is a sender and receiver pair with a funded channel between them.
+type Channel struct {
+	Sender    *channels.Sender
+	Receiver  *channels.Receiver
+	FundingTx *wire.MsgTx
+}
+
+// newCreated returns a testnet sender and receiver pair whose channel has
+// been created but not funded, and the channel's funding address.
+func newCreated(t testing.TB) (*channels.Sender, *channels.Receiver, string) {
+	t.Helper()
+
+	net := &chaincfg.TestNet3Params
+
+	s, err := channels.NewSender(channels.DefaultSenderConfig, Key(SenderKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createReq, err := s.GetCreateRequest(Address(SenderKey, net))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := channels.NewReceiver(channels.DefaultReceiverConfig,
+		Address(ReceiverKey, net), Key(ReceiverKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createResp, err := r.Create(createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GotCreateResponse(createResp); err != nil {
+		t.Fatal(err)
+	}
+	return s, r, createResp.FundingAddress
+}
+
+// NewChannel creates and opens a testnet channel with the given capacity
+// using the SenderKey and ReceiverKey fixtures.
+func NewChannel(t testing.TB, capacity int64) *Channel {
+	t.Helper()
+
+	net := &chaincfg.TestNet3Params
+	s, r, fundingAddress := newCreated(t)
+
+	w := NewWallet(WalletKey, net, capacity+100000)
+	fundingTx := w.Fund(t, fundingAddress, capacity, 10000)
+
+	openReq, err := s.GetOpenRequest(fundingTx.TxHash().String(), 0, capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openResp, err := r.Open(fundingTx.TxOut[0], openReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GotOpenResponse(openResp); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Channel{Sender: s, Receiver: r, FundingTx: fundingTx}
+}
+
+// Pay sends a payment of amount to the ReceiverKey fixture's address over
+// the channel, with the channel's next counter, and returns it.
+func (c *Channel) Pay(t testing.TB, amount int64) []byte {
+	t.Helper()
+
+	target := Address(ReceiverKey, &chaincfg.TestNet3Params)
+	payment := Payment(t, amount, target, c.Sender.State.Count+1)
+	req, err := c.Sender.GetSendRequest(amount, payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Receiver.Send(amount, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Sender.GotSendResponse(amount, payment, resp); err != nil {
+		t.Fatal(err)
+	}
+	return payment
+}
+
+// Close closes the channel and returns the signed closure transaction.
+func (c *Channel) Close(t testing.TB) []byte {
+	t.Helper()
+
+	req, err := c.Sender.GetCloseRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Receiver.Close(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Sender.GotCloseResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.CloseTx
+}
+
+// State returns a valid receiver state at the given status. Open and later
+// states have a single payment of 1000 satoshis.
+func State(t testing.TB, status channels.Status) channels.SharedState {
+	t.Helper()
+
+	if status == channels.StatusCreated {
+		_, r, _ := newCreated(t)
+		return r.State
+	}
+
+	c := NewChannel(t, 1000000)
+	c.Pay(t, 1000)
+	if status == channels.StatusOpen {
+		return c.Receiver.State
+	}
+
+	c.Close(t)
+	if status == channels.StatusClosed {
+		if err := c.Receiver.CloseMined(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c.Receiver.State
+}
+
+// Payment returns the encoded payment of amount to target that is the
+// counter-th on its channel. Its nonce is derived from the counter.
+func Payment(t testing.TB, amount int64, target string, counter int) []byte {
+	t.Helper()
+
+	buf, err := models.EncodePayment(models.Payment{
+		Amount:  amount,
+		Target:  target,
+		Nonce:   fmt.Sprintf("keytest-%d", counter),
+		Counter: counter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
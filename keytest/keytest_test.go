@@ -0,0 +1,83 @@
+package keytest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
+)
+
+func TestKeysDeterministic(t *testing.T) {
+	if !bytes.Equal(PubKey(0), PubKey(0)) {
+		t.Errorf("Expected the same key")
+	}
+	if bytes.Equal(PubKey(0), PubKey(1)) {
+		t.Errorf("Expected different keys")
+	}
+	addr := Address(0, &chaincfg.RegressionNetParams)
+	if addr[0] != 'm' && addr[0] != 'n' {
+		t.Errorf("Unexpected regtest address %s", addr)
+	}
+}
+
+func TestWalletFund(t *testing.T) {
+	net := &chaincfg.RegressionNetParams
+	w := NewWallet(WalletKey, net, 100000)
+
+	tx := w.Fund(t, Address(0, net), 50000, 1000)
+
+	prev := w.UTXOs[0]
+	if prev.Value != 49000 || prev.OutPoint.Hash != tx.TxHash() {
+		t.Errorf("Unexpected change output %+v", prev)
+	}
+
+	pkScript := NewWallet(WalletKey, net, 100000).UTXOs[0].PkScript
+	engine, err := txscript.NewEngine(pkScript, tx, 0, txscript.StandardVerifyFlags, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Execute(); err != nil {
+		t.Errorf("Invalid funding tx: %v", err)
+	}
+}
+
+func TestChannelPay(t *testing.T) {
+	c := NewChannel(t, 1000000)
+	for i := 1; i <= 2; i++ {
+		buf := c.Pay(t, 1000)
+		p, err := models.DecodePayment(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Amount != 1000 || p.Counter != i || p.Nonce == "" {
+			t.Errorf("Unexpected payment %+v", p)
+		}
+	}
+	if c.Receiver.State.Count != 2 || c.Receiver.State.Balance != 2000 {
+		t.Errorf("Unexpected receiver state %+v", c.Receiver.State)
+	}
+}
+
+func TestState(t *testing.T) {
+	for _, status := range []channels.Status{
+		channels.StatusCreated,
+		channels.StatusOpen,
+		channels.StatusClosing,
+		channels.StatusClosed,
+	} {
+		s := State(t, status)
+		if s.Status != status {
+			t.Errorf("Expected status %s, got %s", status, s.Status)
+		}
+		if err := channels.CheckTransition(s, s); err != nil {
+			t.Errorf("Invalid %s state: %v", status, err)
+		}
+		if s.ReceiverOutput != Address(ReceiverKey, &chaincfg.TestNet3Params) || len(s.ReceiverPubKey) == 0 {
+			t.Errorf("Expected the receiver's %s state, got %+v", status, s)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Package logging sets up structured, leveled logging and redacts secrets
+// such as signatures and keys from log output.
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveSuffixes are lower case attribute key suffixes whose values are
+// never logged.
+var sensitiveSuffixes = []string{
+	"sig",
+	"signature",
+	"privkey",
+	"secret",
+	"token",
+	"password",
+	"xprivkey",
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveSuffixes {
+		if strings.HasSuffix(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExtendedPrivKey reports whether s looks like a serialized extended
+// private key.
+func isExtendedPrivKey(s string) bool {
+	return len(s) > 100 && (strings.HasPrefix(s, "xprv") || strings.HasPrefix(s, "tprv"))
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		out := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			out[i] = redactAttr(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(out...)}
+	}
+	if isSensitive(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	if a.Value.Kind() == slog.KindString && isExtendedPrivKey(a.Value.String()) {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+type redactHandler struct {
+	h slog.Handler
+}
+
+// Redact wraps h so that sensitive attributes are replaced before they are
+// written.
+func Redact(h slog.Handler) slog.Handler {
+	return redactHandler{h}
+}
+
+func (r redactHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return r.h.Enabled(ctx, l)
+}
+
+func (r redactHandler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, rec.Message, rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	if id := RequestID(ctx); id != "" {
+		out.AddAttrs(slog.String("request_id", id))
+	}
+	return r.h.Handle(ctx, out)
+}
+
+func (r redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = redactAttr(a)
+	}
+	return redactHandler{r.h.WithAttrs(out)}
+}
+
+func (r redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{r.h.WithGroup(name)}
+}
+
+// ParseLevel parses one of debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", s)
+	}
+	return l, nil
+}
+
+// New returns a logger that writes records at level or above to w, as JSON
+// if json is set and as text otherwise. Sensitive attributes are redacted.
+func New(w io.Writer, level slog.Level, json bool) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	if json {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(Redact(h))
+}
+
+// RedactJSON returns a JSON document with the values of sensitive fields
+// replaced. It is used to log request and response bodies. Documents that
+// can't be parsed are omitted entirely.
+func RedactJSON(buf []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return redacted
+	}
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return redacted
+	}
+	return string(out)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, fv := range t {
+			if isSensitive(k) {
+				t[k] = redacted
+			} else {
+				t[k] = redactValue(fv)
+			}
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = redactValue(t[i])
+		}
+	case string:
+		if isExtendedPrivKey(t) {
+			return redacted
+		}
+	}
+	return v
+}
@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, slog.LevelInfo, false)
+
+	l.With("authToken", "abc").Info("test",
+		"channel", "txid-0",
+		"senderSig", "3044aa",
+		"RevocationSecret", "ff00",
+		slog.Group("req", "commitmentSig", "3045bb", "amount", 1000),
+		"key", "tprv"+strings.Repeat("a", 107))
+
+	out := buf.String()
+	for _, s := range []string{"abc", "3044aa", "ff00", "3045bb", "tprv"} {
+		if strings.Contains(out, s) {
+			t.Errorf("%q not redacted: %s", s, out)
+		}
+	}
+	for _, s := range []string{"channel=txid-0", "req.amount=1000"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Expected %q in output: %s", s, out)
+		}
+	}
+}
+
+func TestLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, slog.LevelWarn, true)
+	l.Info("hidden")
+	l.Warn("shown")
+
+	if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), "shown") {
+		t.Errorf("Unexpected output: %s", buf.String())
+	}
+
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("Expected error for invalid level")
+	}
+	if l, err := ParseLevel("debug"); err != nil || l != slog.LevelDebug {
+		t.Errorf("Unexpected level %v: %v", l, err)
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	in := `{"txid":"abc","senderSig":"c2ln","payments":[{"revocationSecret":"x","amount":1}]}`
+	out := RedactJSON([]byte(in))
+	if strings.Contains(out, "c2ln") || strings.Contains(out, `"x"`) {
+		t.Errorf("Not redacted: %s", out)
+	}
+	if !strings.Contains(out, `"txid":"abc"`) || !strings.Contains(out, `"amount":1`) {
+		t.Errorf("Unexpected output: %s", out)
+	}
+	if RedactJSON([]byte("not json")) != redacted {
+		t.Errorf("Expected invalid JSON to be redacted")
+	}
+}
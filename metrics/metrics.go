@@ -0,0 +1,239 @@
+// Package metrics implements counters and histograms exposed in the
+// Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are histogram buckets suitable for request latencies in
+// seconds.
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// Registry holds a set of metrics and serves them over HTTP.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes all metrics in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	ms := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	for _, m := range ms {
+		m.write(w)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Write(w)
+}
+
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d desc) header(w io.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, d.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, typ)
+}
+
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic("metrics: wrong number of label values for " + d.name)
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelString formats label pairs. Extra pairs are appended after the
+// metric's own labels.
+func (d desc) labelString(key string, extra ...string) string {
+	var pairs []string
+	if len(d.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, d.labels[i]+"="+strconv.Quote(v))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+strconv.Quote(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a monotonically increasing value, partitioned by labels.
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		desc:   desc{name, help, labels},
+		values: make(map[string]float64),
+	}
+	r.add(c)
+	return c
+}
+
+// Add increases the counter for the label values by v.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	k := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[k] += v
+}
+
+// Inc increases the counter for the label values by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.header(w, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(k), formatFloat(c.values[k]))
+	}
+}
+
+// GaugeFunc is a gauge whose values are computed when the metrics are
+// collected.
+type GaugeFunc struct {
+	desc
+	f func() map[string]float64
+}
+
+// NewGaugeFunc registers a gauge with a single label. f returns the value
+// for each label value.
+func (r *Registry) NewGaugeFunc(name, help, label string, f func() map[string]float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name, help, []string{label}}, f: f}
+	r.add(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.header(w, "gauge")
+	values := g.f()
+	for _, k := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelString(k), formatFloat(values[k]))
+	}
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Histogram counts observations in buckets, partitioned by labels.
+type Histogram struct {
+	desc
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds,
+// which must be sorted.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name, help, labels},
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+	r.add(h)
+	return h
+}
+
+// Observe adds an observation for the label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	k := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hv, ok := h.values[k]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hv
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.header(w, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var keys []string
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		hv := h.values[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n",
+				h.name, h.labelString(k, "le", formatFloat(b)), hv.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n",
+			h.name, h.labelString(k, "le", "+Inf"), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(k), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(k), hv.count)
+	}
+}
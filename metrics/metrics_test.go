@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.NewCounter("test_total", "A counter.", "reason")
+	c.Inc("b")
+	c.Inc("a")
+	c.Add(2, "a")
+
+	h := r.NewHistogram("test_seconds", "A histogram.", []float64{1, 5})
+	h.Observe(0.5)
+	h.Observe(3)
+	h.Observe(10)
+
+	r.NewGaugeFunc("test_channels", "A gauge.", "status", func() map[string]float64 {
+		return map[string]float64{"OPEN": 2}
+	})
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+
+	expected := `# HELP test_total A counter.
+# TYPE test_total counter
+test_total{reason="a"} 3
+test_total{reason="b"} 1
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="1"} 1
+test_seconds_bucket{le="5"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 13.5
+test_seconds_count 3
+# HELP test_channels A gauge.
+# TYPE test_channels gauge
+test_channels{status="OPEN"} 2
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "A counter.", "reason")
+	c.Inc(`say "hi"`)
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+	if !strings.Contains(buf.String(), `test_total{reason="say \"hi\""} 1`) {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+}
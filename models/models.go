@@ -6,6 +6,14 @@ type CreateRequest struct {
 
 	SenderPubKey []byte `json:"senderPubKey"`
 	SenderOutput string `json:"senderOutput"`
+
+	AppData []byte `json:"appData,omitempty"`
+
+	Revocable bool `json:"revocable,omitempty"`
+
+	// Labels are stored with the channel once it's opened, e.g. to map it
+	// to a customer.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 type CreateResponse struct {
@@ -14,11 +22,18 @@ type CreateResponse struct {
 	Timeout int64  `json:"timeout"`
 	Fee     int64  `json:"fee"`
 
+	MinPayment int64 `json:"minPayment,omitempty"`
+	MaxPayment int64 `json:"maxPayment,omitempty"`
+
 	ReceiverPubKey []byte `json:"receiverPubKey"`
 	ReceiverOutput string `json:"receiverOutput"`
 
 	FundingAddress string `json:"fundingAddress"`
 
+	AppData []byte `json:"appData,omitempty"`
+
+	Revocable bool `json:"revocable,omitempty"`
+
 	ReceiverData []byte `json:"receiverData"`
 }
 
@@ -33,22 +48,38 @@ type OpenRequest struct {
 	Timeout int64  `json:"timeout"`
 	Fee     int64  `json:"fee"`
 
+	MinPayment int64 `json:"minPayment,omitempty"`
+	MaxPayment int64 `json:"maxPayment,omitempty"`
+
 	SenderPubKey []byte `json:"senderPubKey"`
 	SenderOutput string `json:"senderOutput"`
 
 	ReceiverPubKey []byte `json:"receiverPubKey"`
 	ReceiverOutput string `json:"receiverOutput"`
 
+	AppData []byte `json:"appData,omitempty"`
+
+	Revocable      bool   `json:"revocable,omitempty"`
+	RevocationHash []byte `json:"revocationHash,omitempty"`
+
 	SenderSig []byte `json:"senderSig"`
 }
 
 type OpenResponse struct {
 	AuthToken string `json:"authToken"`
+
+	CommitmentSig []byte `json:"commitmentSig,omitempty"`
 }
 
 type Payment struct {
 	Amount int64  `json:"amount"`
 	Target string `json:"target"`
+
+	// Nonce is a unique random value and Counter is the channel's payment
+	// count after this payment. The receiver rejects payments that reuse a
+	// nonce or have the wrong counter.
+	Nonce   string `json:"nonce"`
+	Counter int    `json:"counter"`
 }
 
 type ValidateRequest struct {
@@ -60,6 +91,70 @@ type ValidateRequest struct {
 
 type ValidateResponse struct {
 	Valid bool `json:"valid"`
+
+	// Reason is why the payment is invalid. It's empty if it's valid.
+	Reason Reason `json:"reason,omitempty"`
+}
+
+// Reason is a machine readable reason for rejecting a payment. Senders
+// should treat reasons they don't know as ReasonInvalidPayment.
+type Reason string
+
+const (
+	// ReasonInvalidPayment is given for payments that are malformed or
+	// rejected for a reason without a more specific code.
+	ReasonInvalidPayment Reason = "invalid_payment"
+
+	// ReasonUnknownTarget is given for payments to a target the receiver
+	// doesn't serve.
+	ReasonUnknownTarget Reason = "unknown_target"
+
+	// ReasonAmountTooSmall is given for payments below the channel's or the
+	// receiver's minimum.
+	ReasonAmountTooSmall Reason = "amount_too_small"
+
+	// ReasonAmountTooLarge is given for payments above the channel's
+	// maximum.
+	ReasonAmountTooLarge Reason = "amount_too_large"
+
+	// ReasonCapacityExhausted is given for payments larger than the
+	// channel's remaining capacity. The sender should open a new channel.
+	ReasonCapacityExhausted Reason = "capacity_exhausted"
+
+	// ReasonInvalidSignature is given for payments with an invalid sender,
+	// fee or revocation signature.
+	ReasonInvalidSignature Reason = "invalid_signature"
+
+	// ReasonChannelNotOpen is given for payments on channels that are
+	// closing, closed, frozen or suspended.
+	ReasonChannelNotOpen Reason = "channel_not_open"
+
+	// ReasonChannelExpiring is given for payments on channels the receiver
+	// is about to close because they're nearing their timeout. The sender
+	// should open a new channel.
+	ReasonChannelExpiring Reason = "channel_expiring"
+
+	// ReasonWrongCounter is given for payments that don't carry the
+	// channel's next payment counter.
+	ReasonWrongCounter Reason = "wrong_counter"
+
+	// ReasonDuplicatePayment is given for payments that reuse a nonce.
+	ReasonDuplicatePayment Reason = "duplicate_payment"
+
+	// ReasonInvalidHold is given for captures of a hold that doesn't exist
+	// or is smaller than the payment.
+	ReasonInvalidHold Reason = "invalid_hold"
+
+	// ReasonQuotaExceeded is given for payments that would exceed the
+	// receiver's limit on payments per channel or sender within a period.
+	// The sender should try again later.
+	ReasonQuotaExceeded Reason = "quota_exceeded"
+)
+
+// ErrorResponse is the body of the response to a rejected payment.
+type ErrorResponse struct {
+	Error  string `json:"error"`
+	Reason Reason `json:"reason"`
 }
 
 type SendRequest struct {
@@ -69,18 +164,87 @@ type SendRequest struct {
 	Payment []byte `json:"payment"`
 
 	SenderSig []byte `json:"senderSig"`
+
+	// HoldID captures the payment from a hold.
+	HoldID string `json:"holdID,omitempty"`
+
+	// PaymentID is chosen by the sender to make retries idempotent. If a
+	// payment with the same ID was already accepted, the original response
+	// is returned.
+	PaymentID string `json:"paymentID,omitempty"`
+
+	// InvoiceID is the receiver-issued invoice the payment pays. The
+	// payment's amount and target must match the invoice.
+	InvoiceID string `json:"invoiceID,omitempty"`
+
+	// FeeSigs are optional signatures of the closure transaction at higher
+	// fees than the channel's.
+	FeeSigs []FeeSig `json:"feeSigs,omitempty"`
+
+	// Revocable channels only.
+	RevocationHash   []byte `json:"revocationHash,omitempty"`
+	RevocationSecret []byte `json:"revocationSecret,omitempty"`
+}
+
+// FeeSig is a sender signature of the closure transaction paying Fee.
+type FeeSig struct {
+	Fee int64  `json:"fee"`
+	Sig []byte `json:"sig"`
 }
 
 type SendResponse struct {
+	CommitmentSig []byte `json:"commitmentSig,omitempty"`
+
+	// Receipt is the receiver's signed proof that the payment was accepted.
+	Receipt *Receipt `json:"receipt,omitempty"`
+}
+
+// Receipt proves that the receiver accepted a payment. It's signed with the
+// receiver's key of the channel and commits to the chain of all payments on
+// the channel up to and including this one.
+type Receipt struct {
+	ChannelID      string `json:"channelID"`
+	Count          int    `json:"count"`
+	Amount         int64  `json:"amount"`
+	Target         string `json:"target"`
+	PaymentsHash   []byte `json:"paymentsHash"`
+	ReceiverPubKey []byte `json:"receiverPubKey"`
+	Signature      []byte `json:"signature"`
+}
+
+type HoldRequest struct {
+	TxID string `json:"txid"`
+	Vout uint32 `json:"vout"`
+
+	HoldID string `json:"holdID"`
+	Amount int64  `json:"amount"`
+}
+
+type HoldResponse struct {
+}
+
+type ReleaseRequest struct {
+	TxID string `json:"txid"`
+	Vout uint32 `json:"vout"`
+
+	HoldID string `json:"holdID"`
+}
+
+type ReleaseResponse struct {
 }
 
 type CloseRequest struct {
 	TxID string `json:"txid"`
 	Vout uint32 `json:"vout"`
+
+	// FeeRate, if set, asks the receiver to close with the sender's
+	// cheapest signature paying at least this rate in satoshis per byte.
+	FeeRate int64 `json:"feeRate,omitempty"`
 }
 
 type CloseResponse struct {
 	CloseTx []byte `json:"closeTx"`
+	Fee     int64  `json:"fee,omitempty"`
 }
 
 type StatusRequest struct {
@@ -92,4 +256,23 @@ type StatusResponse struct {
 	Status       int    `json:"status"`
 	Balance      int64  `json:"balance"`
 	PaymentsHash []byte `json:"paymentsHash"`
+	AppData      []byte `json:"appData,omitempty"`
+	Held         int64  `json:"held,omitempty"`
+
+	CommitmentSig []byte `json:"commitmentSig,omitempty"`
+
+	FundingTxID   string `json:"fundingTxID,omitempty"`
+	FundingVout   uint32 `json:"fundingVout"`
+	FundingAmount int64  `json:"fundingAmount,omitempty"`
+
+	// Available is the largest payment the channel can still accept.
+	Available int64 `json:"available"`
+	Count     int   `json:"count"`
+
+	// BlocksRemaining is the number of blocks until the sender's refund
+	// transaction becomes valid. It's omitted if the channel isn't confirmed
+	// or the receiver doesn't know the chain height.
+	BlocksRemaining *int64 `json:"blocksRemaining,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
 }
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// paymentEncodingVersion is the first byte of a canonically encoded payment.
+// It can never be confused with a legacy JSON payment, which starts with '{'.
+const paymentEncodingVersion = 1
+
+const maxPaymentField = 1 << 12
+
+var ErrInvalidPaymentEncoding = errors.New("invalid payment encoding")
+
+// EncodePayment returns the canonical binary encoding of a payment. This is
+// the serialization that is hashed into the paymentsHash and so it must be
+// identical across implementations:
+//
+//	version  uint8 (1)
+//	amount   int64, big-endian
+//	target   uint32 length, big-endian, followed by the bytes
+//	nonce    uint32 length, big-endian, followed by the bytes
+//	counter  uint64, big-endian
+func EncodePayment(p Payment) ([]byte, error) {
+	if len(p.Target) > maxPaymentField || len(p.Nonce) > maxPaymentField {
+		return nil, errors.New("payment field too long")
+	}
+	if p.Counter < 0 {
+		return nil, errors.New("negative payment counter")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(paymentEncodingVersion)
+	binary.Write(&buf, binary.BigEndian, p.Amount)
+	writeString(&buf, p.Target)
+	writeString(&buf, p.Nonce)
+	binary.Write(&buf, binary.BigEndian, uint64(p.Counter))
+	return buf.Bytes(), nil
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint32(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", ErrInvalidPaymentEncoding
+	}
+	if n > maxPaymentField || int(n) > r.Len() {
+		return "", ErrInvalidPaymentEncoding
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", ErrInvalidPaymentEncoding
+	}
+	return string(b), nil
+}
+
+// DecodeStoredPayment decodes a stored payment. Payments stored before the
+// canonical encoding was introduced are JSON, so they're accepted too. New
+// payments must be decoded with DecodePayment.
+func DecodeStoredPayment(b []byte) (*Payment, error) {
+	if len(b) > 0 && b[0] == '{' {
+		var p Payment
+		if err := json.Unmarshal(b, &p); err != nil {
+			return nil, err
+		}
+		return &p, nil
+	}
+	return DecodePayment(b)
+}
+
+// DecodePayment decodes a canonically encoded payment.
+func DecodePayment(b []byte) (*Payment, error) {
+	r := bytes.NewReader(b)
+	version, err := r.ReadByte()
+	if err != nil || version != paymentEncodingVersion {
+		return nil, ErrInvalidPaymentEncoding
+	}
+
+	var p Payment
+	if err := binary.Read(r, binary.BigEndian, &p.Amount); err != nil {
+		return nil, ErrInvalidPaymentEncoding
+	}
+	if p.Target, err = readString(r); err != nil {
+		return nil, err
+	}
+	if p.Nonce, err = readString(r); err != nil {
+		return nil, err
+	}
+	var counter uint64
+	if err := binary.Read(r, binary.BigEndian, &counter); err != nil {
+		return nil, ErrInvalidPaymentEncoding
+	}
+	if counter > 1<<31-1 {
+		return nil, ErrInvalidPaymentEncoding
+	}
+	p.Counter = int(counter)
+
+	if r.Len() != 0 {
+		return nil, ErrInvalidPaymentEncoding
+	}
+	return &p, nil
+}
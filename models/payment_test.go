@@ -0,0 +1,66 @@
+package models
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestEncodePayment(t *testing.T) {
+	p := Payment{
+		Amount:  1000,
+		Target:  "abc",
+		Nonce:   "n1",
+		Counter: 7,
+	}
+
+	buf, err := EncodePayment(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const expected = "01" +
+		"00000000000003e8" +
+		"00000003" + "616263" +
+		"00000002" + "6e31" +
+		"0000000000000007"
+	if hex.EncodeToString(buf) != expected {
+		t.Errorf("Unexpected encoding %x", buf)
+	}
+
+	p2, err := DecodePayment(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *p2 != p {
+		t.Errorf("Round trip mismatch: %+v", p2)
+	}
+
+	if _, err := DecodePayment(append(buf, 0)); err != ErrInvalidPaymentEncoding {
+		t.Errorf("Expected error for trailing bytes, got: %v", err)
+	}
+	if _, err := DecodePayment(buf[:len(buf)-1]); err != ErrInvalidPaymentEncoding {
+		t.Errorf("Expected error for truncated payment, got: %v", err)
+	}
+}
+
+func TestDecodeLegacyPayment(t *testing.T) {
+	legacy := []byte(`{"amount":5,"target":"x"}`)
+	p, err := DecodeStoredPayment(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Amount != 5 || p.Target != "x" {
+		t.Errorf("Unexpected payment %+v", p)
+	}
+	if _, err := DecodePayment(legacy); err != ErrInvalidPaymentEncoding {
+		t.Errorf("Expected a new JSON payment to be rejected, got %v", err)
+	}
+
+	buf, err := EncodePayment(Payment{Amount: 5, Target: "x", Nonce: "n", Counter: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := DecodeStoredPayment(buf); err != nil || p.Nonce != "n" {
+		t.Errorf("Expected a stored canonical payment to decode, got %+v %v", p, err)
+	}
+}
@@ -0,0 +1,356 @@
+// Package nats implements a minimal NATS client, sufficient for publishing
+// messages to JetStream and waiting for them to be stored.
+//
+// Only plain TCP connections are supported, optionally authenticated with a
+// token.
+package nats
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLineSize guards against reading huge protocol lines from a broken
+// server.
+const maxLineSize = 64 << 10
+
+// maxPayloadSize guards against allocating huge buffers for acks.
+const maxPayloadSize = 1 << 20
+
+var ErrProtocol = errors.New("nats protocol error")
+
+// Client publishes messages to JetStream over a single connection, which is
+// made when needed and dropped after any error. It's safe for concurrent
+// use, but publishes are sent one at a time.
+type Client struct {
+	addr  string
+	token string
+
+	// Timeout bounds connecting and waiting for each acknowledgement.
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	conn  net.Conn
+	r     *bufio.Reader
+	inbox string
+	seq   int
+
+	maxPayload int
+}
+
+// NewClient returns a client of the server at addr, e.g. "localhost:4222".
+// The token is sent if it's not empty.
+func NewClient(addr, token string) *Client {
+	return &Client{addr: addr, token: token, Timeout: 10 * time.Second}
+}
+
+// Close closes the connection, if any.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.drop()
+}
+
+func (c *Client) drop() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn, c.r = nil, nil
+	return err
+}
+
+type serverInfo struct {
+	Headers    bool `json:"headers"`
+	MaxPayload int  `json:"max_payload"`
+}
+
+type connectOptions struct {
+	Verbose   bool   `json:"verbose"`
+	Pedantic  bool   `json:"pedantic"`
+	Name      string `json:"name"`
+	Lang      string `json:"lang"`
+	Version   string `json:"version"`
+	Protocol  int    `json:"protocol"`
+	Headers   bool   `json:"headers"`
+	NoResp    bool   `json:"no_responders"`
+	AuthToken string `json:"auth_token,omitempty"`
+}
+
+func newInbox() (string, error) {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return "_INBOX." + hex.EncodeToString(b[:]), nil
+}
+
+// connect dials the server, exchanges INFO and CONNECT and subscribes to
+// the inbox on which acknowledgements are received.
+func (c *Client) connect(ctx context.Context) error {
+	d := net.Dialer{Timeout: c.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(c.Timeout))
+	r := bufio.NewReader(conn)
+
+	line, err := readLine(r)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return ErrProtocol
+	}
+	var info serverInfo
+	if err := json.Unmarshal([]byte(line[len("INFO "):]), &info); err != nil {
+		conn.Close()
+		return ErrProtocol
+	}
+	if !info.Headers {
+		conn.Close()
+		return errors.New("nats server doesn't support headers")
+	}
+
+	inbox, err := newInbox()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	opts, err := json.Marshal(connectOptions{
+		Name:      "moonbeam",
+		Lang:      "go",
+		Version:   "1",
+		Protocol:  1,
+		Headers:   true,
+		NoResp:    true,
+		AuthToken: c.token,
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	cmd := "CONNECT " + string(opts) + "\r\n" +
+		"SUB " + inbox + ".* 1\r\n" +
+		"PING\r\n"
+	if _, err := io.WriteString(conn, cmd); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// The server replies to PING once it has processed CONNECT and SUB,
+	// or with -ERR if it rejected them.
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return serverError(line)
+		}
+	}
+
+	c.conn, c.r, c.inbox, c.seq = conn, r, inbox, 0
+	c.maxPayload = info.MaxPayload
+	return nil
+}
+
+// Publish publishes data to the subject and waits for JetStream to
+// acknowledge that a stream stored it. msgID is sent as the Nats-Msg-Id
+// header so that the stream discards duplicates of messages that are
+// published again after a lost acknowledgement.
+func (c *Client) Publish(ctx context.Context, subject, msgID string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.publish(ctx, subject, msgID, data)
+	if err != nil {
+		c.drop()
+	}
+	return err
+}
+
+func (c *Client) publish(ctx context.Context, subject, msgID string, data []byte) error {
+	if !validSubject(subject) || strings.ContainsAny(msgID, "\r\n") {
+		return errors.New("invalid subject or message ID")
+	}
+	if c.conn == nil {
+		if err := c.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	deadline := time.Now().Add(c.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.conn.SetDeadline(deadline)
+
+	if c.maxPayload > 0 && len(data) > c.maxPayload {
+		return errors.New("nats: message exceeds server's maximum payload")
+	}
+
+	c.seq++
+	reply := c.inbox + "." + strconv.Itoa(c.seq)
+	hdr := "NATS/1.0\r\nNats-Msg-Id: " + msgID + "\r\n\r\n"
+	cmd := fmt.Sprintf("HPUB %s %s %d %d\r\n%s%s\r\n", subject, reply,
+		len(hdr), len(hdr)+len(data), hdr, data)
+	if _, err := io.WriteString(c.conn, cmd); err != nil {
+		return err
+	}
+
+	for {
+		line, err := readLine(c.r)
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PING":
+			if _, err := io.WriteString(c.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case line == "PONG", line == "+OK":
+		case strings.HasPrefix(line, "-ERR"):
+			return serverError(line)
+		case strings.HasPrefix(line, "MSG "), strings.HasPrefix(line, "HMSG "):
+			to, payload, status, err := readMsg(c.r, line)
+			if err != nil {
+				return err
+			}
+			if to != reply {
+				// An acknowledgement of an earlier publish that timed
+				// out.
+				continue
+			}
+			if status != "" {
+				return fmt.Errorf("nats: publish to %s failed: %s", subject, status)
+			}
+			return parseAck(payload)
+		default:
+			return ErrProtocol
+		}
+	}
+}
+
+// validSubject reports whether s is a subject that can be published to.
+func validSubject(s string) bool {
+	if s == "" || strings.ContainsAny(s, " \t\r\n*>") {
+		return false
+	}
+	for _, tok := range strings.Split(s, ".") {
+		if tok == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		b, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, b...)
+		if len(line) > maxLineSize {
+			return "", ErrProtocol
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// readMsg reads the payload of a MSG or HMSG whose first line is line. It
+// returns the message's subject, its payload and, for HMSG, the status
+// description if the headers carry an error status such as 503 when no
+// stream stores the subject.
+func readMsg(r *bufio.Reader, line string) (string, []byte, string, error) {
+	fields := strings.Fields(line)
+	hmsg := fields[0] == "HMSG"
+
+	// MSG <subject> <sid> [reply] <size>
+	// HMSG <subject> <sid> [reply] <hdr size> <size>
+	n := 4
+	if hmsg {
+		n = 5
+	}
+	if len(fields) != n && len(fields) != n+1 {
+		return "", nil, "", ErrProtocol
+	}
+	size, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || size < 0 || size > maxPayloadSize {
+		return "", nil, "", ErrProtocol
+	}
+	hdrSize := 0
+	if hmsg {
+		hdrSize, err = strconv.Atoi(fields[len(fields)-2])
+		if err != nil || hdrSize < 0 || hdrSize > size {
+			return "", nil, "", ErrProtocol
+		}
+	}
+
+	buf := make([]byte, size+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", nil, "", err
+	}
+	if string(buf[size:]) != "\r\n" {
+		return "", nil, "", ErrProtocol
+	}
+
+	var status string
+	if hmsg {
+		// NATS/1.0 [<code> <description>]
+		first := strings.SplitN(string(buf[:hdrSize]), "\r\n", 2)[0]
+		status = strings.TrimSpace(strings.TrimPrefix(first, "NATS/1.0"))
+	}
+	return fields[1], buf[hdrSize:size], status, nil
+}
+
+type pubAck struct {
+	Stream string `json:"stream"`
+	Seq    uint64 `json:"seq"`
+	Error  *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+func parseAck(payload []byte) error {
+	var ack pubAck
+	if err := json.Unmarshal(payload, &ack); err != nil {
+		return ErrProtocol
+	}
+	if ack.Error != nil {
+		return fmt.Errorf("nats: jetstream error %d: %s", ack.Error.Code, ack.Error.Description)
+	}
+	if ack.Stream == "" {
+		return ErrProtocol
+	}
+	return nil
+}
+
+func serverError(line string) error {
+	msg := strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'")
+	return errors.New("nats: " + msg)
+}
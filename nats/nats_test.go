@@ -0,0 +1,125 @@
+package nats
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// serve accepts one client, checks its handshake and acknowledges each
+// publish with the reply returned by ack.
+func serve(t *testing.T, l net.Listener, ack func(subject, hdr, data string) string) {
+	conn, err := l.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	io.WriteString(conn, `INFO {"server_id":"test","headers":true,"max_payload":1048576}`+"\r\n")
+
+	var inbox string
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "CONNECT":
+			if !strings.Contains(line, `"auth_token":"secret"`) {
+				io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "SUB":
+			inbox = strings.TrimSuffix(fields[1], "*")
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "HPUB":
+			hdrSize, _ := strconv.Atoi(fields[3])
+			size, _ := strconv.Atoi(fields[4])
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				t.Error(err)
+				return
+			}
+			if !strings.HasPrefix(fields[2], inbox) {
+				t.Errorf("Unexpected reply subject %s", fields[2])
+			}
+			payload := ack(fields[1], string(buf[:hdrSize]), string(buf[hdrSize:size]))
+			fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s\r\n", fields[2], len(payload), payload)
+		default:
+			t.Errorf("Unexpected command %q", line)
+			return
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var got []string
+	go serve(t, l, func(subject, hdr, data string) string {
+		got = append(got, subject+" "+data)
+		if !strings.Contains(hdr, "Nats-Msg-Id: ") {
+			t.Errorf("Missing message ID in %q", hdr)
+		}
+		if data == "fail" {
+			return `{"error":{"code":503,"description":"unavailable"}}`
+		}
+		return `{"stream":"EVENTS","seq":1}`
+	})
+
+	c := NewClient(l.Addr().String(), "secret")
+	defer c.Close()
+
+	ctx := context.Background()
+	if err := c.Publish(ctx, "moonbeam.payment", "1", []byte(`{"amount":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Publish(ctx, "moonbeam.payment", "2", []byte("fail")); err == nil {
+		t.Errorf("Expected JetStream error")
+	}
+	if err := c.Publish(ctx, "moonbeam.*", "3", nil); err == nil {
+		t.Errorf("Expected invalid subject to be rejected")
+	}
+	if len(got) != 2 || got[0] != `moonbeam.payment {"amount":1}` {
+		t.Errorf("Unexpected messages %q", got)
+	}
+}
+
+func TestPublishUnauthorized(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serve(t, l, nil)
+
+	c := NewClient(l.Addr().String(), "wrong")
+	err = c.Publish(context.Background(), "moonbeam.payment", "1", nil)
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("Expected authorization error, got %v", err)
+	}
+}
+
+func TestReadMsg(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("NATS/1.0 503\r\n\r\n\r\n"))
+	to, payload, status, err := readMsg(r, "HMSG _INBOX.x.1 1 16 16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to != "_INBOX.x.1" || len(payload) != 0 || status != "503" {
+		t.Errorf("Unexpected message %q %q %q", to, payload, status)
+	}
+}
@@ -0,0 +1,207 @@
+package receiver
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/luno/moonbeam/models"
+)
+
+// AcceptancePolicy decides whether the receiver accepts new channels and
+// payments. It's evaluated by Create, Open and Send after the request has
+// been validated, so implementations only need to apply business rules.
+//
+// Rejections should be ExposableErrors, which are reported to the sender.
+// Other errors are treated as internal errors.
+type AcceptancePolicy interface {
+	AcceptCreate(ctx context.Context, req models.CreateRequest) error
+
+	// AcceptOpen is called with the value of the channel's funding output.
+	AcceptOpen(ctx context.Context, req models.OpenRequest, value int64) error
+
+	AcceptPayment(ctx context.Context, p PaymentCheck) error
+}
+
+// PaymentCheck is a payment about to be accepted on a channel.
+type PaymentCheck struct {
+	ChannelID    string
+	SenderPubKey []byte
+	Payment      models.Payment
+}
+
+// SetAcceptancePolicy sets a policy evaluated in addition to the funding
+// policy. It replaces any policy set before.
+func (r *Receiver) SetAcceptancePolicy(p AcceptancePolicy) {
+	r.settings.update(func(s *Settings) { s.Acceptance = p })
+}
+
+func (r *Receiver) acceptancePolicies() []AcceptancePolicy {
+	s := r.settings.get()
+	ps := []AcceptancePolicy{s.Funding}
+	if s.Acceptance != nil {
+		ps = append(ps, s.Acceptance)
+	}
+	return ps
+}
+
+func (r *Receiver) acceptCreate(ctx context.Context, req models.CreateRequest) error {
+	for _, p := range r.acceptancePolicies() {
+		if err := p.AcceptCreate(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Receiver) acceptOpen(ctx context.Context, req models.OpenRequest, value int64) error {
+	for _, p := range r.acceptancePolicies() {
+		if err := p.AcceptOpen(ctx, req, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Receiver) acceptPayment(ctx context.Context, pc PaymentCheck) error {
+	for _, p := range r.acceptancePolicies() {
+		if err := p.AcceptPayment(ctx, pc); err != nil {
+			r.metrics.validationFailures.Inc("policy")
+			return err
+		}
+	}
+	return nil
+}
+
+var ErrBelowMinPayment = NewRejection(models.ReasonAmountTooSmall, "payment below minimum")
+var ErrSenderThrottled = NewExposableError("too many payments from sender")
+
+// Rules is the default AcceptancePolicy, usually loaded from a file with
+// LoadRules. Zero fields impose no restriction.
+type Rules struct {
+	// Funding bounds the funding amount of new channels.
+	Funding FundingPolicy
+
+	// MinPayment is the smallest payment accepted for any target.
+	MinPayment int64
+
+	// TargetFloors are the smallest payments accepted for specific
+	// targets, e.g. the price of the content behind them.
+	TargetFloors map[string]int64
+
+	// SenderPayments limits the payments accepted per sender pubkey within
+	// SenderWindow, across all of the sender's channels.
+	SenderPayments int
+	SenderWindow   time.Duration
+
+	mu   sync.Mutex
+	sent map[string][]time.Time
+}
+
+func (p *Rules) AcceptCreate(ctx context.Context, req models.CreateRequest) error {
+	return nil
+}
+
+func (p *Rules) AcceptOpen(ctx context.Context, req models.OpenRequest, value int64) error {
+	return p.Funding.check(value)
+}
+
+func (p *Rules) AcceptPayment(ctx context.Context, pc PaymentCheck) error {
+	amount := pc.Payment.Amount
+	if amount < p.MinPayment {
+		return ErrBelowMinPayment
+	}
+	if floor, ok := p.TargetFloors[pc.Payment.Target]; ok && amount < floor {
+		return NewExposableError(fmt.Sprintf("payment below price %d of target", floor))
+	}
+	if !p.allowSender(pc.SenderPubKey, time.Now()) {
+		return ErrSenderThrottled
+	}
+	return nil
+}
+
+// allowSender records a payment by the sender and reports whether it's
+// within the sender's limit.
+func (p *Rules) allowSender(senderPubKey []byte, now time.Time) bool {
+	if p.SenderPayments <= 0 || p.SenderWindow <= 0 {
+		return true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.sent == nil {
+		p.sent = make(map[string][]time.Time)
+	}
+	for k, ts := range p.sent {
+		i := 0
+		for i < len(ts) && now.Sub(ts[i]) >= p.SenderWindow {
+			i++
+		}
+		if i == len(ts) {
+			delete(p.sent, k)
+		} else {
+			p.sent[k] = ts[i:]
+		}
+	}
+
+	k := hex.EncodeToString(senderPubKey)
+	if len(p.sent[k]) >= p.SenderPayments {
+		return false
+	}
+	p.sent[k] = append(p.sent[k], now)
+	return true
+}
+
+// rulesFile is the JSON encoding of Rules.
+type rulesFile struct {
+	MinFunding     int64            `json:"minFunding"`
+	MaxFunding     int64            `json:"maxFunding"`
+	MinPayment     int64            `json:"minPayment"`
+	TargetFloors   map[string]int64 `json:"targetFloors"`
+	SenderPayments int              `json:"senderPayments"`
+	SenderWindow   string           `json:"senderWindow"`
+}
+
+// LoadRules reads Rules from a JSON file such as:
+//
+//	{
+//	  "minFunding": 100000,
+//	  "maxFunding": 10000000,
+//	  "minPayment": 100,
+//	  "targetFloors": {"premium+alice@example.com": 5000},
+//	  "senderPayments": 60,
+//	  "senderWindow": "1m"
+//	}
+func LoadRules(path string) (*Rules, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f rulesFile
+	if err := json.Unmarshal(buf, &f); err != nil {
+		return nil, err
+	}
+
+	p := &Rules{
+		Funding:        FundingPolicy{Min: f.MinFunding, Max: f.MaxFunding},
+		MinPayment:     f.MinPayment,
+		TargetFloors:   f.TargetFloors,
+		SenderPayments: f.SenderPayments,
+	}
+	if f.SenderWindow != "" {
+		p.SenderWindow, err = time.ParseDuration(f.SenderWindow)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.SenderPayments > 0 && p.SenderWindow <= 0 {
+		return nil, errors.New("senderPayments requires senderWindow")
+	}
+	return p, nil
+}
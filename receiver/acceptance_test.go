@@ -0,0 +1,98 @@
+package receiver
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/models"
+)
+
+func TestRules(t *testing.T) {
+	ctx := context.Background()
+	p := &Rules{
+		MinPayment:     10,
+		TargetFloors:   map[string]int64{"premium": 500},
+		SenderPayments: 2,
+		SenderWindow:   time.Hour,
+	}
+
+	check := func(sender byte, target string, amount int64) error {
+		return p.AcceptPayment(ctx, PaymentCheck{
+			SenderPubKey: []byte{sender},
+			Payment:      models.Payment{Target: target, Amount: amount},
+		})
+	}
+
+	if err := check(1, "basic", 5); err != ErrBelowMinPayment {
+		t.Errorf("Expected ErrBelowMinPayment, got %v", err)
+	}
+	if err := check(1, "premium", 100); err == nil {
+		t.Errorf("Expected payment below target floor to be rejected")
+	}
+	if err := check(1, "premium", 500); err != nil {
+		t.Errorf("Expected payment at floor to be accepted, got %v", err)
+	}
+	if err := check(1, "basic", 10); err != nil {
+		t.Errorf("Expected payment to be accepted, got %v", err)
+	}
+	if err := check(1, "basic", 10); err != ErrSenderThrottled {
+		t.Errorf("Expected ErrSenderThrottled, got %v", err)
+	}
+	if err := check(2, "basic", 10); err != nil {
+		t.Errorf("Expected other sender to be accepted, got %v", err)
+	}
+
+	now := time.Now()
+	if !p.allowSender([]byte{1}, now.Add(time.Hour)) {
+		t.Errorf("Expected sender to be allowed after the window")
+	}
+}
+
+type rejectCreates struct {
+	Rules
+}
+
+func (*rejectCreates) AcceptCreate(ctx context.Context, req models.CreateRequest) error {
+	return NewExposableError("closed for business")
+}
+
+func TestCustomAcceptancePolicy(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newOpenReceiver(t, &closeBackend{})
+	r.SetFundingPolicy(FundingPolicy{Min: 1000})
+	r.SetAcceptancePolicy(&rejectCreates{})
+
+	if _, err := r.Create(ctx, models.CreateRequest{}); err == nil || err.Error() != "closed for business" {
+		t.Errorf("Expected custom rejection, got %v", err)
+	}
+	if err := r.acceptOpen(ctx, models.OpenRequest{}, 999); err == nil {
+		t.Errorf("Expected funding policy to still apply")
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	buf := []byte(`{"minFunding":1000,"minPayment":10,"targetFloors":{"a":50},` +
+		`"senderPayments":5,"senderWindow":"1m"}`)
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Funding.Min != 1000 || p.MinPayment != 10 || p.TargetFloors["a"] != 50 ||
+		p.SenderPayments != 5 || p.SenderWindow != time.Minute {
+		t.Errorf("Unexpected rules %+v", p)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"senderPayments":5}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRules(path); err == nil {
+		t.Errorf("Expected senderPayments without window to be rejected")
+	}
+}
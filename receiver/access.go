@@ -0,0 +1,146 @@
+package receiver
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+var ErrSenderBlocked = NewExposableError("sender is blocked")
+var ErrSenderNotAllowed = NewExposableError("sender is not on the allow list")
+
+// AccessControl is the receiver's access list, which decides who may create
+// and open channels. Blocked senders and addresses are always refused. In
+// allowlist-only mode, a sender must also have its public key or output
+// address on the allow list.
+type AccessControl struct {
+	AllowlistOnly bool
+	Entries       []storage.AccessEntry
+}
+
+// Access returns the access list.
+func (r *Receiver) Access(ctx context.Context) (*AccessControl, error) {
+	on, err := r.db.GetAllowlistOnly(ctx)
+	if err != nil {
+		return nil, err
+	}
+	es, err := r.db.ListAccessEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if es == nil {
+		es = []storage.AccessEntry{}
+	}
+	return &AccessControl{AllowlistOnly: on, Entries: es}, nil
+}
+
+// PutAccessEntry adds a sender public key, hex encoded, or an output
+// address to the allow or block list. It replaces an entry for the same
+// value on the same list.
+func (r *Receiver) PutAccessEntry(ctx context.Context, list storage.AccessList, kind storage.AccessKind, value, note string) (*storage.AccessEntry, error) {
+	value, err := r.normalizeAccess(list, kind, value)
+	if err != nil {
+		return nil, err
+	}
+	e := storage.AccessEntry{
+		List:    list,
+		Kind:    kind,
+		Value:   value,
+		Note:    note,
+		Created: time.Now(),
+	}
+	if err := r.db.PutAccessEntry(ctx, e); err != nil {
+		return nil, err
+	}
+	r.log.InfoContext(ctx, "access entry added", "list", list, "kind", kind, "value", value)
+	return &e, nil
+}
+
+// RemoveAccessEntry removes an entry added with PutAccessEntry.
+func (r *Receiver) RemoveAccessEntry(ctx context.Context, list storage.AccessList, kind storage.AccessKind, value string) error {
+	value, err := r.normalizeAccess(list, kind, value)
+	if err != nil {
+		return err
+	}
+	if err := r.db.DeleteAccessEntry(ctx, list, kind, value); err != nil {
+		return err
+	}
+	r.log.InfoContext(ctx, "access entry removed", "list", list, "kind", kind, "value", value)
+	return nil
+}
+
+// SetAllowlistOnly turns allowlist-only mode on or off.
+func (r *Receiver) SetAllowlistOnly(ctx context.Context, on bool) error {
+	if err := r.db.SetAllowlistOnly(ctx, on); err != nil {
+		return err
+	}
+	r.log.InfoContext(ctx, "allowlist-only mode set", "on", on)
+	return nil
+}
+
+// normalizeAccess validates an entry's value and returns it in the form
+// it's matched in.
+func (r *Receiver) normalizeAccess(list storage.AccessList, kind storage.AccessKind, value string) (string, error) {
+	if list != storage.AccessAllow && list != storage.AccessBlock {
+		return "", NewExposableError("list must be allow or block")
+	}
+	switch kind {
+	case storage.AccessSender:
+		buf, err := hex.DecodeString(value)
+		if err != nil {
+			return "", NewExposableError("invalid sender public key")
+		}
+		if _, err := btcec.ParsePubKey(buf, btcec.S256()); err != nil {
+			return "", NewExposableError("invalid sender public key")
+		}
+		return strings.ToLower(value), nil
+	case storage.AccessAddress:
+		addr, err := btcutil.DecodeAddress(value, r.Net)
+		if err != nil || !addr.IsForNet(r.Net) {
+			return "", NewExposableError("invalid address")
+		}
+		return addr.EncodeAddress(), nil
+	default:
+		return "", NewExposableError("kind must be sender or address")
+	}
+}
+
+// checkAccess returns an error if the access list refuses channels from
+// the sender or to its output address.
+func (r *Receiver) checkAccess(ctx context.Context, senderPubKey []byte, senderOutput string) error {
+	on, err := r.db.GetAllowlistOnly(ctx)
+	if err != nil {
+		return err
+	}
+	es, err := r.db.ListAccessEntries(ctx)
+	if err != nil {
+		return err
+	}
+
+	sender := hex.EncodeToString(senderPubKey)
+	if addr, err := btcutil.DecodeAddress(senderOutput, r.Net); err == nil {
+		senderOutput = addr.EncodeAddress()
+	}
+	var allowed bool
+	for _, e := range es {
+		match := (e.Kind == storage.AccessSender && e.Value == sender) ||
+			(e.Kind == storage.AccessAddress && e.Value == senderOutput)
+		if !match {
+			continue
+		}
+		if e.List == storage.AccessBlock {
+			return ErrSenderBlocked
+		}
+		allowed = true
+	}
+	if on && !allowed {
+		return ErrSenderNotAllowed
+	}
+	return nil
+}
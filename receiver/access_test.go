@@ -0,0 +1,83 @@
+package receiver
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/storage"
+)
+
+func TestAccess(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newOpenReceiver(t, &closeBackend{})
+	net := r.Net
+
+	alice, bob := keytest.PubKey(3), keytest.PubKey(4)
+	aliceOut, bobOut := keytest.Address(5, net), keytest.Address(6, net)
+
+	if err := r.checkAccess(ctx, alice, aliceOut); err != nil {
+		t.Fatalf("Expected access by default, got %v", err)
+	}
+
+	_, err := r.PutAccessEntry(ctx, storage.AccessBlock, storage.AccessSender, "zz", "")
+	if _, ok := err.(ExposableError); !ok {
+		t.Errorf("Expected invalid public key to be rejected, got %v", err)
+	}
+	_, err = r.PutAccessEntry(ctx, storage.AccessBlock, storage.AccessAddress, keytest.Address(6, &chaincfg.MainNetParams), "")
+	if _, ok := err.(ExposableError); !ok {
+		t.Errorf("Expected address for another network to be rejected, got %v", err)
+	}
+
+	// Blocking either the sender or its output refuses it.
+	if _, err := r.PutAccessEntry(ctx, storage.AccessBlock, storage.AccessSender, hex.EncodeToString(alice), "fraud"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.PutAccessEntry(ctx, storage.AccessBlock, storage.AccessAddress, bobOut, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.checkAccess(ctx, alice, aliceOut); err != ErrSenderBlocked {
+		t.Errorf("Expected blocked sender, got %v", err)
+	}
+	if err := r.checkAccess(ctx, bob, bobOut); err != ErrSenderBlocked {
+		t.Errorf("Expected blocked address, got %v", err)
+	}
+	if err := r.checkAccess(ctx, bob, aliceOut); err != nil {
+		t.Errorf("Expected access, got %v", err)
+	}
+
+	// In allowlist-only mode, only allowed senders get access, and blocks
+	// still take precedence.
+	if err := r.SetAllowlistOnly(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.checkAccess(ctx, bob, aliceOut); err != ErrSenderNotAllowed {
+		t.Errorf("Expected sender not allowed, got %v", err)
+	}
+	if _, err := r.PutAccessEntry(ctx, storage.AccessAllow, storage.AccessSender, hex.EncodeToString(bob), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.checkAccess(ctx, bob, aliceOut); err != nil {
+		t.Errorf("Expected allowed sender, got %v", err)
+	}
+	if err := r.checkAccess(ctx, bob, bobOut); err != ErrSenderBlocked {
+		t.Errorf("Expected blocked address, got %v", err)
+	}
+
+	if err := r.RemoveAccessEntry(ctx, storage.AccessBlock, storage.AccessAddress, bobOut); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RemoveAccessEntry(ctx, storage.AccessBlock, storage.AccessAddress, bobOut); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	ac, err := r.Access(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ac.AllowlistOnly || len(ac.Entries) != 2 {
+		t.Errorf("Unexpected access list %+v", ac)
+	}
+}
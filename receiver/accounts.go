@@ -0,0 +1,272 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+// Account is a merchant served by the receiver alongside the default
+// account configured with NewReceiver. Its channels settle to its own
+// destination, accept payments to targets in its own domain and are signed
+// with keys derived under its own branch of the extended key.
+type Account struct {
+	ID          string
+	Destination string
+	Domain      string
+
+	// DestinationXPub, if set, is an extended public key from which a
+	// fresh destination is derived for each channel. Destination may then
+	// be empty.
+	DestinationXPub string
+
+	// KeyIndex selects the hardened child of the receiver's extended key
+	// under which the account's channel keys are derived. It must be
+	// unique and must never change once channels are open.
+	KeyIndex uint32
+}
+
+var ErrUnknownAccount = NewExposableError("unknown account")
+
+var validAccountID = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+type account struct {
+	Account
+	dir          *Directory
+	destinations *xpubDestinations
+	settlement   *SettlementPolicy
+}
+
+// AddAccount registers an account. It must be called before the receiver
+// starts serving requests.
+func (r *Receiver) AddAccount(a Account) error {
+	if !validAccountID.MatchString(a.ID) {
+		return errors.New("invalid account ID")
+	}
+	if (a.Destination == "" && a.DestinationXPub == "") || a.Domain == "" {
+		return errors.New("account destination and domain are required")
+	}
+	if a.KeyIndex >= hdkeychain.HardenedKeyStart {
+		return errors.New("account key index out of range")
+	}
+	for _, other := range r.accounts {
+		if other.ID == a.ID {
+			return errors.New("duplicate account " + a.ID)
+		}
+		if other.KeyIndex == a.KeyIndex {
+			return errors.New("duplicate account key index " + strconv.Itoa(int(a.KeyIndex)))
+		}
+	}
+	acc := &account{Account: a, dir: NewDirectory(a.Domain)}
+	if a.DestinationXPub != "" {
+		d, err := newXPubDestinations(r.Net, a.DestinationXPub)
+		if err != nil {
+			return err
+		}
+		acc.destinations = d
+	}
+	if r.accounts == nil {
+		r.accounts = make(map[string]*account)
+	}
+	r.accounts[a.ID] = acc
+	return nil
+}
+
+// AccountForDomain returns the ID of the account accepting payments for the
+// domain, or false if it's not served by an account.
+func (r *Receiver) AccountForDomain(domain string) (string, bool) {
+	for _, a := range r.accounts {
+		if strings.EqualFold(a.Domain, domain) {
+			return a.ID, true
+		}
+	}
+	return "", false
+}
+
+type accountKey struct{}
+
+// WithAccount returns a context that scopes receiver calls to the account.
+// An empty ID selects the default account. Calls made without an account
+// in their context, such as the receiver's own background work, see all
+// channels.
+func WithAccount(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, accountKey{}, id)
+}
+
+func accountFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(accountKey{}).(string)
+	return id, ok
+}
+
+// account returns the account the context is scoped to, or nil for the
+// default account.
+func (r *Receiver) account(ctx context.Context) (*account, error) {
+	id, _ := accountFromContext(ctx)
+	if id == "" {
+		return nil, nil
+	}
+	a, ok := r.accounts[id]
+	if !ok {
+		return nil, ErrUnknownAccount
+	}
+	return a, nil
+}
+
+// visible reports whether the context may access the channel.
+func visible(ctx context.Context, rec *storage.Record) bool {
+	id, ok := accountFromContext(ctx)
+	return !ok || rec.Account == id
+}
+
+// getRecord is like db.Get but hides channels of other accounts.
+func (r *Receiver) getRecord(ctx context.Context, id string) (*storage.Record, error) {
+	rec, err := r.db.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !visible(ctx, rec) {
+		return nil, storage.ErrNotFound
+	}
+	return rec, nil
+}
+
+// accountKeyFor returns the extended key of the key generation under which
+// the account's channel keys are derived.
+func (r *Receiver) accountKeyFor(accountID string, gen int) (*hdkeychain.ExtendedKey, error) {
+	ek, err := r.keyring.get(r.ek, gen)
+	if err != nil {
+		return nil, err
+	}
+	if accountID == "" {
+		return ek, nil
+	}
+	a, ok := r.accounts[accountID]
+	if !ok {
+		return nil, ErrUnknownAccount
+	}
+	return ek.Child(hdkeychain.HardenedKeyStart + a.KeyIndex)
+}
+
+// directory returns the directory of the account's targets.
+func (r *Receiver) directory(accountID string) *Directory {
+	if a, ok := r.accounts[accountID]; ok {
+		return a.dir
+	}
+	return r.settings.get().Directory
+}
+
+// getAccountKey returns the signer of the channel key at path n in the
+// account's namespace of key generation gen. Remote keys sign with ctx.
+func (r *Receiver) getAccountKey(ctx context.Context, accountID string, gen, n int) (channels.Signer, error) {
+	id := keyID{accountID, gen, n}
+	key, ok := r.keys.get(id)
+	if !ok {
+		var err error
+		key, err = r.deriveKey(accountID, gen, n)
+		if err != nil {
+			return nil, err
+		}
+		r.keys.add(id, key)
+	}
+	if k, ok := key.(*remoteKey); ok {
+		return k.withContext(ctx), nil
+	}
+	return key, nil
+}
+
+func (r *Receiver) deriveKey(accountID string, gen, n int) (channels.Signer, error) {
+	if r.remote != nil {
+		if gen != 0 {
+			return nil, ErrUnknownKeyGeneration
+		}
+		return r.remoteKey(accountID, n)
+	}
+	ek, err := r.accountKeyFor(accountID, gen)
+	if err != nil {
+		return nil, err
+	}
+	ek, err = ek.Child(uint32(n))
+	if err != nil {
+		return nil, err
+	}
+	return ek.ECPrivKey()
+}
+
+// receiverData identifies the key and destination of a created channel so
+// that Open can find them again.
+type receiverData struct {
+	account string
+	keyGen  int
+	keyPath int
+
+	// destIndex is the index of the destination derived from the account's
+	// extended public key, or -1 for a fixed destination.
+	destIndex int
+}
+
+// encodeReceiverData encodes the channel's receiverData as
+// [<account>/][<keyGen>.]<keyPath>[:<destIndex>]. The default account's
+// channels with the original key and a fixed destination keep the original
+// encoding of just the key path.
+func encodeReceiverData(d receiverData) []byte {
+	s := strconv.Itoa(d.keyPath)
+	if d.keyGen > 0 {
+		s = strconv.Itoa(d.keyGen) + "." + s
+	}
+	if d.account != "" {
+		s = d.account + "/" + s
+	}
+	if d.destIndex >= 0 {
+		s += ":" + strconv.Itoa(d.destIndex)
+	}
+	return []byte(s)
+}
+
+// decodeReceiverData decodes data encoded by encodeReceiverData. Key paths
+// above maxKeyPath, which haven't been reserved, are rejected.
+func decodeReceiverData(data []byte, maxKeyPath int) (receiverData, error) {
+	invalid := errors.New("invalid receiverData")
+
+	s := string(data)
+	d := receiverData{destIndex: -1}
+	if i := strings.Index(s, ":"); i >= 0 {
+		n, err := strconv.ParseUint(s[i+1:], 10, 31)
+		if err != nil {
+			return receiverData{}, invalid
+		}
+		s, d.destIndex = s[:i], int(n)
+	}
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		d.account, s = s[:i], s[i+1:]
+	}
+	if i := strings.Index(s, "."); i >= 0 {
+		n, ok := parseCanonical(s[:i])
+		if !ok || n == 0 {
+			return receiverData{}, invalid
+		}
+		s, d.keyGen = s[i+1:], n
+	}
+	n, ok := parseCanonical(s)
+	if !ok || n > maxKeyPath {
+		return receiverData{}, invalid
+	}
+	d.keyPath = n
+	return d, nil
+}
+
+// parseCanonical parses a non-negative number without leading zeros or
+// signs, so that each receiverData has a single encoding.
+func parseCanonical(s string) (int, bool) {
+	n, err := strconv.ParseUint(s, 10, 31)
+	if err != nil || strconv.FormatUint(n, 10) != s {
+		return 0, false
+	}
+	return int(n), true
+}
@@ -0,0 +1,132 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+func TestAddAccount(t *testing.T) {
+	r, _ := newOpenReceiver(t, &closeBackend{})
+	shop := Account{ID: "shop", Destination: "dest", Domain: "shop.example", KeyIndex: 1}
+	if err := r.AddAccount(shop); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := []Account{
+		{ID: "Shop!", Destination: "dest", Domain: "other.example", KeyIndex: 2},
+		{ID: "other", Domain: "other.example", KeyIndex: 2},
+		{ID: "shop", Destination: "dest", Domain: "other.example", KeyIndex: 2},
+		{ID: "other", Destination: "dest", Domain: "other.example", KeyIndex: 1},
+		{ID: "other", Destination: "dest", Domain: "other.example", KeyIndex: hdkeychain.HardenedKeyStart},
+	}
+	for _, a := range bad {
+		if err := r.AddAccount(a); err == nil {
+			t.Errorf("Expected %+v to be rejected", a)
+		}
+	}
+
+	if id, ok := r.AccountForDomain("Shop.Example"); !ok || id != "shop" {
+		t.Errorf("Expected shop for its domain, got %q %v", id, ok)
+	}
+}
+
+func TestAccountIsolation(t *testing.T) {
+	ctx := context.Background()
+	r, rec := newOpenReceiver(t, &closeBackend{})
+	err := r.AddAccount(Account{ID: "shop", Destination: "dest", Domain: "shop.example", KeyIndex: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txid, vout := rec.SharedState.FundingTxID, rec.SharedState.FundingVout
+
+	shopCtx := WithAccount(ctx, "shop")
+	if r.Get(shopCtx, txid, vout) != nil {
+		t.Errorf("Expected default account's channel to be hidden from shop")
+	}
+	if _, err := r.Inspect(shopCtx, txid, vout); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := r.Suspend(shopCtx, txid, vout, ""); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	recs, _, err := r.List(shopCtx, storage.ListFilter{}, "", 0)
+	if err != nil || len(recs) != 0 {
+		t.Errorf("Expected no channels for shop, got %d %v", len(recs), err)
+	}
+
+	for _, c := range []context.Context{ctx, WithAccount(ctx, "")} {
+		if r.Get(c, txid, vout) == nil {
+			t.Errorf("Expected channel to be visible")
+		}
+		recs, _, err := r.List(c, storage.ListFilter{}, "", 0)
+		if err != nil || len(recs) != 1 {
+			t.Errorf("Expected one channel, got %d %v", len(recs), err)
+		}
+	}
+}
+
+func TestAccountCreate(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := memory.New()
+	r := NewReceiver(net, ek, &closeBackend{}, db, nil, keytest.Address(1, net), "")
+
+	shopDest := keytest.Address(2, net)
+	err = r.AddAccount(Account{ID: "shop", Destination: shopDest, Domain: "shop.example", KeyIndex: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := channels.NewSender(channels.DefaultSenderConfig, keytest.Key(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := s.GetCreateRequest(keytest.Address(4, net))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	def, err := r.Create(WithAccount(ctx, ""), *req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shop, err := r.Create(WithAccount(ctx, "shop"), *req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(def.ReceiverData) != "0" || string(shop.ReceiverData) != "shop/0" {
+		t.Errorf("Unexpected receiverData %q %q", def.ReceiverData, shop.ReceiverData)
+	}
+	if shop.ReceiverOutput != shopDest {
+		t.Errorf("Expected shop's destination, got %s", shop.ReceiverOutput)
+	}
+	if bytes.Equal(def.ReceiverPubKey, shop.ReceiverPubKey) {
+		t.Errorf("Expected shop to use its own key namespace")
+	}
+
+	if _, err := r.Create(WithAccount(ctx, "nope"), *req); err != ErrUnknownAccount {
+		t.Errorf("Expected ErrUnknownAccount, got %v", err)
+	}
+
+	// Open refuses receiverData of another account.
+	_, err = r.Open(WithAccount(ctx, ""), models.OpenRequest{ReceiverData: shop.ReceiverData})
+	if err == nil {
+		t.Errorf("Expected open with other account's receiverData to fail")
+	}
+}
@@ -0,0 +1,174 @@
+package receiver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/btcsuite/btcd/txscript"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage"
+)
+
+// operatorSuspension prefixes the reason of channels suspended by the
+// operator. Unlike suspensions due to reorgs, they aren't lifted
+// automatically.
+const operatorSuspension = "suspended by operator"
+
+func suspendedByOperator(rec storage.Record) bool {
+	return rec.Suspended && strings.HasPrefix(rec.SuspendedReason, operatorSuspension)
+}
+
+// ChannelDump is the full stored state of a channel.
+type ChannelDump struct {
+	Record   storage.Record
+	Payments [][]byte
+}
+
+// Inspect returns the stored state and payment log of a channel. The
+// payments of an archived channel are read from its batch, and those of a
+// purged channel are gone.
+func (r *Receiver) Inspect(ctx context.Context, txid string, vout uint32) (*ChannelDump, error) {
+	id := getChannelID(txid, vout)
+	rec, err := r.getRecord(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Archived == storage.Purged {
+		return &ChannelDump{Record: *rec}, nil
+	}
+	if rec.Archived != "" {
+		c, err := r.archivedChannel(ctx, *rec)
+		if err != nil {
+			return nil, err
+		}
+		var payments [][]byte
+		for _, p := range c.Payments {
+			payments = append(payments, p.Payment)
+		}
+		return &ChannelDump{Record: *rec, Payments: payments}, nil
+	}
+	payments, err := r.db.ListPayments(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &ChannelDump{Record: *rec, Payments: payments}, nil
+}
+
+// SenderChannels returns the channels opened by the sender's pubkey, in ID
+// order, for support to answer what channels a customer has.
+func (r *Receiver) SenderChannels(ctx context.Context, senderPubKey []byte) ([]storage.Record, error) {
+	recs, err := r.db.GetBySenderPubKey(ctx, senderPubKey)
+	if err != nil {
+		return nil, err
+	}
+	account, ok := accountFromContext(ctx)
+	if !ok {
+		return recs, nil
+	}
+	sl := []storage.Record{}
+	for _, rec := range recs {
+		if rec.Account == account {
+			sl = append(sl, rec)
+		}
+	}
+	return sl, nil
+}
+
+// CloseOutput is an output of an estimated closure transaction. Address is
+// empty for the output committing to the payments hash.
+type CloseOutput struct {
+	Address  string
+	Value    int64
+	PkScript []byte
+}
+
+// CloseEstimate is the closure transaction a channel would be closed with.
+type CloseEstimate struct {
+	Balance int64
+	Outputs []CloseOutput
+	Fee     int64
+
+	// VSize is an upper bound on the size of the signed transaction.
+	VSize int
+}
+
+// CloseEstimate builds the closure transaction for the channel's current
+// balance without signing or broadcasting it, so that operators can check a
+// settlement before closing.
+func (r *Receiver) CloseEstimate(ctx context.Context, txid string, vout uint32) (*CloseEstimate, error) {
+	c, err := r.get(ctx, getChannelID(txid, vout))
+	if err != nil {
+		return nil, err
+	}
+	if !c.State.Status.IsOpen() && c.State.Status != channels.StatusClosing {
+		return nil, channels.ErrNotStatusOpen
+	}
+
+	est, err := c.State.EstimateClosure()
+	if err != nil {
+		return nil, err
+	}
+
+	res := CloseEstimate{
+		Balance: c.State.Balance,
+		Fee:     est.Fee,
+		VSize:   est.VSize,
+	}
+	for _, out := range est.Tx.TxOut {
+		o := CloseOutput{Value: out.Value, PkScript: out.PkScript}
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(out.PkScript, r.Net)
+		if err == nil && len(addrs) == 1 {
+			o.Address = addrs[0].EncodeAddress()
+		}
+		res.Outputs = append(res.Outputs, o)
+	}
+	return &res, nil
+}
+
+// ForceClose closes a channel at its current balance on behalf of the
+// operator. If feeRate is set, the channel is closed with the sender's
+// cheapest signature paying at least that rate, if the sender provided one.
+func (r *Receiver) ForceClose(ctx context.Context, txid string, vout uint32, feeRate int64) (*models.CloseResponse, error) {
+	r.log.Warn("force closing channel", "channel", getChannelID(txid, vout),
+		"feeRate", feeRate)
+	return r.Close(ctx, models.CloseRequest{TxID: txid, Vout: vout, FeeRate: feeRate})
+}
+
+// Suspend stops a channel from accepting payments until it's resumed.
+func (r *Receiver) Suspend(ctx context.Context, txid string, vout uint32, reason string) error {
+	id := getChannelID(txid, vout)
+	ctx, unlock, err := r.lockChannel(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := r.getRecord(ctx, id); err != nil {
+		return err
+	}
+
+	if reason != "" {
+		reason = operatorSuspension + ": " + reason
+	} else {
+		reason = operatorSuspension
+	}
+	return r.db.Suspend(ctx, id, true, reason)
+}
+
+// Resume lifts a suspension. A channel whose funding is insufficiently
+// confirmed is suspended again when the next block is checked.
+func (r *Receiver) Resume(ctx context.Context, txid string, vout uint32) error {
+	id := getChannelID(txid, vout)
+	ctx, unlock, err := r.lockChannel(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := r.getRecord(ctx, id); err != nil {
+		return err
+	}
+	return r.db.Suspend(ctx, id, false, "")
+}
@@ -0,0 +1,46 @@
+package receiver
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Alerter is notified of conditions that need urgent operator attention.
+type Alerter interface {
+	Alert(channelID string, msg string)
+}
+
+type logAlerter struct {
+	log *slog.Logger
+}
+
+func (a logAlerter) Alert(channelID string, msg string) {
+	l := a.log
+	if l == nil {
+		l = slog.Default()
+	}
+	l.Error("ALERT: "+msg, "channel", channelID)
+}
+
+// SetAlerter sets where alerts are sent. By default they are logged.
+func (r *Receiver) SetAlerter(a Alerter) {
+	r.alerter = a
+}
+
+// SetLogger sets the logger used by the receiver. Alerts are also sent to it
+// unless an Alerter has been set.
+func (r *Receiver) SetLogger(l *slog.Logger) {
+	r.log = l
+	if _, ok := r.alerter.(logAlerter); ok {
+		r.alerter = logAlerter{l}
+	}
+}
+
+// freeze marks the channel as frozen so that it is no longer signed for or
+// broadcast, and alerts the operator.
+func (r *Receiver) freeze(ctx context.Context, id string, reason error) {
+	r.alerter.Alert(id, "freezing channel: "+reason.Error())
+	if err := r.db.Freeze(ctx, id, reason.Error()); err != nil {
+		r.alerter.Alert(id, "failed to freeze channel: "+err.Error())
+	}
+}
@@ -0,0 +1,110 @@
+package receiver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+// API key scopes.
+const (
+	// ScopeRPC allows calling the sender-facing API when it requires keys.
+	ScopeRPC = "rpc"
+
+	// ScopeAdminRead allows inspecting channels through the admin API.
+	ScopeAdminRead = "admin:read"
+
+	// ScopeAdminWrite allows changing channels through the admin API.
+	ScopeAdminWrite = "admin:write"
+)
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+var ErrMissingScope = errors.New("api key lacks scope")
+
+func validScope(scope string) bool {
+	switch scope {
+	case ScopeRPC, ScopeAdminRead, ScopeAdminWrite:
+		return true
+	default:
+		return false
+	}
+}
+
+func hashAPISecret(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
+
+// CreateAPIKey stores a new API key with the given scopes and returns it.
+// The key is only available now, since only its hash is stored. If account
+// isn't empty, the key only grants access to that account's channels.
+func CreateAPIKey(ctx context.Context, db storage.Storage, account string, scopes []string) (string, error) {
+	if len(scopes) == 0 {
+		return "", errors.New("no scopes")
+	}
+	for _, s := range scopes {
+		if !validScope(s) {
+			return "", errors.New("unknown scope " + s)
+		}
+	}
+
+	idBuf := make([]byte, 8)
+	if _, err := rand.Read(idBuf); err != nil {
+		return "", err
+	}
+	secretBuf := make([]byte, 32)
+	if _, err := rand.Read(secretBuf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(idBuf)
+	secret := base64.RawURLEncoding.EncodeToString(secretBuf)
+
+	err := db.AddAPIKey(ctx, storage.APIKey{
+		ID:      id,
+		Hash:    hashAPISecret(secret),
+		Scopes:  scopes,
+		Created: time.Now(),
+		Account: account,
+	})
+	if err != nil {
+		return "", err
+	}
+	return id + "." + secret, nil
+}
+
+// CheckAPIKey returns nil if key is a valid API key with the scope. It also
+// returns the account the key is restricted to, if any.
+func (r *Receiver) CheckAPIKey(ctx context.Context, key, scope string) (string, error) {
+	id, secret, ok := strings.Cut(key, ".")
+	if !ok {
+		return "", ErrInvalidAPIKey
+	}
+	k, err := r.db.GetAPIKey(ctx, id)
+	if err != nil {
+		return "", err
+	} else if k == nil {
+		return "", ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare(hashAPISecret(secret), k.Hash) != 1 {
+		return "", ErrInvalidAPIKey
+	}
+	for _, s := range k.Scopes {
+		if s == scope {
+			return k.Account, nil
+		}
+	}
+	return "", ErrMissingScope
+}
+
+// RevokeAPIKey deletes the API key with the ID.
+func (r *Receiver) RevokeAPIKey(ctx context.Context, id string) error {
+	return r.db.DeleteAPIKey(ctx, id)
+}
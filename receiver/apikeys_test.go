@@ -0,0 +1,54 @@
+package receiver
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newOpenReceiver(t, &closeBackend{})
+
+	if _, err := CreateAPIKey(ctx, r.db, "", []string{"root"}); err == nil {
+		t.Errorf("Expected unknown scope to be rejected")
+	}
+
+	key, err := CreateAPIKey(ctx, r.db, "", []string{ScopeAdminRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.CheckAPIKey(ctx, key, ScopeAdminRead); err != nil {
+		t.Errorf("Expected valid key, got %v", err)
+	}
+	if _, err := r.CheckAPIKey(ctx, key, ScopeAdminWrite); err != ErrMissingScope {
+		t.Errorf("Expected ErrMissingScope, got %v", err)
+	}
+	if _, err := r.CheckAPIKey(ctx, key+"x", ScopeAdminRead); err != ErrInvalidAPIKey {
+		t.Errorf("Expected ErrInvalidAPIKey for wrong secret, got %v", err)
+	}
+
+	id, _, _ := strings.Cut(key, ".")
+	stored, err := r.db.GetAPIKey(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(stored.Hash), key[len(id)+1:]) {
+		t.Errorf("Expected only a hash of the secret to be stored")
+	}
+
+	if err := r.RevokeAPIKey(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.CheckAPIKey(ctx, key, ScopeAdminRead); err != ErrInvalidAPIKey {
+		t.Errorf("Expected revoked key to be invalid, got %v", err)
+	}
+
+	key, err = CreateAPIKey(ctx, r.db, "shop", []string{ScopeRPC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account, err := r.CheckAPIKey(ctx, key, ScopeRPC); err != nil || account != "shop" {
+		t.Errorf("Expected key for account shop, got %q %v", account, err)
+	}
+}
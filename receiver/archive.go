@@ -0,0 +1,186 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/luno/moonbeam/archive"
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+// archiveBatchSize is the most channels written to a single batch.
+const archiveBatchSize = 100
+
+// archiver is the store closed channels are archived to.
+type archiver struct {
+	mu        sync.Mutex
+	store     archive.Store
+	retention time.Duration
+}
+
+// SetArchive makes ArchiveClosed move closed channels to the store once no
+// payment has been made on them for retention. Nil disables archiving.
+func (r *Receiver) SetArchive(store archive.Store, retention time.Duration) {
+	r.archiver.mu.Lock()
+	defer r.archiver.mu.Unlock()
+	r.archiver.store = store
+	r.archiver.retention = retention
+}
+
+func (r *Receiver) archiveStore() (archive.Store, time.Duration) {
+	r.archiver.mu.Lock()
+	defer r.archiver.mu.Unlock()
+	return r.archiver.store, r.archiver.retention
+}
+
+// ArchiveClosed writes the closed channels whose last payment, or opening
+// if there was none, is older than the retention to batches in the archive
+// store, with their payments and revocation secrets. Once a batch is
+// written, its channels' logs are deleted from storage and their records
+// kept as stubs pointing at it. It returns the number of channels archived.
+func (r *Receiver) ArchiveClosed(ctx context.Context) (int, error) {
+	store, retention := r.archiveStore()
+	if store == nil {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-retention)
+
+	var batch []archive.Channel
+	var n int
+	f := storage.ListFilter{Status: channels.StatusClosed}
+	var cursor string
+	for {
+		recs, next, err := r.db.ListPage(ctx, f, cursor, archiveBatchSize)
+		if err != nil {
+			return n, err
+		}
+		for _, rec := range recs {
+			if rec.Archived != "" {
+				continue
+			}
+			c, ok, err := r.archivable(ctx, rec, cutoff)
+			if err != nil {
+				return n, err
+			} else if !ok {
+				continue
+			}
+			if batch = append(batch, *c); len(batch) < archiveBatchSize {
+				continue
+			}
+			archived, err := r.writeBatch(ctx, store, batch)
+			n += archived
+			if err != nil {
+				return n, err
+			}
+			batch = nil
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(batch) == 0 {
+		return n, nil
+	}
+	archived, err := r.writeBatch(ctx, store, batch)
+	return n + archived, err
+}
+
+// archivable reads the channel's logs and reports whether it has been
+// inactive since before cutoff.
+func (r *Receiver) archivable(ctx context.Context, rec storage.Record, cutoff time.Time) (*archive.Channel, bool, error) {
+	payments, err := r.db.ListChannelPayments(ctx, rec.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	last := rec.Created
+	if len(payments) > 0 {
+		last = payments[len(payments)-1].Time
+	}
+	if last.After(cutoff) {
+		return nil, false, nil
+	}
+	secrets, err := r.db.ListRevocationSecrets(ctx, rec.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	return &archive.Channel{Record: rec, Payments: payments, RevocationSecrets: secrets}, true, nil
+}
+
+// writeBatch writes the channels to a new batch and then turns them into
+// stubs. Channels that changed since they were read are left in storage, to
+// be archived again by a later run.
+func (r *Receiver) writeBatch(ctx context.Context, store archive.Store, batch []archive.Channel) (int, error) {
+	now := time.Now()
+	key, err := archive.Key(now)
+	if err != nil {
+		return 0, err
+	}
+	data, err := archive.Encode(archive.Batch{
+		Version:  archive.Version,
+		Net:      r.Net.Name,
+		Created:  now,
+		Channels: batch,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := store.Put(ctx, key, data); err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, c := range batch {
+		err := r.db.ArchiveChannel(ctx, c.Record.ID, c.Record.Version, key)
+		if errors.Is(err, storage.ErrConflict) {
+			r.log.Warn("channel changed while archiving", "channel", c.Record.ID)
+			continue
+		} else if err != nil {
+			return n, err
+		}
+		n++
+	}
+	r.log.Info("channels archived", "batch", key, "channels", n, "size", len(data))
+	return n, nil
+}
+
+// Archive runs ArchiveClosed every interval until ctx is cancelled.
+func (r *Receiver) Archive(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if _, err := r.ArchiveClosed(ctx); err != nil {
+			r.log.Error("archive failed", "err", err)
+		}
+	}
+}
+
+// archivedChannel reads the archived channel from its batch.
+func (r *Receiver) archivedChannel(ctx context.Context, rec storage.Record) (*archive.Channel, error) {
+	store, _ := r.archiveStore()
+	if store == nil {
+		return nil, errors.New("channel is archived but no archive store is configured")
+	}
+	data, err := store.Get(ctx, rec.Archived)
+	if err != nil {
+		return nil, err
+	}
+	b, err := archive.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	c := b.Find(rec.ID)
+	if c == nil {
+		return nil, errors.New("channel missing from its archive batch " + rec.Archived)
+	}
+	return c, nil
+}
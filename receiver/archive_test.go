@@ -0,0 +1,105 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/archive"
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+func TestArchiveClosed(t *testing.T) {
+	ctx := context.Background()
+	r, open := newOpenReceiver(t, &closeBackend{})
+	store, err := archive.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetArchive(store, time.Hour)
+
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+	idle := storage.Record{
+		ID:          getChannelID(txid, 1),
+		SharedState: channels.SharedState{Status: channels.StatusClosed},
+		Created:     time.Now().Add(-2 * time.Hour),
+	}
+	paid := storage.Record{
+		ID:          getChannelID(txid, 2),
+		SharedState: channels.SharedState{Status: channels.StatusOpen},
+		Created:     time.Now().Add(-2 * time.Hour),
+	}
+	for _, rec := range []storage.Record{idle, paid} {
+		if err := r.db.Create(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	closed := paid.SharedState
+	closed.Status = channels.StatusClosed
+	if err := r.db.Update(ctx, paid.ID, 1, closed, []byte("payment")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.db.AddRevocationSecret(ctx, paid.ID, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the closed channel without recent payments is archived.
+	n, err := r.ArchiveClosed(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 channel archived, got %d", n)
+	}
+	for id, archived := range map[string]bool{idle.ID: true, paid.ID: false, open.ID: false} {
+		rec, err := r.db.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (rec.Archived != "") != archived {
+			t.Errorf("Expected %s archived %v, got %q", id, archived, rec.Archived)
+		}
+	}
+
+	r.SetArchive(store, 0)
+	if n, err := r.ArchiveClosed(ctx); err != nil || n != 1 {
+		t.Errorf("Expected the paid channel to be archived, got %d %v", n, err)
+	}
+	payments, err := r.db.ListPayments(ctx, paid.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 0 {
+		t.Errorf("Expected the payments to be deleted, got %q", payments)
+	}
+
+	// The archived payments are read back from the batch.
+	dump, err := r.Inspect(ctx, txid, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dump.Payments) != 1 || !bytes.Equal(dump.Payments[0], []byte("payment")) {
+		t.Errorf("Expected the archived payment, got %q", dump.Payments)
+	}
+	rec, err := r.db.Get(ctx, paid.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := store.Get(ctx, rec.Archived)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := archive.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := b.Find(paid.ID); c == nil || len(c.RevocationSecrets) != 1 {
+		t.Errorf("Expected the revocation secret in the batch, got %+v", c)
+	}
+
+	if n, err := r.ArchiveClosed(ctx); err != nil || n != 0 {
+		t.Errorf("Expected nothing left to archive, got %d %v", n, err)
+	}
+}
@@ -0,0 +1,133 @@
+package receiver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/luno/moonbeam/backup"
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+var ErrNoBackupKey = NewExposableError("no backup key configured")
+
+// SetBackupKey sets the key that backups are encrypted with. It must be
+// backup.KeySize bytes, e.g. from backup.ParseKey.
+func (r *Receiver) SetBackupKey(key []byte) error {
+	if len(key) != backup.KeySize {
+		return backup.ErrInvalidKey
+	}
+	r.backupKey = key
+	return nil
+}
+
+// Backup writes an encrypted archive of the entire stored state to w. It's
+// a consistent snapshot, taken while the receiver keeps serving requests.
+func (r *Receiver) Backup(ctx context.Context, w io.Writer) (*backup.Manifest, error) {
+	if r.backupKey == nil {
+		return nil, ErrNoBackupKey
+	}
+
+	snap, err := r.db.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := backup.Manifest{
+		Version:        backup.Version,
+		Net:            r.Net.Name,
+		Created:        time.Now(),
+		Channels:       snap.Channels,
+		Payments:       snap.Payments,
+		KeyPathCounter: snap.KeyPathCounter,
+	}
+	if err := backup.Write(w, r.backupKey, backup.Archive{Manifest: m, State: snap.Data}); err != nil {
+		return nil, err
+	}
+
+	r.log.Info("backup written", "channels", m.Channels, "payments", m.Payments)
+	return &m, nil
+}
+
+// RestoreBackup replaces the entire stored state with that of an archive
+// written by Backup. The restored state is verified before the call
+// returns: it must match the archive's manifest, the key generations must
+// match the receiver's keys and every channel that isn't frozen must load
+// with its key. If it doesn't, the previous state is put back.
+//
+// The receiver keeps serving requests while the state is restored. Calls
+// that were in flight fail to store their updates, since the channel
+// states they started from have been replaced.
+func (r *Receiver) RestoreBackup(ctx context.Context, rd io.Reader) (*backup.Manifest, error) {
+	if r.backupKey == nil {
+		return nil, ErrNoBackupKey
+	}
+
+	a, err := backup.Read(rd, r.backupKey)
+	if err != nil {
+		return nil, NewExposableError(err.Error())
+	}
+	if a.Net != r.Net.Name {
+		return nil, NewExposableError("backup is for another network")
+	}
+
+	prev, err := r.db.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.db.Restore(ctx, storage.Snapshot{Data: a.State}); err != nil {
+		return nil, NewExposableError("invalid backup: " + err.Error())
+	}
+
+	if err := r.verifyRestore(ctx, a.Manifest); err != nil {
+		if rerr := r.db.Restore(ctx, *prev); rerr != nil {
+			r.alerter.Alert("", "failed to roll back restore: "+rerr.Error())
+			return nil, rerr
+		}
+		if lerr := r.LoadKeyGenerations(ctx); lerr != nil {
+			r.alerter.Alert("", "failed to reload key generations: "+lerr.Error())
+		}
+		return nil, NewExposableError("backup failed verification: " + err.Error())
+	}
+
+	r.log.Warn("backup restored", "created", a.Created,
+		"channels", a.Channels, "payments", a.Payments)
+	return &a.Manifest, nil
+}
+
+// verifyRestore checks the restored state.
+func (r *Receiver) verifyRestore(ctx context.Context, m backup.Manifest) error {
+	snap, err := r.db.Snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	if snap.Channels != m.Channels || snap.Payments != m.Payments ||
+		snap.KeyPathCounter != m.KeyPathCounter {
+		return fmt.Errorf("restored %d channels, %d payments and key path %d, expected %d, %d and %d",
+			snap.Channels, snap.Payments, snap.KeyPathCounter,
+			m.Channels, m.Payments, m.KeyPathCounter)
+	}
+
+	if err := r.LoadKeyGenerations(ctx); err != nil {
+		return err
+	}
+
+	recs, err := r.db.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if rec.Frozen {
+			continue
+		}
+		key, err := r.getAccountKey(ctx, rec.Account, rec.KeyGeneration, rec.KeyPath)
+		if err != nil {
+			return fmt.Errorf("channel %s: %v", rec.ID, err)
+		}
+		if _, err := channels.LoadReceiverWithSigner(r.config, rec.SharedState, key); err != nil {
+			return fmt.Errorf("channel %s: %v", rec.ID, err)
+		}
+	}
+	return nil
+}
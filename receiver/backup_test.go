@@ -0,0 +1,91 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/backup"
+	"github.com/luno/moonbeam/chain"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+	key := bytes.Repeat([]byte{7}, backup.KeySize)
+
+	cb := &closeBackend{status: &chain.TxStatus{Confirmations: 10, BlockHeight: 100}}
+	newReceiver := func(seed byte) *Receiver {
+		ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{seed}, 32), net)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := memory.New()
+		r := NewReceiver(net, ek, heightBackend{cb}, db, NewDirectory("example.com"), keytest.Address(1, net), "")
+		r.SetKeyGapLimit(10)
+		if err := r.SetBackupKey(key); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.LoadKeyGenerations(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	r := newReceiver(1)
+	openTestChannel(t, r, cb, txid, 1000)
+	id := getChannelID(txid, 0)
+
+	var archive bytes.Buffer
+	m, err := r.Backup(ctx, &archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Channels != 1 || m.Payments != 1 || m.KeyPathCounter != 4 || m.Net != net.Name {
+		t.Errorf("Unexpected manifest %+v", m)
+	}
+
+	if err := r.db.Freeze(ctx, id, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.RestoreBackup(ctx, bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := r.db.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Frozen || rec.SharedState.Balance != 1000 {
+		t.Errorf("Expected state of the backup, got %+v", rec)
+	}
+
+	// A modified archive is rejected.
+	tampered := append([]byte(nil), archive.Bytes()...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := r.RestoreBackup(ctx, bytes.NewReader(tampered)); err == nil {
+		t.Error("Expected modified archive to be rejected")
+	}
+
+	// Another receiver's channels don't load with this receiver's keys, so
+	// its state is put back.
+	other := newReceiver(2)
+	if _, err := other.RestoreBackup(ctx, bytes.NewReader(archive.Bytes())); err == nil {
+		t.Error("Expected verification to fail")
+	}
+	if _, err := other.db.Get(ctx, id); err == nil {
+		t.Error("Expected previous state to be restored")
+	}
+	gens, err := other.KeyGenerations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gens) != 1 {
+		t.Errorf("Expected own key generation, got %+v", gens)
+	}
+}
@@ -0,0 +1,95 @@
+package receiver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
+)
+
+// lifecycleEvent is published on the receiver's bus when a channel changes.
+// It's one of channelCreated, channelOpened, paymentAccepted,
+// channelClosing or channelClosed.
+type lifecycleEvent interface {
+	// event returns the event as reported to webhooks and subscribers.
+	event() Event
+}
+
+type channelCreated struct {
+	state channels.SharedState
+}
+
+type channelOpened struct {
+	id    string
+	state channels.SharedState
+}
+
+// paymentAccepted is published once a payment has been stored. payment is
+// zero if the payment couldn't be decoded.
+type paymentAccepted struct {
+	id          string
+	prev, state channels.SharedState
+	amount      int64
+	payment     models.Payment
+}
+
+type channelClosing struct {
+	id    string
+	state channels.SharedState
+}
+
+type channelClosed struct {
+	id    string
+	state channels.SharedState
+}
+
+func (e channelCreated) event() Event { return stateEvent(EventCreated, "", e.state) }
+func (e channelOpened) event() Event  { return stateEvent(EventOpened, e.id, e.state) }
+func (e channelClosing) event() Event { return stateEvent(EventClosing, e.id, e.state) }
+func (e channelClosed) event() Event  { return stateEvent(EventClosed, e.id, e.state) }
+
+func (e paymentAccepted) event() Event {
+	ev := stateEvent(EventPayment, e.id, e.state)
+	ev.Amount = e.amount
+	ev.Target = e.payment.Target
+	return ev
+}
+
+// bus delivers lifecycle events to the receiver's side effects, such as
+// webhooks, metrics and accounting, so that the request path only needs to
+// publish what happened.
+//
+// Handlers are called in the order they subscribed, on the publisher's
+// goroutine and usually with the channel locked, so they must hand off slow
+// work.
+type bus struct {
+	mu       sync.RWMutex
+	handlers []func(context.Context, lifecycleEvent)
+}
+
+func (b *bus) subscribe(h func(context.Context, lifecycleEvent)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, h)
+}
+
+func (b *bus) publish(ctx context.Context, e lifecycleEvent) {
+	b.mu.RLock()
+	hs := b.handlers
+	b.mu.RUnlock()
+
+	for _, h := range hs {
+		h(ctx, e)
+	}
+}
+
+// subscribeAll subscribes the receiver's own handlers to its bus.
+func (r *Receiver) subscribeAll() {
+	r.bus.subscribe(r.observeEvent)
+	r.bus.subscribe(r.publishEvent)
+	r.bus.subscribe(r.outboxEvent)
+	r.bus.subscribe(r.autoCloseEvent)
+	r.bus.subscribe(r.settleEvent)
+	r.bus.subscribe(r.journalEvent)
+}
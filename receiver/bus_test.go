@@ -0,0 +1,66 @@
+package receiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/logging"
+	"github.com/luno/moonbeam/models"
+)
+
+func TestBusOrder(t *testing.T) {
+	var b bus
+	var got []int
+	for i := 0; i < 3; i++ {
+		i := i
+		b.subscribe(func(ctx context.Context, e lifecycleEvent) {
+			got = append(got, i)
+		})
+	}
+	b.publish(context.Background(), channelCreated{})
+	if len(got) != 3 || got[0] != 0 || got[1] != 1 || got[2] != 2 {
+		t.Errorf("Expected handlers in subscription order, got %v", got)
+	}
+}
+
+func TestPublishTransition(t *testing.T) {
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	r, rec := newOpenReceiver(t, &closeBackend{})
+	r.SetUtilizationPolicy(UtilizationPolicy{Threshold: 0.5})
+
+	events, cancel := r.Subscribe()
+	defer cancel()
+
+	payment, err := models.EncodePayment(models.Payment{Amount: 600, Target: "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := rec.SharedState
+	prev.Capacity = 1000
+	next := prev
+	next.Count, next.Balance = 1, 600
+	r.publishTransition(ctx, rec.ID, prev, next, payment)
+
+	for _, exp := range []EventType{EventPayment, EventExhausted} {
+		e := <-events
+		if e.Type != exp || e.ChannelID != rec.ID {
+			t.Errorf("Expected %s event, got %+v", exp, e)
+		}
+		if e.Type == EventPayment && e.RequestID != "req-1" {
+			t.Errorf("Expected request ID in payment event, got %q", e.RequestID)
+		}
+	}
+
+	closed := next
+	closed.Status = channels.StatusClosed
+	r.publishTransition(ctx, rec.ID, next, closed, nil)
+	if e := <-events; e.Type != EventClosed {
+		t.Errorf("Expected closed event, got %+v", e)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("Unexpected event %+v", e)
+	default:
+	}
+}
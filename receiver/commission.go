@@ -0,0 +1,137 @@
+package receiver
+
+import (
+	"context"
+	"time"
+
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage"
+)
+
+// maxBasisPoints is a commission of 100%.
+const maxBasisPoints = 10000
+
+// CommissionPolicy sets the share of each payment the receiver keeps when
+// targets belong to third parties. Rates are in basis points.
+type CommissionPolicy struct {
+	// Rate applies to targets without their own rate.
+	Rate int64
+
+	// Targets overrides the rate for specific targets.
+	Targets map[string]int64
+}
+
+func (p CommissionPolicy) commission(target string, amount int64) int64 {
+	rate, ok := p.Targets[target]
+	if !ok {
+		rate = p.Rate
+	}
+	if rate < 0 {
+		rate = 0
+	} else if rate > maxBasisPoints {
+		rate = maxBasisPoints
+	}
+	return amount * rate / maxBasisPoints
+}
+
+// SetCommissionPolicy sets the commission recorded with each accepted
+// payment. Payments already accepted keep the commission recorded with them.
+func (r *Receiver) SetCommissionPolicy(p CommissionPolicy) {
+	r.settings.update(func(s *Settings) { s.Commission = p })
+}
+
+// targetCredit returns the credit of the payment to its target, which Send
+// stores with the payment.
+func (r *Receiver) targetCredit(id string, p *models.Payment) *storage.TargetCredit {
+	return &storage.TargetCredit{
+		ChannelID:  id,
+		Target:     p.Target,
+		Amount:     p.Amount,
+		Commission: r.settings.get().Commission.commission(p.Target, p.Amount),
+		Time:       time.Now(),
+	}
+}
+
+// visibleTarget reports whether the context's account accepts payments for
+// the target.
+func (r *Receiver) visibleTarget(ctx context.Context, target string) bool {
+	id, ok := accountFromContext(ctx)
+	if !ok {
+		return true
+	}
+	if _, ok := r.accounts[id]; !ok && id != "" {
+		return false
+	}
+	has, err := r.directory(id).HasTarget(target)
+	return err == nil && has
+}
+
+// TargetBalances returns the gross amount paid to each target and the
+// commission kept by the receiver. If the context is scoped to an account,
+// only its targets are included.
+func (r *Receiver) TargetBalances(ctx context.Context) ([]storage.TargetBalance, error) {
+	balances, err := r.db.ListTargetBalances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var res []storage.TargetBalance
+	for _, b := range balances {
+		if r.visibleTarget(ctx, b.Target) {
+			res = append(res, b)
+		}
+	}
+	return res, nil
+}
+
+// AccountingTotals sums the payments credited over a period.
+type AccountingTotals struct {
+	Count      int64
+	Gross      int64
+	Commission int64
+	Net        int64
+}
+
+// Totals returns the totals of payments credited at or after from and
+// before to. Zero bounds are ignored. If the context is scoped to an
+// account, only its targets are included.
+func (r *Receiver) Totals(ctx context.Context, from, to time.Time) (*AccountingTotals, error) {
+	credits, err := r.db.ListTargetCredits(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	var t AccountingTotals
+	for _, c := range credits {
+		if !r.visibleTarget(ctx, c.Target) {
+			continue
+		}
+		t.Count++
+		t.Gross += c.Amount
+		t.Commission += c.Commission
+	}
+	t.Net = t.Gross - t.Commission
+	return &t, nil
+}
+
+// DailyTotals returns the per-day totals of payments credited to the
+// target, or to all targets if it's empty, from the UTC day of from up to
+// but excluding the day of to. Zero bounds are ignored. If the context is
+// scoped to an account, only its targets are included.
+func (r *Receiver) DailyTotals(ctx context.Context, target string, from, to time.Time) ([]storage.DailyTotal, error) {
+	if !from.IsZero() {
+		from = storage.Day(from)
+	}
+	if !to.IsZero() {
+		to = storage.Day(to)
+	}
+	totals, err := r.db.ListDailyTotals(ctx, target, from, to)
+	if err != nil {
+		return nil, err
+	}
+	var res []storage.DailyTotal
+	for _, t := range totals {
+		if r.visibleTarget(ctx, t.Target) {
+			res = append(res, t)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,126 @@
+package receiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage"
+)
+
+func TestCommission(t *testing.T) {
+	p := CommissionPolicy{Rate: 250, Targets: map[string]int64{"partner": 100, "free": 0}}
+
+	tests := []struct {
+		target string
+		amount int64
+		exp    int64
+	}{
+		{"other", 10000, 250},
+		{"partner", 10000, 100},
+		{"free", 10000, 0},
+		{"other", 39, 0},
+	}
+	for _, test := range tests {
+		if c := p.commission(test.target, test.amount); c != test.exp {
+			t.Errorf("%s %d: expected %d, got %d", test.target, test.amount, test.exp, c)
+		}
+	}
+}
+
+func TestTargetBalances(t *testing.T) {
+	ctx := context.Background()
+	r, rec := newOpenReceiver(t, &closeBackend{})
+	r.SetCommissionPolicy(CommissionPolicy{Rate: 1000})
+
+	start := time.Now()
+	for _, p := range []models.Payment{
+		{Target: "a", Amount: 1000},
+		{Target: "a", Amount: 2000},
+		{Target: "b", Amount: 500},
+	} {
+		p := p
+		if err := r.db.AddTargetCredit(ctx, *r.targetCredit(rec.ID, &p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	balances, err := r.TargetBalances(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 2 {
+		t.Fatalf("Expected 2 balances, got %+v", balances)
+	}
+	a := balances[0]
+	if a.Target != "a" || a.Count != 2 || a.Gross != 3000 || a.Commission != 300 || a.Net() != 2700 {
+		t.Errorf("Unexpected balance %+v", a)
+	}
+
+	totals, err := r.Totals(ctx, start, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := AccountingTotals{Count: 3, Gross: 3500, Commission: 350, Net: 3150}
+	if *totals != exp {
+		t.Errorf("Expected %+v, got %+v", exp, *totals)
+	}
+
+	totals, err = r.Totals(ctx, time.Time{}, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals.Count != 0 {
+		t.Errorf("Expected no credits before start, got %+v", *totals)
+	}
+
+	// Targets outside the account's domain aren't visible to it.
+	balances, err = r.TargetBalances(WithAccount(ctx, "nope"))
+	if err != nil || len(balances) != 0 {
+		t.Errorf("Expected no balances for unknown account, got %+v %v", balances, err)
+	}
+}
+
+func TestDailyTotals(t *testing.T) {
+	ctx := context.Background()
+	r, rec := newOpenReceiver(t, &closeBackend{})
+
+	day1 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	for _, c := range []storage.TargetCredit{
+		{Target: "a", Amount: 1000, Commission: 10, Time: day1.Add(time.Hour)},
+		{Target: "a", Amount: 2001, Commission: 20, Time: day1.Add(23 * time.Hour)},
+		{Target: "b", Amount: 500, Time: day1.Add(2 * time.Hour)},
+		{Target: "a", Amount: 700, Time: day2.Add(time.Minute)},
+	} {
+		c.ChannelID = rec.ID
+		if err := r.db.AddTargetCredit(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	totals, err := r.DailyTotals(ctx, "", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 3 {
+		t.Fatalf("Expected 3 daily totals, got %+v", totals)
+	}
+	a := totals[0]
+	if !a.Day.Equal(day1) || a.Target != "a" || a.Count != 2 || a.Gross != 3001 ||
+		a.Net() != 2971 || a.Average() != 1500 {
+		t.Errorf("Unexpected daily total %+v", a)
+	}
+	if totals[1].Target != "b" || !totals[2].Day.Equal(day2) {
+		t.Errorf("Unexpected order %+v", totals)
+	}
+
+	totals, err = r.DailyTotals(ctx, "a", day1.Add(time.Hour), day2.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || !totals[0].Day.Equal(day1) || totals[0].Target != "a" {
+		t.Errorf("Expected first day of a, got %+v", totals)
+	}
+}
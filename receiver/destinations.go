@@ -0,0 +1,118 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// xpubDestinations derives a fresh settlement address for each channel from
+// an extended public key, so that settlements can't be linked on-chain and
+// can be attributed to their channel. Addresses are derived on the
+// external chain, i.e. at xpub/0/i.
+type xpubDestinations struct {
+	xpub     string
+	external *hdkeychain.ExtendedKey
+	net      *chaincfg.Params
+}
+
+func newXPubDestinations(net *chaincfg.Params, xpub string) (*xpubDestinations, error) {
+	ek, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return nil, err
+	}
+	if ek.IsPrivate() {
+		return nil, errors.New("destination key must be an extended public key")
+	}
+	if !ek.IsForNet(net) {
+		return nil, errors.New("destination key is for the wrong network")
+	}
+	external, err := ek.Child(0)
+	if err != nil {
+		return nil, err
+	}
+	return &xpubDestinations{xpub: xpub, external: external, net: net}, nil
+}
+
+func (d *xpubDestinations) address(i uint32) (string, error) {
+	if i >= hdkeychain.HardenedKeyStart {
+		return "", errors.New("invalid destination index")
+	}
+	ek, err := d.external.Child(i)
+	if err != nil {
+		return "", err
+	}
+	addr, err := ek.Address(d.net)
+	if err != nil {
+		return "", err
+	}
+	return addr.EncodeAddress(), nil
+}
+
+// SetDestinationXPub makes the default account settle each channel to a
+// fresh address derived from the extended public key instead of the fixed
+// destination. Channels created before keep their destination.
+func (r *Receiver) SetDestinationXPub(xpub string) error {
+	d, err := newXPubDestinations(r.Net, xpub)
+	if err != nil {
+		return err
+	}
+	r.destinations = d
+	return nil
+}
+
+// destinationsFor returns the fixed destination and the xpub destinations,
+// if any, of the account.
+func (r *Receiver) destinationsFor(accountID string) (string, *xpubDestinations, error) {
+	if accountID == "" {
+		return r.receiverOutput, r.destinations, nil
+	}
+	a, ok := r.accounts[accountID]
+	if !ok {
+		return "", nil, ErrUnknownAccount
+	}
+	return a.Destination, a.destinations, nil
+}
+
+// newDestination returns the settlement address of a new channel of the
+// account. If it's derived from an xpub, its index is also returned,
+// otherwise the index is -1.
+func (r *Receiver) newDestination(ctx context.Context, accountID string) (string, int, error) {
+	fixed, xd, err := r.destinationsFor(accountID)
+	if err != nil {
+		return "", 0, err
+	}
+	if xd == nil {
+		return fixed, -1, nil
+	}
+	i, err := r.db.ReserveDestinationIndex(ctx, xd.xpub)
+	if err != nil {
+		return "", 0, err
+	}
+	addr, err := xd.address(i)
+	if err != nil {
+		return "", 0, err
+	}
+	return addr, int(i), nil
+}
+
+// destination returns the settlement address of a channel being opened,
+// given the index returned by newDestination.
+func (r *Receiver) destination(accountID string, index int) (string, error) {
+	fixed, xd, err := r.destinationsFor(accountID)
+	if err != nil {
+		return "", err
+	}
+	if index < 0 {
+		if fixed == "" {
+			return "", errors.New("invalid receiverData")
+		}
+		return fixed, nil
+	}
+	if xd == nil {
+		return "", errors.New("invalid receiverData")
+	}
+	return xd.address(uint32(index))
+}
@@ -0,0 +1,120 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+func TestReceiverData(t *testing.T) {
+	tests := []struct {
+		account   string
+		destIndex int
+		encoded   string
+	}{
+		{"", -1, "0"},
+		{"shop", -1, "shop/0"},
+		{"", 7, "0:7"},
+		{"shop", 7, "shop/0:7"},
+	}
+	for _, test := range tests {
+		want := receiverData{account: test.account, destIndex: test.destIndex}
+		buf := encodeReceiverData(want)
+		if string(buf) != test.encoded {
+			t.Errorf("Expected %q, got %q", test.encoded, buf)
+		}
+		got, err := decodeReceiverData(buf, 0)
+		if err != nil || got != want {
+			t.Errorf("%q decoded to %+v %v", buf, got, err)
+		}
+	}
+
+	for _, bad := range []string{"", "1", "0:", "0:-1", "0:x", "0:2147483648"} {
+		if _, err := decodeReceiverData([]byte(bad), 0); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+
+	want := receiverData{account: "shop", keyGen: 2, keyPath: 5, destIndex: 2}
+	if buf := encodeReceiverData(want); string(buf) != "shop/2.5:2" {
+		t.Errorf("Unexpected encoding %q", buf)
+	}
+	got, err := decodeReceiverData([]byte("shop/2.5:2"), 5)
+	if err != nil || got != want {
+		t.Errorf("Unexpected reserved key path decoding %+v %v", got, err)
+	}
+	for _, bad := range []string{"6", "05", "+5", "0.5", "01.5", ".5", "1."} {
+		if _, err := decodeReceiverData([]byte(bad), 5); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestDestinationXPub(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := memory.New()
+	r := NewReceiver(net, ek, &closeBackend{}, db, nil, "", "")
+
+	if err := r.SetDestinationXPub(ek.String()); err == nil {
+		t.Errorf("Expected private key to be rejected")
+	}
+	wallet, err := hdkeychain.NewMaster(bytes.Repeat([]byte{2}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xpub, err := wallet.Neuter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetDestinationXPub(xpub.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := channels.NewSender(channels.DefaultSenderConfig, keytest.Key(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := s.GetCreateRequest(keytest.Address(4, net))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		resp, err := r.Create(ctx, *req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[resp.ReceiverOutput] {
+			t.Errorf("Destination %s reused", resp.ReceiverOutput)
+		}
+		seen[resp.ReceiverOutput] = true
+
+		rd, err := decodeReceiverData(resp.ReceiverData, 0)
+		if err != nil || rd.destIndex != i {
+			t.Errorf("Expected destination index %d, got %d %v", i, rd.destIndex, err)
+		}
+		expected, err := r.destination("", rd.destIndex)
+		if err != nil || expected != resp.ReceiverOutput {
+			t.Errorf("Expected %s, got %s %v", resp.ReceiverOutput, expected, err)
+		}
+	}
+
+	// Without a fixed destination, channels must carry a destination index.
+	if _, err := r.destination("", -1); err == nil {
+		t.Errorf("Expected missing destination index to be rejected")
+	}
+}
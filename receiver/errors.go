@@ -1,7 +1,15 @@
 package receiver
 
+import (
+	"fmt"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
+)
+
 type ExposableError struct {
-	err string
+	err    string
+	reason models.Reason
 }
 
 func NewExposableError(err string) ExposableError {
@@ -10,6 +18,67 @@ func NewExposableError(err string) ExposableError {
 	}
 }
 
+// NewRejection returns an ExposableError for a payment rejected for reason.
+func NewRejection(reason models.Reason, err string) ExposableError {
+	return ExposableError{
+		err:    err,
+		reason: reason,
+	}
+}
+
 func (e ExposableError) Error() string {
 	return e.err
 }
+
+// Reason returns why the payment was rejected, or an empty reason if the
+// error isn't a payment rejection.
+func (e ExposableError) Reason() models.Reason {
+	return e.reason
+}
+
+// rejectionReasons maps the channels package's errors for invalid payments
+// to reasons.
+var rejectionReasons = map[error]models.Reason{
+	channels.ErrNotStatusOpen:           models.ReasonChannelNotOpen,
+	channels.ErrAmountTooSmall:          models.ReasonAmountTooSmall,
+	channels.ErrAmountTooLarge:          models.ReasonAmountTooLarge,
+	channels.ErrInsufficientCapacity:    models.ReasonCapacityExhausted,
+	channels.ErrInvalidPayment:          models.ReasonInvalidPayment,
+	channels.ErrInvalidSenderSig:        models.ReasonInvalidSignature,
+	channels.ErrInvalidFeeSig:           models.ReasonInvalidSignature,
+	channels.ErrInvalidRevocationSecret: models.ReasonInvalidSignature,
+	channels.ErrHoldNotFound:            models.ReasonInvalidHold,
+	channels.ErrCaptureExceedsHold:      models.ReasonInvalidHold,
+}
+
+// rejection returns err as a rejection if it's one of the channels
+// package's errors for invalid payments, or err otherwise.
+func rejection(err error) error {
+	if reason, ok := rejectionReasons[err]; ok {
+		return NewRejection(reason, err.Error())
+	}
+	return err
+}
+
+// FundingRangeError is returned by Open if the funding amount is outside the
+// range accepted by the receiver. Max is zero if there is no maximum.
+type FundingRangeError struct {
+	Value int64
+	Min   int64
+	Max   int64
+}
+
+func (e FundingRangeError) Error() string {
+	if e.Max > 0 {
+		return fmt.Sprintf("funding amount %d outside accepted range %d to %d",
+			e.Value, e.Min, e.Max)
+	}
+	return fmt.Sprintf("funding amount %d below minimum %d", e.Value, e.Min)
+}
+
+var ErrFrozen = NewRejection(models.ReasonChannelNotOpen, "channel is frozen")
+var ErrSuspended = NewRejection(models.ReasonChannelNotOpen, "channel is suspended")
+var ErrDuplicatePayment = NewRejection(models.ReasonDuplicatePayment, "duplicate payment")
+var ErrWrongPaymentCounter = NewRejection(models.ReasonWrongCounter, "wrong payment counter")
+var ErrChannelExpiring = NewRejection(models.ReasonChannelExpiring, "channel is expiring")
+var ErrPaymentIDReused = NewExposableError("payment ID reused for a different payment")
@@ -0,0 +1,149 @@
+package receiver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/logging"
+	"github.com/luno/moonbeam/models"
+)
+
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventOpened  EventType = "opened"
+	EventPayment EventType = "payment"
+	EventClosing EventType = "closing"
+	EventClosed  EventType = "closed"
+
+	// EventFunded is sent when the funding address of a created channel
+	// receives an output with enough confirmations to be opened.
+	EventFunded EventType = "funded"
+
+	// EventExhausted is sent when a channel's balance reaches the
+	// utilization threshold.
+	EventExhausted EventType = "exhausted"
+
+	// EventExpiring is sent when an open channel comes within the expiry
+	// warning of its timeout.
+	EventExpiring EventType = "expiring"
+)
+
+// Event describes channel activity. Created events have no channel ID since
+// the channel isn't funded yet.
+type Event struct {
+	Type      EventType `json:"type"`
+	ChannelID string    `json:"channelID,omitempty"`
+	Time      time.Time `json:"time"`
+
+	Status  int   `json:"status"`
+	Balance int64 `json:"balance"`
+
+	// Amount and Target are set for payment events.
+	Amount int64  `json:"amount,omitempty"`
+	Target string `json:"target,omitempty"`
+
+	// BlocksRemaining is set for expiring events.
+	BlocksRemaining int64 `json:"blocksRemaining,omitempty"`
+
+	// RequestID is the ID of the request that caused the event, if any.
+	RequestID string `json:"requestID,omitempty"`
+}
+
+// eventBuffer is the number of events buffered per subscriber. Events are
+// dropped for subscribers that fall further behind than this.
+const eventBuffer = 64
+
+type events struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Subscribe returns a channel of events for all channels, and a function
+// that must be called to unsubscribe. Events are dropped if the subscriber
+// doesn't keep up.
+func (r *Receiver) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+
+	r.events.mu.Lock()
+	if r.events.subs == nil {
+		r.events.subs = make(map[chan Event]struct{})
+	}
+	r.events.subs[ch] = struct{}{}
+	r.events.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			r.events.mu.Lock()
+			delete(r.events.subs, ch)
+			r.events.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+func (r *Receiver) publish(e Event) {
+	e.Time = time.Now()
+
+	r.notifyWebhooks(e)
+
+	r.events.mu.Lock()
+	defer r.events.mu.Unlock()
+
+	for ch := range r.events.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func stateEvent(t EventType, id string, s channels.SharedState) Event {
+	return Event{
+		Type:      t,
+		ChannelID: id,
+		Status:    int(s.Status),
+		Balance:   s.Balance,
+	}
+}
+
+// publishEvent reports lifecycle events to webhooks and subscribers.
+func (r *Receiver) publishEvent(ctx context.Context, e lifecycleEvent) {
+	ev := e.event()
+	ev.RequestID = logging.RequestID(ctx)
+	r.publish(ev)
+}
+
+// publishTransition publishes the lifecycle events implied by a stored
+// state transition.
+func (r *Receiver) publishTransition(ctx context.Context, id string, prev, next channels.SharedState, payment []byte) {
+	if next.Count > prev.Count {
+		e := paymentAccepted{
+			id:     id,
+			prev:   prev,
+			state:  next,
+			amount: next.Balance - prev.Balance,
+		}
+		if p, err := models.DecodeStoredPayment(payment); err == nil {
+			e.payment = *p
+		}
+		r.bus.publish(ctx, e)
+	}
+
+	if next.Status == prev.Status {
+		return
+	}
+	switch next.Status {
+	case channels.StatusOpen:
+		r.bus.publish(ctx, channelOpened{id, next})
+	case channels.StatusClosing:
+		r.bus.publish(ctx, channelClosing{id, next})
+	case channels.StatusClosed:
+		r.bus.publish(ctx, channelClosed{id, next})
+	}
+}
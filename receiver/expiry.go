@@ -0,0 +1,95 @@
+package receiver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// expiry tracks the open channels that are within the expiry warning of
+// their timeout.
+type expiry struct {
+	mu       sync.Mutex
+	warning  int64
+	expiring map[string]int64
+}
+
+// SetExpiryWarning sets the number of blocks before a channel's refund
+// becomes valid at which the receiver warns that the channel is still open.
+// Zero uses the network's close window, by which Watch should already have
+// closed the channel.
+func (r *Receiver) SetExpiryWarning(blocks int64) {
+	r.expiry.mu.Lock()
+	defer r.expiry.mu.Unlock()
+	r.expiry.warning = blocks
+}
+
+func (r *Receiver) expiryWarning() int64 {
+	r.expiry.mu.Lock()
+	defer r.expiry.mu.Unlock()
+	if r.expiry.warning > 0 {
+		return r.expiry.warning
+	}
+	return int64(r.getPolicy().CloseWindow)
+}
+
+// checkExpiry warns about confirmed channels that are still open within the
+// expiry warning of their timeout, for example because they're frozen or
+// closing them failed. Once the sender's refund is valid, the receiver can
+// lose the channel's whole balance. Each channel is logged, alerted and
+// sent as an expiring event once, when it comes within the warning.
+func (r *Receiver) checkExpiry(ctx context.Context, blockCount int64) error {
+	recs, err := r.db.List(ctx)
+	if err != nil {
+		return err
+	}
+	warning := r.expiryWarning()
+
+	r.expiry.mu.Lock()
+	prev := r.expiry.expiring
+	r.expiry.mu.Unlock()
+
+	expiring := make(map[string]int64)
+	for _, rec := range recs {
+		s := rec.SharedState
+		if !s.Status.IsOpen() || s.BlockHeight == 0 {
+			continue
+		}
+		remaining := int64(s.BlockHeight) + s.Timeout - blockCount
+		if remaining > warning {
+			continue
+		}
+		expiring[rec.ID] = remaining
+		if _, ok := prev[rec.ID]; ok {
+			continue
+		}
+
+		r.metrics.expiryWarnings.Inc()
+		r.log.Warn("channel nearing expiry", "channel", rec.ID,
+			"blocks_remaining", remaining, "balance", s.Balance,
+			"frozen", rec.Frozen, "suspended", rec.Suspended)
+		r.alerter.Alert(rec.ID, fmt.Sprintf(
+			"channel still open %d blocks before the sender's refund is valid", remaining))
+
+		e := stateEvent(EventExpiring, rec.ID, s)
+		e.BlocksRemaining = remaining
+		r.publish(e)
+	}
+
+	r.expiry.mu.Lock()
+	r.expiry.expiring = expiring
+	r.expiry.mu.Unlock()
+	return nil
+}
+
+// expiringChannels returns the blocks remaining until the timeout of each
+// channel found within the expiry warning by the last check.
+func (r *Receiver) expiringChannels() map[string]float64 {
+	r.expiry.mu.Lock()
+	defer r.expiry.mu.Unlock()
+	res := make(map[string]float64)
+	for id, n := range r.expiry.expiring {
+		res[id] = float64(n)
+	}
+	return res
+}
@@ -0,0 +1,61 @@
+package receiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+func TestCheckExpiry(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newOpenReceiver(t, &closeBackend{})
+	a := &recordingAlerter{}
+	r.SetAlerter(a)
+	r.SetExpiryWarning(10)
+
+	rec := storage.Record{
+		ID: "expiring-0",
+		SharedState: channels.SharedState{
+			Status:      channels.StatusOpen,
+			BlockHeight: 100,
+			Timeout:     1008,
+		},
+		Frozen: true,
+	}
+	if err := r.db.Create(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	events, cancel := r.Subscribe()
+	defer cancel()
+
+	if err := r.checkExpiry(ctx, 1097); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.alerts) != 0 {
+		t.Errorf("Unexpected alerts %q", a.alerts)
+	}
+
+	for _, height := range []int64{1098, 1100} {
+		if err := r.checkExpiry(ctx, height); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(a.alerts) != 1 {
+		t.Errorf("Expected a single alert, got %q", a.alerts)
+	}
+	if e := <-events; e.Type != EventExpiring || e.ChannelID != rec.ID || e.BlocksRemaining != 10 {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	if got := r.expiringChannels(); len(got) != 1 || got[rec.ID] != 8 {
+		t.Errorf("Unexpected expiring channels %v", got)
+	}
+
+	// The default warning is the network's close window.
+	r.SetExpiryWarning(0)
+	if w := r.expiryWarning(); w != int64(r.getPolicy().CloseWindow) {
+		t.Errorf("Expected close window, got %d", w)
+	}
+}
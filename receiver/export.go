@@ -0,0 +1,235 @@
+package receiver
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage"
+)
+
+// ExportFormat is the encoding of exported rows.
+type ExportFormat string
+
+const (
+	// ExportCSV writes a header row followed by a row per record.
+	ExportCSV ExportFormat = "csv"
+
+	// ExportJSON writes a JSON object per line.
+	ExportJSON ExportFormat = "json"
+)
+
+// ParseExportFormat returns the format with the given name.
+func ParseExportFormat(s string) (ExportFormat, bool) {
+	switch f := ExportFormat(s); f {
+	case ExportCSV, ExportJSON:
+		return f, true
+	default:
+		return "", false
+	}
+}
+
+// exportPageSize is the number of channels read from storage at a time.
+const exportPageSize = 500
+
+// ExportedChannel is a row of ExportChannels.
+type ExportedChannel struct {
+	ID           string            `json:"id"`
+	Account      string            `json:"account,omitempty"`
+	Status       string            `json:"status"`
+	SenderPubKey string            `json:"senderPubKey"`
+	Capacity     int64             `json:"capacity"`
+	Balance      int64             `json:"balance"`
+	Fee          int64             `json:"fee"`
+	Count        int               `json:"count"`
+	Created      time.Time         `json:"created"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+// ExportedPayment is a row of ExportPayments.
+type ExportedPayment struct {
+	ChannelID string    `json:"channelID"`
+	Time      time.Time `json:"time"`
+	Counter   int       `json:"counter"`
+	Amount    int64     `json:"amount"`
+	Target    string    `json:"target"`
+}
+
+var channelHeader = []string{"id", "account", "status", "sender_pubkey",
+	"capacity", "balance", "fee", "count", "created", "labels"}
+
+var paymentHeader = []string{"channel_id", "time", "counter", "amount", "target"}
+
+func (c ExportedChannel) csvRow() []string {
+	var labels []string
+	for k, v := range c.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	return []string{c.ID, c.Account, c.Status, c.SenderPubKey,
+		strconv.FormatInt(c.Capacity, 10), strconv.FormatInt(c.Balance, 10),
+		strconv.FormatInt(c.Fee, 10), strconv.Itoa(c.Count),
+		formatExportTime(c.Created), strings.Join(labels, ";")}
+}
+
+func (p ExportedPayment) csvRow() []string {
+	return []string{p.ChannelID, formatExportTime(p.Time), strconv.Itoa(p.Counter),
+		strconv.FormatInt(p.Amount, 10), p.Target}
+}
+
+// formatExportTime formats t in RFC 3339, or returns an empty string for
+// times that weren't recorded.
+func formatExportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// exportWriter writes rows in either format.
+type exportWriter struct {
+	format ExportFormat
+	csv    *csv.Writer
+	json   *json.Encoder
+}
+
+func newExportWriter(w io.Writer, format ExportFormat, header []string) (*exportWriter, error) {
+	ew := &exportWriter{format: format}
+	if format == ExportJSON {
+		ew.json = json.NewEncoder(w)
+		return ew, nil
+	}
+	ew.csv = csv.NewWriter(w)
+	return ew, ew.csv.Write(header)
+}
+
+func (ew *exportWriter) write(row interface{ csvRow() []string }) error {
+	if ew.json != nil {
+		return ew.json.Encode(row)
+	}
+	return ew.csv.Write(row.csvRow())
+}
+
+// flush writes buffered rows, which is done after every page so that rows
+// are streamed as they're read.
+func (ew *exportWriter) flush() error {
+	if ew.csv == nil {
+		return nil
+	}
+	ew.csv.Flush()
+	return ew.csv.Error()
+}
+
+// eachChannel calls fn with the channels matching f, reading them from
+// storage a page at a time. If the context is scoped to an account, only
+// its channels are included.
+func (r *Receiver) eachChannel(ctx context.Context, f storage.ListFilter, fn func(storage.Record) error) error {
+	var cursor string
+	for {
+		recs, next, err := r.List(ctx, f, cursor, exportPageSize)
+		if err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// ExportChannels writes the channels matching f to w. Channels are read
+// and written a page at a time, so any number can be exported.
+func (r *Receiver) ExportChannels(ctx context.Context, w io.Writer, format ExportFormat, f storage.ListFilter) error {
+	ew, err := newExportWriter(w, format, channelHeader)
+	if err != nil {
+		return err
+	}
+	var n int
+	err = r.eachChannel(ctx, f, func(rec storage.Record) error {
+		s := rec.SharedState
+		err := ew.write(ExportedChannel{
+			ID:           rec.ID,
+			Account:      rec.Account,
+			Status:       s.Status.String(),
+			SenderPubKey: hex.EncodeToString(s.SenderPubKey),
+			Capacity:     s.Capacity,
+			Balance:      s.Balance,
+			Fee:          s.Fee,
+			Count:        s.Count,
+			Created:      rec.Created,
+			Labels:       rec.Labels,
+		})
+		if err != nil {
+			return err
+		}
+		if n++; n%exportPageSize == 0 {
+			return ew.flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return ew.flush()
+}
+
+// ExportPayments writes the decoded payments of the channels matching f
+// that also match q to w, ordered by channel and then by counter. Payments
+// are read a channel at a time, so any number can be exported. Payments
+// that can't be decoded are skipped.
+func (r *Receiver) ExportPayments(ctx context.Context, w io.Writer, format ExportFormat, f storage.ListFilter, q PaymentQuery) error {
+	ew, err := newExportWriter(w, format, paymentHeader)
+	if err != nil {
+		return err
+	}
+	err = r.eachChannel(ctx, f, func(rec storage.Record) error {
+		stored, err := r.db.ListChannelPayments(ctx, rec.ID)
+		if err != nil {
+			return err
+		}
+		for i, sp := range stored {
+			if (!q.From.IsZero() && sp.Time.Before(q.From)) ||
+				(!q.To.IsZero() && !sp.Time.Before(q.To)) {
+				continue
+			}
+			p, err := models.DecodeStoredPayment(sp.Payment)
+			if err != nil {
+				r.log.Debug("skipping undecodable payment", "channel", rec.ID, "err", err)
+				continue
+			}
+			if !q.match(*p) {
+				continue
+			}
+			err = ew.write(ExportedPayment{
+				ChannelID: rec.ID,
+				Time:      sp.Time,
+				Counter:   i + 1,
+				Amount:    p.Amount,
+				Target:    p.Target,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return ew.flush()
+	})
+	if err != nil {
+		return err
+	}
+	return ew.flush()
+}
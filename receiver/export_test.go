@@ -0,0 +1,82 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage"
+)
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	r, rec := newOpenReceiver(t, &closeBackend{})
+
+	prev := rec.SharedState
+	for i, p := range []models.Payment{
+		{Amount: 100, Target: "a", Counter: 1},
+		{Amount: 250, Target: "b,c", Counter: 2},
+	} {
+		payment, err := models.EncodePayment(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := prev
+		next.Count, next.Balance = i+1, prev.Balance+p.Amount
+		if err := r.db.Update(ctx, rec.ID, int64(i+1), next, payment); err != nil {
+			t.Fatal(err)
+		}
+		prev = next
+	}
+
+	var buf bytes.Buffer
+	if err := r.ExportPayments(ctx, &buf, ExportCSV, storage.ListFilter{}, PaymentQuery{}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0][0] != "channel_id" || rows[2][0] != rec.ID ||
+		rows[2][2] != "2" || rows[2][3] != "250" || rows[2][4] != "b,c" {
+		t.Errorf("Unexpected payments %q", rows)
+	}
+
+	buf.Reset()
+	q := PaymentQuery{Target: "a"}
+	if err := r.ExportPayments(ctx, &buf, ExportJSON, storage.ListFilter{}, q); err != nil {
+		t.Fatal(err)
+	}
+	var p ExportedPayment
+	if err := json.Unmarshal(buf.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Amount != 100 || p.Counter != 1 || p.Time.IsZero() {
+		t.Errorf("Unexpected payment %+v", p)
+	}
+
+	buf.Reset()
+	if err := r.ExportChannels(ctx, &buf, ExportJSON, storage.ListFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	var c ExportedChannel
+	if err := json.Unmarshal(buf.Bytes(), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != rec.ID || c.Status != "OPEN" || c.Balance != 350 || c.Count != 2 {
+		t.Errorf("Unexpected channel %+v", c)
+	}
+
+	buf.Reset()
+	f := storage.ListFilter{Status: channels.StatusClosed}
+	if err := r.ExportChannels(ctx, &buf, ExportCSV, f); err != nil {
+		t.Fatal(err)
+	}
+	if rows, _ := csv.NewReader(&buf).ReadAll(); len(rows) != 1 {
+		t.Errorf("Expected only a header, got %q", rows)
+	}
+}
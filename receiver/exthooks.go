@@ -0,0 +1,203 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/luno/moonbeam/logging"
+	"github.com/luno/moonbeam/models"
+)
+
+// DefaultHookTimeout bounds calls of external hooks without a timeout.
+const DefaultHookTimeout = 5 * time.Second
+
+// maxHookOutput limits how much of a hook's output is read. Only the first
+// line is used, as the reason for a veto.
+const maxHookOutput = 4096
+
+// ExternalHook is a PaymentHook for integrations that aren't written in Go,
+// in the way of bitcoind's notify options. It either runs a command or
+// posts to a URL, with a HookCall as JSON.
+type ExternalHook struct {
+	// Command is run with sh -c and given the call on stdin. It accepts a
+	// payment by exiting with status 0 and vetoes it by exiting with
+	// status 1, with the reason on stdout.
+	Command string
+
+	// URL is posted the call, signed with Secret like webhooks. It accepts
+	// a payment by responding with a 2xx status and vetoes it with 403,
+	// with the reason as the body.
+	URL    string
+	Secret string
+
+	// Timeout bounds each call. It defaults to DefaultHookTimeout.
+	Timeout time.Duration
+
+	// FailOpen accepts payments if the hook fails, e.g. because it times
+	// out, can't be run or exits with another status. Otherwise they're
+	// rejected with an internal error, which the sender can retry.
+	FailOpen bool
+
+	// NotifyOnly makes the hook only be called after payments are stored,
+	// so that it can't veto them.
+	NotifyOnly bool
+}
+
+// HookStage is when an ExternalHook is called.
+type HookStage string
+
+const (
+	// HookBefore calls are made before a payment is stored and may veto
+	// it.
+	HookBefore HookStage = "before"
+
+	// HookAfter calls are made once a payment is stored.
+	HookAfter HookStage = "after"
+)
+
+// HookCall is sent to an ExternalHook.
+type HookCall struct {
+	Stage   HookStage   `json:"stage"`
+	Payment HookPayment `json:"payment"`
+}
+
+// AddExternalHook registers an external hook called by Send for every
+// payment. Like AddPaymentHook, it must be called before the receiver
+// starts serving requests.
+func (r *Receiver) AddExternalHook(h ExternalHook) error {
+	if (h.Command == "") == (h.URL == "") {
+		return errors.New("external hook needs either a command or a URL")
+	}
+	if h.URL != "" && h.Secret == "" {
+		return errors.New("external hook URL needs a secret")
+	}
+	if h.Timeout == 0 {
+		h.Timeout = DefaultHookTimeout
+	}
+	r.AddPaymentHook(&externalHook{h: h, log: r.log})
+	return nil
+}
+
+type externalHook struct {
+	h   ExternalHook
+	log *slog.Logger
+}
+
+// hookVeto is a hook's rejection of a payment.
+type hookVeto struct {
+	reason string
+}
+
+func (v hookVeto) Error() string {
+	return "payment vetoed by hook: " + v.reason
+}
+
+func (e *externalHook) BeforePayment(ctx context.Context, p HookPayment) error {
+	if e.h.NotifyOnly {
+		return nil
+	}
+	err := e.call(ctx, HookBefore, p)
+	if v, ok := err.(hookVeto); ok {
+		msg := "payment rejected"
+		if v.reason != "" {
+			msg += ": " + v.reason
+		}
+		return NewRejection(models.ReasonInvalidPayment, msg)
+	} else if err != nil {
+		if e.h.FailOpen {
+			e.log.WarnContext(ctx, "payment hook failed, accepting payment",
+				"channel", p.ChannelID, "err", err)
+			return nil
+		}
+		return fmt.Errorf("payment hook: %v", err)
+	}
+	return nil
+}
+
+func (e *externalHook) AfterPayment(ctx context.Context, p HookPayment) error {
+	return e.call(ctx, HookAfter, p)
+}
+
+func (e *externalHook) call(ctx context.Context, stage HookStage, p HookPayment) error {
+	body, err := json.Marshal(HookCall{Stage: stage, Payment: p})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.h.Timeout)
+	defer cancel()
+	if e.h.Command != "" {
+		return e.run(ctx, body)
+	}
+	return e.post(ctx, body)
+}
+
+func (e *externalHook) run(ctx context.Context, body []byte) error {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", e.h.Command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = &out
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if ee, ok := err.(*exec.ExitError); ok && ee.ExitCode() == 1 {
+		return hookVeto{reason: firstLine(out.Bytes())}
+	}
+	return err
+}
+
+func (e *externalHook) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(e.h.Secret, time.Now().Unix(), body))
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHookOutput))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusForbidden {
+		return hookVeto{reason: firstLine(out)}
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("hook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// firstLine returns the first line of a hook's output, for use in an error
+// reported to the sender.
+func firstLine(out []byte) string {
+	if len(out) > maxHookOutput {
+		out = out[:maxHookOutput]
+	}
+	s := string(out)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if len(s) > 256 {
+		s = s[:256]
+	}
+	return s
+}
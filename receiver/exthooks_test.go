@@ -0,0 +1,123 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/models"
+)
+
+func TestExternalHookCommand(t *testing.T) {
+	ctx := context.Background()
+	out := filepath.Join(t.TempDir(), "calls")
+	p := HookPayment{ChannelID: "chan", Payment: models.Payment{Amount: 1000}, Count: 1}
+
+	r, _ := newOpenReceiver(t, &closeBackend{})
+	err := r.AddExternalHook(ExternalHook{Command: "cat >> " + out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.beforePayment(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	r.afterPayment(ctx, p)
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []HookCall
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	for dec.More() {
+		var c HookCall
+		if err := dec.Decode(&c); err != nil {
+			t.Fatal(err)
+		}
+		calls = append(calls, c)
+	}
+	if len(calls) != 2 || calls[0].Stage != HookBefore || calls[1].Stage != HookAfter ||
+		calls[0].Payment.ChannelID != "chan" || calls[0].Payment.Payment.Amount != 1000 {
+		t.Errorf("Unexpected calls %+v", calls)
+	}
+
+	tests := []struct {
+		name   string
+		hook   ExternalHook
+		vetoed bool
+		failed bool
+	}{
+		{"veto", ExternalHook{Command: "echo sold out; exit 1"}, true, false},
+		{"fail closed", ExternalHook{Command: "exit 2"}, false, true},
+		{"fail open", ExternalHook{Command: "exit 2", FailOpen: true}, false, false},
+		{"timeout", ExternalHook{Command: "sleep 5", Timeout: 50 * time.Millisecond}, false, true},
+		{"notify only", ExternalHook{Command: "exit 1", NotifyOnly: true}, false, false},
+	}
+	for _, test := range tests {
+		r, _ := newOpenReceiver(t, &closeBackend{})
+		if err := r.AddExternalHook(test.hook); err != nil {
+			t.Fatal(err)
+		}
+		err := r.beforePayment(ctx, p)
+		e, isRejection := err.(ExposableError)
+		if test.vetoed && (!isRejection || e.Error() != "payment rejected: sold out" ||
+			e.Reason() != models.ReasonInvalidPayment) {
+			t.Errorf("%s: expected veto, got %v", test.name, err)
+		}
+		if test.failed && (err == nil || isRejection) {
+			t.Errorf("%s: expected internal error, got %v", test.name, err)
+		}
+		if !test.vetoed && !test.failed && err != nil {
+			t.Errorf("%s: expected payment to be accepted, got %v", test.name, err)
+		}
+	}
+}
+
+func TestExternalHookURL(t *testing.T) {
+	ctx := context.Background()
+	p := HookPayment{ChannelID: "chan", Payment: models.Payment{Amount: 1000}}
+
+	status := http.StatusOK
+	var got HookCall
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get(WebhookSignatureHeader) == "" {
+			t.Error("Expected signed request")
+		}
+		json.NewDecoder(req.Body).Decode(&got)
+		w.WriteHeader(status)
+		w.Write([]byte("not entitled\n"))
+	}))
+	defer srv.Close()
+
+	r, _ := newOpenReceiver(t, &closeBackend{})
+	if err := r.AddExternalHook(ExternalHook{URL: srv.URL}); err == nil {
+		t.Error("Expected error for URL without secret")
+	}
+	if err := r.AddExternalHook(ExternalHook{URL: srv.URL, Secret: "s"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.beforePayment(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if got.Stage != HookBefore || got.Payment.ChannelID != "chan" {
+		t.Errorf("Unexpected call %+v", got)
+	}
+
+	status = http.StatusForbidden
+	err := r.beforePayment(ctx, p)
+	if e, ok := err.(ExposableError); !ok || e.Error() != "payment rejected: not entitled" {
+		t.Errorf("Expected veto, got %v", err)
+	}
+
+	status = http.StatusInternalServerError
+	err = r.beforePayment(ctx, p)
+	if _, ok := err.(ExposableError); ok || err == nil {
+		t.Errorf("Expected internal error, got %v", err)
+	}
+}
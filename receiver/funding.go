@@ -0,0 +1,210 @@
+package receiver
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/luno/moonbeam/chain"
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+const (
+	// maxPending limits the number of created channels whose funding
+	// addresses are monitored, since anyone can create channels.
+	maxPending = 1000
+
+	// pendingTTL is how long a created channel's funding address is
+	// monitored.
+	pendingTTL = 48 * time.Hour
+)
+
+// SetFundingMonitor enables monitoring the funding addresses of created
+// channels. Once a funding address receives an output with enough
+// confirmations, a funded event is sent and the output is recorded so that
+// Open can use it without looking it up. The chain backend must implement
+// chain.AddressScanner.
+func (r *Receiver) SetFundingMonitor(enabled bool) {
+	r.fundingMonitor = enabled
+}
+
+// addPending starts monitoring a created channel's funding address.
+func (r *Receiver) addPending(ctx context.Context, addr string) error {
+	pending, err := r.db.ListPending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) >= maxPending {
+		r.log.Warn("too many pending channels, not monitoring funding",
+			"address", addr)
+		return nil
+	}
+	return r.db.PutPending(ctx, storage.Pending{
+		FundingAddress: addr,
+		Created:        time.Now(),
+	})
+}
+
+// checkPending looks for confirmed outputs paying to the funding addresses
+// of created channels.
+func (r *Receiver) checkPending(ctx context.Context, blockCount int64) error {
+	if !r.fundingMonitor {
+		return nil
+	}
+	scanner, ok := r.chain.(chain.AddressScanner)
+	if !ok {
+		return nil
+	}
+
+	pending, err := r.db.ListPending(ctx)
+	if err != nil {
+		return err
+	}
+
+	var addrs []string
+	byScript := make(map[string]storage.Pending)
+	for _, p := range pending {
+		if time.Since(p.Created) > pendingTTL {
+			if err := r.db.DeletePending(ctx, p.FundingAddress); err != nil {
+				return err
+			}
+			continue
+		}
+		if p.Funded() {
+			continue
+		}
+		addr, err := btcutil.DecodeAddress(p.FundingAddress, r.Net)
+		if err != nil {
+			continue
+		}
+		script, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, p.FundingAddress)
+		byScript[hex.EncodeToString(script)] = p
+	}
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	done := r.startBitcoind(ctx, "scantxoutset")
+	utxos, err := scanner.ListUnspent(ctx, addrs)
+	done(err)
+	if err != nil {
+		return err
+	}
+
+	minConf := r.getPolicy().FundingMinConf
+	for _, u := range utxos {
+		script := hex.EncodeToString(u.PkScript)
+		p, ok := byScript[script]
+		if !ok || u.Coinbase || u.Confirmations < minConf {
+			continue
+		}
+		// Only the first output found is used.
+		delete(byScript, script)
+
+		p.TxID = u.TxID
+		p.Vout = u.Vout
+		p.Value = u.Value
+		p.PkScript = u.PkScript
+		p.Confirmations = u.Confirmations
+		p.Height = blockCount
+		if err := r.db.PutPending(ctx, p); err != nil {
+			return err
+		}
+
+		r.log.Info("channel funded", "address", p.FundingAddress,
+			"txid", u.TxID, "vout", u.Vout, "value", u.Value)
+		r.publish(Event{
+			Type:      EventFunded,
+			ChannelID: getChannelID(u.TxID, u.Vout),
+			Time:      time.Now(),
+		})
+	}
+	return nil
+}
+
+// pendingFunding returns the monitored channel funded by the output, if
+// any.
+func (r *Receiver) pendingFunding(ctx context.Context, txid string, vout uint32) (*storage.Pending, error) {
+	if !r.fundingMonitor {
+		return nil, nil
+	}
+	pending, err := r.db.ListPending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pending {
+		if p.Funded() && strings.EqualFold(p.TxID, txid) && p.Vout == vout {
+			return &p, nil
+		}
+	}
+	return nil, nil
+}
+
+// fundingLookback is how many blocks before the tip a script watching
+// chain backend looks for the funding of a channel being opened, since the
+// funding address may not have been watched since it was created, for
+// example after a restart.
+const fundingLookback = 1008
+
+// watchFunding asks a chain.ScriptWatcher backend to watch a funding
+// address from height on. It does nothing for other backends.
+func (r *Receiver) watchFunding(addr string, height int64) error {
+	w, ok := r.chain.(chain.ScriptWatcher)
+	if !ok {
+		return nil
+	}
+	a, err := btcutil.DecodeAddress(addr, r.Net)
+	if err != nil {
+		return err
+	}
+	script, err := txscript.PayToAddrScript(a)
+	if err != nil {
+		return err
+	}
+	w.WatchScript(script, height)
+	return nil
+}
+
+// watchOpening watches the funding address of a channel being opened from
+// fundingLookback blocks before the tip.
+func (r *Receiver) watchOpening(ctx context.Context, s channels.SharedState) error {
+	if _, ok := r.chain.(chain.ScriptWatcher); !ok {
+		return nil
+	}
+	_, addr, err := s.GetFundingScript()
+	if err != nil {
+		return err
+	}
+	tip, err := r.getHeight(ctx, "")
+	if err != nil {
+		return err
+	}
+	height := tip - fundingLookback
+	if height < 1 {
+		height = 1
+	}
+	return r.watchFunding(addr, height)
+}
+
+// watchChannel watches the funding address of an open channel from the
+// block it was opened at, so that a script watching chain backend sees its
+// funding and closure after a restart.
+func (r *Receiver) watchChannel(s channels.SharedState) error {
+	if _, ok := r.chain.(chain.ScriptWatcher); !ok {
+		return nil
+	}
+	_, addr, err := s.GetFundingScript()
+	if err != nil {
+		return err
+	}
+	return r.watchFunding(addr, int64(s.BlockHeight))
+}
@@ -0,0 +1,195 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/chain"
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+type scanBackend struct {
+	chain.Backend
+	utxos []chain.UTXO
+}
+
+func (s *scanBackend) ListUnspent(ctx context.Context, addrs []string) ([]chain.UTXO, error) {
+	return s.utxos, nil
+}
+
+func TestCheckPending(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+
+	addr, err := btcutil.NewAddressScriptHash([]byte{1, 2, 3}, net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+	minConf := getPolicy(net).FundingMinConf
+	cb := &scanBackend{utxos: []chain.UTXO{{
+		TxID:  txid,
+		Vout:  1,
+		TxOut: chain.TxOut{Value: 1000, PkScript: script, Confirmations: minConf - 1},
+	}}}
+
+	db := memory.New()
+	r := NewReceiver(net, nil, cb, db, nil, "", "")
+	r.SetFundingMonitor(true)
+
+	if err := r.addPending(ctx, addr.EncodeAddress()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.db.PutPending(ctx, storage.Pending{
+		FundingAddress: "expired",
+		Created:        time.Now().Add(-pendingTTL - time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not enough confirmations yet.
+	if err := r.checkPending(ctx, 100); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := r.pendingFunding(ctx, txid, 1); err != nil || p != nil {
+		t.Fatalf("Unexpected funding %+v %v", p, err)
+	}
+	pending, err := r.db.ListPending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].FundingAddress != addr.EncodeAddress() {
+		t.Errorf("Expected only unexpired pending channel, got %+v", pending)
+	}
+
+	events, cancel := r.Subscribe()
+	defer cancel()
+
+	cb.utxos[0].Confirmations = minConf
+	if err := r.checkPending(ctx, 101); err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.pendingFunding(ctx, txid, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == nil || p.Value != 1000 || p.Height != 101 || p.Confirmations != minConf {
+		t.Errorf("Unexpected funding %+v", p)
+	}
+
+	select {
+	case e := <-events:
+		if e.Type != EventFunded || e.ChannelID != getChannelID(txid, 1) {
+			t.Errorf("Unexpected event %+v", e)
+		}
+	default:
+		t.Errorf("Expected funded event")
+	}
+}
+
+// watchBackend is a chain.ScriptWatcher whose only output is the funding
+// of the channel under test.
+type watchBackend struct {
+	chain.Backend
+	funding *chain.TxOut
+	watched []watchedScript
+}
+
+type watchedScript struct {
+	script []byte
+	height int64
+}
+
+func (b *watchBackend) GetTxOut(ctx context.Context, txid string, vout uint32, includeMempool bool) (*chain.TxOut, error) {
+	return b.funding, nil
+}
+
+func (b *watchBackend) GetHeight(ctx context.Context, hash string) (int64, error) {
+	return 100, nil
+}
+
+func (b *watchBackend) WatchScript(pkScript []byte, height int64) {
+	b.watched = append(b.watched, watchedScript{pkScript, height})
+}
+
+func TestWatchFunding(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wb := &watchBackend{}
+	db := memory.New()
+	r := NewReceiver(net, ek, wb, db, NewDirectory("example.com"), keytest.Address(1, net), "")
+
+	s, err := channels.NewSender(channels.DefaultSenderConfig, keytest.Key(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createReq, err := s.GetCreateRequest(keytest.Address(4, net))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createResp, err := r.Create(ctx, *createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GotCreateResponse(createResp); err != nil {
+		t.Fatal(err)
+	}
+	addr, err := btcutil.DecodeAddress(createResp.FundingAddress, net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wb.funding = &chain.TxOut{Value: 1000000, PkScript: script, Confirmations: 10}
+
+	openReq, err := s.GetOpenRequest(txid, 0, 1000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openReq.ReceiverData = createResp.ReceiverData
+	if _, err := r.Open(ctx, *openReq); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create watches new blocks and Open looks back from the tip.
+	if len(wb.watched) != 2 ||
+		!bytes.Equal(wb.watched[0].script, script) || wb.watched[0].height != 0 ||
+		!bytes.Equal(wb.watched[1].script, script) || wb.watched[1].height != 1 {
+		t.Errorf("Expected funding to be watched from heights 0 and 1, got %+v", wb.watched)
+	}
+
+	rec, err := r.db.Get(ctx, getChannelID(txid, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.checkChannel(ctx, 100, *rec); err != nil {
+		t.Fatal(err)
+	}
+	last := wb.watched[len(wb.watched)-1]
+	if !bytes.Equal(last.script, script) || last.height != 100 {
+		t.Errorf("Expected open channel to be watched from height 100, got %d", last.height)
+	}
+}
@@ -0,0 +1,115 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+// DefaultMaxBlockAge is how long the receiver is considered ready without
+// seeing a new block. Blocks are occasionally over an hour apart, so a
+// shorter limit would cause spurious failures.
+const DefaultMaxBlockAge = 2 * time.Hour
+
+// healthCheckID is looked up to check that storage is reachable.
+const healthCheckID = "healthcheck"
+
+// tip records when the chain tip last changed.
+type tip struct {
+	mu     sync.Mutex
+	height int64
+	seen   time.Time
+}
+
+func (t *tip) observe(height int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if height != t.height {
+		t.height = height
+		t.seen = time.Now()
+	}
+}
+
+func (t *tip) get() (int64, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.height, t.seen
+}
+
+// SetMaxBlockAge sets how long the receiver is considered ready without
+// seeing a new block.
+func (r *Receiver) SetMaxBlockAge(d time.Duration) {
+	r.maxBlockAge = d
+}
+
+// HealthCheck is the result of checking one of the receiver's
+// dependencies. Err is nil if the dependency is healthy.
+type HealthCheck struct {
+	Name string
+	Err  error
+}
+
+// CheckHealth checks that the signing keys and storage are available. If
+// ready is set, it also checks that the chain backend is reachable and that
+// Watch has seen a new block recently, which is required to accept
+// payments safely.
+func (r *Receiver) CheckHealth(ctx context.Context, ready bool) []HealthCheck {
+	checks := []HealthCheck{
+		{Name: "keys", Err: r.checkKeys(ctx)},
+		{Name: "storage", Err: r.checkStorage(ctx)},
+	}
+	if !ready {
+		return checks
+	}
+	_, err := r.getBlockCount(ctx)
+	checks = append(checks,
+		HealthCheck{Name: "chain", Err: err},
+		HealthCheck{Name: "blocks", Err: r.checkBlocks()},
+		HealthCheck{Name: "shutdown", Err: r.checkShutdown()},
+	)
+	if r.remote != nil {
+		checks = append(checks, HealthCheck{Name: "signer", Err: r.checkSigner(ctx)})
+	}
+	return checks
+}
+
+func (r *Receiver) checkKeys(ctx context.Context) error {
+	if r.ek == nil && r.remote == nil {
+		return errors.New("no extended key")
+	}
+	_, err := r.getKey(ctx, 0)
+	return err
+}
+
+func (r *Receiver) checkStorage(ctx context.Context) error {
+	_, err := r.db.Get(ctx, healthCheckID)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (r *Receiver) checkShutdown() error {
+	select {
+	case <-r.life.stopped():
+		return ErrShuttingDown
+	default:
+		return nil
+	}
+}
+
+func (r *Receiver) checkBlocks() error {
+	height, seen := r.tip.get()
+	if seen.IsZero() {
+		return errors.New("no block seen yet")
+	}
+	if age := time.Since(seen); age > r.maxBlockAge {
+		return fmt.Errorf("no new block since height %d, %s ago",
+			height, age.Truncate(time.Second))
+	}
+	return nil
+}
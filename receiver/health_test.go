@@ -0,0 +1,33 @@
+package receiver
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCheckHealth(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newOpenReceiver(t, &closeBackend{})
+
+	errs := make(map[string]error)
+	for _, c := range r.CheckHealth(ctx, false) {
+		errs[c.Name] = c.Err
+	}
+	if len(errs) != 2 || errs["storage"] != nil || errs["keys"] == nil {
+		t.Errorf("Unexpected health checks %v", errs)
+	}
+
+	if err := r.checkBlocks(); err == nil {
+		t.Errorf("Expected failure before any block is seen")
+	}
+	r.tip.observe(100)
+	if err := r.checkBlocks(); err != nil {
+		t.Errorf("Expected fresh tip, got %v", err)
+	}
+	r.SetMaxBlockAge(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if err := r.checkBlocks(); err == nil {
+		t.Errorf("Expected stale tip to fail")
+	}
+}
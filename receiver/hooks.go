@@ -0,0 +1,73 @@
+package receiver
+
+import (
+	"context"
+
+	"github.com/luno/moonbeam/models"
+)
+
+// PaymentHook lets integrators act on payments from Go, e.g. to check
+// inventory before a payment is accepted and to issue entitlements once it
+// has been.
+type PaymentHook interface {
+	// BeforePayment is called by Send once the payment and the sender's
+	// signatures have been validated, before the new state is stored. It's
+	// called with the channel locked, so it should be quick.
+	//
+	// Returning an error vetoes the payment. Rejections should be
+	// ExposableErrors, e.g. from NewRejection, which are reported to the
+	// sender. Other errors are treated as internal errors.
+	BeforePayment(ctx context.Context, p HookPayment) error
+
+	// AfterPayment is called once the payment has been stored. The payment
+	// can't be undone, so errors are only logged.
+	AfterPayment(ctx context.Context, p HookPayment) error
+}
+
+// HookPayment is a payment passed to a PaymentHook.
+type HookPayment struct {
+	ChannelID    string         `json:"channelID"`
+	Account      string         `json:"account,omitempty"`
+	SenderPubKey []byte         `json:"senderPubKey"`
+	Payment      models.Payment `json:"payment"`
+
+	// InvoiceID and PaymentID are set if the sender gave them.
+	InvoiceID string `json:"invoiceID,omitempty"`
+	PaymentID string `json:"paymentID,omitempty"`
+
+	// Count and Balance are the channel's payment count and balance after
+	// the payment.
+	Count   int   `json:"count"`
+	Balance int64 `json:"balance"`
+
+	// RequestID is the ID of the send request, if it was given one.
+	RequestID string `json:"requestID,omitempty"`
+}
+
+// AddPaymentHook registers a hook called by Send for every payment. Hooks
+// are called in the order they were added. It must be called before the
+// receiver starts serving requests.
+func (r *Receiver) AddPaymentHook(h PaymentHook) {
+	r.paymentHooks = append(r.paymentHooks, h)
+}
+
+// beforePayment runs the hooks' checks, stopping at the first veto.
+func (r *Receiver) beforePayment(ctx context.Context, p HookPayment) error {
+	for _, h := range r.paymentHooks {
+		if err := h.BeforePayment(ctx, p); err != nil {
+			r.metrics.validationFailures.Inc("hook")
+			return err
+		}
+	}
+	return nil
+}
+
+// afterPayment tells every hook about a stored payment.
+func (r *Receiver) afterPayment(ctx context.Context, p HookPayment) {
+	for _, h := range r.paymentHooks {
+		if err := h.AfterPayment(ctx, p); err != nil {
+			r.log.ErrorContext(ctx, "payment hook failed", "channel", p.ChannelID,
+				"count", p.Count, "err", err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/address"
+	"github.com/luno/moonbeam/chain"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+type testHook struct {
+	veto   error
+	before []HookPayment
+	after  []HookPayment
+}
+
+func (h *testHook) BeforePayment(ctx context.Context, p HookPayment) error {
+	h.before = append(h.before, p)
+	return h.veto
+}
+
+func (h *testHook) AfterPayment(ctx context.Context, p HookPayment) error {
+	h.after = append(h.after, p)
+	return nil
+}
+
+func TestPaymentHooks(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := &closeBackend{status: &chain.TxStatus{Confirmations: 10, BlockHeight: 100}}
+	db := memory.New()
+	r := NewReceiver(net, ek, heightBackend{cb}, db, NewDirectory("example.com"), keytest.Address(1, net), "")
+	var h testHook
+	r.AddPaymentHook(&h)
+	s := openTestChannel(t, r, cb, txid, 1000)
+
+	if len(h.before) != 1 || len(h.after) != 1 {
+		t.Fatalf("Expected hooks to be called once, got %d and %d", len(h.before), len(h.after))
+	}
+	p := h.after[0]
+	if p.ChannelID != getChannelID(txid, 0) || p.Payment.Amount != 1000 ||
+		p.Count != 1 || p.Balance != 1000 {
+		t.Errorf("Unexpected hook payment %+v", p)
+	}
+
+	// A veto rejects the payment without storing it.
+	h.veto = NewRejection(models.ReasonInvalidPayment, "out of stock")
+	target, err := address.Encode(keytest.Address(2, net), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := keytest.Payment(t, 500, target, 2)
+	req, err := s.GetSendRequest(500, payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Send(ctx, *req); err != h.veto {
+		t.Errorf("Expected veto, got %v", err)
+	}
+	if len(h.before) != 2 || len(h.after) != 1 {
+		t.Errorf("Expected only the check to run, got %d and %d", len(h.before), len(h.after))
+	}
+	rec, err := r.db.Get(ctx, getChannelID(txid, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.SharedState.Count != 1 || rec.SharedState.Balance != 1000 {
+		t.Errorf("Expected vetoed payment not to be stored, got %+v", rec.SharedState)
+	}
+}
@@ -0,0 +1,100 @@
+package receiver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage"
+)
+
+var (
+	ErrUnknownInvoice  = NewExposableError("unknown invoice")
+	ErrInvoiceExpired  = NewExposableError("invoice expired")
+	ErrInvoicePaid     = NewExposableError("invoice already paid")
+	ErrInvoiceMismatch = NewExposableError("payment doesn't match invoice")
+)
+
+// CreateInvoice issues an invoice for a payment of amount to target, which
+// can be paid until ttl has passed. Senders pay it by setting the invoice ID
+// in their SendRequest. If the context is scoped to an account, the invoice
+// belongs to the account and the target must be in its domain.
+func (r *Receiver) CreateInvoice(ctx context.Context, amount int64, target string, ttl time.Duration) (*storage.Invoice, error) {
+	if amount <= 0 {
+		return nil, NewExposableError("invalid amount")
+	}
+	if ttl <= 0 {
+		return nil, NewExposableError("invalid expiry")
+	}
+	a, err := r.account(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var accountID string
+	if a != nil {
+		accountID = a.ID
+	}
+	has, err := r.directory(accountID).HasTarget(target)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, NewExposableError("unknown target")
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	inv := storage.Invoice{
+		ID:      hex.EncodeToString(buf),
+		Amount:  amount,
+		Target:  target,
+		Expiry:  now.Add(ttl),
+		Created: now,
+		Account: accountID,
+	}
+	if err := r.db.AddInvoice(ctx, inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// GetInvoice returns the invoice with the ID, including whether and by which
+// channel it was paid.
+func (r *Receiver) GetInvoice(ctx context.Context, id string) (*storage.Invoice, error) {
+	inv, err := r.db.GetInvoice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if account, ok := accountFromContext(ctx); ok && inv.Account != account {
+		return nil, storage.ErrNotFound
+	}
+	return inv, nil
+}
+
+// checkInvoice checks that the payment on the channel can pay the invoice.
+func (r *Receiver) checkInvoice(ctx context.Context, rec *storage.Record, id string, p models.Payment) error {
+	inv, err := r.db.GetInvoice(ctx, id)
+	if err == storage.ErrNotFound {
+		return ErrUnknownInvoice
+	} else if err != nil {
+		return err
+	}
+	if inv.Account != rec.Account {
+		return ErrUnknownInvoice
+	}
+	if inv.Paid() {
+		return ErrInvoicePaid
+	}
+	if !time.Now().Before(inv.Expiry) {
+		return ErrInvoiceExpired
+	}
+	if p.Amount != inv.Amount || p.Target != inv.Target {
+		return ErrInvoiceMismatch
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package receiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/address"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage"
+)
+
+func TestInvoices(t *testing.T) {
+	ctx := context.Background()
+	r, rec := newOpenReceiver(t, &closeBackend{})
+	r.settings.s.Directory = NewDirectory("example.com")
+	err := r.AddAccount(Account{ID: "shop", Destination: "dest", Domain: "shop.example", KeyIndex: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := address.Encode(keytest.Address(2, r.Net), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.CreateInvoice(ctx, 100, "alice@other.com", time.Minute); err == nil {
+		t.Errorf("Expected error for unknown target")
+	}
+	if _, err := r.CreateInvoice(WithAccount(ctx, "shop"), 100, target, time.Minute); err == nil {
+		t.Errorf("Expected error for target outside the account's domain")
+	}
+
+	inv, err := r.CreateInvoice(ctx, 100, target, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetInvoice(WithAccount(ctx, "shop"), inv.ID); err != storage.ErrNotFound {
+		t.Errorf("Expected other account not to see invoice, got %v", err)
+	}
+
+	p := models.Payment{Amount: 100, Target: target}
+	if err := r.checkInvoice(ctx, &rec, "nope", p); err != ErrUnknownInvoice {
+		t.Errorf("Expected ErrUnknownInvoice, got %v", err)
+	}
+	if err := r.checkInvoice(ctx, &rec, inv.ID, models.Payment{Amount: 99, Target: p.Target}); err != ErrInvoiceMismatch {
+		t.Errorf("Expected ErrInvoiceMismatch, got %v", err)
+	}
+	if err := r.checkInvoice(ctx, &rec, inv.ID, p); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	next := rec.SharedState
+	next.Count++
+	next.Balance += 100
+	tx := storage.Tx{ChannelID: rec.ID, Version: 1, State: next, Payment: []byte("p"), InvoiceID: inv.ID}
+	if err := r.db.Commit(ctx, tx); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.GetInvoice(ctx, inv.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Paid() || got.PaidChannel != rec.ID || got.PaidCount != next.Count {
+		t.Errorf("Expected invoice paid by channel, got %+v", got)
+	}
+	if err := r.checkInvoice(ctx, &rec, inv.ID, p); err != ErrInvoicePaid {
+		t.Errorf("Expected ErrInvoicePaid, got %v", err)
+	}
+
+	// A concurrent payment that passed the check must not pay it twice.
+	after := next
+	after.Count++
+	after.Balance += 100
+	tx = storage.Tx{ChannelID: rec.ID, Version: 2, State: after, Payment: []byte("p"), InvoiceID: inv.ID}
+	if err := r.db.Commit(ctx, tx); err != storage.ErrInvoicePaid {
+		t.Errorf("Expected ErrInvoicePaid, got %v", err)
+	}
+
+	expired, err := r.CreateInvoice(ctx, 100, target, time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := r.checkInvoice(ctx, &rec, expired.ID, p); err != ErrInvoiceExpired {
+		t.Errorf("Expected ErrInvoiceExpired, got %v", err)
+	}
+}
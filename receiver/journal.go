@@ -0,0 +1,190 @@
+package receiver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
+)
+
+type JournalEntryType string
+
+const (
+	// JournalKey records a new generation of the receiver's extended key.
+	JournalKey JournalEntryType = "key"
+
+	// JournalOpen records the request that opened a channel.
+	JournalOpen JournalEntryType = "open"
+
+	// JournalPayment records a payment accepted on a channel.
+	JournalPayment JournalEntryType = "payment"
+
+	// JournalStatus records a change of a channel's status.
+	JournalStatus JournalEntryType = "status"
+)
+
+// JournalEntry is a record of the recovery journal. Together with the
+// receiver's extended keys and the blockchain, the entries of a channel are
+// enough to rebuild its state. See Recover.
+type JournalEntry struct {
+	Type      JournalEntryType `json:"type"`
+	Time      time.Time        `json:"time"`
+	ChannelID string           `json:"channelID,omitempty"`
+
+	// Open, Capacity and PkScript are set for open entries. The funding
+	// output is used if it's already spent by the time of recovery.
+	Open     *models.OpenRequest `json:"open,omitempty"`
+	Capacity int64               `json:"capacity,omitempty"`
+	PkScript []byte              `json:"pkScript,omitempty"`
+
+	// Amount, Send and Count are set for payment entries. Count is the
+	// channel's payment count after the payment.
+	Amount int64               `json:"amount,omitempty"`
+	Send   *models.SendRequest `json:"send,omitempty"`
+	Count  int                 `json:"count,omitempty"`
+
+	// Status is set for open and status entries.
+	Status channels.Status `json:"status,omitempty"`
+
+	// Generation and XPub are set for key entries.
+	Generation int    `json:"generation,omitempty"`
+	XPub       string `json:"xpub,omitempty"`
+}
+
+// Journal durably records what's needed to rebuild the receiver's channels
+// if its database is lost. It should be kept on different storage from the
+// database.
+type Journal interface {
+	// Append must only return nil once the entry is durably stored.
+	Append(ctx context.Context, e JournalEntry) error
+}
+
+// SetJournal makes the receiver record every opened channel, accepted
+// payment, status change and key generation in j. It must be called before
+// the receiver starts serving requests.
+func (r *Receiver) SetJournal(j Journal) {
+	r.journal = j
+}
+
+// appendJournal records e, alerting the operator if that fails. The change
+// it records has already been stored, so it can't be undone.
+func (r *Receiver) appendJournal(ctx context.Context, e JournalEntry) {
+	if r.journal == nil {
+		return
+	}
+	e.Time = time.Now()
+	if err := r.journal.Append(ctx, e); err != nil {
+		r.alerter.Alert(e.ChannelID, "failed to journal "+string(e.Type)+": "+err.Error())
+	}
+}
+
+func (r *Receiver) journalOpen(ctx context.Context, id string, req models.OpenRequest, txout *wire.TxOut, status channels.Status) {
+	r.appendJournal(ctx, JournalEntry{
+		Type:      JournalOpen,
+		ChannelID: id,
+		Open:      &req,
+		Capacity:  txout.Value,
+		PkScript:  txout.PkScript,
+		Status:    status,
+	})
+}
+
+func (r *Receiver) journalPayment(ctx context.Context, id string, amount int64, req models.SendRequest, count int) {
+	r.appendJournal(ctx, JournalEntry{
+		Type:      JournalPayment,
+		ChannelID: id,
+		Amount:    amount,
+		Send:      &req,
+		Count:     count,
+	})
+}
+
+func (r *Receiver) journalKey(ctx context.Context, gen int, xpub string) {
+	r.appendJournal(ctx, JournalEntry{
+		Type:       JournalKey,
+		Generation: gen,
+		XPub:       xpub,
+	})
+}
+
+// journalEvent records status changes.
+func (r *Receiver) journalEvent(ctx context.Context, e lifecycleEvent) {
+	var id string
+	var s channels.SharedState
+	switch e := e.(type) {
+	case channelOpened:
+		id, s = e.id, e.state
+	case channelClosing:
+		id, s = e.id, e.state
+	case channelClosed:
+		id, s = e.id, e.state
+	default:
+		return
+	}
+	r.appendJournal(ctx, JournalEntry{
+		Type:      JournalStatus,
+		ChannelID: id,
+		Status:    s.Status,
+	})
+}
+
+// FileJournal is a Journal that appends entries to a file, one JSON object
+// per line.
+type FileJournal struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenFileJournal opens the journal at path, creating it if it doesn't
+// exist.
+func OpenFileJournal(path string) (*FileJournal, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileJournal{f: f}, nil
+}
+
+func (j *FileJournal) Append(ctx context.Context, e JournalEntry) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.Write(buf); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+func (j *FileJournal) Close() error {
+	return j.f.Close()
+}
+
+// ReadJournal reads the entries written by a FileJournal. A final entry
+// that was only partly written, e.g. because of a crash, is ignored.
+func ReadJournal(rd io.Reader) ([]JournalEntry, error) {
+	var res []JournalEntry
+	dec := json.NewDecoder(bufio.NewReader(rd))
+	for {
+		var e JournalEntry
+		err := dec.Decode(&e)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return res, nil
+		} else if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+}
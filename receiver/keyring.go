@@ -0,0 +1,215 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// ErrUnknownKeyGeneration is returned for channels of a key generation
+// whose extended key hasn't been added with AddExtendedKey.
+var ErrUnknownKeyGeneration = errors.New("unknown key generation")
+
+// KeyGeneration is a generation of the receiver's extended key.
+type KeyGeneration struct {
+	Generation int
+	XPub       string
+	Created    time.Time
+
+	// Current is set for the generation that new channels use.
+	Current bool
+}
+
+// keyring holds the generations of the receiver's extended key. Until the
+// generations are loaded, the key passed to NewReceiver is generation zero.
+type keyring struct {
+	mu      sync.RWMutex
+	loaded  bool
+	gens    map[int]*hdkeychain.ExtendedKey
+	current int
+
+	// standby are keys added with AddExtendedKey, which become generations
+	// once loaded or rotated to.
+	standby []*hdkeychain.ExtendedKey
+}
+
+func (k *keyring) get(initial *hdkeychain.ExtendedKey, gen int) (*hdkeychain.ExtendedKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if !k.loaded && gen == 0 && initial != nil {
+		return initial, nil
+	}
+	ek, ok := k.gens[gen]
+	if !ok {
+		return nil, ErrUnknownKeyGeneration
+	}
+	return ek, nil
+}
+
+func (r *Receiver) currentKeyGen() int {
+	r.keyring.mu.RLock()
+	defer r.keyring.mu.RUnlock()
+	return r.keyring.current
+}
+
+func xpubOf(ek *hdkeychain.ExtendedKey) (string, error) {
+	pub, err := ek.Neuter()
+	if err != nil {
+		return "", err
+	}
+	return pub.String(), nil
+}
+
+// AddExtendedKey makes an extended private key available besides the one
+// passed to NewReceiver, either for the channels of an earlier generation or
+// as the standby key to rotate to. It must be called before
+// LoadKeyGenerations.
+func (r *Receiver) AddExtendedKey(ek *hdkeychain.ExtendedKey) error {
+	if !ek.IsPrivate() {
+		return errors.New("not an extended private key")
+	}
+	if !ek.IsForNet(r.Net) {
+		return errors.New("extended key is for wrong network")
+	}
+	r.keyring.mu.Lock()
+	defer r.keyring.mu.Unlock()
+	r.keyring.standby = append(r.keyring.standby, ek)
+	return nil
+}
+
+// LoadKeyGenerations matches the stored key generations with the extended
+// keys passed to NewReceiver and AddExtendedKey. The key passed to
+// NewReceiver becomes generation zero if none are stored yet. It fails if
+// the key of any generation is missing, since its channels couldn't be
+// signed for, and the latest generation is used for new channels.
+//
+// To rotate the key, restart the receiver with the new key added with
+// AddExtendedKey and call RotateKey, e.g. through the admin API.
+func (r *Receiver) LoadKeyGenerations(ctx context.Context) error {
+	if r.remote != nil {
+		return nil
+	}
+	gens, err := r.db.ListKeyGenerations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(gens) == 0 && r.ek != nil {
+		xpub, err := xpubOf(r.ek)
+		if err != nil {
+			return err
+		}
+		g, err := r.db.AddKeyGeneration(ctx, xpub)
+		if err != nil {
+			return err
+		}
+		gens = append(gens, g)
+	}
+	// Journal the generations every time, so that the journal has them
+	// even if it was set up after they were added.
+	for _, g := range gens {
+		r.journalKey(ctx, g.Generation, g.XPub)
+	}
+
+	r.keyring.mu.Lock()
+	defer r.keyring.mu.Unlock()
+
+	keys := r.keyring.standby
+	if r.ek != nil {
+		keys = append([]*hdkeychain.ExtendedKey{r.ek}, keys...)
+	}
+	byXPub := make(map[string]*hdkeychain.ExtendedKey)
+	for _, ek := range keys {
+		xpub, err := xpubOf(ek)
+		if err != nil {
+			return err
+		}
+		byXPub[xpub] = ek
+	}
+
+	loaded := make(map[int]*hdkeychain.ExtendedKey)
+	for _, g := range gens {
+		ek, ok := byXPub[g.XPub]
+		if !ok {
+			return fmt.Errorf("missing extended key of key generation %d: %s", g.Generation, g.XPub)
+		}
+		loaded[g.Generation] = ek
+		delete(byXPub, g.XPub)
+	}
+
+	var standby []*hdkeychain.ExtendedKey
+	for _, ek := range byXPub {
+		standby = append(standby, ek)
+	}
+	r.keyring.loaded = true
+	r.keyring.gens = loaded
+	r.keyring.current = len(gens) - 1
+	r.keyring.standby = standby
+	return nil
+}
+
+// RotateKey makes the standby key with the extended public key xpub the
+// current generation, so that new channels use it. Existing channels keep
+// using the keys of their generations. If xpub is empty, the only standby
+// key is used.
+func (r *Receiver) RotateKey(ctx context.Context, xpub string) (*KeyGeneration, error) {
+	if r.remote != nil {
+		return nil, NewExposableError("key rotation isn't supported with a remote signer")
+	}
+
+	r.keyring.mu.Lock()
+	defer r.keyring.mu.Unlock()
+	if !r.keyring.loaded {
+		return nil, errors.New("key generations not loaded")
+	}
+
+	i := -1
+	for j, ek := range r.keyring.standby {
+		x, err := xpubOf(ek)
+		if err != nil {
+			return nil, err
+		}
+		if x == xpub || (xpub == "" && len(r.keyring.standby) == 1) {
+			i, xpub = j, x
+		}
+	}
+	if i < 0 {
+		return nil, NewExposableError("no matching standby key")
+	}
+
+	g, err := r.db.AddKeyGeneration(ctx, xpub)
+	if err != nil {
+		return nil, err
+	}
+	r.journalKey(ctx, g.Generation, g.XPub)
+	ek := r.keyring.standby[i]
+	r.keyring.standby = append(r.keyring.standby[:i], r.keyring.standby[i+1:]...)
+	r.keyring.gens[g.Generation] = ek
+	r.keyring.current = g.Generation
+
+	r.log.Info("rotated key", "generation", g.Generation, "xpub", g.XPub)
+
+	return &KeyGeneration{Generation: g.Generation, XPub: g.XPub, Created: g.Created, Current: true}, nil
+}
+
+// KeyGenerations returns the stored generations of the receiver's key.
+func (r *Receiver) KeyGenerations(ctx context.Context) ([]KeyGeneration, error) {
+	gens, err := r.db.ListKeyGenerations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current := r.currentKeyGen()
+	res := []KeyGeneration{}
+	for _, g := range gens {
+		res = append(res, KeyGeneration{
+			Generation: g.Generation,
+			XPub:       g.XPub,
+			Created:    g.Created,
+			Current:    g.Generation == current,
+		})
+	}
+	return res, nil
+}
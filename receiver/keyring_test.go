@@ -0,0 +1,105 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+func TestRotateKey(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+
+	ek1, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek2, err := hdkeychain.NewMaster(bytes.Repeat([]byte{2}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := memory.New()
+	newReceiver := func(ek *hdkeychain.ExtendedKey, extra ...*hdkeychain.ExtendedKey) (*Receiver, error) {
+		r := NewReceiver(net, ek, &closeBackend{}, db, nil, keytest.Address(1, net), "")
+		for _, ek := range extra {
+			if err := r.AddExtendedKey(ek); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return r, r.LoadKeyGenerations(ctx)
+	}
+
+	r, err := newReceiver(ek1, ek2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := channels.NewSender(channels.DefaultSenderConfig, keytest.Key(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := s.GetCreateRequest(keytest.Address(4, net))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := r.Create(ctx, *req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := r.RotateKey(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Generation != 1 || !g.Current {
+		t.Errorf("Unexpected generation %+v", g)
+	}
+	if _, err := r.RotateKey(ctx, ""); err == nil {
+		t.Error("Expected no standby key left")
+	}
+
+	after, err := r.Create(ctx, *req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before.ReceiverData) != "0" || string(after.ReceiverData) != "1.0" {
+		t.Errorf("Unexpected receiverData %q %q", before.ReceiverData, after.ReceiverData)
+	}
+	if bytes.Equal(before.ReceiverPubKey, after.ReceiverPubKey) {
+		t.Error("Expected new channels to use the new key")
+	}
+	old, err := r.getAccountKey(ctx, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(old.PubKey().SerializeCompressed(), before.ReceiverPubKey) {
+		t.Error("Expected old channels to keep the old key")
+	}
+
+	// Restarting without the old key would strand its channels.
+	if _, err := newReceiver(ek2); err == nil {
+		t.Error("Expected missing key generation to fail")
+	}
+
+	r, err = newReceiver(ek2, ek1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gens, err := r.KeyGenerations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gens) != 2 || gens[0].Current || !gens[1].Current {
+		t.Errorf("Unexpected generations %+v", gens)
+	}
+	if _, err := r.getAccountKey(ctx, "", 2, 0); err != ErrUnknownKeyGeneration {
+		t.Errorf("Expected unknown generation, got %v", err)
+	}
+}
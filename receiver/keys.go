@@ -0,0 +1,139 @@
+package receiver
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/luno/moonbeam/channels"
+)
+
+// DefaultKeyCacheSize is the number of derived channel keys kept in memory.
+const DefaultKeyCacheSize = 1024
+
+// keyCache keeps the most recently used channel keys so that they needn't
+// be derived again on every call.
+type keyCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	keys  map[keyID]*list.Element
+}
+
+type keyID struct {
+	account string
+	gen     int
+	path    int
+}
+
+type cachedKey struct {
+	id  keyID
+	key channels.Signer
+}
+
+func (c *keyCache) get(id keyID) (channels.Signer, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.keys[id]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedKey).key, true
+}
+
+func (c *keyCache) add(id keyID, key channels.Signer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.keys == nil {
+		c.keys = make(map[keyID]*list.Element)
+		c.order = list.New()
+	}
+	if e, ok := c.keys[id]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.keys[id] = c.order.PushFront(&cachedKey{id: id, key: key})
+
+	size := c.size
+	if size <= 0 {
+		size = DefaultKeyCacheSize
+	}
+	for c.order.Len() > size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.keys, e.Value.(*cachedKey).id)
+	}
+}
+
+// SetKeyGapLimit makes Create derive each channel's key from a newly
+// reserved key path instead of sharing path zero. At most limit reserved
+// paths beyond the highest path of an opened channel are outstanding, so a
+// restore from the extended private key can scan paths until limit
+// consecutive ones are unused. Zero, the default, uses path zero for every
+// channel.
+func (r *Receiver) SetKeyGapLimit(limit int) {
+	r.keyGapLimit = limit
+}
+
+// SetKeyCacheSize sets the number of derived channel keys kept in memory,
+// DefaultKeyCacheSize by default.
+func (r *Receiver) SetKeyCacheSize(n int) {
+	r.keys.mu.Lock()
+	defer r.keys.mu.Unlock()
+	r.keys.size = n
+}
+
+// reserveKeyPath returns the key path of a new channel.
+func (r *Receiver) reserveKeyPath(ctx context.Context) (int, error) {
+	if r.keyGapLimit <= 0 {
+		return 0, nil
+	}
+	return r.db.ReserveKeyPath(ctx, r.keyGapLimit)
+}
+
+// maxKeyPath returns the highest key path Create may have returned.
+func (r *Receiver) maxKeyPath(ctx context.Context) (int, error) {
+	if r.keyGapLimit <= 0 {
+		return 0, nil
+	}
+	return r.db.GetKeyPathCounter(ctx)
+}
+
+// PrederiveKeys derives the keys of the next n key paths that Create may
+// reserve, for each account, so that they're cached before the first
+// requests. Without a key gap limit, only path zero is derived.
+func (r *Receiver) PrederiveKeys(ctx context.Context, n int) error {
+	first, last := 0, 0
+	if r.keyGapLimit > 0 {
+		counter, err := r.db.GetKeyPathCounter(ctx)
+		if err != nil {
+			return err
+		}
+		// Paths from the gap may be reserved again, so start there.
+		first = counter - r.keyGapLimit + 1
+		if first < 1 {
+			first = 1
+		}
+		last = counter + n
+	}
+
+	accounts := []string{""}
+	for id := range r.accounts {
+		accounts = append(accounts, id)
+	}
+	gen := r.currentKeyGen()
+	for _, a := range accounts {
+		for path := first; path <= last; path++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if _, err := r.getAccountKey(ctx, a, gen, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+func TestKeyCacheEvicts(t *testing.T) {
+	c := keyCache{size: 2}
+	k := &btcec.PrivateKey{}
+	c.add(keyID{"", 0, 1}, k)
+	c.add(keyID{"", 0, 2}, k)
+	c.get(keyID{"", 0, 1})
+	c.add(keyID{"", 0, 3}, k)
+
+	if _, ok := c.get(keyID{"", 0, 2}); ok {
+		t.Error("Expected least recently used key evicted")
+	}
+	for _, path := range []int{1, 3} {
+		if _, ok := c.get(keyID{"", 0, path}); !ok {
+			t.Errorf("Expected key %d cached", path)
+		}
+	}
+}
+
+func TestReserveKeyPathGap(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+
+	reserve := func(want ...int) {
+		t.Helper()
+		for _, w := range want {
+			n, err := db.ReserveKeyPath(ctx, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != w {
+				t.Errorf("Expected path %d, got %d", w, n)
+			}
+		}
+	}
+
+	reserve(1, 2, 3, 1, 2, 3, 1)
+
+	// Opening a channel on path 2 moves the gap along.
+	err := db.Create(ctx, storage.Record{ID: "a-0", KeyPath: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserve(4, 5, 4, 5, 3)
+}
+
+func TestCreateKeyGapLimit(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := memory.New()
+	r := NewReceiver(net, ek, &closeBackend{}, db, nil, keytest.Address(1, net), "")
+	r.SetKeyGapLimit(2)
+	if err := r.PrederiveKeys(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.keys.get(keyID{"", 0, 2}); !ok {
+		t.Error("Expected path 2 prederived")
+	}
+
+	s, err := channels.NewSender(channels.DefaultSenderConfig, keytest.Key(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := s.GetCreateRequest(keytest.Address(4, net))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pubKeys [][]byte
+	for _, want := range []string{"1", "2", "1"} {
+		resp, err := r.Create(ctx, *req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.ReceiverData) != want {
+			t.Errorf("Expected receiverData %q, got %q", want, resp.ReceiverData)
+		}
+		pubKeys = append(pubKeys, resp.ReceiverPubKey)
+	}
+	if bytes.Equal(pubKeys[0], pubKeys[1]) || !bytes.Equal(pubKeys[0], pubKeys[2]) {
+		t.Error("Expected a key per reserved path")
+	}
+}
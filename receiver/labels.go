@@ -0,0 +1,103 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"regexp"
+)
+
+// Limits on channel labels.
+const (
+	maxLabels        = 16
+	maxLabelValueLen = 256
+)
+
+var validLabelKey = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+var ErrInvalidLabels = NewExposableError("invalid labels")
+
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return ErrInvalidLabels
+	}
+	for k, v := range labels {
+		if !validLabelKey.MatchString(k) || len(v) > maxLabelValueLen {
+			return ErrInvalidLabels
+		}
+	}
+	return nil
+}
+
+// labelsSep separates the labels requested at Create from the rest of the
+// receiverData, so that they can be stored when the channel is opened.
+const labelsSep = '#'
+
+func appendLabels(data []byte, labels map[string]string) ([]byte, error) {
+	if len(labels) == 0 {
+		return data, nil
+	}
+	buf, err := json.Marshal(labels)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, labelsSep)
+	return append(data, base64.RawURLEncoding.EncodeToString(buf)...), nil
+}
+
+// splitLabels returns the receiverData without the labels appended by
+// appendLabels, and the labels.
+func splitLabels(data []byte) ([]byte, map[string]string, error) {
+	i := bytes.IndexByte(data, labelsSep)
+	if i < 0 {
+		return data, nil, nil
+	}
+	invalid := errors.New("invalid receiverData")
+	buf, err := base64.RawURLEncoding.DecodeString(string(data[i+1:]))
+	if err != nil {
+		return nil, nil, invalid
+	}
+	var labels map[string]string
+	if err := json.Unmarshal(buf, &labels); err != nil {
+		return nil, nil, invalid
+	}
+	if err := validateLabels(labels); err != nil {
+		return nil, nil, err
+	}
+	return data[:i], labels, nil
+}
+
+// SetLabels updates the labels of a channel, which operators can use to map
+// channels to their own systems, e.g. by customer ID. Labels with empty
+// values are removed and other labels are kept.
+func (r *Receiver) SetLabels(ctx context.Context, txid string, vout uint32, labels map[string]string) error {
+	id := getChannelID(txid, vout)
+	ctx, unlock, err := r.lockChannel(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rec, err := r.getRecord(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	next := make(map[string]string)
+	for k, v := range rec.Labels {
+		next[k] = v
+	}
+	for k, v := range labels {
+		if v == "" {
+			delete(next, k)
+		} else {
+			next[k] = v
+		}
+	}
+	if err := validateLabels(next); err != nil {
+		return err
+	}
+	return r.db.SetLabels(ctx, id, next)
+}
@@ -0,0 +1,88 @@
+package receiver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+func TestLabelsReceiverData(t *testing.T) {
+	labels := map[string]string{"customer": "42", "plan": "gold"}
+	buf, err := appendLabels(encodeReceiverData(receiverData{account: "shop", destIndex: 3}), labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, got, err := splitLabels(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd, err := decodeReceiverData(data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rd.account != "shop" || rd.destIndex != 3 || len(got) != 2 || got["plan"] != "gold" {
+		t.Errorf("Unexpected decoding %+v %v", rd, got)
+	}
+
+	if _, _, err := splitLabels([]byte("0#!")); err == nil {
+		t.Errorf("Expected invalid labels to be rejected")
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	tooMany := make(map[string]string)
+	for i := 0; i <= maxLabels; i++ {
+		tooMany[strings.Repeat("k", i+1)] = "v"
+	}
+	for _, labels := range []map[string]string{
+		{"": "v"},
+		{"a b": "v"},
+		{"k": strings.Repeat("v", maxLabelValueLen+1)},
+		tooMany,
+	} {
+		if err := validateLabels(labels); err != ErrInvalidLabels {
+			t.Errorf("Expected %v to be invalid, got %v", labels, err)
+		}
+	}
+}
+
+func TestSetLabels(t *testing.T) {
+	ctx := context.Background()
+	r, rec := newOpenReceiver(t, &closeBackend{})
+	txid, vout := rec.SharedState.FundingTxID, rec.SharedState.FundingVout
+
+	err := r.SetLabels(ctx, txid, vout, map[string]string{"customer": "42", "plan": "gold"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.SetLabels(ctx, txid, vout, map[string]string{"plan": "", "region": "eu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recs, _, err := r.List(ctx, storage.ListFilter{Labels: map[string]string{"customer": "42"}}, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("Expected channel to be found by label, got %d", len(recs))
+	}
+	labels := recs[0].Labels
+	if len(labels) != 2 || labels["region"] != "eu" || labels["plan"] != "" {
+		t.Errorf("Unexpected labels %v", labels)
+	}
+
+	recs, _, err = r.List(ctx, storage.ListFilter{Labels: map[string]string{"customer": "43"}}, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 0 {
+		t.Errorf("Expected no channels, got %d", len(recs))
+	}
+
+	if err := r.SetLabels(WithAccount(ctx, "other"), txid, vout, nil); err != storage.ErrNotFound {
+		t.Errorf("Expected other account not to find channel, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+// leaderLease is the lease ID held by the instance running background
+// workers.
+const leaderLease = "leader"
+
+// Lead runs work, e.g. Watch and Rebroadcast, on a single instance of those
+// sharing the storage until ctx is cancelled. Instances campaign for the
+// leader lease and the instance that acquires it runs work with a context
+// that's cancelled once it loses the lease, e.g. because renewing it failed.
+// Another instance takes over within the lease TTL of the leader stopping.
+// Without SetInstance, work simply runs on this instance.
+func (r *Receiver) Lead(ctx context.Context, work func(ctx context.Context)) {
+	if r.instance == "" {
+		r.leading.Store(true)
+		defer r.leading.Store(false)
+		work(ctx)
+		return
+	}
+
+	t := time.NewTicker(r.leaseTTL / 3)
+	defer t.Stop()
+
+	for {
+		token, err := r.db.AcquireLease(ctx, leaderLease, r.instance, r.leaseTTL)
+		if err == nil {
+			r.log.Info("elected leader", "instance", r.instance)
+			r.lead(ctx, t, token, work)
+			r.log.Info("stopped leading", "instance", r.instance)
+		} else if ctx.Err() == nil && !errors.Is(err, storage.ErrLeaseHeld) {
+			r.log.Error("acquire leader lease failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// lead runs work while renewing the leader lease, and waits for work to
+// return once renewing fails or ctx is cancelled.
+func (r *Receiver) lead(ctx context.Context, t *time.Ticker, token int64, work func(ctx context.Context)) {
+	r.leading.Store(true)
+	defer r.leading.Store(false)
+
+	wctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		work(wctx)
+	}()
+	defer func() {
+		cancel()
+		wg.Wait()
+		// Hand over straight away rather than after the TTL.
+		rctx, rcancel := context.WithTimeout(context.Background(), leaseWait)
+		defer rcancel()
+		if err := r.db.ReleaseLease(rctx, leaderLease, r.instance, token); err != nil {
+			r.log.Warn("release leader lease", "err", err)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		var err error
+		token, err = r.db.AcquireLease(ctx, leaderLease, r.instance, r.leaseTTL)
+		if err != nil {
+			if ctx.Err() == nil {
+				r.log.Error("renew leader lease failed", "err", err)
+			}
+			return
+		}
+	}
+}
+
+// Leading returns whether this instance is running background workers.
+func (r *Receiver) Leading() bool {
+	return r.leading.Load()
+}
+
+func (r *Receiver) leaderGauge() map[string]float64 {
+	var v float64
+	if r.Leading() {
+		v = 1
+	}
+	return map[string]float64{r.instance: v}
+}
@@ -0,0 +1,65 @@
+package receiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLeadFailover(t *testing.T) {
+	r1, _ := newOpenReceiver(t, &closeBackend{})
+	r2 := NewReceiver(&chaincfg.TestNet3Params, nil, &closeBackend{}, r1.db, nil, "", "")
+	r1.SetInstance("a", 30*time.Millisecond)
+	r2.SetInstance("b", 30*time.Millisecond)
+
+	work := func(ctx context.Context) { <-ctx.Done() }
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	done1 := make(chan struct{})
+	go func() {
+		r1.Lead(ctx1, work)
+		close(done1)
+	}()
+	waitFor(t, r1.Leading)
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	go r2.Lead(ctx2, work)
+
+	time.Sleep(50 * time.Millisecond)
+	if r2.Leading() {
+		t.Fatal("Expected a single leader")
+	}
+
+	cancel1()
+	<-done1
+	if r1.Leading() {
+		t.Error("Expected r1 to stop leading")
+	}
+	waitFor(t, r2.Leading)
+}
+
+func TestLeadWithoutInstance(t *testing.T) {
+	r, _ := newOpenReceiver(t, &closeBackend{})
+
+	var leading bool
+	r.Lead(context.Background(), func(ctx context.Context) {
+		leading = r.Leading()
+	})
+	if !leading {
+		t.Error("Expected work to run without an instance ID")
+	}
+}
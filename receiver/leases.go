@@ -0,0 +1,81 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+// DefaultLeaseTTL is how long a channel lease lasts if the instance holding
+// it dies before releasing it.
+const DefaultLeaseTTL = 30 * time.Second
+
+const (
+	leaseWait  = 5 * time.Second
+	leaseRetry = 50 * time.Millisecond
+)
+
+// ErrChannelBusy is returned if another instance holds a channel's lease
+// for longer than the receiver waits for it.
+var ErrChannelBusy = NewExposableError("channel busy, try again")
+
+// SetInstance lets several receivers share one Storage. id must be unique
+// to each instance. Each channel operation then holds a lease on the channel
+// in storage besides the in-process lock, and its updates are fenced so that
+// an instance whose lease expired mid-operation can't overwrite the state
+// stored by the instance that took over. Leases of instances that die are
+// available again after ttl, or DefaultLeaseTTL if ttl is zero.
+func (r *Receiver) SetInstance(id string, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	r.instance = id
+	r.leaseTTL = ttl
+}
+
+// lockChannel blocks until the channel, or another ID, is available to this
+// instance and returns a context fencing updates of it, and a function that
+// releases it.
+func (r *Receiver) lockChannel(ctx context.Context, id string) (context.Context, func(), error) {
+	unlock := r.locks.lock(id)
+	if r.instance == "" {
+		return ctx, unlock, nil
+	}
+
+	token, err := r.acquireLease(ctx, id)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+
+	release := func() {
+		// Release with a fresh context since the request's may be done.
+		ctx, cancel := context.WithTimeout(context.Background(), leaseWait)
+		defer cancel()
+		if err := r.db.ReleaseLease(ctx, id, r.instance, token); err != nil {
+			r.log.Warn("release lease", "id", id, "err", err)
+		}
+		unlock()
+	}
+	return storage.WithFence(ctx, id, token), release, nil
+}
+
+func (r *Receiver) acquireLease(ctx context.Context, id string) (int64, error) {
+	deadline := time.Now().Add(leaseWait)
+	for {
+		token, err := r.db.AcquireLease(ctx, id, r.instance, r.leaseTTL)
+		if !errors.Is(err, storage.ErrLeaseHeld) {
+			return token, err
+		}
+		if time.Now().After(deadline) {
+			return 0, ErrChannelBusy
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(leaseRetry):
+		}
+	}
+}
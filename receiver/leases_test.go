@@ -0,0 +1,83 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+func TestLockChannelLeases(t *testing.T) {
+	ctx := context.Background()
+	r1, rec := newOpenReceiver(t, &closeBackend{})
+	r2 := NewReceiver(&chaincfg.TestNet3Params, nil, &closeBackend{}, r1.db, nil, "", "")
+	r1.SetInstance("a", time.Minute)
+	r2.SetInstance("b", time.Minute)
+
+	_, unlock, err := r1.lockChannel(ctx, rec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, 3*leaseRetry)
+	defer cancel()
+	if _, _, err := r2.lockChannel(tctx, rec.ID); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected lease held by other instance, got %v", err)
+	}
+
+	unlock()
+
+	_, unlock, err = r2.lockChannel(ctx, rec.ID)
+	if err != nil {
+		t.Fatalf("Expected released lease, got %v", err)
+	}
+	unlock()
+}
+
+func TestLockChannelFenced(t *testing.T) {
+	ctx := context.Background()
+	r1, rec := newOpenReceiver(t, &closeBackend{})
+	r2 := NewReceiver(&chaincfg.TestNet3Params, nil, &closeBackend{}, r1.db, nil, "", "")
+	r1.SetInstance("a", time.Millisecond)
+	r2.SetInstance("b", time.Minute)
+
+	fctx, unlock, err := r1.lockChannel(ctx, rec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	// r2 takes over the expired lease while r1 is still working.
+	_, unlock2, err := r2.lockChannel(ctx, rec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock2()
+
+	next := rec.SharedState
+	next.Balance = 1000
+	err = r1.db.Update(fctx, rec.ID, 1, next, nil)
+	if !errors.Is(err, storage.ErrFenced) {
+		t.Errorf("Expected fenced update, got %v", err)
+	}
+}
+
+func TestLockChannelWithoutInstance(t *testing.T) {
+	ctx := context.Background()
+	r, rec := newOpenReceiver(t, &closeBackend{})
+
+	lctx, unlock, err := r.lockChannel(ctx, rec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	if _, ok := storage.FenceToken(lctx, rec.ID); ok {
+		t.Error("Expected no fence without an instance ID")
+	}
+}
@@ -0,0 +1,81 @@
+package receiver
+
+import (
+	"context"
+	"encoding/hex"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
+)
+
+var ErrTooManyChannels = NewExposableError("too many open channels for sender")
+
+// SetMaxChannelsPerSender limits the number of channels that aren't closed
+// per sender pubkey. Anyone can create and open channels, so this stops a
+// single sender from exhausting storage. Zero means no limit.
+func (r *Receiver) SetMaxChannelsPerSender(n int) {
+	r.settings.update(func(s *Settings) { s.MaxChannelsPerSender = n })
+}
+
+// checkSenderLimit returns ErrTooManyChannels if the sender has reached the
+// limit on channels that aren't closed.
+func (r *Receiver) checkSenderLimit(ctx context.Context, senderPubKey []byte) error {
+	max := r.settings.get().MaxChannelsPerSender
+	if max <= 0 {
+		return nil
+	}
+	recs, err := r.db.GetBySenderPubKey(ctx, senderPubKey)
+	if err != nil {
+		return err
+	}
+	var n int
+	for _, rec := range recs {
+		if rec.SharedState.Status == channels.StatusClosed {
+			continue
+		}
+		if n++; n >= max {
+			return ErrTooManyChannels
+		}
+	}
+	return nil
+}
+
+// FundingPolicy bounds the funding amount of channels accepted by Open.
+// Zero means no bound.
+type FundingPolicy struct {
+	// Min rejects channels too small to be worth the closure fee.
+	Min int64
+
+	// Max rejects channels larger than the operator wants at risk.
+	Max int64
+}
+
+func (p FundingPolicy) check(value int64) error {
+	if value < p.Min || (p.Max > 0 && value > p.Max) {
+		return FundingRangeError{Value: value, Min: p.Min, Max: p.Max}
+	}
+	return nil
+}
+
+func (p FundingPolicy) AcceptCreate(ctx context.Context, req models.CreateRequest) error {
+	return nil
+}
+
+func (p FundingPolicy) AcceptOpen(ctx context.Context, req models.OpenRequest, value int64) error {
+	return p.check(value)
+}
+
+func (p FundingPolicy) AcceptPayment(ctx context.Context, pc PaymentCheck) error {
+	return nil
+}
+
+// SetFundingPolicy sets the accepted range of funding amounts.
+func (r *Receiver) SetFundingPolicy(p FundingPolicy) {
+	r.settings.update(func(s *Settings) { s.Funding = p })
+}
+
+// senderLockID is used to serialize opening channels from the same sender
+// so that concurrent requests can't exceed the limit.
+func senderLockID(senderPubKey []byte) string {
+	return "sender:" + hex.EncodeToString(senderPubKey)
+}
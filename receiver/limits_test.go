@@ -0,0 +1,66 @@
+package receiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+func TestCheckSenderLimit(t *testing.T) {
+	ctx := context.Background()
+	r, rec := newOpenReceiver(t, &closeBackend{})
+	sender := []byte{2, 1}
+
+	closed := storage.Record{
+		ID: "closed",
+		SharedState: channels.SharedState{
+			Status:       channels.StatusClosed,
+			SenderPubKey: sender,
+		},
+	}
+	if err := r.db.Create(ctx, closed); err != nil {
+		t.Fatal(err)
+	}
+
+	r.SetMaxChannelsPerSender(1)
+	if err := r.checkSenderLimit(ctx, sender); err != nil {
+		t.Errorf("Expected closed channels to be ignored, got %v", err)
+	}
+
+	rec.ID = "open2"
+	rec.SharedState.SenderPubKey = sender
+	if err := r.db.Create(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.checkSenderLimit(ctx, sender); err != ErrTooManyChannels {
+		t.Errorf("Expected ErrTooManyChannels, got %v", err)
+	}
+	if err := r.checkSenderLimit(ctx, []byte{3, 1}); err != nil {
+		t.Errorf("Expected other senders to be allowed, got %v", err)
+	}
+
+	r.SetMaxChannelsPerSender(0)
+	if err := r.checkSenderLimit(ctx, sender); err != nil {
+		t.Errorf("Expected no limit, got %v", err)
+	}
+}
+
+func TestFundingPolicy(t *testing.T) {
+	p := FundingPolicy{Min: 10000, Max: 1e6}
+	if err := p.check(50000); err != nil {
+		t.Errorf("Expected amount in range to be accepted, got %v", err)
+	}
+	for _, v := range []int64{9999, 1e6 + 1} {
+		err := p.check(v)
+		expected := FundingRangeError{Value: v, Min: 10000, Max: 1e6}
+		if err != expected {
+			t.Errorf("Expected %v, got %v", expected, err)
+		}
+	}
+
+	if err := (FundingPolicy{}).check(1); err != nil {
+		t.Errorf("Expected no bounds, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package receiver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	r, rec := newOpenReceiver(t, &closeBackend{})
+
+	created := time.Now()
+	for i := 0; i < 5; i++ {
+		rec := storage.Record{
+			ID:      fmt.Sprintf("c%d", i),
+			Created: created.Add(time.Duration(i) * time.Hour),
+			SharedState: channels.SharedState{
+				Status: channels.StatusClosed,
+			},
+		}
+		if i%2 == 0 {
+			rec.SharedState.Status = channels.StatusOpen
+		}
+		if err := r.db.Create(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	var cursor string
+	for {
+		recs, next, err := r.List(ctx, storage.ListFilter{}, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range recs {
+			ids = append(ids, rec.ID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	expected := fmt.Sprint([]string{"c0", "c1", "c2", "c3", "c4", rec.ID})
+	if fmt.Sprint(ids) != expected {
+		t.Errorf("Expected %s, got %v", expected, ids)
+	}
+
+	f := storage.ListFilter{
+		Status:       channels.StatusOpen,
+		CreatedAfter: created,
+	}
+	recs, next, err := r.List(ctx, f, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || recs[0].ID != "c2" || recs[1].ID != "c4" || next != "" {
+		t.Errorf("Unexpected filtered channels %v %q", recs, next)
+	}
+}
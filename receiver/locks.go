@@ -0,0 +1,46 @@
+package receiver
+
+import "sync"
+
+// channelLocks serializes operations on the same channel so that concurrent
+// requests don't race between loading a channel's state and storing its
+// successor. Locks are keyed by channel ID, or by another ID for operations
+// that span channels. They are removed once no one holds or waits for them.
+type channelLocks struct {
+	mu    sync.Mutex
+	locks map[string]*channelLock
+}
+
+type channelLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock blocks until the channel is available and returns a function that
+// releases it.
+func (l *channelLocks) lock(id string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*channelLock)
+	}
+	cl, ok := l.locks[id]
+	if !ok {
+		cl = &channelLock{}
+		l.locks[id] = cl
+	}
+	cl.refs++
+	l.mu.Unlock()
+
+	cl.Lock()
+
+	return func() {
+		cl.Unlock()
+
+		l.mu.Lock()
+		cl.refs--
+		if cl.refs == 0 {
+			delete(l.locks, id)
+		}
+		l.mu.Unlock()
+	}
+}
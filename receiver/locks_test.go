@@ -0,0 +1,30 @@
+package receiver
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestChannelLocks(t *testing.T) {
+	var l channelLocks
+	var wg sync.WaitGroup
+	var n int
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := l.lock("a")
+			defer unlock()
+			v := n
+			n = v + 1
+		}()
+	}
+	wg.Wait()
+
+	if n != 100 {
+		t.Errorf("Expected 100, got %d", n)
+	}
+	if len(l.locks) != 0 {
+		t.Errorf("Expected locks to be removed, got %d", len(l.locks))
+	}
+}
@@ -0,0 +1,530 @@
+package receiver
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/metrics"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/trace"
+)
+
+var amountBuckets = []float64{1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8}
+
+type receiverMetrics struct {
+	reg *metrics.Registry
+
+	payments           *metrics.Counter
+	paymentAmount      *metrics.Histogram
+	validationFailures *metrics.Counter
+	expiryWarnings     *metrics.Counter
+	bitcoindLatency    *metrics.Histogram
+	bitcoindErrors     *metrics.Counter
+	storageLatency     *metrics.Histogram
+	storageErrors      *metrics.Counter
+}
+
+func newReceiverMetrics(r *Receiver) *receiverMetrics {
+	reg := metrics.NewRegistry()
+	m := &receiverMetrics{
+		reg: reg,
+		payments: reg.NewCounter("moonbeam_payments_total",
+			"Number of payments received."),
+		paymentAmount: reg.NewHistogram("moonbeam_payment_amount_satoshis",
+			"Amounts of payments received.", amountBuckets),
+		validationFailures: reg.NewCounter("moonbeam_validation_failures_total",
+			"Number of payments that failed validation.", "reason"),
+		expiryWarnings: reg.NewCounter("moonbeam_expiry_warnings_total",
+			"Number of channels found open within the expiry warning of their timeout."),
+		bitcoindLatency: reg.NewHistogram("moonbeam_bitcoind_request_duration_seconds",
+			"Latency of bitcoind RPC calls.", metrics.DefBuckets, "method"),
+		bitcoindErrors: reg.NewCounter("moonbeam_bitcoind_errors_total",
+			"Number of failed bitcoind RPC calls.", "method"),
+		storageLatency: reg.NewHistogram("moonbeam_storage_request_duration_seconds",
+			"Latency of storage operations.", metrics.DefBuckets, "op"),
+		storageErrors: reg.NewCounter("moonbeam_storage_errors_total",
+			"Number of failed storage operations.", "op"),
+	}
+	reg.NewGaugeFunc("moonbeam_channels", "Number of channels by status.",
+		"status", r.countChannels)
+	reg.NewGaugeFunc("moonbeam_channel_blocks_to_expiry",
+		"Blocks until the timeout of channels within the expiry warning.",
+		"channel", r.expiringChannels)
+	reg.NewGaugeFunc("moonbeam_leader",
+		"Whether the instance is running background workers.",
+		"instance", r.leaderGauge)
+	return m
+}
+
+// observeEvent records metrics of lifecycle events.
+func (r *Receiver) observeEvent(ctx context.Context, e lifecycleEvent) {
+	if p, ok := e.(paymentAccepted); ok {
+		r.metrics.payments.Inc()
+		r.metrics.paymentAmount.Observe(float64(p.amount))
+	}
+}
+
+// Metrics returns a handler that serves the receiver's metrics in the
+// Prometheus text format.
+func (r *Receiver) Metrics() http.Handler {
+	return r.metrics.reg
+}
+
+func (r *Receiver) countChannels() map[string]float64 {
+	recs, err := r.db.List(context.Background())
+	if err != nil {
+		return nil
+	}
+	counts := make(map[string]float64)
+	for _, rec := range recs {
+		status := rec.SharedState.Status.String()
+		if rec.Frozen {
+			status = "FROZEN"
+		} else if rec.Suspended {
+			status = "SUSPENDED"
+		}
+		counts[status]++
+	}
+	return counts
+}
+
+// startBitcoind starts a span for a bitcoind call. The returned function
+// ends it and records the call's latency.
+func (r *Receiver) startBitcoind(ctx context.Context, method string) func(error) {
+	_, span := trace.Start(ctx, "bitcoind."+method)
+	start := time.Now()
+	return func(err error) {
+		if err != nil {
+			span.SetError(err)
+		}
+		span.End()
+		r.metrics.observeBitcoind(method, start, err)
+	}
+}
+
+func (m *receiverMetrics) observeBitcoind(method string, start time.Time, err error) {
+	m.bitcoindLatency.Observe(time.Since(start).Seconds(), method)
+	if err != nil {
+		m.bitcoindErrors.Inc(method)
+	}
+}
+
+func (m *receiverMetrics) observeStorage(op string, start time.Time, err error) {
+	m.storageLatency.Observe(time.Since(start).Seconds(), op)
+	if err != nil {
+		m.storageErrors.Inc(op)
+	}
+}
+
+// instrumentedStorage records a span and the latency of each storage
+// operation.
+type instrumentedStorage struct {
+	db storage.Storage
+	m  *receiverMetrics
+}
+
+func (s instrumentedStorage) start(ctx context.Context, op string) (context.Context, func(error)) {
+	ctx, span := trace.Start(ctx, "storage."+op)
+	start := time.Now()
+	return ctx, func(err error) {
+		if err != nil {
+			span.SetError(err)
+		}
+		span.End()
+		s.m.observeStorage(op, start, err)
+	}
+}
+
+func (s instrumentedStorage) Get(ctx context.Context, id string) (*storage.Record, error) {
+	ctx, done := s.start(ctx, "get")
+	rec, err := s.db.Get(ctx, id)
+	done(err)
+	return rec, err
+}
+
+func (s instrumentedStorage) List(ctx context.Context) ([]storage.Record, error) {
+	ctx, done := s.start(ctx, "list")
+	recs, err := s.db.List(ctx)
+	done(err)
+	return recs, err
+}
+
+func (s instrumentedStorage) Create(ctx context.Context, rec storage.Record) error {
+	ctx, done := s.start(ctx, "create")
+	err := s.db.Create(ctx, rec)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) Update(ctx context.Context, id string, version int64, new channels.SharedState, payment []byte) error {
+	ctx, done := s.start(ctx, "update")
+	err := s.db.Update(ctx, id, version, new, payment)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) Commit(ctx context.Context, tx storage.Tx) error {
+	ctx, done := s.start(ctx, "commit")
+	err := s.db.Commit(ctx, tx)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) ReserveKeyPath(ctx context.Context, gapLimit int) (int, error) {
+	ctx, done := s.start(ctx, "reserve_key_path")
+	n, err := s.db.ReserveKeyPath(ctx, gapLimit)
+	done(err)
+	return n, err
+}
+
+func (s instrumentedStorage) ListKeyGenerations(ctx context.Context) ([]storage.KeyGeneration, error) {
+	ctx, done := s.start(ctx, "list_key_generations")
+	gs, err := s.db.ListKeyGenerations(ctx)
+	done(err)
+	return gs, err
+}
+
+func (s instrumentedStorage) AddKeyGeneration(ctx context.Context, xpub string) (storage.KeyGeneration, error) {
+	ctx, done := s.start(ctx, "add_key_generation")
+	g, err := s.db.AddKeyGeneration(ctx, xpub)
+	done(err)
+	return g, err
+}
+
+func (s instrumentedStorage) Snapshot(ctx context.Context) (*storage.Snapshot, error) {
+	ctx, done := s.start(ctx, "snapshot")
+	snap, err := s.db.Snapshot(ctx)
+	done(err)
+	return snap, err
+}
+
+func (s instrumentedStorage) Restore(ctx context.Context, snap storage.Snapshot) error {
+	ctx, done := s.start(ctx, "restore")
+	err := s.db.Restore(ctx, snap)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) PutAccessEntry(ctx context.Context, e storage.AccessEntry) error {
+	ctx, done := s.start(ctx, "put_access_entry")
+	err := s.db.PutAccessEntry(ctx, e)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) DeleteAccessEntry(ctx context.Context, list storage.AccessList, kind storage.AccessKind, value string) error {
+	ctx, done := s.start(ctx, "delete_access_entry")
+	err := s.db.DeleteAccessEntry(ctx, list, kind, value)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) ListAccessEntries(ctx context.Context) ([]storage.AccessEntry, error) {
+	ctx, done := s.start(ctx, "list_access_entries")
+	es, err := s.db.ListAccessEntries(ctx)
+	done(err)
+	return es, err
+}
+
+func (s instrumentedStorage) GetAllowlistOnly(ctx context.Context) (bool, error) {
+	ctx, done := s.start(ctx, "get_allowlist_only")
+	on, err := s.db.GetAllowlistOnly(ctx)
+	done(err)
+	return on, err
+}
+
+func (s instrumentedStorage) SetAllowlistOnly(ctx context.Context, on bool) error {
+	ctx, done := s.start(ctx, "set_allowlist_only")
+	err := s.db.SetAllowlistOnly(ctx, on)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) AddScreeningRecord(ctx context.Context, rec storage.ScreeningRecord) error {
+	ctx, done := s.start(ctx, "add_screening_record")
+	err := s.db.AddScreeningRecord(ctx, rec)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) ListScreeningRecords(ctx context.Context) ([]storage.ScreeningRecord, error) {
+	ctx, done := s.start(ctx, "list_screening_records")
+	recs, err := s.db.ListScreeningRecords(ctx)
+	done(err)
+	return recs, err
+}
+
+func (s instrumentedStorage) GetKeyPathCounter(ctx context.Context) (int, error) {
+	ctx, done := s.start(ctx, "get_key_path_counter")
+	n, err := s.db.GetKeyPathCounter(ctx)
+	done(err)
+	return n, err
+}
+
+func (s instrumentedStorage) ReserveDestinationIndex(ctx context.Context, xpub string) (uint32, error) {
+	ctx, done := s.start(ctx, "reserve_destination_index")
+	n, err := s.db.ReserveDestinationIndex(ctx, xpub)
+	done(err)
+	return n, err
+}
+
+func (s instrumentedStorage) ListPayments(ctx context.Context, channelID string) ([][]byte, error) {
+	ctx, done := s.start(ctx, "list_payments")
+	payments, err := s.db.ListPayments(ctx, channelID)
+	done(err)
+	return payments, err
+}
+
+func (s instrumentedStorage) ListPaymentsPage(ctx context.Context, f storage.PaymentFilter, cursor string, limit int) ([]storage.StoredPayment, string, error) {
+	ctx, done := s.start(ctx, "list_payments_page")
+	payments, next, err := s.db.ListPaymentsPage(ctx, f, cursor, limit)
+	done(err)
+	return payments, next, err
+}
+
+func (s instrumentedStorage) Freeze(ctx context.Context, id string, reason string) error {
+	ctx, done := s.start(ctx, "freeze")
+	err := s.db.Freeze(ctx, id, reason)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) Suspend(ctx context.Context, id string, suspended bool, reason string) error {
+	ctx, done := s.start(ctx, "suspend")
+	err := s.db.Suspend(ctx, id, suspended, reason)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) GetWatchHeight(ctx context.Context) (int64, error) {
+	ctx, done := s.start(ctx, "get_watch_height")
+	h, err := s.db.GetWatchHeight(ctx)
+	done(err)
+	return h, err
+}
+
+func (s instrumentedStorage) SetWatchHeight(ctx context.Context, height int64) error {
+	ctx, done := s.start(ctx, "set_watch_height")
+	err := s.db.SetWatchHeight(ctx, height)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) SetLabels(ctx context.Context, id string, labels map[string]string) error {
+	ctx, done := s.start(ctx, "set_labels")
+	err := s.db.SetLabels(ctx, id, labels)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) PutPending(ctx context.Context, p storage.Pending) error {
+	ctx, done := s.start(ctx, "put_pending")
+	err := s.db.PutPending(ctx, p)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) ListPending(ctx context.Context) ([]storage.Pending, error) {
+	ctx, done := s.start(ctx, "list_pending")
+	res, err := s.db.ListPending(ctx)
+	done(err)
+	return res, err
+}
+
+func (s instrumentedStorage) DeletePending(ctx context.Context, fundingAddress string) error {
+	ctx, done := s.start(ctx, "delete_pending")
+	err := s.db.DeletePending(ctx, fundingAddress)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) SetClosure(ctx context.Context, id string, c storage.Closure) error {
+	ctx, done := s.start(ctx, "set_closure")
+	err := s.db.SetClosure(ctx, id, c)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) AddRevocationSecret(ctx context.Context, channelID string, secret []byte) error {
+	ctx, done := s.start(ctx, "add_revocation_secret")
+	err := s.db.AddRevocationSecret(ctx, channelID, secret)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) ListRevocationSecrets(ctx context.Context, channelID string) ([][]byte, error) {
+	ctx, done := s.start(ctx, "list_revocation_secrets")
+	secrets, err := s.db.ListRevocationSecrets(ctx, channelID)
+	done(err)
+	return secrets, err
+}
+
+func (s instrumentedStorage) ArchiveChannel(ctx context.Context, id string, version int64, location string) error {
+	ctx, done := s.start(ctx, "archive_channel")
+	err := s.db.ArchiveChannel(ctx, id, version, location)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) GetBySenderPubKey(ctx context.Context, senderPubKey []byte) ([]storage.Record, error) {
+	ctx, done := s.start(ctx, "get_by_sender_pubkey")
+	recs, err := s.db.GetBySenderPubKey(ctx, senderPubKey)
+	done(err)
+	return recs, err
+}
+
+func (s instrumentedStorage) ListPage(ctx context.Context, f storage.ListFilter, cursor string, limit int) ([]storage.Record, string, error) {
+	ctx, done := s.start(ctx, "list_page")
+	recs, next, err := s.db.ListPage(ctx, f, cursor, limit)
+	done(err)
+	return recs, next, err
+}
+
+func (s instrumentedStorage) QueryPayments(ctx context.Context, from, to time.Time) ([]storage.StoredPayment, error) {
+	ctx, done := s.start(ctx, "query_payments")
+	payments, err := s.db.QueryPayments(ctx, from, to)
+	done(err)
+	return payments, err
+}
+
+func (s instrumentedStorage) AddSentPayment(ctx context.Context, channelID string, p storage.SentPayment) error {
+	ctx, done := s.start(ctx, "add_sent_payment")
+	err := s.db.AddSentPayment(ctx, channelID, p)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) GetSentPayment(ctx context.Context, channelID string, paymentID string) (*storage.SentPayment, error) {
+	ctx, done := s.start(ctx, "get_sent_payment")
+	p, err := s.db.GetSentPayment(ctx, channelID, paymentID)
+	done(err)
+	return p, err
+}
+
+func (s instrumentedStorage) AddAPIKey(ctx context.Context, k storage.APIKey) error {
+	ctx, done := s.start(ctx, "add_api_key")
+	err := s.db.AddAPIKey(ctx, k)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) GetAPIKey(ctx context.Context, id string) (*storage.APIKey, error) {
+	ctx, done := s.start(ctx, "get_api_key")
+	k, err := s.db.GetAPIKey(ctx, id)
+	done(err)
+	return k, err
+}
+
+func (s instrumentedStorage) DeleteAPIKey(ctx context.Context, id string) error {
+	ctx, done := s.start(ctx, "delete_api_key")
+	err := s.db.DeleteAPIKey(ctx, id)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) AddTargetCredit(ctx context.Context, c storage.TargetCredit) error {
+	ctx, done := s.start(ctx, "add_target_credit")
+	err := s.db.AddTargetCredit(ctx, c)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) ListTargetCredits(ctx context.Context, from, to time.Time) ([]storage.TargetCredit, error) {
+	ctx, done := s.start(ctx, "list_target_credits")
+	credits, err := s.db.ListTargetCredits(ctx, from, to)
+	done(err)
+	return credits, err
+}
+
+func (s instrumentedStorage) ListTargetBalances(ctx context.Context) ([]storage.TargetBalance, error) {
+	ctx, done := s.start(ctx, "list_target_balances")
+	balances, err := s.db.ListTargetBalances(ctx)
+	done(err)
+	return balances, err
+}
+
+func (s instrumentedStorage) AddInvoice(ctx context.Context, inv storage.Invoice) error {
+	ctx, done := s.start(ctx, "add_invoice")
+	err := s.db.AddInvoice(ctx, inv)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) GetInvoice(ctx context.Context, id string) (*storage.Invoice, error) {
+	ctx, done := s.start(ctx, "get_invoice")
+	inv, err := s.db.GetInvoice(ctx, id)
+	done(err)
+	return inv, err
+}
+
+func (s instrumentedStorage) AddDeadLetter(ctx context.Context, dl storage.DeadLetter) error {
+	ctx, done := s.start(ctx, "add_dead_letter")
+	err := s.db.AddDeadLetter(ctx, dl)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) ListDeadLetters(ctx context.Context) ([]storage.DeadLetter, error) {
+	ctx, done := s.start(ctx, "list_dead_letters")
+	dls, err := s.db.ListDeadLetters(ctx)
+	done(err)
+	return dls, err
+}
+
+func (s instrumentedStorage) ListChannelPayments(ctx context.Context, channelID string) ([]storage.StoredPayment, error) {
+	ctx, done := s.start(ctx, "list_channel_payments")
+	payments, err := s.db.ListChannelPayments(ctx, channelID)
+	done(err)
+	return payments, err
+}
+
+func (s instrumentedStorage) ListDailyTotals(ctx context.Context, target string, from, to time.Time) ([]storage.DailyTotal, error) {
+	ctx, done := s.start(ctx, "list_daily_totals")
+	ts, err := s.db.ListDailyTotals(ctx, target, from, to)
+	done(err)
+	return ts, err
+}
+
+func (s instrumentedStorage) AddOutbox(ctx context.Context, e storage.OutboxEvent) (int64, error) {
+	ctx, done := s.start(ctx, "add_outbox")
+	id, err := s.db.AddOutbox(ctx, e)
+	done(err)
+	return id, err
+}
+
+func (s instrumentedStorage) ListOutbox(ctx context.Context, limit int) ([]storage.OutboxEvent, error) {
+	ctx, done := s.start(ctx, "list_outbox")
+	es, err := s.db.ListOutbox(ctx, limit)
+	done(err)
+	return es, err
+}
+
+func (s instrumentedStorage) DeleteOutbox(ctx context.Context, id int64) error {
+	ctx, done := s.start(ctx, "delete_outbox")
+	err := s.db.DeleteOutbox(ctx, id)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) AcquireLease(ctx context.Context, id, owner string, ttl time.Duration) (int64, error) {
+	ctx, done := s.start(ctx, "acquire_lease")
+	token, err := s.db.AcquireLease(ctx, id, owner, ttl)
+	if err == storage.ErrLeaseHeld {
+		// Contention, not a storage failure.
+		done(nil)
+	} else {
+		done(err)
+	}
+	return token, err
+}
+
+func (s instrumentedStorage) ReleaseLease(ctx context.Context, id, owner string, token int64) error {
+	ctx, done := s.start(ctx, "release_lease")
+	err := s.db.ReleaseLease(ctx, id, owner, token)
+	done(err)
+	return err
+}
+
+var _ storage.Storage = instrumentedStorage{}
@@ -0,0 +1,188 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/zmq"
+)
+
+const (
+	zmqRawBlock = "rawblock"
+	zmqRawTx    = "rawtx"
+
+	zmqMaxBackoff = time.Minute
+)
+
+// notifier wakes the watcher when bitcoind announces a new block or a
+// transaction spending the funding output of an open channel, so that
+// confirmations, reorgs and closures are noticed without waiting for the
+// next poll.
+type notifier struct {
+	blocks chan struct{}
+	spends chan spend
+
+	mu      sync.Mutex
+	funding map[wire.OutPoint]string
+}
+
+func newNotifier() notifier {
+	return notifier{
+		blocks:  make(chan struct{}, 1),
+		spends:  make(chan spend, 64),
+		funding: make(map[wire.OutPoint]string),
+	}
+}
+
+func fundingOutPoint(s channels.SharedState) (wire.OutPoint, bool) {
+	h, err := chainhash.NewHashFromStr(s.FundingTxID)
+	if err != nil {
+		return wire.OutPoint{}, false
+	}
+	return *wire.NewOutPoint(h, s.FundingVout), true
+}
+
+// track replaces the set of funding outputs whose spends are reported.
+func (n *notifier) track(recs []storage.Record) {
+	funding := make(map[wire.OutPoint]string)
+	for _, rec := range recs {
+		s := rec.SharedState
+		switch s.Status {
+		case channels.StatusOpenUnconfirmed, channels.StatusOpen, channels.StatusClosing:
+		default:
+			continue
+		}
+		if op, ok := fundingOutPoint(s); ok {
+			funding[op] = rec.ID
+		}
+	}
+	n.mu.Lock()
+	n.funding = funding
+	n.mu.Unlock()
+}
+
+// add tracks the funding output of a newly opened channel.
+func (n *notifier) add(id string, s channels.SharedState) {
+	op, ok := fundingOutPoint(s)
+	if !ok {
+		return
+	}
+	n.mu.Lock()
+	n.funding[op] = id
+	n.mu.Unlock()
+}
+
+func (n *notifier) block() {
+	select {
+	case n.blocks <- struct{}{}:
+	default:
+	}
+}
+
+// spend is a transaction spending a channel's funding output.
+type spend struct {
+	id string
+	tx *wire.MsgTx
+}
+
+// tx reports any tracked channels whose funding the transaction spends.
+func (n *notifier) tx(tx *wire.MsgTx) {
+	n.mu.Lock()
+	var spends []spend
+	for _, in := range tx.TxIn {
+		if id, ok := n.funding[in.PreviousOutPoint]; ok {
+			spends = append(spends, spend{id: id, tx: tx})
+		}
+	}
+	n.mu.Unlock()
+
+	for _, sp := range spends {
+		select {
+		case n.spends <- sp:
+		default:
+			// The watcher is busy. The channel will be checked at the
+			// next block.
+		}
+	}
+}
+
+func (r *Receiver) handleNotification(msg [][]byte) {
+	if len(msg) < 2 {
+		return
+	}
+	switch string(msg[0]) {
+	case zmqRawBlock:
+		var b wire.MsgBlock
+		if err := b.Deserialize(bytes.NewReader(msg[1])); err != nil {
+			r.log.Debug("failed to decode zmq block", "err", err)
+		} else {
+			for _, tx := range b.Transactions {
+				r.notify.tx(tx)
+			}
+		}
+		r.notify.block()
+	case zmqRawTx:
+		var tx wire.MsgTx
+		if err := tx.Deserialize(bytes.NewReader(msg[1])); err != nil {
+			r.log.Debug("failed to decode zmq transaction", "err", err)
+			return
+		}
+		r.notify.tx(&tx)
+	}
+}
+
+// SubscribeZMQ receives bitcoind's rawblock and rawtx notifications from
+// addr, as configured with bitcoind's -zmqpubrawblock and -zmqpubrawtx
+// options, until ctx is cancelled. Notifications make Watch check channels
+// immediately instead of at its next poll. The connection is retried with
+// backoff if it fails.
+func (r *Receiver) SubscribeZMQ(ctx context.Context, addr string) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := r.receiveZMQ(ctx, addr)
+		if ctx.Err() != nil {
+			return
+		}
+		r.log.Error("zmq subscription failed", "addr", addr, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > zmqMaxBackoff {
+			backoff = zmqMaxBackoff
+		}
+	}
+}
+
+func (r *Receiver) receiveZMQ(ctx context.Context, addr string) error {
+	sub, err := zmq.Dial(addr, zmqRawBlock, zmqRawTx)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	stop := context.AfterFunc(ctx, func() { sub.Close() })
+	defer stop()
+
+	r.log.Info("subscribed to zmq notifications", "addr", addr)
+
+	// Catch up on anything missed while disconnected.
+	r.notify.block()
+
+	for {
+		msg, err := sub.Receive()
+		if err != nil {
+			return err
+		}
+		r.handleNotification(msg)
+	}
+}
@@ -0,0 +1,60 @@
+package receiver
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+func TestNotifierSpends(t *testing.T) {
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+
+	n := newNotifier()
+	n.track([]storage.Record{
+		{
+			ID: "open",
+			SharedState: channels.SharedState{
+				Status:      channels.StatusOpen,
+				FundingTxID: txid,
+				FundingVout: 1,
+			},
+		},
+		{
+			ID: "closed",
+			SharedState: channels.SharedState{
+				Status:      channels.StatusClosed,
+				FundingTxID: txid,
+				FundingVout: 2,
+			},
+		},
+	})
+
+	spendTx := func(vout uint32) *wire.MsgTx {
+		op, _ := fundingOutPoint(channels.SharedState{FundingTxID: txid, FundingVout: vout})
+		tx := wire.NewMsgTx(2)
+		tx.AddTxIn(wire.NewTxIn(&op, nil, nil))
+		return tx
+	}
+
+	n.tx(spendTx(2))
+	n.tx(spendTx(3))
+	select {
+	case sp := <-n.spends:
+		t.Fatalf("Unexpected spend of %s", sp.id)
+	default:
+	}
+
+	tx := spendTx(1)
+	n.tx(tx)
+	select {
+	case sp := <-n.spends:
+		if sp.id != "open" || sp.tx != tx {
+			t.Errorf("Unexpected spend %+v", sp)
+		}
+	default:
+		t.Errorf("Expected spend of open channel")
+	}
+}
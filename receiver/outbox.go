@@ -0,0 +1,108 @@
+package receiver
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+// outboxBatch is the number of stored events published per storage read.
+const outboxBatch = 100
+
+// Publisher delivers channel events to a message broker, such as NATS
+// JetStream or Kafka, e.g. to feed an analytics pipeline.
+type Publisher interface {
+	// Publish sends the JSON encoding of an Event. It must only return nil
+	// once the broker has durably stored it. id is unique to the event and
+	// stays the same when the event is published again, so that brokers
+	// and consumers can discard duplicates.
+	Publish(ctx context.Context, id string, t EventType, event []byte) error
+}
+
+// SetPublisher enables publishing of every channel lifecycle event and
+// accepted payment. Events are stored in an outbox by the call that caused
+// them and published in order by RunPublisher, which retries until the
+// publisher succeeds, so each event is delivered at least once. It must be
+// called before the receiver starts serving requests.
+func (r *Receiver) SetPublisher(p Publisher) {
+	r.publisher = p
+	r.outboxWake = make(chan struct{}, 1)
+}
+
+// outboxEvent stores lifecycle events to be published.
+func (r *Receiver) outboxEvent(ctx context.Context, e lifecycleEvent) {
+	if r.publisher == nil {
+		return
+	}
+	ev := e.event()
+	ev.Time = time.Now()
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		r.log.Error("failed to encode event", "type", ev.Type, "err", err)
+		return
+	}
+	_, err = r.db.AddOutbox(ctx, storage.OutboxEvent{
+		Type:      string(ev.Type),
+		ChannelID: ev.ChannelID,
+		Event:     buf,
+		Time:      ev.Time,
+	})
+	if err != nil {
+		// The state change has been stored, so it can't be undone. The
+		// event is lost to the publisher.
+		r.log.Error("failed to store outbox event", "type", ev.Type,
+			"channel", ev.ChannelID, "err", err)
+		return
+	}
+
+	select {
+	case r.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunPublisher publishes stored events with the publisher set by
+// SetPublisher until ctx is cancelled. Events are published as soon as
+// they're stored, and after a failure the remaining events are tried again
+// every interval.
+func (r *Receiver) RunPublisher(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if err := r.publishOutbox(ctx); err != nil && ctx.Err() == nil {
+			r.log.Error("publishing events failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-r.outboxWake:
+		}
+	}
+}
+
+// publishOutbox publishes stored events in order until none are left or
+// one fails, which stops later events from overtaking it.
+func (r *Receiver) publishOutbox(ctx context.Context) error {
+	for {
+		es, err := r.db.ListOutbox(ctx, outboxBatch)
+		if err != nil {
+			return err
+		} else if len(es) == 0 {
+			return nil
+		}
+		for _, e := range es {
+			id := strconv.FormatInt(e.ID, 10)
+			if err := r.publisher.Publish(ctx, id, EventType(e.Type), e.Event); err != nil {
+				return err
+			}
+			if err := r.db.DeleteOutbox(ctx, e.ID); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package receiver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/luno/moonbeam/channels"
+)
+
+type fakePublisher struct {
+	err error
+	ids []string
+	evs []Event
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, id string, t EventType, event []byte) error {
+	if p.err != nil {
+		return p.err
+	}
+	var e Event
+	if err := json.Unmarshal(event, &e); err != nil {
+		return err
+	}
+	if e.Type != t {
+		return errors.New("type mismatch")
+	}
+	p.ids = append(p.ids, id)
+	p.evs = append(p.evs, e)
+	return nil
+}
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	r, rec := newOpenReceiver(t, &closeBackend{})
+	p := &fakePublisher{err: errors.New("broker down")}
+	r.SetPublisher(p)
+
+	next := rec.SharedState
+	next.Count, next.Balance = 1, 100
+	r.publishTransition(ctx, rec.ID, rec.SharedState, next, nil)
+	closed := next
+	closed.Status = channels.StatusClosed
+	r.publishTransition(ctx, rec.ID, next, closed, nil)
+
+	if err := r.publishOutbox(ctx); err == nil {
+		t.Fatal("Expected publish to fail")
+	}
+	es, err := r.db.ListOutbox(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 {
+		t.Fatalf("Expected 2 stored events, got %d", len(es))
+	}
+
+	p.err = nil
+	if err := r.publishOutbox(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.evs) != 2 || p.evs[0].Type != EventPayment || p.evs[0].Amount != 100 ||
+		p.evs[1].Type != EventClosed || p.evs[1].ChannelID != rec.ID {
+		t.Errorf("Unexpected events %+v", p.evs)
+	}
+	if p.ids[0] != "1" || p.ids[1] != "2" {
+		t.Errorf("Unexpected event IDs %v", p.ids)
+	}
+
+	es, err = r.db.ListOutbox(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 0 {
+		t.Errorf("Expected empty outbox, got %d events", len(es))
+	}
+}
@@ -0,0 +1,89 @@
+package receiver
+
+import (
+	"context"
+	"time"
+
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage"
+)
+
+// PaymentQuery selects payments for QueryPayments. Zero fields match all
+// payments.
+type PaymentQuery struct {
+	Target string
+
+	MinAmount int64
+	MaxAmount int64
+
+	// From and To bound the time the payment was accepted. From is
+	// inclusive and To exclusive.
+	From time.Time
+	To   time.Time
+}
+
+func (q PaymentQuery) match(p models.Payment) bool {
+	if q.Target != "" && p.Target != q.Target {
+		return false
+	}
+	if p.Amount < q.MinAmount {
+		return false
+	}
+	if q.MaxAmount > 0 && p.Amount > q.MaxAmount {
+		return false
+	}
+	return true
+}
+
+// ReceivedPayment is a decoded payment accepted on a channel.
+type ReceivedPayment struct {
+	ChannelID string
+	Time      time.Time
+	models.Payment
+}
+
+// QueryPayments returns the payments accepted on all channels that match
+// q, ordered by time. Payments that can't be decoded are skipped. If the
+// context is scoped to an account, only its channels are included.
+func (r *Receiver) QueryPayments(ctx context.Context, q PaymentQuery) ([]ReceivedPayment, error) {
+	stored, err := r.db.QueryPayments(ctx, q.From, q.To)
+	if err != nil {
+		return nil, err
+	}
+
+	_, scoped := accountFromContext(ctx)
+	visibleChannels := make(map[string]bool)
+
+	var res []ReceivedPayment
+	for _, sp := range stored {
+		if scoped {
+			ok, seen := visibleChannels[sp.ChannelID]
+			if !seen {
+				_, err := r.getRecord(ctx, sp.ChannelID)
+				if err != nil && err != storage.ErrNotFound {
+					return nil, err
+				}
+				ok = err == nil
+				visibleChannels[sp.ChannelID] = ok
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		p, err := models.DecodeStoredPayment(sp.Payment)
+		if err != nil {
+			r.log.Debug("skipping undecodable payment", "channel", sp.ChannelID, "err", err)
+			continue
+		}
+		if !q.match(*p) {
+			continue
+		}
+		res = append(res, ReceivedPayment{
+			ChannelID: sp.ChannelID,
+			Time:      sp.Time,
+			Payment:   *p,
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,63 @@
+package receiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/models"
+)
+
+func TestQueryPayments(t *testing.T) {
+	ctx := context.Background()
+	r, rec := newOpenReceiver(t, &closeBackend{})
+
+	start := time.Now()
+	prev := rec.SharedState
+	for i, p := range []models.Payment{
+		{Amount: 100, Target: "/article/123"},
+		{Amount: 500, Target: "/article/123"},
+		{Amount: 100, Target: "/article/456"},
+	} {
+		p.Nonce = string(rune('a' + i))
+		p.Counter = i + 1
+		buf, err := models.EncodePayment(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := prev
+		next.Count++
+		next.Balance += p.Amount
+		if err := r.db.Update(ctx, rec.ID, int64(i+1), next, buf); err != nil {
+			t.Fatal(err)
+		}
+		prev = next
+	}
+
+	res, err := r.QueryPayments(ctx, PaymentQuery{Target: "/article/123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0].Amount != 100 || res[1].Amount != 500 {
+		t.Errorf("Unexpected payments %+v", res)
+	}
+	if res[0].ChannelID != rec.ID || res[0].Time.Before(start) {
+		t.Errorf("Unexpected payment details %+v", res[0])
+	}
+
+	res, err = r.QueryPayments(ctx, PaymentQuery{MinAmount: 200, From: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Amount != 500 {
+		t.Errorf("Unexpected payments %+v", res)
+	}
+
+	res, err = r.QueryPayments(ctx, PaymentQuery{To: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 0 {
+		t.Errorf("Expected no payments before start, got %+v", res)
+	}
+}
@@ -2,21 +2,36 @@ package receiver
 
 import (
 	"github.com/btcsuite/btcd/chaincfg"
+
+	"github.com/luno/moonbeam/channels"
 )
 
 type policy struct {
 	SoftTimeout    int
 	FundingMinConf int
+
+	// CloseWindow is the number of blocks before the sender can claim the
+	// refund by which the channel must be closed, to leave time for the
+	// closure transaction to confirm.
+	CloseWindow int
+
+	// CloseMinConf is the number of confirmations after which a closure
+	// transaction is considered final.
+	CloseMinConf int
 }
 
 var policies = map[string]policy{
 	"mainnet": policy{
 		SoftTimeout:    144,
 		FundingMinConf: 3,
+		CloseWindow:    144,
+		CloseMinConf:   6,
 	},
 	"testnet3": policy{
 		SoftTimeout:    32,
 		FundingMinConf: 1,
+		CloseWindow:    32,
+		CloseMinConf:   1,
 	},
 }
 
@@ -28,3 +43,20 @@ func getPolicy(net *chaincfg.Params) policy {
 		return policies["mainnet"]
 	}
 }
+
+// closeHeight returns the block height at which the receiver closes the
+// channel. This is normally after the soft timeout, but never later than
+// CloseWindow blocks before the refund becomes valid.
+func (p policy) closeHeight(s channels.SharedState) int64 {
+	timeout := int64(p.SoftTimeout)
+	if timeout < s.Timeout {
+		timeout = s.Timeout / 2
+	}
+	h := int64(s.BlockHeight) + timeout
+
+	deadline := int64(s.BlockHeight) + s.Timeout - int64(p.CloseWindow)
+	if deadline < h {
+		h = deadline
+	}
+	return h
+}
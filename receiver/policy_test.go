@@ -0,0 +1,49 @@
+package receiver
+
+import (
+	"testing"
+
+	"github.com/luno/moonbeam/channels"
+)
+
+func TestCloseHeight(t *testing.T) {
+	p := policy{SoftTimeout: 144, CloseWindow: 144}
+
+	tests := []struct {
+		timeout  int64
+		expected int64
+	}{
+		// Half the timeout for long timeouts.
+		{1008, 1000 + 504},
+		{400, 1000 + 200},
+		// Never later than the close window before expiry.
+		{200, 1000 + 200 - 144},
+		{100, 1000 + 100 - 144},
+	}
+	for _, test := range tests {
+		s := channels.SharedState{BlockHeight: 1000, Timeout: test.timeout}
+		if h := p.closeHeight(s); h != test.expected {
+			t.Errorf("timeout %d: expected %d, got %d", test.timeout, test.expected, h)
+		}
+	}
+}
+
+func TestUtilizationExhausted(t *testing.T) {
+	s := channels.SharedState{Capacity: 10000, Fee: 1000}
+
+	var p UtilizationPolicy
+	s.Balance = 9000
+	if p.exhausted(s) {
+		t.Errorf("Disabled policy shouldn't flag channels")
+	}
+
+	p.Threshold = 0.9
+	s.Balance = 8099
+	if p.exhausted(s) {
+		t.Errorf("Channel below threshold flagged")
+	}
+	s.Balance = 8100
+	if !p.exhausted(s) {
+		t.Errorf("Channel at threshold not flagged")
+	}
+}
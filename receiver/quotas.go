@@ -0,0 +1,168 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
+)
+
+// Quota limits the payments accepted within a sliding window.
+type Quota struct {
+	// Amount limits the total amount of the payments, if it's not zero.
+	Amount int64
+
+	// Payments limits the number of payments, if it's not zero.
+	Payments int
+
+	Window time.Duration
+}
+
+func (q Quota) validate() error {
+	if q.Amount < 0 || q.Payments < 0 {
+		return errors.New("negative quota")
+	}
+	if q.Window <= 0 {
+		return errors.New("quota requires a window")
+	}
+	return nil
+}
+
+// Quotas cap the receiver's exposure to a single counterparty, e.g. 100000
+// satoshis per channel per 24h and 60 payments per sender per minute.
+type Quotas struct {
+	// Channel quotas apply to each channel.
+	Channel []Quota
+
+	// Sender quotas apply to all of the channels of a sender pubkey
+	// together.
+	Sender []Quota
+}
+
+func (q Quotas) validate() error {
+	for _, qs := range [][]Quota{q.Channel, q.Sender} {
+		for _, quota := range qs {
+			if err := quota.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SetQuotas sets the quotas enforced by Send.
+func (r *Receiver) SetQuotas(q Quotas) error {
+	if err := q.validate(); err != nil {
+		return err
+	}
+	r.settings.update(func(s *Settings) { s.Quotas = q })
+	return nil
+}
+
+func quotaError(scope string, q Quota) error {
+	return NewRejection(models.ReasonQuotaExceeded,
+		fmt.Sprintf("%s quota of %s exceeded", scope, q.describe()))
+}
+
+func (q Quota) describe() string {
+	var s string
+	if q.Amount > 0 {
+		s = fmt.Sprintf("%d satoshis", q.Amount)
+	}
+	if q.Payments > 0 {
+		if s != "" {
+			s += " and "
+		}
+		s += fmt.Sprintf("%d payments", q.Payments)
+	}
+	return s + " per " + q.Window.String()
+}
+
+// checkQuotas returns a rejection if the payment would exceed one of the
+// quotas. It's called by Send with the channel locked, and locks the sender
+// if there are sender quotas, so usage is counted from the stored payments
+// without racing other payments. The returned function releases the sender
+// lock and must be called once the payment has been stored or rejected.
+func (r *Receiver) checkQuotas(ctx context.Context, id string, s channels.SharedState, amount int64) (context.Context, func(), error) {
+	q := r.settings.get().Quotas
+	now := time.Now()
+
+	if err := r.checkUsage(ctx, []string{id}, q.Channel, amount, now, "channel"); err != nil {
+		return ctx, func() {}, err
+	}
+	if len(q.Sender) == 0 {
+		return ctx, func() {}, nil
+	}
+
+	ctx, unlock, err := r.lockChannel(ctx, senderLockID(s.SenderPubKey))
+	if err != nil {
+		return ctx, nil, err
+	}
+	recs, err := r.db.GetBySenderPubKey(ctx, s.SenderPubKey)
+	if err != nil {
+		unlock()
+		return ctx, nil, err
+	}
+	var ids []string
+	for _, rec := range recs {
+		ids = append(ids, rec.ID)
+	}
+	if err := r.checkUsage(ctx, ids, q.Sender, amount, now, "sender"); err != nil {
+		unlock()
+		return ctx, nil, err
+	}
+	return ctx, unlock, nil
+}
+
+// checkUsage checks the quotas against the payments of the channels.
+func (r *Receiver) checkUsage(ctx context.Context, ids []string, quotas []Quota, amount int64, now time.Time, scope string) error {
+	if len(quotas) == 0 {
+		return nil
+	}
+	var longest time.Duration
+	for _, q := range quotas {
+		if q.Window > longest {
+			longest = q.Window
+		}
+	}
+
+	type use struct {
+		t      time.Time
+		amount int64
+	}
+	var uses []use
+	for _, id := range ids {
+		payments, err := r.db.ListChannelPayments(ctx, id)
+		if err != nil {
+			return err
+		}
+		for _, sp := range payments {
+			if now.Sub(sp.Time) >= longest {
+				continue
+			}
+			p, err := models.DecodeStoredPayment(sp.Payment)
+			if err != nil {
+				continue
+			}
+			uses = append(uses, use{t: sp.Time, amount: p.Amount})
+		}
+	}
+
+	for _, q := range quotas {
+		n, total := 1, amount
+		for _, u := range uses {
+			if now.Sub(u.t) < q.Window {
+				n++
+				total += u.amount
+			}
+		}
+		if (q.Payments > 0 && n > q.Payments) || (q.Amount > 0 && total > q.Amount) {
+			r.metrics.validationFailures.Inc("quota")
+			return quotaError(scope, q)
+		}
+	}
+	return nil
+}
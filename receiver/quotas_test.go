@@ -0,0 +1,84 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/address"
+	"github.com/luno/moonbeam/chain"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+func TestQuotas(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := &closeBackend{status: &chain.TxStatus{Confirmations: 10, BlockHeight: 100}}
+	db := memory.New()
+	r := NewReceiver(net, ek, heightBackend{cb}, db, NewDirectory("example.com"), keytest.Address(1, net), "")
+	s := openTestChannel(t, r, cb, txid, 1000, 1000)
+
+	target, err := address.Encode(keytest.Address(2, net), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(amount int64) error {
+		payment := keytest.Payment(t, amount, target, 3)
+		req, err := s.GetSendRequest(amount, payment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = r.Send(ctx, *req)
+		if err != nil {
+			s.AbortSend()
+		}
+		return err
+	}
+	isQuota := func(err error) bool {
+		e, ok := err.(ExposableError)
+		return ok && e.Reason() == models.ReasonQuotaExceeded
+	}
+
+	if err := r.SetQuotas(Quotas{Channel: []Quota{{Amount: 100}}}); err == nil {
+		t.Error("Expected error for quota without window")
+	}
+
+	err = r.SetQuotas(Quotas{Channel: []Quota{{Amount: 2500, Window: 24 * time.Hour}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := send(1000); !isQuota(err) {
+		t.Errorf("Expected channel amount quota to be exceeded, got %v", err)
+	}
+
+	err = r.SetQuotas(Quotas{Sender: []Quota{{Payments: 2, Window: time.Minute}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := send(1000); !isQuota(err) {
+		t.Errorf("Expected sender payments quota to be exceeded, got %v", err)
+	}
+
+	err = r.SetQuotas(Quotas{
+		Channel: []Quota{{Amount: 3000, Window: 24 * time.Hour}},
+		Sender:  []Quota{{Payments: 3, Window: time.Minute}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := send(1000); err != nil {
+		t.Errorf("Expected payment within quotas, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+// rebroadcast resubmits a channel's stored closure transaction unless it's
+// already in the mempool or the chain. A closure whose funding has been
+// spent by another transaction isn't resubmitted, since it can never
+// confirm.
+func (r *Receiver) rebroadcast(ctx context.Context, rec storage.Record) error {
+	done := r.startBitcoind(ctx, "getrawtransaction")
+	st, err := r.chain.GetTxStatus(ctx, rec.Closure.TxID)
+	done(err)
+	if err != nil {
+		return err
+	} else if st != nil {
+		return nil
+	}
+
+	s := rec.SharedState
+	_, _, _, err = r.getTxOut(ctx, s.FundingTxID, s.FundingVout, true)
+	if _, ok := err.(ExposableError); ok {
+		r.log.Warn("closure conflicts with another spend of the funding",
+			"channel", rec.ID, "txid", rec.Closure.TxID)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := r.broadcastClosure(ctx, rec); err != nil {
+		return err
+	}
+	r.log.Warn("rebroadcast closure", "channel", rec.ID, "txid", rec.Closure.TxID)
+	return nil
+}
+
+// broadcastClosure submits the channel's stored closure transaction.
+func (r *Receiver) broadcastClosure(ctx context.Context, rec storage.Record) error {
+	var tx wire.MsgTx
+	err := tx.BtcDecode(bytes.NewReader(rec.Closure.RawTx), wire.ProtocolVersion)
+	if err != nil {
+		return err
+	}
+
+	done := r.startBitcoind(ctx, "sendrawtransaction")
+	_, err = r.chain.Broadcast(ctx, &tx)
+	done(err)
+	return err
+}
+
+// rebroadcastAll resubmits the closures of all closing channels that have
+// dropped out of the mempool. Failures for individual channels are logged
+// and retried next time.
+func (r *Receiver) rebroadcastAll(ctx context.Context) error {
+	recs, err := r.db.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range recs {
+		if rec.Frozen || rec.SharedState.Status != channels.StatusClosing {
+			continue
+		}
+		if len(rec.Closure.RawTx) == 0 || rec.Closure.BlockHash != "" {
+			continue
+		}
+		if err := r.rebroadcast(ctx, rec); err != nil {
+			r.log.Error("rebroadcast closure failed", "channel", rec.ID, "err", err)
+		}
+	}
+	return nil
+}
+
+// Rebroadcast resubmits closure transactions that have been evicted from
+// the mempool, for example by a fee spike or a node restart, every interval
+// until ctx is cancelled. Closures are resubmitted until they confirm or the
+// funding is spent by a conflicting transaction.
+func (r *Receiver) Rebroadcast(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := r.rebroadcastAll(ctx); err != nil {
+			r.log.Error("rebroadcast failed", "err", err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/luno/moonbeam/chain"
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+type closeBackend struct {
+	chain.Backend
+	status    *chain.TxStatus
+	funding   *chain.TxOut
+	broadcast []string
+}
+
+func (b *closeBackend) GetTxStatus(ctx context.Context, txid string) (*chain.TxStatus, error) {
+	return b.status, nil
+}
+
+func (b *closeBackend) GetTxOut(ctx context.Context, txid string, vout uint32, includeMempool bool) (*chain.TxOut, error) {
+	return b.funding, nil
+}
+
+func (b *closeBackend) Broadcast(ctx context.Context, tx *wire.MsgTx) (string, error) {
+	txid := tx.TxHash().String()
+	b.broadcast = append(b.broadcast, txid)
+	return txid, nil
+}
+
+// newClosingReceiver returns a receiver with a closing channel whose closure
+// transaction has been stored.
+func newClosingReceiver(t *testing.T, cb chain.Backend) (*Receiver, storage.Record) {
+	ctx := context.Background()
+	const fundingTxID = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+
+	op, _ := fundingOutPoint(channels.SharedState{FundingTxID: fundingTxID})
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&op, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	db := memory.New()
+	r := NewReceiver(&chaincfg.TestNet3Params, nil, cb, db, nil, "", "")
+
+	rec := storage.Record{
+		ID: "closing",
+		SharedState: channels.SharedState{
+			Status:      channels.StatusClosing,
+			FundingTxID: fundingTxID,
+		},
+		Closure: storage.Closure{TxID: tx.TxHash().String(), RawTx: buf.Bytes()},
+	}
+	if err := db.Create(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	return r, rec
+}
+
+func TestRebroadcast(t *testing.T) {
+	ctx := context.Background()
+	cb := &closeBackend{funding: &chain.TxOut{Value: 1000, Confirmations: 10}}
+	r, rec := newClosingReceiver(t, cb)
+	closure := rec.Closure
+
+	// Evicted from the mempool.
+	if err := r.rebroadcastAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(cb.broadcast) != 1 || cb.broadcast[0] != closure.TxID {
+		t.Fatalf("Expected closure to be rebroadcast, got %v", cb.broadcast)
+	}
+
+	// In the mempool.
+	cb.status = &chain.TxStatus{}
+	if err := r.rebroadcastAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Conflicting spend of the funding.
+	cb.status = nil
+	cb.funding = nil
+	if err := r.rebroadcastAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cb.broadcast) != 1 {
+		t.Errorf("Unexpected rebroadcasts %v", cb.broadcast)
+	}
+}
@@ -2,44 +2,79 @@ package receiver
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"strconv"
+	"log/slog"
 	"strings"
+	"sync/atomic"
+	"time"
 
-	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
-	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
-	"github.com/btcsuite/btcrpcclient"
 	"github.com/btcsuite/btcutil/hdkeychain"
 
+	"github.com/luno/moonbeam/chain"
 	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/logging"
 	"github.com/luno/moonbeam/models"
 	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/trace"
 )
 
 type Receiver struct {
 	Net            *chaincfg.Params
 	ek             *hdkeychain.ExtendedKey
-	bc             *btcrpcclient.Client
+	chain          chain.Backend
 	db             storage.Storage
-	dir            *Directory
 	receiverOutput string
+	destinations   *xpubDestinations
+	accounts       map[string]*account
 	authKey        []byte
 	config         channels.ReceiverConfig
+	alerter        Alerter
+	zeroConf       ZeroConfPolicy
+	utilization    UtilizationPolicy
+	settlement     SettlementPolicy
+	fundingMonitor bool
+	settings       settingsStore
+	bus            bus
+	events         events
+	notify         notifier
+	locks          channelLocks
+	instance       string
+	leaseTTL       time.Duration
+	leading        atomic.Bool
+	keys           keyCache
+	keyring        keyring
+	keyGapLimit    int
+	remote         RemoteSigner
+	xpubs          map[string]*hdkeychain.ExtendedKey
+	life           lifecycle
+	tip            tip
+	expiry         expiry
+	archiver       archiver
+	retention      RetentionPolicy
+	maxBlockAge    time.Duration
+	webhooks       []Webhook
+	publisher      Publisher
+	outboxWake     chan struct{}
+	journal        Journal
+	backupKey      []byte
+	paymentHooks   []PaymentHook
+	screener       Screener
+	metrics        *receiverMetrics
+	log            *slog.Logger
 }
 
 func NewReceiver(net *chaincfg.Params,
 	ek *hdkeychain.ExtendedKey,
-	bc *btcrpcclient.Client,
+	cb chain.Backend,
 	db storage.Storage,
 	dir *Directory,
 	destination string,
@@ -48,21 +83,28 @@ func NewReceiver(net *chaincfg.Params,
 	config := channels.DefaultReceiverConfig
 	config.Net = net.Name
 
-	return &Receiver{
+	r := &Receiver{
 		Net:            net,
 		ek:             ek,
-		bc:             bc,
-		db:             db,
-		dir:            dir,
+		chain:          cb,
 		receiverOutput: destination,
 		authKey:        []byte(authKey),
 		config:         config,
-	}
+		alerter:        logAlerter{},
+		notify:         newNotifier(),
+		maxBlockAge:    DefaultMaxBlockAge,
+		log:            slog.Default(),
+	}
+	r.settings.s.Directory = dir
+	r.metrics = newReceiverMetrics(r)
+	r.db = instrumentedStorage{db, r.metrics}
+	r.subscribeAll()
+	return r
 }
 
-func (r *Receiver) Get(txid string, vout uint32) *channels.SharedState {
+func (r *Receiver) Get(ctx context.Context, txid string, vout uint32) *channels.SharedState {
 	id := getChannelID(txid, vout)
-	rec, err := r.db.Get(id)
+	rec, err := r.getRecord(ctx, id)
 	if err != nil {
 		return nil
 	}
@@ -72,13 +114,57 @@ func (r *Receiver) Get(txid string, vout uint32) *channels.SharedState {
 	return &rec.SharedState
 }
 
-func (r *Receiver) List() ([]storage.Record, error) {
-	return r.db.List()
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// List returns a page of up to limit channels matching f, ordered by ID,
+// starting after cursor. It also returns the cursor of the next page, which
+// is empty on the last page. Cursors remain valid as channels are added.
+func (r *Receiver) List(ctx context.Context, f storage.ListFilter, cursor string, limit int) ([]storage.Record, string, error) {
+	if limit <= 0 {
+		limit = defaultListLimit
+	} else if limit > maxListLimit {
+		limit = maxListLimit
+	}
+	if id, ok := accountFromContext(ctx); ok {
+		f.ByAccount, f.Account = true, id
+	}
+	return r.db.ListPage(ctx, f, cursor, limit)
 }
 
-func (r *Receiver) ListPayments(txid string, vout uint32) ([][]byte, error) {
+// SenderPubKey returns the sender's public key of the channel, which signs
+// the sender's requests.
+func (r *Receiver) SenderPubKey(ctx context.Context, txid string, vout uint32) ([]byte, error) {
+	rec, err := r.getRecord(ctx, getChannelID(txid, vout))
+	if err != nil {
+		return nil, err
+	}
+	return rec.SharedState.SenderPubKey, nil
+}
+
+func (r *Receiver) ListPayments(ctx context.Context, txid string, vout uint32) ([][]byte, error) {
+	id := getChannelID(txid, vout)
+	if _, err := r.getRecord(ctx, id); err != nil {
+		return nil, err
+	}
+	return r.db.ListPayments(ctx, id)
+}
+
+// ListPaymentsPage returns a page of up to limit of the channel's payments,
+// in the order they were accepted, starting after cursor, like List.
+func (r *Receiver) ListPaymentsPage(ctx context.Context, txid string, vout uint32, cursor string, limit int) ([]storage.StoredPayment, string, error) {
 	id := getChannelID(txid, vout)
-	return r.db.ListPayments(id)
+	if _, err := r.getRecord(ctx, id); err != nil {
+		return nil, "", err
+	}
+	if limit <= 0 {
+		limit = defaultListLimit
+	} else if limit > maxListLimit {
+		limit = maxListLimit
+	}
+	return r.db.ListPaymentsPage(ctx, storage.PaymentFilter{ChannelID: id}, cursor, limit)
 }
 
 func (r *Receiver) issue(txid string, vout uint32) []byte {
@@ -101,12 +187,8 @@ func (r *Receiver) ValidateToken(txid string, vout uint32, token string) bool {
 	return hmac.Equal(actual, expected)
 }
 
-func (r *Receiver) getKey(n int) (*btcec.PrivateKey, error) {
-	ek, err := r.ek.Child(uint32(n))
-	if err != nil {
-		return nil, err
-	}
-	return ek.ECPrivKey()
+func (r *Receiver) getKey(ctx context.Context, n int) (channels.Signer, error) {
+	return r.getAccountKey(ctx, "", r.currentKeyGen(), n)
 }
 
 func genChannelID() (string, error) {
@@ -122,125 +204,265 @@ func getChannelID(txid string, vout uint32) string {
 	return fmt.Sprintf("%s-%d", strings.ToLower(txid), vout)
 }
 
-func (r *Receiver) Create(req models.CreateRequest) (*models.CreateResponse, error) {
-	// TODO: Periodically rotate privKey by incrementing the child key
-	// counter and return the key index in ReceiverData.
-	const keyPath = 0
-	privKey, err := r.getKey(keyPath)
+func (r *Receiver) Create(ctx context.Context, req models.CreateRequest) (*models.CreateResponse, error) {
+	ctx, span := trace.Start(ctx, "receiver.Create")
+	defer span.End()
+
+	end, err := r.begin()
 	if err != nil {
 		return nil, err
 	}
+	defer end()
 
-	c, err := channels.NewReceiver(r.config, r.receiverOutput, privKey)
+	a, err := r.account(ctx)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.Create(&req)
-	if err != nil {
+	var accountID string
+	if a != nil {
+		accountID = a.ID
+	}
+
+	if err := r.checkAccess(ctx, req.SenderPubKey, req.SenderOutput); err != nil {
+		return nil, err
+	}
+	if err := r.screen(ctx, "create", "", req.SenderOutput); err != nil {
+		return nil, err
+	}
+	if err := r.checkSenderLimit(ctx, req.SenderPubKey); err != nil {
+		return nil, err
+	}
+	if err := r.acceptCreate(ctx, req); err != nil {
+		return nil, err
+	}
+	if err := validateLabels(req.Labels); err != nil {
 		return nil, err
 	}
 
-	resp.ReceiverData = []byte(strconv.Itoa(keyPath))
+	keyGen := r.currentKeyGen()
+	keyPath, err := r.reserveKeyPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, err := r.getAccountKey(ctx, accountID, keyGen, keyPath)
+	if err != nil {
+		return nil, err
+	}
 
-	return resp, nil
-}
+	destination, destIndex, err := r.newDestination(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
 
-func getTxOut(bc *btcrpcclient.Client, txid string, vout uint32) (*wire.TxOut, int, string, error) {
+	c, err := channels.NewReceiverWithSigner(r.config, destination, key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Create(&req)
+	if err != nil {
+		return nil, err
+	}
 
-	txhash, err := chainhash.NewHashFromStr(txid)
+	resp.ReceiverData, err = appendLabels(
+		encodeReceiverData(receiverData{
+			account:   accountID,
+			keyGen:    keyGen,
+			keyPath:   keyPath,
+			destIndex: destIndex,
+		}), req.Labels)
 	if err != nil {
-		return nil, 0, "", err
+		return nil, err
+	}
+	if err := r.watchFunding(resp.FundingAddress, 0); err != nil {
+		return nil, err
+	}
+
+	if r.fundingMonitor {
+		if err := r.addPending(ctx, resp.FundingAddress); err != nil {
+			return nil, err
+		}
 	}
 
-	txout, err := bc.GetTxOut(txhash, vout, false)
+	r.bus.publish(ctx, channelCreated{c.State})
+
+	return resp, nil
+}
+
+func (r *Receiver) getTxOut(ctx context.Context, txid string, vout uint32, includeMempool bool) (*wire.TxOut, int, string, error) {
+	done := r.startBitcoind(ctx, "gettxout")
+	txout, err := r.chain.GetTxOut(ctx, txid, vout, includeMempool)
+	done(err)
 	if err != nil {
 		return nil, 0, "", err
 	}
 	if txout == nil {
-		return nil, 0, "", NewExposableError("confirmed utxo not found")
+		return nil, 0, "", NewExposableError("utxo not found")
 	}
 
 	if txout.Coinbase {
 		return nil, 0, "", NewExposableError("cannot use coinbase utxo")
 	}
 
-	pkscript, err := hex.DecodeString(txout.ScriptPubKey.Hex)
-	if err != nil {
-		return nil, 0, "", err
-	}
-
-	// yuck
-	value := int64(txout.Value * 1e8)
+	wtxout := wire.NewTxOut(txout.Value, txout.PkScript)
 
-	wtxout := wire.NewTxOut(value, pkscript)
+	return wtxout, txout.Confirmations, txout.BestBlock, nil
+}
 
-	return wtxout, int(txout.Confirmations), txout.BestBlock, nil
+func (r *Receiver) getHeight(ctx context.Context, blockhash string) (int64, error) {
+	done := r.startBitcoind(ctx, "getblockheader")
+	height, err := r.chain.GetHeight(ctx, blockhash)
+	done(err)
+	return height, err
 }
 
-func getHeight(bc *btcrpcclient.Client, blockhash string) (int64, error) {
-	bh, err := chainhash.NewHashFromStr(blockhash)
+// getFunding returns a channel's funding output, its confirmations and the
+// current block height. Outputs already found by the funding monitor are
+// used without querying the chain backend.
+func (r *Receiver) getFunding(ctx context.Context, txid string, vout uint32, includeMempool bool) (*wire.TxOut, int, int64, *storage.Pending, error) {
+	p, err := r.pendingFunding(ctx, txid, vout)
 	if err != nil {
-		return 0, err
+		return nil, 0, 0, nil, err
+	} else if p != nil {
+		return wire.NewTxOut(p.Value, p.PkScript), p.Confirmations, p.Height, p, nil
 	}
-	header, err := bc.GetBlockHeaderVerbose(bh)
+
+	txout, conf, blockHash, err := r.getTxOut(ctx, txid, vout, includeMempool)
 	if err != nil {
-		return 0, err
+		return nil, 0, 0, nil, err
 	}
-	return int64(header.Height), nil
+	height, err := r.getHeight(ctx, blockHash)
+	if err != nil {
+		return nil, 0, 0, nil, err
+	}
+	return txout, conf, height, nil, nil
 }
 
-func (r *Receiver) get(id string) (*channels.Receiver, error) {
-	rec, err := r.db.Get(id)
+func (r *Receiver) load(ctx context.Context, id string) (*storage.Record, *channels.Receiver, error) {
+	rec, err := r.getRecord(ctx, id)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if rec.Frozen {
+		return nil, nil, ErrFrozen
 	}
 
-	privKey, err := r.getKey(rec.KeyPath)
+	key, err := r.getAccountKey(ctx, rec.Account, rec.KeyGeneration, rec.KeyPath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	c, err := channels.LoadReceiver(r.config, rec.SharedState, privKey)
-	if err != nil {
-		return nil, err
+	c, err := channels.LoadReceiverWithSigner(r.config, rec.SharedState, key)
+	if _, ok := err.(channels.InvariantError); ok {
+		r.freeze(ctx, id, err)
+		return nil, nil, ErrFrozen
+	} else if err != nil {
+		return nil, nil, err
 	}
 
-	return c, nil
+	return rec, c, nil
+}
+
+func (r *Receiver) get(ctx context.Context, id string) (*channels.Receiver, error) {
+	_, c, err := r.load(ctx, id)
+	return c, err
+}
+
+// getActive is like load but also refuses suspended channels. It is used
+// for operations that accept new payments.
+func (r *Receiver) getActive(ctx context.Context, id string) (*storage.Record, *channels.Receiver, error) {
+	rec, c, err := r.load(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rec.Suspended {
+		return nil, nil, ErrSuspended
+	}
+	return rec, c, nil
 }
 
 func (r *Receiver) getPolicy() policy {
 	return getPolicy(r.Net)
 }
 
-func (r *Receiver) Open(req models.OpenRequest) (*models.OpenResponse, error) {
-	if string(req.ReceiverData) != "0" {
+func (r *Receiver) Open(ctx context.Context, req models.OpenRequest) (*models.OpenResponse, error) {
+	ctx, span := trace.Start(ctx, "receiver.Open")
+	defer span.End()
+
+	end, err := r.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
+	data, labels, err := splitLabels(req.ReceiverData)
+	if err != nil {
+		return nil, err
+	}
+	maxKeyPath, err := r.maxKeyPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rd, err := decodeReceiverData(data, maxKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	accountID := rd.account
+	if id, ok := accountFromContext(ctx); ok && id != accountID {
 		return nil, errors.New("invalid receiverData")
 	}
+	destination, err := r.destination(accountID, rd.destIndex)
+	if err != nil {
+		return nil, err
+	}
 
-	txout, conf, blockHash, err := getTxOut(r.bc, req.TxID, req.Vout)
+	ctx, unlock, err := r.lockChannel(ctx, senderLockID(req.SenderPubKey))
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
 
-	if conf < r.getPolicy().FundingMinConf {
-		return nil, NewExposableError("too few confirmations")
+	if err := r.checkAccess(ctx, req.SenderPubKey, req.SenderOutput); err != nil {
+		return nil, err
+	}
+	err = r.screen(ctx, "open", getChannelID(req.TxID, req.Vout), req.SenderOutput, req.ReceiverOutput)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkSenderLimit(ctx, req.SenderPubKey); err != nil {
+		return nil, err
 	}
 
-	height, err := getHeight(r.bc, blockHash)
+	key, err := r.getAccountKey(ctx, accountID, rd.keyGen, rd.keyPath)
 	if err != nil {
 		return nil, err
 	}
 
-	const keyPath = 0
-	privKey, err := r.getKey(keyPath)
+	c, err := channels.NewReceiverWithSigner(r.config, destination, key)
 	if err != nil {
 		return nil, err
 	}
 
-	c, err := channels.NewReceiver(r.config, r.receiverOutput, privKey)
+	opening := c.State
+	opening.Timeout = req.Timeout
+	opening.SenderPubKey = req.SenderPubKey
+	if err := r.watchOpening(ctx, opening); err != nil {
+		return nil, err
+	}
+
+	zeroConf := r.zeroConf.allowsSender(req.SenderPubKey)
+
+	txout, conf, height, pending, err := r.getFunding(ctx, req.TxID, req.Vout, zeroConf)
 	if err != nil {
 		return nil, err
 	}
+	if err := r.acceptOpen(ctx, req, txout.Value); err != nil {
+		return nil, err
+	}
+
+	unconfirmed := conf < r.getPolicy().FundingMinConf
+	if unconfirmed && !r.zeroConf.allows(req.SenderPubKey, txout.Value) {
+		return nil, NewExposableError("too few confirmations")
+	}
 
 	resp, err := c.Open(txout, &req)
 	if err != nil {
@@ -248,6 +470,9 @@ func (r *Receiver) Open(req models.OpenRequest) (*models.OpenResponse, error) {
 	}
 
 	c.State.BlockHeight = int(height)
+	if unconfirmed {
+		c.State.Status = channels.StatusOpenUnconfirmed
+	}
 	if conf > r.getPolicy().SoftTimeout {
 		c.State.Status = channels.StatusClosing
 	}
@@ -255,107 +480,413 @@ func (r *Receiver) Open(req models.OpenRequest) (*models.OpenResponse, error) {
 	id := getChannelID(req.TxID, req.Vout)
 
 	rec := storage.Record{
-		ID:          id,
-		KeyPath:     keyPath,
-		SharedState: c.State,
+		ID:            id,
+		KeyPath:       rd.keyPath,
+		KeyGeneration: rd.keyGen,
+		SharedState:   c.State,
+		Created:       time.Now(),
+		Account:       accountID,
+		Labels:        labels,
 	}
 
-	if err := r.db.Create(rec); err != nil {
+	if err := r.db.Create(ctx, rec); err != nil {
 		return nil, err
 	}
 
 	resp.AuthToken = r.issueToken(req.TxID, req.Vout)
 
+	if pending != nil {
+		if err := r.db.DeletePending(ctx, pending.FundingAddress); err != nil {
+			r.log.ErrorContext(ctx, "delete pending failed", "channel", id, "err", err)
+		}
+	}
+
+	r.journalOpen(ctx, id, req, txout, c.State.Status)
+	r.notify.add(id, c.State)
+	r.bus.publish(ctx, channelOpened{id, c.State})
+
 	return resp, nil
 }
 
-func (r *Receiver) validate(c *channels.Receiver, payment []byte) (bool, *models.Payment, error) {
-	var p models.Payment
-	if err := json.Unmarshal(payment, &p); err != nil {
-		return false, nil, errors.New("invalid payment")
+const maxNonceLen = 64
+
+// checkReplay rejects payments without a nonce or that don't carry the
+// channel's next payment counter. Every accepted payment increments the
+// count, so a resubmitted payment's counter is one the channel has passed.
+func checkReplay(c *channels.Receiver, p models.Payment) error {
+	if p.Nonce == "" || len(p.Nonce) > maxNonceLen {
+		return NewExposableError("invalid payment nonce")
+	}
+	if p.Counter <= c.State.Count {
+		return ErrDuplicatePayment
 	}
+	if p.Counter != c.State.Count+1 {
+		return ErrWrongPaymentCounter
+	}
+	return nil
+}
 
-	valid, err := c.Validate(p.Amount, payment)
+// validate checks a payment on the channel. It returns the reason the
+// payment is invalid, which is empty if it's valid.
+func (r *Receiver) validate(ctx context.Context, rec *storage.Record, c *channels.Receiver, holdID string, payment []byte) (models.Reason, *models.Payment, error) {
+	p, err := models.DecodePayment(payment)
 	if err != nil {
-		return false, nil, err
+		r.metrics.validationFailures.Inc("decode")
+		return "", nil, NewRejection(models.ReasonInvalidPayment, "invalid payment")
 	}
-	if !valid {
-		return false, nil, nil
+
+	if err := checkReplay(c, *p); err != nil {
+		r.metrics.validationFailures.Inc("replay")
+		return "", nil, err
+	}
+
+	if holdID != "" {
+		err = c.CheckCapture(holdID, p.Amount, payment)
+	} else {
+		err = c.CheckPayment(p.Amount, payment)
+	}
+	if err == channels.ErrNotStatusOpen {
+		return "", nil, rejection(err)
+	} else if err != nil {
+		r.metrics.validationFailures.Inc("invalid")
+		if e, ok := rejection(err).(ExposableError); ok {
+			return e.Reason(), nil, nil
+		}
+		return "", nil, err
 	}
-	has, err := r.dir.HasTarget(p.Target)
+	if r.expiring(c.State) {
+		r.metrics.validationFailures.Inc("expiring")
+		return models.ReasonChannelExpiring, nil, nil
+	}
+	has, err := r.directory(rec.Account).HasTarget(p.Target)
 	if err != nil {
-		return false, nil, err
+		return "", nil, err
 	}
 	if !has {
-		return false, nil, nil
+		r.metrics.validationFailures.Inc("target")
+		return models.ReasonUnknownTarget, nil, nil
 	}
 
-	return true, &p, nil
+	return "", p, nil
+}
+
+// expiring returns whether the receiver is about to close the channel
+// because it's nearing its timeout. It's based on the tip seen by Watch, so
+// it's false until Watch has run.
+func (r *Receiver) expiring(s channels.SharedState) bool {
+	height, _ := r.tip.get()
+	if height == 0 || s.BlockHeight <= 0 {
+		return false
+	}
+	return height >= r.getPolicy().closeHeight(s)
 }
 
-func (r *Receiver) Validate(req models.ValidateRequest) (*models.ValidateResponse, error) {
+func (r *Receiver) Validate(ctx context.Context, req models.ValidateRequest) (*models.ValidateResponse, error) {
+	ctx, span := trace.Start(ctx, "receiver.Validate")
+	defer span.End()
+
 	id := getChannelID(req.TxID, req.Vout)
-	c, err := r.get(id)
+	rec, c, err := r.getActive(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	valid, _, err := r.validate(c, req.Payment)
+	reason, _, err := r.validate(ctx, rec, c, "", req.Payment)
 	if err != nil {
 		return nil, err
 	}
 
-	return &models.ValidateResponse{Valid: valid}, nil
+	return &models.ValidateResponse{Valid: reason == "", Reason: reason}, nil
 }
 
-func (r *Receiver) Send(req models.SendRequest) (*models.SendResponse, error) {
+func (r *Receiver) Send(ctx context.Context, req models.SendRequest) (*models.SendResponse, error) {
+	ctx, span := trace.Start(ctx, "receiver.Send")
+	defer span.End()
+
+	end, err := r.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
 	id := getChannelID(req.TxID, req.Vout)
-	c, err := r.get(id)
+	ctx, unlock, err := r.lockChannel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if req.PaymentID != "" {
+		resp, err := r.getSent(ctx, id, req)
+		if err != nil || resp != nil {
+			return resp, err
+		}
+	}
+
+	rec, c, err := r.getActive(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 	prevState := c.State
 
-	valid, p, err := r.validate(c, req.Payment)
+	reason, p, err := r.validate(ctx, rec, c, req.HoldID, req.Payment)
 	if err != nil {
 		return nil, err
 	}
-	if !valid {
-		return nil, errors.New("invalid payment")
+	if reason != "" {
+		return nil, NewRejection(reason, "invalid payment: "+string(reason))
+	}
+
+	err = r.acceptPayment(ctx, PaymentCheck{
+		ChannelID:    id,
+		SenderPubKey: c.State.SenderPubKey,
+		Payment:      *p,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, unlockSender, err := r.checkQuotas(ctx, id, c.State, p.Amount)
+	if err != nil {
+		return nil, err
+	}
+	defer unlockSender()
+
+	if req.InvoiceID != "" {
+		if err := r.checkInvoice(ctx, rec, req.InvoiceID, *p); err != nil {
+			return nil, err
+		}
 	}
 
 	resp, err := c.Send(p.Amount, &req)
+	if err != nil {
+		return nil, rejection(err)
+	}
+	resp.Receipt, err = c.SignReceipt(id, p.Amount, p.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	hp := HookPayment{
+		ChannelID:    id,
+		Account:      rec.Account,
+		SenderPubKey: c.State.SenderPubKey,
+		Payment:      *p,
+		InvoiceID:    req.InvoiceID,
+		PaymentID:    req.PaymentID,
+		Count:        c.State.Count,
+		Balance:      c.State.Balance,
+		RequestID:    logging.RequestID(ctx),
+	}
+	if err := r.beforePayment(ctx, hp); err != nil {
+		return nil, err
+	}
+
+	// The secret has been checked, so store it before the new state to make
+	// sure we never hold a state whose predecessor we can't penalize.
+	if len(req.RevocationSecret) > 0 {
+		if err := r.db.AddRevocationSecret(ctx, id, req.RevocationSecret); err != nil {
+			return nil, err
+		}
+	}
+
+	// The invoice is marked paid and the target credited in the same commit
+	// as the payment, so that neither can be lost or counted twice.
+	err = r.commit(ctx, prevState, storage.Tx{
+		ChannelID: id,
+		Version:   rec.Version,
+		State:     c.State,
+		Payment:   req.Payment,
+		InvoiceID: req.InvoiceID,
+		Credit:    r.targetCredit(id, p),
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.journalPayment(ctx, id, p.Amount, req, c.State.Count)
+
+	if req.PaymentID != "" {
+		if err := r.putSent(ctx, id, req, resp); err != nil {
+			// The payment has been accepted, so report success. A retry
+			// will fail the replay checks instead of returning this
+			// response.
+			r.log.ErrorContext(ctx, "failed to store sent payment", "channel", id,
+				"paymentID", req.PaymentID, "err", err)
+		}
+	}
+
+	r.afterPayment(ctx, hp)
+
+	return resp, nil
+}
+
+const maxPaymentIDLen = 64
+
+// getSent returns the response to an earlier payment with the same payment
+// ID, or nil if there was none.
+func (r *Receiver) getSent(ctx context.Context, id string, req models.SendRequest) (*models.SendResponse, error) {
+	if len(req.PaymentID) > maxPaymentIDLen {
+		return nil, NewExposableError("invalid payment ID")
+	}
+	if _, err := r.getRecord(ctx, id); err != nil {
+		return nil, err
+	}
+	sent, err := r.db.GetSentPayment(ctx, id, req.PaymentID)
+	if err != nil {
+		return nil, err
+	} else if sent == nil {
+		return nil, nil
+	}
+	if !bytes.Equal(sent.Payment, req.Payment) {
+		return nil, ErrPaymentIDReused
+	}
+
+	var resp models.SendResponse
+	if err := json.Unmarshal(sent.Response, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (r *Receiver) putSent(ctx context.Context, id string, req models.SendRequest, resp *models.SendResponse) error {
+	buf, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return r.db.AddSentPayment(ctx, id, storage.SentPayment{
+		PaymentID: req.PaymentID,
+		Payment:   req.Payment,
+		Response:  buf,
+	})
+}
+
+// update checks that the state transition is legal before storing it over
+// the version of the channel that prev was read at.
+func (r *Receiver) update(ctx context.Context, id string, version int64, prev, next channels.SharedState, payment []byte) error {
+	return r.commit(ctx, prev, storage.Tx{ChannelID: id, Version: version, State: next, Payment: payment})
+}
+
+// commit is like update but stores the other writes of tx in the same
+// commit.
+func (r *Receiver) commit(ctx context.Context, prev channels.SharedState, tx storage.Tx) error {
+	if err := channels.CheckTransition(prev, tx.State); err != nil {
+		r.freeze(ctx, tx.ChannelID, err)
+		return ErrFrozen
+	}
+	err := r.db.Commit(ctx, tx)
+	if err == storage.ErrInvoicePaid {
+		return ErrInvoicePaid
+	} else if err != nil {
+		return err
+	}
+	r.publishTransition(ctx, tx.ChannelID, prev, tx.State, tx.Payment)
+	return nil
+}
+
+func (r *Receiver) Hold(ctx context.Context, req models.HoldRequest) (*models.HoldResponse, error) {
+	ctx, span := trace.Start(ctx, "receiver.Hold")
+	defer span.End()
+
+	end, err := r.begin()
 	if err != nil {
 		return nil, err
 	}
+	defer end()
 
-	newState := c.State
+	id := getChannelID(req.TxID, req.Vout)
+	ctx, unlock, err := r.lockChannel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
-	if err := r.db.Update(id, prevState, newState, req.Payment); err != nil {
+	rec, c, err := r.getActive(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	prevState := c.State
+
+	resp, err := c.Hold(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.update(ctx, id, rec.Version, prevState, c.State, nil); err != nil {
 		return nil, err
 	}
 
 	return resp, nil
 }
 
-func (r *Receiver) Close(req models.CloseRequest) (*models.CloseResponse, error) {
+func (r *Receiver) Release(ctx context.Context, req models.ReleaseRequest) (*models.ReleaseResponse, error) {
+	ctx, span := trace.Start(ctx, "receiver.Release")
+	defer span.End()
+
+	end, err := r.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
 	id := getChannelID(req.TxID, req.Vout)
-	c, err := r.get(id)
+	ctx, unlock, err := r.lockChannel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rec, c, err := r.load(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 	prevState := c.State
 
-	resp, err := c.Close(&req)
+	resp, err := c.Release(&req)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("closeTx: %s", hex.EncodeToString(resp.CloseTx))
+	if err := r.update(ctx, id, rec.Version, prevState, c.State, nil); err != nil {
+		return nil, err
+	}
 
-	newState := c.State
+	return resp, nil
+}
 
-	if err := r.db.Update(id, prevState, newState, nil); err != nil {
+// Close closes the channel at its current balance and broadcasts the closure
+// transaction.
+func (r *Receiver) Close(ctx context.Context, req models.CloseRequest) (*models.CloseResponse, error) {
+	end, err := r.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	return r.close(ctx, req)
+}
+
+func (r *Receiver) close(ctx context.Context, req models.CloseRequest) (*models.CloseResponse, error) {
+	ctx, span := trace.Start(ctx, "receiver.Close")
+	defer span.End()
+
+	id := getChannelID(req.TxID, req.Vout)
+	ctx, unlock, err := r.lockChannel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rec, c, err := r.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	prevState := c.State
+
+	resp, err := c.Close(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.update(ctx, id, rec.Version, prevState, c.State, nil); err != nil {
 		return nil, err
 	}
 
@@ -365,18 +896,35 @@ func (r *Receiver) Close(req models.CloseRequest) (*models.CloseResponse, error)
 		return nil, err
 	}
 
-	txid, err := r.bc.SendRawTransaction(&tx, false)
+	// Record the closure before broadcasting so that it's tracked even if
+	// the broadcast fails.
+	closure := storage.Closure{TxID: tx.TxHash().String(), RawTx: resp.CloseTx}
+	if err := r.db.SetClosure(ctx, id, closure); err != nil {
+		return nil, err
+	}
+
+	done := r.startBitcoind(ctx, "sendrawtransaction")
+	txid, err := r.chain.Broadcast(ctx, &tx)
+	done(err)
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("closeTx txid: %s", txid.String())
+	r.log.InfoContext(ctx, "broadcast close tx", "channel", id,
+		"status", c.State.Status.String(), "txid", txid, "fee", resp.Fee)
+	if req.FeeRate > 0 && resp.Fee == prevState.Fee {
+		r.log.WarnContext(ctx, "no sender signature at requested fee rate, closed at channel fee",
+			"channel", id, "feeRate", req.FeeRate, "fee", resp.Fee)
+	}
 
 	return resp, nil
 }
 
-func (r *Receiver) Status(req models.StatusRequest) (*models.StatusResponse, error) {
+func (r *Receiver) Status(ctx context.Context, req models.StatusRequest) (*models.StatusResponse, error) {
+	ctx, span := trace.Start(ctx, "receiver.Status")
+	defer span.End()
+
 	id := getChannelID(req.TxID, req.Vout)
-	c, err := r.get(id)
+	rec, c, err := r.load(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -385,5 +933,43 @@ func (r *Receiver) Status(req models.StatusRequest) (*models.StatusResponse, err
 		Status:       int(c.State.Status),
 		Balance:      c.State.Balance,
 		PaymentsHash: c.State.PaymentsHash[:],
+		AppData:      c.State.AppData,
+		Held:         c.State.Held(),
+
+		CommitmentSig: c.State.CommitmentSig,
+
+		FundingTxID:   c.State.FundingTxID,
+		FundingVout:   c.State.FundingVout,
+		FundingAmount: c.State.Capacity,
+
+		Available: c.State.Available(),
+		Count:     c.State.Count,
+
+		BlocksRemaining: r.blocksRemaining(ctx, c.State),
+
+		Labels: rec.Labels,
 	}, nil
 }
+
+// blocksRemaining returns the number of blocks until the channel's refund
+// transaction becomes valid, or nil if it isn't known.
+func (r *Receiver) blocksRemaining(ctx context.Context, s channels.SharedState) *int64 {
+	if s.BlockHeight <= 0 {
+		return nil
+	}
+	// Prefer the tip seen by Watch to avoid a chain call on every status.
+	height, _ := r.tip.get()
+	if height == 0 {
+		var err error
+		height, err = r.getHeight(ctx, "")
+		if err != nil {
+			r.log.DebugContext(ctx, "failed to get chain height", "err", err)
+			return nil
+		}
+	}
+	n := int64(s.BlockHeight) + s.Timeout - height
+	if n < 0 {
+		n = 0
+	}
+	return &n
+}
@@ -0,0 +1,293 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+// RecoveredChannel is the outcome of recovering a channel.
+type RecoveredChannel struct {
+	ID       string
+	Status   channels.Status
+	Balance  int64
+	Payments int
+
+	// Err is set if the channel couldn't be recovered, or if it was
+	// recovered only up to a payment missing from the journal.
+	Err error
+}
+
+// Recover rebuilds the channels recorded in journal entries, e.g. after
+// the database was corrupted or lost. It's given the entries of every
+// journal written by the receiver's instances, in any order.
+//
+// Each channel's key is derived again from the receiver's extended keys,
+// its funding output is looked up with the chain backend and its payments
+// are replayed, checking the sender's signature of every state. Channels
+// are stored with their latest journaled status, or as closed if their
+// funding has been spent. Channels that are already stored are skipped, so
+// Recover can be run again. Holds and closure transactions aren't
+// recovered.
+//
+// Recover restores the journaled key generations and then loads them, so
+// it must be called instead of LoadKeyGenerations, with the receiver's
+// keys and settings in place.
+func (r *Receiver) Recover(ctx context.Context, entries []JournalEntry) ([]RecoveredChannel, error) {
+	if err := r.recoverKeyGenerations(ctx, entries); err != nil {
+		return nil, err
+	}
+
+	byChannel := make(map[string][]JournalEntry)
+	var ids []string
+	for _, e := range entries {
+		if e.ChannelID == "" {
+			continue
+		}
+		if _, ok := byChannel[e.ChannelID]; !ok {
+			ids = append(ids, e.ChannelID)
+		}
+		byChannel[e.ChannelID] = append(byChannel[e.ChannelID], e)
+	}
+	sort.Strings(ids)
+
+	var res []RecoveredChannel
+	for _, id := range ids {
+		rc, err := r.recoverChannel(ctx, id, byChannel[id])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rc)
+	}
+
+	// Don't hand out the key paths of journaled channels again.
+	var maxKeyPath int
+	for _, e := range entries {
+		if e.Type != JournalOpen || e.Open == nil {
+			continue
+		}
+		data, _, err := splitLabels(e.Open.ReceiverData)
+		if err != nil {
+			continue
+		}
+		if rd, err := decodeReceiverData(data, math.MaxInt32); err == nil && rd.keyPath > maxKeyPath {
+			maxKeyPath = rd.keyPath
+		}
+	}
+	for {
+		n, err := r.db.GetKeyPathCounter(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if n >= maxKeyPath {
+			break
+		}
+		if _, err := r.db.ReserveKeyPath(ctx, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// recoverKeyGenerations stores the journaled key generations that aren't
+// stored yet, and loads them.
+func (r *Receiver) recoverKeyGenerations(ctx context.Context, entries []JournalEntry) error {
+	xpubs := make(map[int]string)
+	for _, e := range entries {
+		if e.Type != JournalKey {
+			continue
+		}
+		if x, ok := xpubs[e.Generation]; ok && x != e.XPub {
+			return fmt.Errorf("conflicting journal entries for key generation %d", e.Generation)
+		}
+		xpubs[e.Generation] = e.XPub
+	}
+
+	gens, err := r.db.ListKeyGenerations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, g := range gens {
+		if x, ok := xpubs[g.Generation]; ok && x != g.XPub {
+			return fmt.Errorf("stored key generation %d differs from journal", g.Generation)
+		}
+	}
+	for n := len(gens); n < len(xpubs); n++ {
+		xpub, ok := xpubs[n]
+		if !ok {
+			return fmt.Errorf("key generation %d missing from journal", n)
+		}
+		g, err := r.db.AddKeyGeneration(ctx, xpub)
+		if err != nil {
+			return err
+		}
+		if g.Generation != n {
+			return fmt.Errorf("stored key generation %d instead of %d", g.Generation, n)
+		}
+	}
+
+	return r.LoadKeyGenerations(ctx)
+}
+
+// recoverChannel rebuilds and stores a channel. Errors specific to the
+// channel are returned in RecoveredChannel, and others, such as storage
+// failures, as the error.
+func (r *Receiver) recoverChannel(ctx context.Context, id string, entries []JournalEntry) (RecoveredChannel, error) {
+	rc := RecoveredChannel{ID: id}
+
+	_, err := r.db.Get(ctx, id)
+	if err == nil {
+		rc.Err = errors.New("channel already stored")
+		return rc, nil
+	} else if err != storage.ErrNotFound {
+		return rc, err
+	}
+
+	var open *JournalEntry
+	payments := make(map[int]JournalEntry)
+	var last *JournalEntry
+	for i, e := range entries {
+		switch e.Type {
+		case JournalOpen:
+			open = &entries[i]
+		case JournalPayment:
+			payments[e.Count] = e
+		}
+		if e.Type == JournalOpen || e.Type == JournalStatus {
+			if last == nil || !e.Time.Before(last.Time) {
+				last = &entries[i]
+			}
+		}
+	}
+	if open == nil || open.Open == nil {
+		rc.Err = errors.New("channel open missing from journal")
+		return rc, nil
+	}
+	req := *open.Open
+
+	data, labels, err := splitLabels(req.ReceiverData)
+	if err != nil {
+		rc.Err = err
+		return rc, nil
+	}
+	rd, err := decodeReceiverData(data, math.MaxInt32)
+	if err != nil {
+		rc.Err = err
+		return rc, nil
+	}
+	key, err := r.getAccountKey(ctx, rd.account, rd.keyGen, rd.keyPath)
+	if err != nil {
+		rc.Err = err
+		return rc, nil
+	}
+
+	txout, height, spent, err := r.recoverFunding(ctx, req.TxID, req.Vout, open)
+	if err != nil {
+		rc.Err = err
+		return rc, nil
+	}
+
+	// The channel was accepted with the settings at the time, which may
+	// have changed since.
+	config := r.config
+	config.MinPayment = req.MinPayment
+	config.MaxPayment = req.MaxPayment
+	config.AllowRevocable = req.Revocable
+
+	c, err := channels.NewReceiverWithSigner(config, req.ReceiverOutput, key)
+	if err != nil {
+		rc.Err = err
+		return rc, nil
+	}
+	// Open checks that the funding output pays to the channel's script and
+	// that the sender signed the initial state for the key derived again.
+	if _, err := c.Open(txout, &req); err != nil {
+		rc.Err = err
+		return rc, nil
+	}
+	c.State.BlockHeight = int(height)
+
+	rec := storage.Record{
+		ID:            id,
+		KeyPath:       rd.keyPath,
+		KeyGeneration: rd.keyGen,
+		SharedState:   c.State,
+		Created:       open.Time,
+		Account:       rd.account,
+		Labels:        labels,
+	}
+	if err := r.db.Create(ctx, rec); err != nil {
+		return rc, err
+	}
+	// Create stores the first version, and each update the next.
+	version := int64(1)
+
+	for n := 1; n <= len(payments); n++ {
+		e, ok := payments[n]
+		if !ok || e.Send == nil {
+			rc.Err = fmt.Errorf("payment %d missing from journal", n)
+			break
+		}
+		if err := c.Replay(e.Amount, e.Send); err != nil {
+			rc.Err = fmt.Errorf("payment %d: %v", n, err)
+			break
+		}
+		if len(e.Send.RevocationSecret) > 0 {
+			if err := r.db.AddRevocationSecret(ctx, id, e.Send.RevocationSecret); err != nil {
+				return rc, err
+			}
+		}
+		if err := r.db.Update(ctx, id, version, c.State, e.Send.Payment); err != nil {
+			return rc, err
+		}
+		version++
+	}
+
+	status := last.Status
+	if spent {
+		status = channels.StatusClosed
+	}
+	if status != c.State.Status {
+		c.State.Status = status
+		if err := r.db.Update(ctx, id, version, c.State, nil); err != nil {
+			return rc, err
+		}
+	}
+
+	rc.Status = c.State.Status
+	rc.Balance = c.State.Balance
+	rc.Payments = c.State.Count
+	return rc, nil
+}
+
+// recoverFunding finds a channel's funding output with the chain backend.
+// If it's already spent, the journaled output is returned.
+func (r *Receiver) recoverFunding(ctx context.Context, txid string, vout uint32, open *JournalEntry) (*wire.TxOut, int64, bool, error) {
+	status, err := r.chain.GetTxStatus(ctx, txid)
+	if err != nil {
+		return nil, 0, false, err
+	} else if status == nil {
+		return nil, 0, false, errors.New("funding transaction not found")
+	}
+
+	txout, err := r.chain.GetTxOut(ctx, txid, vout, true)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if txout == nil {
+		return wire.NewTxOut(open.Capacity, open.PkScript), status.BlockHeight, true, nil
+	}
+	if txout.Value != open.Capacity || !bytes.Equal(txout.PkScript, open.PkScript) {
+		return nil, 0, false, errors.New("funding output differs from journal")
+	}
+	return wire.NewTxOut(txout.Value, txout.PkScript), status.BlockHeight, false, nil
+}
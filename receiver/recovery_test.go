@@ -0,0 +1,238 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/address"
+	"github.com/luno/moonbeam/chain"
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+type memJournal struct {
+	entries []JournalEntry
+}
+
+func (j *memJournal) Append(ctx context.Context, e JournalEntry) error {
+	j.entries = append(j.entries, e)
+	return nil
+}
+
+type heightBackend struct {
+	*closeBackend
+}
+
+func (b heightBackend) GetHeight(ctx context.Context, hash string) (int64, error) {
+	return 100, nil
+}
+
+// openTestChannel opens a channel funded by txid on r, whose chain backend
+// is cb, and makes payments of the amounts on it. It returns the channel's
+// sender.
+func openTestChannel(t *testing.T, r *Receiver, cb *closeBackend, txid string, amounts ...int64) *channels.Sender {
+	ctx := context.Background()
+	net := r.Net
+
+	s, err := channels.NewSender(channels.DefaultSenderConfig, keytest.Key(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createReq, err := s.GetCreateRequest(keytest.Address(4, net))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reserve a few key paths so that the channel doesn't use path zero.
+	for i := 0; i < 3; i++ {
+		if _, err := r.Create(ctx, *createReq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createResp, err := r.Create(ctx, *createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GotCreateResponse(createResp); err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := btcutil.DecodeAddress(createResp.FundingAddress, net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb.funding = &chain.TxOut{Value: 1000000, PkScript: pkScript, Confirmations: 10}
+
+	openReq, err := s.GetOpenRequest(txid, 0, 1000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openReq.ReceiverData = createResp.ReceiverData
+	openResp, err := r.Open(ctx, *openReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GotOpenResponse(openResp); err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := address.Encode(keytest.Address(2, net), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, amount := range amounts {
+		payment := keytest.Payment(t, amount, target, i+1)
+		sendReq, err := s.GetSendRequest(amount, payment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sendResp, err := r.Send(ctx, *sendReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.GotSendResponse(amount, payment, sendResp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestRecover(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := &closeBackend{status: &chain.TxStatus{Confirmations: 10, BlockHeight: 100}}
+	newReceiver := func() *Receiver {
+		db := memory.New()
+		r := NewReceiver(net, ek, heightBackend{cb}, db, NewDirectory("example.com"), keytest.Address(1, net), "")
+		r.SetKeyGapLimit(10)
+		return r
+	}
+
+	var j memJournal
+	r := newReceiver()
+	r.SetJournal(&j)
+	if err := r.LoadKeyGenerations(ctx); err != nil {
+		t.Fatal(err)
+	}
+	openTestChannel(t, r, cb, txid, 1000, 2500)
+
+	id := getChannelID(txid, 0)
+	want, err := r.db.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Recover into an empty database from the journal written to disk.
+	path := filepath.Join(t.TempDir(), "journal")
+	fj, err := OpenFileJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range j.entries {
+		if err := fj.Append(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fj.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries, err := ReadJournal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r = newReceiver()
+	res, err := r.Recover(ctx, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Err != nil || res[0].Payments != 2 || res[0].Balance != 3500 {
+		t.Fatalf("Unexpected recovery %+v", res)
+	}
+
+	got, err := r.db.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.KeyPath != want.KeyPath || got.KeyPath == 0 ||
+		got.SharedState.Status != channels.StatusOpen ||
+		got.SharedState.BlockHeight != want.SharedState.BlockHeight ||
+		got.SharedState.PaymentsHash != want.SharedState.PaymentsHash ||
+		!bytes.Equal(got.SharedState.SenderSig, want.SharedState.SenderSig) ||
+		!bytes.Equal(got.SharedState.ReceiverPubKey, want.SharedState.ReceiverPubKey) {
+		t.Errorf("Recovered %+v, expected %+v", got, want)
+	}
+	payments, err := r.db.ListPayments(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 2 {
+		t.Errorf("Expected payments to be recovered, got %d", len(payments))
+	}
+	n, err := r.db.GetKeyPathCounter(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n < got.KeyPath {
+		t.Errorf("Expected key path counter past %d, got %d", got.KeyPath, n)
+	}
+
+	// Running it again leaves the channel alone.
+	res, err = r.Recover(ctx, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Err == nil {
+		t.Errorf("Expected stored channel to be skipped, got %+v", res)
+	}
+
+	// A channel whose funding is spent is recovered as closed.
+	cb.funding = nil
+	r = newReceiver()
+	res, err = r.Recover(ctx, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Err != nil || res[0].Status != channels.StatusClosed {
+		t.Errorf("Expected closed channel, got %+v", res)
+	}
+
+	// A gap in the payments stops recovery at the last complete state.
+	var partial []JournalEntry
+	for _, e := range entries {
+		if e.Type != JournalPayment || e.Count != 1 {
+			partial = append(partial, e)
+		}
+	}
+	r = newReceiver()
+	res, err = r.Recover(ctx, partial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Err == nil || res[0].Payments != 0 {
+		t.Errorf("Expected partial recovery, got %+v", res)
+	}
+}
@@ -0,0 +1,90 @@
+package receiver
+
+import (
+	"errors"
+	"sync"
+)
+
+// Settings are the receiver's settings that can be replaced while it's
+// serving requests, e.g. when the operator edits its configuration.
+type Settings struct {
+	// Directory is the default account's set of targets.
+	Directory *Directory
+
+	Funding              FundingPolicy
+	MaxChannelsPerSender int
+	Commission           CommissionPolicy
+
+	// Acceptance is evaluated in addition to Funding. It may be nil.
+	Acceptance AcceptancePolicy
+
+	Quotas Quotas
+}
+
+func (s Settings) validate() error {
+	if s.Directory == nil || s.Directory.domain == "" {
+		return errors.New("directory domain is required")
+	}
+	if s.Funding.Min < 0 || s.Funding.Max < 0 {
+		return errors.New("negative funding bound")
+	}
+	if s.Funding.Max > 0 && s.Funding.Max < s.Funding.Min {
+		return errors.New("maximum funding below minimum")
+	}
+	if s.MaxChannelsPerSender < 0 {
+		return errors.New("negative channels per sender limit")
+	}
+	if !validRate(s.Commission.Rate) {
+		return errors.New("commission rate out of range")
+	}
+	for _, rate := range s.Commission.Targets {
+		if !validRate(rate) {
+			return errors.New("commission rate out of range")
+		}
+	}
+	return s.Quotas.validate()
+}
+
+func validRate(bps int64) bool {
+	return bps >= 0 && bps <= maxBasisPoints
+}
+
+// settingsStore guards the current settings. Calls take a copy when they
+// start so that a reload never changes the settings halfway through one.
+type settingsStore struct {
+	mu sync.RWMutex
+	s  Settings
+}
+
+func (st *settingsStore) get() Settings {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.s
+}
+
+func (st *settingsStore) update(f func(s *Settings)) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	f(&st.s)
+}
+
+// Settings returns the current settings.
+func (r *Receiver) Settings() Settings {
+	return r.settings.get()
+}
+
+// Reload replaces all of the receiver's reloadable settings. The new
+// settings are validated first; if they're invalid, an error is returned
+// and the current settings remain in effect. Calls already in progress
+// finish with the settings they started with.
+func (r *Receiver) Reload(s Settings) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	r.settings.update(func(cur *Settings) { *cur = s })
+	r.log.Info("settings reloaded", "domain", s.Directory.domain,
+		"min_funding", s.Funding.Min, "max_funding", s.Funding.Max,
+		"max_channels_per_sender", s.MaxChannelsPerSender,
+		"commission_bps", s.Commission.Rate)
+	return nil
+}
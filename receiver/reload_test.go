@@ -0,0 +1,54 @@
+package receiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/luno/moonbeam/address"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/models"
+)
+
+func TestReload(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newOpenReceiver(t, &closeBackend{})
+
+	s := Settings{
+		Directory:            NewDirectory("shop.example"),
+		Funding:              FundingPolicy{Min: 1000, Max: 100000},
+		MaxChannelsPerSender: 2,
+		Commission:           CommissionPolicy{Rate: 100},
+	}
+	if err := r.Reload(s); err != nil {
+		t.Fatal(err)
+	}
+	target, err := address.Encode(keytest.Address(2, r.Net), "shop.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := r.directory("").HasTarget(target); !ok {
+		t.Errorf("Expected reloaded directory to accept %s", target)
+	}
+	if err := r.acceptOpen(ctx, models.OpenRequest{}, 500); err == nil {
+		t.Errorf("Expected reloaded funding minimum to apply")
+	}
+
+	invalid := []Settings{
+		{},
+		{Directory: NewDirectory("example.com"), Funding: FundingPolicy{Min: 10, Max: 5}},
+		{Directory: NewDirectory("example.com"), MaxChannelsPerSender: -1},
+		{Directory: NewDirectory("example.com"), Commission: CommissionPolicy{Rate: maxBasisPoints + 1}},
+		{Directory: NewDirectory("example.com"), Commission: CommissionPolicy{Targets: map[string]int64{"t": -1}}},
+	}
+	for i, bad := range invalid {
+		if err := r.Reload(bad); err == nil {
+			t.Errorf("%d: expected invalid settings to be rejected", i)
+		}
+	}
+
+	cur := r.Settings()
+	if cur.Directory != s.Directory || cur.Funding != s.Funding ||
+		cur.MaxChannelsPerSender != 2 || cur.Commission.Rate != 100 {
+		t.Errorf("Expected previous settings to remain, got %+v", cur)
+	}
+}
@@ -0,0 +1,100 @@
+package receiver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/luno/moonbeam/chain"
+	"github.com/luno/moonbeam/storage"
+)
+
+// fundingState is the outcome of re-validating a channel's funding.
+type fundingState int
+
+const (
+	fundingConfirmed fundingState = iota
+
+	// fundingSuspended means the funding no longer has enough
+	// confirmations, so the channel takes no new payments until it does.
+	fundingSuspended
+
+	// fundingFrozen means the funding was spent by a transaction other
+	// than the closure.
+	fundingFrozen
+)
+
+// checkFunding re-validates the funding output of an open channel. A reorg
+// can unconfirm the funding transaction or replace it with a double spend,
+// so channels whose funding no longer has enough confirmations are
+// suspended until it does. Channels whose funding has been spent by a
+// transaction other than the closure are frozen, since payments on them
+// can no longer be claimed. Suspensions by an operator are left in place but
+// not reported, since they only stop new payments and the channel must still
+// be closed before its refund becomes valid.
+func (r *Receiver) checkFunding(ctx context.Context, rec storage.Record) (fundingState, error) {
+	s := rec.SharedState
+
+	var reason string
+	_, conf, _, err := r.getTxOut(ctx, s.FundingTxID, s.FundingVout, true)
+	if _, ok := err.(ExposableError); ok {
+		spender, spent, err := r.fundingSpender(ctx, rec)
+		if err != nil {
+			return fundingConfirmed, err
+		}
+		if spent && spender != rec.Closure.TxID {
+			if spender == "" {
+				spender = "unknown transaction"
+			}
+			r.freeze(ctx, rec.ID, fmt.Errorf("funding spent by %s", spender))
+			return fundingFrozen, nil
+		}
+		reason = "funding output is spent or missing"
+	} else if err != nil {
+		return fundingConfirmed, err
+	} else if conf < r.getPolicy().FundingMinConf {
+		reason = fmt.Sprintf("funding has only %d confirmations", conf)
+	}
+
+	if reason != "" {
+		if rec.Suspended {
+			return fundingSuspended, nil
+		}
+		r.alerter.Alert(rec.ID, "suspending channel: "+reason)
+		return fundingSuspended, r.db.Suspend(ctx, rec.ID, true, reason)
+	}
+
+	if rec.Suspended && !suspendedByOperator(rec) {
+		r.log.Info("resuming channel", "channel", rec.ID, "confirmations", conf)
+		if err := r.db.Suspend(ctx, rec.ID, false, ""); err != nil {
+			return fundingSuspended, err
+		}
+	}
+	return fundingConfirmed, nil
+}
+
+// fundingSpender determines whether a channel's missing funding output has
+// been spent rather than reorged out, and by which transaction if the chain
+// backend can tell. The output is spent if the funding transaction is still
+// confirmed.
+func (r *Receiver) fundingSpender(ctx context.Context, rec storage.Record) (string, bool, error) {
+	s := rec.SharedState
+
+	if f, ok := r.chain.(chain.SpendFinder); ok {
+		done := r.startBitcoind(ctx, "gettxspendingprevout")
+		txid, err := f.GetSpender(ctx, s.FundingTxID, s.FundingVout)
+		done(err)
+		if err != nil {
+			r.log.Debug("find funding spender failed", "channel", rec.ID, "err", err)
+		} else if txid != "" {
+			return txid, true, nil
+		}
+	}
+
+	done := r.startBitcoind(ctx, "getrawtransaction")
+	st, err := r.chain.GetTxStatus(ctx, s.FundingTxID)
+	done(err)
+	if err != nil {
+		return "", false, err
+	}
+	return "", st != nil && st.BlockHash != "", nil
+}
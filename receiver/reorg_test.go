@@ -0,0 +1,329 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/address"
+	"github.com/luno/moonbeam/chain"
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+type spenderBackend struct {
+	*closeBackend
+	spender string
+}
+
+func (b *spenderBackend) GetSpender(ctx context.Context, txid string, vout uint32) (string, error) {
+	return b.spender, nil
+}
+
+func newOpenReceiver(t *testing.T, cb chain.Backend) (*Receiver, storage.Record) {
+	db := memory.New()
+	r := NewReceiver(&chaincfg.TestNet3Params, nil, cb, db, nil, "", "")
+
+	const fundingTxID = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+	rec := storage.Record{
+		ID: getChannelID(fundingTxID, 0),
+		SharedState: channels.SharedState{
+			Status:      channels.StatusOpen,
+			FundingTxID: fundingTxID,
+		},
+	}
+	if err := db.Create(context.Background(), rec); err != nil {
+		t.Fatal(err)
+	}
+	return r, rec
+}
+
+func TestCheckFundingReorged(t *testing.T) {
+	ctx := context.Background()
+	r, rec := newOpenReceiver(t, &closeBackend{})
+
+	funding, err := r.checkFunding(ctx, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.db.Get(ctx, rec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if funding != fundingSuspended || !got.Suspended || got.Frozen {
+		t.Errorf("Expected suspended channel, got %+v", got)
+	}
+}
+
+func TestCheckFundingSpent(t *testing.T) {
+	ctx := context.Background()
+	cb := &spenderBackend{
+		closeBackend: &closeBackend{
+			status: &chain.TxStatus{Confirmations: 10, BlockHash: "blockhash"},
+		},
+		spender: "conflict",
+	}
+	r, rec := newOpenReceiver(t, cb)
+	alerter := &recordingAlerter{}
+	r.SetAlerter(alerter)
+
+	funding, err := r.checkFunding(ctx, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.db.Get(ctx, rec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if funding != fundingFrozen || !got.Frozen {
+		t.Errorf("Expected frozen channel, got %+v", got)
+	}
+	if len(alerter.alerts) != 1 || !strings.Contains(alerter.alerts[0], "conflict") {
+		t.Errorf("Expected alert with conflicting txid, got %v", alerter.alerts)
+	}
+}
+
+func TestCheckFundingOperatorSuspension(t *testing.T) {
+	ctx := context.Background()
+	cb := &closeBackend{funding: &chain.TxOut{Value: 1000, Confirmations: 10}}
+	r, rec := newOpenReceiver(t, cb)
+
+	txid, vout := rec.SharedState.FundingTxID, rec.SharedState.FundingVout
+	if err := r.Suspend(ctx, txid, vout, "investigating"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.db.Get(ctx, rec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Operator suspensions survive sufficiently confirmed funding, but
+	// don't stop the watcher from closing the channel.
+	if funding, err := r.checkFunding(ctx, *got); err != nil || funding != fundingConfirmed {
+		t.Errorf("Expected suspension not to be reported, got %v %v", funding, err)
+	}
+	got, err = r.db.Get(ctx, rec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Suspended {
+		t.Errorf("Expected channel to stay suspended")
+	}
+
+	if err := r.Resume(ctx, txid, vout); err != nil {
+		t.Fatal(err)
+	}
+	got, err = r.db.Get(ctx, rec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Suspended {
+		t.Errorf("Expected channel to be resumed")
+	}
+}
+
+func TestCheckFundingLifecycle(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := &closeBackend{status: &chain.TxStatus{Confirmations: 10, BlockHeight: 100}}
+	r := NewReceiver(net, ek, heightBackend{cb}, memory.New(), NewDirectory("example.com"), keytest.Address(1, net), "")
+	s := openTestChannel(t, r, cb, txid, 1000)
+	funding := *cb.funding
+	id := getChannelID(txid, 0)
+
+	target, err := address.Encode(keytest.Address(2, net), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(counter int) error {
+		payment := keytest.Payment(t, 1000, target, counter)
+		req, err := s.GetSendRequest(1000, payment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := r.Send(ctx, *req)
+		if err != nil {
+			return err
+		}
+		return s.GotSendResponse(1000, payment, resp)
+	}
+
+	tests := []struct {
+		name    string
+		funding *chain.TxOut
+		status  *chain.TxStatus
+		reason  string
+	}{
+		{
+			name:    "unconfirmed after reorg",
+			funding: &chain.TxOut{Value: funding.Value, PkScript: funding.PkScript},
+			reason:  "funding has only 0 confirmations",
+		},
+		{
+			name:   "double spent after reorg",
+			reason: "funding output is spent or missing",
+		},
+	}
+	counter := 2
+	for _, test := range tests {
+		cb.funding, cb.status = test.funding, test.status
+		rec, err := r.db.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if funding, err := r.checkFunding(ctx, *rec); err != nil || funding != fundingSuspended {
+			t.Fatalf("%s: expected suspension, got %v %v", test.name, funding, err)
+		}
+		rec, err = r.db.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rec.Suspended || rec.Frozen || rec.SuspendedReason != test.reason {
+			t.Errorf("%s: unexpected record: suspended %v, frozen %v, reason %q",
+				test.name, rec.Suspended, rec.Frozen, rec.SuspendedReason)
+		}
+		if err := send(counter); err != ErrSuspended {
+			t.Errorf("%s: expected ErrSuspended, got %v", test.name, err)
+		}
+
+		// The channel resumes once the funding confirms again.
+		cb.funding = &funding
+		if funding, err := r.checkFunding(ctx, *rec); err != nil || funding != fundingConfirmed {
+			t.Fatalf("%s: expected resumption, got %v %v", test.name, funding, err)
+		}
+		rec, err = r.db.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Suspended {
+			t.Errorf("%s: expected channel to be resumed", test.name)
+		}
+		if err := send(counter); err != nil {
+			t.Errorf("%s: expected send to succeed after reconfirmation, got %v", test.name, err)
+		}
+		counter++
+	}
+}
+
+// blocksBackend announces a single chain tip to Watch.
+type blocksBackend struct {
+	heightBackend
+	tip int64
+}
+
+func (b *blocksBackend) SubscribeBlocks(ctx context.Context) (<-chan int64, error) {
+	ch := make(chan int64, 1)
+	ch <- b.tip
+	return ch, nil
+}
+
+// newWatchedChannel opens a channel on a receiver whose backend announces
+// the channel's close height to Watch.
+func newWatchedChannel(t *testing.T) (*Receiver, *closeBackend, string, int64) {
+	net := &chaincfg.TestNet3Params
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := &closeBackend{status: &chain.TxStatus{Confirmations: 10, BlockHeight: 100}}
+	bb := &blocksBackend{heightBackend: heightBackend{cb}}
+	r := NewReceiver(net, ek, bb, memory.New(), NewDirectory("example.com"), keytest.Address(1, net), "")
+	openTestChannel(t, r, cb, txid, 1000)
+
+	id := getChannelID(txid, 0)
+	rec, err := r.db.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb.tip = r.getPolicy().closeHeight(rec.SharedState)
+	return r, cb, id, bb.tip
+}
+
+// watchTip runs Watch on r until it has checked the channels at tip.
+func watchTip(t *testing.T, r *Receiver, tip int64) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Watch(ctx, time.Hour)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		h, err := r.db.GetWatchHeight(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h == tip {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected Watch to check height %d", tip)
+}
+
+func TestWatchClosesOperatorSuspended(t *testing.T) {
+	ctx := context.Background()
+	r, cb, id, tip := newWatchedChannel(t)
+
+	rec, err := r.db.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Suspend(ctx, rec.SharedState.FundingTxID, rec.SharedState.FundingVout, "investigating"); err != nil {
+		t.Fatal(err)
+	}
+
+	watchTip(t, r, tip)
+
+	if len(cb.broadcast) != 1 {
+		t.Errorf("Expected closure to be broadcast, got %v", cb.broadcast)
+	}
+	rec, err = r.db.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.SharedState.Status != channels.StatusClosing {
+		t.Errorf("Expected closing channel, got %s", rec.SharedState.Status)
+	}
+}
+
+func TestWatchClosesReorged(t *testing.T) {
+	ctx := context.Background()
+	r, cb, id, tip := newWatchedChannel(t)
+
+	// The funding is back in the mempool after a reorg.
+	cb.funding = &chain.TxOut{Value: cb.funding.Value, PkScript: cb.funding.PkScript}
+
+	watchTip(t, r, tip)
+
+	if len(cb.broadcast) != 1 {
+		t.Errorf("Expected closure to be broadcast, got %v", cb.broadcast)
+	}
+	rec, err := r.db.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Suspended || rec.SharedState.Status != channels.StatusClosing {
+		t.Errorf("Expected suspended closing channel, got suspended %v, status %s",
+			rec.Suspended, rec.SharedState.Status)
+	}
+}
@@ -0,0 +1,153 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+// RetentionPolicy limits how long the receiver keeps data it no longer
+// needs. Channel records and the balances and totals of targets are kept
+// forever.
+type RetentionPolicy struct {
+	// Payments is how long after it closed a channel's payments,
+	// revocation secrets and sent payments are kept. Channels closed before
+	// close times were recorded are aged from their last payment, or opening
+	// if there was none. Zero keeps them forever.
+	Payments time.Duration
+
+	// DryRun makes RunRetention only report what would be purged.
+	DryRun bool
+}
+
+func (p RetentionPolicy) validate() error {
+	if p.Payments < 0 {
+		return errors.New("payment retention can't be negative")
+	}
+	return nil
+}
+
+// SetRetentionPolicy sets the policy applied by Purge.
+func (r *Receiver) SetRetentionPolicy(p RetentionPolicy) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	r.retention = p
+	return nil
+}
+
+var ErrNoRetention = NewExposableError("no retention policy configured")
+
+// PurgeReport is what a purge deleted, or would delete on a dry run.
+type PurgeReport struct {
+	DryRun bool
+
+	// Cutoff is the time before which purged channels closed.
+	Cutoff time.Time
+
+	// Channels and Payments are the numbers of channels and payments
+	// purged, and Bytes the size of the payments.
+	Channels int
+	Payments int
+	Bytes    int64
+}
+
+// Purge deletes the payments, revocation secrets and sent payments of the
+// closed channels that are older than the retention policy allows, and
+// keeps their records as stubs. Archived channels are skipped, since their
+// logs are already gone. On a dry run nothing is deleted and the report is
+// of what would be.
+func (r *Receiver) Purge(ctx context.Context, dryRun bool) (*PurgeReport, error) {
+	if r.retention.Payments == 0 {
+		return nil, ErrNoRetention
+	}
+	rep := &PurgeReport{
+		DryRun: dryRun,
+		Cutoff: time.Now().Add(-r.retention.Payments),
+	}
+
+	f := storage.ListFilter{Status: channels.StatusClosed}
+	var cursor string
+	for {
+		recs, next, err := r.db.ListPage(ctx, f, cursor, archiveBatchSize)
+		if err != nil {
+			return rep, err
+		}
+		for _, rec := range recs {
+			if rec.Archived != "" {
+				continue
+			}
+			if err := r.purgeChannel(ctx, rec, rep); err != nil {
+				return rep, err
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	if dryRun {
+		r.log.Info("purge dry run", "channels", rep.Channels,
+			"payments", rep.Payments, "bytes", rep.Bytes)
+	} else if rep.Channels > 0 {
+		r.log.Info("channels purged", "channels", rep.Channels,
+			"payments", rep.Payments, "bytes", rep.Bytes)
+	}
+	return rep, nil
+}
+
+// purgeChannel purges the channel if it closed before the report's cutoff,
+// and adds it to the report. A channel that changed since
+// it was read is left for a later run.
+func (r *Receiver) purgeChannel(ctx context.Context, rec storage.Record, rep *PurgeReport) error {
+	payments, err := r.db.ListChannelPayments(ctx, rec.ID)
+	if err != nil {
+		return err
+	}
+	last := rec.Created
+	if !rec.Closure.Closed.IsZero() {
+		last = rec.Closure.Closed
+	} else if len(payments) > 0 {
+		last = payments[len(payments)-1].Time
+	}
+	if !last.Before(rep.Cutoff) {
+		return nil
+	}
+	if !rep.DryRun {
+		err := r.db.ArchiveChannel(ctx, rec.ID, rec.Version, storage.Purged)
+		if errors.Is(err, storage.ErrConflict) {
+			r.log.Warn("channel changed while purging", "channel", rec.ID)
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	rep.Channels++
+	rep.Payments += len(payments)
+	for _, p := range payments {
+		rep.Bytes += int64(len(p.Payment))
+	}
+	return nil
+}
+
+// RunRetention runs Purge every interval until ctx is cancelled, as a dry
+// run if the policy says so.
+func (r *Receiver) RunRetention(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if _, err := r.Purge(ctx, r.retention.DryRun); err != nil {
+			r.log.Error("purge failed", "err", err)
+		}
+	}
+}
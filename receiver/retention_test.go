@@ -0,0 +1,176 @@
+package receiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	r, open := newOpenReceiver(t, &closeBackend{})
+
+	if _, err := r.Purge(ctx, true); err != ErrNoRetention {
+		t.Errorf("Expected ErrNoRetention, got %v", err)
+	}
+	if err := r.SetRetentionPolicy(RetentionPolicy{Payments: -time.Hour}); err == nil {
+		t.Errorf("Expected negative retention to be rejected")
+	}
+	if err := r.SetRetentionPolicy(RetentionPolicy{Payments: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+	idle := storage.Record{
+		ID:          getChannelID(txid, 1),
+		SharedState: channels.SharedState{Status: channels.StatusOpen},
+		Created:     time.Now().Add(-2 * time.Hour),
+	}
+	if err := r.db.Create(ctx, idle); err != nil {
+		t.Fatal(err)
+	}
+	closed := idle.SharedState
+	closed.Status = channels.StatusClosed
+	if err := r.db.Update(ctx, idle.ID, 1, closed, []byte("payment")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.db.AddRevocationSecret(ctx, idle.ID, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The payment was just made, so nothing is old enough yet.
+	rep, err := r.Purge(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Channels != 0 {
+		t.Errorf("Expected nothing to purge, got %+v", rep)
+	}
+
+	r.retention.Payments = time.Nanosecond
+	time.Sleep(time.Millisecond)
+
+	// A dry run reports the channel without deleting anything.
+	rep, err = r.Purge(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.DryRun || rep.Channels != 1 || rep.Payments != 1 || rep.Bytes != int64(len("payment")) {
+		t.Errorf("Unexpected dry run report %+v", rep)
+	}
+	payments, err := r.db.ListPayments(ctx, idle.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 {
+		t.Errorf("Expected the dry run to keep the payment, got %q", payments)
+	}
+
+	rep, err = r.Purge(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.DryRun || rep.Channels != 1 {
+		t.Errorf("Unexpected purge report %+v", rep)
+	}
+	for id, purged := range map[string]bool{idle.ID: true, open.ID: false} {
+		rec, err := r.db.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (rec.Archived == storage.Purged) != purged {
+			t.Errorf("Expected %s purged %v, got %q", id, purged, rec.Archived)
+		}
+	}
+	payments, err = r.db.ListPayments(ctx, idle.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets, err := r.db.ListRevocationSecrets(ctx, idle.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 0 || len(secrets) != 0 {
+		t.Errorf("Expected the logs to be deleted, got %q %q", payments, secrets)
+	}
+
+	// The purged channel's record is still there, without payments.
+	dump, err := r.Inspect(ctx, txid, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dump.Record.ID != idle.ID || len(dump.Payments) != 0 {
+		t.Errorf("Unexpected dump of a purged channel %+v", dump)
+	}
+
+	if rep, err := r.Purge(ctx, false); err != nil || rep.Channels != 0 {
+		t.Errorf("Expected nothing left to purge, got %+v %v", rep, err)
+	}
+}
+
+// agedPayments reports the payments of a storage as made age earlier.
+type agedPayments struct {
+	storage.Storage
+	age time.Duration
+}
+
+func (s agedPayments) ListChannelPayments(ctx context.Context, channelID string) ([]storage.StoredPayment, error) {
+	payments, err := s.Storage.ListChannelPayments(ctx, channelID)
+	for i := range payments {
+		payments[i].Time = payments[i].Time.Add(-s.age)
+	}
+	return payments, err
+}
+
+func TestPurgeRecentlyClosed(t *testing.T) {
+	ctx := context.Background()
+	db := agedPayments{Storage: memory.New(), age: 2 * time.Hour}
+	r := NewReceiver(&chaincfg.TestNet3Params, nil, &closeBackend{}, db, nil, "", "")
+	if err := r.SetRetentionPolicy(RetentionPolicy{Payments: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := storage.Record{
+		ID:          "closed",
+		SharedState: channels.SharedState{Status: channels.StatusOpen},
+		Created:     time.Now().Add(-3 * time.Hour),
+	}
+	if err := db.Create(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	closed := rec.SharedState
+	closed.Status = channels.StatusClosed
+	if err := db.Update(ctx, rec.ID, 1, closed, []byte("payment")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetClosure(ctx, rec.ID, storage.Closure{Closed: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The payment is older than the policy allows, but the channel only
+	// just closed.
+	rep, err := r.Purge(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Channels != 0 {
+		t.Errorf("Expected recently closed channel to be kept, got %+v", rep)
+	}
+
+	if err := db.SetClosure(ctx, rec.ID, storage.Closure{Closed: time.Now().Add(-2 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	rep, err = r.Purge(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Channels != 1 || rep.Payments != 1 {
+		t.Errorf("Expected channel closed long ago to be purged, got %+v", rep)
+	}
+}
@@ -0,0 +1,71 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/luno/moonbeam/trace"
+)
+
+// SetAllowRevocable sets whether senders may open revocable channels.
+func (r *Receiver) SetAllowRevocable(allow bool) {
+	r.config.AllowRevocable = allow
+}
+
+// Penalize broadcasts a penalty transaction for a revoked commitment
+// transaction that the sender has broadcast, claiming the sender's output.
+func (r *Receiver) Penalize(ctx context.Context, commitTx *wire.MsgTx) error {
+	ctx, span := trace.Start(ctx, "receiver.Penalize")
+	defer span.End()
+
+	if len(commitTx.TxIn) != 1 {
+		return errors.New("not a commitment tx")
+	}
+	op := commitTx.TxIn[0].PreviousOutPoint
+	id := getChannelID(op.Hash.String(), op.Index)
+
+	rec, err := r.db.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !rec.SharedState.Revocable {
+		return errors.New("channel is not revocable")
+	}
+	key, err := r.getAccountKey(ctx, rec.Account, rec.KeyGeneration, rec.KeyPath)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := r.db.ListRevocationSecrets(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// We don't know which state was broadcast, so try every revoked one.
+	for _, secret := range secrets {
+		rawTx, err := rec.SharedState.GetPenaltyTx(commitTx, secret, key)
+		if err != nil {
+			continue
+		}
+
+		r.alerter.Alert(id, "sender broadcast a revoked commitment")
+
+		var tx wire.MsgTx
+		if err := tx.BtcDecode(bytes.NewReader(rawTx), wire.ProtocolVersion); err != nil {
+			return err
+		}
+		done := r.startBitcoind(ctx, "sendrawtransaction")
+		txid, err := r.chain.Broadcast(ctx, &tx)
+		done(err)
+		if err != nil {
+			return err
+		}
+		r.log.Warn("broadcast penalty tx", "channel", id, "txid", txid)
+		return nil
+	}
+
+	return errors.New("commitment tx is not revoked")
+}
@@ -0,0 +1,135 @@
+package receiver
+
+import (
+	"context"
+	"time"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+// Screener checks addresses against a sanctions or risk screening provider.
+type Screener interface {
+	// Screen returns a hit if the address is flagged, or nil if it isn't.
+	// Errors, e.g. if the provider can't be reached, fail the call being
+	// screened.
+	Screen(ctx context.Context, address string) (*ScreeningHit, error)
+}
+
+// ScreeningHit is an address flagged by a Screener.
+type ScreeningHit struct {
+	Provider string
+	Reason   string
+}
+
+// ScreeningError is returned by Create and Open if an address of the
+// channel is flagged by the Screener. The hit isn't reported to the sender.
+type ScreeningError struct {
+	Address string
+	Hit     ScreeningHit
+}
+
+func (e ScreeningError) Error() string {
+	return "address " + e.Address + " flagged by screening: " + e.Hit.Reason
+}
+
+var ErrNoScreener = NewExposableError("no screener configured")
+
+// SetScreener makes Create screen the sender's output address and Open
+// screen both the sender's and the receiver's. It must be called before the
+// receiver starts serving requests.
+func (r *Receiver) SetScreener(s Screener) {
+	r.screener = s
+}
+
+// screen screens the addresses, recording the first hit and returning it as
+// a ScreeningError.
+func (r *Receiver) screen(ctx context.Context, call, id string, addrs ...string) error {
+	if r.screener == nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if addr == "" {
+			continue
+		}
+		hit, err := r.screener.Screen(ctx, addr)
+		if err != nil {
+			return err
+		} else if hit == nil {
+			continue
+		}
+		r.log.WarnContext(ctx, "address flagged by screening", "call", call, "channel", id,
+			"address", addr, "provider", hit.Provider, "reason", hit.Reason)
+		err = r.db.AddScreeningRecord(ctx, storage.ScreeningRecord{
+			Time:      time.Now(),
+			Call:      call,
+			ChannelID: id,
+			Address:   addr,
+			Provider:  hit.Provider,
+			Reason:    hit.Reason,
+		})
+		if err != nil {
+			return err
+		}
+		return ScreeningError{Address: addr, Hit: *hit}
+	}
+	return nil
+}
+
+// Rescreen screens the addresses of every channel that isn't closed or
+// already frozen again, e.g. after the provider's lists were updated, and
+// freezes the channels that are flagged now. It returns the records of the
+// new hits.
+func (r *Receiver) Rescreen(ctx context.Context) ([]storage.ScreeningRecord, error) {
+	if r.screener == nil {
+		return nil, ErrNoScreener
+	}
+	recs, err := r.db.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []storage.ScreeningRecord{}
+	for _, rec := range recs {
+		s := rec.SharedState
+		if rec.Frozen || s.Status == channels.StatusClosed {
+			continue
+		}
+		for _, addr := range []string{s.SenderOutput, s.ReceiverOutput} {
+			hit, err := r.screener.Screen(ctx, addr)
+			if err != nil {
+				return nil, err
+			} else if hit == nil {
+				continue
+			}
+			sr := storage.ScreeningRecord{
+				Time:      time.Now(),
+				Call:      "rescreen",
+				ChannelID: rec.ID,
+				Address:   addr,
+				Provider:  hit.Provider,
+				Reason:    hit.Reason,
+				Frozen:    true,
+			}
+			if err := r.db.AddScreeningRecord(ctx, sr); err != nil {
+				return nil, err
+			}
+			r.freeze(ctx, rec.ID, ScreeningError{Address: addr, Hit: *hit})
+			res = append(res, sr)
+			break
+		}
+	}
+	return res, nil
+}
+
+// ScreeningRecords returns the audit records of all screening hits.
+func (r *Receiver) ScreeningRecords(ctx context.Context) ([]storage.ScreeningRecord, error) {
+	recs, err := r.db.ListScreeningRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if recs == nil {
+		recs = []storage.ScreeningRecord{}
+	}
+	return recs, nil
+}
@@ -0,0 +1,87 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/chain"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+type testScreener struct {
+	flagged map[string]bool
+}
+
+func (s *testScreener) Screen(ctx context.Context, address string) (*ScreeningHit, error) {
+	if s.flagged[address] {
+		return &ScreeningHit{Provider: "test", Reason: "sanctioned"}, nil
+	}
+	return nil, nil
+}
+
+func TestScreening(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := &closeBackend{status: &chain.TxStatus{Confirmations: 10, BlockHeight: 100}}
+	db := memory.New()
+	r := NewReceiver(net, ek, heightBackend{cb}, db, NewDirectory("example.com"), keytest.Address(1, net), "")
+
+	if _, err := r.Rescreen(ctx); err != ErrNoScreener {
+		t.Errorf("Expected ErrNoScreener, got %v", err)
+	}
+	s := &testScreener{flagged: map[string]bool{keytest.Address(7, net): true}}
+	r.SetScreener(s)
+
+	_, err = r.Create(ctx, models.CreateRequest{
+		SenderPubKey: keytest.PubKey(3),
+		SenderOutput: keytest.Address(7, net),
+	})
+	if e, ok := err.(ScreeningError); !ok || e.Address != keytest.Address(7, net) {
+		t.Errorf("Expected ScreeningError, got %v", err)
+	}
+
+	openTestChannel(t, r, cb, txid)
+
+	// A hit that appears later freezes the channel.
+	s.flagged[keytest.Address(4, net)] = true
+	hits, err := r.Rescreen(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := getChannelID(txid, 0)
+	if len(hits) != 1 || hits[0].ChannelID != id || !hits[0].Frozen {
+		t.Errorf("Unexpected hits %+v", hits)
+	}
+	rec, err := r.db.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Frozen {
+		t.Error("Expected flagged channel to be frozen")
+	}
+
+	recs, err := r.ScreeningRecords(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || recs[0].Call != "create" || recs[1].Call != "rescreen" {
+		t.Errorf("Unexpected audit records %+v", recs)
+	}
+
+	// Frozen channels aren't screened again.
+	if hits, err := r.Rescreen(ctx); err != nil || len(hits) != 0 {
+		t.Errorf("Expected no new hits, got %+v %v", hits, err)
+	}
+}
@@ -0,0 +1,174 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/address"
+	"github.com/luno/moonbeam/chain"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+func TestSentPayment(t *testing.T) {
+	ctx := context.Background()
+	r, rec := newOpenReceiver(t, &closeBackend{})
+
+	req := models.SendRequest{PaymentID: "id", Payment: []byte("payment")}
+	if resp, err := r.getSent(ctx, rec.ID, req); err != nil || resp != nil {
+		t.Fatalf("Expected no sent payment, got %+v %v", resp, err)
+	}
+
+	sent := &models.SendResponse{CommitmentSig: []byte("sig")}
+	if err := r.putSent(ctx, rec.ID, req, sent); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := r.getSent(ctx, rec.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !bytes.Equal(resp.CommitmentSig, sent.CommitmentSig) {
+		t.Errorf("Expected original response, got %+v", resp)
+	}
+
+	req.Payment = []byte("other")
+	if _, err := r.getSent(ctx, rec.ID, req); err != ErrPaymentIDReused {
+		t.Errorf("Expected ErrPaymentIDReused, got %v", err)
+	}
+}
+
+func TestValidateReasons(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := &closeBackend{status: &chain.TxStatus{Confirmations: 10, BlockHeight: 100}}
+	db := memory.New()
+	r := NewReceiver(net, ek, heightBackend{cb}, db, NewDirectory("example.com"), keytest.Address(1, net), "")
+	openTestChannel(t, r, cb, txid, 1000)
+
+	target, err := address.Encode(keytest.Address(2, net), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := address.Encode(keytest.Address(2, net), "example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := func(amount int64, target string, counter int) []byte {
+		return keytest.Payment(t, amount, target, counter)
+	}
+	validate := func(payment []byte) (*models.ValidateResponse, error) {
+		return r.Validate(ctx, models.ValidateRequest{TxID: txid, Vout: 0, Payment: payment})
+	}
+
+	tests := []struct {
+		name    string
+		payment []byte
+		reason  models.Reason
+	}{
+		{"valid", payment(1000, target, 2), ""},
+		{"unknown target", payment(1000, other, 2), models.ReasonUnknownTarget},
+		{"too small", payment(0, target, 2), models.ReasonAmountTooSmall},
+		{"capacity", payment(2000000, target, 2), models.ReasonCapacityExhausted},
+	}
+	for _, test := range tests {
+		resp, err := validate(test.payment)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if resp.Valid != (test.reason == "") || resp.Reason != test.reason {
+			t.Errorf("%s: expected reason %q, got %+v", test.name, test.reason, resp)
+		}
+	}
+
+	_, err = validate(payment(1000, target, 5))
+	if e, ok := err.(ExposableError); !ok || e.Reason() != models.ReasonWrongCounter {
+		t.Errorf("Expected wrong counter rejection, got %v", err)
+	}
+	_, err = validate([]byte("garbage"))
+	if e, ok := err.(ExposableError); !ok || e.Reason() != models.ReasonInvalidPayment {
+		t.Errorf("Expected invalid payment rejection, got %v", err)
+	}
+	_, err = validate([]byte(`{"amount":1000,"target":"` + target + `","nonce":"j","counter":2}`))
+	if e, ok := err.(ExposableError); !ok || e.Reason() != models.ReasonInvalidPayment {
+		t.Errorf("Expected JSON payment rejection, got %v", err)
+	}
+
+	_, err = r.Send(ctx, models.SendRequest{
+		TxID:      txid,
+		Payment:   payment(1000, target, 2),
+		SenderSig: []byte("bad"),
+	})
+	if e, ok := err.(ExposableError); !ok || e.Reason() != models.ReasonInvalidSignature {
+		t.Errorf("Expected invalid signature rejection, got %v", err)
+	}
+
+	_, err = r.Send(ctx, models.SendRequest{
+		TxID:    txid,
+		Payment: payment(2000000, target, 2),
+	})
+	if e, ok := err.(ExposableError); !ok || e.Reason() != models.ReasonCapacityExhausted {
+		t.Errorf("Expected capacity rejection, got %v", err)
+	}
+
+	r.tip.observe(1000000)
+	resp, err := validate(payment(1000, target, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Valid || resp.Reason != models.ReasonChannelExpiring {
+		t.Errorf("Expected expiring channel, got %+v", resp)
+	}
+}
+
+func TestValidateReplay(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := &closeBackend{status: &chain.TxStatus{Confirmations: 10, BlockHeight: 100}}
+	r := NewReceiver(net, ek, heightBackend{cb}, memory.New(), NewDirectory("example.com"), keytest.Address(1, net), "")
+	openTestChannel(t, r, cb, txid, 1000, 1000)
+
+	target, err := address.Encode(keytest.Address(2, net), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	noNonce, err := models.EncodePayment(models.Payment{Amount: 1000, Target: target, Counter: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		payment []byte
+		err     error
+	}{
+		{"next", keytest.Payment(t, 1000, target, 3), nil},
+		{"replayed", keytest.Payment(t, 1000, target, 2), ErrDuplicatePayment},
+		{"replayed first", keytest.Payment(t, 1000, target, 1), ErrDuplicatePayment},
+		{"skipped counter", keytest.Payment(t, 1000, target, 4), ErrWrongPaymentCounter},
+		{"no nonce", noNonce, NewExposableError("invalid payment nonce")},
+	}
+	for _, test := range tests {
+		_, err := r.Validate(ctx, models.ValidateRequest{TxID: txid, Vout: 0, Payment: test.payment})
+		if err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage"
+)
+
+// SettlementPolicy closes open channels to limit the receiver's exposure to
+// their senders. Channels are closed when any rule applies. Zero fields
+// disable their rule. Channels without a balance are never settled.
+type SettlementPolicy struct {
+	// MaxBalance settles channels whose balance reaches it.
+	MaxBalance int64
+
+	// MaxAge settles channels that have been open this long.
+	MaxAge time.Duration
+
+	// Daily settles all channels once a day at DailyAt after midnight UTC.
+	Daily   bool
+	DailyAt time.Duration
+}
+
+func (p SettlementPolicy) validate() error {
+	if p.MaxBalance < 0 || p.MaxAge < 0 {
+		return errors.New("negative settlement limit")
+	}
+	if p.DailyAt < 0 || p.DailyAt >= 24*time.Hour {
+		return errors.New("daily settlement time out of range")
+	}
+	return nil
+}
+
+// lastDaily returns the most recent daily settlement time at or before now.
+func (p SettlementPolicy) lastDaily(now time.Time) time.Time {
+	t := storage.Day(now).Add(p.DailyAt)
+	if t.After(now) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// due returns why the channel should be settled now, or an empty string if
+// it shouldn't.
+func (p SettlementPolicy) due(rec storage.Record, now time.Time) string {
+	s := rec.SharedState
+	if s.Status != channels.StatusOpen || s.Balance <= 0 {
+		return ""
+	}
+	if p.MaxBalance > 0 && s.Balance >= p.MaxBalance {
+		return "balance"
+	}
+	// Channels opened before the time was recorded count as old.
+	if p.MaxAge > 0 && now.Sub(rec.Created) >= p.MaxAge {
+		return "age"
+	}
+	if p.Daily && rec.Created.Before(p.lastDaily(now)) {
+		return "daily"
+	}
+	return ""
+}
+
+// SetSettlementPolicy sets the settlement policy of channels whose account
+// doesn't have its own.
+func (r *Receiver) SetSettlementPolicy(p SettlementPolicy) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	r.settlement = p
+	return nil
+}
+
+// SetAccountSettlementPolicy overrides the settlement policy for the
+// account's channels. It must be called after AddAccount and before the
+// receiver starts serving requests.
+func (r *Receiver) SetAccountSettlementPolicy(accountID string, p SettlementPolicy) error {
+	a, ok := r.accounts[accountID]
+	if !ok {
+		return ErrUnknownAccount
+	}
+	if err := p.validate(); err != nil {
+		return err
+	}
+	a.settlement = &p
+	return nil
+}
+
+func (r *Receiver) settlementPolicy(accountID string) SettlementPolicy {
+	if a, ok := r.accounts[accountID]; ok && a.settlement != nil {
+		return *a.settlement
+	}
+	return r.settlement
+}
+
+// settleEvent settles channels as soon as a payment takes their balance to
+// the policy's maximum, rather than waiting for Watch.
+func (r *Receiver) settleEvent(ctx context.Context, e lifecycleEvent) {
+	p, ok := e.(paymentAccepted)
+	if !ok || !r.settlesOnBalance() {
+		return
+	}
+	rec, err := r.db.Get(ctx, p.id)
+	if err != nil {
+		r.log.Error("settlement check failed", "channel", p.id, "err", err)
+		return
+	}
+	rec.SharedState = p.state
+	if r.settlementPolicy(rec.Account).due(*rec, time.Now()) == "balance" {
+		r.closeInBackground(p.id, p.state, "settling channel", "reason", "balance")
+	}
+}
+
+// settlesOnBalance reports whether any policy has a maximum balance.
+func (r *Receiver) settlesOnBalance() bool {
+	if r.settlement.MaxBalance > 0 {
+		return true
+	}
+	for _, a := range r.accounts {
+		if a.settlement != nil && a.settlement.MaxBalance > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// closeInBackground closes the channel without waiting, since the caller
+// may hold its lock. If the close fails, Watch tries again on its next
+// pass.
+func (r *Receiver) closeInBackground(id string, s channels.SharedState, msg string, args ...interface{}) {
+	end, err := r.begin()
+	if err != nil {
+		return
+	}
+	go func() {
+		defer end()
+		r.log.Info(msg, append([]interface{}{"channel", id,
+			"balance", s.Balance, "capacity", s.Capacity}, args...)...)
+		req := models.CloseRequest{
+			TxID: s.FundingTxID,
+			Vout: s.FundingVout,
+		}
+		if _, err := r.close(context.Background(), req); err != nil {
+			r.log.Error("failed to close channel", "channel", id, "err", err)
+		}
+	}()
+}
@@ -0,0 +1,73 @@
+package receiver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+func TestSettlementDue(t *testing.T) {
+	now := time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	open := func(balance int64, created time.Time) storage.Record {
+		return storage.Record{
+			SharedState: channels.SharedState{Status: channels.StatusOpen, Balance: balance},
+			Created:     created,
+		}
+	}
+
+	tests := []struct {
+		name string
+		p    SettlementPolicy
+		rec  storage.Record
+		exp  string
+	}{
+		{"none", SettlementPolicy{}, open(100, now), ""},
+		{"balance", SettlementPolicy{MaxBalance: 100}, open(100, now), "balance"},
+		{"below balance", SettlementPolicy{MaxBalance: 100}, open(99, now), ""},
+		{"age", SettlementPolicy{MaxAge: time.Hour}, open(1, now.Add(-time.Hour)), "age"},
+		{"young", SettlementPolicy{MaxAge: time.Hour}, open(1, now.Add(-time.Minute)), ""},
+		{"empty", SettlementPolicy{MaxAge: time.Hour}, open(0, now.Add(-2*time.Hour)), ""},
+		{"daily", SettlementPolicy{Daily: true, DailyAt: 2 * time.Hour}, open(1, now.Add(-11*time.Hour)), "daily"},
+		{"opened since daily", SettlementPolicy{Daily: true, DailyAt: 2 * time.Hour}, open(1, now.Add(-9*time.Hour)), ""},
+		{"daily yesterday", SettlementPolicy{Daily: true, DailyAt: 14 * time.Hour}, open(1, now.Add(-23*time.Hour)), "daily"},
+	}
+	for _, test := range tests {
+		if got := test.p.due(test.rec, now); got != test.exp {
+			t.Errorf("%s: expected %q, got %q", test.name, test.exp, got)
+		}
+	}
+
+	closing := open(100, now)
+	closing.SharedState.Status = channels.StatusClosing
+	if got := (SettlementPolicy{MaxBalance: 1}).due(closing, now); got != "" {
+		t.Errorf("Expected closing channel not to be settled, got %q", got)
+	}
+}
+
+func TestSettlementPolicies(t *testing.T) {
+	r, _ := newOpenReceiver(t, &closeBackend{})
+	if err := r.SetSettlementPolicy(SettlementPolicy{Daily: true, DailyAt: 25 * time.Hour}); err == nil {
+		t.Errorf("Expected invalid daily time to be rejected")
+	}
+	if err := r.SetSettlementPolicy(SettlementPolicy{MaxBalance: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetAccountSettlementPolicy("shop", SettlementPolicy{}); err != ErrUnknownAccount {
+		t.Errorf("Expected ErrUnknownAccount, got %v", err)
+	}
+	err := r.AddAccount(Account{ID: "shop", Destination: "dest", Domain: "shop.example", KeyIndex: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetAccountSettlementPolicy("shop", SettlementPolicy{MaxBalance: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if p := r.settlementPolicy("shop"); p.MaxBalance != 10 {
+		t.Errorf("Expected account override, got %+v", p)
+	}
+	if p := r.settlementPolicy(""); p.MaxBalance != 1000 {
+		t.Errorf("Expected default policy, got %+v", p)
+	}
+}
@@ -0,0 +1,112 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrShuttingDown is returned for calls made after Shutdown.
+var ErrShuttingDown = errors.New("receiver is shutting down")
+
+// lifecycle tracks the calls and background work in progress so that
+// Shutdown can wait for them.
+type lifecycle struct {
+	mu       sync.Mutex
+	stopping chan struct{}
+	active   int
+	idle     chan struct{}
+}
+
+func (l *lifecycle) init() {
+	if l.stopping == nil {
+		l.stopping = make(chan struct{})
+	}
+}
+
+// stopped returns a channel that's closed once Shutdown has been called.
+func (l *lifecycle) stopped() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.init()
+	return l.stopping
+}
+
+func (l *lifecycle) isStopping() bool {
+	select {
+	case <-l.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// begin registers work in progress and returns the function to call once
+// it's done. New work is refused after Shutdown unless force is set, which
+// is used for work that an in-progress call depends on.
+func (l *lifecycle) begin(force bool) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.init()
+	if !force && l.isStopping() {
+		return nil, ErrShuttingDown
+	}
+	l.active++
+	return l.end, nil
+}
+
+func (l *lifecycle) end() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	if l.active == 0 && l.idle != nil {
+		close(l.idle)
+		l.idle = nil
+	}
+}
+
+func (l *lifecycle) shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	l.init()
+	if !l.isStopping() {
+		close(l.stopping)
+	}
+	if l.active == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	if l.idle == nil {
+		l.idle = make(chan struct{})
+	}
+	idle := l.idle
+	l.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops the receiver from accepting new calls and waits until the
+// calls in progress, the current pass of Watch and webhook deliveries have
+// finished, or until ctx is done. Webhook deliveries that are still being
+// retried are stored as dead letters. Storage writes are made synchronously,
+// including the height Watch has checked, so nothing else needs flushing.
+//
+// Background work should be stopped by cancelling its context before
+// calling Shutdown.
+func (r *Receiver) Shutdown(ctx context.Context) error {
+	err := r.life.shutdown(ctx)
+	if err != nil {
+		r.log.Warn("shutdown timed out with work in progress", "err", err)
+	}
+	return err
+}
+
+// begin registers a call in progress. It fails once Shutdown has been
+// called.
+func (r *Receiver) begin() (func(), error) {
+	return r.life.begin(false)
+}
@@ -0,0 +1,99 @@
+package receiver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+func TestShutdown(t *testing.T) {
+	ctx := context.Background()
+	r, rec := newOpenReceiver(t, &closeBackend{})
+
+	end, err := r.begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- r.Shutdown(ctx) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned with a call in progress: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	_, err = r.Send(ctx, models.SendRequest{TxID: rec.SharedState.FundingTxID})
+	if err != ErrShuttingDown {
+		t.Errorf("Expected ErrShuttingDown, got %v", err)
+	}
+	if err := r.checkShutdown(); err != ErrShuttingDown {
+		t.Errorf("Expected not ready during shutdown, got %v", err)
+	}
+
+	end()
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected shutdown error %v", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	r, _ := newOpenReceiver(t, &closeBackend{})
+	if _, err := r.begin(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := r.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestShutdownWebhookRetries(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	db := memory.New()
+	r := &Receiver{db: db, alerter: logAlerter{}}
+	r.AddWebhook(Webhook{URL: ts.URL, Secret: "secret"})
+
+	r.notifyWebhooks(Event{Type: EventPayment, Amount: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected delivery to stop retrying, got %v", err)
+	}
+
+	dls, err := db.ListDeadLetters(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 1 || dls[0].Attempts != 1 {
+		t.Errorf("Expected dead letter after one attempt, got %+v", dls)
+	}
+}
+
+func TestWatchHeight(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newOpenReceiver(t, &closeBackend{})
+
+	if h, err := r.db.GetWatchHeight(ctx); err != nil || h != 0 {
+		t.Fatalf("Expected no watch height, got %d %v", h, err)
+	}
+	if err := r.db.SetWatchHeight(ctx, 100); err != nil {
+		t.Fatal(err)
+	}
+	if h, err := r.db.GetWatchHeight(ctx); err != nil || h != 100 {
+		t.Errorf("Expected watch height 100, got %d %v", h, err)
+	}
+}
@@ -0,0 +1,146 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
+)
+
+// RemoteSigner signs with the receiver's channel keys, which are held by a
+// signing daemon such as the one in package signer. Account paths are
+// relative to the receiver's extended key, e.g. "m" or "m/1'", and channel
+// keys are the unhardened children n of account keys.
+type RemoteSigner interface {
+	XPub(ctx context.Context, path string) (*hdkeychain.ExtendedKey, error)
+	Sign(ctx context.Context, accountPath string, n uint32, req channels.SignRequest) (*btcec.Signature, error)
+}
+
+// SetRemoteSigner makes the receiver sign with s instead of its own
+// extended key, which may then be nil. Channel public keys are derived from
+// the accounts' extended public keys, fetched from s, so it must be called
+// after AddAccount.
+func (r *Receiver) SetRemoteSigner(ctx context.Context, s RemoteSigner) error {
+	xpubs := make(map[string]*hdkeychain.ExtendedKey)
+	ids := []string{""}
+	for id := range r.accounts {
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		xpub, err := s.XPub(ctx, r.accountPath(id))
+		if err != nil {
+			return err
+		}
+		if xpub.IsPrivate() {
+			return errors.New("remote signer returned a private key")
+		}
+		if !xpub.IsForNet(r.Net) {
+			return errors.New("remote signer key is for wrong network")
+		}
+		xpubs[id] = xpub
+	}
+	r.remote = s
+	r.xpubs = xpubs
+	return nil
+}
+
+// accountPath returns the path of the account's extended key.
+func (r *Receiver) accountPath(accountID string) string {
+	if a, ok := r.accounts[accountID]; ok {
+		return fmt.Sprintf("m/%d'", a.KeyIndex)
+	}
+	return "m"
+}
+
+// remoteKey is a channel key held by the remote signer. It only signs
+// channels.SignRequests, not digests.
+type remoteKey struct {
+	ctx         context.Context
+	signer      RemoteSigner
+	accountPath string
+	n           uint32
+	pub         *btcec.PublicKey
+}
+
+func (r *Receiver) remoteKey(accountID string, n int) (*remoteKey, error) {
+	xpub, ok := r.xpubs[accountID]
+	if !ok {
+		return nil, ErrUnknownAccount
+	}
+	child, err := xpub.Child(uint32(n))
+	if err != nil {
+		return nil, err
+	}
+	pub, err := child.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	return &remoteKey{
+		signer:      r.remote,
+		accountPath: r.accountPath(accountID),
+		n:           uint32(n),
+		pub:         pub,
+	}, nil
+}
+
+// withContext returns a copy of the key whose signing calls use ctx.
+func (k *remoteKey) withContext(ctx context.Context) *remoteKey {
+	c := *k
+	c.ctx = ctx
+	return &c
+}
+
+func (k *remoteKey) PubKey() *btcec.PublicKey {
+	return k.pub
+}
+
+var errRemoteDigest = errors.New("remote signer doesn't sign digests")
+
+func (k *remoteKey) Sign(hash []byte) (*btcec.Signature, error) {
+	return nil, errRemoteDigest
+}
+
+func (k *remoteKey) SignRequest(req channels.SignRequest) (*btcec.Signature, error) {
+	digest, err := req.Digest(k.pub)
+	if err != nil {
+		return nil, err
+	}
+	ctx := k.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	sig, err := k.signer.Sign(ctx, k.accountPath, k.n, req)
+	if err != nil {
+		return nil, err
+	}
+	// Don't hand out signatures the network would reject.
+	if !sig.Verify(digest, k.pub) {
+		return nil, errors.New("remote signer signed with the wrong key")
+	}
+	return sig, nil
+}
+
+var (
+	_ channels.Signer        = (*remoteKey)(nil)
+	_ channels.RequestSigner = (*remoteKey)(nil)
+)
+
+// checkSigner checks that the remote signer signs with the default
+// account's first channel key.
+func (r *Receiver) checkSigner(ctx context.Context) error {
+	k, err := r.remoteKey("", 0)
+	if err != nil {
+		return err
+	}
+	rc := models.Receipt{
+		ChannelID:      "moonbeam signer health check",
+		ReceiverPubKey: k.pub.SerializeCompressed(),
+	}
+	_, err = k.withContext(ctx).SignRequest(channels.SignRequest{Kind: channels.SignReceipt, Receipt: &rc})
+	return err
+}
@@ -0,0 +1,138 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/chain"
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/signer"
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+func TestRemoteSigner(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(signer.NewServer(ek, "secret"))
+	defer ts.Close()
+
+	newReceiver := func(ek *hdkeychain.ExtendedKey) *Receiver {
+		db := memory.New()
+		r := NewReceiver(net, ek, &closeBackend{}, db, nil, keytest.Address(1, net), "")
+		err := r.AddAccount(Account{ID: "shop", Destination: keytest.Address(2, net), Domain: "shop.example", KeyIndex: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	local := newReceiver(ek)
+	remote := newReceiver(nil)
+	if err := remote.SetRemoteSigner(ctx, signer.NewClient(ts.URL, "secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, account := range []string{"", "shop"} {
+		lk, err := local.getAccountKey(ctx, account, 0, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rk, err := remote.getAccountKey(ctx, account, 0, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !lk.PubKey().IsEqual(rk.PubKey()) {
+			t.Errorf("%q: expected the same public key", account)
+		}
+
+		rc := models.Receipt{ChannelID: "channel", ReceiverPubKey: lk.PubKey().SerializeCompressed()}
+		req := channels.SignRequest{Kind: channels.SignReceipt, Receipt: &rc}
+		digest, err := req.Digest(lk.PubKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		lsig, err := lk.Sign(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsig, err := rk.(channels.RequestSigner).SignRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(lsig.Serialize(), rsig.Serialize()) {
+			t.Errorf("%q: expected the same signature", account)
+		}
+		if _, err := rk.Sign(digest); err == nil {
+			t.Errorf("%q: expected remote key to refuse to sign a digest", account)
+		}
+	}
+
+	if err := remote.checkSigner(ctx); err != nil {
+		t.Errorf("Expected healthy signer, got %v", err)
+	}
+}
+
+func TestRemoteSignerUnauthorized(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(signer.NewServer(ek, "secret"))
+	defer ts.Close()
+
+	r, _ := newOpenReceiver(t, &closeBackend{})
+	if err := r.SetRemoteSigner(ctx, signer.NewClient(ts.URL, "wrong")); err == nil {
+		t.Error("Expected unauthorized signer to fail")
+	}
+}
+
+func TestRemoteSignerChannel(t *testing.T) {
+	ctx := context.Background()
+	net := &chaincfg.TestNet3Params
+	const txid = "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"
+
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(signer.NewServer(ek, "secret"))
+	defer ts.Close()
+
+	cb := &closeBackend{status: &chain.TxStatus{Confirmations: 10, BlockHeight: 100}}
+	r := NewReceiver(net, nil, heightBackend{cb}, memory.New(), NewDirectory("example.com"), keytest.Address(1, net), "")
+	if err := r.SetRemoteSigner(ctx, signer.NewClient(ts.URL, "secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Payments are validated and receipted with the remote key.
+	s := openTestChannel(t, r, cb, txid, 1000, 2500)
+
+	closeReq, err := s.GetCloseRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeResp, err := r.Close(ctx, *closeReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GotCloseResponse(closeResp); err != nil {
+		t.Errorf("Expected closure signed by the remote key, got %v", err)
+	}
+	if len(cb.broadcast) != 1 {
+		t.Errorf("Expected closure to be broadcast, got %v", cb.broadcast)
+	}
+}
@@ -0,0 +1,29 @@
+package receiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/luno/moonbeam/channels"
+)
+
+func TestBlocksRemaining(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newOpenReceiver(t, &closeBackend{})
+
+	s := channels.SharedState{Timeout: 100}
+	if n := r.blocksRemaining(ctx, s); n != nil {
+		t.Errorf("Expected unconfirmed channel to have no expiry, got %d", *n)
+	}
+
+	s.BlockHeight = 1000
+	r.tip.observe(1040)
+	if n := r.blocksRemaining(ctx, s); n == nil || *n != 60 {
+		t.Errorf("Expected 60 blocks remaining, got %v", n)
+	}
+
+	r.tip.observe(1200)
+	if n := r.blocksRemaining(ctx, s); n == nil || *n != 0 {
+		t.Errorf("Expected expired channel, got %v", n)
+	}
+}
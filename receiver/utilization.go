@@ -0,0 +1,46 @@
+package receiver
+
+import (
+	"context"
+
+	"github.com/luno/moonbeam/channels"
+)
+
+// UtilizationPolicy flags channels whose balance has reached a fraction of
+// the channel's usable capacity. Such channels have little headroom left for
+// further payments and should be settled.
+type UtilizationPolicy struct {
+	// Threshold is the fraction of Capacity-Fee at which a channel is
+	// considered exhausted. Zero disables the policy.
+	Threshold float64
+
+	// Close closes exhausted channels instead of only flagging them.
+	Close bool
+}
+
+func (p UtilizationPolicy) exhausted(s channels.SharedState) bool {
+	if p.Threshold <= 0 {
+		return false
+	}
+	usable := s.Capacity - s.Fee
+	return usable > 0 && float64(s.Balance) >= p.Threshold*float64(usable)
+}
+
+// SetUtilizationPolicy sets the policy for exhausted channels.
+func (r *Receiver) SetUtilizationPolicy(p UtilizationPolicy) {
+	r.utilization = p
+}
+
+// autoCloseEvent flags channels that a payment has exhausted and, if the
+// policy says so, closes them in the background.
+func (r *Receiver) autoCloseEvent(ctx context.Context, e lifecycleEvent) {
+	p, ok := e.(paymentAccepted)
+	if !ok || r.utilization.exhausted(p.prev) || !r.utilization.exhausted(p.state) {
+		return
+	}
+	r.publish(stateEvent(EventExhausted, p.id, p.state))
+
+	if r.utilization.Close {
+		r.closeInBackground(p.id, p.state, "closing exhausted channel")
+	}
+}
@@ -1,66 +1,279 @@
 package receiver
 
 import (
-	"log"
+	"context"
 	"time"
 
 	"github.com/luno/moonbeam/channels"
 	"github.com/luno/moonbeam/models"
 	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/trace"
 )
 
-func (r *Receiver) checkChannel(blockCount int64, rec storage.Record) error {
+func (r *Receiver) checkChannel(ctx context.Context, blockCount int64, rec storage.Record) error {
 	s := rec.SharedState
+	if rec.Frozen {
+		return nil
+	}
+	if s.Status == channels.StatusOpenUnconfirmed || s.Status == channels.StatusOpen ||
+		s.Status == channels.StatusClosing {
+		if err := r.watchChannel(s); err != nil {
+			return err
+		}
+	}
+	if s.Status == channels.StatusOpenUnconfirmed {
+		return r.checkUnconfirmed(ctx, blockCount, rec)
+	}
+	if s.Status == channels.StatusClosing {
+		return r.retryClose(ctx, rec)
+	}
 	if s.Status != channels.StatusOpen {
 		return nil
 	}
 
-	timeout := int64(r.getPolicy().SoftTimeout)
-	if timeout < s.Timeout {
-		timeout = s.Timeout / 2
+	funding, err := r.checkFunding(ctx, rec)
+	if err != nil {
+		return err
+	} else if funding == fundingFrozen {
+		return nil
+	}
+
+	closeHeight := r.getPolicy().closeHeight(s)
+	if funding == fundingSuspended {
+		// The channel takes no new payments until its funding is
+		// reconfirmed, but must still be closed before the refund becomes
+		// valid.
+		if blockCount < closeHeight {
+			return nil
+		}
+		r.log.Info("closing suspended channel due to nearing timeout",
+			"channel", rec.ID, "blockCount", blockCount,
+			"expiry", int64(s.BlockHeight)+s.Timeout)
+	} else if r.utilization.Close && r.utilization.exhausted(s) {
+		r.log.Info("closing exhausted channel",
+			"channel", rec.ID, "balance", s.Balance, "capacity", s.Capacity)
+	} else if reason := r.settlementPolicy(rec.Account).due(rec, time.Now()); reason != "" {
+		r.log.Info("settling channel", "channel", rec.ID, "reason", reason,
+			"balance", s.Balance, "created", rec.Created)
+	} else if blockCount >= closeHeight {
+		r.log.Info("closing channel due to nearing timeout",
+			"channel", rec.ID, "status", s.Status.String(), "blockCount", blockCount,
+			"expiry", int64(s.BlockHeight)+s.Timeout)
+	} else {
+		return nil
+	}
+
+	req := models.CloseRequest{
+		TxID: s.FundingTxID,
+		Vout: s.FundingVout,
+	}
+	_, err = r.close(ctx, req)
+	return err
+}
+
+// retryClose rebroadcasts the closure transaction of a closing channel whose
+// funding output hasn't been spent, for example because the previous
+// broadcast failed. Channels whose funding has been spent are marked closed
+// once the spend is final.
+func (r *Receiver) retryClose(ctx context.Context, rec storage.Record) error {
+	s := rec.SharedState
+
+	_, _, _, err := r.getTxOut(ctx, s.FundingTxID, s.FundingVout, false)
+	if _, ok := err.(ExposableError); ok {
+		// Spent in a block, by the closure or the refund.
+		return r.checkClosure(ctx, rec)
+	} else if err != nil {
+		return err
 	}
-	cutoff := int64(s.BlockHeight) + timeout
 
-	if blockCount < cutoff {
+	_, _, _, err = r.getTxOut(ctx, s.FundingTxID, s.FundingVout, true)
+	if _, ok := err.(ExposableError); ok {
+		// The closure is in the mempool.
 		return nil
+	} else if err != nil {
+		return err
 	}
 
-	log.Printf("Closing channel %s due to nearing timeout", rec.ID)
+	if len(rec.Closure.RawTx) > 0 {
+		return r.rebroadcast(ctx, rec)
+	}
+
+	r.log.Warn("rebroadcasting closure", "channel", rec.ID)
 
 	req := models.CloseRequest{
 		TxID: s.FundingTxID,
 		Vout: s.FundingVout,
 	}
-	_, err := r.Close(req)
+	_, err = r.close(ctx, req)
 	return err
 }
 
-func (r *Receiver) watchBlockchain() error {
-	blockCount, err := r.bc.GetBlockCount()
+// checkClosure marks a channel whose funding has been spent in a block as
+// closed once its closure has CloseMinConf confirmations, recording the
+// confirming block. If the funding was spent by another transaction, such
+// as the sender's refund, the channel is closed immediately.
+func (r *Receiver) checkClosure(ctx context.Context, rec storage.Record) error {
+	if rec.Closure.TxID == "" {
+		// Closed before closures were recorded.
+		return r.markClosed(ctx, rec)
+	}
+
+	done := r.startBitcoind(ctx, "getrawtransaction")
+	st, err := r.chain.GetTxStatus(ctx, rec.Closure.TxID)
+	done(err)
+	if err != nil {
+		return err
+	}
+	if st == nil || st.BlockHash == "" {
+		r.alerter.Alert(rec.ID, "funding spent by a transaction other than the closure")
+		return r.markClosed(ctx, rec)
+	}
+	if st.Confirmations < r.getPolicy().CloseMinConf {
+		return nil
+	}
+
+	c := rec.Closure
+	c.BlockHash = st.BlockHash
+	c.Height = st.BlockHeight
+	if err := r.db.SetClosure(ctx, rec.ID, c); err != nil {
+		return err
+	}
+	rec.Closure = c
+	return r.markClosed(ctx, rec)
+}
+
+func (r *Receiver) markClosed(ctx context.Context, rec storage.Record) error {
+	cur, c, err := r.load(ctx, rec.ID)
 	if err != nil {
 		return err
 	}
+	prevState := c.State
+
+	if err := c.CloseMined(); err != nil {
+		return err
+	}
+
+	closure := cur.Closure
+	closure.Closed = time.Now()
+	if err := r.db.SetClosure(ctx, rec.ID, closure); err != nil {
+		return err
+	}
+
+	r.log.Info("channel closed", "channel", rec.ID,
+		"txid", rec.Closure.TxID, "block", rec.Closure.BlockHash)
 
-	recs, err := r.db.List()
+	return r.update(ctx, rec.ID, cur.Version, prevState, c.State, nil)
+}
+
+func (r *Receiver) getBlockCount(ctx context.Context) (int64, error) {
+	done := r.startBitcoind(ctx, "getblockcount")
+	blockCount, err := r.chain.GetHeight(ctx, "")
+	done(err)
+	return blockCount, err
+}
+
+func (r *Receiver) watchBlockchain(ctx context.Context, blockCount int64) error {
+	ctx, span := trace.Start(ctx, "receiver.watchBlockchain")
+	defer span.End()
+
+	recs, err := r.db.List(ctx)
 	if err != nil {
 		return err
 	}
+	r.notify.track(recs)
 
 	var anyErr error
 	for _, rec := range recs {
-		if err := r.checkChannel(blockCount, rec); err != nil {
+		if err := r.checkChannel(ctx, blockCount, rec); err != nil {
 			anyErr = err
 		}
 	}
 
+	if err := r.checkPending(ctx, blockCount); err != nil {
+		anyErr = err
+	}
+
+	// Checked after the channels so that those just closed aren't
+	// reported.
+	if err := r.checkExpiry(ctx, blockCount); err != nil {
+		anyErr = err
+	}
+
 	return anyErr
 }
 
-func (r *Receiver) WatchBlockchainForever() {
+// Watch checks all channels whenever the chain backend reports a new block,
+// until ctx is cancelled. Channels nearing their timeout are closed. Checks
+// that fail are retried every interval. If SubscribeZMQ is running, new
+// blocks and spends of channel funding are handled as soon as bitcoind
+// announces them.
+func (r *Receiver) Watch(ctx context.Context, interval time.Duration) {
+	end, err := r.begin()
+	if err != nil {
+		return
+	}
+	defer end()
+
+	blocks, err := r.chain.SubscribeBlocks(ctx)
+	if err != nil {
+		r.log.Error("subscribe blocks failed", "err", err)
+		return
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	// Resume from the height checked before a restart.
+	lastHeight, err := r.db.GetWatchHeight(ctx)
+	if err != nil {
+		r.log.Error("get watch height failed", "err", err)
+	}
+
+	var blockCount int64
 	for {
-		if err := r.watchBlockchain(); err != nil {
-			log.Printf("watchBlockchain error: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case h, ok := <-blocks:
+			if !ok {
+				return
+			}
+			blockCount = h
+		case <-t.C:
+		case <-r.notify.blocks:
+			h, err := r.getBlockCount(ctx)
+			if err != nil {
+				r.log.Error("get block count failed", "err", err)
+				continue
+			}
+			blockCount = h
+		case sp := <-r.notify.spends:
+			if err := r.checkSpent(ctx, lastHeight, sp); err != nil {
+				r.log.Error("check spent channel failed", "err", err,
+					"channel", sp.id)
+			}
+			continue
+		}
+
+		if blockCount == 0 {
+			continue
+		}
+		r.tip.observe(blockCount)
+		if blockCount == lastHeight {
+			continue
+		}
+		if err := r.watchBlockchain(ctx, blockCount); err != nil {
+			r.log.Error("watch blockchain failed", "err", err,
+				"blockCount", blockCount)
+			continue
+		}
+		lastHeight = blockCount
+		if err := r.db.SetWatchHeight(ctx, lastHeight); err != nil {
+			r.log.Error("set watch height failed", "err", err)
 		}
-		time.Sleep(time.Minute)
 	}
 }
+
+func (r *Receiver) WatchBlockchainForever() {
+	r.Watch(context.Background(), time.Minute)
+}
@@ -0,0 +1,59 @@
+package receiver
+
+import (
+	"context"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
+)
+
+// checkSpent responds to a transaction seen on the network that spends a
+// channel's funding output. Spends other than the receiver's own closure,
+// such as a sender broadcasting the refund early, are alerted on and raced
+// by immediately broadcasting the latest closure. Revoked commitments of
+// revocable channels are penalized.
+func (r *Receiver) checkSpent(ctx context.Context, blockCount int64, sp spend) error {
+	rec, err := r.db.Get(ctx, sp.id)
+	if err != nil {
+		return err
+	} else if rec == nil {
+		return nil
+	}
+
+	txid := sp.tx.TxHash().String()
+	if txid == rec.Closure.TxID {
+		return r.checkChannel(ctx, blockCount, *rec)
+	}
+
+	if rec.SharedState.Revocable {
+		if err := r.Penalize(ctx, sp.tx); err == nil {
+			return nil
+		}
+	}
+
+	r.alerter.Alert(rec.ID, "funding spent by unknown transaction "+txid)
+	if rec.Frozen {
+		return nil
+	}
+
+	switch rec.SharedState.Status {
+	case channels.StatusOpen:
+		r.log.Warn("broadcasting closure to race unknown spend",
+			"channel", rec.ID, "txid", txid)
+		req := models.CloseRequest{
+			TxID: rec.SharedState.FundingTxID,
+			Vout: rec.SharedState.FundingVout,
+		}
+		_, err := r.close(ctx, req)
+		return err
+
+	case channels.StatusClosing:
+		if len(rec.Closure.RawTx) == 0 {
+			return nil
+		}
+		r.log.Warn("rebroadcasting closure to race unknown spend",
+			"channel", rec.ID, "txid", txid)
+		return r.broadcastClosure(ctx, *rec)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/luno/moonbeam/chain"
+)
+
+type recordingAlerter struct {
+	alerts []string
+}
+
+func (a *recordingAlerter) Alert(channelID, msg string) {
+	a.alerts = append(a.alerts, msg)
+}
+
+func TestCheckSpent(t *testing.T) {
+	ctx := context.Background()
+	cb := &closeBackend{
+		status:  &chain.TxStatus{},
+		funding: &chain.TxOut{Value: 1000, Confirmations: 10},
+	}
+	r, rec := newClosingReceiver(t, cb)
+	alerter := &recordingAlerter{}
+	r.SetAlerter(alerter)
+
+	var closure wire.MsgTx
+	if err := closure.Deserialize(bytes.NewReader(rec.Closure.RawTx)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Our own closure is expected.
+	if err := r.checkSpent(ctx, 100, spend{id: rec.ID, tx: &closure}); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerter.alerts) != 0 || len(cb.broadcast) != 0 {
+		t.Errorf("Unexpected response to own closure: %v %v", alerter.alerts, cb.broadcast)
+	}
+
+	// Anything else is raced with the closure.
+	refund := wire.NewMsgTx(2)
+	refund.AddTxOut(wire.NewTxOut(900, []byte{0x52}))
+	if err := r.checkSpent(ctx, 100, spend{id: rec.ID, tx: refund}); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerter.alerts) != 1 {
+		t.Errorf("Expected alert, got %v", alerter.alerts)
+	}
+	if len(cb.broadcast) != 1 || cb.broadcast[0] != rec.Closure.TxID {
+		t.Errorf("Expected closure broadcast, got %v", cb.broadcast)
+	}
+}
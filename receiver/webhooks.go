@@ -0,0 +1,166 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+const (
+	// WebhookSignatureHeader carries the signature of a webhook request.
+	// Its value is of the form t=<unix time>,v1=<hex hmac>, where the hmac
+	// is HMAC-SHA256 keyed with the webhook secret over "<unix time>.<body>".
+	WebhookSignatureHeader = "X-Moonbeam-Signature"
+
+	// WebhookDeliveryHeader carries a unique ID for each event, which stays
+	// the same across retries so that endpoints can ignore duplicates.
+	WebhookDeliveryHeader = "X-Moonbeam-Delivery"
+
+	webhookMaxAttempts = 8
+	webhookTimeout     = 10 * time.Second
+)
+
+// webhookBaseDelay is the delay before the first retry. It doubles with each
+// subsequent attempt.
+var webhookBaseDelay = 5 * time.Second
+
+// Webhook is an endpoint that is sent channel events. If Target is set,
+// only payments to that target are sent.
+type Webhook struct {
+	URL    string
+	Secret string
+	Target string
+}
+
+func (h Webhook) wants(e Event) bool {
+	switch e.Type {
+	case EventPayment:
+		return h.Target == "" || h.Target == e.Target
+	case EventFunded, EventOpened, EventExhausted, EventExpiring, EventClosing, EventClosed:
+		return h.Target == ""
+	default:
+		return false
+	}
+}
+
+// AddWebhook registers an endpoint for event notifications. It must be
+// called before the receiver starts serving requests.
+func (r *Receiver) AddWebhook(h Webhook) {
+	r.webhooks = append(r.webhooks, h)
+}
+
+// SignWebhook returns the signature header value for a webhook body.
+func SignWebhook(secret string, t int64, body []byte) string {
+	ts := strconv.FormatInt(t, 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func genDeliveryID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func (r *Receiver) notifyWebhooks(e Event) {
+	for _, h := range r.webhooks {
+		if h.wants(e) {
+			// Events are published by calls in progress, so deliveries
+			// are tracked even during Shutdown.
+			end, _ := r.life.begin(true)
+			go func(h Webhook) {
+				defer end()
+				r.deliver(h, e)
+			}(h)
+		}
+	}
+}
+
+// deliver posts the event to the webhook, retrying with exponential backoff.
+// Events that can't be delivered are stored as dead letters.
+func (r *Receiver) deliver(h Webhook, e Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		r.alerter.Alert(e.ChannelID, "failed to encode webhook event: "+err.Error())
+		return
+	}
+	id, err := genDeliveryID()
+	if err != nil {
+		r.alerter.Alert(e.ChannelID, "failed to generate delivery id: "+err.Error())
+		return
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	delay := webhookBaseDelay
+
+	var attempts int
+	for attempts < webhookMaxAttempts {
+		if attempts > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.life.stopped():
+				// Don't hold up shutdown with retries.
+				r.storeDeadLetter(h, e, body, attempts, err)
+				return
+			}
+			delay *= 2
+		}
+		attempts++
+
+		err = postWebhook(client, h, id, body)
+		if err == nil {
+			return
+		}
+	}
+
+	r.storeDeadLetter(h, e, body, attempts, err)
+}
+
+func (r *Receiver) storeDeadLetter(h Webhook, e Event, body []byte, attempts int, err error) {
+	dl := storage.DeadLetter{
+		URL:       h.URL,
+		Event:     body,
+		Attempts:  attempts,
+		LastError: err.Error(),
+		Time:      time.Now(),
+	}
+	if err := r.db.AddDeadLetter(context.Background(), dl); err != nil {
+		r.alerter.Alert(e.ChannelID, "failed to store undeliverable webhook: "+err.Error())
+	}
+}
+
+func postWebhook(client *http.Client, h Webhook, id string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookDeliveryHeader, id)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(h.Secret, time.Now().Unix(), body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
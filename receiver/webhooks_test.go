@@ -0,0 +1,115 @@
+package receiver
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/storage/memory"
+)
+
+func TestSignWebhook(t *testing.T) {
+	sig := SignWebhook("secret", 1500000000, []byte(`{"type":"payment"}`))
+	if !strings.HasPrefix(sig, "t=1500000000,v1=") {
+		t.Errorf("Unexpected signature %q", sig)
+	}
+	if sig == SignWebhook("other", 1500000000, []byte(`{"type":"payment"}`)) {
+		t.Errorf("Signature doesn't depend on secret")
+	}
+	if sig == SignWebhook("secret", 1500000001, []byte(`{"type":"payment"}`)) {
+		t.Errorf("Signature doesn't depend on time")
+	}
+}
+
+func TestWebhookWants(t *testing.T) {
+	all := Webhook{URL: "x"}
+	target := Webhook{URL: "x", Target: "alice"}
+
+	if !all.wants(Event{Type: EventOpened}) || target.wants(Event{Type: EventOpened}) {
+		t.Errorf("Unexpected filtering of opened events")
+	}
+	if all.wants(Event{Type: EventCreated}) {
+		t.Errorf("Created events shouldn't be sent")
+	}
+	if !target.wants(Event{Type: EventPayment, Target: "alice"}) {
+		t.Errorf("Expected payment to target")
+	}
+	if target.wants(Event{Type: EventPayment, Target: "bob"}) {
+		t.Errorf("Unexpected payment to other target")
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	defer func(d time.Duration) { webhookBaseDelay = d }(webhookBaseDelay)
+	webhookBaseDelay = time.Millisecond
+
+	var mu sync.Mutex
+	var ids []string
+	fail := 2
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		sig := r.Header.Get(WebhookSignatureHeader)
+		ts, _ := strconv.ParseInt(strings.TrimPrefix(strings.Split(sig, ",")[0], "t="), 10, 64)
+		if sig != SignWebhook("secret", ts, body) {
+			t.Errorf("Invalid signature %q", sig)
+		}
+		ids = append(ids, r.Header.Get(WebhookDeliveryHeader))
+
+		if fail > 0 {
+			fail--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	db := memory.New()
+	r := &Receiver{db: db, alerter: logAlerter{}}
+
+	r.deliver(Webhook{URL: ts.URL, Secret: "secret"}, Event{Type: EventPayment, Amount: 1})
+
+	if len(ids) != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", len(ids))
+	}
+	if ids[0] == "" || ids[0] != ids[1] || ids[1] != ids[2] {
+		t.Errorf("Delivery ID changed between retries: %v", ids)
+	}
+	dls, err := db.ListDeadLetters(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 0 {
+		t.Errorf("Unexpected dead letters: %v", dls)
+	}
+
+	// An endpoint that never succeeds results in a dead letter.
+	fail = webhookMaxAttempts
+	r.deliver(Webhook{URL: ts.URL, Secret: "secret"}, Event{Type: EventClosing})
+
+	dls, err = db.ListDeadLetters(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 1 {
+		t.Fatalf("Expected dead letter, got %d", len(dls))
+	}
+	if dls[0].URL != ts.URL || dls[0].Attempts != webhookMaxAttempts {
+		t.Errorf("Unexpected dead letter: %+v", dls[0])
+	}
+	var e Event
+	if err := json.Unmarshal(dls[0].Event, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != EventClosing {
+		t.Errorf("Unexpected dead letter event: %+v", e)
+	}
+}
@@ -0,0 +1,76 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+// ZeroConfPolicy allows trusted senders to open channels before the funding
+// transaction has enough confirmations. Such channels have status
+// OPEN_UNCONFIRMED until it does, since the funding can still be double
+// spent.
+type ZeroConfPolicy struct {
+	// MaxValue is the largest funding amount accepted without enough
+	// confirmations. Zero disables zero-conf channels.
+	MaxValue int64
+
+	// Senders are the sender pubkeys allowed to open zero-conf channels.
+	Senders [][]byte
+}
+
+func (p ZeroConfPolicy) allowsSender(senderPubKey []byte) bool {
+	if p.MaxValue <= 0 {
+		return false
+	}
+	for _, pk := range p.Senders {
+		if bytes.Equal(pk, senderPubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p ZeroConfPolicy) allows(senderPubKey []byte, value int64) bool {
+	return p.allowsSender(senderPubKey) && value <= p.MaxValue
+}
+
+// SetZeroConfPolicy sets the policy for accepting unconfirmed funding.
+func (r *Receiver) SetZeroConfPolicy(p ZeroConfPolicy) {
+	r.zeroConf = p
+}
+
+// checkUnconfirmed promotes a zero-conf channel to open once its funding has
+// enough confirmations, and freezes it if the funding disappears.
+func (r *Receiver) checkUnconfirmed(ctx context.Context, blockCount int64, rec storage.Record) error {
+	s := rec.SharedState
+
+	txout, conf, _, err := r.getTxOut(ctx, s.FundingTxID, s.FundingVout, true)
+	if _, ok := err.(ExposableError); ok || (err == nil && txout == nil) {
+		r.freeze(ctx, rec.ID, NewExposableError("unconfirmed funding disappeared"))
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if conf < r.getPolicy().FundingMinConf {
+		return nil
+	}
+
+	cur, c, err := r.load(ctx, rec.ID)
+	if err != nil {
+		return err
+	}
+	prevState := c.State
+
+	if err := c.Confirmed(); err != nil {
+		return err
+	}
+	// The refund timeout starts when the funding confirms.
+	c.State.BlockHeight = int(blockCount) - conf + 1
+
+	r.log.Info("funding confirmed", "channel", rec.ID, "confirmations", conf)
+
+	return r.update(ctx, rec.ID, cur.Version, prevState, c.State, nil)
+}
@@ -12,6 +12,10 @@ const MoonbeamPath = "/moonbeam.json"
 
 type DomainReceiver struct {
 	URL string `json:"url"`
+
+	// Uptime is the fraction of time (0 to 1) that the receiver advertises
+	// being available. Zero means unknown.
+	Uptime float64 `json:"uptime,omitempty"`
 }
 
 type Domain struct {
@@ -31,9 +35,18 @@ func NewResolver() *Resolver {
 }
 
 func (r *Resolver) Resolve(domain string) (*url.URL, error) {
+	receivers, err := r.ResolveAll(domain)
+	if err != nil {
+		return nil, err
+	}
+	return url.Parse(receivers[0].URL)
+}
+
+// ResolveAll returns all the receivers advertised for the domain.
+func (r *Resolver) ResolveAll(domain string) ([]DomainReceiver, error) {
 	if u, err := url.Parse(domain); err == nil {
 		if u.Scheme != "" {
-			return u, nil
+			return []DomainReceiver{{URL: u.String()}}, nil
 		}
 	}
 
@@ -65,5 +78,5 @@ func (r *Resolver) Resolve(domain string) (*url.URL, error) {
 		return nil, errors.New("no url found")
 	}
 
-	return url.Parse(d.Receivers[0].URL)
+	return d.Receivers, nil
 }
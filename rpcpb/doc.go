@@ -0,0 +1,5 @@
+// Package rpcpb contains the protobuf definition of the receiver API for
+// gRPC clients and servers. The messages mirror the models package.
+package rpcpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative moonbeam.proto
@@ -0,0 +1,1632 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        v5.29.3
+// source: moonbeam.proto
+
+package rpcpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type CreateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       int32                  `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	Net           string                 `protobuf:"bytes,2,opt,name=net,proto3" json:"net,omitempty"`
+	SenderPubKey  []byte                 `protobuf:"bytes,3,opt,name=sender_pub_key,json=senderPubKey,proto3" json:"sender_pub_key,omitempty"`
+	SenderOutput  string                 `protobuf:"bytes,4,opt,name=sender_output,json=senderOutput,proto3" json:"sender_output,omitempty"`
+	AppData       []byte                 `protobuf:"bytes,5,opt,name=app_data,json=appData,proto3" json:"app_data,omitempty"`
+	Revocable     bool                   `protobuf:"varint,6,opt,name=revocable,proto3" json:"revocable,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,7,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateRequest) Reset() {
+	*x = CreateRequest{}
+	mi := &file_moonbeam_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateRequest) ProtoMessage() {}
+
+func (x *CreateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateRequest.ProtoReflect.Descriptor instead.
+func (*CreateRequest) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CreateRequest) GetVersion() int32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *CreateRequest) GetNet() string {
+	if x != nil {
+		return x.Net
+	}
+	return ""
+}
+
+func (x *CreateRequest) GetSenderPubKey() []byte {
+	if x != nil {
+		return x.SenderPubKey
+	}
+	return nil
+}
+
+func (x *CreateRequest) GetSenderOutput() string {
+	if x != nil {
+		return x.SenderOutput
+	}
+	return ""
+}
+
+func (x *CreateRequest) GetAppData() []byte {
+	if x != nil {
+		return x.AppData
+	}
+	return nil
+}
+
+func (x *CreateRequest) GetRevocable() bool {
+	if x != nil {
+		return x.Revocable
+	}
+	return false
+}
+
+func (x *CreateRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type CreateResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Version        int32                  `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	Net            string                 `protobuf:"bytes,2,opt,name=net,proto3" json:"net,omitempty"`
+	Timeout        int64                  `protobuf:"varint,3,opt,name=timeout,proto3" json:"timeout,omitempty"`
+	Fee            int64                  `protobuf:"varint,4,opt,name=fee,proto3" json:"fee,omitempty"`
+	MinPayment     int64                  `protobuf:"varint,5,opt,name=min_payment,json=minPayment,proto3" json:"min_payment,omitempty"`
+	MaxPayment     int64                  `protobuf:"varint,6,opt,name=max_payment,json=maxPayment,proto3" json:"max_payment,omitempty"`
+	ReceiverPubKey []byte                 `protobuf:"bytes,7,opt,name=receiver_pub_key,json=receiverPubKey,proto3" json:"receiver_pub_key,omitempty"`
+	ReceiverOutput string                 `protobuf:"bytes,8,opt,name=receiver_output,json=receiverOutput,proto3" json:"receiver_output,omitempty"`
+	FundingAddress string                 `protobuf:"bytes,9,opt,name=funding_address,json=fundingAddress,proto3" json:"funding_address,omitempty"`
+	AppData        []byte                 `protobuf:"bytes,10,opt,name=app_data,json=appData,proto3" json:"app_data,omitempty"`
+	Revocable      bool                   `protobuf:"varint,11,opt,name=revocable,proto3" json:"revocable,omitempty"`
+	ReceiverData   []byte                 `protobuf:"bytes,12,opt,name=receiver_data,json=receiverData,proto3" json:"receiver_data,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *CreateResponse) Reset() {
+	*x = CreateResponse{}
+	mi := &file_moonbeam_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateResponse) ProtoMessage() {}
+
+func (x *CreateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateResponse.ProtoReflect.Descriptor instead.
+func (*CreateResponse) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CreateResponse) GetVersion() int32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *CreateResponse) GetNet() string {
+	if x != nil {
+		return x.Net
+	}
+	return ""
+}
+
+func (x *CreateResponse) GetTimeout() int64 {
+	if x != nil {
+		return x.Timeout
+	}
+	return 0
+}
+
+func (x *CreateResponse) GetFee() int64 {
+	if x != nil {
+		return x.Fee
+	}
+	return 0
+}
+
+func (x *CreateResponse) GetMinPayment() int64 {
+	if x != nil {
+		return x.MinPayment
+	}
+	return 0
+}
+
+func (x *CreateResponse) GetMaxPayment() int64 {
+	if x != nil {
+		return x.MaxPayment
+	}
+	return 0
+}
+
+func (x *CreateResponse) GetReceiverPubKey() []byte {
+	if x != nil {
+		return x.ReceiverPubKey
+	}
+	return nil
+}
+
+func (x *CreateResponse) GetReceiverOutput() string {
+	if x != nil {
+		return x.ReceiverOutput
+	}
+	return ""
+}
+
+func (x *CreateResponse) GetFundingAddress() string {
+	if x != nil {
+		return x.FundingAddress
+	}
+	return ""
+}
+
+func (x *CreateResponse) GetAppData() []byte {
+	if x != nil {
+		return x.AppData
+	}
+	return nil
+}
+
+func (x *CreateResponse) GetRevocable() bool {
+	if x != nil {
+		return x.Revocable
+	}
+	return false
+}
+
+func (x *CreateResponse) GetReceiverData() []byte {
+	if x != nil {
+		return x.ReceiverData
+	}
+	return nil
+}
+
+type OpenRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Txid           string                 `protobuf:"bytes,1,opt,name=txid,proto3" json:"txid,omitempty"`
+	Vout           uint32                 `protobuf:"varint,2,opt,name=vout,proto3" json:"vout,omitempty"`
+	ReceiverData   []byte                 `protobuf:"bytes,3,opt,name=receiver_data,json=receiverData,proto3" json:"receiver_data,omitempty"`
+	Version        int32                  `protobuf:"varint,4,opt,name=version,proto3" json:"version,omitempty"`
+	Net            string                 `protobuf:"bytes,5,opt,name=net,proto3" json:"net,omitempty"`
+	Timeout        int64                  `protobuf:"varint,6,opt,name=timeout,proto3" json:"timeout,omitempty"`
+	Fee            int64                  `protobuf:"varint,7,opt,name=fee,proto3" json:"fee,omitempty"`
+	MinPayment     int64                  `protobuf:"varint,8,opt,name=min_payment,json=minPayment,proto3" json:"min_payment,omitempty"`
+	MaxPayment     int64                  `protobuf:"varint,9,opt,name=max_payment,json=maxPayment,proto3" json:"max_payment,omitempty"`
+	SenderPubKey   []byte                 `protobuf:"bytes,10,opt,name=sender_pub_key,json=senderPubKey,proto3" json:"sender_pub_key,omitempty"`
+	SenderOutput   string                 `protobuf:"bytes,11,opt,name=sender_output,json=senderOutput,proto3" json:"sender_output,omitempty"`
+	ReceiverPubKey []byte                 `protobuf:"bytes,12,opt,name=receiver_pub_key,json=receiverPubKey,proto3" json:"receiver_pub_key,omitempty"`
+	ReceiverOutput string                 `protobuf:"bytes,13,opt,name=receiver_output,json=receiverOutput,proto3" json:"receiver_output,omitempty"`
+	AppData        []byte                 `protobuf:"bytes,14,opt,name=app_data,json=appData,proto3" json:"app_data,omitempty"`
+	Revocable      bool                   `protobuf:"varint,15,opt,name=revocable,proto3" json:"revocable,omitempty"`
+	RevocationHash []byte                 `protobuf:"bytes,16,opt,name=revocation_hash,json=revocationHash,proto3" json:"revocation_hash,omitempty"`
+	SenderSig      []byte                 `protobuf:"bytes,17,opt,name=sender_sig,json=senderSig,proto3" json:"sender_sig,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *OpenRequest) Reset() {
+	*x = OpenRequest{}
+	mi := &file_moonbeam_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OpenRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OpenRequest) ProtoMessage() {}
+
+func (x *OpenRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OpenRequest.ProtoReflect.Descriptor instead.
+func (*OpenRequest) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *OpenRequest) GetTxid() string {
+	if x != nil {
+		return x.Txid
+	}
+	return ""
+}
+
+func (x *OpenRequest) GetVout() uint32 {
+	if x != nil {
+		return x.Vout
+	}
+	return 0
+}
+
+func (x *OpenRequest) GetReceiverData() []byte {
+	if x != nil {
+		return x.ReceiverData
+	}
+	return nil
+}
+
+func (x *OpenRequest) GetVersion() int32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *OpenRequest) GetNet() string {
+	if x != nil {
+		return x.Net
+	}
+	return ""
+}
+
+func (x *OpenRequest) GetTimeout() int64 {
+	if x != nil {
+		return x.Timeout
+	}
+	return 0
+}
+
+func (x *OpenRequest) GetFee() int64 {
+	if x != nil {
+		return x.Fee
+	}
+	return 0
+}
+
+func (x *OpenRequest) GetMinPayment() int64 {
+	if x != nil {
+		return x.MinPayment
+	}
+	return 0
+}
+
+func (x *OpenRequest) GetMaxPayment() int64 {
+	if x != nil {
+		return x.MaxPayment
+	}
+	return 0
+}
+
+func (x *OpenRequest) GetSenderPubKey() []byte {
+	if x != nil {
+		return x.SenderPubKey
+	}
+	return nil
+}
+
+func (x *OpenRequest) GetSenderOutput() string {
+	if x != nil {
+		return x.SenderOutput
+	}
+	return ""
+}
+
+func (x *OpenRequest) GetReceiverPubKey() []byte {
+	if x != nil {
+		return x.ReceiverPubKey
+	}
+	return nil
+}
+
+func (x *OpenRequest) GetReceiverOutput() string {
+	if x != nil {
+		return x.ReceiverOutput
+	}
+	return ""
+}
+
+func (x *OpenRequest) GetAppData() []byte {
+	if x != nil {
+		return x.AppData
+	}
+	return nil
+}
+
+func (x *OpenRequest) GetRevocable() bool {
+	if x != nil {
+		return x.Revocable
+	}
+	return false
+}
+
+func (x *OpenRequest) GetRevocationHash() []byte {
+	if x != nil {
+		return x.RevocationHash
+	}
+	return nil
+}
+
+func (x *OpenRequest) GetSenderSig() []byte {
+	if x != nil {
+		return x.SenderSig
+	}
+	return nil
+}
+
+type OpenResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AuthToken     string                 `protobuf:"bytes,1,opt,name=auth_token,json=authToken,proto3" json:"auth_token,omitempty"`
+	CommitmentSig []byte                 `protobuf:"bytes,2,opt,name=commitment_sig,json=commitmentSig,proto3" json:"commitment_sig,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OpenResponse) Reset() {
+	*x = OpenResponse{}
+	mi := &file_moonbeam_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OpenResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OpenResponse) ProtoMessage() {}
+
+func (x *OpenResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OpenResponse.ProtoReflect.Descriptor instead.
+func (*OpenResponse) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *OpenResponse) GetAuthToken() string {
+	if x != nil {
+		return x.AuthToken
+	}
+	return ""
+}
+
+func (x *OpenResponse) GetCommitmentSig() []byte {
+	if x != nil {
+		return x.CommitmentSig
+	}
+	return nil
+}
+
+type ValidateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Txid          string                 `protobuf:"bytes,1,opt,name=txid,proto3" json:"txid,omitempty"`
+	Vout          uint32                 `protobuf:"varint,2,opt,name=vout,proto3" json:"vout,omitempty"`
+	Payment       []byte                 `protobuf:"bytes,3,opt,name=payment,proto3" json:"payment,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ValidateRequest) Reset() {
+	*x = ValidateRequest{}
+	mi := &file_moonbeam_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ValidateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ValidateRequest) ProtoMessage() {}
+
+func (x *ValidateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ValidateRequest.ProtoReflect.Descriptor instead.
+func (*ValidateRequest) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ValidateRequest) GetTxid() string {
+	if x != nil {
+		return x.Txid
+	}
+	return ""
+}
+
+func (x *ValidateRequest) GetVout() uint32 {
+	if x != nil {
+		return x.Vout
+	}
+	return 0
+}
+
+func (x *ValidateRequest) GetPayment() []byte {
+	if x != nil {
+		return x.Payment
+	}
+	return nil
+}
+
+type ValidateResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Valid bool                   `protobuf:"varint,1,opt,name=valid,proto3" json:"valid,omitempty"`
+	// Why the payment is invalid, e.g. "capacity_exhausted". Empty if valid.
+	Reason        string `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ValidateResponse) Reset() {
+	*x = ValidateResponse{}
+	mi := &file_moonbeam_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ValidateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ValidateResponse) ProtoMessage() {}
+
+func (x *ValidateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ValidateResponse.ProtoReflect.Descriptor instead.
+func (*ValidateResponse) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ValidateResponse) GetValid() bool {
+	if x != nil {
+		return x.Valid
+	}
+	return false
+}
+
+func (x *ValidateResponse) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type SendRequest struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Txid             string                 `protobuf:"bytes,1,opt,name=txid,proto3" json:"txid,omitempty"`
+	Vout             uint32                 `protobuf:"varint,2,opt,name=vout,proto3" json:"vout,omitempty"`
+	Payment          []byte                 `protobuf:"bytes,3,opt,name=payment,proto3" json:"payment,omitempty"`
+	SenderSig        []byte                 `protobuf:"bytes,4,opt,name=sender_sig,json=senderSig,proto3" json:"sender_sig,omitempty"`
+	HoldId           string                 `protobuf:"bytes,5,opt,name=hold_id,json=holdId,proto3" json:"hold_id,omitempty"`
+	RevocationHash   []byte                 `protobuf:"bytes,6,opt,name=revocation_hash,json=revocationHash,proto3" json:"revocation_hash,omitempty"`
+	RevocationSecret []byte                 `protobuf:"bytes,7,opt,name=revocation_secret,json=revocationSecret,proto3" json:"revocation_secret,omitempty"`
+	PaymentId        string                 `protobuf:"bytes,8,opt,name=payment_id,json=paymentId,proto3" json:"payment_id,omitempty"`
+	InvoiceId        string                 `protobuf:"bytes,9,opt,name=invoice_id,json=invoiceId,proto3" json:"invoice_id,omitempty"`
+	FeeSigs          []*FeeSig              `protobuf:"bytes,10,rep,name=fee_sigs,json=feeSigs,proto3" json:"fee_sigs,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *SendRequest) Reset() {
+	*x = SendRequest{}
+	mi := &file_moonbeam_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendRequest) ProtoMessage() {}
+
+func (x *SendRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendRequest.ProtoReflect.Descriptor instead.
+func (*SendRequest) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SendRequest) GetTxid() string {
+	if x != nil {
+		return x.Txid
+	}
+	return ""
+}
+
+func (x *SendRequest) GetVout() uint32 {
+	if x != nil {
+		return x.Vout
+	}
+	return 0
+}
+
+func (x *SendRequest) GetPayment() []byte {
+	if x != nil {
+		return x.Payment
+	}
+	return nil
+}
+
+func (x *SendRequest) GetSenderSig() []byte {
+	if x != nil {
+		return x.SenderSig
+	}
+	return nil
+}
+
+func (x *SendRequest) GetHoldId() string {
+	if x != nil {
+		return x.HoldId
+	}
+	return ""
+}
+
+func (x *SendRequest) GetRevocationHash() []byte {
+	if x != nil {
+		return x.RevocationHash
+	}
+	return nil
+}
+
+func (x *SendRequest) GetRevocationSecret() []byte {
+	if x != nil {
+		return x.RevocationSecret
+	}
+	return nil
+}
+
+func (x *SendRequest) GetPaymentId() string {
+	if x != nil {
+		return x.PaymentId
+	}
+	return ""
+}
+
+func (x *SendRequest) GetInvoiceId() string {
+	if x != nil {
+		return x.InvoiceId
+	}
+	return ""
+}
+
+func (x *SendRequest) GetFeeSigs() []*FeeSig {
+	if x != nil {
+		return x.FeeSigs
+	}
+	return nil
+}
+
+type FeeSig struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Fee           int64                  `protobuf:"varint,1,opt,name=fee,proto3" json:"fee,omitempty"`
+	Sig           []byte                 `protobuf:"bytes,2,opt,name=sig,proto3" json:"sig,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FeeSig) Reset() {
+	*x = FeeSig{}
+	mi := &file_moonbeam_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FeeSig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FeeSig) ProtoMessage() {}
+
+func (x *FeeSig) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FeeSig.ProtoReflect.Descriptor instead.
+func (*FeeSig) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *FeeSig) GetFee() int64 {
+	if x != nil {
+		return x.Fee
+	}
+	return 0
+}
+
+func (x *FeeSig) GetSig() []byte {
+	if x != nil {
+		return x.Sig
+	}
+	return nil
+}
+
+type SendResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CommitmentSig []byte                 `protobuf:"bytes,1,opt,name=commitment_sig,json=commitmentSig,proto3" json:"commitment_sig,omitempty"`
+	Receipt       *Receipt               `protobuf:"bytes,2,opt,name=receipt,proto3" json:"receipt,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendResponse) Reset() {
+	*x = SendResponse{}
+	mi := &file_moonbeam_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendResponse) ProtoMessage() {}
+
+func (x *SendResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendResponse.ProtoReflect.Descriptor instead.
+func (*SendResponse) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SendResponse) GetCommitmentSig() []byte {
+	if x != nil {
+		return x.CommitmentSig
+	}
+	return nil
+}
+
+func (x *SendResponse) GetReceipt() *Receipt {
+	if x != nil {
+		return x.Receipt
+	}
+	return nil
+}
+
+type Receipt struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	ChannelId      string                 `protobuf:"bytes,1,opt,name=channel_id,json=channelId,proto3" json:"channel_id,omitempty"`
+	Count          int64                  `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+	Amount         int64                  `protobuf:"varint,3,opt,name=amount,proto3" json:"amount,omitempty"`
+	Target         string                 `protobuf:"bytes,4,opt,name=target,proto3" json:"target,omitempty"`
+	PaymentsHash   []byte                 `protobuf:"bytes,5,opt,name=payments_hash,json=paymentsHash,proto3" json:"payments_hash,omitempty"`
+	ReceiverPubKey []byte                 `protobuf:"bytes,6,opt,name=receiver_pub_key,json=receiverPubKey,proto3" json:"receiver_pub_key,omitempty"`
+	Signature      []byte                 `protobuf:"bytes,7,opt,name=signature,proto3" json:"signature,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Receipt) Reset() {
+	*x = Receipt{}
+	mi := &file_moonbeam_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Receipt) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Receipt) ProtoMessage() {}
+
+func (x *Receipt) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Receipt.ProtoReflect.Descriptor instead.
+func (*Receipt) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Receipt) GetChannelId() string {
+	if x != nil {
+		return x.ChannelId
+	}
+	return ""
+}
+
+func (x *Receipt) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *Receipt) GetAmount() int64 {
+	if x != nil {
+		return x.Amount
+	}
+	return 0
+}
+
+func (x *Receipt) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *Receipt) GetPaymentsHash() []byte {
+	if x != nil {
+		return x.PaymentsHash
+	}
+	return nil
+}
+
+func (x *Receipt) GetReceiverPubKey() []byte {
+	if x != nil {
+		return x.ReceiverPubKey
+	}
+	return nil
+}
+
+func (x *Receipt) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+type HoldRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Txid          string                 `protobuf:"bytes,1,opt,name=txid,proto3" json:"txid,omitempty"`
+	Vout          uint32                 `protobuf:"varint,2,opt,name=vout,proto3" json:"vout,omitempty"`
+	HoldId        string                 `protobuf:"bytes,3,opt,name=hold_id,json=holdId,proto3" json:"hold_id,omitempty"`
+	Amount        int64                  `protobuf:"varint,4,opt,name=amount,proto3" json:"amount,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HoldRequest) Reset() {
+	*x = HoldRequest{}
+	mi := &file_moonbeam_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HoldRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HoldRequest) ProtoMessage() {}
+
+func (x *HoldRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HoldRequest.ProtoReflect.Descriptor instead.
+func (*HoldRequest) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *HoldRequest) GetTxid() string {
+	if x != nil {
+		return x.Txid
+	}
+	return ""
+}
+
+func (x *HoldRequest) GetVout() uint32 {
+	if x != nil {
+		return x.Vout
+	}
+	return 0
+}
+
+func (x *HoldRequest) GetHoldId() string {
+	if x != nil {
+		return x.HoldId
+	}
+	return ""
+}
+
+func (x *HoldRequest) GetAmount() int64 {
+	if x != nil {
+		return x.Amount
+	}
+	return 0
+}
+
+type HoldResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HoldResponse) Reset() {
+	*x = HoldResponse{}
+	mi := &file_moonbeam_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HoldResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HoldResponse) ProtoMessage() {}
+
+func (x *HoldResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HoldResponse.ProtoReflect.Descriptor instead.
+func (*HoldResponse) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{11}
+}
+
+type ReleaseRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Txid          string                 `protobuf:"bytes,1,opt,name=txid,proto3" json:"txid,omitempty"`
+	Vout          uint32                 `protobuf:"varint,2,opt,name=vout,proto3" json:"vout,omitempty"`
+	HoldId        string                 `protobuf:"bytes,3,opt,name=hold_id,json=holdId,proto3" json:"hold_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReleaseRequest) Reset() {
+	*x = ReleaseRequest{}
+	mi := &file_moonbeam_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReleaseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseRequest) ProtoMessage() {}
+
+func (x *ReleaseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseRequest.ProtoReflect.Descriptor instead.
+func (*ReleaseRequest) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ReleaseRequest) GetTxid() string {
+	if x != nil {
+		return x.Txid
+	}
+	return ""
+}
+
+func (x *ReleaseRequest) GetVout() uint32 {
+	if x != nil {
+		return x.Vout
+	}
+	return 0
+}
+
+func (x *ReleaseRequest) GetHoldId() string {
+	if x != nil {
+		return x.HoldId
+	}
+	return ""
+}
+
+type ReleaseResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReleaseResponse) Reset() {
+	*x = ReleaseResponse{}
+	mi := &file_moonbeam_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReleaseResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseResponse) ProtoMessage() {}
+
+func (x *ReleaseResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseResponse.ProtoReflect.Descriptor instead.
+func (*ReleaseResponse) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{13}
+}
+
+type CloseRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Txid          string                 `protobuf:"bytes,1,opt,name=txid,proto3" json:"txid,omitempty"`
+	Vout          uint32                 `protobuf:"varint,2,opt,name=vout,proto3" json:"vout,omitempty"`
+	FeeRate       int64                  `protobuf:"varint,3,opt,name=fee_rate,json=feeRate,proto3" json:"fee_rate,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CloseRequest) Reset() {
+	*x = CloseRequest{}
+	mi := &file_moonbeam_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CloseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CloseRequest) ProtoMessage() {}
+
+func (x *CloseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CloseRequest.ProtoReflect.Descriptor instead.
+func (*CloseRequest) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *CloseRequest) GetTxid() string {
+	if x != nil {
+		return x.Txid
+	}
+	return ""
+}
+
+func (x *CloseRequest) GetVout() uint32 {
+	if x != nil {
+		return x.Vout
+	}
+	return 0
+}
+
+func (x *CloseRequest) GetFeeRate() int64 {
+	if x != nil {
+		return x.FeeRate
+	}
+	return 0
+}
+
+type CloseResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CloseTx       []byte                 `protobuf:"bytes,1,opt,name=close_tx,json=closeTx,proto3" json:"close_tx,omitempty"`
+	Fee           int64                  `protobuf:"varint,2,opt,name=fee,proto3" json:"fee,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CloseResponse) Reset() {
+	*x = CloseResponse{}
+	mi := &file_moonbeam_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CloseResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CloseResponse) ProtoMessage() {}
+
+func (x *CloseResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CloseResponse.ProtoReflect.Descriptor instead.
+func (*CloseResponse) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *CloseResponse) GetCloseTx() []byte {
+	if x != nil {
+		return x.CloseTx
+	}
+	return nil
+}
+
+func (x *CloseResponse) GetFee() int64 {
+	if x != nil {
+		return x.Fee
+	}
+	return 0
+}
+
+type StatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Txid          string                 `protobuf:"bytes,1,opt,name=txid,proto3" json:"txid,omitempty"`
+	Vout          uint32                 `protobuf:"varint,2,opt,name=vout,proto3" json:"vout,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatusRequest) Reset() {
+	*x = StatusRequest{}
+	mi := &file_moonbeam_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusRequest) ProtoMessage() {}
+
+func (x *StatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusRequest.ProtoReflect.Descriptor instead.
+func (*StatusRequest) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *StatusRequest) GetTxid() string {
+	if x != nil {
+		return x.Txid
+	}
+	return ""
+}
+
+func (x *StatusRequest) GetVout() uint32 {
+	if x != nil {
+		return x.Vout
+	}
+	return 0
+}
+
+type StatusResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Status          int32                  `protobuf:"varint,1,opt,name=status,proto3" json:"status,omitempty"`
+	Balance         int64                  `protobuf:"varint,2,opt,name=balance,proto3" json:"balance,omitempty"`
+	PaymentsHash    []byte                 `protobuf:"bytes,3,opt,name=payments_hash,json=paymentsHash,proto3" json:"payments_hash,omitempty"`
+	AppData         []byte                 `protobuf:"bytes,4,opt,name=app_data,json=appData,proto3" json:"app_data,omitempty"`
+	Held            int64                  `protobuf:"varint,5,opt,name=held,proto3" json:"held,omitempty"`
+	CommitmentSig   []byte                 `protobuf:"bytes,6,opt,name=commitment_sig,json=commitmentSig,proto3" json:"commitment_sig,omitempty"`
+	FundingTxid     string                 `protobuf:"bytes,7,opt,name=funding_txid,json=fundingTxid,proto3" json:"funding_txid,omitempty"`
+	FundingVout     uint32                 `protobuf:"varint,8,opt,name=funding_vout,json=fundingVout,proto3" json:"funding_vout,omitempty"`
+	FundingAmount   int64                  `protobuf:"varint,9,opt,name=funding_amount,json=fundingAmount,proto3" json:"funding_amount,omitempty"`
+	Available       int64                  `protobuf:"varint,10,opt,name=available,proto3" json:"available,omitempty"`
+	Count           int64                  `protobuf:"varint,11,opt,name=count,proto3" json:"count,omitempty"`
+	BlocksRemaining *int64                 `protobuf:"varint,12,opt,name=blocks_remaining,json=blocksRemaining,proto3,oneof" json:"blocks_remaining,omitempty"`
+	Labels          map[string]string      `protobuf:"bytes,13,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *StatusResponse) Reset() {
+	*x = StatusResponse{}
+	mi := &file_moonbeam_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusResponse) ProtoMessage() {}
+
+func (x *StatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_moonbeam_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusResponse.ProtoReflect.Descriptor instead.
+func (*StatusResponse) Descriptor() ([]byte, []int) {
+	return file_moonbeam_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *StatusResponse) GetStatus() int32 {
+	if x != nil {
+		return x.Status
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetBalance() int64 {
+	if x != nil {
+		return x.Balance
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetPaymentsHash() []byte {
+	if x != nil {
+		return x.PaymentsHash
+	}
+	return nil
+}
+
+func (x *StatusResponse) GetAppData() []byte {
+	if x != nil {
+		return x.AppData
+	}
+	return nil
+}
+
+func (x *StatusResponse) GetHeld() int64 {
+	if x != nil {
+		return x.Held
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetCommitmentSig() []byte {
+	if x != nil {
+		return x.CommitmentSig
+	}
+	return nil
+}
+
+func (x *StatusResponse) GetFundingTxid() string {
+	if x != nil {
+		return x.FundingTxid
+	}
+	return ""
+}
+
+func (x *StatusResponse) GetFundingVout() uint32 {
+	if x != nil {
+		return x.FundingVout
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetFundingAmount() int64 {
+	if x != nil {
+		return x.FundingAmount
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetAvailable() int64 {
+	if x != nil {
+		return x.Available
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetBlocksRemaining() int64 {
+	if x != nil && x.BlocksRemaining != nil {
+		return *x.BlocksRemaining
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+var File_moonbeam_proto protoreflect.FileDescriptor
+
+const file_moonbeam_proto_rawDesc = "" +
+	"\n" +
+	"\x0emoonbeam.proto\x12\bmoonbeam\"\xb7\x02\n" +
+	"\rCreateRequest\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\x05R\aversion\x12\x10\n" +
+	"\x03net\x18\x02 \x01(\tR\x03net\x12$\n" +
+	"\x0esender_pub_key\x18\x03 \x01(\fR\fsenderPubKey\x12#\n" +
+	"\rsender_output\x18\x04 \x01(\tR\fsenderOutput\x12\x19\n" +
+	"\bapp_data\x18\x05 \x01(\fR\aappData\x12\x1c\n" +
+	"\trevocable\x18\x06 \x01(\bR\trevocable\x12;\n" +
+	"\x06labels\x18\a \x03(\v2#.moonbeam.CreateRequest.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x84\x03\n" +
+	"\x0eCreateResponse\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\x05R\aversion\x12\x10\n" +
+	"\x03net\x18\x02 \x01(\tR\x03net\x12\x18\n" +
+	"\atimeout\x18\x03 \x01(\x03R\atimeout\x12\x10\n" +
+	"\x03fee\x18\x04 \x01(\x03R\x03fee\x12\x1f\n" +
+	"\vmin_payment\x18\x05 \x01(\x03R\n" +
+	"minPayment\x12\x1f\n" +
+	"\vmax_payment\x18\x06 \x01(\x03R\n" +
+	"maxPayment\x12(\n" +
+	"\x10receiver_pub_key\x18\a \x01(\fR\x0ereceiverPubKey\x12'\n" +
+	"\x0freceiver_output\x18\b \x01(\tR\x0ereceiverOutput\x12'\n" +
+	"\x0ffunding_address\x18\t \x01(\tR\x0efundingAddress\x12\x19\n" +
+	"\bapp_data\x18\n" +
+	" \x01(\fR\aappData\x12\x1c\n" +
+	"\trevocable\x18\v \x01(\bR\trevocable\x12#\n" +
+	"\rreceiver_data\x18\f \x01(\fR\freceiverData\"\x93\x04\n" +
+	"\vOpenRequest\x12\x12\n" +
+	"\x04txid\x18\x01 \x01(\tR\x04txid\x12\x12\n" +
+	"\x04vout\x18\x02 \x01(\rR\x04vout\x12#\n" +
+	"\rreceiver_data\x18\x03 \x01(\fR\freceiverData\x12\x18\n" +
+	"\aversion\x18\x04 \x01(\x05R\aversion\x12\x10\n" +
+	"\x03net\x18\x05 \x01(\tR\x03net\x12\x18\n" +
+	"\atimeout\x18\x06 \x01(\x03R\atimeout\x12\x10\n" +
+	"\x03fee\x18\a \x01(\x03R\x03fee\x12\x1f\n" +
+	"\vmin_payment\x18\b \x01(\x03R\n" +
+	"minPayment\x12\x1f\n" +
+	"\vmax_payment\x18\t \x01(\x03R\n" +
+	"maxPayment\x12$\n" +
+	"\x0esender_pub_key\x18\n" +
+	" \x01(\fR\fsenderPubKey\x12#\n" +
+	"\rsender_output\x18\v \x01(\tR\fsenderOutput\x12(\n" +
+	"\x10receiver_pub_key\x18\f \x01(\fR\x0ereceiverPubKey\x12'\n" +
+	"\x0freceiver_output\x18\r \x01(\tR\x0ereceiverOutput\x12\x19\n" +
+	"\bapp_data\x18\x0e \x01(\fR\aappData\x12\x1c\n" +
+	"\trevocable\x18\x0f \x01(\bR\trevocable\x12'\n" +
+	"\x0frevocation_hash\x18\x10 \x01(\fR\x0erevocationHash\x12\x1d\n" +
+	"\n" +
+	"sender_sig\x18\x11 \x01(\fR\tsenderSig\"T\n" +
+	"\fOpenResponse\x12\x1d\n" +
+	"\n" +
+	"auth_token\x18\x01 \x01(\tR\tauthToken\x12%\n" +
+	"\x0ecommitment_sig\x18\x02 \x01(\fR\rcommitmentSig\"S\n" +
+	"\x0fValidateRequest\x12\x12\n" +
+	"\x04txid\x18\x01 \x01(\tR\x04txid\x12\x12\n" +
+	"\x04vout\x18\x02 \x01(\rR\x04vout\x12\x18\n" +
+	"\apayment\x18\x03 \x01(\fR\apayment\"@\n" +
+	"\x10ValidateResponse\x12\x14\n" +
+	"\x05valid\x18\x01 \x01(\bR\x05valid\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\"\xc8\x02\n" +
+	"\vSendRequest\x12\x12\n" +
+	"\x04txid\x18\x01 \x01(\tR\x04txid\x12\x12\n" +
+	"\x04vout\x18\x02 \x01(\rR\x04vout\x12\x18\n" +
+	"\apayment\x18\x03 \x01(\fR\apayment\x12\x1d\n" +
+	"\n" +
+	"sender_sig\x18\x04 \x01(\fR\tsenderSig\x12\x17\n" +
+	"\ahold_id\x18\x05 \x01(\tR\x06holdId\x12'\n" +
+	"\x0frevocation_hash\x18\x06 \x01(\fR\x0erevocationHash\x12+\n" +
+	"\x11revocation_secret\x18\a \x01(\fR\x10revocationSecret\x12\x1d\n" +
+	"\n" +
+	"payment_id\x18\b \x01(\tR\tpaymentId\x12\x1d\n" +
+	"\n" +
+	"invoice_id\x18\t \x01(\tR\tinvoiceId\x12+\n" +
+	"\bfee_sigs\x18\n" +
+	" \x03(\v2\x10.moonbeam.FeeSigR\afeeSigs\",\n" +
+	"\x06FeeSig\x12\x10\n" +
+	"\x03fee\x18\x01 \x01(\x03R\x03fee\x12\x10\n" +
+	"\x03sig\x18\x02 \x01(\fR\x03sig\"b\n" +
+	"\fSendResponse\x12%\n" +
+	"\x0ecommitment_sig\x18\x01 \x01(\fR\rcommitmentSig\x12+\n" +
+	"\areceipt\x18\x02 \x01(\v2\x11.moonbeam.ReceiptR\areceipt\"\xdb\x01\n" +
+	"\aReceipt\x12\x1d\n" +
+	"\n" +
+	"channel_id\x18\x01 \x01(\tR\tchannelId\x12\x14\n" +
+	"\x05count\x18\x02 \x01(\x03R\x05count\x12\x16\n" +
+	"\x06amount\x18\x03 \x01(\x03R\x06amount\x12\x16\n" +
+	"\x06target\x18\x04 \x01(\tR\x06target\x12#\n" +
+	"\rpayments_hash\x18\x05 \x01(\fR\fpaymentsHash\x12(\n" +
+	"\x10receiver_pub_key\x18\x06 \x01(\fR\x0ereceiverPubKey\x12\x1c\n" +
+	"\tsignature\x18\a \x01(\fR\tsignature\"f\n" +
+	"\vHoldRequest\x12\x12\n" +
+	"\x04txid\x18\x01 \x01(\tR\x04txid\x12\x12\n" +
+	"\x04vout\x18\x02 \x01(\rR\x04vout\x12\x17\n" +
+	"\ahold_id\x18\x03 \x01(\tR\x06holdId\x12\x16\n" +
+	"\x06amount\x18\x04 \x01(\x03R\x06amount\"\x0e\n" +
+	"\fHoldResponse\"Q\n" +
+	"\x0eReleaseRequest\x12\x12\n" +
+	"\x04txid\x18\x01 \x01(\tR\x04txid\x12\x12\n" +
+	"\x04vout\x18\x02 \x01(\rR\x04vout\x12\x17\n" +
+	"\ahold_id\x18\x03 \x01(\tR\x06holdId\"\x11\n" +
+	"\x0fReleaseResponse\"Q\n" +
+	"\fCloseRequest\x12\x12\n" +
+	"\x04txid\x18\x01 \x01(\tR\x04txid\x12\x12\n" +
+	"\x04vout\x18\x02 \x01(\rR\x04vout\x12\x19\n" +
+	"\bfee_rate\x18\x03 \x01(\x03R\afeeRate\"<\n" +
+	"\rCloseResponse\x12\x19\n" +
+	"\bclose_tx\x18\x01 \x01(\fR\acloseTx\x12\x10\n" +
+	"\x03fee\x18\x02 \x01(\x03R\x03fee\"7\n" +
+	"\rStatusRequest\x12\x12\n" +
+	"\x04txid\x18\x01 \x01(\tR\x04txid\x12\x12\n" +
+	"\x04vout\x18\x02 \x01(\rR\x04vout\"\x9c\x04\n" +
+	"\x0eStatusResponse\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\x05R\x06status\x12\x18\n" +
+	"\abalance\x18\x02 \x01(\x03R\abalance\x12#\n" +
+	"\rpayments_hash\x18\x03 \x01(\fR\fpaymentsHash\x12\x19\n" +
+	"\bapp_data\x18\x04 \x01(\fR\aappData\x12\x12\n" +
+	"\x04held\x18\x05 \x01(\x03R\x04held\x12%\n" +
+	"\x0ecommitment_sig\x18\x06 \x01(\fR\rcommitmentSig\x12!\n" +
+	"\ffunding_txid\x18\a \x01(\tR\vfundingTxid\x12!\n" +
+	"\ffunding_vout\x18\b \x01(\rR\vfundingVout\x12%\n" +
+	"\x0efunding_amount\x18\t \x01(\x03R\rfundingAmount\x12\x1c\n" +
+	"\tavailable\x18\n" +
+	" \x01(\x03R\tavailable\x12\x14\n" +
+	"\x05count\x18\v \x01(\x03R\x05count\x12.\n" +
+	"\x10blocks_remaining\x18\f \x01(\x03H\x00R\x0fblocksRemaining\x88\x01\x01\x12<\n" +
+	"\x06labels\x18\r \x03(\v2$.moonbeam.StatusResponse.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\x13\n" +
+	"\x11_blocks_remaining2\xe6\x03\n" +
+	"\bReceiver\x12;\n" +
+	"\x06Create\x12\x17.moonbeam.CreateRequest\x1a\x18.moonbeam.CreateResponse\x125\n" +
+	"\x04Open\x12\x15.moonbeam.OpenRequest\x1a\x16.moonbeam.OpenResponse\x12A\n" +
+	"\bValidate\x12\x19.moonbeam.ValidateRequest\x1a\x1a.moonbeam.ValidateResponse\x125\n" +
+	"\x04Send\x12\x15.moonbeam.SendRequest\x1a\x16.moonbeam.SendResponse\x125\n" +
+	"\x04Hold\x12\x15.moonbeam.HoldRequest\x1a\x16.moonbeam.HoldResponse\x12>\n" +
+	"\aRelease\x12\x18.moonbeam.ReleaseRequest\x1a\x19.moonbeam.ReleaseResponse\x128\n" +
+	"\x05Close\x12\x16.moonbeam.CloseRequest\x1a\x17.moonbeam.CloseResponse\x12;\n" +
+	"\x06Status\x12\x17.moonbeam.StatusRequest\x1a\x18.moonbeam.StatusResponseB Z\x1egithub.com/luno/moonbeam/rpcpbb\x06proto3"
+
+var (
+	file_moonbeam_proto_rawDescOnce sync.Once
+	file_moonbeam_proto_rawDescData []byte
+)
+
+func file_moonbeam_proto_rawDescGZIP() []byte {
+	file_moonbeam_proto_rawDescOnce.Do(func() {
+		file_moonbeam_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_moonbeam_proto_rawDesc), len(file_moonbeam_proto_rawDesc)))
+	})
+	return file_moonbeam_proto_rawDescData
+}
+
+var file_moonbeam_proto_msgTypes = make([]protoimpl.MessageInfo, 20)
+var file_moonbeam_proto_goTypes = []any{
+	(*CreateRequest)(nil),    // 0: moonbeam.CreateRequest
+	(*CreateResponse)(nil),   // 1: moonbeam.CreateResponse
+	(*OpenRequest)(nil),      // 2: moonbeam.OpenRequest
+	(*OpenResponse)(nil),     // 3: moonbeam.OpenResponse
+	(*ValidateRequest)(nil),  // 4: moonbeam.ValidateRequest
+	(*ValidateResponse)(nil), // 5: moonbeam.ValidateResponse
+	(*SendRequest)(nil),      // 6: moonbeam.SendRequest
+	(*FeeSig)(nil),           // 7: moonbeam.FeeSig
+	(*SendResponse)(nil),     // 8: moonbeam.SendResponse
+	(*Receipt)(nil),          // 9: moonbeam.Receipt
+	(*HoldRequest)(nil),      // 10: moonbeam.HoldRequest
+	(*HoldResponse)(nil),     // 11: moonbeam.HoldResponse
+	(*ReleaseRequest)(nil),   // 12: moonbeam.ReleaseRequest
+	(*ReleaseResponse)(nil),  // 13: moonbeam.ReleaseResponse
+	(*CloseRequest)(nil),     // 14: moonbeam.CloseRequest
+	(*CloseResponse)(nil),    // 15: moonbeam.CloseResponse
+	(*StatusRequest)(nil),    // 16: moonbeam.StatusRequest
+	(*StatusResponse)(nil),   // 17: moonbeam.StatusResponse
+	nil,                      // 18: moonbeam.CreateRequest.LabelsEntry
+	nil,                      // 19: moonbeam.StatusResponse.LabelsEntry
+}
+var file_moonbeam_proto_depIdxs = []int32{
+	18, // 0: moonbeam.CreateRequest.labels:type_name -> moonbeam.CreateRequest.LabelsEntry
+	7,  // 1: moonbeam.SendRequest.fee_sigs:type_name -> moonbeam.FeeSig
+	9,  // 2: moonbeam.SendResponse.receipt:type_name -> moonbeam.Receipt
+	19, // 3: moonbeam.StatusResponse.labels:type_name -> moonbeam.StatusResponse.LabelsEntry
+	0,  // 4: moonbeam.Receiver.Create:input_type -> moonbeam.CreateRequest
+	2,  // 5: moonbeam.Receiver.Open:input_type -> moonbeam.OpenRequest
+	4,  // 6: moonbeam.Receiver.Validate:input_type -> moonbeam.ValidateRequest
+	6,  // 7: moonbeam.Receiver.Send:input_type -> moonbeam.SendRequest
+	10, // 8: moonbeam.Receiver.Hold:input_type -> moonbeam.HoldRequest
+	12, // 9: moonbeam.Receiver.Release:input_type -> moonbeam.ReleaseRequest
+	14, // 10: moonbeam.Receiver.Close:input_type -> moonbeam.CloseRequest
+	16, // 11: moonbeam.Receiver.Status:input_type -> moonbeam.StatusRequest
+	1,  // 12: moonbeam.Receiver.Create:output_type -> moonbeam.CreateResponse
+	3,  // 13: moonbeam.Receiver.Open:output_type -> moonbeam.OpenResponse
+	5,  // 14: moonbeam.Receiver.Validate:output_type -> moonbeam.ValidateResponse
+	8,  // 15: moonbeam.Receiver.Send:output_type -> moonbeam.SendResponse
+	11, // 16: moonbeam.Receiver.Hold:output_type -> moonbeam.HoldResponse
+	13, // 17: moonbeam.Receiver.Release:output_type -> moonbeam.ReleaseResponse
+	15, // 18: moonbeam.Receiver.Close:output_type -> moonbeam.CloseResponse
+	17, // 19: moonbeam.Receiver.Status:output_type -> moonbeam.StatusResponse
+	12, // [12:20] is the sub-list for method output_type
+	4,  // [4:12] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_moonbeam_proto_init() }
+func file_moonbeam_proto_init() {
+	if File_moonbeam_proto != nil {
+		return
+	}
+	file_moonbeam_proto_msgTypes[17].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_moonbeam_proto_rawDesc), len(file_moonbeam_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   20,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_moonbeam_proto_goTypes,
+		DependencyIndexes: file_moonbeam_proto_depIdxs,
+		MessageInfos:      file_moonbeam_proto_msgTypes,
+	}.Build()
+	File_moonbeam_proto = out.File
+	file_moonbeam_proto_goTypes = nil
+	file_moonbeam_proto_depIdxs = nil
+}
@@ -0,0 +1,825 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luno/moonbeam/backup"
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/receiver"
+	"github.com/luno/moonbeam/storage"
+)
+
+// AdminPath is the path under which the admin API is served.
+const AdminPath = "/admin"
+
+// AdminReceiver is the set of privileged receiver operations exposed by the
+// admin API. It is implemented by receiver.Receiver.
+type AdminReceiver interface {
+	Inspect(ctx context.Context, txid string, vout uint32) (*receiver.ChannelDump, error)
+	ForceClose(ctx context.Context, txid string, vout uint32, feeRate int64) (*models.CloseResponse, error)
+	CloseEstimate(ctx context.Context, txid string, vout uint32) (*receiver.CloseEstimate, error)
+	Suspend(ctx context.Context, txid string, vout uint32, reason string) error
+	Resume(ctx context.Context, txid string, vout uint32) error
+	SetLabels(ctx context.Context, txid string, vout uint32, labels map[string]string) error
+	TargetBalances(ctx context.Context) ([]storage.TargetBalance, error)
+	Totals(ctx context.Context, from, to time.Time) (*receiver.AccountingTotals, error)
+	DailyTotals(ctx context.Context, target string, from, to time.Time) ([]storage.DailyTotal, error)
+	CreateInvoice(ctx context.Context, amount int64, target string, ttl time.Duration) (*storage.Invoice, error)
+	GetInvoice(ctx context.Context, id string) (*storage.Invoice, error)
+	ExportChannels(ctx context.Context, w io.Writer, format receiver.ExportFormat, f storage.ListFilter) error
+	ExportPayments(ctx context.Context, w io.Writer, format receiver.ExportFormat, f storage.ListFilter, q receiver.PaymentQuery) error
+	KeyGenerations(ctx context.Context) ([]receiver.KeyGeneration, error)
+	RotateKey(ctx context.Context, xpub string) (*receiver.KeyGeneration, error)
+	Backup(ctx context.Context, w io.Writer) (*backup.Manifest, error)
+	RestoreBackup(ctx context.Context, r io.Reader) (*backup.Manifest, error)
+	Access(ctx context.Context) (*receiver.AccessControl, error)
+	PutAccessEntry(ctx context.Context, list storage.AccessList, kind storage.AccessKind, value, note string) (*storage.AccessEntry, error)
+	RemoveAccessEntry(ctx context.Context, list storage.AccessList, kind storage.AccessKind, value string) error
+	SetAllowlistOnly(ctx context.Context, on bool) error
+	ScreeningRecords(ctx context.Context) ([]storage.ScreeningRecord, error)
+	SenderChannels(ctx context.Context, senderPubKey []byte) ([]storage.Record, error)
+	Rescreen(ctx context.Context) ([]storage.ScreeningRecord, error)
+	Purge(ctx context.Context, dryRun bool) (*receiver.PurgeReport, error)
+}
+
+// maxBackupSize bounds the archives accepted by the restore call.
+const maxBackupSize = 1 << 30
+
+// Balance is the balance of a target in the admin balances call.
+type Balance struct {
+	Target     string
+	Count      int64
+	Gross      int64
+	Commission int64
+	Net        int64
+}
+
+// DailyTotal is a target's totals for a day in the admin daily call.
+type DailyTotal struct {
+	Day        string
+	Target     string
+	Count      int64
+	Gross      int64
+	Commission int64
+	Net        int64
+	Average    int64
+}
+
+// InvoiceRequest is the body of an admin invoices call. TTL is a duration
+// such as "15m".
+type InvoiceRequest struct {
+	Amount int64  `json:"amount"`
+	Target string `json:"target"`
+	TTL    string `json:"ttl"`
+}
+
+// CloseRequest is the body of an admin close call. FeeRate is optional.
+type CloseRequest struct {
+	FeeRate int64 `json:"feeRate"`
+}
+
+// LabelsRequest is the body of an admin labels call. Labels with empty
+// values are removed.
+type LabelsRequest struct {
+	Labels map[string]string `json:"labels"`
+}
+
+// RotateKeyRequest is the body of an admin keys/rotate call. XPub selects
+// the standby key and may be empty if there is only one.
+type RotateKeyRequest struct {
+	XPub string `json:"xpub"`
+}
+
+// AccessRequest is the body of an admin access or access/remove call. List
+// is allow or block, and Kind is sender, for a hex encoded public key, or
+// address.
+type AccessRequest struct {
+	List  storage.AccessList `json:"list"`
+	Kind  storage.AccessKind `json:"kind"`
+	Value string             `json:"value"`
+	Note  string             `json:"note"`
+}
+
+// AccessModeRequest is the body of an admin access/mode call.
+type AccessModeRequest struct {
+	AllowlistOnly bool `json:"allowlistOnly"`
+}
+
+// SuspendRequest is the body of an admin suspend call.
+type SuspendRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Admin serves the operator's API, separately from the sender-facing RPC.
+// Calls on a channel are made to AdminPath/<call>/<txid>-<vout> and must
+// carry the admin token in a Bearer Authorization header:
+//
+//	GET  inspect  returns the channel's stored state and payment log
+//	GET  estimate returns the outputs, fee and size of the transaction the
+//	              channel would be closed with, without closing it
+//	POST close    closes the channel at its current balance, optionally at
+//	              a higher fee rate the sender has signed for
+//	POST suspend  stops the channel from accepting payments
+//	POST resume   lifts a suspension
+//	POST labels   sets or removes the channel's labels
+//
+// The accounting calls are made to AdminPath/<call>:
+//
+//	GET  balances  returns the gross, commission and net balance per target
+//	GET  totals    returns the totals credited between the RFC 3339 from
+//	               and to query parameters, which are optional
+//	GET  daily     returns the totals per target and UTC day, optionally
+//	               for a single target and between from and to
+//
+// Invoices are created with POST AdminPath/invoices and fetched, including
+// whether they've been paid, with GET AdminPath/invoices/<id>.
+//
+// Channels and their decoded payments are streamed with GET
+// AdminPath/export/channels and GET AdminPath/export/payments, as CSV or,
+// with format=json, a JSON object per line. Channels are selected with the
+// status, account and label=<key>=<value> query parameters, and payments
+// also with target and the RFC 3339 from and to.
+//
+// POST AdminPath/reload reloads the receiver's settings. If the new settings
+// are invalid, the call fails and the current settings remain in effect.
+//
+// GET AdminPath/keys lists the generations of the receiver's extended key
+// and POST AdminPath/keys/rotate makes a standby key, loaded at startup, the
+// one new channels use.
+//
+// POST AdminPath/backup returns an encrypted archive of the receiver's
+// entire stored state, and POST AdminPath/restore replaces the state with
+// that of an archive posted as the body, returning its manifest once the
+// restored state has been verified. Both can be called while the receiver
+// serves requests.
+//
+// GET AdminPath/access returns the access list of senders and output
+// addresses that are allowed or blocked from creating and opening channels.
+// POST AdminPath/access adds an entry, POST AdminPath/access/remove removes
+// one and POST AdminPath/access/mode turns allowlist-only mode on or off.
+//
+// GET AdminPath/senders/<pubkey> returns the channels of the sender with the
+// hex encoded public key.
+//
+// GET AdminPath/screening returns the audit records of addresses flagged by
+// compliance screening, and POST AdminPath/screening/rescreen screens the
+// addresses of all channels again, freezing those flagged now.
+//
+// If Debug is set, pprof profiles, expvar counters and a goroutine dump are
+// served under AdminPath/debug for profiling in production.
+//
+// Instead of the admin token, calls may present an API key with the
+// admin:read scope for GET calls or admin:write for the others, either as the
+// Bearer token or in the X-API-Key header. Keys restricted to an account can
+// only reach that account's channels.
+//
+// Every call is logged for auditing. If RequireClientCert is set, calls must
+// also be made over TLS with a verified client certificate.
+type Admin struct {
+	r     AdminReceiver
+	token string
+
+	// Keys, if set, validates API keys presented instead of the admin token.
+	Keys KeyChecker
+
+	// RequireClientCert refuses calls made without a client certificate
+	// verified against Config.ClientCAs.
+	RequireClientCert bool
+
+	// Log receives the audit log.
+	Log *slog.Logger
+
+	// Reload, if set, is called by POST AdminPath/reload to reload the
+	// receiver's settings.
+	Reload func() error
+
+	// Debug serves profiles and runtime diagnostics under AdminPath/debug.
+	// They require the admin:write scope.
+	Debug bool
+}
+
+// NewAdmin returns a handler for the admin API. If token is empty, only API
+// keys are accepted.
+func NewAdmin(r AdminReceiver, token string) *Admin {
+	return &Admin{r: r, token: token, Log: slog.Default()}
+}
+
+// authorized reports whether the call is authorized for the scope. It also
+// returns the account the call is restricted to, if any.
+func (s *Admin) authorized(r *http.Request, scope string) (string, bool) {
+	if s.RequireClientCert && clientCertName(r) == "" {
+		return "", false
+	}
+	h := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if s.token != "" && strings.HasPrefix(h, prefix) &&
+		subtle.ConstantTimeCompare([]byte(h[len(prefix):]), []byte(s.token)) == 1 {
+		return "", true
+	}
+	if s.Keys == nil {
+		return "", false
+	}
+	return checkKey(s.Keys, r, scope, true)
+}
+
+// clientCertName returns the common name of the request's verified client
+// certificate, or an empty string if there is none.
+func clientCertName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return cert.SerialNumber.String()
+	}
+	return cert.Subject.CommonName
+}
+
+func (s *Admin) Register(mux *http.ServeMux) {
+	mux.Handle(AdminPath+"/", s)
+}
+
+func (s *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, AdminPath+"/")
+	i := strings.Index(path, "/")
+	if i < 0 && path != "balances" && path != "totals" && path != "daily" && path != "invoices" && path != "reload" && path != "keys" && path != "backup" && path != "restore" && path != "access" && path != "screening" && path != "retention" {
+		http.NotFound(w, r)
+		return
+	}
+	call := path
+	if i >= 0 {
+		call = path[:i]
+	}
+
+	scope := receiver.ScopeAdminWrite
+	if r.Method == http.MethodGet && call != "debug" {
+		scope = receiver.ScopeAdminRead
+	}
+	account, ok := s.authorized(r, scope)
+	if !ok {
+		s.Log.Warn("admin call unauthorized", "path", r.URL.Path,
+			"remote", clientIP(r))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	if account != "" {
+		ctx = receiver.WithAccount(ctx, account)
+	}
+
+	if call == "invoices" {
+		s.invoices(w, r.WithContext(ctx), path[len(call):], account)
+		return
+	}
+	if call == "export" {
+		s.export(w, r.WithContext(ctx), path[len(call):], account)
+		return
+	}
+	if call == "reload" {
+		s.reload(w, r, account)
+		return
+	}
+	if call == "keys" {
+		s.keys(w, r, path[len(call):], account)
+		return
+	}
+	if call == "backup" || call == "restore" {
+		s.backup(w, r, call, account)
+		return
+	}
+	if call == "access" {
+		s.access(w, r, path[len(call):], account)
+		return
+	}
+	if call == "screening" {
+		s.screening(w, r, path[len(call):], account)
+		return
+	}
+	if call == "retention" {
+		s.retention(w, r, path[len(call):], account)
+		return
+	}
+	if call == "senders" {
+		s.senders(w, r.WithContext(ctx), path[i+1:], account)
+		return
+	}
+	if call == "debug" {
+		s.debug(w, r, path[len(call):], account)
+		return
+	}
+	if i < 0 {
+		s.accounting(w, r.WithContext(ctx), call, account)
+		return
+	}
+
+	txid, vout, ok := ParseChannelID(path[i+1:])
+	if !ok {
+		http.Error(w, "Invalid channel ID", http.StatusNotFound)
+		return
+	}
+
+	method := http.MethodPost
+	if call == "inspect" || call == "estimate" {
+		method = http.MethodGet
+	}
+	if r.Method != method {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var resp interface{}
+	var err error
+	var reason string
+	switch call {
+	case "inspect":
+		resp, err = s.r.Inspect(ctx, txid, vout)
+	case "estimate":
+		resp, err = s.r.CloseEstimate(ctx, txid, vout)
+	case "close":
+		var req CloseRequest
+		if !readBody(w, r, &req) {
+			return
+		}
+		resp, err = s.r.ForceClose(ctx, txid, vout, req.FeeRate)
+	case "suspend":
+		var req SuspendRequest
+		if !readBody(w, r, &req) {
+			return
+		}
+		reason = req.Reason
+		err = s.r.Suspend(ctx, txid, vout, reason)
+		resp = struct{}{}
+	case "resume":
+		err = s.r.Resume(ctx, txid, vout)
+		resp = struct{}{}
+	case "labels":
+		var req LabelsRequest
+		if !readBody(w, r, &req) {
+			return
+		}
+		err = s.r.SetLabels(ctx, txid, vout, req.Labels)
+		resp = struct{}{}
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.Log.Info("admin call", "call", call, "channel", txid+"-"+strconv.Itoa(int(vout)),
+		"remote", clientIP(r), "client", clientCertName(r), "account", account,
+		"reason", reason, "err", err)
+
+	s.respond(w, r, resp, err)
+}
+
+// readBody decodes the optional JSON body of a call into req. It responds
+// with an error and returns false if the body is invalid.
+func readBody(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	buf, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err == nil && len(buf) > 0 {
+		err = json.Unmarshal(buf, req)
+	}
+	if err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (s *Admin) respond(w http.ResponseWriter, r *http.Request, resp interface{}, err error) {
+	if err == storage.ErrNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	switch err.(type) {
+	case nil:
+	case receiver.ExposableError:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.Log.Error("json encode failed", "err", err)
+	}
+}
+
+// accounting serves the calls that aren't on a single channel.
+func (s *Admin) accounting(w http.ResponseWriter, r *http.Request, call, account string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	var resp interface{}
+	var err error
+	switch call {
+	case "balances":
+		var balances []storage.TargetBalance
+		balances, err = s.r.TargetBalances(ctx)
+		res := []Balance{}
+		for _, b := range balances {
+			res = append(res, Balance{
+				Target:     b.Target,
+				Count:      b.Count,
+				Gross:      b.Gross,
+				Commission: b.Commission,
+				Net:        b.Net(),
+			})
+		}
+		resp = res
+	case "totals":
+		from, ok := parseTime(r.FormValue("from"))
+		to, ok2 := parseTime(r.FormValue("to"))
+		if !ok || !ok2 {
+			http.Error(w, "invalid time", http.StatusBadRequest)
+			return
+		}
+		resp, err = s.r.Totals(ctx, from, to)
+	case "daily":
+		from, ok := parseTime(r.FormValue("from"))
+		to, ok2 := parseTime(r.FormValue("to"))
+		if !ok || !ok2 {
+			http.Error(w, "invalid time", http.StatusBadRequest)
+			return
+		}
+		var totals []storage.DailyTotal
+		totals, err = s.r.DailyTotals(ctx, r.FormValue("target"), from, to)
+		res := []DailyTotal{}
+		for _, t := range totals {
+			res = append(res, DailyTotal{
+				Day:        t.Day.Format("2006-01-02"),
+				Target:     t.Target,
+				Count:      t.Count,
+				Gross:      t.Gross,
+				Commission: t.Commission,
+				Net:        t.Net(),
+				Average:    t.Average(),
+			})
+		}
+		resp = res
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	s.Log.Info("admin call", "call", call, "remote", clientIP(r),
+		"client", clientCertName(r), "account", account, "err", err)
+
+	s.respond(w, r, resp, err)
+}
+
+// parseListFilter parses the channel filter of a call's query parameters.
+func parseListFilter(r *http.Request) (storage.ListFilter, bool) {
+	var f storage.ListFilter
+	if status := r.FormValue("status"); status != "" {
+		s, ok := channels.ParseStatus(status)
+		if !ok {
+			return f, false
+		}
+		f.Status = s
+	}
+	if _, ok := r.Form["account"]; ok {
+		f.ByAccount, f.Account = true, r.FormValue("account")
+	}
+	for _, l := range r.Form["label"] {
+		k, v, ok := strings.Cut(l, "=")
+		if !ok {
+			return f, false
+		}
+		if f.Labels == nil {
+			f.Labels = make(map[string]string)
+		}
+		f.Labels[k] = v
+	}
+	return f, true
+}
+
+// export serves the export calls. what is "/channels" or "/payments".
+func (s *Admin) export(w http.ResponseWriter, r *http.Request, what, account string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	if what != "/channels" && what != "/payments" {
+		http.NotFound(w, r)
+		return
+	}
+
+	format := receiver.ExportCSV
+	if v := r.FormValue("format"); v != "" {
+		var ok bool
+		if format, ok = receiver.ParseExportFormat(v); !ok {
+			http.Error(w, "invalid format", http.StatusBadRequest)
+			return
+		}
+	}
+	f, ok := parseListFilter(r)
+	from, ok2 := parseTime(r.FormValue("from"))
+	to, ok3 := parseTime(r.FormValue("to"))
+	if !ok || !ok2 || !ok3 {
+		http.Error(w, "invalid filter", http.StatusBadRequest)
+		return
+	}
+
+	if format == receiver.ExportJSON {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/csv")
+	}
+
+	// Rows are streamed, so errors can't be reported once the first has
+	// been written. The response is cut short instead.
+	var err error
+	if what == "/channels" {
+		err = s.r.ExportChannels(r.Context(), w, format, f)
+	} else {
+		q := receiver.PaymentQuery{Target: r.FormValue("target"), From: from, To: to}
+		err = s.r.ExportPayments(r.Context(), w, format, f, q)
+	}
+
+	s.Log.Info("admin call", "call", "export"+what, "remote", clientIP(r),
+		"client", clientCertName(r), "account", account, "err", err)
+}
+
+// reload serves the reload call, which isn't available to keys restricted
+// to an account.
+func (s *Admin) reload(w http.ResponseWriter, r *http.Request, account string) {
+	if r.Method != http.MethodPost || s.Reload == nil {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	if account != "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err := s.Reload()
+	s.Log.Info("admin call", "call", "reload", "remote", clientIP(r),
+		"client", clientCertName(r), "err", err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.respond(w, r, struct{}{}, nil)
+}
+
+// keys serves the key generation calls, which aren't available to keys
+// restricted to an account. call is empty or "/rotate".
+func (s *Admin) keys(w http.ResponseWriter, r *http.Request, call, account string) {
+	if account != "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	var resp interface{}
+	var err error
+	var xpub string
+	switch {
+	case call == "" && r.Method == http.MethodGet:
+		resp, err = s.r.KeyGenerations(ctx)
+	case call == "/rotate" && r.Method == http.MethodPost:
+		var req RotateKeyRequest
+		if !readBody(w, r, &req) {
+			return
+		}
+		xpub = req.XPub
+		resp, err = s.r.RotateKey(ctx, xpub)
+	case call == "" || call == "/rotate":
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	s.Log.Info("admin call", "call", "keys"+call, "xpub", xpub,
+		"remote", clientIP(r), "client", clientCertName(r), "err", err)
+
+	s.respond(w, r, resp, err)
+}
+
+// backup serves the backup and restore calls.
+func (s *Admin) backup(w http.ResponseWriter, r *http.Request, call, account string) {
+	if account != "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	var buf bytes.Buffer
+	var m *backup.Manifest
+	var err error
+	if call == "backup" {
+		m, err = s.r.Backup(ctx, &buf)
+	} else {
+		m, err = s.r.RestoreBackup(ctx, http.MaxBytesReader(w, r.Body, maxBackupSize))
+	}
+
+	var n int
+	if m != nil {
+		n = m.Channels
+	}
+	s.Log.Info("admin call", "call", call, "channels", n,
+		"remote", clientIP(r), "client", clientCertName(r), "err", err)
+
+	if call == "restore" || err != nil {
+		s.respond(w, r, m, err)
+		return
+	}
+	name := fmt.Sprintf("moonbeam-%s-%s.backup", m.Net, m.Created.UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Write(buf.Bytes())
+}
+
+// invoices serves the invoice calls. id is empty or "/<id>".
+func (s *Admin) invoices(w http.ResponseWriter, r *http.Request, id, account string) {
+	ctx := r.Context()
+	var resp interface{}
+	var err error
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		var req InvoiceRequest
+		buf, rerr := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+		if rerr == nil {
+			rerr = json.Unmarshal(buf, &req)
+		}
+		ttl, terr := time.ParseDuration(req.TTL)
+		if rerr != nil || terr != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		resp, err = s.r.CreateInvoice(ctx, req.Amount, req.Target, ttl)
+	case id != "" && r.Method == http.MethodGet:
+		resp, err = s.r.GetInvoice(ctx, id[1:])
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.Log.Info("admin call", "call", "invoices", "invoice", strings.TrimPrefix(id, "/"),
+		"remote", clientIP(r), "client", clientCertName(r), "account", account,
+		"err", err)
+
+	s.respond(w, r, resp, err)
+}
+
+// parseTime parses an optional RFC 3339 time or date.
+func parseTime(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", s)
+	}
+	return t, err == nil
+}
+
+// access serves the access list calls.
+func (s *Admin) access(w http.ResponseWriter, r *http.Request, call, account string) {
+	if account != "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	var resp interface{}
+	var err error
+	var req AccessRequest
+	switch {
+	case call == "" && r.Method == http.MethodGet:
+		resp, err = s.r.Access(ctx)
+	case call == "" && r.Method == http.MethodPost:
+		if !readBody(w, r, &req) {
+			return
+		}
+		resp, err = s.r.PutAccessEntry(ctx, req.List, req.Kind, req.Value, req.Note)
+	case call == "/remove" && r.Method == http.MethodPost:
+		if !readBody(w, r, &req) {
+			return
+		}
+		err = s.r.RemoveAccessEntry(ctx, req.List, req.Kind, req.Value)
+		resp = struct{}{}
+	case call == "/mode" && r.Method == http.MethodPost:
+		var mreq AccessModeRequest
+		if !readBody(w, r, &mreq) {
+			return
+		}
+		err = s.r.SetAllowlistOnly(ctx, mreq.AllowlistOnly)
+		resp = struct{}{}
+	case call == "" || call == "/remove" || call == "/mode":
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	s.Log.Info("admin call", "call", "access"+call, "list", req.List,
+		"kind", req.Kind, "value", req.Value,
+		"remote", clientIP(r), "client", clientCertName(r), "err", err)
+
+	s.respond(w, r, resp, err)
+}
+
+// screening serves the screening audit and rescreen calls.
+func (s *Admin) senders(w http.ResponseWriter, r *http.Request, pubKey, account string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	senderPubKey, err := hex.DecodeString(pubKey)
+	if err != nil || len(senderPubKey) == 0 {
+		http.Error(w, "Invalid sender public key", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.r.SenderChannels(r.Context(), senderPubKey)
+
+	s.Log.Info("admin call", "call", "senders", "sender", pubKey,
+		"remote", clientIP(r), "client", clientCertName(r), "account", account,
+		"err", err)
+
+	s.respond(w, r, resp, err)
+}
+
+func (s *Admin) screening(w http.ResponseWriter, r *http.Request, call, account string) {
+	if account != "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	var resp interface{}
+	var err error
+	switch {
+	case call == "" && r.Method == http.MethodGet:
+		resp, err = s.r.ScreeningRecords(ctx)
+	case call == "/rescreen" && r.Method == http.MethodPost:
+		resp, err = s.r.Rescreen(ctx)
+	case call == "" || call == "/rescreen":
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	s.Log.Info("admin call", "call", "screening"+call,
+		"remote", clientIP(r), "client", clientCertName(r), "err", err)
+
+	s.respond(w, r, resp, err)
+}
+
+// retention reports what the retention policy would purge, or purges it.
+func (s *Admin) retention(w http.ResponseWriter, r *http.Request, call, account string) {
+	if account != "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	var resp interface{}
+	var err error
+	switch {
+	case call == "" && r.Method == http.MethodGet:
+		resp, err = s.r.Purge(ctx, true)
+	case call == "/purge" && r.Method == http.MethodPost:
+		resp, err = s.r.Purge(ctx, false)
+	case call == "" || call == "/purge":
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	s.Log.Info("admin call", "call", "retention"+call,
+		"remote", clientIP(r), "client", clientCertName(r), "err", err)
+
+	s.respond(w, r, resp, err)
+}
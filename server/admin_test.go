@@ -0,0 +1,532 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/backup"
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/receiver"
+	"github.com/luno/moonbeam/storage"
+)
+
+type fakeAdmin struct {
+	calls   []string
+	reason  string
+	feeRate int64
+}
+
+func (f *fakeAdmin) Inspect(ctx context.Context, txid string, vout uint32) (*receiver.ChannelDump, error) {
+	f.calls = append(f.calls, "inspect")
+	if vout != 0 {
+		return nil, storage.ErrNotFound
+	}
+	return &receiver.ChannelDump{Record: storage.Record{ID: txid}}, nil
+}
+
+func (f *fakeAdmin) ForceClose(ctx context.Context, txid string, vout uint32, feeRate int64) (*models.CloseResponse, error) {
+	f.calls = append(f.calls, "close")
+	f.feeRate = feeRate
+	return &models.CloseResponse{}, nil
+}
+
+func (f *fakeAdmin) CloseEstimate(ctx context.Context, txid string, vout uint32) (*receiver.CloseEstimate, error) {
+	f.calls = append(f.calls, "estimate")
+	return &receiver.CloseEstimate{Fee: 1000}, nil
+}
+
+func (f *fakeAdmin) Suspend(ctx context.Context, txid string, vout uint32, reason string) error {
+	f.calls = append(f.calls, "suspend")
+	f.reason = reason
+	return nil
+}
+
+func (f *fakeAdmin) SetLabels(ctx context.Context, txid string, vout uint32, labels map[string]string) error {
+	f.calls = append(f.calls, "labels")
+	if labels["customer"] == "" {
+		return receiver.ErrInvalidLabels
+	}
+	return nil
+}
+
+func (f *fakeAdmin) Resume(ctx context.Context, txid string, vout uint32) error {
+	f.calls = append(f.calls, "resume")
+	return nil
+}
+
+func (f *fakeAdmin) TargetBalances(ctx context.Context) ([]storage.TargetBalance, error) {
+	f.calls = append(f.calls, "balances")
+	return []storage.TargetBalance{{Target: "t", Count: 1, Gross: 100, Commission: 2}}, nil
+}
+
+func (f *fakeAdmin) Totals(ctx context.Context, from, to time.Time) (*receiver.AccountingTotals, error) {
+	f.calls = append(f.calls, "totals")
+	return &receiver.AccountingTotals{Count: 1, Gross: 100, Commission: 2, Net: 98}, nil
+}
+
+func (f *fakeAdmin) DailyTotals(ctx context.Context, target string, from, to time.Time) ([]storage.DailyTotal, error) {
+	f.calls = append(f.calls, "daily")
+	day := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	return []storage.DailyTotal{{Day: day, Target: "t", Count: 2, Gross: 300, Commission: 6}}, nil
+}
+
+func (f *fakeAdmin) ExportChannels(ctx context.Context, w io.Writer, format receiver.ExportFormat, lf storage.ListFilter) error {
+	f.calls = append(f.calls, "export channels "+string(format)+" "+lf.Status.String())
+	_, err := io.WriteString(w, "id\n")
+	return err
+}
+
+func (f *fakeAdmin) ExportPayments(ctx context.Context, w io.Writer, format receiver.ExportFormat, lf storage.ListFilter, q receiver.PaymentQuery) error {
+	f.calls = append(f.calls, "export payments "+string(format)+" "+q.Target)
+	return nil
+}
+
+func (f *fakeAdmin) KeyGenerations(ctx context.Context) ([]receiver.KeyGeneration, error) {
+	f.calls = append(f.calls, "keys")
+	return []receiver.KeyGeneration{{Generation: 0, XPub: "tpub", Current: true}}, nil
+}
+
+func (f *fakeAdmin) RotateKey(ctx context.Context, xpub string) (*receiver.KeyGeneration, error) {
+	f.calls = append(f.calls, "rotate "+xpub)
+	if xpub == "unknown" {
+		return nil, receiver.NewExposableError("no matching standby key")
+	}
+	return &receiver.KeyGeneration{Generation: 1, XPub: xpub, Current: true}, nil
+}
+
+func (f *fakeAdmin) Backup(ctx context.Context, w io.Writer) (*backup.Manifest, error) {
+	f.calls = append(f.calls, "backup")
+	_, err := io.WriteString(w, "archive")
+	return &backup.Manifest{Net: "testnet3", Channels: 1}, err
+}
+
+func (f *fakeAdmin) RestoreBackup(ctx context.Context, r io.Reader) (*backup.Manifest, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	f.calls = append(f.calls, "restore "+string(buf))
+	if string(buf) != "archive" {
+		return nil, receiver.NewExposableError(backup.ErrCorrupt.Error())
+	}
+	return &backup.Manifest{Net: "testnet3", Channels: 1}, nil
+}
+
+func (f *fakeAdmin) Access(ctx context.Context) (*receiver.AccessControl, error) {
+	f.calls = append(f.calls, "access")
+	return &receiver.AccessControl{}, nil
+}
+
+func (f *fakeAdmin) PutAccessEntry(ctx context.Context, list storage.AccessList, kind storage.AccessKind, value, note string) (*storage.AccessEntry, error) {
+	f.calls = append(f.calls, "put "+string(list)+" "+string(kind)+" "+value)
+	if kind != storage.AccessSender && kind != storage.AccessAddress {
+		return nil, receiver.NewExposableError("invalid kind")
+	}
+	return &storage.AccessEntry{List: list, Kind: kind, Value: value, Note: note}, nil
+}
+
+func (f *fakeAdmin) RemoveAccessEntry(ctx context.Context, list storage.AccessList, kind storage.AccessKind, value string) error {
+	f.calls = append(f.calls, "remove "+string(list)+" "+string(kind)+" "+value)
+	return storage.ErrNotFound
+}
+
+func (f *fakeAdmin) SetAllowlistOnly(ctx context.Context, on bool) error {
+	f.calls = append(f.calls, fmt.Sprintf("mode %v", on))
+	return nil
+}
+
+func (f *fakeAdmin) ScreeningRecords(ctx context.Context) ([]storage.ScreeningRecord, error) {
+	f.calls = append(f.calls, "screening")
+	return []storage.ScreeningRecord{{Address: "addr"}}, nil
+}
+
+func (f *fakeAdmin) SenderChannels(ctx context.Context, senderPubKey []byte) ([]storage.Record, error) {
+	f.calls = append(f.calls, fmt.Sprintf("senders %x", senderPubKey))
+	return []storage.Record{{ID: "chan"}}, nil
+}
+
+func (f *fakeAdmin) Rescreen(ctx context.Context) ([]storage.ScreeningRecord, error) {
+	f.calls = append(f.calls, "rescreen")
+	return nil, receiver.ErrNoScreener
+}
+
+func (f *fakeAdmin) Purge(ctx context.Context, dryRun bool) (*receiver.PurgeReport, error) {
+	f.calls = append(f.calls, fmt.Sprintf("purge %v", dryRun))
+	return &receiver.PurgeReport{DryRun: dryRun, Channels: 2}, nil
+}
+
+func (f *fakeAdmin) CreateInvoice(ctx context.Context, amount int64, target string, ttl time.Duration) (*storage.Invoice, error) {
+	f.calls = append(f.calls, "create invoice")
+	if amount <= 0 {
+		return nil, receiver.NewExposableError("invalid amount")
+	}
+	return &storage.Invoice{ID: "inv", Amount: amount, Target: target}, nil
+}
+
+func (f *fakeAdmin) GetInvoice(ctx context.Context, id string) (*storage.Invoice, error) {
+	f.calls = append(f.calls, "get invoice")
+	if id != "inv" {
+		return nil, storage.ErrNotFound
+	}
+	return &storage.Invoice{ID: id}, nil
+}
+
+func TestAdmin(t *testing.T) {
+	f := &fakeAdmin{}
+	h := NewAdmin(f, "secret")
+	id := testTxID + "-0"
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		code   int
+	}{
+		{"no token", http.MethodGet, AdminPath + "/inspect/" + id, "", "", http.StatusUnauthorized},
+		{"rpc token", http.MethodGet, AdminPath + "/inspect/" + id, "token", "", http.StatusUnauthorized},
+		{"inspect", http.MethodGet, AdminPath + "/inspect/" + id, "secret", "", http.StatusOK},
+		{"inspect missing", http.MethodGet, AdminPath + "/inspect/" + testTxID + "-1", "secret", "", http.StatusNotFound},
+		{"estimate", http.MethodGet, AdminPath + "/estimate/" + id, "secret", "", http.StatusOK},
+		{"estimate wrong method", http.MethodPost, AdminPath + "/estimate/" + id, "secret", "", http.StatusMethodNotAllowed},
+		{"close wrong method", http.MethodGet, AdminPath + "/close/" + id, "secret", "", http.StatusMethodNotAllowed},
+		{"close", http.MethodPost, AdminPath + "/close/" + id, "secret", `{"feeRate":50}`, http.StatusOK},
+		{"close bad body", http.MethodPost, AdminPath + "/close/" + id, "secret", "{", http.StatusBadRequest},
+		{"suspend", http.MethodPost, AdminPath + "/suspend/" + id, "secret", `{"reason":"fraud"}`, http.StatusOK},
+		{"resume", http.MethodPost, AdminPath + "/resume/" + id, "secret", "", http.StatusOK},
+		{"labels", http.MethodPost, AdminPath + "/labels/" + id, "secret", `{"labels":{"customer":"42"}}`, http.StatusOK},
+		{"invalid labels", http.MethodPost, AdminPath + "/labels/" + id, "secret", `{"labels":{}}`, http.StatusBadRequest},
+		{"bad channel id", http.MethodPost, AdminPath + "/resume/xyz", "secret", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		w := call(h, test.method, test.path, test.token, test.body)
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, w.Code)
+		}
+	}
+
+	expected := []string{"inspect", "inspect", "estimate", "close", "suspend", "resume", "labels", "labels"}
+	if len(f.calls) != len(expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, f.calls)
+	}
+	for i := range expected {
+		if f.calls[i] != expected[i] {
+			t.Errorf("Expected calls %v, got %v", expected, f.calls)
+		}
+	}
+	if f.feeRate != 50 {
+		t.Errorf("Expected close fee rate, got %d", f.feeRate)
+	}
+	if f.reason != "fraud" {
+		t.Errorf("Expected suspend reason, got %q", f.reason)
+	}
+
+	if w := call(NewAdmin(f, ""), http.MethodGet, AdminPath+"/inspect/"+id, "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected admin API without token to be disabled, got %d", w.Code)
+	}
+}
+
+func TestAdminAccounting(t *testing.T) {
+	h := NewAdmin(&fakeAdmin{}, "secret")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		code   int
+	}{
+		{"balances", http.MethodGet, AdminPath + "/balances", http.StatusOK},
+		{"balances wrong method", http.MethodPost, AdminPath + "/balances", http.StatusMethodNotAllowed},
+		{"totals", http.MethodGet, AdminPath + "/totals?from=2020-01-01T00:00:00Z", http.StatusOK},
+		{"totals bad time", http.MethodGet, AdminPath + "/totals?to=yesterday", http.StatusBadRequest},
+		{"daily", http.MethodGet, AdminPath + "/daily?target=t&from=2020-01-01", http.StatusOK},
+		{"daily bad time", http.MethodGet, AdminPath + "/daily?from=monday", http.StatusBadRequest},
+		{"unknown", http.MethodGet, AdminPath + "/foo", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		w := call(h, test.method, test.path, "secret", "")
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, w.Code)
+		}
+	}
+
+	w := call(h, http.MethodGet, AdminPath+"/balances", "secret", "")
+	var balances []Balance
+	if err := json.NewDecoder(w.Body).Decode(&balances); err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 1 || balances[0].Net != 98 {
+		t.Errorf("Unexpected balances %+v", balances)
+	}
+
+	w = call(h, http.MethodGet, AdminPath+"/daily", "secret", "")
+	var daily []DailyTotal
+	if err := json.NewDecoder(w.Body).Decode(&daily); err != nil {
+		t.Fatal(err)
+	}
+	if len(daily) != 1 || daily[0].Day != "2020-01-02" || daily[0].Net != 294 || daily[0].Average != 150 {
+		t.Errorf("Unexpected daily totals %+v", daily)
+	}
+}
+
+func TestAdminInvoices(t *testing.T) {
+	h := NewAdmin(&fakeAdmin{}, "secret")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"create", http.MethodPost, AdminPath + "/invoices", `{"amount":100,"target":"t","ttl":"15m"}`, http.StatusOK},
+		{"create bad ttl", http.MethodPost, AdminPath + "/invoices", `{"amount":100,"target":"t","ttl":"soon"}`, http.StatusBadRequest},
+		{"create bad amount", http.MethodPost, AdminPath + "/invoices", `{"amount":0,"target":"t","ttl":"15m"}`, http.StatusBadRequest},
+		{"list", http.MethodGet, AdminPath + "/invoices", "", http.StatusMethodNotAllowed},
+		{"get", http.MethodGet, AdminPath + "/invoices/inv", "", http.StatusOK},
+		{"get missing", http.MethodGet, AdminPath + "/invoices/other", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		w := call(h, test.method, test.path, "secret", test.body)
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, w.Code)
+		}
+	}
+
+	w := call(h, http.MethodPost, AdminPath+"/invoices", "secret", `{"amount":100,"target":"t","ttl":"15m"}`)
+	var inv storage.Invoice
+	if err := json.NewDecoder(w.Body).Decode(&inv); err != nil {
+		t.Fatal(err)
+	}
+	if inv.ID != "inv" || inv.Amount != 100 {
+		t.Errorf("Unexpected invoice %+v", inv)
+	}
+}
+
+func TestAdminReload(t *testing.T) {
+	h := NewAdmin(&fakeAdmin{}, "secret")
+	if w := call(h, http.MethodPost, AdminPath+"/reload", "secret", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected reload to be unavailable, got %d", w.Code)
+	}
+
+	var err error
+	var n int
+	h.Reload = func() error {
+		n++
+		return err
+	}
+	if w := call(h, http.MethodGet, AdminPath+"/reload", "secret", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to be refused, got %d", w.Code)
+	}
+	if w := call(h, http.MethodPost, AdminPath+"/reload", "secret", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected reload to succeed, got %d", w.Code)
+	}
+	err = errors.New("invalid settings")
+	if w := call(h, http.MethodPost, AdminPath+"/reload", "secret", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected reload to fail, got %d", w.Code)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 reloads, got %d", n)
+	}
+}
+
+func TestAdminKeyGenerations(t *testing.T) {
+	f := &fakeAdmin{}
+	h := NewAdmin(f, "secret")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+		call   string
+	}{
+		{"list", http.MethodGet, "/keys", "", http.StatusOK, "keys"},
+		{"rotate", http.MethodPost, "/keys/rotate", `{"xpub":"tpub2"}`, http.StatusOK, "rotate tpub2"},
+		{"no standby", http.MethodPost, "/keys/rotate", `{"xpub":"unknown"}`, http.StatusBadRequest, "rotate unknown"},
+		{"rotate GET", http.MethodGet, "/keys/rotate", "", http.StatusMethodNotAllowed, ""},
+		{"unknown", http.MethodPost, "/keys/other", "", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		f.calls = nil
+		w := call(h, test.method, AdminPath+test.path, "secret", test.body)
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, w.Code)
+		}
+		if test.call == "" && len(f.calls) > 0 || test.call != "" && (len(f.calls) != 1 || f.calls[0] != test.call) {
+			t.Errorf("%s: unexpected calls %v", test.name, f.calls)
+		}
+	}
+}
+
+func TestAdminBackup(t *testing.T) {
+	f := &fakeAdmin{}
+	h := NewAdmin(f, "secret")
+
+	w := call(h, http.MethodPost, AdminPath+"/backup", "secret", "")
+	if w.Code != http.StatusOK || w.Body.String() != "archive" {
+		t.Errorf("Expected archive, got %d %q", w.Code, w.Body.String())
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "moonbeam-testnet3-") {
+		t.Errorf("Unexpected Content-Disposition %q", cd)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+		call   string
+	}{
+		{"restore", http.MethodPost, "/restore", "archive", http.StatusOK, "restore archive"},
+		{"corrupt", http.MethodPost, "/restore", "junk", http.StatusBadRequest, "restore junk"},
+		{"backup GET", http.MethodGet, "/backup", "", http.StatusMethodNotAllowed, ""},
+	}
+	for _, test := range tests {
+		f.calls = nil
+		w := call(h, test.method, AdminPath+test.path, "secret", test.body)
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, w.Code)
+		}
+		if test.call == "" && len(f.calls) > 0 || test.call != "" && (len(f.calls) != 1 || f.calls[0] != test.call) {
+			t.Errorf("%s: unexpected calls %v", test.name, f.calls)
+		}
+	}
+}
+
+func TestAdminAccess(t *testing.T) {
+	f := &fakeAdmin{}
+	h := NewAdmin(f, "secret")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+		call   string
+	}{
+		{"list", http.MethodGet, "", "", http.StatusOK, "access"},
+		{"block", http.MethodPost, "", `{"list":"block","kind":"sender","value":"02ab"}`, http.StatusOK, "put block sender 02ab"},
+		{"bad kind", http.MethodPost, "", `{"list":"allow","kind":"ip","value":"x"}`, http.StatusBadRequest, "put allow ip x"},
+		{"remove", http.MethodPost, "/remove", `{"list":"block","kind":"address","value":"a"}`, http.StatusNotFound, "remove block address a"},
+		{"mode", http.MethodPost, "/mode", `{"allowlistOnly":true}`, http.StatusOK, "mode true"},
+		{"mode GET", http.MethodGet, "/mode", "", http.StatusMethodNotAllowed, ""},
+		{"unknown", http.MethodPost, "/other", "", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		f.calls = nil
+		w := call(h, test.method, AdminPath+"/access"+test.path, "secret", test.body)
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, w.Code)
+		}
+		if test.call == "" && len(f.calls) > 0 || test.call != "" && (len(f.calls) != 1 || f.calls[0] != test.call) {
+			t.Errorf("%s: unexpected calls %v", test.name, f.calls)
+		}
+	}
+}
+
+func TestAdminScreening(t *testing.T) {
+	f := &fakeAdmin{}
+	h := NewAdmin(f, "secret")
+
+	w := call(h, http.MethodGet, AdminPath+"/screening", "secret", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "addr") {
+		t.Errorf("Expected screening records, got %d %q", w.Code, w.Body.String())
+	}
+	w = call(h, http.MethodPost, AdminPath+"/screening/rescreen", "secret", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without screener, got %d", w.Code)
+	}
+	w = call(h, http.MethodGet, AdminPath+"/screening/rescreen", "secret", "")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", w.Code)
+	}
+}
+
+func TestAdminRetention(t *testing.T) {
+	f := &fakeAdmin{}
+	h := NewAdmin(f, "secret")
+
+	w := call(h, http.MethodGet, AdminPath+"/retention", "secret", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"DryRun":true`) {
+		t.Errorf("Expected a dry run report, got %d %q", w.Code, w.Body.String())
+	}
+	w = call(h, http.MethodPost, AdminPath+"/retention/purge", "secret", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"DryRun":false`) {
+		t.Errorf("Expected a purge report, got %d %q", w.Code, w.Body.String())
+	}
+	if len(f.calls) != 2 || f.calls[0] != "purge true" || f.calls[1] != "purge false" {
+		t.Errorf("Unexpected calls %v", f.calls)
+	}
+	w = call(h, http.MethodGet, AdminPath+"/retention/purge", "secret", "")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", w.Code)
+	}
+}
+
+func TestAdminSenders(t *testing.T) {
+	f := &fakeAdmin{}
+	h := NewAdmin(f, "secret")
+
+	w := call(h, http.MethodGet, AdminPath+"/senders/02ab", "secret", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "chan") {
+		t.Errorf("Expected the sender's channels, got %d %q", w.Code, w.Body.String())
+	}
+	if len(f.calls) != 1 || f.calls[0] != "senders 02ab" {
+		t.Errorf("Unexpected calls %v", f.calls)
+	}
+	w = call(h, http.MethodGet, AdminPath+"/senders/xyz", "secret", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid key, got %d", w.Code)
+	}
+	w = call(h, http.MethodPost, AdminPath+"/senders/02ab", "secret", "")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", w.Code)
+	}
+}
+
+func TestAdminExport(t *testing.T) {
+	f := &fakeAdmin{}
+	h := NewAdmin(f, "secret")
+
+	tests := []struct {
+		name string
+		path string
+		code int
+		call string
+	}{
+		{"channels", AdminPath + "/export/channels?status=OPEN", http.StatusOK, "export channels csv OPEN"},
+		{"payments", AdminPath + "/export/payments?format=json&target=t", http.StatusOK, "export payments json t"},
+		{"bad format", AdminPath + "/export/channels?format=xml", http.StatusBadRequest, ""},
+		{"bad status", AdminPath + "/export/channels?status=nope", http.StatusBadRequest, ""},
+		{"bad time", AdminPath + "/export/payments?from=soon", http.StatusBadRequest, ""},
+		{"unknown", AdminPath + "/export/invoices", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		f.calls = nil
+		w := call(h, http.MethodGet, test.path, "secret", "")
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, w.Code)
+		}
+		if test.call != "" && (len(f.calls) != 1 || f.calls[0] != test.call) {
+			t.Errorf("%s: unexpected calls %q", test.name, f.calls)
+		}
+	}
+
+	w := call(h, http.MethodGet, AdminPath+"/export/channels", "secret", "")
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv" || w.Body.String() != "id\n" {
+		t.Errorf("Unexpected export %q %q", ct, w.Body.String())
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// APIKeyHeader is the header carrying an API key.
+const APIKeyHeader = "X-API-Key"
+
+// KeyChecker validates API keys and returns the account they're restricted
+// to, if any. It is implemented by receiver.Receiver.
+type KeyChecker interface {
+	CheckAPIKey(ctx context.Context, key, scope string) (string, error)
+}
+
+// apiKey returns the API key presented with the request. Calls that don't
+// use the Authorization header for other tokens may also present it as a
+// Bearer token.
+func apiKey(r *http.Request, bearer bool) string {
+	if k := r.Header.Get(APIKeyHeader); k != "" {
+		return k
+	}
+	const prefix = "Bearer "
+	if h := r.Header.Get("Authorization"); bearer && strings.HasPrefix(h, prefix) {
+		return h[len(prefix):]
+	}
+	return ""
+}
+
+// checkKey reports whether the request carries an API key with the scope.
+// It also returns the account the key is restricted to, if any.
+func checkKey(keys KeyChecker, r *http.Request, scope string, bearer bool) (string, bool) {
+	k := apiKey(r, bearer)
+	if k == "" {
+		return "", false
+	}
+	account, err := keys.CheckAPIKey(r.Context(), k, scope)
+	if err != nil {
+		return "", false
+	}
+	return account, true
+}
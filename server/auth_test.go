@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/luno/moonbeam/receiver"
+)
+
+// fakeKeys accepts keys named after their scope.
+type fakeKeys struct{}
+
+func (fakeKeys) CheckAPIKey(ctx context.Context, key, scope string) (string, error) {
+	if key != scope {
+		return "", receiver.ErrInvalidAPIKey
+	}
+	return "", nil
+}
+
+func TestRPCKeys(t *testing.T) {
+	h := NewRPC(&fakeReceiver{})
+	h.Keys = fakeKeys{}
+	path := RPCPath + "/create"
+
+	if w := call(h, http.MethodPost, path, "", `{"version":1}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected call without key to be refused, got %d", w.Code)
+	}
+
+	req := newTestRequest(http.MethodPost, path, "", `{"version":1}`)
+	req.Header.Set(APIKeyHeader, receiver.ScopeRPC)
+	if w := serve(h, req); w.Code != http.StatusOK {
+		t.Errorf("Expected call with key to succeed, got %d", w.Code)
+	}
+}
+
+func TestAdminKeys(t *testing.T) {
+	h := NewAdmin(&fakeAdmin{}, "")
+	h.Keys = fakeKeys{}
+	id := testTxID + "-0"
+
+	tests := []struct {
+		name  string
+		call  string
+		token string
+		code  int
+	}{
+		{"inspect with read key", "inspect", receiver.ScopeAdminRead, http.StatusOK},
+		{"inspect with write key", "inspect", receiver.ScopeAdminWrite, http.StatusUnauthorized},
+		{"resume with read key", "resume", receiver.ScopeAdminRead, http.StatusUnauthorized},
+		{"resume with write key", "resume", receiver.ScopeAdminWrite, http.StatusOK},
+		{"empty admin token", "resume", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		method := http.MethodPost
+		if test.call == "inspect" {
+			method = http.MethodGet
+		}
+		w := call(h, method, AdminPath+"/"+test.call+"/"+id, test.token, "")
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, w.Code)
+		}
+	}
+}
+
+// accountKeys accepts any key, restricted to the shop account.
+type accountKeys struct{}
+
+func (accountKeys) CheckAPIKey(ctx context.Context, key, scope string) (string, error) {
+	return "shop", nil
+}
+
+func TestRPCAccountKeys(t *testing.T) {
+	h := NewRPC(&fakeReceiver{})
+	h.Keys = accountKeys{}
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{RPCPath + "/create", http.StatusUnauthorized},
+		{AccountPath("other") + "/create", http.StatusUnauthorized},
+		{AccountPath("shop") + "/create", http.StatusOK},
+		{AccountPath("shop"), http.StatusNotFound},
+	}
+	for _, test := range tests {
+		req := newTestRequest(http.MethodPost, test.path, "", `{"version":1}`)
+		req.Header.Set(APIKeyHeader, "key")
+		if w := serve(h, req); w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.path, test.code, w.Code)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Package server contains the HTTP components for serving a moonbeam receiver.
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	csrfSessionCookie = "mb_session"
+	csrfHeader        = "X-CSRF-Token"
+	csrfFormField     = "csrf_token"
+
+	csrfNonceLen = 16
+	csrfMACLen   = sha256.Size
+)
+
+var (
+	errCSRFMissing = errors.New("missing csrf token")
+	errCSRFInvalid = errors.New("invalid csrf token")
+	errCSRFExpired = errors.New("expired csrf token")
+	errCSRFReplay  = errors.New("csrf token already used")
+	errCSRFOrigin  = errors.New("cross-origin request")
+)
+
+// CSRF protects browser-driven mutating endpoints against cross-site request
+// forgery and replay.
+//
+// Each browser gets a random session cookie with SameSite=Strict. Pages
+// embed a token obtained from Token, which is bound to the session cookie,
+// expires after Window and can only be used once.
+//
+// It is opt-in: mbserver's own pages only read state, so it doesn't mount
+// it. Wrap handlers that change state from a browser with Middleware.
+type CSRF struct {
+	key []byte
+
+	// Window is how long an issued token remains valid.
+	Window time.Duration
+
+	// Secure sets the Secure attribute on the session cookie. It should only
+	// be disabled for local development over plain HTTP.
+	Secure bool
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+
+	// expiry holds the seen nonces in the order they were seen, so that
+	// expired ones are found without scanning all of them.
+	expiry []seenNonce
+}
+
+// NewCSRF returns CSRF middleware that authenticates tokens with key.
+func NewCSRF(key []byte) *CSRF {
+	return &CSRF{
+		key:    key,
+		Window: 10 * time.Minute,
+		Secure: true,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func (c *CSRF) mac(session string, nonce []byte, issued int64) []byte {
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(issued))
+
+	m := hmac.New(sha256.New, c.key)
+	m.Write([]byte(session))
+	m.Write(nonce)
+	m.Write(ts[:])
+	return m.Sum(nil)
+}
+
+// session returns the browser's session ID, setting a new session cookie if
+// there isn't one yet.
+func (c *CSRF) session(w http.ResponseWriter, r *http.Request) (string, error) {
+	if ck, err := r.Cookie(csrfSessionCookie); err == nil && ck.Value != "" {
+		return ck.Value, nil
+	}
+
+	id, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfSessionCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   c.Secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	// Make the new session visible to the rest of this request.
+	r.AddCookie(&http.Cookie{Name: csrfSessionCookie, Value: id})
+	return id, nil
+}
+
+// Token issues a new single-use token for the request's browser session. It
+// should be embedded in a form field named csrf_token or sent in the
+// X-CSRF-Token header.
+func (c *CSRF) Token(w http.ResponseWriter, r *http.Request) (string, error) {
+	session, err := c.session(w, r)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, csrfNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	issued := time.Now().Unix()
+
+	buf := make([]byte, 0, csrfNonceLen+8+csrfMACLen)
+	buf = append(buf, nonce...)
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(issued))
+	buf = append(buf, ts[:]...)
+	buf = append(buf, c.mac(session, nonce, issued)...)
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		// Browsers always send one of these on cross-site POSTs, so their
+		// absence means a non-browser client.
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host
+}
+
+// markSeen records the nonce and returns false if it had already been used.
+func (c *CSRF) markSeen(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.expiry) > 0 && now.Sub(c.expiry[0].at) > c.Window {
+		delete(c.seen, c.expiry[0].nonce)
+		c.expiry = c.expiry[1:]
+	}
+
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	c.seen[nonce] = now
+	c.expiry = append(c.expiry, seenNonce{nonce, now})
+	return true
+}
+
+func (c *CSRF) verify(r *http.Request) error {
+	if !sameOrigin(r) {
+		return errCSRFOrigin
+	}
+
+	ck, err := r.Cookie(csrfSessionCookie)
+	if err != nil || ck.Value == "" {
+		return errCSRFMissing
+	}
+
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		token = r.PostFormValue(csrfFormField)
+	}
+	if token == "" {
+		return errCSRFMissing
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(buf) != csrfNonceLen+8+csrfMACLen {
+		return errCSRFInvalid
+	}
+	nonce := buf[:csrfNonceLen]
+	issued := int64(binary.BigEndian.Uint64(buf[csrfNonceLen : csrfNonceLen+8]))
+	mac := buf[csrfNonceLen+8:]
+
+	if !hmac.Equal(mac, c.mac(ck.Value, nonce, issued)) {
+		return errCSRFInvalid
+	}
+
+	now := time.Now()
+	age := now.Sub(time.Unix(issued, 0))
+	if age < -time.Minute || age > c.Window {
+		return errCSRFExpired
+	}
+
+	if !c.markSeen(string(nonce), now) {
+		return errCSRFReplay
+	}
+
+	return nil
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// Middleware rejects mutating requests that don't carry a valid token.
+// Safe requests are passed through, establishing a session if needed.
+func (c *CSRF) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isSafeMethod(r.Method) {
+			if _, err := c.session(w, r); err != nil {
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if err := c.verify(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
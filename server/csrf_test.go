@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestCSRF() (*CSRF, http.Handler) {
+	c := NewCSRF([]byte("test key"))
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	return c, h
+}
+
+func getToken(t *testing.T, c *CSRF) (*http.Cookie, string) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	token, err := c.Token(w, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Expected session cookie")
+	}
+	if cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Errorf("Expected SameSite=Strict")
+	}
+	return cookies[0], token
+}
+
+func post(h http.Handler, ck *http.Cookie, token string) int {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	if ck != nil {
+		req.AddCookie(ck)
+	}
+	if token != "" {
+		req.Header.Set(csrfHeader, token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestCSRF(t *testing.T) {
+	c, h := newTestCSRF()
+	ck, token := getToken(t, c)
+
+	if code := post(h, ck, ""); code != http.StatusForbidden {
+		t.Errorf("Expected missing token to be rejected, got %d", code)
+	}
+	if code := post(h, ck, token); code != http.StatusOK {
+		t.Errorf("Expected valid token to be accepted, got %d", code)
+	}
+	if code := post(h, ck, token); code != http.StatusForbidden {
+		t.Errorf("Expected replayed token to be rejected, got %d", code)
+	}
+}
+
+func TestCSRFWrongSession(t *testing.T) {
+	c, h := newTestCSRF()
+	_, token := getToken(t, c)
+	other, _ := getToken(t, c)
+
+	if code := post(h, other, token); code != http.StatusForbidden {
+		t.Errorf("Expected token from other session to be rejected, got %d", code)
+	}
+}
+
+func TestCSRFExpired(t *testing.T) {
+	c, h := newTestCSRF()
+	c.Window = -time.Hour
+	ck, token := getToken(t, c)
+
+	if code := post(h, ck, token); code != http.StatusForbidden {
+		t.Errorf("Expected expired token to be rejected, got %d", code)
+	}
+}
+
+func TestCSRFCrossOrigin(t *testing.T) {
+	c, h := newTestCSRF()
+	ck, token := getToken(t, c)
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.AddCookie(ck)
+	req.Header.Set(csrfHeader, token)
+	req.Header.Set("Origin", "https://evil.example.com")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected cross-origin request to be rejected, got %d", w.Code)
+	}
+}
+
+func TestCSRFSeenExpiry(t *testing.T) {
+	c := NewCSRF([]byte("test key"))
+	start := time.Now()
+
+	for i := 0; i < 100; i++ {
+		now := start.Add(time.Duration(i) * time.Second)
+		if !c.markSeen(strconv.Itoa(i), now) {
+			t.Fatalf("Expected nonce %d to be new", i)
+		}
+	}
+
+	// Nonces seen more than the window ago are forgotten.
+	later := start.Add(c.Window + 50*time.Second + time.Millisecond)
+	if !c.markSeen("new", later) {
+		t.Errorf("Expected nonce to be new")
+	}
+	if len(c.seen) != 50 || len(c.expiry) != 50 {
+		t.Errorf("Expected 50 remembered nonces, got %d and %d", len(c.seen), len(c.expiry))
+	}
+	if c.markSeen("99", later) {
+		t.Errorf("Expected recent nonce to be remembered")
+	}
+}
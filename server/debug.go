@@ -0,0 +1,76 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var started = time.Now()
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("uptime_seconds", expvar.Func(func() interface{} {
+		return int64(time.Since(started).Seconds())
+	}))
+}
+
+// debug serves the runtime diagnostics under AdminPath/debug:
+//
+//	/debug/pprof/        pprof profiles, as served by net/http/pprof
+//	/debug/vars          expvar counters, including goroutines and memstats
+//	/debug/goroutines    a dump of every goroutine's stack, showing the
+//	                     goroutines waiting on locks
+//
+// Mutex and block profiles are empty unless the process enables them with
+// runtime.SetMutexProfileFraction and runtime.SetBlockProfileRate.
+func (s *Admin) debug(w http.ResponseWriter, r *http.Request, call, account string) {
+	if !s.Debug {
+		http.NotFound(w, r)
+		return
+	}
+	if account != "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	s.Log.Info("admin call", "call", "debug"+call,
+		"remote", clientIP(r), "client", clientCertName(r))
+
+	switch {
+	case call == "/vars":
+		expvar.Handler().ServeHTTP(w, r)
+	case call == "/goroutines":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		pprof.Handler("goroutine").ServeHTTP(w, withDebug(r, "2"))
+	case call == "/pprof" || call == "/pprof/":
+		pprof.Index(w, r)
+	case call == "/pprof/cmdline":
+		pprof.Cmdline(w, r)
+	case call == "/pprof/profile":
+		pprof.Profile(w, r)
+	case call == "/pprof/symbol":
+		pprof.Symbol(w, r)
+	case call == "/pprof/trace":
+		pprof.Trace(w, r)
+	case strings.HasPrefix(call, "/pprof/"):
+		pprof.Handler(strings.TrimPrefix(call, "/pprof/")).ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// withDebug returns r with the debug query parameter set, which selects the
+// text format of a pprof profile.
+func withDebug(r *http.Request, level string) *http.Request {
+	r2 := r.Clone(r.Context())
+	q := r2.URL.Query()
+	q.Set("debug", level)
+	r2.URL.RawQuery = q.Encode()
+	return r2
+}
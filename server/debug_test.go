@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/luno/moonbeam/receiver"
+)
+
+func TestAdminDebug(t *testing.T) {
+	h := NewAdmin(&fakeAdmin{}, "secret")
+	h.Keys = fakeKeys{}
+
+	w := call(h, http.MethodGet, AdminPath+"/debug/vars", "secret", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 with debug disabled, got %d", w.Code)
+	}
+
+	h.Debug = true
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		code  int
+		body  string
+	}{
+		{"vars", "/debug/vars", "secret", http.StatusOK, `"goroutines"`},
+		{"goroutines", "/debug/goroutines", "secret", http.StatusOK, "goroutine "},
+		{"heap profile", "/debug/pprof/heap?debug=1", "secret", http.StatusOK, "heap profile"},
+		{"index", "/debug/pprof/", "secret", http.StatusOK, "goroutine"},
+		{"read key", "/debug/vars", receiver.ScopeAdminRead, http.StatusUnauthorized, ""},
+		{"write key", "/debug/vars", receiver.ScopeAdminWrite, http.StatusOK, "memstats"},
+		{"unknown", "/debug/foo", "secret", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := call(h, http.MethodGet, AdminPath+test.path, test.token, "")
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("%s: expected %q in body", test.name, test.body)
+		}
+	}
+}
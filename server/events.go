@@ -0,0 +1,108 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/luno/moonbeam/receiver"
+)
+
+// EventSource is a source of channel activity. It is implemented by
+// receiver.Receiver.
+type EventSource interface {
+	Subscribe() (<-chan receiver.Event, func())
+}
+
+// heartbeatInterval is how often a comment is sent on idle streams so that
+// proxies don't close the connection.
+const heartbeatInterval = 30 * time.Second
+
+// EventStream serves channel activity as server-sent events. Each event is
+// sent with its type as the event name and the JSON encoded event as data.
+//
+// The stream includes activity for all channels, so it should only be
+// exposed to the receiver's operator.
+type EventStream struct {
+	src   EventSource
+	token string
+}
+
+// NewEventStream returns a handler that streams events from src. Clients
+// must present token either in a Bearer Authorization header or, since
+// browsers can't set headers on EventSource requests, in the token query
+// parameter.
+func NewEventStream(src EventSource, token string) *EventStream {
+	return &EventStream{src: src, token: token}
+}
+
+func (s *EventStream) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return false
+	}
+	token := r.URL.Query().Get("token")
+	const prefix = "Bearer "
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, prefix) {
+		token = h[len(prefix):]
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+func (s *EventStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		http.Error(w, "invalid auth token", http.StatusUnauthorized)
+		return
+	}
+
+	// Streams are long lived so the server's write timeout doesn't apply.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+
+	events, cancel := s.src.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	t := time.NewTicker(heartbeatInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-t.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			buf, err := json.Marshal(e)
+			if err != nil {
+				slog.Error("json encode failed", "err", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, buf); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/receiver"
+)
+
+type fakeSource struct {
+	ch chan receiver.Event
+}
+
+func (f *fakeSource) Subscribe() (<-chan receiver.Event, func()) {
+	return f.ch, func() {}
+}
+
+func TestEventStreamAuth(t *testing.T) {
+	h := NewEventStream(&fakeSource{}, "secret")
+
+	for _, path := range []string{"/events", "/events?token=wrong"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %d", path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/events?token=secret", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", w.Code)
+	}
+
+	// An empty token disables the stream.
+	h = NewEventStream(&fakeSource{}, "")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events?token=", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with no token configured, got %d", w.Code)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	src := &fakeSource{ch: make(chan receiver.Event, 1)}
+	ts := httptest.NewServer(NewEventStream(src, "secret"))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+
+	src.ch <- receiver.Event{
+		Type:      receiver.EventPayment,
+		ChannelID: testTxID + "-0",
+		Amount:    1000,
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	var lines []string
+	for sc.Scan() {
+		if sc.Text() == "" {
+			break
+		}
+		lines = append(lines, sc.Text())
+	}
+	if len(lines) != 2 || lines[0] != "event: payment" {
+		t.Fatalf("Unexpected event: %q", lines)
+	}
+
+	var e receiver.Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Amount != 1000 || e.ChannelID != testTxID+"-0" {
+		t.Errorf("Unexpected event: %+v", e)
+	}
+}
@@ -0,0 +1,379 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/receiver"
+	"github.com/luno/moonbeam/rpcpb"
+)
+
+// ReasonMetadata is the trailer metadata key carrying the reason for a
+// rejected payment, the Reason of the HTTP API's ErrorResponse.
+const ReasonMetadata = "moonbeam-reason"
+
+// AccountMetadata is the metadata key selecting the account whose channels
+// a call is made for, like the HTTP API's AccountPath.
+const AccountMetadata = "moonbeam-account"
+
+// GRPC serves the receiver API as the rpcpb.Receiver gRPC service.
+//
+// Except for Create and Open, calls must carry the channel's auth token in
+// an "authorization" metadata entry of the form "Bearer <token>". API keys
+// and request signatures aren't supported, so servers requiring them
+// shouldn't serve GRPC.
+type GRPC struct {
+	rpcpb.UnimplementedReceiverServer
+
+	r Receiver
+
+	// Log receives call errors at debug level.
+	Log *slog.Logger
+
+	// CreateLimit, ChannelLimit and IPLimit limit calls as they do for RPC.
+	CreateLimit  *IPLimiter
+	ChannelLimit *RateLimiter
+	IPLimit      *RateLimiter
+}
+
+// NewGRPC returns a gRPC service for the receiver API.
+func NewGRPC(r Receiver) *GRPC {
+	return &GRPC{r: r, Log: slog.Default()}
+}
+
+// Register adds the service to s.
+func (g *GRPC) Register(s *grpc.Server) {
+	rpcpb.RegisterReceiverServer(s, g)
+}
+
+// ServeGRPC serves g on c.Addr until ctx is cancelled, with the TLS
+// certificate of c if it has one. It then waits up to c.ShutdownTimeout for
+// in-flight calls to finish.
+func ServeGRPC(ctx context.Context, c Config, g *GRPC) error {
+	var opts []grpc.ServerOption
+	if c.TLSCert != "" {
+		tc, err := tlsConfig(c)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tc)))
+	}
+	lis, err := net.Listen("tcp", c.Addr)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer(opts...)
+	g.Register(s)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Serve(lis)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(c.ShutdownTimeout):
+		s.Stop()
+	}
+	return nil
+}
+
+// callContext applies the account metadata of the call to ctx.
+func callContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var account string
+	if v := md.Get(AccountMetadata); len(v) > 0 {
+		account = v[0]
+	}
+	return receiver.WithAccount(ctx, account)
+}
+
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+func (g *GRPC) allowCreate(ctx context.Context) error {
+	if g.CreateLimit == nil || g.CreateLimit.Allow(peerIP(ctx), time.Now()) {
+		return nil
+	}
+	return status.Error(codes.ResourceExhausted, "too many requests")
+}
+
+// checkCall checks the rate limits and auth token of a call on a channel.
+func (g *GRPC) checkCall(ctx context.Context, txid string, vout uint32) error {
+	now := time.Now()
+	for _, l := range []struct {
+		limiter *RateLimiter
+		key     string
+	}{{g.ChannelLimit, txid + "-" + strconv.Itoa(int(vout))}, {g.IPLimit, peerIP(ctx)}} {
+		if l.limiter == nil {
+			continue
+		}
+		if ok, _ := l.limiter.Allow(l.key, now); !ok {
+			return status.Error(codes.ResourceExhausted, "too many requests")
+		}
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	const prefix = "Bearer "
+	if v := md.Get("authorization"); len(v) > 0 && strings.HasPrefix(v[0], prefix) {
+		token = v[0][len(prefix):]
+	}
+	if token == "" || !g.r.ValidateToken(txid, vout, token) {
+		return status.Error(codes.Unauthenticated, "invalid auth token")
+	}
+	return nil
+}
+
+// status converts an error returned by the receiver to a gRPC status error,
+// mapping the HTTP API's status codes to their gRPC equivalents.
+func (g *GRPC) status(ctx context.Context, err error) error {
+	g.Log.Debug("grpc error", "err", err)
+
+	if err == receiver.ErrShuttingDown {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	switch e := err.(type) {
+	case receiver.ExposableError:
+		if e.Reason() != "" {
+			grpc.SetTrailer(ctx, metadata.Pairs(ReasonMetadata, string(e.Reason())))
+		}
+		return status.Error(codes.InvalidArgument, e.Error())
+	case receiver.FundingRangeError:
+		return status.Error(codes.InvalidArgument, e.Error())
+	case receiver.ScreeningError:
+		// Don't reveal why the address was flagged.
+		return status.Error(codes.PermissionDenied, "address rejected")
+	default:
+		return status.Error(codes.Internal, "error")
+	}
+}
+
+func (g *GRPC) Create(ctx context.Context, req *rpcpb.CreateRequest) (*rpcpb.CreateResponse, error) {
+	if err := g.allowCreate(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := g.r.Create(callContext(ctx), models.CreateRequest{
+		Version:      int(req.Version),
+		Net:          req.Net,
+		SenderPubKey: req.SenderPubKey,
+		SenderOutput: req.SenderOutput,
+		AppData:      req.AppData,
+		Revocable:    req.Revocable,
+		Labels:       req.Labels,
+	})
+	if err != nil {
+		return nil, g.status(ctx, err)
+	}
+	return &rpcpb.CreateResponse{
+		Version:        int32(resp.Version),
+		Net:            resp.Net,
+		Timeout:        resp.Timeout,
+		Fee:            resp.Fee,
+		MinPayment:     resp.MinPayment,
+		MaxPayment:     resp.MaxPayment,
+		ReceiverPubKey: resp.ReceiverPubKey,
+		ReceiverOutput: resp.ReceiverOutput,
+		FundingAddress: resp.FundingAddress,
+		AppData:        resp.AppData,
+		Revocable:      resp.Revocable,
+		ReceiverData:   resp.ReceiverData,
+	}, nil
+}
+
+func (g *GRPC) Open(ctx context.Context, req *rpcpb.OpenRequest) (*rpcpb.OpenResponse, error) {
+	if err := g.allowCreate(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := g.r.Open(callContext(ctx), models.OpenRequest{
+		TxID:           req.Txid,
+		Vout:           req.Vout,
+		ReceiverData:   req.ReceiverData,
+		Version:        int(req.Version),
+		Net:            req.Net,
+		Timeout:        req.Timeout,
+		Fee:            req.Fee,
+		MinPayment:     req.MinPayment,
+		MaxPayment:     req.MaxPayment,
+		SenderPubKey:   req.SenderPubKey,
+		SenderOutput:   req.SenderOutput,
+		ReceiverPubKey: req.ReceiverPubKey,
+		ReceiverOutput: req.ReceiverOutput,
+		AppData:        req.AppData,
+		Revocable:      req.Revocable,
+		RevocationHash: req.RevocationHash,
+		SenderSig:      req.SenderSig,
+	})
+	if err != nil {
+		return nil, g.status(ctx, err)
+	}
+	return &rpcpb.OpenResponse{
+		AuthToken:     resp.AuthToken,
+		CommitmentSig: resp.CommitmentSig,
+	}, nil
+}
+
+func (g *GRPC) Validate(ctx context.Context, req *rpcpb.ValidateRequest) (*rpcpb.ValidateResponse, error) {
+	if err := g.checkCall(ctx, req.Txid, req.Vout); err != nil {
+		return nil, err
+	}
+	resp, err := g.r.Validate(callContext(ctx), models.ValidateRequest{
+		TxID:    req.Txid,
+		Vout:    req.Vout,
+		Payment: req.Payment,
+	})
+	if err != nil {
+		return nil, g.status(ctx, err)
+	}
+	return &rpcpb.ValidateResponse{
+		Valid:  resp.Valid,
+		Reason: string(resp.Reason),
+	}, nil
+}
+
+func (g *GRPC) Send(ctx context.Context, req *rpcpb.SendRequest) (*rpcpb.SendResponse, error) {
+	if err := g.checkCall(ctx, req.Txid, req.Vout); err != nil {
+		return nil, err
+	}
+	var feeSigs []models.FeeSig
+	for _, fs := range req.FeeSigs {
+		feeSigs = append(feeSigs, models.FeeSig{Fee: fs.Fee, Sig: fs.Sig})
+	}
+	resp, err := g.r.Send(callContext(ctx), models.SendRequest{
+		TxID:             req.Txid,
+		Vout:             req.Vout,
+		Payment:          req.Payment,
+		SenderSig:        req.SenderSig,
+		HoldID:           req.HoldId,
+		PaymentID:        req.PaymentId,
+		InvoiceID:        req.InvoiceId,
+		FeeSigs:          feeSigs,
+		RevocationHash:   req.RevocationHash,
+		RevocationSecret: req.RevocationSecret,
+	})
+	if err != nil {
+		return nil, g.status(ctx, err)
+	}
+	out := &rpcpb.SendResponse{CommitmentSig: resp.CommitmentSig}
+	if rc := resp.Receipt; rc != nil {
+		out.Receipt = &rpcpb.Receipt{
+			ChannelId:      rc.ChannelID,
+			Count:          int64(rc.Count),
+			Amount:         rc.Amount,
+			Target:         rc.Target,
+			PaymentsHash:   rc.PaymentsHash,
+			ReceiverPubKey: rc.ReceiverPubKey,
+			Signature:      rc.Signature,
+		}
+	}
+	return out, nil
+}
+
+func (g *GRPC) Hold(ctx context.Context, req *rpcpb.HoldRequest) (*rpcpb.HoldResponse, error) {
+	if err := g.checkCall(ctx, req.Txid, req.Vout); err != nil {
+		return nil, err
+	}
+	_, err := g.r.Hold(callContext(ctx), models.HoldRequest{
+		TxID:   req.Txid,
+		Vout:   req.Vout,
+		HoldID: req.HoldId,
+		Amount: req.Amount,
+	})
+	if err != nil {
+		return nil, g.status(ctx, err)
+	}
+	return &rpcpb.HoldResponse{}, nil
+}
+
+func (g *GRPC) Release(ctx context.Context, req *rpcpb.ReleaseRequest) (*rpcpb.ReleaseResponse, error) {
+	if err := g.checkCall(ctx, req.Txid, req.Vout); err != nil {
+		return nil, err
+	}
+	_, err := g.r.Release(callContext(ctx), models.ReleaseRequest{
+		TxID:   req.Txid,
+		Vout:   req.Vout,
+		HoldID: req.HoldId,
+	})
+	if err != nil {
+		return nil, g.status(ctx, err)
+	}
+	return &rpcpb.ReleaseResponse{}, nil
+}
+
+func (g *GRPC) Close(ctx context.Context, req *rpcpb.CloseRequest) (*rpcpb.CloseResponse, error) {
+	if err := g.checkCall(ctx, req.Txid, req.Vout); err != nil {
+		return nil, err
+	}
+	resp, err := g.r.Close(callContext(ctx), models.CloseRequest{
+		TxID:    req.Txid,
+		Vout:    req.Vout,
+		FeeRate: req.FeeRate,
+	})
+	if err != nil {
+		return nil, g.status(ctx, err)
+	}
+	return &rpcpb.CloseResponse{
+		CloseTx: resp.CloseTx,
+		Fee:     resp.Fee,
+	}, nil
+}
+
+func (g *GRPC) Status(ctx context.Context, req *rpcpb.StatusRequest) (*rpcpb.StatusResponse, error) {
+	if err := g.checkCall(ctx, req.Txid, req.Vout); err != nil {
+		return nil, err
+	}
+	resp, err := g.r.Status(callContext(ctx), models.StatusRequest{
+		TxID: req.Txid,
+		Vout: req.Vout,
+	})
+	if err != nil {
+		return nil, g.status(ctx, err)
+	}
+	return &rpcpb.StatusResponse{
+		Status:          int32(resp.Status),
+		Balance:         resp.Balance,
+		PaymentsHash:    resp.PaymentsHash,
+		AppData:         resp.AppData,
+		Held:            resp.Held,
+		CommitmentSig:   resp.CommitmentSig,
+		FundingTxid:     resp.FundingTxID,
+		FundingVout:     resp.FundingVout,
+		FundingAmount:   resp.FundingAmount,
+		Available:       resp.Available,
+		Count:           int64(resp.Count),
+		BlocksRemaining: resp.BlocksRemaining,
+		Labels:          resp.Labels,
+	}, nil
+}
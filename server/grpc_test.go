@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/receiver"
+	"github.com/luno/moonbeam/rpcpb"
+)
+
+func newGRPCClient(t *testing.T, g *GRPC) rpcpb.ReceiverClient {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	g.Register(s)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return rpcpb.NewReceiverClient(conn)
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestGRPC(t *testing.T) {
+	f := &fakeReceiver{}
+	c := newGRPCClient(t, NewGRPC(f))
+
+	cr, err := c.Create(context.Background(), &rpcpb.CreateRequest{Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.Version != 1 {
+		t.Errorf("Unexpected response: %+v", cr)
+	}
+
+	or, err := c.Open(context.Background(), &rpcpb.OpenRequest{Txid: testTxID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if or.AuthToken != "token" {
+		t.Errorf("Expected auth token, got %q", or.AuthToken)
+	}
+
+	req := &rpcpb.SendRequest{Txid: testTxID, Payment: []byte("payment")}
+	if _, err := c.Send(context.Background(), req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated without token, got %v", err)
+	}
+	if _, err := c.Send(withToken("wrong"), req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated with bad token, got %v", err)
+	}
+	if _, err := c.Send(withToken("token"), req); err != nil {
+		t.Errorf("Expected send to succeed, got %v", err)
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	f := &fakeReceiver{}
+	c := newGRPCClient(t, NewGRPC(f))
+	req := &rpcpb.SendRequest{Txid: testTxID}
+
+	tests := []struct {
+		name   string
+		err    error
+		code   codes.Code
+		reason string
+	}{
+		{"exposable", receiver.ErrFrozen, codes.InvalidArgument, string(models.ReasonChannelNotOpen)},
+		{"no reason", receiver.NewExposableError("no reason"), codes.InvalidArgument, ""},
+		{"funding range", receiver.FundingRangeError{Value: 1, Min: 1000}, codes.InvalidArgument, ""},
+		{"screening", receiver.ScreeningError{Address: "addr", Hit: receiver.ScreeningHit{Reason: "secret list"}}, codes.PermissionDenied, ""},
+		{"shutting down", receiver.ErrShuttingDown, codes.Unavailable, ""},
+		{"internal", errors.New("secret internal detail"), codes.Internal, ""},
+	}
+	for _, test := range tests {
+		f.sendErr = test.err
+		var trailer metadata.MD
+		_, err := c.Send(withToken("token"), req, grpc.Trailer(&trailer))
+		st, _ := status.FromError(err)
+		if st.Code() != test.code {
+			t.Errorf("%s: expected %v, got %v", test.name, test.code, st.Code())
+		}
+		var reason string
+		if v := trailer.Get(ReasonMetadata); len(v) > 0 {
+			reason = v[0]
+		}
+		if reason != test.reason {
+			t.Errorf("%s: expected reason %q, got %q", test.name, test.reason, reason)
+		}
+		if test.code == codes.PermissionDenied || test.code == codes.Internal {
+			if st.Message() == test.err.Error() {
+				t.Errorf("%s: error leaked: %q", test.name, st.Message())
+			}
+		}
+	}
+}
+
+func TestGRPCChannelLimit(t *testing.T) {
+	g := NewGRPC(&fakeReceiver{})
+	g.ChannelLimit = NewRateLimiter(1, 1)
+	c := newGRPCClient(t, g)
+
+	req := &rpcpb.SendRequest{Txid: testTxID}
+	if _, err := c.Send(withToken("token"), req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Send(withToken("token"), req); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/luno/moonbeam/receiver"
+)
+
+// HealthChecker checks the receiver's dependencies. It is implemented by
+// receiver.Receiver.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context, ready bool) []receiver.HealthCheck
+}
+
+// Health serves /healthz and /readyz for orchestrators. /healthz fails if
+// the receiver's keys or storage are unavailable, in which case it should
+// be restarted. /readyz also fails if the chain backend is unreachable or
+// no new block has been seen recently, in which case it shouldn't be sent
+// traffic.
+//
+// The endpoints are unauthenticated, so failure details are only logged.
+type Health struct {
+	hc HealthChecker
+
+	// Log receives failed checks.
+	Log *slog.Logger
+}
+
+// NewHealth returns a handler for the health endpoints.
+func NewHealth(hc HealthChecker) *Health {
+	return &Health{hc: hc, Log: slog.Default()}
+}
+
+func (h *Health) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, false)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, true)
+	})
+}
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func (h *Health) serve(w http.ResponseWriter, r *http.Request, ready bool) {
+	resp := healthResponse{Status: "ok", Checks: make(map[string]string)}
+	for _, c := range h.hc.CheckHealth(r.Context(), ready) {
+		if c.Err != nil {
+			h.Log.Warn("health check failed", "check", c.Name, "err", c.Err)
+			resp.Checks[c.Name] = "fail"
+			resp.Status = "fail"
+		} else {
+			resp.Checks[c.Name] = "ok"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.Log.Error("json encode failed", "err", err)
+	}
+}
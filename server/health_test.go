@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/luno/moonbeam/receiver"
+)
+
+type fakeHealth struct {
+	chainErr error
+}
+
+func (f fakeHealth) CheckHealth(ctx context.Context, ready bool) []receiver.HealthCheck {
+	checks := []receiver.HealthCheck{{Name: "storage"}}
+	if ready {
+		checks = append(checks, receiver.HealthCheck{Name: "chain", Err: f.chainErr})
+	}
+	return checks
+}
+
+func TestHealth(t *testing.T) {
+	mux := http.NewServeMux()
+	NewHealth(fakeHealth{chainErr: errors.New("connection refused to 10.0.0.1")}).Register(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected healthy, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected not ready, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "10.0.0.1") {
+		t.Errorf("Failure details leaked: %q", w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"chain":"fail"`) {
+		t.Errorf("Expected failed check in body, got %q", w.Body.String())
+	}
+}
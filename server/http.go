@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Config holds the settings for the HTTP server.
+type Config struct {
+	// Addr is the TCP address to listen on.
+	Addr string
+
+	// TLSCert and TLSKey are the paths of the TLS certificate and key. If
+	// TLSCert is empty, the server listens over plain HTTP. The files are
+	// reloaded when they change.
+	TLSCert string
+	TLSKey  string
+
+	// ClientCAs is the path of PEM encoded CA certificates used to verify
+	// client certificates. Clients aren't required to present one, but
+	// handlers such as Admin can require it.
+	ClientCAs string
+
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// ShutdownTimeout is how long to wait for in-flight requests to finish
+	// once the server is stopped.
+	ShutdownTimeout time.Duration
+}
+
+// DefaultConfig returns a config with reasonable timeouts for addr.
+func DefaultConfig(addr string) Config {
+	return Config{
+		Addr:            addr,
+		ReadTimeout:     10 * time.Second,
+		WriteTimeout:    30 * time.Second,
+		IdleTimeout:     2 * time.Minute,
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+// ListenAndServe serves h until ctx is cancelled. It then stops accepting
+// new connections and waits up to c.ShutdownTimeout for in-flight requests
+// to finish. It returns nil if the server shut down cleanly.
+func ListenAndServe(ctx context.Context, c Config, h http.Handler) error {
+	srv := &http.Server{
+		Addr:         c.Addr,
+		Handler:      h,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+		IdleTimeout:  c.IdleTimeout,
+	}
+
+	if c.TLSCert != "" {
+		tc, err := tlsConfig(c)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = tc
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		if c.TLSCert == "" {
+			errc <- srv.ListenAndServe()
+		} else {
+			errc <- srv.ListenAndServeTLS("", "")
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		return err
+	}
+	if err := <-errc; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IPLimiter limits the number of requests from each client IP within a
+// fixed window.
+type IPLimiter struct {
+	max    int
+	window time.Duration
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[string]int
+}
+
+// NewIPLimiter returns a limiter that allows max requests per IP every
+// window.
+func NewIPLimiter(max int, window time.Duration) *IPLimiter {
+	return &IPLimiter{
+		max:    max,
+		window: window,
+		counts: make(map[string]int),
+	}
+}
+
+// Allow records a request from ip and reports whether it is within the
+// limit.
+func (l *IPLimiter) Allow(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.start) >= l.window {
+		l.start = now
+		l.counts = make(map[string]int)
+	}
+	if l.counts[ip] >= l.max {
+		return false
+	}
+	l.counts[ip]++
+	return true
+}
+
+// clientIP returns the IP of the connecting client. Forwarding headers
+// aren't trusted.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RateLimiter is a token bucket rate limiter with a bucket per key, such as
+// a channel ID or client IP. Each bucket holds up to burst tokens and is
+// refilled at rate tokens per second.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// sweepInterval is how often full buckets are discarded.
+const sweepInterval = time.Minute
+
+// NewRateLimiter returns a limiter allowing rate requests per second per
+// key, with bursts of up to burst requests.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (l *RateLimiter) refill(b *bucket, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+}
+
+// Allow takes a token from key's bucket and reports whether one was
+// available. If not, it returns how long until one will be.
+func (l *RateLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep discards full buckets, which are equivalent to missing ones.
+func (l *RateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
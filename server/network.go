@@ -0,0 +1,20 @@
+package server
+
+import (
+	"net/http"
+)
+
+// NetworkPath returns the path prefix under which the handlers of an
+// additional network are served by the same process, e.g. /testnet3, so that
+// its senders use NetworkPath("testnet3")+RPCPath.
+func NetworkPath(name string) string {
+	return "/" + name
+}
+
+// MountNetwork serves h, which has the RPC, admin and health handlers of
+// another network's receiver registered at their usual paths, under
+// NetworkPath(name) on mux.
+func MountNetwork(mux *http.ServeMux, name string, h http.Handler) {
+	p := NetworkPath(name)
+	mux.Handle(p+"/", http.StripPrefix(p, h))
+}
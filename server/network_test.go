@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/luno/moonbeam/receiver"
+)
+
+func TestMountNetwork(t *testing.T) {
+	main := &fakeReceiver{}
+	test := &fakeReceiver{sendErr: receiver.ErrFrozen}
+
+	mux := http.NewServeMux()
+	NewRPC(main).Register(mux)
+	tm := http.NewServeMux()
+	NewRPC(test).Register(tm)
+	MountNetwork(mux, "testnet3", tm)
+
+	path := RPCPath + "/send/" + testTxID + "-0"
+	body := `{"txid":"` + testTxID + `","vout":0}`
+
+	w := call(mux, http.MethodPost, path, "token", body)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected main network to accept payment, got %d", w.Code)
+	}
+	w = call(mux, http.MethodPost, NetworkPath("testnet3")+path, "token", body)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected testnet3 receiver to handle call, got %d", w.Code)
+	}
+	w = call(mux, http.MethodPost, NetworkPath("regtest")+path, "token", body)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown network, got %d", w.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/luno/moonbeam/logging"
+	"github.com/luno/moonbeam/trace"
+)
+
+// RequestIDHeader carries the ID of a request. An ID set by the client or a
+// proxy in front of the server is kept, otherwise one is generated. It's
+// returned in the response either way.
+const RequestIDHeader = logging.RequestIDHeader
+
+// maxRequestIDLen limits the length of request IDs accepted from clients.
+const maxRequestIDLen = 64
+
+// LogRequests wraps h so that each request is given an ID, carried by its
+// context to the receiver, storage and chain backend calls and webhook
+// events it causes, and is logged once it's served with its method, path,
+// channel, status and latency.
+func LogRequests(log *slog.Logger, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			var err error
+			id, err = logging.NewRequestID()
+			if err != nil {
+				log.Error("request id failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx, span := trace.Start(logging.WithRequestID(r.Context(), id), "http")
+		span.SetAttribute("request_id", id)
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+
+		attrs := []interface{}{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"duration", time.Since(start),
+			"remote", clientIP(r),
+		}
+		if ch := channelFromPath(r.URL.Path); ch != "" {
+			attrs = append(attrs, "channel", ch)
+		}
+		level := slog.LevelInfo
+		if sw.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		log.Log(ctx, level, "request", attrs...)
+	})
+}
+
+// validRequestID reports whether id can be used as a request ID.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		ok := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.'
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// channelFromPath returns the channel ID at the end of an RPC or admin call
+// path, if any.
+func channelFromPath(path string) string {
+	id := path[strings.LastIndex(path, "/")+1:]
+	if _, _, ok := ParseChannelID(id); !ok {
+		return ""
+	}
+	return id
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.status = code
+		w.wrote = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets streaming handlers such as EventStream flush the response
+// through http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/luno/moonbeam/logging"
+	"github.com/luno/moonbeam/models"
+)
+
+type idReceiver struct {
+	fakeReceiver
+	id string
+}
+
+func (f *idReceiver) Send(ctx context.Context, req models.SendRequest) (*models.SendResponse, error) {
+	f.id = logging.RequestID(ctx)
+	return &models.SendResponse{}, nil
+}
+
+func TestLogRequests(t *testing.T) {
+	var buf bytes.Buffer
+	log := logging.New(&buf, slog.LevelInfo, false)
+	f := &idReceiver{}
+	mux := http.NewServeMux()
+	NewRPC(f).Register(mux)
+	h := LogRequests(log, mux)
+
+	id := testTxID + "-0"
+	body := `{"txid":"` + testTxID + `","vout":0}`
+	w := call(h, http.MethodPost, RPCPath+"/send/"+id, "token", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	reqID := w.Header().Get(RequestIDHeader)
+	if reqID == "" || f.id != reqID {
+		t.Errorf("Expected request ID %q to reach the receiver, got %q", reqID, f.id)
+	}
+	out := buf.String()
+	for _, s := range []string{"request_id=" + reqID, "channel=" + id, "status=200", "method=POST", "duration="} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Expected %q in log %q", s, out)
+		}
+	}
+
+	req := newTestRequest(http.MethodPost, RPCPath+"/send/"+id, "token", body)
+	req.Header.Set(RequestIDHeader, "upstream-1")
+	serve(h, req)
+	if f.id != "upstream-1" {
+		t.Errorf("Expected client request ID to be kept, got %q", f.id)
+	}
+
+	req = newTestRequest(http.MethodPost, RPCPath+"/send/"+id, "token", body)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	serve(h, req)
+	if f.id == "bad id\n" || f.id == "" {
+		t.Errorf("Expected invalid request ID to be replaced, got %q", f.id)
+	}
+}
@@ -0,0 +1,443 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luno/moonbeam/logging"
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/receiver"
+	"github.com/luno/moonbeam/trace"
+)
+
+// RPCPath is the path under which the receiver API is served.
+const RPCPath = "/moonbeamrpc"
+
+// maxRequestSize limits the size of request bodies. Requests are small JSON
+// objects so anything larger is rejected before it is parsed.
+const maxRequestSize = 1 << 20
+
+// Receiver is the receiver API exposed over HTTP. It is implemented by
+// receiver.Receiver.
+type Receiver interface {
+	Create(ctx context.Context, req models.CreateRequest) (*models.CreateResponse, error)
+	Open(ctx context.Context, req models.OpenRequest) (*models.OpenResponse, error)
+	Validate(ctx context.Context, req models.ValidateRequest) (*models.ValidateResponse, error)
+	Send(ctx context.Context, req models.SendRequest) (*models.SendResponse, error)
+	Hold(ctx context.Context, req models.HoldRequest) (*models.HoldResponse, error)
+	Release(ctx context.Context, req models.ReleaseRequest) (*models.ReleaseResponse, error)
+	Close(ctx context.Context, req models.CloseRequest) (*models.CloseResponse, error)
+	Status(ctx context.Context, req models.StatusRequest) (*models.StatusResponse, error)
+	ValidateToken(txid string, vout uint32, token string) bool
+}
+
+// AccountPath returns the endpoint of the receiver API for an account's
+// channels.
+func AccountPath(id string) string {
+	return RPCPath + "/account/" + id
+}
+
+// RPC serves the receiver API with JSON request and response bodies.
+//
+// Channels are created with a POST to RPCPath/create. All other calls are
+// made to RPCPath/<call>/<txid>-<vout>. Except for open, they must carry the
+// channel's auth token in a Bearer Authorization header. Calls for an
+// account's channels are made under AccountPath instead of RPCPath.
+type RPC struct {
+	r Receiver
+
+	// Log receives request logs at debug level, with secrets redacted.
+	Log *slog.Logger
+
+	// Keys, if set, requires every call to carry an API key with the rpc
+	// scope in the X-API-Key header.
+	Keys KeyChecker
+
+	// CreateLimit, if set, limits create and open calls per client IP, so
+	// that a single client can't exhaust the receiver's storage.
+	CreateLimit *IPLimiter
+
+	// ChannelLimit and IPLimit, if set, limit calls on existing channels per
+	// channel and per client IP, since validating payments is expensive.
+	ChannelLimit *RateLimiter
+	IPLimit      *RateLimiter
+
+	// Signatures, if set, requires open, send and status calls to be signed
+	// by the channel's sender key.
+	Signatures *SigVerifier
+}
+
+// NewRPC returns a handler for the receiver API.
+func NewRPC(r Receiver) *RPC {
+	return &RPC{r: r, Log: slog.Default()}
+}
+
+func (s *RPC) parse(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	_, ok := s.parseBody(w, r, req)
+	return ok
+}
+
+// parseBody is like parse but also returns the raw request body.
+func (s *RPC) parseBody(w http.ResponseWriter, r *http.Request, req interface{}) ([]byte, bool) {
+	buf, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+
+	if s.Log.Enabled(r.Context(), slog.LevelDebug) {
+		s.Log.Debug("rpc request body", "body", logging.RedactJSON(buf))
+	}
+
+	if err := json.Unmarshal(buf, &req); err != nil {
+		http.Error(w, "json parse error", http.StatusBadRequest)
+		return nil, false
+	}
+	return buf, true
+}
+
+// ParseChannelID parses a channel ID of the form <txid>-<vout>.
+func ParseChannelID(txidvout string) (string, uint32, bool) {
+	i := strings.Index(txidvout, "-")
+	if i < 0 {
+		return "", 0, false
+	}
+
+	txid := txidvout[:i]
+	vouts := txidvout[i+1:]
+
+	if len(txid) != 64 {
+		return "", 0, false
+	}
+	if txid != strings.ToLower(txid) {
+		return "", 0, false
+	}
+	if _, err := hex.DecodeString(txid); err != nil {
+		return "", 0, false
+	}
+
+	if len(vouts) == 0 {
+		return "", 0, false
+	}
+	vout, err := strconv.ParseUint(vouts, 10, 32)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return txid, uint32(vout), true
+}
+
+func checkID(w http.ResponseWriter, atxid string, avout uint32, btxid string, bvout uint32) bool {
+	if !(atxid == btxid && avout == bvout) {
+		http.Error(w, "URL doesn't match channel ID", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (s *RPC) checkAuthToken(r *http.Request, txid string, vout uint32) bool {
+	h := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(h, prefix) {
+		return false
+	}
+	h = h[len(prefix):]
+	return s.r.ValidateToken(txid, vout, h)
+}
+
+func (s *RPC) respond(w http.ResponseWriter, resp interface{}, err error) {
+	if err != nil {
+		s.Log.Debug("rpc error", "err", err)
+
+		if err == receiver.ErrShuttingDown {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		switch e := err.(type) {
+		case receiver.ExposableError:
+			if e.Reason() != "" {
+				// Rejected payments get a reason senders can act on.
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(models.ErrorResponse{
+					Error:  e.Error(),
+					Reason: e.Reason(),
+				})
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case receiver.FundingRangeError:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case receiver.ScreeningError:
+			// Don't reveal why the address was flagged.
+			http.Error(w, "address rejected", http.StatusForbidden)
+		default:
+			http.Error(w, "error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.Log.Error("json encode failed", "err", err)
+	}
+}
+
+// allowCreate reports whether the client may create or open another channel,
+// responding with an error if not.
+func (s *RPC) allowCreate(w http.ResponseWriter, r *http.Request) bool {
+	if s.CreateLimit == nil || s.CreateLimit.Allow(clientIP(r), time.Now()) {
+		return true
+	}
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+	return false
+}
+
+// allowCall reports whether a call on the channel is within the rate
+// limits, responding with an error if not.
+func (s *RPC) allowCall(w http.ResponseWriter, r *http.Request, id string) bool {
+	now := time.Now()
+	for _, l := range []struct {
+		limiter *RateLimiter
+		key     string
+	}{{s.ChannelLimit, id}, {s.IPLimit, clientIP(r)}} {
+		if l.limiter == nil {
+			continue
+		}
+		if ok, wait := l.limiter.Allow(l.key, now); !ok {
+			secs := int(wait.Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return false
+		}
+	}
+	return true
+}
+
+// checkSig reports whether the call carries a valid sender signature,
+// responding with an error if not. If pubKey is nil, the channel's stored
+// sender key is used.
+func (s *RPC) checkSig(w http.ResponseWriter, r *http.Request, txid string, vout uint32,
+	call string, pubKey, body []byte) bool {
+
+	if s.Signatures == nil || s.Signatures.verify(r, txid, vout, call, pubKey, body) {
+		return true
+	}
+	http.Error(w, "invalid request signature", http.StatusUnauthorized)
+	return false
+}
+
+func (s *RPC) create(w http.ResponseWriter, r *http.Request) {
+	if !s.allowCreate(w, r) {
+		return
+	}
+	var req models.CreateRequest
+	if !s.parse(w, r, &req) {
+		return
+	}
+	resp, err := s.r.Create(r.Context(), req)
+	s.respond(w, resp, err)
+}
+
+func (s *RPC) open(w http.ResponseWriter, r *http.Request, txid string, vout uint32) {
+	if !s.allowCreate(w, r) {
+		return
+	}
+	var req models.OpenRequest
+	body, ok := s.parseBody(w, r, &req)
+	if !ok {
+		return
+	}
+	if !checkID(w, txid, vout, req.TxID, req.Vout) {
+		return
+	}
+	if !s.checkSig(w, r, txid, vout, "open", req.SenderPubKey, body) {
+		return
+	}
+	resp, err := s.r.Open(r.Context(), req)
+	s.respond(w, resp, err)
+}
+
+func (s *RPC) validate(w http.ResponseWriter, r *http.Request, txid string, vout uint32) {
+	var req models.ValidateRequest
+	if !s.parse(w, r, &req) {
+		return
+	}
+	if !checkID(w, txid, vout, req.TxID, req.Vout) {
+		return
+	}
+	resp, err := s.r.Validate(r.Context(), req)
+	s.respond(w, resp, err)
+}
+
+func (s *RPC) send(w http.ResponseWriter, r *http.Request, txid string, vout uint32) {
+	var req models.SendRequest
+	body, ok := s.parseBody(w, r, &req)
+	if !ok {
+		return
+	}
+	if !checkID(w, txid, vout, req.TxID, req.Vout) {
+		return
+	}
+	if !s.checkSig(w, r, txid, vout, "send", nil, body) {
+		return
+	}
+	resp, err := s.r.Send(r.Context(), req)
+	s.respond(w, resp, err)
+}
+
+func (s *RPC) hold(w http.ResponseWriter, r *http.Request, txid string, vout uint32) {
+	var req models.HoldRequest
+	if !s.parse(w, r, &req) {
+		return
+	}
+	if !checkID(w, txid, vout, req.TxID, req.Vout) {
+		return
+	}
+	resp, err := s.r.Hold(r.Context(), req)
+	s.respond(w, resp, err)
+}
+
+func (s *RPC) release(w http.ResponseWriter, r *http.Request, txid string, vout uint32) {
+	var req models.ReleaseRequest
+	if !s.parse(w, r, &req) {
+		return
+	}
+	if !checkID(w, txid, vout, req.TxID, req.Vout) {
+		return
+	}
+	resp, err := s.r.Release(r.Context(), req)
+	s.respond(w, resp, err)
+}
+
+func (s *RPC) close(w http.ResponseWriter, r *http.Request, txid string, vout uint32) {
+	var req models.CloseRequest
+	if !s.parse(w, r, &req) {
+		return
+	}
+	if !checkID(w, txid, vout, req.TxID, req.Vout) {
+		return
+	}
+	resp, err := s.r.Close(r.Context(), req)
+	s.respond(w, resp, err)
+}
+
+func (s *RPC) status(w http.ResponseWriter, r *http.Request, txid string, vout uint32) {
+	var req models.StatusRequest
+	body, ok := s.parseBody(w, r, &req)
+	if !ok {
+		return
+	}
+	if !checkID(w, txid, vout, req.TxID, req.Vout) {
+		return
+	}
+	if !s.checkSig(w, r, txid, vout, "status", nil, body) {
+		return
+	}
+	resp, err := s.r.Status(r.Context(), req)
+	s.respond(w, resp, err)
+}
+
+// Register adds the RPC handler to mux.
+func (s *RPC) Register(mux *http.ServeMux) {
+	mux.Handle(RPCPath, s)
+	mux.Handle(RPCPath+"/", s)
+}
+
+func (s *RPC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Log.Debug("rpc request", "method", r.Method, "path", r.URL.Path)
+
+	var keyAccount string
+	if s.Keys != nil {
+		var ok bool
+		keyAccount, ok = checkKey(s.Keys, r, receiver.ScopeRPC, false)
+		if !ok {
+			http.Error(w, "invalid api key", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, RPCPath+"/")
+
+	var account string
+	if rest := strings.TrimPrefix(path, "account/"); rest != path {
+		i := strings.Index(rest, "/")
+		if i < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		account, path = rest[:i], rest[i+1:]
+	}
+	if keyAccount != "" && keyAccount != account {
+		http.Error(w, "invalid api key", http.StatusUnauthorized)
+		return
+	}
+	r = r.WithContext(receiver.WithAccount(r.Context(), account))
+
+	if path == "create" {
+		if r.Method == http.MethodPost {
+			ctx, span := trace.Start(r.Context(), "rpc.create")
+			defer span.End()
+			s.create(w, r.WithContext(ctx))
+			return
+		}
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	i := strings.Index(path, "/")
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	call := path[:i]
+	txid, vout, ok := ParseChannelID(path[i+1:])
+	if !ok {
+		http.Error(w, "Invalid channel ID", http.StatusNotFound)
+		return
+	}
+
+	id := txid + "-" + strconv.Itoa(int(vout))
+	ctx, span := trace.Start(r.Context(), "rpc."+call)
+	defer span.End()
+	span.SetAttribute("channel", id)
+	r = r.WithContext(ctx)
+
+	if call == "open" {
+		s.open(w, r, txid, vout)
+		return
+	}
+
+	if !s.allowCall(w, r, id) {
+		return
+	}
+
+	if !s.checkAuthToken(r, txid, vout) {
+		http.Error(w, "invalid auth token", http.StatusUnauthorized)
+		return
+	}
+
+	switch call {
+	case "validate":
+		s.validate(w, r, txid, vout)
+	case "send":
+		s.send(w, r, txid, vout)
+	case "hold":
+		s.hold(w, r, txid, vout)
+	case "release":
+		s.release(w, r, txid, vout)
+	case "close":
+		s.close(w, r, txid, vout)
+	case "status":
+		s.status(w, r, txid, vout)
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
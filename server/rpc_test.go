@@ -0,0 +1,313 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/models"
+	"github.com/luno/moonbeam/receiver"
+)
+
+const testTxID = "0000000000000000000000000000000000000000000000000000000000000001"
+
+type fakeReceiver struct {
+	Receiver
+	sendErr error
+}
+
+func (f *fakeReceiver) Create(ctx context.Context, req models.CreateRequest) (*models.CreateResponse, error) {
+	return &models.CreateResponse{Version: req.Version}, nil
+}
+
+func (f *fakeReceiver) Open(ctx context.Context, req models.OpenRequest) (*models.OpenResponse, error) {
+	return &models.OpenResponse{AuthToken: "token"}, nil
+}
+
+func (f *fakeReceiver) Send(ctx context.Context, req models.SendRequest) (*models.SendResponse, error) {
+	if f.sendErr != nil {
+		return nil, f.sendErr
+	}
+	return &models.SendResponse{}, nil
+}
+
+func (f *fakeReceiver) ValidateToken(txid string, vout uint32, token string) bool {
+	return token == "token"
+}
+
+func newTestRequest(method, path, token, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func serve(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func call(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	return serve(h, newTestRequest(method, path, token, body))
+}
+
+func TestParseChannelID(t *testing.T) {
+	txid, vout, ok := ParseChannelID(testTxID + "-3")
+	if !ok || txid != testTxID || vout != 3 {
+		t.Errorf("Unexpected result: %s %d %v", txid, vout, ok)
+	}
+
+	bad := []string{
+		"",
+		testTxID,
+		testTxID + "-",
+		testTxID + "--1",
+		testTxID + "-4294967296",
+		strings.Repeat("A", 64) + "-0",
+		testTxID[1:] + "-0",
+	}
+	for _, id := range bad {
+		if _, _, ok := ParseChannelID(id); ok {
+			t.Errorf("Expected %q to be rejected", id)
+		}
+	}
+}
+
+func TestRPC(t *testing.T) {
+	f := &fakeReceiver{}
+	h := NewRPC(f)
+	id := testTxID + "-0"
+
+	sendBody := `{"txid":"` + testTxID + `","vout":0}`
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		code   int
+	}{
+		{"create", http.MethodPost, RPCPath + "/create", "", `{"version":1}`, http.StatusOK},
+		{"create wrong method", http.MethodGet, RPCPath + "/create", "", `{}`, http.StatusMethodNotAllowed},
+		{"open without token", http.MethodPut, RPCPath + "/open/" + id, "", sendBody, http.StatusOK},
+		{"send without token", http.MethodPost, RPCPath + "/send/" + id, "", sendBody, http.StatusUnauthorized},
+		{"send with bad token", http.MethodPost, RPCPath + "/send/" + id, "wrong", sendBody, http.StatusUnauthorized},
+		{"send", http.MethodPost, RPCPath + "/send/" + id, "token", sendBody, http.StatusOK},
+		{"send wrong channel", http.MethodPost, RPCPath + "/send/" + testTxID + "-1", "token", sendBody, http.StatusBadRequest},
+		{"bad json", http.MethodPost, RPCPath + "/send/" + id, "token", "{", http.StatusBadRequest},
+		{"bad channel id", http.MethodPost, RPCPath + "/send/xyz", "token", sendBody, http.StatusNotFound},
+		{"unknown call", http.MethodPost, RPCPath + "/foo/" + id, "token", sendBody, http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		w := call(h, test.method, test.path, test.token, test.body)
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, w.Code)
+		}
+	}
+
+	w := call(h, http.MethodPost, RPCPath+"/create", "", `{"version":1}`)
+	var resp models.CreateResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Version != 1 {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+}
+
+func TestRPCErrors(t *testing.T) {
+	f := &fakeReceiver{}
+	h := NewRPC(f)
+	path := RPCPath + "/send/" + testTxID + "-0"
+	body := `{"txid":"` + testTxID + `","vout":0}`
+
+	f.sendErr = receiver.ErrFrozen
+	w := call(h, http.MethodPost, path, "token", body)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for exposable error, got %d", w.Code)
+	}
+	var er models.ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&er); err != nil {
+		t.Fatal(err)
+	}
+	if er.Error != receiver.ErrFrozen.Error() || er.Reason != models.ReasonChannelNotOpen {
+		t.Errorf("Expected error message and reason in body, got %+v", er)
+	}
+
+	f.sendErr = receiver.NewExposableError("no reason")
+	w = call(h, http.MethodPost, path, "token", body)
+	if w.Code != http.StatusBadRequest || w.Body.String() != "no reason\n" {
+		t.Errorf("Expected 400 with plain text error, got %d %q", w.Code, w.Body.String())
+	}
+
+	f.sendErr = receiver.FundingRangeError{Value: 1, Min: 1000}
+	w = call(h, http.MethodPost, path, "token", body)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "1000") {
+		t.Errorf("Expected 400 with accepted range, got %d %q", w.Code, w.Body.String())
+	}
+
+	f.sendErr = receiver.ScreeningError{Address: "addr", Hit: receiver.ScreeningHit{Reason: "secret list"}}
+	w = call(h, http.MethodPost, path, "token", body)
+	if w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("Expected 403 without the hit, got %d %q", w.Code, w.Body.String())
+	}
+
+	f.sendErr = errors.New("secret internal detail")
+	w = call(h, http.MethodPost, path, "token", body)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 for internal error, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("Internal error leaked: %q", w.Body.String())
+	}
+
+	f.sendErr = nil
+	big := `{"payment":"` + strings.Repeat("a", maxRequestSize) + `"}`
+	w = call(h, http.MethodPost, path, "token", big)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for large request, got %d", w.Code)
+	}
+}
+
+func TestRPCCreateLimit(t *testing.T) {
+	h := NewRPC(&fakeReceiver{})
+	h.CreateLimit = NewIPLimiter(2, time.Hour)
+
+	for i, code := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		w := call(h, http.MethodPost, RPCPath+"/create", "", `{"version":1}`)
+		if w.Code != code {
+			t.Errorf("%d: expected %d, got %d", i, code, w.Code)
+		}
+	}
+}
+
+func TestIPLimiter(t *testing.T) {
+	l := NewIPLimiter(1, time.Minute)
+	now := time.Now()
+
+	if !l.Allow("a", now) || !l.Allow("b", now) {
+		t.Errorf("Expected first requests to be allowed")
+	}
+	if l.Allow("a", now.Add(time.Second)) {
+		t.Errorf("Expected second request to be limited")
+	}
+	if !l.Allow("a", now.Add(time.Minute)) {
+		t.Errorf("Expected request in the next window to be allowed")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(1, 2)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a", now); !ok {
+			t.Errorf("Expected burst to be allowed")
+		}
+	}
+	ok, wait := l.Allow("a", now)
+	if ok || wait <= 0 || wait > time.Second {
+		t.Errorf("Expected to wait up to a second, got %v %v", ok, wait)
+	}
+	if ok, _ := l.Allow("b", now); !ok {
+		t.Errorf("Expected other keys to be allowed")
+	}
+	if ok, _ := l.Allow("a", now.Add(time.Second)); !ok {
+		t.Errorf("Expected refilled bucket to be allowed")
+	}
+
+	l.Allow("c", now.Add(time.Minute))
+	if _, ok := l.buckets["b"]; ok {
+		t.Errorf("Expected full buckets to be discarded")
+	}
+}
+
+func TestRPCChannelLimit(t *testing.T) {
+	h := NewRPC(&fakeReceiver{})
+	h.ChannelLimit = NewRateLimiter(1, 1)
+	body := `{"txid":"` + testTxID + `","vout":0}`
+
+	w := call(h, http.MethodPost, RPCPath+"/send/"+testTxID+"-0", "token", body)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected first call to succeed, got %d", w.Code)
+	}
+	w = call(h, http.MethodPost, RPCPath+"/send/"+testTxID+"-0", "token", body)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After, got %d", w.Code)
+	}
+}
+
+func TestListenAndServeShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := DefaultConfig(addr)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- ListenAndServe(ctx, c, h)
+	}()
+
+	respc := make(chan error, 1)
+	go func() {
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			resp, err = http.Get("http://" + addr)
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = errors.New(resp.Status)
+			}
+		}
+		respc <- err
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request not started")
+	}
+
+	// Shutdown waits for the in-flight request.
+	cancel()
+	select {
+	case err := <-errc:
+		t.Fatalf("Server stopped before request finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if err := <-respc; err != nil {
+		t.Errorf("In-flight request failed: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("Unexpected shutdown error: %v", err)
+	}
+}
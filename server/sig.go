@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/luno/moonbeam/channels"
+)
+
+// Headers carrying a sender's request signature.
+const (
+	NonceHeader     = "X-Moonbeam-Nonce"
+	SignatureHeader = "X-Moonbeam-Signature"
+)
+
+// SenderKeys looks up the sender's public key of a channel. It is
+// implemented by receiver.Receiver.
+type SenderKeys interface {
+	SenderPubKey(ctx context.Context, txid string, vout uint32) ([]byte, error)
+}
+
+// SigVerifier checks that calls are signed by the channel's sender key, so
+// that knowing a channel ID and auth token isn't enough to use the channel.
+//
+// The nonce is <unix time>.<random> and may only be used once within Window
+// of its timestamp. The signature is the base64 DER encoding of an ECDSA
+// signature over channels.RequestDigest.
+//
+// Used nonces are only kept in memory, so a replay is only detected by the
+// server that saw the nonce first. When several servers share storage, a
+// channel's requests must all be routed to the same one.
+type SigVerifier struct {
+	keys SenderKeys
+
+	// Window is how far a nonce's timestamp may be from the server's clock.
+	Window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+
+	// expiry holds the seen nonces in the order they were seen, so that
+	// expired ones are found without scanning all of them.
+	expiry []seenNonce
+}
+
+type seenNonce struct {
+	nonce string
+	at    time.Time
+}
+
+// NewSigVerifier returns a verifier that looks up sender keys with keys.
+func NewSigVerifier(keys SenderKeys) *SigVerifier {
+	return &SigVerifier{
+		keys:   keys,
+		Window: 5 * time.Minute,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// markSeen records the nonce and returns false if it had already been used.
+func (v *SigVerifier) markSeen(nonce string, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for len(v.expiry) > 0 && now.Sub(v.expiry[0].at) > 2*v.Window {
+		delete(v.seen, v.expiry[0].nonce)
+		v.expiry = v.expiry[1:]
+	}
+
+	if _, ok := v.seen[nonce]; ok {
+		return false
+	}
+	v.seen[nonce] = now
+	v.expiry = append(v.expiry, seenNonce{nonce, now})
+	return true
+}
+
+func (v *SigVerifier) checkNonce(nonce string, now time.Time) bool {
+	i := strings.Index(nonce, ".")
+	if i < 0 || i == len(nonce)-1 {
+		return false
+	}
+	ts, err := strconv.ParseInt(nonce[:i], 10, 64)
+	if err != nil {
+		return false
+	}
+	d := now.Sub(time.Unix(ts, 0))
+	if d > v.Window || d < -v.Window {
+		return false
+	}
+	return true
+}
+
+// verify checks the request's signature by pubKey. If pubKey is nil, the
+// channel's stored sender key is used.
+func (v *SigVerifier) verify(r *http.Request, txid string, vout uint32,
+	call string, pubKey, body []byte) bool {
+
+	nonce := r.Header.Get(NonceHeader)
+	sig, err := base64.StdEncoding.DecodeString(r.Header.Get(SignatureHeader))
+	if err != nil || len(sig) == 0 {
+		return false
+	}
+
+	now := time.Now()
+	if !v.checkNonce(nonce, now) {
+		return false
+	}
+
+	if pubKey == nil {
+		pubKey, err = v.keys.SenderPubKey(r.Context(), txid, vout)
+		if err != nil {
+			return false
+		}
+	}
+
+	id := txid + "-" + strconv.Itoa(int(vout))
+	if channels.VerifyRequest(pubKey, id, call, nonce, body, sig) != nil {
+		return false
+	}
+
+	// Only mark the nonce once the signature is valid so that others can't
+	// burn the sender's nonces.
+	return v.markSeen(id+"/"+nonce, now)
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+type fakeSenderKeys struct {
+	pubKey []byte
+}
+
+func (f fakeSenderKeys) SenderPubKey(ctx context.Context, txid string, vout uint32) ([]byte, error) {
+	if vout != 0 {
+		return nil, storage.ErrNotFound
+	}
+	return f.pubKey, nil
+}
+
+func newTestSender(t *testing.T) *channels.Sender {
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := channels.NewSender(channels.DefaultSenderConfig, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func signedRequest(t *testing.T, s *channels.Sender, method, call, id, nonce, body string) *http.Request {
+	req := newTestRequest(method, RPCPath+"/"+call+"/"+id, "token", body)
+	sig, err := s.SignRequest(id, call, nonce, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(sig))
+	return req
+}
+
+func TestRPCSignatures(t *testing.T) {
+	s := newTestSender(t)
+	other := newTestSender(t)
+
+	h := NewRPC(&fakeReceiver{})
+	h.Signatures = NewSigVerifier(fakeSenderKeys{s.State.SenderPubKey})
+
+	id := testTxID + "-0"
+	body := `{"txid":"` + testTxID + `","vout":0}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		code int
+	}{
+		{"unsigned", newTestRequest(http.MethodPost, RPCPath+"/send/"+id, "token", body), http.StatusUnauthorized},
+		{"signed", signedRequest(t, s, http.MethodPost, "send", id, now+".a", body), http.StatusOK},
+		{"replayed", signedRequest(t, s, http.MethodPost, "send", id, now+".a", body), http.StatusUnauthorized},
+		{"other key", signedRequest(t, other, http.MethodPost, "send", id, now+".b", body), http.StatusUnauthorized},
+		{"stale nonce", signedRequest(t, s, http.MethodPost, "send", id, stale+".c", body), http.StatusUnauthorized},
+		{"bad nonce", signedRequest(t, s, http.MethodPost, "send", id, "d", body), http.StatusUnauthorized},
+		{"unknown channel", signedRequest(t, s, http.MethodPost, "send", testTxID+"-1",
+			now+".e", `{"txid":"`+testTxID+`","vout":1}`), http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		w := serve(h, test.req)
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, w.Code)
+		}
+	}
+}
+
+func TestRPCSignedOpen(t *testing.T) {
+	s := newTestSender(t)
+	other := newTestSender(t)
+
+	h := NewRPC(&fakeReceiver{})
+	h.Signatures = NewSigVerifier(fakeSenderKeys{})
+
+	id := testTxID + "-0"
+	pk := base64.StdEncoding.EncodeToString(s.State.SenderPubKey)
+	body := `{"txid":"` + testTxID + `","vout":0,"senderPubKey":"` + pk + `"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	// Open is signed by the key in the request since the channel doesn't
+	// exist yet.
+	w := serve(h, signedRequest(t, other, http.MethodPut, "open", id, now+".a", body))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected open signed by other key to fail, got %d", w.Code)
+	}
+	w = serve(h, signedRequest(t, s, http.MethodPut, "open", id, now+".b", body))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected signed open to succeed, got %d", w.Code)
+	}
+}
+
+func TestSigVerifierExpiry(t *testing.T) {
+	v := NewSigVerifier(fakeSenderKeys{})
+	start := time.Now()
+
+	for i := 0; i < 100; i++ {
+		now := start.Add(time.Duration(i) * time.Second)
+		if !v.markSeen(strconv.Itoa(i), now) {
+			t.Fatalf("Expected nonce %d to be new", i)
+		}
+	}
+	if v.markSeen("0", start.Add(time.Minute)) {
+		t.Errorf("Expected reused nonce to be rejected")
+	}
+
+	// Nonces older than twice the window are forgotten.
+	later := start.Add(2*v.Window + 50*time.Second + time.Millisecond)
+	if !v.markSeen("new", later) {
+		t.Errorf("Expected nonce to be new")
+	}
+	if len(v.seen) != 50 || len(v.expiry) != 50 {
+		t.Errorf("Expected 50 remembered nonces, got %d and %d", len(v.seen), len(v.expiry))
+	}
+	if !v.markSeen("0", later) {
+		t.Errorf("Expected expired nonce to be forgotten")
+	}
+	if v.markSeen("99", later) {
+		t.Errorf("Expected recent nonce to be remembered")
+	}
+}
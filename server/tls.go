@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves a TLS certificate from files, reloading it when the
+// files change so that certificates can be rotated without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time
+	lastStat time.Time
+}
+
+// certCheckInterval limits how often the certificate files are checked.
+const certCheckInterval = 10 * time.Second
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *certReloader) modified() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{cr.certFile, cr.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload loads the certificate if the files changed. It must be called with
+// cr.mu held or before cr is shared.
+func (cr *certReloader) reload(now time.Time) error {
+	cr.lastStat = now
+	mt, err := cr.modified()
+	if err != nil {
+		return err
+	}
+	if cr.cert != nil && mt.Equal(cr.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.cert = &cert
+	cr.modTime = mt
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. If reloading fails,
+// for example because the files are being replaced, the previous
+// certificate is served.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if now := time.Now(); now.Sub(cr.lastStat) >= certCheckInterval {
+		_ = cr.reload(now)
+	}
+	return cr.cert, nil
+}
+
+// tlsConfig returns the server's TLS config. If c.ClientCAs is set, clients
+// may present certificates signed by those CAs, which handlers can require
+// with hasClientCert.
+func tlsConfig(c Config) (*tls.Config, error) {
+	cr, err := newCertReloader(c.TLSCert, c.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{
+		GetCertificate: cr.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if c.ClientCAs != "" {
+		buf, err := os.ReadFile(c.ClientCAs)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, errors.New("no certificates found in " + c.ClientCAs)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tc, nil
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCert(t *testing.T, certFile, keyFile, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func certName(t *testing.T, cert *tls.Certificate) string {
+	c, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "first")
+
+	cr, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := cr.GetCertificate(nil)
+	if err != nil || certName(t, cert) != "first" {
+		t.Fatalf("Expected first certificate, got %v", err)
+	}
+
+	writeTestCert(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cr.lastStat = time.Time{}
+
+	cert, err = cr.GetCertificate(nil)
+	if err != nil || certName(t, cert) != "second" {
+		t.Errorf("Expected rotated certificate, got %v", err)
+	}
+
+	// A failed reload keeps serving the current certificate.
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(keyFile, later.Add(time.Minute), later.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	cr.lastStat = time.Time{}
+	cert, err = cr.GetCertificate(nil)
+	if err != nil || certName(t, cert) != "second" {
+		t.Errorf("Expected previous certificate, got %v", err)
+	}
+}
+
+func TestAdminRequireClientCert(t *testing.T) {
+	h := NewAdmin(&fakeAdmin{}, "secret")
+	h.RequireClientCert = true
+	path := AdminPath + "/inspect/" + testTxID + "-0"
+
+	if w := call(h, http.MethodGet, path, "secret", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected call without client certificate to be refused, got %d", w.Code)
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "ops"}}
+	req := newTestRequest(http.MethodGet, path, "secret", "")
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	if name := clientCertName(req); name != "ops" {
+		t.Errorf("Expected client name ops, got %q", name)
+	}
+	w := serve(h, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected call with client certificate to succeed, got %d", w.Code)
+	}
+}
@@ -0,0 +1,343 @@
+// Package signer keeps a receiver's extended private key off the API host.
+// A signing daemon holds the key and signs with the channel keys derived
+// from it, for receivers that hold only extended public keys. It doesn't
+// sign digests but the channel transactions and receipts that the receiver
+// signs, described by channels.SignRequest, and computes their digests
+// itself.
+//
+// Requests are JSON posted over HTTP and authenticated with a secret shared
+// by the daemon and its clients, sent in a Bearer Authorization header. The
+// daemon should listen on a loopback address or serve TLS.
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/models"
+)
+
+const (
+	XPubPath = "/xpub"
+	SignPath = "/sign"
+)
+
+// maxDepth limits paths to an account key and the channel keys below it.
+const maxDepth = 2
+
+// maxBodySize bounds request and response bodies, which are small.
+const maxBodySize = 64 << 10
+
+var ErrUnauthorized = errors.New("signer: unauthorized")
+
+type xpubRequest struct {
+	Path string `json:"path"`
+}
+
+type xpubResponse struct {
+	XPub string `json:"xpub"`
+}
+
+// signRequest identifies the channel key by its path relative to the
+// account key, the hardened child Account of the extended key, or relative
+// to the extended key itself if Account is missing.
+type signRequest struct {
+	Account *uint32 `json:"account,omitempty"`
+	Path    string  `json:"path"`
+
+	Kind           channels.SignKind `json:"kind"`
+	Tx             []byte            `json:"tx,omitempty"`
+	SenderPubKey   []byte            `json:"senderPubKey,omitempty"`
+	Timeout        int64             `json:"timeout,omitempty"`
+	RevocationHash []byte            `json:"revocationHash,omitempty"`
+	Receipt        *models.Receipt   `json:"receipt,omitempty"`
+}
+
+type signResponse struct {
+	Signature []byte `json:"signature"`
+}
+
+// ParsePath parses a derivation path relative to the extended key, such as
+// "m", "m/3" or "m/1'/3", where ' marks a hardened child.
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" || len(parts) > maxDepth+1 {
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+	var res []uint32
+	for _, p := range parts[1:] {
+		var offset uint32
+		if strings.HasSuffix(p, "'") {
+			p = p[:len(p)-1]
+			offset = hdkeychain.HardenedKeyStart
+		}
+		n, err := strconv.ParseUint(p, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %q", path)
+		}
+		res = append(res, uint32(n)+offset)
+	}
+	return res, nil
+}
+
+// parseKeyPath parses the path of a channel key relative to its account
+// key, which must be a single unhardened child such as "m/3".
+func parseKeyPath(path string) (uint32, error) {
+	children, err := ParsePath(path)
+	if err != nil {
+		return 0, err
+	}
+	if len(children) != 1 || children[0] >= hdkeychain.HardenedKeyStart {
+		return 0, fmt.Errorf("invalid channel key path %q", path)
+	}
+	return children[0], nil
+}
+
+func derive(ek *hdkeychain.ExtendedKey, path string) (*hdkeychain.ExtendedKey, error) {
+	children, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range children {
+		ek, err = ek.Child(i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ek, nil
+}
+
+// Server is the signing daemon's handler.
+type Server struct {
+	ek     *hdkeychain.ExtendedKey
+	secret string
+
+	Log *slog.Logger
+}
+
+// NewServer returns a handler signing with keys derived from ek for clients
+// presenting secret.
+func NewServer(ek *hdkeychain.ExtendedKey, secret string) *Server {
+	return &Server{ek: ek, secret: secret, Log: slog.Default()}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if s.secret == "" || !strings.HasPrefix(h, prefix) ||
+		subtle.ConstantTimeCompare([]byte(h[len(prefix):]), []byte(s.secret)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var (
+		resp interface{}
+		err  error
+	)
+	body := http.MaxBytesReader(w, r.Body, maxBodySize)
+	switch r.URL.Path {
+	case XPubPath:
+		var req xpubRequest
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		resp, err = s.xpub(req)
+	case SignPath:
+		var req signRequest
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		resp, err = s.sign(req)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.Log.Warn("signer request failed", "path", r.URL.Path, "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.Log.Warn("write signer response failed", "err", err)
+	}
+}
+
+func (s *Server) xpub(req xpubRequest) (*xpubResponse, error) {
+	ek, err := derive(s.ek, req.Path)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ek.Neuter()
+	if err != nil {
+		return nil, err
+	}
+	return &xpubResponse{XPub: pub.String()}, nil
+}
+
+// channelKey derives the channel key of a sign request.
+func (s *Server) channelKey(req signRequest) (*btcec.PrivateKey, error) {
+	n, err := parseKeyPath(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	ek := s.ek
+	if req.Account != nil {
+		if *req.Account >= hdkeychain.HardenedKeyStart {
+			return nil, errors.New("invalid account")
+		}
+		ek, err = ek.Child(hdkeychain.HardenedKeyStart + *req.Account)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ek, err = ek.Child(n)
+	if err != nil {
+		return nil, err
+	}
+	return ek.ECPrivKey()
+}
+
+func (s *Server) sign(req signRequest) (*signResponse, error) {
+	key, err := s.channelKey(req)
+	if err != nil {
+		return nil, err
+	}
+	cr := channels.SignRequest{
+		Kind:           req.Kind,
+		SenderPubKey:   req.SenderPubKey,
+		Timeout:        req.Timeout,
+		RevocationHash: req.RevocationHash,
+		Receipt:        req.Receipt,
+	}
+	if len(req.Tx) > 0 {
+		cr.Tx = new(wire.MsgTx)
+		if err := cr.Tx.Deserialize(bytes.NewReader(req.Tx)); err != nil {
+			return nil, errors.New("invalid tx")
+		}
+	}
+	digest, err := cr.Digest(key.PubKey())
+	if err != nil {
+		return nil, err
+	}
+	sig, err := key.Sign(digest)
+	if err != nil {
+		return nil, err
+	}
+	return &signResponse{Signature: sig.Serialize()}, nil
+}
+
+// Client calls a signing daemon. It's safe for concurrent use.
+type Client struct {
+	url    string
+	secret string
+
+	HTTP *http.Client
+}
+
+// NewClient returns a client of the daemon at url, e.g.
+// "http://127.0.0.1:8443".
+func NewClient(url, secret string) *Client {
+	return &Client{
+		url:    strings.TrimSuffix(url, "/"),
+		secret: secret,
+		HTTP:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// XPub returns the extended public key at path.
+func (c *Client) XPub(ctx context.Context, path string) (*hdkeychain.ExtendedKey, error) {
+	var resp xpubResponse
+	if err := c.call(ctx, XPubPath, xpubRequest{Path: path}, &resp); err != nil {
+		return nil, err
+	}
+	return hdkeychain.NewKeyFromString(resp.XPub)
+}
+
+// Sign returns the signature of req by the channel key n below the account
+// key at accountPath, which is "m" or a hardened child such as "m/1'".
+func (c *Client) Sign(ctx context.Context, accountPath string, n uint32, req channels.SignRequest) (*btcec.Signature, error) {
+	account, err := ParsePath(accountPath)
+	if err != nil {
+		return nil, err
+	}
+	sr := signRequest{
+		Path:           fmt.Sprintf("m/%d", n),
+		Kind:           req.Kind,
+		SenderPubKey:   req.SenderPubKey,
+		Timeout:        req.Timeout,
+		RevocationHash: req.RevocationHash,
+		Receipt:        req.Receipt,
+	}
+	switch {
+	case len(account) == 0:
+	case len(account) == 1 && account[0] >= hdkeychain.HardenedKeyStart:
+		i := account[0] - hdkeychain.HardenedKeyStart
+		sr.Account = &i
+	default:
+		return nil, fmt.Errorf("invalid account path %q", accountPath)
+	}
+	if req.Tx != nil {
+		var buf bytes.Buffer
+		if err := req.Tx.Serialize(&buf); err != nil {
+			return nil, err
+		}
+		sr.Tx = buf.Bytes()
+	}
+
+	var resp signResponse
+	if err := c.call(ctx, SignPath, sr, &resp); err != nil {
+		return nil, err
+	}
+	return btcec.ParseDERSignature(resp.Signature, btcec.S256())
+}
+
+func (c *Client) call(ctx context.Context, path string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Authorization", "Bearer "+c.secret)
+	hreq.Header.Set("Content-Type", "application/json")
+
+	hresp, err := c.HTTP.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close()
+
+	r := io.LimitReader(hresp.Body, maxBodySize)
+	if hresp.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized
+	} else if hresp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(r)
+		return fmt.Errorf("signer: %s: %s", hresp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(r).Decode(resp)
+}
@@ -0,0 +1,272 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil/hdkeychain"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/keytest"
+	"github.com/luno/moonbeam/models"
+)
+
+func TestParsePath(t *testing.T) {
+	for _, test := range []struct {
+		path string
+		want []uint32
+	}{
+		{"m", nil},
+		{"m/3", []uint32{3}},
+		{"m/1'/3", []uint32{hdkeychain.HardenedKeyStart + 1, 3}},
+	} {
+		got, err := ParsePath(test.path)
+		if err != nil || len(got) != len(test.want) {
+			t.Errorf("%s: got %v %v", test.path, got, err)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got %v", test.path, got)
+			}
+		}
+	}
+	for _, bad := range []string{"", "3", "m/", "m/x", "m/1/2/3", "m/2147483648"} {
+		if _, err := ParsePath(bad); err == nil {
+			t.Errorf("Expected %q to be invalid", bad)
+		}
+	}
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, *hdkeychain.ExtendedKey) {
+	ek, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), &chaincfg.TestNet3Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewServer(ek, "secret"))
+	t.Cleanup(ts.Close)
+	return ts, ek
+}
+
+// channelKey returns the public key at the account's hardened child and n.
+func channelKey(t *testing.T, ek *hdkeychain.ExtendedKey, account, n uint32) *btcec.PublicKey {
+	child, err := ek.Child(hdkeychain.HardenedKeyStart + account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err = child.Child(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := child.ECPubKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+// testState returns an open channel with the receiver key.
+func testState(receiver *btcec.PublicKey) channels.SharedState {
+	return channels.SharedState{
+		Version:        channels.Version,
+		Net:            channels.NetTestnet3,
+		Timeout:        1008,
+		Capacity:       100000,
+		Fee:            10000,
+		SenderPubKey:   keytest.PubKey(1),
+		ReceiverPubKey: receiver.SerializeCompressed(),
+		SenderOutput:   keytest.Address(2, &chaincfg.TestNet3Params),
+		ReceiverOutput: keytest.Address(3, &chaincfg.TestNet3Params),
+		FundingTxID:    "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d",
+	}
+}
+
+func closureRequest(t *testing.T, s channels.SharedState) channels.SignRequest {
+	tx, err := s.GetClosureTx(20000, [32]byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return channels.SignRequest{
+		Kind:         channels.SignClosure,
+		Tx:           tx,
+		SenderPubKey: s.SenderPubKey,
+		Timeout:      s.Timeout,
+	}
+}
+
+func TestSign(t *testing.T) {
+	ctx := context.Background()
+	ts, ek := newTestServer(t)
+	c := NewClient(ts.URL, "secret")
+
+	xpub, err := c.XPub(ctx, "m/1'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xpub.IsPrivate() {
+		t.Fatal("Expected a public key")
+	}
+	child, err := xpub.Child(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := child.ECPubKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.IsEqual(channelKey(t, ek, 1, 3)) {
+		t.Fatal("Expected the channel key")
+	}
+
+	s := testState(pub)
+	revocationHash := bytes.Repeat([]byte{2}, 20)
+	commitTx, err := s.GetCommitmentTx(20000, [32]byte{1}, revocationHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := &models.Receipt{ChannelID: "channel", Amount: 1000, ReceiverPubKey: s.ReceiverPubKey}
+
+	tests := []struct {
+		name string
+		req  channels.SignRequest
+	}{
+		{"closure", closureRequest(t, s)},
+		{"commitment", channels.SignRequest{
+			Kind:           channels.SignCommitment,
+			Tx:             commitTx,
+			SenderPubKey:   s.SenderPubKey,
+			Timeout:        s.Timeout,
+			RevocationHash: revocationHash,
+		}},
+		{"receipt", channels.SignRequest{Kind: channels.SignReceipt, Receipt: rc}},
+	}
+	for _, test := range tests {
+		sig, err := c.Sign(ctx, "m/1'", 3, test.req)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		digest, err := test.req.Digest(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sig.Verify(digest, pub) {
+			t.Errorf("%s: expected signature of the digest by the channel key", test.name)
+		}
+	}
+
+	_, err = NewClient(ts.URL, "wrong").Sign(ctx, "m", 3, closureRequest(t, s))
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected unauthorized, got %v", err)
+	}
+}
+
+// postSign posts a raw sign request, bypassing the client's checks.
+func postSign(t *testing.T, url string, req interface{}) int {
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hreq, err := http.NewRequest(http.MethodPost, url+SignPath, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hreq.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(hreq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestSignRejected(t *testing.T) {
+	ts, ek := newTestServer(t)
+	s := testState(channelKey(t, ek, 1, 3))
+
+	var raw bytes.Buffer
+	if err := closureRequest(t, s).Tx.Serialize(&raw); err != nil {
+		t.Fatal(err)
+	}
+	account := uint32(1)
+	valid := signRequest{
+		Account:      &account,
+		Path:         "m/3",
+		Kind:         channels.SignClosure,
+		Tx:           raw.Bytes(),
+		SenderPubKey: s.SenderPubKey,
+		Timeout:      s.Timeout,
+	}
+	if code := postSign(t, ts.URL, valid); code != http.StatusOK {
+		t.Fatalf("Expected valid request to succeed, got %d", code)
+	}
+
+	withTx := func(f func(tx *wire.MsgTx)) []byte {
+		tx := closureRequest(t, s).Tx
+		f(tx)
+		var buf bytes.Buffer
+		if err := tx.Serialize(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	hardened := uint32(hdkeychain.HardenedKeyStart)
+
+	tests := []struct {
+		name   string
+		modify func(r *signRequest)
+	}{
+		{"master key", func(r *signRequest) { r.Account, r.Path = nil, "m" }},
+		{"account key", func(r *signRequest) { r.Account, r.Path = nil, "m/1'" }},
+		{"hardened", func(r *signRequest) { r.Path = "m/3'" }},
+		{"too deep", func(r *signRequest) { r.Path = "m/3/1" }},
+		{"hardened account", func(r *signRequest) { r.Account = &hardened }},
+		{"digest", func(r *signRequest) { r.Kind, r.Tx = "", nil }},
+		{"unknown kind", func(r *signRequest) { r.Kind = "refund" }},
+		{"bad tx", func(r *signRequest) { r.Tx = []byte{1, 2, 3} }},
+		{"two inputs", func(r *signRequest) {
+			r.Tx = withTx(func(tx *wire.MsgTx) { tx.AddTxIn(tx.TxIn[0]) })
+		}},
+		{"no data output", func(r *signRequest) {
+			r.Tx = withTx(func(tx *wire.MsgTx) { tx.TxOut = tx.TxOut[1:] })
+		}},
+		{"too many outputs", func(r *signRequest) {
+			r.Tx = withTx(func(tx *wire.MsgTx) { tx.AddTxOut(tx.TxOut[1]); tx.AddTxOut(tx.TxOut[1]) })
+		}},
+		{"bad sender key", func(r *signRequest) { r.SenderPubKey = []byte{2, 3} }},
+		{"commitment paying the sender directly", func(r *signRequest) {
+			r.Kind = channels.SignCommitment
+			r.RevocationHash = bytes.Repeat([]byte{2}, 20)
+		}},
+		{"penalty without revocation hash", func(r *signRequest) {
+			r.Kind = channels.SignPenalty
+			r.Tx = withTx(func(tx *wire.MsgTx) { tx.TxOut = tx.TxOut[:1] })
+		}},
+		{"receipt of another key", func(r *signRequest) {
+			r.Kind, r.Tx = channels.SignReceipt, nil
+			r.Receipt = &models.Receipt{ChannelID: "channel", ReceiverPubKey: keytest.PubKey(4)}
+		}},
+	}
+	for _, test := range tests {
+		req := valid
+		test.modify(&req)
+		if code := postSign(t, ts.URL, req); code != http.StatusBadRequest {
+			t.Errorf("%s: expected request to be rejected, got %d", test.name, code)
+		}
+	}
+
+	// An old style request to sign a digest.
+	hash := strings.Repeat("a", 43) + "="
+	if code := postSign(t, ts.URL, map[string]string{"path": "m/3", "hash": hash}); code != http.StatusBadRequest {
+		t.Errorf("Expected digest to be rejected, got %d", code)
+	}
+}
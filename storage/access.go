@@ -0,0 +1,31 @@
+package storage
+
+import "time"
+
+// AccessList is the list an AccessEntry is on.
+type AccessList string
+
+const (
+	AccessAllow AccessList = "allow"
+	AccessBlock AccessList = "block"
+)
+
+// AccessKind is what an AccessEntry matches.
+type AccessKind string
+
+const (
+	// AccessSender entries match a sender's hex encoded public key.
+	AccessSender AccessKind = "sender"
+
+	// AccessAddress entries match a sender's refund output address.
+	AccessAddress AccessKind = "address"
+)
+
+// AccessEntry allows or blocks a sender public key or output address.
+type AccessEntry struct {
+	List    AccessList
+	Kind    AccessKind
+	Value   string
+	Note    string
+	Created time.Time
+}
@@ -0,0 +1,1767 @@
+// Package dynamodb implements storage.Storage on Amazon DynamoDB, for
+// serverless deployments that don't want to manage a database. Update is a
+// conditional write on the channel's version, and ListPage pages through
+// channels, or a sender's, with global secondary indexes.
+package dynamodb
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+// maxRetries is how many times a write is retried when a concurrent write
+// changed what it was conditional on, or conflicted with it.
+const maxRetries = 10
+
+// tableWait is how long Open waits for a new table or index to become
+// active, checking every indexPoll.
+const (
+	tableWait = 5 * time.Minute
+	indexPoll = 5 * time.Second
+)
+
+// pageBatch is how many channels ListPage reads at a time, the most that
+// BatchGetItem accepts.
+const pageBatch = 100
+
+var (
+	errContention = errors.New("dynamodb: too many concurrent updates")
+
+	// errDone stops an iteration over items.
+	errDone = errors.New("done")
+)
+
+// Storage is a storage.Storage backed by a DynamoDB table.
+type Storage struct {
+	client *ddb.Client
+	table  string
+}
+
+// Open connects to DynamoDB with the default AWS configuration, which is
+// read from the environment and the shared config files, and creates the
+// table if it doesn't exist.
+func Open(ctx context.Context, table string) (*Storage, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := New(ddb.NewFromConfig(cfg), table)
+	if err := s.CreateTable(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// New returns a storage using the client's table.
+func New(client *ddb.Client, table string) *Storage {
+	return &Storage{client: client, table: table}
+}
+
+// channelIndexSchema is the key schema of the channel index. Only keys are
+// projected, so that updates of channels' states don't write to it.
+func channelIndexSchema() ([]types.KeySchemaElement, *types.Projection) {
+	return []types.KeySchemaElement{
+		{AttributeName: aws.String(attrKind), KeyType: types.KeyTypeHash},
+		{AttributeName: aws.String(attrPK), KeyType: types.KeyTypeRange},
+	}, &types.Projection{ProjectionType: types.ProjectionTypeKeysOnly}
+}
+
+// CreateTable creates the table and its indexes, on demand capacity, if it
+// doesn't exist, and waits for it to become active. Tables created before
+// the channel index are given it.
+func (s *Storage) CreateTable(ctx context.Context) error {
+	describe := &ddb.DescribeTableInput{TableName: aws.String(s.table)}
+	out, err := s.client.DescribeTable(ctx, describe)
+	var notFound *types.ResourceNotFoundException
+	if err == nil {
+		return s.addChannelIndex(ctx, out.Table)
+	} else if !errors.As(err, &notFound) {
+		return err
+	}
+
+	channelKeys, channelProjection := channelIndexSchema()
+
+	_, err = s.client.CreateTable(ctx, &ddb.CreateTableInput{
+		TableName:   aws.String(s.table),
+		BillingMode: types.BillingModePayPerRequest,
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(attrPK), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String(attrSK), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String(attrSender), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String(attrKind), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(attrPK), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(attrSK), KeyType: types.KeyTypeRange},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName: aws.String(senderIndex),
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: aws.String(attrSender), KeyType: types.KeyTypeHash},
+				{AttributeName: aws.String(attrPK), KeyType: types.KeyTypeRange},
+			},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		}, {
+			IndexName:  aws.String(channelIndex),
+			KeySchema:  channelKeys,
+			Projection: channelProjection,
+		}},
+	})
+	// Another instance may have created it first.
+	var inUse *types.ResourceInUseException
+	if err != nil && !errors.As(err, &inUse) {
+		return err
+	}
+	return ddb.NewTableExistsWaiter(s.client).Wait(ctx, describe, tableWait)
+}
+
+// addChannelIndex adds the channel index to the table if it doesn't have
+// it, marks the channels stored before it with their kind, and waits for
+// the index to become active.
+func (s *Storage) addChannelIndex(ctx context.Context, table *types.TableDescription) error {
+	var exists bool
+	for _, gsi := range table.GlobalSecondaryIndexes {
+		if aws.ToString(gsi.IndexName) == channelIndex {
+			exists = true
+		}
+	}
+	if !exists {
+		keys, projection := channelIndexSchema()
+		_, err := s.client.UpdateTable(ctx, &ddb.UpdateTableInput{
+			TableName: aws.String(s.table),
+			AttributeDefinitions: []types.AttributeDefinition{
+				{AttributeName: aws.String(attrKind), AttributeType: types.ScalarAttributeTypeS},
+			},
+			GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{
+				Create: &types.CreateGlobalSecondaryIndexAction{
+					IndexName:  aws.String(channelIndex),
+					KeySchema:  keys,
+					Projection: projection,
+				},
+			}},
+		})
+		// Another instance may be adding it.
+		var inUse *types.ResourceInUseException
+		if err != nil && !errors.As(err, &inUse) {
+			return err
+		}
+	}
+
+	err := s.eachScan(ctx, &ddb.ScanInput{
+		TableName:                aws.String(s.table),
+		FilterExpression:         aws.String("#sk = :sk AND attribute_not_exists(#kind)"),
+		ExpressionAttributeNames: names(attrSK, attrKind),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sk": str(skChannel),
+		},
+	}, func(item map[string]types.AttributeValue) error {
+		if !strings.HasPrefix(getStr(item, attrPK), "channel#") {
+			return nil
+		}
+		_, err := s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+			TableName:                 aws.String(s.table),
+			Key:                       itemKey(getStr(item, attrPK), skChannel),
+			UpdateExpression:          aws.String("SET #kind = :kind"),
+			ExpressionAttributeNames:  names(attrKind),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":kind": str(skChannel)},
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return s.waitIndex(ctx, channelIndex)
+}
+
+// waitIndex waits for the table's index to become active.
+func (s *Storage) waitIndex(ctx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, tableWait)
+	defer cancel()
+	for {
+		out, err := s.client.DescribeTable(ctx, &ddb.DescribeTableInput{TableName: aws.String(s.table)})
+		if err != nil {
+			return err
+		}
+		for _, gsi := range out.Table.GlobalSecondaryIndexes {
+			if aws.ToString(gsi.IndexName) == name && gsi.IndexStatus == types.IndexStatusActive {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(indexPoll):
+		}
+	}
+}
+
+// names returns the expression attribute names "#<name>" of the names.
+func names(ns ...string) map[string]string {
+	m := make(map[string]string, len(ns))
+	for _, n := range ns {
+		m["#"+n] = n
+	}
+	return m
+}
+
+func isConditionFailed(err error) bool {
+	var ccf *types.ConditionalCheckFailedException
+	return errors.As(err, &ccf)
+}
+
+// cancelled returns the reason the i'th item of a cancelled transaction
+// failed its condition, if it did.
+func cancelled(err error, i int) (types.CancellationReason, bool) {
+	var tce *types.TransactionCanceledException
+	if !errors.As(err, &tce) || i < 0 || i >= len(tce.CancellationReasons) {
+		return types.CancellationReason{}, false
+	}
+	r := tce.CancellationReasons[i]
+	return r, aws.ToString(r.Code) == "ConditionalCheckFailed"
+}
+
+// transactWrite writes the items in a transaction, retrying it if it
+// conflicted with another.
+func (s *Storage) transactWrite(ctx context.Context, items []types.TransactWriteItem) error {
+	for attempt := 0; ; attempt++ {
+		_, err := s.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{
+			TransactItems: items,
+		})
+		var tce *types.TransactionCanceledException
+		if err == nil || !errors.As(err, &tce) || attempt == maxRetries {
+			return err
+		}
+		var conflict bool
+		for _, r := range tce.CancellationReasons {
+			if aws.ToString(r.Code) == "TransactionConflict" {
+				conflict = true
+			}
+		}
+		if !conflict {
+			return err
+		}
+	}
+}
+
+// get returns the item, or nil if it doesn't exist.
+func (s *Storage) get(ctx context.Context, key map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	out, err := s.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Item, nil
+}
+
+// put writes the item if the condition, if any, holds.
+func (s *Storage) put(ctx context.Context, item map[string]types.AttributeValue, cond string) error {
+	in := &ddb.PutItemInput{TableName: aws.String(s.table), Item: item}
+	if cond != "" {
+		in.ConditionExpression = aws.String(cond)
+	}
+	_, err := s.client.PutItem(ctx, in)
+	return err
+}
+
+func (s *Storage) delete(ctx context.Context, key map[string]types.AttributeValue) error {
+	_, err := s.client.DeleteItem(ctx, &ddb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key:       key,
+	})
+	return err
+}
+
+// query calls fn for each item of the partition, in sort key order, until
+// it returns errDone.
+func (s *Storage) query(ctx context.Context, pk string, fn func(item map[string]types.AttributeValue) error) error {
+	return s.eachQuery(ctx, &ddb.QueryInput{
+		TableName:                 aws.String(s.table),
+		ConsistentRead:            aws.Bool(true),
+		KeyConditionExpression:    aws.String("#pk = :pk"),
+		ExpressionAttributeNames:  names(attrPK),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":pk": str(pk)},
+	}, fn)
+}
+
+func (s *Storage) eachQuery(ctx context.Context, in *ddb.QueryInput, fn func(item map[string]types.AttributeValue) error) error {
+	p := ddb.NewQueryPaginator(s.client, in)
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range out.Items {
+			if err := fn(item); err == errDone {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scan calls fn for each item in the table matching the filter, if any,
+// on the attribute.
+func (s *Storage) scan(ctx context.Context, filter, attr string, values map[string]types.AttributeValue, fn func(item map[string]types.AttributeValue) error) error {
+	in := &ddb.ScanInput{
+		TableName:      aws.String(s.table),
+		ConsistentRead: aws.Bool(true),
+	}
+	if filter != "" {
+		in.FilterExpression = aws.String(filter)
+		in.ExpressionAttributeNames = names(attr)
+		in.ExpressionAttributeValues = values
+	}
+	return s.eachScan(ctx, in, fn)
+}
+
+func (s *Storage) eachScan(ctx context.Context, in *ddb.ScanInput, fn func(item map[string]types.AttributeValue) error) error {
+	p := ddb.NewScanPaginator(s.client, in)
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range out.Items {
+			if err := fn(item); err == errDone {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dataItem returns an item holding v as JSON.
+func dataItem(pk, sk string, v interface{}) (map[string]types.AttributeValue, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	item := itemKey(pk, sk)
+	item[attrData] = str(string(buf))
+	return item, nil
+}
+
+func getData(item map[string]types.AttributeValue, v interface{}) error {
+	return json.Unmarshal([]byte(getStr(item, attrData)), v)
+}
+
+// listData decodes the JSON of the partition's items into the slice
+// pointed to by v.
+func (s *Storage) listData(ctx context.Context, pk string, v interface{}) error {
+	var values []string
+	err := s.query(ctx, pk, func(item map[string]types.AttributeValue) error {
+		values = append(values, getStr(item, attrData))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte("["+strings.Join(values, ",")+"]"), v)
+}
+
+// nextSeq returns the next number of the named sequence, starting at one.
+func (s *Storage) nextSeq(ctx context.Context, name string) (int64, error) {
+	return s.increment(ctx, itemKey("seq", name), "n", "")
+}
+
+// increment adds one to a number attribute of the item, if the condition,
+// if any, holds, and returns the new value.
+func (s *Storage) increment(ctx context.Context, key map[string]types.AttributeValue, attr, cond string) (int64, error) {
+	in := &ddb.UpdateItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       key,
+		UpdateExpression:          aws.String("ADD #" + attr + " :one"),
+		ExpressionAttributeNames:  names(attr),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":one": num(1)},
+		ReturnValues:              types.ReturnValueUpdatedNew,
+	}
+	if cond != "" {
+		in.ConditionExpression = aws.String(cond)
+		in.ExpressionAttributeNames = names(attr, attrPK)
+	}
+	out, err := s.client.UpdateItem(ctx, in)
+	if err != nil {
+		return 0, err
+	}
+	return getNum(out.Attributes, attr)
+}
+
+// channelSeq returns the next number of a sequence kept on the channel's
+// item, or ErrNotFound if it doesn't exist.
+func (s *Storage) channelSeq(ctx context.Context, id, attr string) (int64, error) {
+	n, err := s.increment(ctx, channelKey(id), attr, "attribute_exists(#pk)")
+	if isConditionFailed(err) {
+		return 0, storage.ErrNotFound
+	}
+	return n, err
+}
+
+func recordItem(rec storage.Record) (map[string]types.AttributeValue, error) {
+	state, err := json.Marshal(rec.SharedState)
+	if err != nil {
+		return nil, err
+	}
+	closure, err := json.Marshal(rec.Closure)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := json.Marshal(rec.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	item := channelKey(rec.ID)
+	item["id"] = str(rec.ID)
+	item["key_path"] = num(int64(rec.KeyPath))
+	item["key_generation"] = num(int64(rec.KeyGeneration))
+	item["state"] = str(string(state))
+	item["version"] = num(rec.Version)
+	item["frozen"] = boolean(rec.Frozen)
+	item["frozen_reason"] = str(rec.FrozenReason)
+	item["suspended"] = boolean(rec.Suspended)
+	item["suspended_reason"] = str(rec.SuspendedReason)
+	item["closure"] = str(string(closure))
+	item["created"] = nanos(rec.Created)
+	item["account"] = str(rec.Account)
+	item["labels"] = str(string(labels))
+	item["archived"] = str(rec.Archived)
+	item[attrKind] = str(skChannel)
+	if len(rec.SharedState.SenderPubKey) > 0 {
+		item[attrSender] = str(hex.EncodeToString(rec.SharedState.SenderPubKey))
+	}
+	return item, nil
+}
+
+func decodeRecord(item map[string]types.AttributeValue) (*storage.Record, error) {
+	rec := storage.Record{
+		ID:              getStr(item, "id"),
+		Frozen:          getBool(item, "frozen"),
+		FrozenReason:    getStr(item, "frozen_reason"),
+		Suspended:       getBool(item, "suspended"),
+		SuspendedReason: getStr(item, "suspended_reason"),
+		Account:         getStr(item, "account"),
+		Archived:        getStr(item, "archived"),
+	}
+	keyPath, err := getNum(item, "key_path")
+	if err != nil {
+		return nil, err
+	}
+	rec.KeyPath = int(keyPath)
+	keyGeneration, err := getNum(item, "key_generation")
+	if err != nil {
+		return nil, err
+	}
+	rec.KeyGeneration = int(keyGeneration)
+	if rec.Version, err = getNum(item, "version"); err != nil {
+		return nil, err
+	}
+	if rec.Created, err = getTime(item, "created"); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(getStr(item, "state")), &rec.SharedState); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(getStr(item, "closure")), &rec.Closure); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(getStr(item, "labels")), &rec.Labels); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s *Storage) Get(ctx context.Context, id string) (*storage.Record, error) {
+	item, err := s.get(ctx, channelKey(id))
+	if err != nil {
+		return nil, err
+	} else if item == nil {
+		return nil, storage.ErrNotFound
+	}
+	return decodeRecord(item)
+}
+
+// List scans the whole table and returns the channels in ID order.
+func (s *Storage) List(ctx context.Context) ([]storage.Record, error) {
+	var sl []storage.Record
+	err := s.scan(ctx, "#sk = :sk", attrSK, map[string]types.AttributeValue{":sk": str(skChannel)},
+		func(item map[string]types.AttributeValue) error {
+			rec, err := decodeRecord(item)
+			if err != nil {
+				return err
+			}
+			sl = append(sl, *rec)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sl, func(i, j int) bool {
+		return sl[i].ID < sl[j].ID
+	})
+	return sl, nil
+}
+
+// querySender returns the sender's channels whose IDs sort after cursor
+// from the sender index, which is eventually consistent, so a channel
+// created moments ago may be missing.
+func (s *Storage) querySender(ctx context.Context, senderPubKey []byte, cursor string) ([]storage.Record, error) {
+	in := &ddb.QueryInput{
+		TableName:                aws.String(s.table),
+		IndexName:                aws.String(senderIndex),
+		KeyConditionExpression:   aws.String("#sender = :sender AND #pk > :cursor"),
+		ExpressionAttributeNames: names(attrSender, attrPK),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sender": str(hex.EncodeToString(senderPubKey)),
+			":cursor": str("channel#" + cursor),
+		},
+	}
+	var sl []storage.Record
+	err := s.eachQuery(ctx, in, func(item map[string]types.AttributeValue) error {
+		rec, err := decodeRecord(item)
+		if err != nil {
+			return err
+		}
+		sl = append(sl, *rec)
+		return nil
+	})
+	return sl, err
+}
+
+// GetBySenderPubKey reads the sender index, so a channel created moments
+// ago may be missing.
+func (s *Storage) GetBySenderPubKey(ctx context.Context, senderPubKey []byte) ([]storage.Record, error) {
+	return s.querySender(ctx, senderPubKey, "")
+}
+
+// batchGet returns the channels with the keys, at most pageBatch of them,
+// in ID order, retrying those DynamoDB didn't process with a backoff.
+// Channels that no longer exist are left out.
+func (s *Storage) batchGet(ctx context.Context, keys []map[string]types.AttributeValue) ([]storage.Record, error) {
+	var sl []storage.Record
+	for backoff := 50 * time.Millisecond; len(keys) > 0; backoff *= 2 {
+		out, err := s.client.BatchGetItem(ctx, &ddb.BatchGetItemInput{
+			RequestItems: map[string]types.KeysAndAttributes{s.table: {
+				Keys:           keys,
+				ConsistentRead: aws.Bool(true),
+			}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Responses[s.table] {
+			rec, err := decodeRecord(item)
+			if err != nil {
+				return nil, err
+			}
+			sl = append(sl, *rec)
+		}
+		keys = out.UnprocessedKeys[s.table].Keys
+		if len(keys) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+	sort.Slice(sl, func(i, j int) bool {
+		return sl[i].ID < sl[j].ID
+	})
+	return sl, nil
+}
+
+// ListPage queries the channel index, or the sender index if the filter has
+// a sender, for the keys of the channels after cursor, pageBatch at a time,
+// and reads the channels themselves until the page is full. The indexes are
+// eventually consistent, so a channel created moments ago may be missing.
+func (s *Storage) ListPage(ctx context.Context, f storage.ListFilter, cursor string, limit int) ([]storage.Record, string, error) {
+	in := &ddb.QueryInput{
+		TableName:                aws.String(s.table),
+		IndexName:                aws.String(channelIndex),
+		KeyConditionExpression:   aws.String("#kind = :kind AND #pk > :cursor"),
+		ProjectionExpression:     aws.String("#pk, #sk"),
+		ExpressionAttributeNames: names(attrKind, attrPK, attrSK),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":kind":   str(skChannel),
+			":cursor": str("channel#" + cursor),
+		},
+		Limit: aws.Int32(pageBatch),
+	}
+	if len(f.SenderPubKey) > 0 {
+		in.IndexName = aws.String(senderIndex)
+		in.KeyConditionExpression = aws.String("#sender = :sender AND #pk > :cursor")
+		in.ExpressionAttributeNames = names(attrSender, attrPK, attrSK)
+		in.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":sender": str(hex.EncodeToString(f.SenderPubKey)),
+			":cursor": str("channel#" + cursor),
+		}
+	}
+
+	sl := []storage.Record{}
+	var next string
+	var keys []map[string]types.AttributeValue
+	// flush reads the channels of the keys read so far, and returns errDone
+	// once there is a channel after the page.
+	flush := func() error {
+		recs, err := s.batchGet(ctx, keys)
+		if err != nil {
+			return err
+		}
+		keys = keys[:0]
+		for _, rec := range recs {
+			if !f.Match(rec) {
+				continue
+			}
+			if limit > 0 && len(sl) == limit {
+				next = sl[limit-1].ID
+				return errDone
+			}
+			sl = append(sl, rec)
+		}
+		return nil
+	}
+	err := s.eachQuery(ctx, in, func(item map[string]types.AttributeValue) error {
+		keys = append(keys, itemKey(getStr(item, attrPK), getStr(item, attrSK)))
+		if len(keys) < pageBatch {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if next == "" && len(keys) > 0 {
+		if err := flush(); err != nil && err != errDone {
+			return nil, "", err
+		}
+	}
+	return sl, next, nil
+}
+
+func (s *Storage) Create(ctx context.Context, rec storage.Record) error {
+	if rec.ID == "" {
+		return errors.New("invalid id")
+	}
+	rec.Version = 1
+	item, err := recordItem(rec)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		items := []types.TransactWriteItem{{Put: &types.Put{
+			TableName:                aws.String(s.table),
+			Item:                     item,
+			ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+			ExpressionAttributeNames: names(attrPK),
+		}}}
+
+		// Keep track of the highest key path used by a channel, for
+		// ReserveKeyPath.
+		kp, err := s.getKeyPaths(ctx)
+		if err != nil {
+			return err
+		}
+		if rec.KeyPath > kp.used {
+			upd := s.keyPathsUpdate(kp)
+			upd.UpdateExpression = aws.String("SET #used = :used, " +
+				"#counter = if_not_exists(#counter, :zero), " +
+				"#reuse = if_not_exists(#reuse, :zero)")
+			upd.ExpressionAttributeValues[":used"] = num(int64(rec.KeyPath))
+			upd.ExpressionAttributeValues[":zero"] = num(0)
+			items = append(items, types.TransactWriteItem{Update: upd})
+		}
+
+		err = s.transactWrite(ctx, items)
+		if _, ok := cancelled(err, 0); ok {
+			return errors.New("record already exists")
+		} else if _, ok := cancelled(err, 1); ok {
+			continue
+		}
+		return err
+	}
+	return errContention
+}
+
+func (s *Storage) Update(ctx context.Context, id string, version int64, new channels.SharedState, payment []byte) error {
+	return s.Commit(ctx, storage.Tx{ChannelID: id, Version: version, State: new, Payment: payment})
+}
+
+// paidInvoice returns the write that marks the transaction's invoice paid.
+func (s *Storage) paidInvoice(ctx context.Context, tx storage.Tx) (*types.Put, error) {
+	key := itemKey("invoice#"+tx.InvoiceID, "invoice")
+	item, err := s.get(ctx, key)
+	if err != nil {
+		return nil, err
+	} else if item == nil {
+		return nil, storage.ErrNotFound
+	}
+	var inv storage.Invoice
+	if err := getData(item, &inv); err != nil {
+		return nil, err
+	}
+	if inv.PaidChannel != "" {
+		return nil, storage.ErrInvoicePaid
+	}
+
+	inv.PaidChannel = tx.ChannelID
+	inv.PaidCount = tx.State.Count
+	inv.PaidTime = time.Now()
+	paid, err := dataItem("invoice#"+tx.InvoiceID, "invoice", inv)
+	if err != nil {
+		return nil, err
+	}
+	// Invoices only change when they're paid, so if it isn't the one read
+	// above, it's been paid since.
+	return &types.Put{
+		TableName:                aws.String(s.table),
+		Item:                     paid,
+		ConditionExpression:      aws.String("#data = :data"),
+		ExpressionAttributeNames: names(attrData),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":data": item[attrData],
+		},
+	}, nil
+}
+
+// Commit makes the writes in a single TransactWriteItems, which is
+// conditional on the channel's version, its lease and the invoice being
+// unpaid.
+func (s *Storage) Commit(ctx context.Context, tx storage.Tx) error {
+	id, version := tx.ChannelID, tx.Version
+	var invoice *types.Put
+	if tx.InvoiceID != "" {
+		var err error
+		if invoice, err = s.paidInvoice(ctx, tx); err != nil {
+			return err
+		}
+	}
+	state, err := json.Marshal(tx.State)
+	if err != nil {
+		return err
+	}
+	cond := "attribute_exists(#pk) AND #version = :version"
+	if version == 0 {
+		// Channels created before versions were recorded have none.
+		cond = "attribute_exists(#pk) AND (attribute_not_exists(#version) OR #version = :version)"
+	}
+	items := []types.TransactWriteItem{{Update: &types.Update{
+		TableName:                aws.String(s.table),
+		Key:                      channelKey(id),
+		UpdateExpression:         aws.String("SET #state = :state, #version = :next"),
+		ConditionExpression:      aws.String(cond),
+		ExpressionAttributeNames: names(attrPK, "state", "version"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":state":   str(string(state)),
+			":version": num(version),
+			":next":    num(version + 1),
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}}}
+
+	if tx.Payment != nil {
+		// Sequence numbers are taken in the order updates are made, since
+		// an update can only be made from the state of the one before it.
+		seq, err := s.channelSeq(ctx, id, "payment_seq")
+		if err != nil {
+			return err
+		}
+		item := itemKey("payments#"+id, seqKey(seq))
+		item["payment"] = bin(tx.Payment)
+		item["time"] = nanos(time.Now())
+		items = append(items, types.TransactWriteItem{Put: &types.Put{
+			TableName: aws.String(s.table),
+			Item:      item,
+		}})
+	}
+
+	fenced := -1
+	if token, ok := storage.FenceToken(ctx, id); ok {
+		fenced = len(items)
+		items = append(items, types.TransactWriteItem{ConditionCheck: &types.ConditionCheck{
+			TableName:                 aws.String(s.table),
+			Key:                       leaseKey(id),
+			ConditionExpression:       aws.String("attribute_not_exists(#pk) OR #token <= :token"),
+			ExpressionAttributeNames:  names(attrPK, "token"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":token": num(token)},
+		}})
+	}
+
+	paying := -1
+	if invoice != nil {
+		paying = len(items)
+		items = append(items, types.TransactWriteItem{Put: invoice})
+	}
+
+	if tx.Credit != nil {
+		credit, err := s.creditItems(ctx, *tx.Credit)
+		if err != nil {
+			return err
+		}
+		items = append(items, credit...)
+	}
+
+	err = s.transactWrite(ctx, items)
+	if r, ok := cancelled(err, 0); ok {
+		if r.Item == nil {
+			return storage.ErrNotFound
+		}
+		return storage.ErrConflict
+	} else if _, ok := cancelled(err, fenced); ok {
+		return storage.ErrFenced
+	} else if _, ok := cancelled(err, paying); ok {
+		return storage.ErrInvoicePaid
+	}
+	return err
+}
+
+// modify updates attributes of the channel's item, if it exists.
+func (s *Storage) modify(ctx context.Context, id string, values map[string]types.AttributeValue) error {
+	attrs := []string{attrPK}
+	var set []string
+	for name := range values {
+		attrs = append(attrs, name)
+		set = append(set, "#"+name+" = :"+name)
+	}
+	exprValues := make(map[string]types.AttributeValue, len(values))
+	for name, v := range values {
+		exprValues[":"+name] = v
+	}
+	_, err := s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       channelKey(id),
+		UpdateExpression:          aws.String("SET " + strings.Join(set, ", ")),
+		ConditionExpression:       aws.String("attribute_exists(#pk)"),
+		ExpressionAttributeNames:  names(attrs...),
+		ExpressionAttributeValues: exprValues,
+	})
+	if isConditionFailed(err) {
+		return storage.ErrNotFound
+	}
+	return err
+}
+
+// keyPaths is the state of ReserveKeyPath. Used is the highest key path of
+// a stored channel.
+type keyPaths struct {
+	exists               bool
+	counter, reuse, used int
+}
+
+func (s *Storage) getKeyPaths(ctx context.Context) (*keyPaths, error) {
+	item, err := s.get(ctx, itemKey("keypaths", "keypaths"))
+	if err != nil || item == nil {
+		return &keyPaths{}, err
+	}
+	kp := keyPaths{exists: true}
+	for _, f := range []struct {
+		name string
+		v    *int
+	}{{"counter", &kp.counter}, {"reuse", &kp.reuse}, {"used", &kp.used}} {
+		n, err := getNum(item, f.name)
+		if err != nil {
+			return nil, err
+		}
+		*f.v = int(n)
+	}
+	return &kp, nil
+}
+
+// keyPathsUpdate returns an update of the key paths, without its update
+// expression, conditional on them not having changed.
+func (s *Storage) keyPathsUpdate(kp *keyPaths) *types.Update {
+	upd := &types.Update{
+		TableName:                 aws.String(s.table),
+		Key:                       itemKey("keypaths", "keypaths"),
+		ExpressionAttributeNames:  names("counter", "reuse", "used"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{},
+	}
+	if !kp.exists {
+		upd.ConditionExpression = aws.String("attribute_not_exists(#pk)")
+		upd.ExpressionAttributeNames = names(attrPK, "counter", "reuse", "used")
+		return upd
+	}
+	upd.ConditionExpression = aws.String("#counter = :old_counter AND " +
+		"#reuse = :old_reuse AND #used = :old_used")
+	upd.ExpressionAttributeValues[":old_counter"] = num(int64(kp.counter))
+	upd.ExpressionAttributeValues[":old_reuse"] = num(int64(kp.reuse))
+	upd.ExpressionAttributeValues[":old_used"] = num(int64(kp.used))
+	return upd
+}
+
+func (s *Storage) ReserveKeyPath(ctx context.Context, gapLimit int) (int, error) {
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		kp, err := s.getKeyPaths(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		var path int
+		counter, reuse := kp.counter, kp.reuse
+		if gapLimit > 0 && counter-kp.used >= gapLimit {
+			reuse = reuse%gapLimit + 1
+			path = kp.used + reuse
+		} else {
+			counter++
+			path = counter
+		}
+
+		upd := s.keyPathsUpdate(kp)
+		upd.UpdateExpression = aws.String("SET #counter = :counter, #reuse = :reuse, " +
+			"#used = if_not_exists(#used, :zero)")
+		upd.ExpressionAttributeValues[":counter"] = num(int64(counter))
+		upd.ExpressionAttributeValues[":reuse"] = num(int64(reuse))
+		upd.ExpressionAttributeValues[":zero"] = num(0)
+		_, err = s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+			TableName:                 aws.String(s.table),
+			Key:                       upd.Key,
+			UpdateExpression:          upd.UpdateExpression,
+			ConditionExpression:       upd.ConditionExpression,
+			ExpressionAttributeNames:  upd.ExpressionAttributeNames,
+			ExpressionAttributeValues: upd.ExpressionAttributeValues,
+		})
+		if isConditionFailed(err) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		return path, nil
+	}
+	return 0, errContention
+}
+
+func (s *Storage) GetKeyPathCounter(ctx context.Context) (int, error) {
+	kp, err := s.getKeyPaths(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return kp.counter, nil
+}
+
+func (s *Storage) ListKeyGenerations(ctx context.Context) ([]storage.KeyGeneration, error) {
+	var sl []storage.KeyGeneration
+	if err := s.listData(ctx, "keygens", &sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) AddKeyGeneration(ctx context.Context, xpub string) (storage.KeyGeneration, error) {
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		gens, err := s.ListKeyGenerations(ctx)
+		if err != nil {
+			return storage.KeyGeneration{}, err
+		}
+		for _, g := range gens {
+			if g.XPub == xpub {
+				return storage.KeyGeneration{}, storage.ErrDuplicateKey
+			}
+		}
+
+		g := storage.KeyGeneration{
+			Generation: len(gens),
+			XPub:       xpub,
+			Created:    time.Now(),
+		}
+		item, err := dataItem("keygens", seqKey(int64(g.Generation)), g)
+		if err != nil {
+			return storage.KeyGeneration{}, err
+		}
+		// Another generation may have been added since they were listed.
+		err = s.put(ctx, item, "attribute_not_exists(pk)")
+		if isConditionFailed(err) {
+			continue
+		} else if err != nil {
+			return storage.KeyGeneration{}, err
+		}
+		return g, nil
+	}
+	return storage.KeyGeneration{}, errContention
+}
+
+func (s *Storage) ReserveDestinationIndex(ctx context.Context, xpub string) (uint32, error) {
+	n, err := s.increment(ctx, itemKey("destination#"+xpub, "destination"), "n", "")
+	if err != nil {
+		return 0, err
+	}
+	return uint32(n - 1), nil
+}
+
+func decodePayment(item map[string]types.AttributeValue) (storage.StoredPayment, error) {
+	t, err := getTime(item, "time")
+	if err != nil {
+		return storage.StoredPayment{}, err
+	}
+	return storage.StoredPayment{
+		ChannelID: strings.TrimPrefix(getStr(item, attrPK), "payments#"),
+		Payment:   getBin(item, "payment"),
+		Time:      t,
+	}, nil
+}
+
+func (s *Storage) ListPayments(ctx context.Context, channelID string) ([][]byte, error) {
+	sps, err := s.ListChannelPayments(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	var sl [][]byte
+	for _, sp := range sps {
+		sl = append(sl, sp.Payment)
+	}
+	return sl, nil
+}
+
+func (s *Storage) ListChannelPayments(ctx context.Context, channelID string) ([]storage.StoredPayment, error) {
+	var sl []storage.StoredPayment
+	err := s.query(ctx, "payments#"+channelID, func(item map[string]types.AttributeValue) error {
+		sp, err := decodePayment(item)
+		if err != nil {
+			return err
+		}
+		sl = append(sl, sp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+// ListPaymentsPage queries a channel's payments, or scans the table for all
+// channels', from the item of the cursor. Without a channel, the channels
+// are in the scan's order rather than by ID.
+func (s *Storage) ListPaymentsPage(ctx context.Context, f storage.PaymentFilter, cursor string, limit int) ([]storage.StoredPayment, string, error) {
+	var start map[string]types.AttributeValue
+	if cursor != "" {
+		id, pos, err := storage.ParsePaymentCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if f.ChannelID == "" || id == f.ChannelID {
+			start = itemKey("payments#"+id, pos)
+		} else if id > f.ChannelID {
+			return []storage.StoredPayment{}, "", nil
+		}
+	}
+
+	sl := []storage.StoredPayment{}
+	var next string
+	more := false
+	fn := func(item map[string]types.AttributeValue) error {
+		sp, err := decodePayment(item)
+		if err != nil {
+			return err
+		}
+		if !f.Match(sp) {
+			return nil
+		}
+		if limit > 0 && len(sl) == limit {
+			more = true
+			return errDone
+		}
+		sl = append(sl, sp)
+		next = storage.PaymentCursor(sp.ChannelID, getStr(item, attrSK))
+		return nil
+	}
+
+	var err error
+	if f.ChannelID != "" {
+		err = s.eachQuery(ctx, &ddb.QueryInput{
+			TableName:                aws.String(s.table),
+			ConsistentRead:           aws.Bool(true),
+			KeyConditionExpression:   aws.String("#pk = :pk"),
+			ExpressionAttributeNames: names(attrPK),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": str("payments#" + f.ChannelID),
+			},
+			ExclusiveStartKey: start,
+		}, fn)
+	} else {
+		err = s.eachScan(ctx, &ddb.ScanInput{
+			TableName:                aws.String(s.table),
+			ConsistentRead:           aws.Bool(true),
+			FilterExpression:         aws.String("begins_with(#pk, :prefix)"),
+			ExpressionAttributeNames: names(attrPK),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":prefix": str("payments#"),
+			},
+			ExclusiveStartKey: start,
+		}, fn)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if !more {
+		next = ""
+	}
+	return sl, next, nil
+}
+
+// QueryPayments scans the whole table.
+func (s *Storage) QueryPayments(ctx context.Context, from, to time.Time) ([]storage.StoredPayment, error) {
+	type payment struct {
+		storage.StoredPayment
+		seq string
+	}
+	var sl []payment
+	err := s.scan(ctx, "begins_with(#pk, :prefix)", attrPK,
+		map[string]types.AttributeValue{":prefix": str("payments#")},
+		func(item map[string]types.AttributeValue) error {
+			sp, err := decodePayment(item)
+			if err != nil {
+				return err
+			}
+			if (!from.IsZero() && sp.Time.Before(from)) || (!to.IsZero() && !sp.Time.Before(to)) {
+				return nil
+			}
+			sl = append(sl, payment{sp, getStr(item, attrSK)})
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// The scan returns them in no particular order.
+	sort.Slice(sl, func(i, j int) bool {
+		if !sl[i].Time.Equal(sl[j].Time) {
+			return sl[i].Time.Before(sl[j].Time)
+		}
+		if sl[i].ChannelID != sl[j].ChannelID {
+			return sl[i].ChannelID < sl[j].ChannelID
+		}
+		return sl[i].seq < sl[j].seq
+	})
+	var res []storage.StoredPayment
+	for _, p := range sl {
+		res = append(res, p.StoredPayment)
+	}
+	return res, nil
+}
+
+func (s *Storage) Freeze(ctx context.Context, id string, reason string) error {
+	return s.modify(ctx, id, map[string]types.AttributeValue{
+		"frozen":        boolean(true),
+		"frozen_reason": str(reason),
+	})
+}
+
+func (s *Storage) Suspend(ctx context.Context, id string, suspended bool, reason string) error {
+	return s.modify(ctx, id, map[string]types.AttributeValue{
+		"suspended":        boolean(suspended),
+		"suspended_reason": str(reason),
+	})
+}
+
+func (s *Storage) SetLabels(ctx context.Context, id string, labels map[string]string) error {
+	buf, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	return s.modify(ctx, id, map[string]types.AttributeValue{"labels": str(string(buf))})
+}
+
+func (s *Storage) SetClosure(ctx context.Context, id string, c storage.Closure) error {
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return s.modify(ctx, id, map[string]types.AttributeValue{"closure": str(string(buf))})
+}
+
+// ArchiveChannel sets the stub's location with a write conditional on the
+// channel's version, and then deletes the channel's logs in batches. If the
+// deletion fails, calling it again with the same version deletes the rest.
+func (s *Storage) ArchiveChannel(ctx context.Context, id string, version int64, location string) error {
+	cond := "attribute_exists(#pk) AND #version = :version"
+	if version == 0 {
+		cond = "attribute_exists(#pk) AND (attribute_not_exists(#version) OR #version = :version)"
+	}
+	_, err := s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                aws.String(s.table),
+		Key:                      channelKey(id),
+		UpdateExpression:         aws.String("SET #archived = :archived"),
+		ConditionExpression:      aws.String(cond),
+		ExpressionAttributeNames: names(attrPK, "version", "archived"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":version":  num(version),
+			":archived": str(location),
+		},
+	})
+	if isConditionFailed(err) {
+		if _, err := s.Get(ctx, id); err != nil {
+			return err
+		}
+		return storage.ErrConflict
+	} else if err != nil {
+		return err
+	}
+
+	var writes []types.WriteRequest
+	for _, pk := range []string{"payments#" + id, "revocations#" + id, "sent#" + id} {
+		err := s.query(ctx, pk, func(item map[string]types.AttributeValue) error {
+			writes = append(writes, types.WriteRequest{DeleteRequest: &types.DeleteRequest{
+				Key: itemKey(pk, getStr(item, attrSK)),
+			}})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return s.batchWrite(ctx, writes)
+}
+
+const (
+	settingWatchHeight   = "watch_height"
+	settingAllowlistOnly = "allowlist_only"
+)
+
+func (s *Storage) getSetting(ctx context.Context, name string) (int64, error) {
+	item, err := s.get(ctx, itemKey("settings", name))
+	if err != nil {
+		return 0, err
+	}
+	return getNum(item, "n")
+}
+
+func (s *Storage) setSetting(ctx context.Context, name string, v int64) error {
+	item := itemKey("settings", name)
+	item["n"] = num(v)
+	return s.put(ctx, item, "")
+}
+
+func (s *Storage) GetWatchHeight(ctx context.Context) (int64, error) {
+	return s.getSetting(ctx, settingWatchHeight)
+}
+
+func (s *Storage) SetWatchHeight(ctx context.Context, height int64) error {
+	return s.setSetting(ctx, settingWatchHeight, height)
+}
+
+func (s *Storage) GetAllowlistOnly(ctx context.Context) (bool, error) {
+	v, err := s.getSetting(ctx, settingAllowlistOnly)
+	return v != 0, err
+}
+
+func (s *Storage) SetAllowlistOnly(ctx context.Context, on bool) error {
+	var v int64
+	if on {
+		v = 1
+	}
+	return s.setSetting(ctx, settingAllowlistOnly, v)
+}
+
+func (s *Storage) AddRevocationSecret(ctx context.Context, channelID string, secret []byte) error {
+	seq, err := s.channelSeq(ctx, channelID, "revocation_seq")
+	if err != nil {
+		return err
+	}
+	item := itemKey("revocations#"+channelID, seqKey(seq))
+	item["secret"] = bin(secret)
+	return s.put(ctx, item, "")
+}
+
+func (s *Storage) ListRevocationSecrets(ctx context.Context, channelID string) ([][]byte, error) {
+	var sl [][]byte
+	err := s.query(ctx, "revocations#"+channelID, func(item map[string]types.AttributeValue) error {
+		sl = append(sl, getBin(item, "secret"))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) AddSentPayment(ctx context.Context, channelID string, p storage.SentPayment) error {
+	item, err := dataItem("sent#"+channelID, p.PaymentID, p)
+	if err != nil {
+		return err
+	}
+	err = s.transactWrite(ctx, []types.TransactWriteItem{
+		{ConditionCheck: &types.ConditionCheck{
+			TableName:                aws.String(s.table),
+			Key:                      channelKey(channelID),
+			ConditionExpression:      aws.String("attribute_exists(#pk)"),
+			ExpressionAttributeNames: names(attrPK),
+		}},
+		{Put: &types.Put{
+			TableName:                aws.String(s.table),
+			Item:                     item,
+			ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+			ExpressionAttributeNames: names(attrPK),
+		}},
+	})
+	if _, ok := cancelled(err, 0); ok {
+		return storage.ErrNotFound
+	} else if _, ok := cancelled(err, 1); ok {
+		// Like the filesystem storage, the first payment recorded with an
+		// ID is the one returned by GetSentPayment.
+		return nil
+	}
+	return err
+}
+
+func (s *Storage) GetSentPayment(ctx context.Context, channelID string, paymentID string) (*storage.SentPayment, error) {
+	item, err := s.get(ctx, itemKey("sent#"+channelID, paymentID))
+	if err != nil || item == nil {
+		return nil, err
+	}
+	var p storage.SentPayment
+	if err := getData(item, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *Storage) PutPending(ctx context.Context, p storage.Pending) error {
+	if p.FundingAddress == "" {
+		return errors.New("invalid funding address")
+	}
+	item, err := dataItem("pending", p.FundingAddress, p)
+	if err != nil {
+		return err
+	}
+	return s.put(ctx, item, "")
+}
+
+func (s *Storage) ListPending(ctx context.Context) ([]storage.Pending, error) {
+	var sl []storage.Pending
+	if err := s.listData(ctx, "pending", &sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) DeletePending(ctx context.Context, fundingAddress string) error {
+	return s.delete(ctx, itemKey("pending", fundingAddress))
+}
+
+func (s *Storage) AddAPIKey(ctx context.Context, k storage.APIKey) error {
+	item, err := dataItem("apikey#"+k.ID, "apikey", k)
+	if err != nil {
+		return err
+	}
+	err = s.put(ctx, item, "attribute_not_exists(pk)")
+	if isConditionFailed(err) {
+		return errors.New("api key already exists")
+	}
+	return err
+}
+
+func (s *Storage) GetAPIKey(ctx context.Context, id string) (*storage.APIKey, error) {
+	item, err := s.get(ctx, itemKey("apikey#"+id, "apikey"))
+	if err != nil || item == nil {
+		return nil, err
+	}
+	var k storage.APIKey
+	if err := getData(item, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *Storage) DeleteAPIKey(ctx context.Context, id string) error {
+	return s.delete(ctx, itemKey("apikey#"+id, "apikey"))
+}
+
+// addTotals returns an update adding a credit to the totals of an item,
+// which also sets the attributes in values.
+func (s *Storage) addTotals(key map[string]types.AttributeValue, c storage.TargetCredit, values map[string]types.AttributeValue) *types.Update {
+	attrs := []string{"count", "gross", "commission"}
+	var set []string
+	exprValues := map[string]types.AttributeValue{
+		":one":        num(1),
+		":gross":      num(c.Amount),
+		":commission": num(c.Commission),
+	}
+	for name, v := range values {
+		attrs = append(attrs, name)
+		set = append(set, "#"+name+" = :"+name)
+		exprValues[":"+name] = v
+	}
+	return &types.Update{
+		TableName: aws.String(s.table),
+		Key:       key,
+		UpdateExpression: aws.String("SET " + strings.Join(set, ", ") +
+			" ADD #count :one, #gross :gross, #commission :commission"),
+		ExpressionAttributeNames:  names(attrs...),
+		ExpressionAttributeValues: exprValues,
+	}
+}
+
+func (s *Storage) AddTargetCredit(ctx context.Context, c storage.TargetCredit) error {
+	items, err := s.creditItems(ctx, c)
+	if err != nil {
+		return err
+	}
+	return s.transactWrite(ctx, items)
+}
+
+// creditItems returns the writes that record the credit and add it to its
+// totals.
+func (s *Storage) creditItems(ctx context.Context, c storage.TargetCredit) ([]types.TransactWriteItem, error) {
+	seq, err := s.nextSeq(ctx, "credits")
+	if err != nil {
+		return nil, err
+	}
+	item, err := dataItem("credits", seqKey(seq), c)
+	if err != nil {
+		return nil, err
+	}
+	day := storage.Day(c.Time)
+
+	balance := s.addTotals(itemKey("balances", c.Target), c,
+		map[string]types.AttributeValue{"target": str(c.Target)})
+	daily := s.addTotals(itemKey("daily", seqKey(day.UnixNano())+"#"+c.Target), c,
+		map[string]types.AttributeValue{"day": nanos(day), "target": str(c.Target)})
+
+	return []types.TransactWriteItem{
+		{Put: &types.Put{TableName: aws.String(s.table), Item: item}},
+		{Update: balance},
+		{Update: daily},
+	}, nil
+}
+
+func (s *Storage) ListTargetCredits(ctx context.Context, from, to time.Time) ([]storage.TargetCredit, error) {
+	var all []storage.TargetCredit
+	if err := s.listData(ctx, "credits", &all); err != nil {
+		return nil, err
+	}
+	var sl []storage.TargetCredit
+	for _, c := range all {
+		if (!from.IsZero() && c.Time.Before(from)) || (!to.IsZero() && !c.Time.Before(to)) {
+			continue
+		}
+		sl = append(sl, c)
+	}
+	return sl, nil
+}
+
+// getTotals decodes the totals of a balance or daily total item.
+func getTotals(item map[string]types.AttributeValue) (count, gross, commission int64, err error) {
+	if count, err = getNum(item, "count"); err != nil {
+		return 0, 0, 0, err
+	}
+	if gross, err = getNum(item, "gross"); err != nil {
+		return 0, 0, 0, err
+	}
+	if commission, err = getNum(item, "commission"); err != nil {
+		return 0, 0, 0, err
+	}
+	return count, gross, commission, nil
+}
+
+func (s *Storage) ListTargetBalances(ctx context.Context) ([]storage.TargetBalance, error) {
+	var sl []storage.TargetBalance
+	err := s.query(ctx, "balances", func(item map[string]types.AttributeValue) error {
+		b := storage.TargetBalance{Target: getStr(item, "target")}
+		var err error
+		b.Count, b.Gross, b.Commission, err = getTotals(item)
+		if err != nil {
+			return err
+		}
+		sl = append(sl, b)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) ListDailyTotals(ctx context.Context, target string, from, to time.Time) ([]storage.DailyTotal, error) {
+	var sl []storage.DailyTotal
+	err := s.query(ctx, "daily", func(item map[string]types.AttributeValue) error {
+		day, err := getTime(item, "day")
+		if err != nil {
+			return err
+		}
+		t := storage.DailyTotal{Day: day.UTC(), Target: getStr(item, "target")}
+		if target != "" && t.Target != target {
+			return nil
+		}
+		if (!from.IsZero() && t.Day.Before(from)) || (!to.IsZero() && !t.Day.Before(to)) {
+			return nil
+		}
+		t.Count, t.Gross, t.Commission, err = getTotals(item)
+		if err != nil {
+			return err
+		}
+		sl = append(sl, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) AddInvoice(ctx context.Context, inv storage.Invoice) error {
+	item, err := dataItem("invoice#"+inv.ID, "invoice", inv)
+	if err != nil {
+		return err
+	}
+	err = s.put(ctx, item, "attribute_not_exists(pk)")
+	if isConditionFailed(err) {
+		return errors.New("invoice already exists")
+	}
+	return err
+}
+
+func (s *Storage) GetInvoice(ctx context.Context, id string) (*storage.Invoice, error) {
+	item, err := s.get(ctx, itemKey("invoice#"+id, "invoice"))
+	if err != nil {
+		return nil, err
+	} else if item == nil {
+		return nil, storage.ErrNotFound
+	}
+	var inv storage.Invoice
+	if err := getData(item, &inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// appendData adds v to the end of a partition ordered by the sequence of
+// the same name.
+func (s *Storage) appendData(ctx context.Context, pk string, v interface{}) error {
+	seq, err := s.nextSeq(ctx, pk)
+	if err != nil {
+		return err
+	}
+	item, err := dataItem(pk, seqKey(seq), v)
+	if err != nil {
+		return err
+	}
+	return s.put(ctx, item, "")
+}
+
+func (s *Storage) AddDeadLetter(ctx context.Context, dl storage.DeadLetter) error {
+	return s.appendData(ctx, "deadletters", dl)
+}
+
+func (s *Storage) ListDeadLetters(ctx context.Context) ([]storage.DeadLetter, error) {
+	var sl []storage.DeadLetter
+	if err := s.listData(ctx, "deadletters", &sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) AddOutbox(ctx context.Context, e storage.OutboxEvent) (int64, error) {
+	id, err := s.nextSeq(ctx, "outbox")
+	if err != nil {
+		return 0, err
+	}
+	e.ID = id
+	item, err := dataItem("outbox", seqKey(id), e)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.put(ctx, item, ""); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *Storage) ListOutbox(ctx context.Context, limit int) ([]storage.OutboxEvent, error) {
+	var sl []storage.OutboxEvent
+	err := s.query(ctx, "outbox", func(item map[string]types.AttributeValue) error {
+		if limit > 0 && len(sl) == limit {
+			return errDone
+		}
+		var e storage.OutboxEvent
+		if err := getData(item, &e); err != nil {
+			return err
+		}
+		sl = append(sl, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) DeleteOutbox(ctx context.Context, id int64) error {
+	return s.delete(ctx, itemKey("outbox", seqKey(id)))
+}
+
+// AcquireLease grants leases between processes sharing the table. Expiry
+// is measured with the instances' clocks, which must be roughly in sync.
+func (s *Storage) AcquireLease(ctx context.Context, id, owner string, ttl time.Duration) (int64, error) {
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		l, err := s.get(ctx, leaseKey(id))
+		if err != nil {
+			return 0, err
+		}
+		token, err := getNum(l, "token")
+		if err != nil {
+			return 0, err
+		}
+		expiry, err := getTime(l, "expiry")
+		if err != nil {
+			return 0, err
+		}
+
+		now := time.Now()
+		if getStr(l, "owner") != owner && now.Before(expiry) {
+			return 0, storage.ErrLeaseHeld
+		}
+
+		item := leaseKey(id)
+		item["owner"] = str(owner)
+		item["token"] = num(token + 1)
+		item["expiry"] = nanos(now.Add(ttl))
+		in := &ddb.PutItemInput{
+			TableName:                aws.String(s.table),
+			Item:                     item,
+			ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+			ExpressionAttributeNames: names(attrPK),
+		}
+		if l != nil {
+			in.ConditionExpression = aws.String("#token = :token")
+			in.ExpressionAttributeNames = names("token")
+			in.ExpressionAttributeValues = map[string]types.AttributeValue{":token": num(token)}
+		}
+		_, err = s.client.PutItem(ctx, in)
+		if isConditionFailed(err) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		return token + 1, nil
+	}
+	return 0, errContention
+}
+
+func (s *Storage) ReleaseLease(ctx context.Context, id, owner string, token int64) error {
+	// The token is kept so that later leases get higher tokens.
+	_, err := s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                aws.String(s.table),
+		Key:                      leaseKey(id),
+		UpdateExpression:         aws.String("SET #owner = :empty, #expiry = :zero"),
+		ConditionExpression:      aws.String("#owner = :owner AND #token = :token"),
+		ExpressionAttributeNames: names("owner", "expiry", "token"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty": str(""),
+			":zero":  num(0),
+			":owner": str(owner),
+			":token": num(token),
+		},
+	})
+	if isConditionFailed(err) {
+		return nil
+	}
+	return err
+}
+
+func accessKey(list storage.AccessList, kind storage.AccessKind, value string) map[string]types.AttributeValue {
+	return itemKey("access", string(list)+"#"+string(kind)+"#"+value)
+}
+
+// PutAccessEntry keeps the position of an entry it replaces, by keeping its
+// sequence number.
+func (s *Storage) PutAccessEntry(ctx context.Context, e storage.AccessEntry) error {
+	seq, err := s.nextSeq(ctx, "access")
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                aws.String(s.table),
+		Key:                      accessKey(e.List, e.Kind, e.Value),
+		UpdateExpression:         aws.String("SET #data = :data, #seq = if_not_exists(#seq, :seq)"),
+		ExpressionAttributeNames: names(attrData, "seq"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":data": str(string(buf)),
+			":seq":  num(seq),
+		},
+	})
+	return err
+}
+
+func (s *Storage) DeleteAccessEntry(ctx context.Context, list storage.AccessList, kind storage.AccessKind, value string) error {
+	_, err := s.client.DeleteItem(ctx, &ddb.DeleteItemInput{
+		TableName:                aws.String(s.table),
+		Key:                      accessKey(list, kind, value),
+		ConditionExpression:      aws.String("attribute_exists(#pk)"),
+		ExpressionAttributeNames: names(attrPK),
+	})
+	if isConditionFailed(err) {
+		return storage.ErrNotFound
+	}
+	return err
+}
+
+func (s *Storage) ListAccessEntries(ctx context.Context) ([]storage.AccessEntry, error) {
+	type entry struct {
+		storage.AccessEntry
+		seq int64
+	}
+	var sl []entry
+	err := s.query(ctx, "access", func(item map[string]types.AttributeValue) error {
+		var e entry
+		if err := getData(item, &e.AccessEntry); err != nil {
+			return err
+		}
+		var err error
+		if e.seq, err = getNum(item, "seq"); err != nil {
+			return err
+		}
+		sl = append(sl, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sl, func(i, j int) bool {
+		return sl[i].seq < sl[j].seq
+	})
+	var res []storage.AccessEntry
+	for _, e := range sl {
+		res = append(res, e.AccessEntry)
+	}
+	return res, nil
+}
+
+func (s *Storage) AddScreeningRecord(ctx context.Context, rec storage.ScreeningRecord) error {
+	return s.appendData(ctx, "screening", rec)
+}
+
+func (s *Storage) ListScreeningRecords(ctx context.Context) ([]storage.ScreeningRecord, error) {
+	var sl []storage.ScreeningRecord
+	if err := s.listData(ctx, "screening", &sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+// Make sure Storage implements storage.Storage.
+var _ storage.Storage = &Storage{}
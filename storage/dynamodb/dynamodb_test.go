@@ -0,0 +1,240 @@
+package dynamodb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/storagetest"
+)
+
+// testClient returns a client of the DynamoDB Local endpoint named by
+// MOONBEAM_DYNAMODB_ENDPOINT, e.g. http://localhost:8000. The test is
+// skipped if it isn't set.
+func testClient(t *testing.T) *ddb.Client {
+	t.Helper()
+	endpoint := os.Getenv("MOONBEAM_DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MOONBEAM_DYNAMODB_ENDPOINT not set")
+	}
+	return ddb.New(ddb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(endpoint),
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+	})
+}
+
+// open returns a storage using a new table, which is deleted when the test
+// ends.
+func open(t *testing.T, client *ddb.Client) *Storage {
+	t.Helper()
+	ctx := context.Background()
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	s := New(client, "moonbeam_test_"+hex.EncodeToString(b))
+	if err := s.CreateTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, err := client.DeleteTable(ctx, &ddb.DeleteTableInput{TableName: aws.String(s.table)})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	return s
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return open(t, testClient(t))
+	})
+}
+
+func TestUpdateClients(t *testing.T) {
+	ctx := context.Background()
+	s := open(t, testClient(t))
+	prev := storagetest.Create(t, s, "a")
+
+	// Conditional writes from separate clients race to replace the same
+	// state, as they would from several instances.
+	const n = 8
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		c := New(testClient(t), s.table)
+		next := prev
+		next.Count++
+		next.Balance += int64(i)
+		go func() { errs <- c.Update(ctx, "a", 1, next, []byte("p")) }()
+	}
+	var ok int
+	for i := 0; i < n; i++ {
+		err := <-errs
+		if err == nil {
+			ok++
+		} else if err != storage.ErrConflict {
+			t.Error(err)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("Expected one update to succeed, got %d", ok)
+	}
+
+	rec, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Version != 2 {
+		t.Errorf("Expected version 2, got %d", rec.Version)
+	}
+	// The payments of the updates that lost aren't stored.
+	payments, err := s.ListPayments(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 {
+		t.Errorf("Expected one payment, got %d", len(payments))
+	}
+}
+
+func TestUpdateUnversioned(t *testing.T) {
+	ctx := context.Background()
+	s := open(t, testClient(t))
+
+	// Channels created before versions were recorded have no version
+	// attribute, and are updated from version zero.
+	state := channels.SharedState{Status: channels.StatusOpen, Balance: 100}
+	item, err := recordItem(storage.Record{ID: "a", KeyPath: 1, SharedState: state})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(item, "version")
+	_, err = s.client.PutItem(ctx, &ddb.PutItemInput{TableName: aws.String(s.table), Item: item})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := state
+	next.Count++
+	if err := s.Update(ctx, "a", 1, next, nil); err != storage.ErrConflict {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+	if err := s.Update(ctx, "a", 0, next, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update(ctx, "a", 0, next, nil); err != storage.ErrConflict {
+		t.Errorf("Expected ErrConflict once versioned, got %v", err)
+	}
+	rec, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Version != 1 || rec.SharedState.Count != 1 {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+}
+
+func TestCommitAtomic(t *testing.T) {
+	ctx := context.Background()
+	s := open(t, testClient(t))
+	prev := storagetest.Create(t, s, "a")
+	storagetest.Create(t, s, "b")
+	if err := s.AddInvoice(ctx, storage.Invoice{ID: "inv", Amount: 10, Target: "t"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(ctx, storage.Record{ID: "a", KeyPath: 2}); err == nil {
+		t.Errorf("Expected an error creating a duplicate channel")
+	}
+
+	next := prev
+	next.Count++
+	tx := storage.Tx{ChannelID: "a", Version: 1, State: next, Payment: []byte("p"), InvoiceID: "missing"}
+	if err := s.Commit(ctx, tx); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	tx.InvoiceID = "inv"
+	if err := s.Commit(ctx, tx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Paying the invoice again fails the whole transaction, including the
+	// conditional write of the channel's state.
+	tx = storage.Tx{ChannelID: "b", Version: 1, State: next, Payment: []byte("p"), InvoiceID: "inv"}
+	if err := s.Commit(ctx, tx); err != storage.ErrInvoicePaid {
+		t.Errorf("Expected ErrInvoicePaid, got %v", err)
+	}
+	rec, err := s.Get(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Version != 1 || rec.SharedState.Count != 0 {
+		t.Errorf("Expected channel b to be unchanged, got %+v", rec)
+	}
+	payments, err := s.ListPayments(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 0 {
+		t.Errorf("Expected no payments, got %d", len(payments))
+	}
+	inv, err := s.GetInvoice(ctx, "inv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.PaidChannel != "a" || inv.PaidCount != 1 {
+		t.Errorf("Unexpected invoice %+v", inv)
+	}
+}
+
+func TestCommitInvoiceRace(t *testing.T) {
+	ctx := context.Background()
+	s := open(t, testClient(t))
+	if err := s.AddInvoice(ctx, storage.Invoice{ID: "inv", Amount: 10, Target: "t"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Channels racing to pay the same invoice may all read it unpaid, so
+	// only the condition on the invoice's item stops the others.
+	const n = 4
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		id := string(rune('a' + i))
+		next := storagetest.Create(t, s, id)
+		next.Count++
+		tx := storage.Tx{ChannelID: id, Version: 1, State: next, Payment: []byte("p"), InvoiceID: "inv"}
+		go func() { errs <- s.Commit(ctx, tx) }()
+	}
+	var ok int
+	for i := 0; i < n; i++ {
+		err := <-errs
+		if err == nil {
+			ok++
+		} else if err != storage.ErrInvoicePaid {
+			t.Error(err)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("Expected one payment of the invoice, got %d", ok)
+	}
+
+	inv, err := s.GetInvoice(ctx, "inv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := s.Get(ctx, inv.PaidChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Version != 2 {
+		t.Errorf("Expected the paying channel at version 2, got %d", rec.Version)
+	}
+}
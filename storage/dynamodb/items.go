@@ -0,0 +1,136 @@
+package dynamodb
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Everything is stored in one table, keyed by a partition key "pk" and a
+// sort key "sk":
+//
+//	channel#<id>        channel                the channel's record
+//	payments#<id>       <seq>                  a payment and its time
+//	revocations#<id>    <seq>                  a revocation secret
+//	sent#<id>           <payment id>           storage.SentPayment
+//	keypaths            keypaths               counter, reuse and used
+//	keygens             <generation>           storage.KeyGeneration
+//	destination#<xpub>  destination            next index
+//	settings            <name>                 int64
+//	pending             <address>              storage.Pending
+//	apikey#<id>         apikey                 storage.APIKey
+//	credits             <seq>                  storage.TargetCredit
+//	balances            <target>               count, gross and commission
+//	daily               <day>#<target>         count, gross and commission
+//	invoice#<id>        invoice                storage.Invoice
+//	deadletters         <seq>                  storage.DeadLetter
+//	outbox              <id>                   storage.OutboxEvent
+//	access              <list>#<kind>#<value>  storage.AccessEntry and its seq
+//	screening           <seq>                  storage.ScreeningRecord
+//	seq                 <name>                 the last number of a sequence
+//	lease#<id>          lease                  owner, token and expiry
+//
+// Numbers in sort keys are zero padded so that they sort in order. Values
+// without their own attributes are stored as JSON in "data".
+//
+// Channel items also have a "sender" attribute, the hex encoded public key
+// of the sender, which the sender index is keyed by, and a "kind" attribute
+// of "channel", which the channel index is keyed by so that channels can be
+// paged through in ID order without scanning the table.
+const (
+	attrPK     = "pk"
+	attrSK     = "sk"
+	attrData   = "data"
+	attrSender = "sender"
+	attrKind   = "kind"
+
+	skChannel = "channel"
+
+	senderIndex  = "sender"
+	channelIndex = "channels"
+)
+
+func itemKey(pk, sk string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{attrPK: str(pk), attrSK: str(sk)}
+}
+
+func channelKey(id string) map[string]types.AttributeValue {
+	return itemKey("channel#"+id, skChannel)
+}
+
+func leaseKey(id string) map[string]types.AttributeValue {
+	return itemKey("lease#"+id, "lease")
+}
+
+// seqKey formats a sequence number as a sort key.
+func seqKey(n int64) string {
+	return fmt.Sprintf("%020d", n)
+}
+
+func str(s string) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: s}
+}
+
+func num(n int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+func bin(b []byte) types.AttributeValue {
+	if b == nil {
+		b = []byte{}
+	}
+	return &types.AttributeValueMemberB{Value: b}
+}
+
+func boolean(b bool) types.AttributeValue {
+	return &types.AttributeValueMemberBOOL{Value: b}
+}
+
+// nanos encodes t with zero for the zero time.
+func nanos(t time.Time) types.AttributeValue {
+	if t.IsZero() {
+		return num(0)
+	}
+	return num(t.UnixNano())
+}
+
+// getStr returns a string attribute, or "" if the item doesn't have it.
+func getStr(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+// getNum returns a number attribute, or zero if the item doesn't have it.
+func getNum(item map[string]types.AttributeValue, name string) (int64, error) {
+	v, ok := item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, nil
+	}
+	return strconv.ParseInt(v.Value, 10, 64)
+}
+
+func getBin(item map[string]types.AttributeValue, name string) []byte {
+	if v, ok := item[name].(*types.AttributeValueMemberB); ok {
+		return v.Value
+	}
+	return nil
+}
+
+func getBool(item map[string]types.AttributeValue, name string) bool {
+	if v, ok := item[name].(*types.AttributeValueMemberBOOL); ok {
+		return v.Value
+	}
+	return false
+}
+
+func getTime(item map[string]types.AttributeValue, name string) (time.Time, error) {
+	n, err := getNum(item, name)
+	if err != nil || n == 0 {
+		return time.Time{}, err
+	}
+	return time.Unix(0, n), nil
+}
@@ -0,0 +1,178 @@
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+// batchSize is the most writes BatchWriteItem accepts.
+const batchSize = 25
+
+// snapshotData is the encoding of a snapshot: every item except leases.
+type snapshotData struct {
+	Items []map[string]snapshotValue
+}
+
+// snapshotValue is an attribute value of one of the types the storage
+// uses.
+type snapshotValue struct {
+	S    *string `json:",omitempty"`
+	N    *string `json:",omitempty"`
+	B    []byte  `json:",omitempty"`
+	BOOL *bool   `json:",omitempty"`
+}
+
+func encodeValue(v types.AttributeValue) (snapshotValue, error) {
+	switch v := v.(type) {
+	case *types.AttributeValueMemberS:
+		return snapshotValue{S: &v.Value}, nil
+	case *types.AttributeValueMemberN:
+		return snapshotValue{N: &v.Value}, nil
+	case *types.AttributeValueMemberB:
+		return snapshotValue{B: v.Value}, nil
+	case *types.AttributeValueMemberBOOL:
+		return snapshotValue{BOOL: &v.Value}, nil
+	}
+	return snapshotValue{}, fmt.Errorf("unexpected attribute value %T", v)
+}
+
+func (v snapshotValue) decode() types.AttributeValue {
+	switch {
+	case v.S != nil:
+		return str(*v.S)
+	case v.N != nil:
+		return &types.AttributeValueMemberN{Value: *v.N}
+	case v.BOOL != nil:
+		return boolean(*v.BOOL)
+	}
+	return bin(v.B)
+}
+
+func isLease(item map[string]types.AttributeValue) bool {
+	return strings.HasPrefix(getStr(item, attrPK), "lease#")
+}
+
+// Snapshot scans the table with strongly consistent reads. DynamoDB can't
+// read a whole table at a point in time, so updates made during the scan
+// may be partly included; take snapshots while no payments are being
+// accepted, or use the table's point-in-time recovery instead.
+func (s *Storage) Snapshot(ctx context.Context) (*storage.Snapshot, error) {
+	var d snapshotData
+	var res storage.Snapshot
+	err := s.scan(ctx, "", "", nil, func(item map[string]types.AttributeValue) error {
+		if isLease(item) {
+			return nil
+		}
+		pk := getStr(item, attrPK)
+		switch {
+		case strings.HasPrefix(pk, "channel#"):
+			res.Channels++
+		case strings.HasPrefix(pk, "payments#"):
+			res.Payments++
+		case pk == "keypaths":
+			n, err := getNum(item, "counter")
+			if err != nil {
+				return err
+			}
+			res.KeyPathCounter = int(n)
+		}
+
+		m := make(map[string]snapshotValue, len(item))
+		for name, v := range item {
+			sv, err := encodeValue(v)
+			if err != nil {
+				return fmt.Errorf("%s of %s: %w", name, pk, err)
+			}
+			m[name] = sv
+		}
+		d.Items = append(d.Items, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Data, err = json.Marshal(d); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Restore deletes every item except leases and writes the snapshot's, in
+// batches, adding the kind of channels snapshotted before the channel index.
+// It isn't atomic, so the receiver must be stopped while it runs.
+func (s *Storage) Restore(ctx context.Context, snap storage.Snapshot) error {
+	var d snapshotData
+	if err := json.Unmarshal(snap.Data, &d); err != nil {
+		return err
+	}
+
+	var writes []types.WriteRequest
+	err := s.scan(ctx, "", "", nil, func(item map[string]types.AttributeValue) error {
+		if !isLease(item) {
+			writes = append(writes, types.WriteRequest{DeleteRequest: &types.DeleteRequest{
+				Key: itemKey(getStr(item, attrPK), getStr(item, attrSK)),
+			}})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.batchWrite(ctx, writes); err != nil {
+		return err
+	}
+
+	writes = writes[:0]
+	for _, m := range d.Items {
+		item := make(map[string]types.AttributeValue, len(m))
+		for name, v := range m {
+			item[name] = v.decode()
+		}
+		if strings.HasPrefix(getStr(item, attrPK), "channel#") && getStr(item, attrSK) == skChannel {
+			item[attrKind] = str(skChannel)
+		}
+		writes = append(writes, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+	return s.batchWrite(ctx, writes)
+}
+
+// batchWrite makes the writes in batches, retrying those DynamoDB didn't
+// process with a backoff.
+func (s *Storage) batchWrite(ctx context.Context, writes []types.WriteRequest) error {
+	for len(writes) > 0 {
+		n := batchSize
+		if n > len(writes) {
+			n = len(writes)
+		}
+		batch := writes[:n]
+		writes = writes[n:]
+
+		for backoff := 50 * time.Millisecond; len(batch) > 0; backoff *= 2 {
+			out, err := s.client.BatchWriteItem(ctx, &ddb.BatchWriteItemInput{
+				RequestItems: map[string][]types.WriteRequest{s.table: batch},
+			})
+			if err != nil {
+				return err
+			}
+			batch = out.UnprocessedItems[s.table]
+			if len(batch) == 0 {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+	}
+	return nil
+}
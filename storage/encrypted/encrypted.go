@@ -0,0 +1,541 @@
+// Package encrypted wraps a storage.Storage so that channel states,
+// payments, sent payments and revocation secrets are encrypted at rest with
+// AES-GCM, and can't be read by anyone with access to the database alone.
+//
+// Each channel's values are encrypted with its own data key, which is
+// stored in the channel's state wrapped by a master key. Rotating the master
+// key only rewraps the data keys, see Rewrap, and leaves the payments as
+// they are.
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+// Storage encrypts the values it stores in the wrapped storage. Other data,
+// such as invoices and accounting totals, is stored as is.
+//
+// The wrapped storage filters channels on their status and sender, and
+// records the payment count of paid invoices, so a channel's stored state
+// only keeps Status, SenderPubKey and Count, with the wrapped data key and
+// the whole state encrypted in AppData.
+//
+// Each value is authenticated with the channel it belongs to, so encrypted
+// values can't be moved between channels. Values that aren't encrypted are
+// returned as is.
+type Storage struct {
+	storage.Storage
+
+	// keys are the master keys, the first of which wraps new data keys.
+	keys []masterKey
+
+	mu       sync.Mutex
+	dataKeys map[string]*dataKey
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+// New returns a storage that encrypts values before storing them in s, with
+// data keys wrapped by the first of keys. The others are earlier master
+// keys, kept to unwrap the data keys that haven't been rewrapped yet.
+func New(s storage.Storage, keys ...[]byte) (*Storage, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption key")
+	}
+	es := &Storage{Storage: s, dataKeys: make(map[string]*dataKey)}
+	for _, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		id := KeyID(key)
+		for _, mk := range es.keys {
+			if mk.id == id {
+				return nil, fmt.Errorf("duplicate encryption key %s", id)
+			}
+		}
+		es.keys = append(es.keys, masterKey{id: id, aead: aead})
+	}
+	return es, nil
+}
+
+// CurrentKeyID returns the ID of the master key that wraps new data keys.
+func (s *Storage) CurrentKeyID() string {
+	return s.keys[0].id
+}
+
+func (s *Storage) cached(id string) *dataKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dataKeys[id]
+}
+
+// cache remembers a channel's data key once it's known to be stored.
+func (s *Storage) cache(id string, dk *dataKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dataKeys[id] = dk
+}
+
+// openState decrypts a stored channel's state, returning its data key, or
+// nil if it was stored before channels had data keys.
+func (s *Storage) openState(rec *storage.Record) (channels.SharedState, *dataKey, error) {
+	v := rec.SharedState.AppData
+	var (
+		dk    *dataKey
+		plain []byte
+		err   error
+	)
+	switch {
+	case bytes.HasPrefix(v, magic):
+		keyID, wrapped, sealed, err := parseState(v)
+		if err != nil {
+			return channels.SharedState{}, nil, err
+		}
+		if dk, err = s.unwrap(rec.ID, keyID, wrapped); err != nil {
+			return channels.SharedState{}, nil, err
+		}
+		s.cache(rec.ID, dk)
+		if plain, err = open(dk.aead, "state:"+rec.ID, sealed); err != nil {
+			return channels.SharedState{}, nil, err
+		}
+	case bytes.HasPrefix(v, magicV1):
+		if plain, err = s.openV1("state:"+rec.ID, v[len(magicV1):]); err != nil {
+			return channels.SharedState{}, nil, err
+		}
+	default:
+		return rec.SharedState, nil, nil
+	}
+	var state channels.SharedState
+	if err := json.Unmarshal(plain, &state); err != nil {
+		return channels.SharedState{}, nil, err
+	}
+	return state, dk, nil
+}
+
+// sealState returns the state to store for a channel, with its data key
+// wrapped by the current master key.
+func (s *Storage) sealState(id string, dk *dataKey, state channels.SharedState) (channels.SharedState, error) {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return channels.SharedState{}, err
+	}
+	sealed, err := seal(nil, dk.aead, "state:"+id, buf)
+	if err != nil {
+		return channels.SharedState{}, err
+	}
+	keyID, wrapped, err := s.wrap(id, dk)
+	if err != nil {
+		return channels.SharedState{}, err
+	}
+	return channels.SharedState{
+		Status:       state.Status,
+		SenderPubKey: state.SenderPubKey,
+		Count:        state.Count,
+		AppData:      marshalState(keyID, wrapped, sealed),
+	}, nil
+}
+
+// writeKey returns the data key to write a channel's state with: its
+// stored one, or a new one if it was stored before data keys.
+func (s *Storage) writeKey(ctx context.Context, id string) (*dataKey, error) {
+	if dk := s.cached(id); dk != nil {
+		return dk, nil
+	}
+	rec, err := s.Storage.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	_, dk, err := s.openState(rec)
+	if err != nil {
+		return nil, err
+	}
+	if dk == nil {
+		return newDataKey()
+	}
+	return dk, nil
+}
+
+// storedKey returns a channel's stored data key, adding one to its state if
+// it was stored before data keys.
+func (s *Storage) storedKey(ctx context.Context, id string) (*dataKey, error) {
+	if dk := s.cached(id); dk != nil {
+		return dk, nil
+	}
+	for {
+		rec, err := s.Storage.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		state, dk, err := s.openState(rec)
+		if err != nil {
+			return nil, err
+		} else if dk != nil {
+			return dk, nil
+		}
+		if dk, err = newDataKey(); err != nil {
+			return nil, err
+		}
+		err = s.writeState(ctx, rec, state, dk)
+		if errors.Is(err, storage.ErrConflict) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return dk, nil
+	}
+}
+
+// writeState rewrites a stored channel's state with the data key, without
+// changing it otherwise.
+func (s *Storage) writeState(ctx context.Context, rec *storage.Record, state channels.SharedState, dk *dataKey) error {
+	sealed, err := s.sealState(rec.ID, dk, state)
+	if err != nil {
+		return err
+	}
+	if err := s.Storage.Update(ctx, rec.ID, rec.Version, sealed, nil); err != nil {
+		return err
+	}
+	s.cache(rec.ID, dk)
+	return nil
+}
+
+// readKey returns a channel's data key to decrypt its values with.
+func (s *Storage) readKey(ctx context.Context, id string) (*dataKey, error) {
+	if dk := s.cached(id); dk != nil {
+		return dk, nil
+	}
+	rec, err := s.Storage.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	_, dk, err := s.openState(rec)
+	if err != nil {
+		return nil, err
+	}
+	if dk == nil {
+		return nil, fmt.Errorf("channel %s has no data key", id)
+	}
+	return dk, nil
+}
+
+// openValue decrypts one of a channel's values, whether encrypted with its
+// data key or, before data keys, a master key.
+func (s *Storage) openValue(ctx context.Context, channelID, aad string, v []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(v, magic):
+		dk, err := s.readKey(ctx, channelID)
+		if err != nil {
+			return nil, err
+		}
+		return open(dk.aead, aad, v[len(magic):])
+	case bytes.HasPrefix(v, magicV1):
+		return s.openV1(aad, v[len(magicV1):])
+	default:
+		return v, nil
+	}
+}
+
+func sealValue(dk *dataKey, aad string, v []byte) ([]byte, error) {
+	return seal(append([]byte{}, magic...), dk.aead, aad, v)
+}
+
+// Rewrap wraps the data keys of channels wrapped by an earlier master key
+// with the current one, and adds data keys to channels stored before them,
+// so that earlier master keys can be retired. Only the channels' states are
+// rewritten. It returns the number of channels rewritten.
+//
+// Values encrypted with a master key directly, before data keys, are left
+// as they are, so the key that encrypted them must be kept.
+func (s *Storage) Rewrap(ctx context.Context) (int, error) {
+	var n int
+	var cursor string
+	for {
+		sl, next, err := s.Storage.ListPage(ctx, storage.ListFilter{}, cursor, 100)
+		if err != nil {
+			return n, err
+		}
+		for _, rec := range sl {
+			for {
+				ok, err := s.rewrap(ctx, &rec)
+				if errors.Is(err, storage.ErrConflict) {
+					cur, err := s.Storage.Get(ctx, rec.ID)
+					if err != nil {
+						return n, err
+					}
+					rec = *cur
+					continue
+				} else if err != nil {
+					return n, err
+				}
+				if ok {
+					n++
+				}
+				break
+			}
+		}
+		if next == "" {
+			return n, nil
+		}
+		cursor = next
+	}
+}
+
+// rewrap rewrites a stored channel's state if its data key isn't wrapped by
+// the current master key, or it has none, reporting whether it did.
+func (s *Storage) rewrap(ctx context.Context, rec *storage.Record) (bool, error) {
+	v := rec.SharedState.AppData
+	if bytes.HasPrefix(v, magic) {
+		keyID, _, _, err := parseState(v)
+		if err != nil {
+			return false, err
+		}
+		if keyID == s.keys[0].id {
+			return false, nil
+		}
+	}
+	state, dk, err := s.openState(rec)
+	if err != nil {
+		return false, err
+	}
+	if dk == nil {
+		if dk, err = newDataKey(); err != nil {
+			return false, err
+		}
+	}
+	if err := s.writeState(ctx, rec, state, dk); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Storage) openRecords(sl []storage.Record) error {
+	for i := range sl {
+		state, _, err := s.openState(&sl[i])
+		if err != nil {
+			return err
+		}
+		sl[i].SharedState = state
+	}
+	return nil
+}
+
+func (s *Storage) Get(ctx context.Context, id string) (*storage.Record, error) {
+	rec, err := s.Storage.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if rec.SharedState, _, err = s.openState(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (s *Storage) List(ctx context.Context) ([]storage.Record, error) {
+	sl, err := s.Storage.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.openRecords(sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+// GetBySenderPubKey relies on the sender pubkey being kept in clear.
+func (s *Storage) GetBySenderPubKey(ctx context.Context, senderPubKey []byte) ([]storage.Record, error) {
+	sl, err := s.Storage.GetBySenderPubKey(ctx, senderPubKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.openRecords(sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) ListPage(ctx context.Context, f storage.ListFilter, cursor string, limit int) ([]storage.Record, string, error) {
+	sl, next, err := s.Storage.ListPage(ctx, f, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.openRecords(sl); err != nil {
+		return nil, "", err
+	}
+	return sl, next, nil
+}
+
+func (s *Storage) Create(ctx context.Context, rec storage.Record) error {
+	dk, err := newDataKey()
+	if err != nil {
+		return err
+	}
+	if rec.SharedState, err = s.sealState(rec.ID, dk, rec.SharedState); err != nil {
+		return err
+	}
+	if err := s.Storage.Create(ctx, rec); err != nil {
+		return err
+	}
+	s.cache(rec.ID, dk)
+	return nil
+}
+
+func (s *Storage) Update(ctx context.Context, id string, version int64, new channels.SharedState, payment []byte) error {
+	return s.Commit(ctx, storage.Tx{ChannelID: id, Version: version, State: new, Payment: payment})
+}
+
+// Commit writes the state with the channel's data key. A channel stored
+// before data keys gets a new one, which is only kept if the commit
+// succeeds.
+func (s *Storage) Commit(ctx context.Context, tx storage.Tx) error {
+	dk, err := s.writeKey(ctx, tx.ChannelID)
+	if err != nil {
+		return err
+	}
+	if tx.State, err = s.sealState(tx.ChannelID, dk, tx.State); err != nil {
+		return err
+	}
+	// A nil payment means there is none.
+	if tx.Payment != nil {
+		tx.Payment, err = sealValue(dk, "payment:"+tx.ChannelID, tx.Payment)
+		if err != nil {
+			return err
+		}
+	}
+	if err := s.Storage.Commit(ctx, tx); err != nil {
+		return err
+	}
+	s.cache(tx.ChannelID, dk)
+	return nil
+}
+
+func (s *Storage) ListPayments(ctx context.Context, channelID string) ([][]byte, error) {
+	sl, err := s.Storage.ListPayments(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range sl {
+		if sl[i], err = s.openValue(ctx, channelID, "payment:"+channelID, p); err != nil {
+			return nil, err
+		}
+	}
+	return sl, nil
+}
+
+func (s *Storage) openPayments(ctx context.Context, sl []storage.StoredPayment) error {
+	for i, p := range sl {
+		var err error
+		sl[i].Payment, err = s.openValue(ctx, p.ChannelID, "payment:"+p.ChannelID, p.Payment)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) ListChannelPayments(ctx context.Context, channelID string) ([]storage.StoredPayment, error) {
+	sl, err := s.Storage.ListChannelPayments(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.openPayments(ctx, sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) ListPaymentsPage(ctx context.Context, f storage.PaymentFilter, cursor string, limit int) ([]storage.StoredPayment, string, error) {
+	sl, next, err := s.Storage.ListPaymentsPage(ctx, f, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.openPayments(ctx, sl); err != nil {
+		return nil, "", err
+	}
+	return sl, next, nil
+}
+
+func (s *Storage) QueryPayments(ctx context.Context, from, to time.Time) ([]storage.StoredPayment, error) {
+	sl, err := s.Storage.QueryPayments(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.openPayments(ctx, sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) AddRevocationSecret(ctx context.Context, channelID string, secret []byte) error {
+	dk, err := s.storedKey(ctx, channelID)
+	if err != nil {
+		return err
+	}
+	sealed, err := sealValue(dk, "revocation:"+channelID, secret)
+	if err != nil {
+		return err
+	}
+	return s.Storage.AddRevocationSecret(ctx, channelID, sealed)
+}
+
+func (s *Storage) ListRevocationSecrets(ctx context.Context, channelID string) ([][]byte, error) {
+	sl, err := s.Storage.ListRevocationSecrets(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	for i, secret := range sl {
+		sl[i], err = s.openValue(ctx, channelID, "revocation:"+channelID, secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sl, nil
+}
+
+func (s *Storage) AddSentPayment(ctx context.Context, channelID string, p storage.SentPayment) error {
+	dk, err := s.storedKey(ctx, channelID)
+	if err != nil {
+		return err
+	}
+	aad := "sent:" + channelID + ":" + p.PaymentID
+	if p.Payment, err = sealValue(dk, aad, p.Payment); err != nil {
+		return err
+	}
+	if p.Response, err = sealValue(dk, aad, p.Response); err != nil {
+		return err
+	}
+	return s.Storage.AddSentPayment(ctx, channelID, p)
+}
+
+func (s *Storage) GetSentPayment(ctx context.Context, channelID string, paymentID string) (*storage.SentPayment, error) {
+	p, err := s.Storage.GetSentPayment(ctx, channelID, paymentID)
+	if err != nil || p == nil {
+		return p, err
+	}
+	aad := "sent:" + channelID + ":" + paymentID
+	if p.Payment, err = s.openValue(ctx, channelID, aad, p.Payment); err != nil {
+		return nil, err
+	}
+	if p.Response, err = s.openValue(ctx, channelID, aad, p.Response); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Restore replaces the state, which may have different data keys.
+func (s *Storage) Restore(ctx context.Context, snap storage.Snapshot) error {
+	err := s.Storage.Restore(ctx, snap)
+	s.mu.Lock()
+	s.dataKeys = make(map[string]*dataKey)
+	s.mu.Unlock()
+	return err
+}
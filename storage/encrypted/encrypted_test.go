@@ -0,0 +1,319 @@
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/memory"
+	"github.com/luno/moonbeam/storage/storagetest"
+)
+
+func newStorage(t *testing.T) (*Storage, storage.Storage) {
+	t.Helper()
+	inner := memory.New()
+	s, err := New(inner, bytes.Repeat([]byte{1}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, inner
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, _ := newStorage(t)
+		return s
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s, inner := newStorage(t)
+
+	state := channels.SharedState{
+		Status:       channels.StatusOpen,
+		SenderPubKey: []byte("sender"),
+		SenderSig:    []byte("signature"),
+		Balance:      100,
+		Count:        1,
+	}
+	rec := storage.Record{ID: "a", KeyPath: 1, SharedState: state, Created: time.Now()}
+	if err := s.Create(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	next := state
+	next.Balance = 200
+	next.Count = 2
+	if err := s.Update(ctx, "a", 1, next, []byte("payment")); err != nil {
+		t.Fatal(err)
+	}
+	sent := storage.SentPayment{PaymentID: "p1", Payment: []byte("payment"), Response: []byte("response")}
+	if err := s.AddSentPayment(ctx, "a", sent); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRevocationSecret(ctx, "a", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SharedState.Balance != 200 || !bytes.Equal(got.SharedState.SenderSig, []byte("signature")) {
+		t.Errorf("Expected the updated state, got %+v", got.SharedState)
+	}
+	sl, _, err := s.ListPage(ctx, storage.ListFilter{SenderPubKey: []byte("sender")}, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sl) != 1 || sl[0].SharedState.Balance != 200 {
+		t.Errorf("Expected the channel listed by its sender, got %+v", sl)
+	}
+	payments, err := s.ListChannelPayments(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 || string(payments[0].Payment) != "payment" {
+		t.Errorf("Expected the payment, got %+v", payments)
+	}
+	gotSent, err := s.GetSentPayment(ctx, "a", "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(gotSent.Payment) != "payment" || string(gotSent.Response) != "response" {
+		t.Errorf("Expected the sent payment, got %+v", gotSent)
+	}
+	secrets, err := s.ListRevocationSecrets(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 1 || string(secrets[0]) != "secret" {
+		t.Errorf("Expected the revocation secret, got %q", secrets)
+	}
+
+	// The wrapped storage only sees what it filters on.
+	raw, err := inner.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.SharedState.Balance != 0 || raw.SharedState.SenderSig != nil {
+		t.Errorf("Expected the state to be encrypted, got %+v", raw.SharedState)
+	}
+	if raw.SharedState.Count != 2 || raw.Version != 2 {
+		t.Errorf("Expected count and version 2, got %d and %d",
+			raw.SharedState.Count, raw.Version)
+	}
+	rawPayments, err := inner.ListPayments(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rawPayments) != 1 || bytes.Contains(rawPayments[0], []byte("payment")) {
+		t.Errorf("Expected the payment to be encrypted, got %q", rawPayments)
+	}
+	rawSent, err := inner.GetSentPayment(ctx, "a", "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(rawSent.Response, []byte("response")) {
+		t.Errorf("Expected the sent payment to be encrypted, got %q", rawSent.Response)
+	}
+}
+
+func TestPlaintext(t *testing.T) {
+	ctx := context.Background()
+	s, inner := newStorage(t)
+
+	state := channels.SharedState{Status: channels.StatusOpen, Balance: 100}
+	rec := storage.Record{ID: "a", KeyPath: 1, SharedState: state, Created: time.Now()}
+	if err := inner.Create(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.Update(ctx, "a", 1, state, []byte("payment")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SharedState.Balance != 100 {
+		t.Errorf("Expected the stored state, got %+v", got.SharedState)
+	}
+	payments, err := s.ListPayments(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 || string(payments[0]) != "payment" {
+		t.Errorf("Expected the stored payment, got %q", payments)
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	ctx := context.Background()
+	s, inner := newStorage(t)
+
+	rec := storage.Record{ID: "a", KeyPath: 1, Created: time.Now()}
+	if err := s.Create(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	other, err := New(inner, bytes.Repeat([]byte{2}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Get(ctx, "a"); err == nil {
+		t.Errorf("Expected an error decrypting with another key")
+	}
+}
+
+func TestMovedState(t *testing.T) {
+	ctx := context.Background()
+	s, inner := newStorage(t)
+
+	for _, id := range []string{"a", "b"} {
+		rec := storage.Record{ID: id, KeyPath: 1, Created: time.Now()}
+		if err := s.Create(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw, err := inner.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.Update(ctx, "b", 1, raw.SharedState, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "b"); err == nil {
+		t.Errorf("Expected an error reading a state moved from another channel")
+	}
+}
+
+func TestKeySize(t *testing.T) {
+	if _, err := New(memory.New(), make([]byte, 16)); err == nil {
+		t.Errorf("Expected an error with a 16 byte key")
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	ctx := context.Background()
+	oldKey := bytes.Repeat([]byte{1}, KeySize)
+	newKey := bytes.Repeat([]byte{2}, KeySize)
+	s, inner := newStorage(t)
+
+	state := channels.SharedState{Status: channels.StatusOpen, Balance: 100}
+	rec := storage.Record{ID: "a", KeyPath: 1, SharedState: state, Created: time.Now()}
+	if err := s.Create(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update(ctx, "a", 1, state, []byte("payment")); err != nil {
+		t.Fatal(err)
+	}
+	before, err := inner.ListPayments(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := New(inner, newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := rotated.Rewrap(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 channel rewrapped, got %d", n)
+	}
+	if n, err := rotated.Rewrap(ctx); err != nil || n != 0 {
+		t.Errorf("Expected nothing left to rewrap, got %d, %v", n, err)
+	}
+
+	after, err := inner.ListPayments(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 1 || !bytes.Equal(after[0], before[0]) {
+		t.Errorf("Expected the payment to be left as it was")
+	}
+
+	// The old key is no longer needed.
+	retired, err := New(inner, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := retired.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SharedState.Balance != 100 {
+		t.Errorf("Expected the stored state, got %+v", got.SharedState)
+	}
+	payments, err := retired.ListPayments(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 || string(payments[0]) != "payment" {
+		t.Errorf("Expected the stored payment, got %q", payments)
+	}
+}
+
+func TestMasterKeyValues(t *testing.T) {
+	ctx := context.Background()
+	s, inner := newStorage(t)
+
+	// Values encrypted with the master key directly, before data keys.
+	aead, err := newAEAD(bytes.Repeat([]byte{1}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := json.Marshal(channels.SharedState{Status: channels.StatusOpen, Balance: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := seal(append([]byte{}, magicV1...), aead, "state:a", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := channels.SharedState{Status: channels.StatusOpen, AppData: sealed}
+	rec := storage.Record{ID: "a", KeyPath: 1, SharedState: state, Created: time.Now()}
+	if err := inner.Create(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := seal(append([]byte{}, magicV1...), aead, "revocation:a", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.AddRevocationSecret(ctx, "a", secret); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SharedState.Balance != 100 {
+		t.Errorf("Expected the stored state, got %+v", got.SharedState)
+	}
+
+	// Adding a secret gives the channel a data key.
+	if err := s.AddRevocationSecret(ctx, "a", []byte("secret2")); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := inner.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw.SharedState.AppData, magic) || raw.Version != 2 {
+		t.Errorf("Expected a data key added at version 2, got version %d", raw.Version)
+	}
+	secrets, err := s.ListRevocationSecrets(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 2 || string(secrets[0]) != "secret" || string(secrets[1]) != "secret2" {
+		t.Errorf("Expected both secrets, got %q", secrets)
+	}
+}
@@ -0,0 +1,167 @@
+package encrypted
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// KeySize is the size of master and data keys, which selects AES-256.
+const KeySize = 32
+
+var (
+	// magic prefixes values encrypted with their channel's data key. A
+	// channel's state also holds its data key, wrapped by a master key.
+	magic = []byte("mbenc2:")
+
+	// magicV1 prefixes values encrypted with a master key directly,
+	// before channels had data keys.
+	magicV1 = []byte("mbenc1:")
+)
+
+// KeyID returns the ID of a master key recorded with the data keys it
+// wraps. It's derived from the key, so it needn't be configured, and
+// doesn't reveal it.
+func KeyID(key []byte) string {
+	h := sha256.Sum256(append([]byte("moonbeam master key:"), key...))
+	return hex.EncodeToString(h[:4])
+}
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// dataKey encrypts the values of a single channel. It never changes once
+// stored, so rotating the master key only rewraps it.
+type dataKey struct {
+	key  []byte
+	aead cipher.AEAD
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newDataKey() (*dataKey, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return makeDataKey(key)
+}
+
+func makeDataKey(key []byte) (*dataKey, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &dataKey{key: key, aead: aead}, nil
+}
+
+// seal encrypts the value with a random nonce, authenticating the context
+// it's stored in, and appends it to out.
+func seal(out []byte, aead cipher.AEAD, aad string, plain []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plain, []byte(aad)), nil
+}
+
+// open decrypts a value encrypted by seal in the same context.
+func open(aead cipher.AEAD, aad string, v []byte) ([]byte, error) {
+	n := aead.NonceSize()
+	if len(v) < n {
+		return nil, errors.New("encrypted value is truncated")
+	}
+	plain, err := aead.Open(nil, v[:n], v[n:], []byte(aad))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s: %v", aad, err)
+	}
+	return plain, nil
+}
+
+// wrap encrypts a channel's data key with the current master key.
+func (s *Storage) wrap(id string, dk *dataKey) (string, []byte, error) {
+	mk := s.keys[0]
+	wrapped, err := seal(nil, mk.aead, "key:"+id, dk.key)
+	if err != nil {
+		return "", nil, err
+	}
+	return mk.id, wrapped, nil
+}
+
+// unwrap decrypts a channel's data key with the master key it was wrapped
+// by.
+func (s *Storage) unwrap(id, keyID string, wrapped []byte) (*dataKey, error) {
+	for _, mk := range s.keys {
+		if mk.id != keyID {
+			continue
+		}
+		key, err := open(mk.aead, "key:"+id, wrapped)
+		if err != nil {
+			return nil, err
+		}
+		return makeDataKey(key)
+	}
+	return nil, fmt.Errorf("channel %s data key is wrapped by unknown master key %s", id, keyID)
+}
+
+// openV1 decrypts a value encrypted with a master key directly, trying
+// each master key, since the value doesn't record which.
+func (s *Storage) openV1(aad string, v []byte) ([]byte, error) {
+	var err error
+	for _, mk := range s.keys {
+		var plain []byte
+		if plain, err = open(mk.aead, aad, v); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, err
+}
+
+// marshalState returns a sealed state's AppData, holding the ID of the
+// master key, the wrapped data key and then the sealed state.
+func marshalState(keyID string, wrapped, sealed []byte) []byte {
+	b := append([]byte{}, magic...)
+	b = append(b, byte(len(keyID)))
+	b = append(b, keyID...)
+	b = append(b, byte(len(wrapped)))
+	b = append(b, wrapped...)
+	return append(b, sealed...)
+}
+
+// parseState splits AppData marshalled by marshalState.
+func parseState(v []byte) (keyID string, wrapped, sealed []byte, err error) {
+	v = bytes.TrimPrefix(v, magic)
+	field := func() ([]byte, error) {
+		if len(v) < 1 || len(v) < 1+int(v[0]) {
+			return nil, errors.New("encrypted state is truncated")
+		}
+		f := v[1 : 1+int(v[0])]
+		v = v[1+int(v[0]):]
+		return f, nil
+	}
+	id, err := field()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if wrapped, err = field(); err != nil {
+		return "", nil, nil, err
+	}
+	return string(id), wrapped, v, nil
+}
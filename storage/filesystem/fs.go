@@ -1,10 +1,16 @@
 package filesystem
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/luno/moonbeam/channels"
 	"github.com/luno/moonbeam/storage"
@@ -12,20 +18,44 @@ import (
 
 type data struct {
 	KeyPathCounter int
+	KeyPathReuse   int
+	KeyGenerations []storage.KeyGeneration
+	Destinations   map[string]uint32
 	Channels       map[string]storage.Record
 	Payments       map[string][][]byte
+	PaymentTimes   map[string][]time.Time
+	Revocations    map[string][][]byte
+	SentPayments   map[string][]storage.SentPayment
+	Pending        map[string]storage.Pending
+	APIKeys        map[string]storage.APIKey
+	Credits        []storage.TargetCredit
+	Invoices       map[string]storage.Invoice
+	Balances       map[string]storage.TargetBalance
+	Daily          map[string]storage.DailyTotal
+	DeadLetters    []storage.DeadLetter
+	WatchHeight    int64
+	Outbox         []storage.OutboxEvent
+	OutboxSeq      int64
+	Leases         map[string]storage.Lease
+	Access         []storage.AccessEntry
+	AllowlistOnly  bool
+	Screening      []storage.ScreeningRecord
 }
 
 func newData() *data {
 	return &data{
-		Channels: make(map[string]storage.Record),
-		Payments: make(map[string][][]byte),
+		Channels:    make(map[string]storage.Record),
+		Payments:    make(map[string][][]byte),
+		Revocations: make(map[string][][]byte),
 	}
 }
 
 type FilesystemStorage struct {
 	mu   sync.RWMutex
 	path string
+
+	// mem is the encoded state of a storage without a path.
+	mem []byte
 }
 
 func NewFilesystemStorage(path string) *FilesystemStorage {
@@ -34,7 +64,25 @@ func NewFilesystemStorage(path string) *FilesystemStorage {
 	}
 }
 
+// NewMemoryStorage returns a storage that keeps its encoded state in memory
+// instead of a file. It's decoded on every call, like the file, so callers
+// never share records with the storage.
+func NewMemoryStorage() *FilesystemStorage {
+	return &FilesystemStorage{}
+}
+
 func (fs *FilesystemStorage) load() (*data, error) {
+	if fs.path == "" {
+		if fs.mem == nil {
+			return newData(), nil
+		}
+		var d data
+		if err := json.Unmarshal(fs.mem, &d); err != nil {
+			return nil, err
+		}
+		return &d, nil
+	}
+
 	f, err := os.Open(fs.path)
 	if os.IsNotExist(err) {
 		return newData(), nil
@@ -51,6 +99,15 @@ func (fs *FilesystemStorage) load() (*data, error) {
 }
 
 func (fs *FilesystemStorage) save(d *data) error {
+	if fs.path == "" {
+		buf, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		fs.mem = buf
+		return nil
+	}
+
 	tmp := fs.path + ".tmp"
 
 	f, err := os.Create(tmp)
@@ -78,7 +135,7 @@ func getChannel(d *data, id string) (*storage.Record, error) {
 	return &r, nil
 }
 
-func (fs *FilesystemStorage) Get(id string) (*storage.Record, error) {
+func (fs *FilesystemStorage) Get(ctx context.Context, id string) (*storage.Record, error) {
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
@@ -90,7 +147,7 @@ func (fs *FilesystemStorage) Get(id string) (*storage.Record, error) {
 	return getChannel(d, id)
 }
 
-func (fs *FilesystemStorage) List() ([]storage.Record, error) {
+func (fs *FilesystemStorage) List(ctx context.Context) ([]storage.Record, error) {
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
@@ -111,7 +168,71 @@ func (fs *FilesystemStorage) List() ([]storage.Record, error) {
 	return sl, nil
 }
 
-func (fs *FilesystemStorage) Create(rec storage.Record) error {
+// GetBySenderPubKey scans the channels, which are all in memory once the
+// file is loaded, so there's no index to keep.
+func (fs *FilesystemStorage) GetBySenderPubKey(ctx context.Context, senderPubKey []byte) ([]storage.Record, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for id, rec := range d.Channels {
+		if bytes.Equal(rec.SharedState.SenderPubKey, senderPubKey) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var sl []storage.Record
+	for _, id := range ids {
+		r, err := getChannel(d, id)
+		if err != nil {
+			return nil, err
+		}
+		sl = append(sl, *r)
+	}
+	return sl, nil
+}
+
+func (fs *FilesystemStorage) ListPage(ctx context.Context, f storage.ListFilter, cursor string, limit int) ([]storage.Record, string, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var ids []string
+	for id, rec := range d.Channels {
+		if id > cursor && f.Match(rec) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var next string
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+		next = ids[limit-1]
+	}
+
+	sl := make([]storage.Record, 0, len(ids))
+	for _, id := range ids {
+		r, err := getChannel(d, id)
+		if err != nil {
+			return nil, "", err
+		}
+		sl = append(sl, *r)
+	}
+	return sl, next, nil
+}
+
+func (fs *FilesystemStorage) Create(ctx context.Context, rec storage.Record) error {
 	if rec.ID == "" {
 		return errors.New("invalid id")
 	}
@@ -128,20 +249,17 @@ func (fs *FilesystemStorage) Create(rec storage.Record) error {
 		return errors.New("record already exists")
 	}
 
+	rec.Version = 1
 	d.Channels[rec.ID] = rec
 
 	return fs.save(d)
 }
 
-func checkSame(d *data, id string, prev channels.SharedState) bool {
-	s := d.Channels[id].SharedState
-	return s.Status == prev.Status &&
-		s.Count == prev.Count &&
-		s.Balance == prev.Balance &&
-		s.PaymentsHash == prev.PaymentsHash
+func (fs *FilesystemStorage) Update(ctx context.Context, id string, version int64, new channels.SharedState, payment []byte) error {
+	return fs.Commit(ctx, storage.Tx{ChannelID: id, Version: version, State: new, Payment: payment})
 }
 
-func (fs *FilesystemStorage) Update(id string, prev, new channels.SharedState, payment []byte) error {
+func (fs *FilesystemStorage) Commit(ctx context.Context, tx storage.Tx) error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
@@ -150,25 +268,68 @@ func (fs *FilesystemStorage) Update(id string, prev, new channels.SharedState, p
 		return err
 	}
 
+	var inv storage.Invoice
+	if tx.InvoiceID != "" {
+		var ok bool
+		inv, ok = d.Invoices[tx.InvoiceID]
+		if !ok {
+			return storage.ErrNotFound
+		}
+		if inv.Paid() {
+			return storage.ErrInvoicePaid
+		}
+	}
+
+	if err := applyUpdate(ctx, d, tx.ChannelID, tx.Version, tx.State, tx.Payment); err != nil {
+		return err
+	}
+
+	if tx.InvoiceID != "" {
+		inv.PaidChannel = tx.ChannelID
+		inv.PaidCount = tx.State.Count
+		inv.PaidTime = time.Now()
+		d.Invoices[tx.InvoiceID] = inv
+	}
+	if tx.Credit != nil {
+		addCredit(d, *tx.Credit)
+	}
+
+	return fs.save(d)
+}
+
+func applyUpdate(ctx context.Context, d *data, id string, version int64, new channels.SharedState, payment []byte) error {
 	if _, ok := d.Channels[id]; !ok {
 		return storage.ErrNotFound
 	}
 
-	if !checkSame(d, id, prev) {
-		return storage.ErrConcurrentUpdate
+	if token, ok := storage.FenceToken(ctx, id); ok && token < d.Leases[id].Token {
+		return storage.ErrFenced
 	}
 
 	rec := d.Channels[id]
+	if rec.Version != version {
+		return storage.ErrConflict
+	}
 	rec.SharedState = new
+	rec.Version++
 	d.Channels[id] = rec
 	if payment != nil {
+		if d.PaymentTimes == nil {
+			d.PaymentTimes = make(map[string][]time.Time)
+		}
+		// Payments stored before times were recorded have zero times.
+		times := d.PaymentTimes[id]
+		for len(times) < len(d.Payments[id]) {
+			times = append(times, time.Time{})
+		}
+		d.PaymentTimes[id] = append(times, time.Now())
 		d.Payments[id] = append(d.Payments[id], payment)
 	}
 
-	return fs.save(d)
+	return nil
 }
 
-func (fs *FilesystemStorage) ReserveKeyPath() (int, error) {
+func (fs *FilesystemStorage) ReserveKeyPath(ctx context.Context, gapLimit int) (int, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
@@ -177,12 +338,87 @@ func (fs *FilesystemStorage) ReserveKeyPath() (int, error) {
 		return 0, err
 	}
 
+	var used int
+	for _, rec := range d.Channels {
+		if rec.KeyPath > used {
+			used = rec.KeyPath
+		}
+	}
+
+	if gapLimit > 0 && d.KeyPathCounter-used >= gapLimit {
+		d.KeyPathReuse = d.KeyPathReuse%gapLimit + 1
+		return used + d.KeyPathReuse, fs.save(d)
+	}
+
 	d.KeyPathCounter++
 
 	return d.KeyPathCounter, fs.save(d)
 }
 
-func (fs *FilesystemStorage) ListPayments(channelID string) ([][]byte, error) {
+func (fs *FilesystemStorage) GetKeyPathCounter(ctx context.Context) (int, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return 0, err
+	}
+	return d.KeyPathCounter, nil
+}
+
+func (fs *FilesystemStorage) ListKeyGenerations(ctx context.Context) ([]storage.KeyGeneration, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+	return d.KeyGenerations, nil
+}
+
+func (fs *FilesystemStorage) AddKeyGeneration(ctx context.Context, xpub string) (storage.KeyGeneration, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return storage.KeyGeneration{}, err
+	}
+	for _, g := range d.KeyGenerations {
+		if g.XPub == xpub {
+			return storage.KeyGeneration{}, storage.ErrDuplicateKey
+		}
+	}
+	g := storage.KeyGeneration{
+		Generation: len(d.KeyGenerations),
+		XPub:       xpub,
+		Created:    time.Now(),
+	}
+	d.KeyGenerations = append(d.KeyGenerations, g)
+
+	return g, fs.save(d)
+}
+
+func (fs *FilesystemStorage) ReserveDestinationIndex(ctx context.Context, xpub string) (uint32, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return 0, err
+	}
+
+	if d.Destinations == nil {
+		d.Destinations = make(map[string]uint32)
+	}
+	n := d.Destinations[xpub]
+	d.Destinations[xpub] = n + 1
+
+	return n, fs.save(d)
+}
+
+func (fs *FilesystemStorage) ListPayments(ctx context.Context, channelID string) ([][]byte, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
@@ -194,5 +430,860 @@ func (fs *FilesystemStorage) ListPayments(channelID string) ([][]byte, error) {
 	return d.Payments[channelID], nil
 }
 
-// Make sure FilesystemStorage implements Storage.
-var _ storage.Storage = &FilesystemStorage{}
+func (fs *FilesystemStorage) ListChannelPayments(ctx context.Context, channelID string) ([]storage.StoredPayment, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	times := d.PaymentTimes[channelID]
+	var sl []storage.StoredPayment
+	for i, payment := range d.Payments[channelID] {
+		sp := storage.StoredPayment{ChannelID: channelID, Payment: payment}
+		if i < len(times) {
+			sp.Time = times[i]
+		}
+		sl = append(sl, sp)
+	}
+	return sl, nil
+}
+
+func (fs *FilesystemStorage) QueryPayments(ctx context.Context, from, to time.Time) ([]storage.StoredPayment, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var sl []storage.StoredPayment
+	for id, payments := range d.Payments {
+		times := d.PaymentTimes[id]
+		for i, payment := range payments {
+			var t time.Time
+			if i < len(times) {
+				t = times[i]
+			}
+			if (!from.IsZero() && t.Before(from)) || (!to.IsZero() && !t.Before(to)) {
+				continue
+			}
+			sl = append(sl, storage.StoredPayment{
+				ChannelID: id,
+				Payment:   payment,
+				Time:      t,
+			})
+		}
+	}
+	sort.SliceStable(sl, func(i, j int) bool {
+		if !sl[i].Time.Equal(sl[j].Time) {
+			return sl[i].Time.Before(sl[j].Time)
+		}
+		return sl[i].ChannelID < sl[j].ChannelID
+	})
+	return sl, nil
+}
+
+// ListPaymentsPage orders the channels by ID.
+func (fs *FilesystemStorage) ListPaymentsPage(ctx context.Context, f storage.PaymentFilter, cursor string, limit int) ([]storage.StoredPayment, string, error) {
+	after, afterIndex := "", -1
+	if cursor != "" {
+		id, pos, err := storage.ParsePaymentCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if afterIndex, err = strconv.Atoi(pos); err != nil {
+			return nil, "", fmt.Errorf("invalid payment cursor %q", cursor)
+		}
+		after = id
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var ids []string
+	for id := range d.Payments {
+		if id >= after && (f.ChannelID == "" || id == f.ChannelID) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	sl := []storage.StoredPayment{}
+	var next string
+	for _, id := range ids {
+		times := d.PaymentTimes[id]
+		for i, payment := range d.Payments[id] {
+			if id == after && i <= afterIndex {
+				continue
+			}
+			sp := storage.StoredPayment{ChannelID: id, Payment: payment}
+			if i < len(times) {
+				sp.Time = times[i]
+			}
+			if !f.Match(sp) {
+				continue
+			}
+			if limit > 0 && len(sl) == limit {
+				return sl, next, nil
+			}
+			sl = append(sl, sp)
+			next = storage.PaymentCursor(id, strconv.Itoa(i))
+		}
+	}
+	return sl, "", nil
+}
+
+func (fs *FilesystemStorage) Freeze(ctx context.Context, id string, reason string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	rec, ok := d.Channels[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	rec.Frozen = true
+	rec.FrozenReason = reason
+	d.Channels[id] = rec
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) Suspend(ctx context.Context, id string, suspended bool, reason string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	rec, ok := d.Channels[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	rec.Suspended = suspended
+	rec.SuspendedReason = reason
+	d.Channels[id] = rec
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) SetLabels(ctx context.Context, id string, labels map[string]string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	rec, ok := d.Channels[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	rec.Labels = labels
+	d.Channels[id] = rec
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) GetWatchHeight(ctx context.Context) (int64, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return 0, err
+	}
+	return d.WatchHeight, nil
+}
+
+func (fs *FilesystemStorage) SetWatchHeight(ctx context.Context, height int64) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+	d.WatchHeight = height
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) SetClosure(ctx context.Context, id string, c storage.Closure) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	rec, ok := d.Channels[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	rec.Closure = c
+	d.Channels[id] = rec
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) ArchiveChannel(ctx context.Context, id string, version int64, location string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	rec, ok := d.Channels[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if rec.Version != version {
+		return storage.ErrConflict
+	}
+	rec.Archived = location
+	d.Channels[id] = rec
+	delete(d.Payments, id)
+	delete(d.PaymentTimes, id)
+	delete(d.Revocations, id)
+	delete(d.SentPayments, id)
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) AddRevocationSecret(ctx context.Context, channelID string, secret []byte) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := d.Channels[channelID]; !ok {
+		return storage.ErrNotFound
+	}
+
+	if d.Revocations == nil {
+		d.Revocations = make(map[string][][]byte)
+	}
+	d.Revocations[channelID] = append(d.Revocations[channelID], secret)
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) ListRevocationSecrets(ctx context.Context, channelID string) ([][]byte, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return d.Revocations[channelID], nil
+}
+
+func (fs *FilesystemStorage) AddSentPayment(ctx context.Context, channelID string, p storage.SentPayment) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := d.Channels[channelID]; !ok {
+		return storage.ErrNotFound
+	}
+
+	if d.SentPayments == nil {
+		d.SentPayments = make(map[string][]storage.SentPayment)
+	}
+	d.SentPayments[channelID] = append(d.SentPayments[channelID], p)
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) GetSentPayment(ctx context.Context, channelID string, paymentID string) (*storage.SentPayment, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range d.SentPayments[channelID] {
+		if p.PaymentID == paymentID {
+			return &p, nil
+		}
+	}
+	return nil, nil
+}
+
+func (fs *FilesystemStorage) PutPending(ctx context.Context, p storage.Pending) error {
+	if p.FundingAddress == "" {
+		return errors.New("invalid funding address")
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	if d.Pending == nil {
+		d.Pending = make(map[string]storage.Pending)
+	}
+	d.Pending[p.FundingAddress] = p
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) ListPending(ctx context.Context) ([]storage.Pending, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var sl []storage.Pending
+	for _, p := range d.Pending {
+		sl = append(sl, p)
+	}
+	return sl, nil
+}
+
+func (fs *FilesystemStorage) DeletePending(ctx context.Context, fundingAddress string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := d.Pending[fundingAddress]; !ok {
+		return nil
+	}
+	delete(d.Pending, fundingAddress)
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) AddDeadLetter(ctx context.Context, dl storage.DeadLetter) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	d.DeadLetters = append(d.DeadLetters, dl)
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) ListDeadLetters(ctx context.Context) ([]storage.DeadLetter, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return d.DeadLetters, nil
+}
+
+func (fs *FilesystemStorage) AddOutbox(ctx context.Context, e storage.OutboxEvent) (int64, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return 0, err
+	}
+
+	d.OutboxSeq++
+	e.ID = d.OutboxSeq
+	d.Outbox = append(d.Outbox, e)
+
+	return e.ID, fs.save(d)
+}
+
+func (fs *FilesystemStorage) ListOutbox(ctx context.Context, limit int) ([]storage.OutboxEvent, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	res := d.Outbox
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (fs *FilesystemStorage) DeleteOutbox(ctx context.Context, id int64) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	for i, e := range d.Outbox {
+		if e.ID == id {
+			d.Outbox = append(d.Outbox[:i], d.Outbox[i+1:]...)
+			return fs.save(d)
+		}
+	}
+	return nil
+}
+
+func (fs *FilesystemStorage) Snapshot(ctx context.Context) (*storage.Snapshot, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+
+	s := storage.Snapshot{
+		Data:           buf,
+		Channels:       len(d.Channels),
+		KeyPathCounter: d.KeyPathCounter,
+	}
+	for _, p := range d.Payments {
+		s.Payments += len(p)
+	}
+	return &s, nil
+}
+
+func (fs *FilesystemStorage) Restore(ctx context.Context, s storage.Snapshot) error {
+	var restored data
+	if err := json.Unmarshal(s.Data, &restored); err != nil {
+		return err
+	}
+	if restored.Channels == nil {
+		restored.Channels = make(map[string]storage.Record)
+	}
+	if restored.Payments == nil {
+		restored.Payments = make(map[string][][]byte)
+	}
+	if restored.Revocations == nil {
+		restored.Revocations = make(map[string][][]byte)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+	restored.Leases = d.Leases
+
+	return fs.save(&restored)
+}
+
+// AcquireLease grants leases between instances sharing the storage within
+// a process. Instances in separate processes need a storage backend that
+// serializes updates across processes.
+func (fs *FilesystemStorage) AcquireLease(ctx context.Context, id, owner string, ttl time.Duration) (int64, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	l := d.Leases[id]
+	if l.Owner != owner && now.Before(l.Expiry) {
+		return 0, storage.ErrLeaseHeld
+	}
+	if d.Leases == nil {
+		d.Leases = make(map[string]storage.Lease)
+	}
+	l = storage.Lease{ID: id, Owner: owner, Token: l.Token + 1, Expiry: now.Add(ttl)}
+	d.Leases[id] = l
+
+	return l.Token, fs.save(d)
+}
+
+func (fs *FilesystemStorage) ReleaseLease(ctx context.Context, id, owner string, token int64) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	l, ok := d.Leases[id]
+	if !ok || l.Owner != owner || l.Token != token {
+		return nil
+	}
+	// The token is kept so that later leases get higher tokens.
+	l.Owner = ""
+	l.Expiry = time.Time{}
+	d.Leases[id] = l
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) AddTargetCredit(ctx context.Context, c storage.TargetCredit) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+	addCredit(d, c)
+	return fs.save(d)
+}
+
+func addCredit(d *data, c storage.TargetCredit) {
+	d.Credits = append(d.Credits, c)
+
+	if d.Balances == nil {
+		d.Balances = make(map[string]storage.TargetBalance)
+	}
+	b := d.Balances[c.Target]
+	b.Target = c.Target
+	b.Count++
+	b.Gross += c.Amount
+	b.Commission += c.Commission
+	d.Balances[c.Target] = b
+
+	if d.Daily == nil {
+		rollUp(d)
+	} else {
+		addDaily(d, c)
+	}
+}
+
+func dailyKey(day time.Time, target string) string {
+	return day.Format("2006-01-02") + " " + target
+}
+
+func addDaily(d *data, c storage.TargetCredit) {
+	day := storage.Day(c.Time)
+	k := dailyKey(day, c.Target)
+	t := d.Daily[k]
+	t.Day = day
+	t.Target = c.Target
+	t.Count++
+	t.Gross += c.Amount
+	t.Commission += c.Commission
+	d.Daily[k] = t
+}
+
+// rollUp computes the daily totals of state stored before they were
+// maintained.
+func rollUp(d *data) {
+	d.Daily = make(map[string]storage.DailyTotal)
+	for _, c := range d.Credits {
+		addDaily(d, c)
+	}
+}
+
+func (fs *FilesystemStorage) ListDailyTotals(ctx context.Context, target string, from, to time.Time) ([]storage.DailyTotal, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+	if d.Daily == nil {
+		rollUp(d)
+	}
+
+	var sl []storage.DailyTotal
+	for _, t := range d.Daily {
+		if target != "" && t.Target != target {
+			continue
+		}
+		if (!from.IsZero() && t.Day.Before(from)) || (!to.IsZero() && !t.Day.Before(to)) {
+			continue
+		}
+		sl = append(sl, t)
+	}
+	sort.Slice(sl, func(i, j int) bool {
+		if !sl[i].Day.Equal(sl[j].Day) {
+			return sl[i].Day.Before(sl[j].Day)
+		}
+		return sl[i].Target < sl[j].Target
+	})
+	return sl, nil
+}
+
+func (fs *FilesystemStorage) ListTargetCredits(ctx context.Context, from, to time.Time) ([]storage.TargetCredit, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var sl []storage.TargetCredit
+	for _, c := range d.Credits {
+		if (!from.IsZero() && c.Time.Before(from)) || (!to.IsZero() && !c.Time.Before(to)) {
+			continue
+		}
+		sl = append(sl, c)
+	}
+	sort.SliceStable(sl, func(i, j int) bool {
+		return sl[i].Time.Before(sl[j].Time)
+	})
+	return sl, nil
+}
+
+func (fs *FilesystemStorage) ListTargetBalances(ctx context.Context) ([]storage.TargetBalance, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var sl []storage.TargetBalance
+	for _, b := range d.Balances {
+		sl = append(sl, b)
+	}
+	sort.Slice(sl, func(i, j int) bool {
+		return sl[i].Target < sl[j].Target
+	})
+	return sl, nil
+}
+
+func (fs *FilesystemStorage) AddInvoice(ctx context.Context, inv storage.Invoice) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	if d.Invoices == nil {
+		d.Invoices = make(map[string]storage.Invoice)
+	}
+	if _, ok := d.Invoices[inv.ID]; ok {
+		return errors.New("invoice already exists")
+	}
+	d.Invoices[inv.ID] = inv
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) GetInvoice(ctx context.Context, id string) (*storage.Invoice, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	inv, ok := d.Invoices[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &inv, nil
+}
+
+// Make sure FilesystemStorage implements Storage.
+var _ storage.Storage = &FilesystemStorage{}
+
+func (fs *FilesystemStorage) AddAPIKey(ctx context.Context, k storage.APIKey) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	if d.APIKeys == nil {
+		d.APIKeys = make(map[string]storage.APIKey)
+	}
+	if _, ok := d.APIKeys[k.ID]; ok {
+		return errors.New("api key already exists")
+	}
+	d.APIKeys[k.ID] = k
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) GetAPIKey(ctx context.Context, id string) (*storage.APIKey, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	k, ok := d.APIKeys[id]
+	if !ok {
+		return nil, nil
+	}
+	return &k, nil
+}
+
+func (fs *FilesystemStorage) DeleteAPIKey(ctx context.Context, id string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	delete(d.APIKeys, id)
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) PutAccessEntry(ctx context.Context, e storage.AccessEntry) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	for i, cur := range d.Access {
+		if cur.List == e.List && cur.Kind == e.Kind && cur.Value == e.Value {
+			d.Access[i] = e
+			return fs.save(d)
+		}
+	}
+	d.Access = append(d.Access, e)
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) DeleteAccessEntry(ctx context.Context, list storage.AccessList, kind storage.AccessKind, value string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	for i, cur := range d.Access {
+		if cur.List == list && cur.Kind == kind && cur.Value == value {
+			d.Access = append(d.Access[:i], d.Access[i+1:]...)
+			return fs.save(d)
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (fs *FilesystemStorage) ListAccessEntries(ctx context.Context) ([]storage.AccessEntry, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return d.Access, nil
+}
+
+func (fs *FilesystemStorage) GetAllowlistOnly(ctx context.Context) (bool, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return false, err
+	}
+
+	return d.AllowlistOnly, nil
+}
+
+func (fs *FilesystemStorage) SetAllowlistOnly(ctx context.Context, on bool) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	d.AllowlistOnly = on
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) AddScreeningRecord(ctx context.Context, rec storage.ScreeningRecord) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return err
+	}
+
+	d.Screening = append(d.Screening, rec)
+
+	return fs.save(d)
+}
+
+func (fs *FilesystemStorage) ListScreeningRecords(ctx context.Context) ([]storage.ScreeningRecord, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	d, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return d.Screening, nil
+}
@@ -0,0 +1,17 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrDuplicateKey = errors.New("key generation already exists")
+
+// KeyGeneration is an extended key the receiver has derived channel keys
+// from. Rotating the key adds a generation that new channels use, while
+// channels of earlier generations keep using theirs.
+type KeyGeneration struct {
+	Generation int
+	XPub       string
+	Created    time.Time
+}
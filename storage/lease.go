@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLeaseHeld is returned by AcquireLease if another instance holds an
+// unexpired lease.
+var ErrLeaseHeld = errors.New("lease held by another instance")
+
+// ErrFenced is returned by updates made under a lease that has since been
+// granted to another instance.
+var ErrFenced = errors.New("lease superseded")
+
+// Lease grants an instance exclusive use of a channel, or of another ID,
+// until Expiry. Token increases with every lease granted on the ID.
+type Lease struct {
+	ID     string
+	Owner  string
+	Token  int64
+	Expiry time.Time
+}
+
+type fenceKey struct{}
+
+// WithFence returns a context whose updates of the channel are rejected
+// with ErrFenced if a lease with a higher token than token has been granted
+// on it, i.e. if the caller's lease has expired and been taken over.
+func WithFence(ctx context.Context, id string, token int64) context.Context {
+	fences := make(map[string]int64)
+	if prev, ok := ctx.Value(fenceKey{}).(map[string]int64); ok {
+		for k, v := range prev {
+			fences[k] = v
+		}
+	}
+	fences[id] = token
+	return context.WithValue(ctx, fenceKey{}, fences)
+}
+
+// FenceToken returns the fencing token of the channel set with WithFence,
+// if any.
+func FenceToken(ctx context.Context, id string) (int64, bool) {
+	fences, _ := ctx.Value(fenceKey{}).(map[string]int64)
+	token, ok := fences[id]
+	return token, ok
+}
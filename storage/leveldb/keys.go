@@ -0,0 +1,78 @@
+package leveldb
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Key prefixes. Keys made of a string followed by more parts separate them
+// with a zero byte, which doesn't occur in IDs, and encode numbers as 8 byte
+// big endian so that keys sort by them.
+var (
+	prefixChannel     = []byte("c/")  // c/<id>: storage.Record
+	prefixPayment     = []byte("p/")  // p/<id>\0<index>: time + payment
+	prefixRevocation  = []byte("r/")  // r/<id>\0<index>: secret
+	prefixSent        = []byte("sp/") // sp/<id>\0<payment id>: storage.SentPayment
+	prefixKeyGen      = []byte("g/")  // g/<generation>: storage.KeyGeneration
+	prefixDestination = []byte("d/")  // d/<xpub>: next index
+	prefixSetting     = []byte("s/")  // s/<name>: int64
+	prefixPending     = []byte("pe/") // pe/<address>: storage.Pending
+	prefixAPIKey      = []byte("ak/") // ak/<id>: storage.APIKey
+	prefixCredit      = []byte("tc/") // tc/<time><seq>: storage.TargetCredit
+	prefixBalance     = []byte("tb/") // tb/<target>: storage.TargetBalance
+	prefixDaily       = []byte("dt/") // dt/<day>\0<target>: storage.DailyTotal
+	prefixInvoice     = []byte("i/")  // i/<id>: storage.Invoice
+	prefixDeadLetter  = []byte("dl/") // dl/<seq>: storage.DeadLetter
+	prefixOutbox      = []byte("o/")  // o/<id>: storage.OutboxEvent
+	prefixAccess      = []byte("a/")  // a/<seq>: storage.AccessEntry
+	prefixScreening   = []byte("sr/") // sr/<seq>: storage.ScreeningRecord
+	prefixSequence    = []byte("q/")  // q/<name>: int64
+	prefixLease       = []byte("l/")  // l/<id>: storage.Lease
+	prefixSender      = []byte("sx/") // sx/<hex sender pubkey>\0<id>: empty
+
+	keyKeyPaths = []byte("k") // keyPaths
+)
+
+// key joins a prefix and the key's parts, each either a string or a number.
+func key(prefix []byte, parts ...interface{}) []byte {
+	k := append([]byte{}, prefix...)
+	for i, p := range parts {
+		switch p := p.(type) {
+		case string:
+			k = append(k, p...)
+			if i < len(parts)-1 {
+				k = append(k, 0)
+			}
+		case int64:
+			k = binary.BigEndian.AppendUint64(k, uint64(p))
+		default:
+			panic("invalid key part")
+		}
+	}
+	return k
+}
+
+// childPrefix is the prefix of the keys under a string key part.
+func childPrefix(prefix []byte, id string) []byte {
+	return append(key(prefix, id), 0)
+}
+
+// lastIndex decodes the number that ends a key.
+func lastIndex(k []byte) int64 {
+	return int64(binary.BigEndian.Uint64(k[len(k)-8:]))
+}
+
+// nanos encodes t for keys and values, with zero for the zero time.
+func nanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromNanos(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
@@ -0,0 +1,1179 @@
+// Package leveldb implements storage.Storage on LevelDB, for receivers that
+// accept thousands of payments per second. Payments are appended under
+// sequential keys per channel, so accepting one writes two small values in a
+// single batch instead of rewriting the state.
+package leveldb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	goleveldb "github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+// stripes is the number of locks that channels are spread over.
+const stripes = 256
+
+// Storage is a storage.Storage backed by a LevelDB database, which only one
+// process may open at a time.
+//
+// Updates of a channel are serialized by a lock striped by the channel's
+// ID, so payments to different channels are stored concurrently. Writes are
+// synced to disk before they return; concurrent writes are merged into a
+// single sync.
+type Storage struct {
+	db *goleveldb.DB
+	wo *opt.WriteOptions
+
+	// mu serializes the updates of state shared between channels, such as
+	// key paths, sequences and invoices. It's locked after a channel's lock.
+	mu sync.Mutex
+
+	locks [stripes]sync.Mutex
+}
+
+// Open opens the database in the directory at path, creating it if it
+// doesn't exist.
+func Open(path string) (*Storage, error) {
+	db, err := goleveldb.OpenFile(path, &opt.Options{
+		// A larger write buffer makes for fewer, larger level 0 tables,
+		// and so less compaction of the payment log.
+		WriteBuffer:        32 * opt.MiB,
+		BlockCacheCapacity: 32 * opt.MiB,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &Storage{db: db, wo: &opt.WriteOptions{Sync: true}}
+	if err := s.indexSenders(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the database.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// lock locks the stripe of the channel or lease id.
+func (s *Storage) lock(id string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	l := &s.locks[h.Sum32()%stripes]
+	l.Lock()
+	return l.Unlock
+}
+
+// reader is implemented by *goleveldb.DB and *goleveldb.Snapshot.
+type reader interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	Has(key []byte, ro *opt.ReadOptions) (bool, error)
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
+// getJSON decodes the value of the key into v, reporting whether it exists.
+func getJSON(r reader, k []byte, v interface{}) (bool, error) {
+	buf, err := r.Get(k, nil)
+	if err == goleveldb.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(buf, v)
+}
+
+func putJSON(b *goleveldb.Batch, k []byte, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b.Put(k, buf)
+	return nil
+}
+
+// scan calls fn for the keys in the range in order. The key and value are
+// only valid until fn returns.
+func scan(r reader, rng *util.Range, fn func(k, v []byte) error) error {
+	it := r.NewIterator(rng, nil)
+	defer it.Release()
+	for it.Next() {
+		if err := fn(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// scanJSON decodes the values of the keys with the prefix in order.
+func scanJSON(r reader, prefix []byte, fn func(dec func(v interface{}) error) error) error {
+	return scan(r, util.BytesPrefix(prefix), func(k, v []byte) error {
+		return fn(func(x interface{}) error { return json.Unmarshal(v, x) })
+	})
+}
+
+// nextIndex returns the index after the last key with the prefix, or zero.
+func nextIndex(r reader, prefix []byte) (int64, error) {
+	it := r.NewIterator(util.BytesPrefix(prefix), nil)
+	defer it.Release()
+	if !it.Last() {
+		return 0, it.Error()
+	}
+	return lastIndex(it.Key()) + 1, nil
+}
+
+// sequence reserves the next value of a sequence, from one, in the batch.
+// It must be called with mu locked.
+func (s *Storage) sequence(b *goleveldb.Batch, name string) (int64, error) {
+	n, err := getInt(s.db, key(prefixSequence, name))
+	if err != nil {
+		return 0, err
+	}
+	n++
+	b.Put(key(prefixSequence, name), encodeInt(n))
+	return n, nil
+}
+
+func encodeInt(n int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(n))
+}
+
+func getInt(r reader, k []byte) (int64, error) {
+	buf, err := r.Get(k, nil)
+	if err == goleveldb.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if len(buf) != 8 {
+		return 0, errors.New("invalid integer value")
+	}
+	return int64(binary.BigEndian.Uint64(buf)), nil
+}
+
+// keyPaths is the state of ReserveKeyPath. Used is the highest key path of
+// the channels.
+type keyPaths struct {
+	Counter int
+	Reuse   int
+	Used    int
+}
+
+func (s *Storage) Get(ctx context.Context, id string) (*storage.Record, error) {
+	var rec storage.Record
+	ok, err := getJSON(s.db, key(prefixChannel, id), &rec)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &rec, nil
+}
+
+// senderKey is the key of the channel in the index by sender pubkey.
+func senderKey(senderPubKey []byte, id string) []byte {
+	return key(prefixSender, hex.EncodeToString(senderPubKey), id)
+}
+
+// indexSenders adds the channels to the index by sender pubkey, unless a
+// previous open already did. Databases created before the index existed
+// have channels that aren't in it.
+func (s *Storage) indexSenders() error {
+	done, err := getInt(s.db, key(prefixSetting, settingSenderIndex))
+	if err != nil || done == 1 {
+		return err
+	}
+	b := &goleveldb.Batch{}
+	err = scanJSON(s.db, prefixChannel, func(dec func(interface{}) error) error {
+		var rec storage.Record
+		if err := dec(&rec); err != nil {
+			return err
+		}
+		b.Put(senderKey(rec.SharedState.SenderPubKey, rec.ID), nil)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b.Put(key(prefixSetting, settingSenderIndex), encodeInt(1))
+	return s.db.Write(b, s.wo)
+}
+
+// GetBySenderPubKey reads the channels' IDs from the index by sender pubkey,
+// whose keys sort by ID.
+func (s *Storage) GetBySenderPubKey(ctx context.Context, senderPubKey []byte) ([]storage.Record, error) {
+	prefix := childPrefix(prefixSender, hex.EncodeToString(senderPubKey))
+	var ids []string
+	err := scan(s.db, util.BytesPrefix(prefix), func(k, v []byte) error {
+		ids = append(ids, string(k[len(prefix):]))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var sl []storage.Record
+	for _, id := range ids {
+		rec, err := s.Get(ctx, id)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		sl = append(sl, *rec)
+	}
+	return sl, nil
+}
+
+func (s *Storage) List(ctx context.Context) ([]storage.Record, error) {
+	var sl []storage.Record
+	err := scanJSON(s.db, prefixChannel, func(dec func(interface{}) error) error {
+		var rec storage.Record
+		if err := dec(&rec); err != nil {
+			return err
+		}
+		sl = append(sl, rec)
+		return nil
+	})
+	return sl, err
+}
+
+// ListPage seeks to the first channel after cursor, or the sender's first
+// in the index by sender pubkey if the filter has a sender, and reads
+// channels in ID order until the page is full.
+func (s *Storage) ListPage(ctx context.Context, f storage.ListFilter, cursor string, limit int) ([]storage.Record, string, error) {
+	rng := util.BytesPrefix(prefixChannel)
+	if cursor != "" {
+		// The first key after the cursor's.
+		rng.Start = append(key(prefixChannel, cursor), 0)
+	}
+	// read decodes the channel of an iterated key and value.
+	read := func(k, v []byte) (*storage.Record, error) {
+		var rec storage.Record
+		if err := json.Unmarshal(v, &rec); err != nil {
+			return nil, err
+		}
+		return &rec, nil
+	}
+	if len(f.SenderPubKey) > 0 {
+		prefix := childPrefix(prefixSender, hex.EncodeToString(f.SenderPubKey))
+		rng = util.BytesPrefix(prefix)
+		if cursor != "" {
+			rng.Start = append(senderKey(f.SenderPubKey, cursor), 0)
+		}
+		read = func(k, v []byte) (*storage.Record, error) {
+			return s.Get(ctx, string(k[len(prefix):]))
+		}
+	}
+
+	sl := []storage.Record{}
+	var next string
+	errDone := errors.New("done")
+	err := scan(s.db, rng, func(k, v []byte) error {
+		r, err := read(k, v)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		rec := *r
+		if !f.Match(rec) {
+			return nil
+		}
+		if limit > 0 && len(sl) == limit {
+			next = sl[limit-1].ID
+			return errDone
+		}
+		sl = append(sl, rec)
+		return nil
+	})
+	if err != nil && err != errDone {
+		return nil, "", err
+	}
+	return sl, next, nil
+}
+
+func (s *Storage) Create(ctx context.Context, rec storage.Record) error {
+	if rec.ID == "" {
+		return errors.New("invalid id")
+	}
+	defer s.lock(rec.ID)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exists, err := s.db.Has(key(prefixChannel, rec.ID), nil)
+	if err != nil {
+		return err
+	} else if exists {
+		return errors.New("record already exists")
+	}
+
+	var kp keyPaths
+	if _, err := getJSON(s.db, keyKeyPaths, &kp); err != nil {
+		return err
+	}
+	b := &goleveldb.Batch{}
+	if rec.KeyPath > kp.Used {
+		kp.Used = rec.KeyPath
+		if err := putJSON(b, keyKeyPaths, kp); err != nil {
+			return err
+		}
+	}
+	rec.Version = 1
+	if err := putJSON(b, key(prefixChannel, rec.ID), rec); err != nil {
+		return err
+	}
+	b.Put(senderKey(rec.SharedState.SenderPubKey, rec.ID), nil)
+	return s.db.Write(b, s.wo)
+}
+
+func (s *Storage) Update(ctx context.Context, id string, version int64, new channels.SharedState, payment []byte) error {
+	return s.Commit(ctx, storage.Tx{ChannelID: id, Version: version, State: new, Payment: payment})
+}
+
+// Commit writes a single batch. Only commits that pay an invoice or credit
+// a target are serialized with other writes; channel updates alone only
+// lock their channel.
+func (s *Storage) Commit(ctx context.Context, tx storage.Tx) error {
+	defer s.lock(tx.ChannelID)()
+	if tx.InvoiceID != "" || tx.Credit != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+
+	var inv storage.Invoice
+	if tx.InvoiceID != "" {
+		ok, err := getJSON(s.db, key(prefixInvoice, tx.InvoiceID), &inv)
+		if err != nil {
+			return err
+		} else if !ok {
+			return storage.ErrNotFound
+		}
+		if inv.PaidChannel != "" {
+			return storage.ErrInvoicePaid
+		}
+	}
+
+	b := &goleveldb.Batch{}
+	if err := s.update(ctx, b, tx.ChannelID, tx.Version, tx.State, tx.Payment); err != nil {
+		return err
+	}
+	if tx.InvoiceID != "" {
+		inv.PaidChannel = tx.ChannelID
+		inv.PaidCount = tx.State.Count
+		inv.PaidTime = time.Now()
+		if err := putJSON(b, key(prefixInvoice, tx.InvoiceID), inv); err != nil {
+			return err
+		}
+	}
+	if tx.Credit != nil {
+		if err := s.addCredit(b, *tx.Credit); err != nil {
+			return err
+		}
+	}
+	return s.db.Write(b, s.wo)
+}
+
+// update adds the channel's new state and the payment, if any, to the batch
+// if its version is still version. It must be called with the channel locked.
+func (s *Storage) update(ctx context.Context, b *goleveldb.Batch, id string, version int64, new channels.SharedState, payment []byte) error {
+	var rec storage.Record
+	ok, err := getJSON(s.db, key(prefixChannel, id), &rec)
+	if err != nil {
+		return err
+	} else if !ok {
+		return storage.ErrNotFound
+	}
+
+	if token, ok := storage.FenceToken(ctx, id); ok {
+		var l storage.Lease
+		if _, err := getJSON(s.db, key(prefixLease, id), &l); err != nil {
+			return err
+		}
+		if token < l.Token {
+			return storage.ErrFenced
+		}
+	}
+
+	if rec.Version != version {
+		return storage.ErrConflict
+	}
+	if !bytes.Equal(rec.SharedState.SenderPubKey, new.SenderPubKey) {
+		b.Delete(senderKey(rec.SharedState.SenderPubKey, id))
+		b.Put(senderKey(new.SenderPubKey, id), nil)
+	}
+	rec.SharedState = new
+	rec.Version++
+	if err := putJSON(b, key(prefixChannel, id), rec); err != nil {
+		return err
+	}
+	if payment == nil {
+		return nil
+	}
+
+	prefix := childPrefix(prefixPayment, id)
+	n, err := nextIndex(s.db, prefix)
+	if err != nil {
+		return err
+	}
+	val := encodeInt(nanos(time.Now()))
+	b.Put(key(prefix, n), append(val, payment...))
+	return nil
+}
+
+// modify replaces the channel's record with the result of fn.
+func (s *Storage) modify(id string, fn func(rec *storage.Record)) error {
+	defer s.lock(id)()
+
+	var rec storage.Record
+	ok, err := getJSON(s.db, key(prefixChannel, id), &rec)
+	if err != nil {
+		return err
+	} else if !ok {
+		return storage.ErrNotFound
+	}
+	fn(&rec)
+
+	b := &goleveldb.Batch{}
+	if err := putJSON(b, key(prefixChannel, id), rec); err != nil {
+		return err
+	}
+	return s.db.Write(b, s.wo)
+}
+
+func (s *Storage) ReserveKeyPath(ctx context.Context, gapLimit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var kp keyPaths
+	if _, err := getJSON(s.db, keyKeyPaths, &kp); err != nil {
+		return 0, err
+	}
+
+	var path int
+	if gapLimit > 0 && kp.Counter-kp.Used >= gapLimit {
+		kp.Reuse = kp.Reuse%gapLimit + 1
+		path = kp.Used + kp.Reuse
+	} else {
+		kp.Counter++
+		path = kp.Counter
+	}
+
+	b := &goleveldb.Batch{}
+	if err := putJSON(b, keyKeyPaths, kp); err != nil {
+		return 0, err
+	}
+	if err := s.db.Write(b, s.wo); err != nil {
+		return 0, err
+	}
+	return path, nil
+}
+
+func (s *Storage) GetKeyPathCounter(ctx context.Context) (int, error) {
+	var kp keyPaths
+	_, err := getJSON(s.db, keyKeyPaths, &kp)
+	return kp.Counter, err
+}
+
+func (s *Storage) ListKeyGenerations(ctx context.Context) ([]storage.KeyGeneration, error) {
+	var sl []storage.KeyGeneration
+	err := scanJSON(s.db, prefixKeyGen, func(dec func(interface{}) error) error {
+		var g storage.KeyGeneration
+		if err := dec(&g); err != nil {
+			return err
+		}
+		sl = append(sl, g)
+		return nil
+	})
+	return sl, err
+}
+
+func (s *Storage) AddKeyGeneration(ctx context.Context, xpub string) (storage.KeyGeneration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gens, err := s.ListKeyGenerations(ctx)
+	if err != nil {
+		return storage.KeyGeneration{}, err
+	}
+	for _, g := range gens {
+		if g.XPub == xpub {
+			return storage.KeyGeneration{}, storage.ErrDuplicateKey
+		}
+	}
+
+	g := storage.KeyGeneration{
+		Generation: len(gens),
+		XPub:       xpub,
+		Created:    time.Now(),
+	}
+	b := &goleveldb.Batch{}
+	if err := putJSON(b, key(prefixKeyGen, int64(g.Generation)), g); err != nil {
+		return storage.KeyGeneration{}, err
+	}
+	if err := s.db.Write(b, s.wo); err != nil {
+		return storage.KeyGeneration{}, err
+	}
+	return g, nil
+}
+
+func (s *Storage) ReserveDestinationIndex(ctx context.Context, xpub string) (uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key(prefixDestination, xpub)
+	next, err := getInt(s.db, k)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.db.Put(k, encodeInt(next+1), s.wo); err != nil {
+		return 0, err
+	}
+	return uint32(next), nil
+}
+
+func decodePayment(id string, v []byte) storage.StoredPayment {
+	return storage.StoredPayment{
+		ChannelID: id,
+		Payment:   append([]byte{}, v[8:]...),
+		Time:      fromNanos(int64(binary.BigEndian.Uint64(v))),
+	}
+}
+
+func (s *Storage) ListPayments(ctx context.Context, channelID string) ([][]byte, error) {
+	sps, err := s.ListChannelPayments(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	var sl [][]byte
+	for _, sp := range sps {
+		sl = append(sl, sp.Payment)
+	}
+	return sl, nil
+}
+
+func (s *Storage) ListChannelPayments(ctx context.Context, channelID string) ([]storage.StoredPayment, error) {
+	var sl []storage.StoredPayment
+	rng := util.BytesPrefix(childPrefix(prefixPayment, channelID))
+	err := scan(s.db, rng, func(k, v []byte) error {
+		sl = append(sl, decodePayment(channelID, v))
+		return nil
+	})
+	return sl, err
+}
+
+// ListPaymentsPage iterates over the payments from the cursor, so a page only
+// reads the payments it skips and returns.
+func (s *Storage) ListPaymentsPage(ctx context.Context, f storage.PaymentFilter, cursor string, limit int) ([]storage.StoredPayment, string, error) {
+	rng := util.BytesPrefix(prefixPayment)
+	if f.ChannelID != "" {
+		rng = util.BytesPrefix(childPrefix(prefixPayment, f.ChannelID))
+	}
+	if cursor != "" {
+		id, pos, err := storage.ParsePaymentCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		index, err := strconv.ParseInt(pos, 10, 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid payment cursor %q", cursor)
+		}
+		if start := key(prefixPayment, id, index+1); bytes.Compare(start, rng.Start) > 0 {
+			rng.Start = start
+		}
+	}
+
+	sl := []storage.StoredPayment{}
+	var next, last string
+	errDone := errors.New("done")
+	err := scan(s.db, rng, func(k, v []byte) error {
+		id := string(k[len(prefixPayment) : len(k)-9])
+		sp := decodePayment(id, v)
+		if !f.Match(sp) {
+			return nil
+		}
+		if limit > 0 && len(sl) == limit {
+			next = last
+			return errDone
+		}
+		sl = append(sl, sp)
+		last = storage.PaymentCursor(id, strconv.FormatInt(lastIndex(k), 10))
+		return nil
+	})
+	if err != nil && err != errDone {
+		return nil, "", err
+	}
+	return sl, next, nil
+}
+
+func (s *Storage) QueryPayments(ctx context.Context, from, to time.Time) ([]storage.StoredPayment, error) {
+	return queryPayments(s.db, from, to)
+}
+
+func queryPayments(r reader, from, to time.Time) ([]storage.StoredPayment, error) {
+	var sl []storage.StoredPayment
+	err := scan(r, util.BytesPrefix(prefixPayment), func(k, v []byte) error {
+		id := string(k[len(prefixPayment) : len(k)-9])
+		sp := decodePayment(id, v)
+		if (!from.IsZero() && sp.Time.Before(from)) || (!to.IsZero() && !sp.Time.Before(to)) {
+			return nil
+		}
+		sl = append(sl, sp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(sl, func(i, j int) bool {
+		return sl[i].Time.Before(sl[j].Time)
+	})
+	return sl, nil
+}
+
+func (s *Storage) Freeze(ctx context.Context, id string, reason string) error {
+	return s.modify(id, func(rec *storage.Record) {
+		rec.Frozen = true
+		rec.FrozenReason = reason
+	})
+}
+
+func (s *Storage) Suspend(ctx context.Context, id string, suspended bool, reason string) error {
+	return s.modify(id, func(rec *storage.Record) {
+		rec.Suspended = suspended
+		rec.SuspendedReason = reason
+	})
+}
+
+func (s *Storage) SetLabels(ctx context.Context, id string, labels map[string]string) error {
+	return s.modify(id, func(rec *storage.Record) {
+		rec.Labels = labels
+	})
+}
+
+func (s *Storage) SetClosure(ctx context.Context, id string, c storage.Closure) error {
+	return s.modify(id, func(rec *storage.Record) {
+		rec.Closure = c
+	})
+}
+
+// ArchiveChannel deletes the channel's logs and stores the stub in a single
+// batch.
+func (s *Storage) ArchiveChannel(ctx context.Context, id string, version int64, location string) error {
+	defer s.lock(id)()
+
+	var rec storage.Record
+	ok, err := getJSON(s.db, key(prefixChannel, id), &rec)
+	if err != nil {
+		return err
+	} else if !ok {
+		return storage.ErrNotFound
+	}
+	if rec.Version != version {
+		return storage.ErrConflict
+	}
+	rec.Archived = location
+
+	b := &goleveldb.Batch{}
+	if err := putJSON(b, key(prefixChannel, id), rec); err != nil {
+		return err
+	}
+	for _, prefix := range [][]byte{prefixPayment, prefixRevocation, prefixSent} {
+		err := scan(s.db, util.BytesPrefix(childPrefix(prefix, id)), func(k, v []byte) error {
+			b.Delete(append([]byte{}, k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return s.db.Write(b, s.wo)
+}
+
+const (
+	settingWatchHeight   = "watch_height"
+	settingAllowlistOnly = "allowlist_only"
+	settingSenderIndex   = "sender_index"
+)
+
+func (s *Storage) GetWatchHeight(ctx context.Context) (int64, error) {
+	return getInt(s.db, key(prefixSetting, settingWatchHeight))
+}
+
+func (s *Storage) SetWatchHeight(ctx context.Context, height int64) error {
+	return s.db.Put(key(prefixSetting, settingWatchHeight), encodeInt(height), s.wo)
+}
+
+func (s *Storage) GetAllowlistOnly(ctx context.Context) (bool, error) {
+	v, err := getInt(s.db, key(prefixSetting, settingAllowlistOnly))
+	return v != 0, err
+}
+
+func (s *Storage) SetAllowlistOnly(ctx context.Context, on bool) error {
+	var v int64
+	if on {
+		v = 1
+	}
+	return s.db.Put(key(prefixSetting, settingAllowlistOnly), encodeInt(v), s.wo)
+}
+
+func (s *Storage) AddRevocationSecret(ctx context.Context, channelID string, secret []byte) error {
+	defer s.lock(channelID)()
+
+	exists, err := s.db.Has(key(prefixChannel, channelID), nil)
+	if err != nil {
+		return err
+	} else if !exists {
+		return storage.ErrNotFound
+	}
+	prefix := childPrefix(prefixRevocation, channelID)
+	n, err := nextIndex(s.db, prefix)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(key(prefix, n), secret, s.wo)
+}
+
+func (s *Storage) ListRevocationSecrets(ctx context.Context, channelID string) ([][]byte, error) {
+	var sl [][]byte
+	rng := util.BytesPrefix(childPrefix(prefixRevocation, channelID))
+	err := scan(s.db, rng, func(k, v []byte) error {
+		sl = append(sl, append([]byte{}, v...))
+		return nil
+	})
+	return sl, err
+}
+
+func (s *Storage) AddSentPayment(ctx context.Context, channelID string, p storage.SentPayment) error {
+	defer s.lock(channelID)()
+
+	exists, err := s.db.Has(key(prefixChannel, channelID), nil)
+	if err != nil {
+		return err
+	} else if !exists {
+		return storage.ErrNotFound
+	}
+	// Like the filesystem storage, the first payment recorded with an ID
+	// is the one returned by GetSentPayment.
+	k := key(prefixSent, channelID, p.PaymentID)
+	if exists, err := s.db.Has(k, nil); err != nil || exists {
+		return err
+	}
+	b := &goleveldb.Batch{}
+	if err := putJSON(b, k, p); err != nil {
+		return err
+	}
+	return s.db.Write(b, s.wo)
+}
+
+func (s *Storage) GetSentPayment(ctx context.Context, channelID string, paymentID string) (*storage.SentPayment, error) {
+	var p storage.SentPayment
+	ok, err := getJSON(s.db, key(prefixSent, channelID, paymentID), &p)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *Storage) PutPending(ctx context.Context, p storage.Pending) error {
+	if p.FundingAddress == "" {
+		return errors.New("invalid funding address")
+	}
+	b := &goleveldb.Batch{}
+	if err := putJSON(b, key(prefixPending, p.FundingAddress), p); err != nil {
+		return err
+	}
+	return s.db.Write(b, s.wo)
+}
+
+func (s *Storage) ListPending(ctx context.Context) ([]storage.Pending, error) {
+	var sl []storage.Pending
+	err := scanJSON(s.db, prefixPending, func(dec func(interface{}) error) error {
+		var p storage.Pending
+		if err := dec(&p); err != nil {
+			return err
+		}
+		sl = append(sl, p)
+		return nil
+	})
+	return sl, err
+}
+
+func (s *Storage) DeletePending(ctx context.Context, fundingAddress string) error {
+	return s.db.Delete(key(prefixPending, fundingAddress), s.wo)
+}
+
+func (s *Storage) AddAPIKey(ctx context.Context, k storage.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exists, err := s.db.Has(key(prefixAPIKey, k.ID), nil)
+	if err != nil {
+		return err
+	} else if exists {
+		return errors.New("api key already exists")
+	}
+	b := &goleveldb.Batch{}
+	if err := putJSON(b, key(prefixAPIKey, k.ID), k); err != nil {
+		return err
+	}
+	return s.db.Write(b, s.wo)
+}
+
+func (s *Storage) GetAPIKey(ctx context.Context, id string) (*storage.APIKey, error) {
+	var k storage.APIKey
+	ok, err := getJSON(s.db, key(prefixAPIKey, id), &k)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *Storage) DeleteAPIKey(ctx context.Context, id string) error {
+	return s.db.Delete(key(prefixAPIKey, id), s.wo)
+}
+
+func (s *Storage) AddTargetCredit(ctx context.Context, c storage.TargetCredit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := &goleveldb.Batch{}
+	if err := s.addCredit(b, c); err != nil {
+		return err
+	}
+	return s.db.Write(b, s.wo)
+}
+
+// addCredit adds the credit and its totals to the batch. It must be called
+// with s.mu locked.
+func (s *Storage) addCredit(b *goleveldb.Batch, c storage.TargetCredit) error {
+	seq, err := s.sequence(b, "credits")
+	if err != nil {
+		return err
+	}
+	if err := putJSON(b, key(prefixCredit, nanos(c.Time), seq), c); err != nil {
+		return err
+	}
+
+	bal := storage.TargetBalance{Target: c.Target}
+	if _, err := getJSON(s.db, key(prefixBalance, c.Target), &bal); err != nil {
+		return err
+	}
+	bal.Count++
+	bal.Gross += c.Amount
+	bal.Commission += c.Commission
+	if err := putJSON(b, key(prefixBalance, c.Target), bal); err != nil {
+		return err
+	}
+
+	day := storage.Day(c.Time)
+	k := key(prefixDaily, nanos(day), c.Target)
+	t := storage.DailyTotal{Day: day, Target: c.Target}
+	if _, err := getJSON(s.db, k, &t); err != nil {
+		return err
+	}
+	t.Count++
+	t.Gross += c.Amount
+	t.Commission += c.Commission
+	return putJSON(b, k, t)
+}
+
+// timeRange is the range of keys with the prefix followed by a time from
+// from, inclusive, to to, exclusive. Zero times don't bound the range.
+func timeRange(prefix []byte, from, to time.Time) *util.Range {
+	rng := util.BytesPrefix(prefix)
+	if !from.IsZero() {
+		rng.Start = key(prefix, nanos(from))
+	}
+	if !to.IsZero() {
+		rng.Limit = key(prefix, nanos(to))
+	}
+	return rng
+}
+
+func (s *Storage) ListTargetCredits(ctx context.Context, from, to time.Time) ([]storage.TargetCredit, error) {
+	var sl []storage.TargetCredit
+	err := scan(s.db, timeRange(prefixCredit, from, to), func(k, v []byte) error {
+		var c storage.TargetCredit
+		if err := json.Unmarshal(v, &c); err != nil {
+			return err
+		}
+		sl = append(sl, c)
+		return nil
+	})
+	return sl, err
+}
+
+func (s *Storage) ListTargetBalances(ctx context.Context) ([]storage.TargetBalance, error) {
+	var sl []storage.TargetBalance
+	err := scanJSON(s.db, prefixBalance, func(dec func(interface{}) error) error {
+		var b storage.TargetBalance
+		if err := dec(&b); err != nil {
+			return err
+		}
+		sl = append(sl, b)
+		return nil
+	})
+	return sl, err
+}
+
+func (s *Storage) ListDailyTotals(ctx context.Context, target string, from, to time.Time) ([]storage.DailyTotal, error) {
+	var sl []storage.DailyTotal
+	err := scan(s.db, timeRange(prefixDaily, from, to), func(k, v []byte) error {
+		var t storage.DailyTotal
+		if err := json.Unmarshal(v, &t); err != nil {
+			return err
+		}
+		if target == "" || t.Target == target {
+			sl = append(sl, t)
+		}
+		return nil
+	})
+	return sl, err
+}
+
+func (s *Storage) AddInvoice(ctx context.Context, inv storage.Invoice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exists, err := s.db.Has(key(prefixInvoice, inv.ID), nil)
+	if err != nil {
+		return err
+	} else if exists {
+		return errors.New("invoice already exists")
+	}
+	b := &goleveldb.Batch{}
+	if err := putJSON(b, key(prefixInvoice, inv.ID), inv); err != nil {
+		return err
+	}
+	return s.db.Write(b, s.wo)
+}
+
+func (s *Storage) GetInvoice(ctx context.Context, id string) (*storage.Invoice, error) {
+	var inv storage.Invoice
+	ok, err := getJSON(s.db, key(prefixInvoice, id), &inv)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &inv, nil
+}
+
+// appendSeq adds v under the prefix with the next value of a sequence.
+func (s *Storage) appendSeq(prefix []byte, seqName string, v interface{}) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := &goleveldb.Batch{}
+	seq, err := s.sequence(b, seqName)
+	if err != nil {
+		return 0, err
+	}
+	if err := putJSON(b, key(prefix, seq), v); err != nil {
+		return 0, err
+	}
+	return seq, s.db.Write(b, s.wo)
+}
+
+func (s *Storage) AddDeadLetter(ctx context.Context, dl storage.DeadLetter) error {
+	_, err := s.appendSeq(prefixDeadLetter, "dead_letters", dl)
+	return err
+}
+
+func (s *Storage) ListDeadLetters(ctx context.Context) ([]storage.DeadLetter, error) {
+	var sl []storage.DeadLetter
+	err := scanJSON(s.db, prefixDeadLetter, func(dec func(interface{}) error) error {
+		var dl storage.DeadLetter
+		if err := dec(&dl); err != nil {
+			return err
+		}
+		sl = append(sl, dl)
+		return nil
+	})
+	return sl, err
+}
+
+func (s *Storage) AddOutbox(ctx context.Context, e storage.OutboxEvent) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := &goleveldb.Batch{}
+	id, err := s.sequence(b, "outbox")
+	if err != nil {
+		return 0, err
+	}
+	e.ID = id
+	if err := putJSON(b, key(prefixOutbox, id), e); err != nil {
+		return 0, err
+	}
+	return id, s.db.Write(b, s.wo)
+}
+
+func (s *Storage) ListOutbox(ctx context.Context, limit int) ([]storage.OutboxEvent, error) {
+	var sl []storage.OutboxEvent
+	errDone := errors.New("done")
+	err := scanJSON(s.db, prefixOutbox, func(dec func(interface{}) error) error {
+		if limit > 0 && len(sl) == limit {
+			return errDone
+		}
+		var e storage.OutboxEvent
+		if err := dec(&e); err != nil {
+			return err
+		}
+		sl = append(sl, e)
+		return nil
+	})
+	if err != nil && err != errDone {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) DeleteOutbox(ctx context.Context, id int64) error {
+	return s.db.Delete(key(prefixOutbox, id), s.wo)
+}
+
+func (s *Storage) AcquireLease(ctx context.Context, id, owner string, ttl time.Duration) (int64, error) {
+	defer s.lock(id)()
+
+	l := storage.Lease{ID: id}
+	if _, err := getJSON(s.db, key(prefixLease, id), &l); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	if l.Owner != owner && now.Before(l.Expiry) {
+		return 0, storage.ErrLeaseHeld
+	}
+	l.Owner = owner
+	l.Token++
+	l.Expiry = now.Add(ttl)
+
+	b := &goleveldb.Batch{}
+	if err := putJSON(b, key(prefixLease, id), l); err != nil {
+		return 0, err
+	}
+	if err := s.db.Write(b, s.wo); err != nil {
+		return 0, err
+	}
+	return l.Token, nil
+}
+
+func (s *Storage) ReleaseLease(ctx context.Context, id, owner string, token int64) error {
+	defer s.lock(id)()
+
+	var l storage.Lease
+	ok, err := getJSON(s.db, key(prefixLease, id), &l)
+	if err != nil || !ok || l.Owner != owner || l.Token != token {
+		return err
+	}
+	// The token is kept so that later leases get higher tokens.
+	l.Owner = ""
+	l.Expiry = time.Time{}
+	b := &goleveldb.Batch{}
+	if err := putJSON(b, key(prefixLease, id), l); err != nil {
+		return err
+	}
+	return s.db.Write(b, s.wo)
+}
+
+// findAccessEntry returns the key of the entry, or nil if there is none.
+func (s *Storage) findAccessEntry(list storage.AccessList, kind storage.AccessKind, value string) ([]byte, error) {
+	var found []byte
+	err := scan(s.db, util.BytesPrefix(prefixAccess), func(k, v []byte) error {
+		var e storage.AccessEntry
+		if err := json.Unmarshal(v, &e); err != nil {
+			return err
+		}
+		if found == nil && e.List == list && e.Kind == kind && e.Value == value {
+			found = append([]byte{}, k...)
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (s *Storage) PutAccessEntry(ctx context.Context, e storage.AccessEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, err := s.findAccessEntry(e.List, e.Kind, e.Value)
+	if err != nil {
+		return err
+	}
+	b := &goleveldb.Batch{}
+	if k == nil {
+		seq, err := s.sequence(b, "access")
+		if err != nil {
+			return err
+		}
+		k = key(prefixAccess, seq)
+	}
+	if err := putJSON(b, k, e); err != nil {
+		return err
+	}
+	return s.db.Write(b, s.wo)
+}
+
+func (s *Storage) DeleteAccessEntry(ctx context.Context, list storage.AccessList, kind storage.AccessKind, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, err := s.findAccessEntry(list, kind, value)
+	if err != nil {
+		return err
+	} else if k == nil {
+		return storage.ErrNotFound
+	}
+	return s.db.Delete(k, s.wo)
+}
+
+func (s *Storage) ListAccessEntries(ctx context.Context) ([]storage.AccessEntry, error) {
+	var sl []storage.AccessEntry
+	err := scanJSON(s.db, prefixAccess, func(dec func(interface{}) error) error {
+		var e storage.AccessEntry
+		if err := dec(&e); err != nil {
+			return err
+		}
+		sl = append(sl, e)
+		return nil
+	})
+	return sl, err
+}
+
+func (s *Storage) AddScreeningRecord(ctx context.Context, rec storage.ScreeningRecord) error {
+	_, err := s.appendSeq(prefixScreening, "screening", rec)
+	return err
+}
+
+func (s *Storage) ListScreeningRecords(ctx context.Context) ([]storage.ScreeningRecord, error) {
+	var sl []storage.ScreeningRecord
+	err := scanJSON(s.db, prefixScreening, func(dec func(interface{}) error) error {
+		var rec storage.ScreeningRecord
+		if err := dec(&rec); err != nil {
+			return err
+		}
+		sl = append(sl, rec)
+		return nil
+	})
+	return sl, err
+}
+
+// Make sure Storage implements storage.Storage.
+var _ storage.Storage = &Storage{}
@@ -0,0 +1,113 @@
+package leveldb
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	goleveldb "github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/storagetest"
+)
+
+func open(t *testing.T, path string) *Storage {
+	t.Helper()
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return open(t, filepath.Join(t.TempDir(), "state"))
+	})
+}
+
+func TestReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state")
+	s := open(t, path)
+	storagetest.Create(t, s, "a")
+	if err := s.SetWatchHeight(ctx, 42); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = open(t, path)
+	h, err := s.GetWatchHeight(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != 42 {
+		t.Errorf("Expected watch height 42, got %d", h)
+	}
+	if _, err := s.Get(ctx, "a"); err != nil {
+		t.Error(err)
+	}
+}
+
+func BenchmarkUpdate(b *testing.B) {
+	ctx := context.Background()
+	s, err := Open(filepath.Join(b.TempDir(), "state"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+
+	// Each goroutine pays into its own channel, as many senders would.
+	var n int64
+	var mu sync.Mutex
+	payment := make([]byte, 200)
+	b.RunParallel(func(pb *testing.PB) {
+		mu.Lock()
+		n++
+		id := fmt.Sprintf("ch%d", n)
+		mu.Unlock()
+
+		state := channels.SharedState{Status: channels.StatusOpen}
+		err := s.Create(ctx, storage.Record{ID: id, KeyPath: 1, SharedState: state})
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		for version := int64(1); pb.Next(); version++ {
+			next := state
+			next.Count++
+			if err := s.Update(ctx, id, version, next, payment); err != nil {
+				b.Error(err)
+				return
+			}
+			state = next
+		}
+	})
+}
+
+func TestIndexSenders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	s := open(t, path)
+	storagetest.CreateSenders(t, s)
+
+	// Databases from before the index are indexed when they're opened.
+	b := &goleveldb.Batch{}
+	err := scan(s.db, util.BytesPrefix(prefixSender), func(k, v []byte) error {
+		b.Delete(append([]byte{}, k...))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Delete(key(prefixSetting, settingSenderIndex))
+	if err := s.db.Write(b, nil); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	storagetest.CheckSenders(t, open(t, path))
+}
@@ -0,0 +1,100 @@
+package leveldb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	goleveldb "github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+// snapshotData is the encoding of a snapshot: every key and value except
+// the leases' and the index's, in order.
+type snapshotData struct {
+	Keys   [][]byte
+	Values [][]byte
+}
+
+// Snapshot copies the state from a LevelDB snapshot, which doesn't block
+// updates.
+func (s *Storage) Snapshot(ctx context.Context) (*storage.Snapshot, error) {
+	snap, err := s.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	var d snapshotData
+	var res storage.Snapshot
+	err = scan(snap, nil, func(k, v []byte) error {
+		switch {
+		case bytes.HasPrefix(k, prefixLease), bytes.HasPrefix(k, prefixSender):
+			return nil
+		case bytes.HasPrefix(k, prefixChannel):
+			res.Channels++
+		case bytes.HasPrefix(k, prefixPayment):
+			res.Payments++
+		}
+		d.Keys = append(d.Keys, append([]byte{}, k...))
+		d.Values = append(d.Values, append([]byte{}, v...))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var kp keyPaths
+	if _, err := getJSON(snap, keyKeyPaths, &kp); err != nil {
+		return nil, err
+	}
+	res.KeyPathCounter = kp.Counter
+
+	if res.Data, err = json.Marshal(d); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Restore replaces the state in a single batch, with every channel locked,
+// and indexes the restored channels by sender pubkey.
+func (s *Storage) Restore(ctx context.Context, snap storage.Snapshot) error {
+	var d snapshotData
+	if err := json.Unmarshal(snap.Data, &d); err != nil {
+		return err
+	}
+
+	for i := range s.locks {
+		s.locks[i].Lock()
+		defer s.locks[i].Unlock()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := &goleveldb.Batch{}
+	err := scan(s.db, nil, func(k, v []byte) error {
+		if !bytes.HasPrefix(k, prefixLease) {
+			b.Delete(append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, k := range d.Keys {
+		switch {
+		case bytes.HasPrefix(k, prefixLease), bytes.HasPrefix(k, prefixSender):
+			continue
+		case bytes.HasPrefix(k, prefixChannel):
+			var rec storage.Record
+			if err := json.Unmarshal(d.Values[i], &rec); err != nil {
+				return err
+			}
+			b.Put(senderKey(rec.SharedState.SenderPubKey, rec.ID), nil)
+		}
+		b.Put(k, d.Values[i])
+	}
+	b.Put(key(prefixSetting, settingSenderIndex), encodeInt(1))
+	return s.db.Write(b, s.wo)
+}
@@ -0,0 +1,62 @@
+// Package memory provides an in-memory storage.Storage for tests, and
+// helpers to snapshot and restore a storage's state within a test.
+//
+// The storage is the filesystem storage with its state kept in memory, so
+// it enforces the same contract as the default backend: Update only stores
+// a state made from the current one, key paths are reserved within the gap
+// limit, and snapshots keep leases. Tests of channels and receivers can use
+// it without a temporary directory or a database.
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/filesystem"
+)
+
+// New returns an empty in-memory storage.
+func New() storage.Storage {
+	return filesystem.NewMemoryStorage()
+}
+
+// Snapshot returns a snapshot of the storage, failing the test if it can't
+// be taken.
+func Snapshot(t testing.TB, s storage.Storage) storage.Snapshot {
+	t.Helper()
+	snap, err := s.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *snap
+}
+
+// Restore replaces the storage's state with the snapshot's, failing the
+// test if it can't be restored.
+func Restore(t testing.TB, s storage.Storage, snap storage.Snapshot) {
+	t.Helper()
+	if err := s.Restore(context.Background(), snap); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Checkpoint snapshots the storage and returns a function that restores
+// it, for tests that try several things from the same state.
+func Checkpoint(t testing.TB, s storage.Storage) func() {
+	t.Helper()
+	snap := Snapshot(t, s)
+	return func() {
+		t.Helper()
+		Restore(t, s, snap)
+	}
+}
+
+// Clone returns a new in-memory storage with a copy of the state of s,
+// which must be an in-memory or filesystem storage.
+func Clone(t testing.TB, s storage.Storage) storage.Storage {
+	t.Helper()
+	c := New()
+	Restore(t, c, Snapshot(t, s))
+	return c
+}
@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage { return New() })
+}
+
+func TestGetCopies(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	storagetest.Create(t, s, "a")
+
+	rec, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.SharedState.Balance = 0
+
+	rec, err = s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.SharedState.Balance != 100 {
+		t.Errorf("Expected the stored record to be unchanged, got balance %d", rec.SharedState.Balance)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	prev := storagetest.Create(t, s, "a")
+	restore := Checkpoint(t, s)
+
+	next := prev
+	next.Count++
+	if err := s.Update(ctx, "a", 1, next, []byte("p")); err != nil {
+		t.Fatal(err)
+	}
+	c := Clone(t, s)
+
+	restore()
+	rec, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.SharedState.Count != 0 {
+		t.Errorf("Expected the checkpoint's state, got count %d", rec.SharedState.Count)
+	}
+	// The restored state accepts the update again.
+	if err := s.Update(ctx, "a", 1, next, []byte("p")); err != nil {
+		t.Error(err)
+	}
+
+	payments, err := c.ListPayments(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 {
+		t.Errorf("Expected the clone to keep its payment, got %d", len(payments))
+	}
+}
@@ -0,0 +1,124 @@
+// Package migrate applies versioned schema migrations to the SQL storage
+// backends. The versions applied to a database are recorded in its
+// schema_version table, so a backend can add columns or tables in a new
+// migration and have every database upgraded on startup, or with
+// "mbserver migrate".
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Migration changes a schema from the previous version to Version.
+// Released migrations must never change; add a new one instead.
+type Migration struct {
+	// Version is the schema version after the migration, from one.
+	Version int
+
+	// Up upgrades the schema, and Down reverts the upgrade. Either may
+	// hold several statements. A migration without Down can't be
+	// reverted.
+	Up   string
+	Down string
+}
+
+// Validate checks that the migrations' versions go from one up by one.
+func Validate(ms []Migration) error {
+	for i, m := range ms {
+		if m.Version != i+1 {
+			return fmt.Errorf("migration %d has version %d", i+1, m.Version)
+		}
+		if m.Up == "" {
+			return fmt.Errorf("migration %d has no up statements", m.Version)
+		}
+	}
+	return nil
+}
+
+// Latest returns the version of the schema after all the migrations.
+func Latest(ms []Migration) int {
+	return len(ms)
+}
+
+// Init creates the schema_version table if it doesn't exist.
+func Init(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY
+	)`)
+	return err
+}
+
+// Version returns the schema version of the database, which is zero if no
+// migrations have been applied. The schema_version table must exist.
+func Version(ctx context.Context, tx *sql.Tx) (int, error) {
+	var version int
+	err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// To migrates the database up or down to the target version, recording
+// each version applied or reverted. The transaction must be committed for
+// the changes to take effect.
+func To(ctx context.Context, tx *sql.Tx, ms []Migration, target int) error {
+	if err := Validate(ms); err != nil {
+		return err
+	}
+	if target < 0 || target > Latest(ms) {
+		return fmt.Errorf("unknown schema version %d, expected 0 to %d",
+			target, Latest(ms))
+	}
+	if err := Init(ctx, tx); err != nil {
+		return err
+	}
+	version, err := Version(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if version > Latest(ms) {
+		return fmt.Errorf("database schema version %d is newer than %d",
+			version, Latest(ms))
+	}
+
+	for v := version + 1; v <= target; v++ {
+		if _, err := tx.ExecContext(ctx, ms[v-1].Up); err != nil {
+			return fmt.Errorf("migration %d: %v", v, err)
+		}
+		_, err := tx.ExecContext(ctx,
+			fmt.Sprintf(`INSERT INTO schema_version (version) VALUES (%d)`, v))
+		if err != nil {
+			return err
+		}
+	}
+	for v := version; v > target; v-- {
+		if ms[v-1].Down == "" {
+			return fmt.Errorf("migration %d can't be reverted", v)
+		}
+		if _, err := tx.ExecContext(ctx, ms[v-1].Down); err != nil {
+			return fmt.Errorf("reverting migration %d: %v", v, err)
+		}
+		_, err := tx.ExecContext(ctx,
+			fmt.Sprintf(`DELETE FROM schema_version WHERE version = %d`, v))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check returns an error if the database's schema isn't at the latest
+// version, for backends opened without migrating. It doesn't change the
+// database, so it works with a user that can't.
+func Check(ctx context.Context, tx *sql.Tx, ms []Migration) error {
+	version, err := Version(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("database schema version: %v, run mbserver migrate", err)
+	}
+	if version != Latest(ms) {
+		return fmt.Errorf("database schema version %d isn't %d, run mbserver migrate",
+			version, Latest(ms))
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var testMigrations = []Migration{
+	{Version: 1, Up: `CREATE TABLE a (x INTEGER);`, Down: `DROP TABLE a;`},
+	{Version: 2, Up: `CREATE TABLE b (z INTEGER); CREATE INDEX b_z ON b (z);`,
+		Down: `DROP INDEX b_z; DROP TABLE b;`},
+}
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// migrateTo migrates db to the target in a transaction and returns the
+// resulting version.
+func migrateTo(t *testing.T, db *sql.DB, ms []Migration, target int) (int, error) {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := To(ctx, tx, ms, target); err != nil {
+		return 0, err
+	}
+	version, err := Version(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version, tx.Commit()
+}
+
+func TestUpDown(t *testing.T) {
+	db := openDB(t)
+
+	version, err := migrateTo(t, db, testMigrations, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Errorf("Expected version 2, got %d", version)
+	}
+	if _, err := db.Exec(`INSERT INTO a (x) VALUES (1)`); err != nil {
+		t.Error(err)
+	}
+	if _, err := db.Exec(`INSERT INTO b (z) VALUES (1)`); err != nil {
+		t.Error(err)
+	}
+
+	// Migrating again is a no-op.
+	if _, err := migrateTo(t, db, testMigrations, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err = migrateTo(t, db, testMigrations, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("Expected version 1, got %d", version)
+	}
+	if _, err := db.Exec(`INSERT INTO b (z) VALUES (1)`); err == nil {
+		t.Errorf("Expected table b to be dropped")
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM a`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("Expected the row in a to be kept, got %d, %v", n, err)
+	}
+
+	version, err = migrateTo(t, db, testMigrations, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Errorf("Expected version 0, got %d", version)
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	db := openDB(t)
+	if _, err := migrateTo(t, db, testMigrations, 2); err != nil {
+		t.Fatal(err)
+	}
+	_, err := migrateTo(t, db, testMigrations[:1], 1)
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Expected newer version error, got %v", err)
+	}
+}
+
+func TestIrreversible(t *testing.T) {
+	db := openDB(t)
+	ms := []Migration{testMigrations[0], {Version: 2, Up: `CREATE TABLE b (z INTEGER);`}}
+	if _, err := migrateTo(t, db, ms, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrateTo(t, db, ms, 0); err == nil {
+		t.Errorf("Expected error reverting migration without down")
+	}
+	// The failed migration was rolled back.
+	if _, err := db.Exec(`INSERT INTO a (x) VALUES (1)`); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(testMigrations); err != nil {
+		t.Error(err)
+	}
+	if err := Validate(testMigrations[1:]); err == nil {
+		t.Errorf("Expected error for migrations not starting at one")
+	}
+	if err := Validate([]Migration{{Version: 1}}); err == nil {
+		t.Errorf("Expected error for migration without up")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	db := openDB(t)
+	ctx := context.Background()
+	check := func() error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		return Check(ctx, tx, testMigrations)
+	}
+
+	if err := check(); err == nil {
+		t.Errorf("Expected error checking unmigrated database")
+	}
+	if _, err := migrateTo(t, db, testMigrations, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(); err == nil {
+		t.Errorf("Expected error checking outdated database")
+	}
+	if _, err := migrateTo(t, db, testMigrations, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(); err != nil {
+		t.Error(err)
+	}
+}
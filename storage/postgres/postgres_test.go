@@ -0,0 +1,316 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/storagetest"
+)
+
+// testDSN returns the URL of the database named by MOONBEAM_POSTGRES_DSN,
+// e.g. postgres://postgres@localhost/moonbeam_test?sslmode=disable, with
+// its search path set to a new schema that is dropped when the test ends.
+// The test is skipped if it isn't set.
+func testDSN(t *testing.T) string {
+	t.Helper()
+	dsn := os.Getenv("MOONBEAM_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("MOONBEAM_POSTGRES_DSN not set")
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	schema := pq.QuoteIdentifier("moonbeam_test_" + hex.EncodeToString(b))
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Error(err)
+		}
+	})
+
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func open(t *testing.T, dsn string) *Storage {
+	t.Helper()
+	s, err := Open(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return open(t, testDSN(t))
+	})
+}
+
+func TestListPaymentsPageWithoutTime(t *testing.T) {
+	ctx := context.Background()
+	s := open(t, testDSN(t))
+	state := storagetest.Create(t, s, "a")
+	state.Count++
+	if err := s.Update(ctx, "a", 1, state, []byte("a0")); err != nil {
+		t.Fatal(err)
+	}
+	// Payments stored before times were recorded have none.
+	if _, err := s.db.ExecContext(ctx, `UPDATE payments SET time = NULL`); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, f := range []storage.PaymentFilter{
+		{From: now.Add(-time.Hour)},
+		{To: now.Add(time.Hour)},
+	} {
+		sl, _, err := s.ListPaymentsPage(ctx, f, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sl) != 0 {
+			t.Errorf("Expected no payments for %+v, got %v", f, sl)
+		}
+	}
+	sl, _, err := s.ListPaymentsPage(ctx, storage.PaymentFilter{}, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sl) != 1 || !sl[0].Time.IsZero() {
+		t.Errorf("Expected one payment without a time, got %v", sl)
+	}
+}
+
+func TestCreateGet(t *testing.T) {
+	ctx := context.Background()
+	s := open(t, testDSN(t))
+	storagetest.Create(t, s, "a")
+
+	rec := storage.Record{ID: "a", KeyPath: 2}
+	if err := s.Create(ctx, rec); err == nil {
+		t.Errorf("Expected an error creating a duplicate channel")
+	}
+	if err := s.Create(ctx, storage.Record{}); err == nil {
+		t.Errorf("Expected an error creating a channel without an ID")
+	}
+	if _, err := s.Get(ctx, "b"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	got, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.KeyPath != 1 || got.Version != 1 || got.SharedState.Balance != 100 || got.Created.IsZero() {
+		t.Errorf("Unexpected record %+v", got)
+	}
+}
+
+func TestUpdateConnections(t *testing.T) {
+	ctx := context.Background()
+	dsn := testDSN(t)
+	prev := storagetest.Create(t, open(t, dsn), "a")
+
+	// Updates from separate connection pools race to replace the same
+	// state, as they would from several instances.
+	const n = 8
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		s := open(t, dsn)
+		next := prev
+		next.Count++
+		next.Balance += int64(i)
+		go func() { errs <- s.Update(ctx, "a", 1, next, []byte("p")) }()
+	}
+	var ok int
+	for i := 0; i < n; i++ {
+		err := <-errs
+		if err == nil {
+			ok++
+		} else if err != storage.ErrConflict {
+			t.Error(err)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("Expected one update to succeed, got %d", ok)
+	}
+
+	s := open(t, dsn)
+	rec, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Version != 2 {
+		t.Errorf("Expected version 2, got %d", rec.Version)
+	}
+	payments, err := s.ListPayments(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 {
+		t.Errorf("Expected one payment, got %d", len(payments))
+	}
+}
+
+func TestListPageFilters(t *testing.T) {
+	ctx := context.Background()
+	s := open(t, testDSN(t))
+	start := time.Now()
+	for i, c := range []struct {
+		id      string
+		sender  byte
+		account string
+	}{{"a", 0, ""}, {"b", 1, "acc"}, {"c", 0, ""}} {
+		rec := storage.Record{
+			ID:      c.id,
+			KeyPath: i + 1,
+			SharedState: channels.SharedState{
+				Status:       channels.StatusOpen,
+				SenderPubKey: []byte{2, c.sender},
+			},
+			Created: start.Add(time.Duration(i) * time.Hour),
+			Account: c.account,
+		}
+		if err := s.Create(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		f    storage.ListFilter
+		want string
+	}{
+		{"all", storage.ListFilter{}, "a,b,c"},
+		{"sender", storage.ListFilter{SenderPubKey: []byte{2, 0}}, "a,c"},
+		{"created after", storage.ListFilter{CreatedAfter: start}, "b,c"},
+		{"created before", storage.ListFilter{CreatedBefore: start.Add(2 * time.Hour)}, "a,b"},
+		{"account", storage.ListFilter{ByAccount: true, Account: "acc"}, "b"},
+		{"default account", storage.ListFilter{ByAccount: true}, "a,c"},
+		{"closed", storage.ListFilter{Status: channels.StatusClosed}, ""},
+	}
+	for _, test := range tests {
+		sl, next, err := s.ListPage(ctx, test.f, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, rec := range sl {
+			ids = append(ids, rec.ID)
+		}
+		if got := strings.Join(ids, ","); got != test.want || next != "" {
+			t.Errorf("%s: expected %q, got %q, %q", test.name, test.want, got, next)
+		}
+	}
+}
+
+func TestMigrateDownUp(t *testing.T) {
+	ctx := context.Background()
+	s := open(t, testDSN(t))
+	if err := s.MigrateTo(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckSchema(ctx); err == nil {
+		t.Errorf("Expected error checking unmigrated schema")
+	}
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckSchema(ctx); err != nil {
+		t.Error(err)
+	}
+	storagetest.Create(t, s, "a")
+}
+
+func TestMigrateConcurrent(t *testing.T) {
+	ctx := context.Background()
+	dsn := testDSN(t)
+
+	// Instances starting together migrate one at a time.
+	const n = 4
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		s, err := OpenUnmigrated(ctx, dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		go func() { errs <- s.Migrate(ctx) }()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	s := open(t, dsn)
+	version, latest, err := s.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latest {
+		t.Errorf("Expected version %d, got %d", latest, version)
+	}
+}
+
+func TestMigrateVersions(t *testing.T) {
+	ctx := context.Background()
+	s := open(t, testDSN(t))
+
+	// Channels stored before versions were recorded start at zero.
+	if err := s.MigrateTo(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.db.Exec(`INSERT INTO channels (id, key_path, status, state)
+		VALUES ('a', 1, $1, $2)`, int(channels.StatusOpen), `{"Status": 2, "Balance": 100}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Version != 0 || rec.Archived != "" || rec.SharedState.Balance != 100 {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+	next := rec.SharedState
+	next.Count++
+	if err := s.Update(ctx, "a", 1, next, nil); err != storage.ErrConflict {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+	if err := s.Update(ctx, "a", 0, next, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,236 @@
+package postgres
+
+import "github.com/luno/moonbeam/storage/migrate"
+
+// migrations upgrade the database's schema one version at a time. Released
+// migrations must never change; add a new one instead.
+//
+// Channel states are stored as JSON, with the fields that are filtered on in
+// their own columns. The first migration only creates
+// tables that don't exist, since databases created before versions were
+// recorded already have them.
+var migrations = []migrate.Migration{{
+	Version: 1,
+	Up: `
+	CREATE TABLE IF NOT EXISTS channels (
+		id               TEXT PRIMARY KEY,
+		key_path         INTEGER NOT NULL,
+		key_generation   INTEGER NOT NULL DEFAULT 0,
+		status           INTEGER NOT NULL,
+		sender_pubkey    BYTEA,
+		state            JSONB NOT NULL,
+		frozen           BOOLEAN NOT NULL DEFAULT FALSE,
+		frozen_reason    TEXT NOT NULL DEFAULT '',
+		suspended        BOOLEAN NOT NULL DEFAULT FALSE,
+		suspended_reason TEXT NOT NULL DEFAULT '',
+		closure          JSONB,
+		created          TIMESTAMPTZ,
+		account          TEXT NOT NULL DEFAULT '',
+		labels           JSONB
+	);
+	CREATE INDEX IF NOT EXISTS channels_status ON channels (status);
+	CREATE INDEX IF NOT EXISTS channels_sender_pubkey ON channels (sender_pubkey);
+	CREATE INDEX IF NOT EXISTS channels_account ON channels (account);
+
+	-- seq is the payment's index in the channel, from zero.
+	CREATE TABLE IF NOT EXISTS payments (
+		channel_id TEXT NOT NULL REFERENCES channels (id),
+		seq        INTEGER NOT NULL,
+		payment    BYTEA NOT NULL,
+		time       TIMESTAMPTZ,
+		PRIMARY KEY (channel_id, seq)
+	);
+	CREATE INDEX IF NOT EXISTS payments_time ON payments (time);
+
+	-- key_paths holds a single row with the key path counter and the
+	-- position of reuse within the gap limit.
+	CREATE TABLE IF NOT EXISTS key_paths (
+		id      BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+		counter INTEGER NOT NULL DEFAULT 0,
+		reuse   INTEGER NOT NULL DEFAULT 0
+	);
+	INSERT INTO key_paths (id) VALUES (TRUE) ON CONFLICT DO NOTHING;
+
+	CREATE TABLE IF NOT EXISTS key_generations (
+		generation INTEGER PRIMARY KEY,
+		xpub       TEXT NOT NULL UNIQUE,
+		created    TIMESTAMPTZ NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS destinations (
+		xpub       TEXT PRIMARY KEY,
+		next_index BIGINT NOT NULL
+	);
+
+	-- settings holds receiver-wide values, such as the watch height.
+	CREATE TABLE IF NOT EXISTS settings (
+		name  TEXT PRIMARY KEY,
+		value BIGINT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS revocations (
+		channel_id TEXT NOT NULL REFERENCES channels (id),
+		seq        SERIAL,
+		secret     BYTEA NOT NULL,
+		PRIMARY KEY (channel_id, seq)
+	);
+	CREATE TABLE IF NOT EXISTS sent_payments (
+		channel_id TEXT NOT NULL REFERENCES channels (id),
+		payment_id TEXT NOT NULL,
+		payment    BYTEA NOT NULL,
+		response   BYTEA NOT NULL,
+		PRIMARY KEY (channel_id, payment_id)
+	);
+	CREATE TABLE IF NOT EXISTS pending (
+		funding_address TEXT PRIMARY KEY,
+		pending         JSONB NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id      TEXT PRIMARY KEY,
+		hash    BYTEA NOT NULL,
+		scopes  TEXT NOT NULL,
+		created TIMESTAMPTZ NOT NULL,
+		account TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS target_credits (
+		seq        BIGSERIAL PRIMARY KEY,
+		channel_id TEXT NOT NULL,
+		target     TEXT NOT NULL,
+		amount     BIGINT NOT NULL,
+		commission BIGINT NOT NULL,
+		time       TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS target_credits_time ON target_credits (time);
+	CREATE TABLE IF NOT EXISTS target_balances (
+		target     TEXT PRIMARY KEY,
+		count      BIGINT NOT NULL,
+		gross      BIGINT NOT NULL,
+		commission BIGINT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS daily_totals (
+		day        TIMESTAMPTZ NOT NULL,
+		target     TEXT NOT NULL,
+		count      BIGINT NOT NULL,
+		gross      BIGINT NOT NULL,
+		commission BIGINT NOT NULL,
+		PRIMARY KEY (day, target)
+	);
+
+	CREATE TABLE IF NOT EXISTS invoices (
+		id           TEXT PRIMARY KEY,
+		amount       BIGINT NOT NULL,
+		target       TEXT NOT NULL,
+		expiry       TIMESTAMPTZ,
+		created      TIMESTAMPTZ,
+		account      TEXT NOT NULL DEFAULT '',
+		paid_channel TEXT NOT NULL DEFAULT '',
+		paid_count   INTEGER NOT NULL DEFAULT 0,
+		paid_time    TIMESTAMPTZ
+	);
+
+	CREATE TABLE IF NOT EXISTS dead_letters (
+		seq        BIGSERIAL PRIMARY KEY,
+		url        TEXT NOT NULL,
+		event      BYTEA NOT NULL,
+		attempts   INTEGER NOT NULL,
+		last_error TEXT NOT NULL,
+		time       TIMESTAMPTZ NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS outbox (
+		id         BIGSERIAL PRIMARY KEY,
+		type       TEXT NOT NULL,
+		channel_id TEXT NOT NULL,
+		event      BYTEA NOT NULL,
+		time       TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS leases (
+		id     TEXT PRIMARY KEY,
+		owner  TEXT NOT NULL,
+		token  BIGINT NOT NULL,
+		expiry TIMESTAMPTZ
+	);
+
+	CREATE TABLE IF NOT EXISTS access_entries (
+		seq     BIGSERIAL,
+		list    TEXT NOT NULL,
+		kind    TEXT NOT NULL,
+		value   TEXT NOT NULL,
+		note    TEXT NOT NULL,
+		created TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (list, kind, value)
+	);
+	CREATE TABLE IF NOT EXISTS screening_records (
+		seq        BIGSERIAL PRIMARY KEY,
+		time       TIMESTAMPTZ NOT NULL,
+		call       TEXT NOT NULL,
+		channel_id TEXT NOT NULL,
+		address    TEXT NOT NULL,
+		provider   TEXT NOT NULL,
+		reason     TEXT NOT NULL,
+		frozen     BOOLEAN NOT NULL
+	);
+`,
+	Down: `
+	DROP TABLE screening_records;
+	DROP TABLE access_entries;
+	DROP TABLE leases;
+	DROP TABLE outbox;
+	DROP TABLE dead_letters;
+	DROP TABLE invoices;
+	DROP TABLE daily_totals;
+	DROP TABLE target_balances;
+	DROP TABLE target_credits;
+	DROP TABLE api_keys;
+	DROP TABLE pending;
+	DROP TABLE sent_payments;
+	DROP TABLE revocations;
+	DROP TABLE settings;
+	DROP TABLE destinations;
+	DROP TABLE key_generations;
+	DROP TABLE key_paths;
+	DROP TABLE payments;
+	DROP TABLE channels;
+`,
+}, {
+	Version: 2,
+	Up: `
+	-- version is incremented by every update of the state, from one.
+	ALTER TABLE channels ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
+`,
+	Down: `
+	ALTER TABLE channels DROP COLUMN version;
+`,
+}, {
+	Version: 3,
+	Up: `
+	-- archived is the location of the batch the channel's payments were
+	-- moved to, or empty.
+	ALTER TABLE channels ADD COLUMN archived TEXT NOT NULL DEFAULT '';
+`,
+	Down: `
+	ALTER TABLE channels DROP COLUMN archived;
+`,
+}}
+
+// stateTables are the tables replaced by Restore, in the order their rows
+// are deleted. Leases are kept so that fencing tokens never go backwards.
+var stateTables = []string{
+	"payments",
+	"revocations",
+	"sent_payments",
+	"channels",
+	"key_generations",
+	"destinations",
+	"settings",
+	"pending",
+	"api_keys",
+	"target_credits",
+	"target_balances",
+	"daily_totals",
+	"invoices",
+	"dead_letters",
+	"outbox",
+	"access_entries",
+	"screening_records",
+}
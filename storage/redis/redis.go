@@ -0,0 +1,1465 @@
+// Package redis implements storage.Storage on Redis, for deployments that
+// already run Redis and want sub-millisecond state reads. Each channel is a
+// hash, its payments are a stream, and updates are made in WATCH/MULTI/EXEC
+// transactions, so Update's compare-and-swap holds across processes.
+//
+// Transactions span several keys, so Redis Cluster isn't supported.
+package redis
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+// Connection pool defaults set by Open.
+const (
+	DefaultMaxActive   = 20
+	DefaultMaxIdle     = 5
+	DefaultIdleTimeout = 5 * time.Minute
+)
+
+// maxRetries is how many times a transaction is retried when a key it
+// watches changes.
+const maxRetries = 10
+
+var errContention = errors.New("redis: too many concurrent updates")
+
+// Storage is a storage.Storage backed by Redis. All of its keys start with
+// a prefix, so that several networks or receivers can share a database.
+type Storage struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+// Open connects to the Redis server at rawurl, e.g.
+// redis://:password@localhost:6379/0, and checks that it persists writes to
+// an append-only file, without which accepted payments could be lost.
+func Open(ctx context.Context, rawurl, prefix string) (*Storage, error) {
+	pool := &redis.Pool{
+		MaxActive:   DefaultMaxActive,
+		MaxIdle:     DefaultMaxIdle,
+		IdleTimeout: DefaultIdleTimeout,
+		Wait:        true,
+		DialContext: func(ctx context.Context) (redis.Conn, error) {
+			return redis.DialURLContext(ctx, rawurl)
+		},
+	}
+	s := New(pool, prefix)
+	if err := s.checkPersistence(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	if err := s.indexSenders(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// New returns a storage using the pool's connections, whose keys start
+// with prefix.
+func New(pool *redis.Pool, prefix string) *Storage {
+	return &Storage{pool: pool, prefix: prefix}
+}
+
+// Close closes the connection pool.
+func (s *Storage) Close() error {
+	return s.pool.Close()
+}
+
+// checkPersistence returns an error if the server doesn't have the
+// append-only file enabled.
+func (s *Storage) checkPersistence(ctx context.Context) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	info, err := redis.String(conn.Do("INFO", "persistence"))
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if strings.TrimSpace(line) == "aof_enabled:1" {
+			return nil
+		}
+	}
+	return errors.New("redis: appendonly must be enabled to persist the state")
+}
+
+func (s *Storage) key(parts ...string) string {
+	return s.prefix + strings.Join(parts, ":")
+}
+
+// senderKey is the sorted set of the IDs of the sender's channels, all
+// scored zero so that they sort by ID. Channels are indexed when they're
+// created, since a channel's sender never changes.
+func (s *Storage) senderKey(senderPubKey []byte) string {
+	return s.key("senders", hex.EncodeToString(senderPubKey))
+}
+
+func (s *Storage) channelKey(id string) string  { return s.key("channel", id) }
+func (s *Storage) paymentsKey(id string) string { return s.key("payments", id) }
+func (s *Storage) leaseKey(id string) string    { return s.key("lease", id) }
+
+// cmd is a command queued in a transaction.
+type cmd struct {
+	name string
+	args []interface{}
+}
+
+func command(name string, args ...interface{}) cmd {
+	return cmd{name: name, args: args}
+}
+
+// do runs a single command on a connection from the pool.
+func (s *Storage) do(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.Do(name, args...)
+}
+
+// transact runs the commands returned by fn in a MULTI/EXEC transaction,
+// watching the keys while fn reads them. It retries if one of them changed
+// before the transaction ran, and returns the commands' replies. Nothing is
+// run if fn returns no commands.
+func (s *Storage) transact(ctx context.Context, keys []string, fn func(conn redis.Conn) ([]cmd, error)) ([]interface{}, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if len(keys) > 0 {
+			if _, err := conn.Do("WATCH", redis.Args{}.AddFlat(keys)...); err != nil {
+				return nil, err
+			}
+		}
+		cmds, err := fn(conn)
+		if err != nil || len(cmds) == 0 {
+			return nil, err
+		}
+
+		if err := conn.Send("MULTI"); err != nil {
+			return nil, err
+		}
+		for _, c := range cmds {
+			if err := conn.Send(c.name, c.args...); err != nil {
+				return nil, err
+			}
+		}
+		replies, err := redis.Values(conn.Do("EXEC"))
+		if err == redis.ErrNil {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, r := range replies {
+			if err, ok := r.(redis.Error); ok {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, errContention
+}
+
+func encodeTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func decodeTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n == 0 {
+		return time.Time{}, err
+	}
+	return time.Unix(0, n), nil
+}
+
+func encodeJSON(v interface{}) (string, error) {
+	buf, err := json.Marshal(v)
+	return string(buf), err
+}
+
+// recordArgs returns the fields of the channel's hash, other than its ID.
+func recordArgs(rec storage.Record) (redis.Args, error) {
+	state, err := encodeJSON(rec.SharedState)
+	if err != nil {
+		return nil, err
+	}
+	closure, err := encodeJSON(rec.Closure)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := encodeJSON(rec.Labels)
+	if err != nil {
+		return nil, err
+	}
+	return redis.Args{
+		"key_path", rec.KeyPath,
+		"key_generation", rec.KeyGeneration,
+		"state", state,
+		"version", rec.Version,
+		"frozen", rec.Frozen,
+		"frozen_reason", rec.FrozenReason,
+		"suspended", rec.Suspended,
+		"suspended_reason", rec.SuspendedReason,
+		"closure", closure,
+		"created", encodeTime(rec.Created),
+		"account", rec.Account,
+		"labels", labels,
+		"archived", rec.Archived,
+	}, nil
+}
+
+// decodeRecord decodes the fields of a channel's hash, returning
+// ErrNotFound if there are none.
+func decodeRecord(id string, m map[string]string) (*storage.Record, error) {
+	if len(m) == 0 {
+		return nil, storage.ErrNotFound
+	}
+	rec := storage.Record{
+		ID:              id,
+		Frozen:          m["frozen"] == "1",
+		FrozenReason:    m["frozen_reason"],
+		Suspended:       m["suspended"] == "1",
+		SuspendedReason: m["suspended_reason"],
+		Account:         m["account"],
+		Archived:        m["archived"],
+	}
+	var err error
+	if rec.KeyPath, err = strconv.Atoi(m["key_path"]); err != nil {
+		return nil, err
+	}
+	if rec.KeyGeneration, err = strconv.Atoi(m["key_generation"]); err != nil {
+		return nil, err
+	}
+	if rec.Created, err = decodeTime(m["created"]); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(m["state"]), &rec.SharedState); err != nil {
+		return nil, err
+	}
+	// Channels created before versions were recorded have none.
+	if v, ok := m["version"]; ok {
+		if rec.Version, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal([]byte(m["closure"]), &rec.Closure); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(m["labels"]), &rec.Labels); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s *Storage) Get(ctx context.Context, id string) (*storage.Record, error) {
+	m, err := redis.StringMap(s.do(ctx, "HGETALL", s.channelKey(id)))
+	if err != nil {
+		return nil, err
+	}
+	return decodeRecord(id, m)
+}
+
+// getRecords gets the channels in a single round trip.
+func (s *Storage) getRecords(conn redis.Conn, ids []string) ([]storage.Record, error) {
+	for _, id := range ids {
+		if err := conn.Send("HGETALL", s.channelKey(id)); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	var sl []storage.Record
+	for _, id := range ids {
+		m, err := redis.StringMap(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+		rec, err := decodeRecord(id, m)
+		if err == storage.ErrNotFound {
+			// Created after the IDs were listed.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		sl = append(sl, *rec)
+	}
+	return sl, nil
+}
+
+// channelIDs returns the IDs of the channels after the cursor, in order,
+// at most count of them if count isn't zero.
+func channelIDs(conn redis.Conn, key, cursor string, count int) ([]string, error) {
+	min := "-"
+	if cursor != "" {
+		min = "(" + cursor
+	}
+	args := []interface{}{key, min, "+"}
+	if count > 0 {
+		args = append(args, "LIMIT", 0, count)
+	}
+	return redis.Strings(conn.Do("ZRANGEBYLEX", args...))
+}
+
+func (s *Storage) List(ctx context.Context) ([]storage.Record, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ids, err := channelIDs(conn, s.key("channels"), "", 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.getRecords(conn, ids)
+}
+
+func (s *Storage) GetBySenderPubKey(ctx context.Context, senderPubKey []byte) ([]storage.Record, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ids, err := channelIDs(conn, s.senderKey(senderPubKey), "", 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.getRecords(conn, ids)
+}
+
+// indexSenders adds the channels to the index by sender pubkey, unless it
+// has been done before. Channels created before the index existed aren't
+// in it.
+func (s *Storage) indexSenders(ctx context.Context) error {
+	done, err := redis.Bool(s.do(ctx, "EXISTS", s.key("sender_index")))
+	if err != nil || done {
+		return err
+	}
+	recs, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	var cmds []cmd
+	for _, rec := range recs {
+		cmds = append(cmds, command("ZADD", s.senderKey(rec.SharedState.SenderPubKey), 0, rec.ID))
+	}
+	cmds = append(cmds, command("SET", s.key("sender_index"), 1))
+	_, err = s.transact(ctx, nil, func(conn redis.Conn) ([]cmd, error) {
+		return cmds, nil
+	})
+	return err
+}
+
+// pageBatch is how many channels ListPage, or payments ListPaymentsPage,
+// gets at a time.
+const pageBatch = 100
+
+// ListPage reads the IDs after cursor from the channels sorted set, or the
+// sender's if the filter has a sender, pageBatch at a time, until the page
+// is full.
+func (s *Storage) ListPage(ctx context.Context, f storage.ListFilter, cursor string, limit int) ([]storage.Record, string, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+
+	key := s.key("channels")
+	if len(f.SenderPubKey) > 0 {
+		key = s.senderKey(f.SenderPubKey)
+	}
+
+	sl := []storage.Record{}
+	for {
+		ids, err := channelIDs(conn, key, cursor, pageBatch)
+		if err != nil {
+			return nil, "", err
+		} else if len(ids) == 0 {
+			return sl, "", nil
+		}
+		cursor = ids[len(ids)-1]
+		recs, err := s.getRecords(conn, ids)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, rec := range recs {
+			if !f.Match(rec) {
+				continue
+			}
+			if limit > 0 && len(sl) == limit {
+				return sl, sl[limit-1].ID, nil
+			}
+			sl = append(sl, rec)
+		}
+	}
+}
+
+func (s *Storage) Create(ctx context.Context, rec storage.Record) error {
+	if rec.ID == "" {
+		return errors.New("invalid id")
+	}
+	rec.Version = 1
+	args, err := recordArgs(rec)
+	if err != nil {
+		return err
+	}
+	key, keyPaths := s.channelKey(rec.ID), s.key("keypaths")
+	_, err = s.transact(ctx, []string{key, keyPaths}, func(conn redis.Conn) ([]cmd, error) {
+		exists, err := redis.Bool(conn.Do("EXISTS", key))
+		if err != nil {
+			return nil, err
+		} else if exists {
+			return nil, errors.New("record already exists")
+		}
+		used, err := redis.Int(conn.Do("HGET", keyPaths, "used"))
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+
+		cmds := []cmd{
+			command("HSET", append(redis.Args{key}, args...)...),
+			command("ZADD", s.key("channels"), 0, rec.ID),
+			command("ZADD", s.senderKey(rec.SharedState.SenderPubKey), 0, rec.ID),
+		}
+		if rec.KeyPath > used {
+			cmds = append(cmds, command("HSET", keyPaths, "used", rec.KeyPath))
+		}
+		return cmds, nil
+	})
+	return err
+}
+
+func (s *Storage) Update(ctx context.Context, id string, version int64, new channels.SharedState, payment []byte) error {
+	return s.Commit(ctx, storage.Tx{ChannelID: id, Version: version, State: new, Payment: payment})
+}
+
+// Commit runs the commands of the writes in a single MULTI, watching the
+// channel, its lease and, if an invoice is paid, the invoices.
+func (s *Storage) Commit(ctx context.Context, tx storage.Tx) error {
+	invoices := s.key("invoices")
+	keys := []string{s.channelKey(tx.ChannelID), s.leaseKey(tx.ChannelID)}
+	if tx.InvoiceID != "" {
+		keys = append(keys, invoices)
+	}
+	var credit []cmd
+	if tx.Credit != nil {
+		var err error
+		if credit, err = s.creditCommands(*tx.Credit); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.transact(ctx, keys, func(conn redis.Conn) ([]cmd, error) {
+		var inv *storage.Invoice
+		if tx.InvoiceID != "" {
+			var err error
+			inv, err = getInvoice(conn, invoices, tx.InvoiceID)
+			if err != nil {
+				return nil, err
+			}
+			if inv.PaidChannel != "" {
+				return nil, storage.ErrInvoicePaid
+			}
+		}
+
+		cmds, err := s.update(ctx, conn, tx.ChannelID, tx.Version, tx.State, tx.Payment)
+		if err != nil {
+			return nil, err
+		}
+
+		if inv != nil {
+			inv.PaidChannel = tx.ChannelID
+			inv.PaidCount = tx.State.Count
+			inv.PaidTime = time.Now()
+			buf, err := encodeJSON(inv)
+			if err != nil {
+				return nil, err
+			}
+			cmds = append(cmds, command("HSET", invoices, tx.InvoiceID, buf))
+		}
+		return append(cmds, credit...), nil
+	})
+	return err
+}
+
+// update returns the commands that store the channel's new state and the
+// payment, if any, if its version is still version. The channel's and its
+// lease's keys must be watched.
+func (s *Storage) update(ctx context.Context, conn redis.Conn, id string, version int64, new channels.SharedState, payment []byte) ([]cmd, error) {
+	key := s.channelKey(id)
+	exists, err := redis.Bool(conn.Do("EXISTS", key))
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, storage.ErrNotFound
+	}
+
+	if token, ok := storage.FenceToken(ctx, id); ok {
+		cur, err := redis.Int64(conn.Do("HGET", s.leaseKey(id), "token"))
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+		if token < cur {
+			return nil, storage.ErrFenced
+		}
+	}
+
+	cur, err := redis.Int64(conn.Do("HGET", key, "version"))
+	if err != nil && err != redis.ErrNil {
+		return nil, err
+	}
+	if cur != version {
+		return nil, storage.ErrConflict
+	}
+
+	state, err := encodeJSON(new)
+	if err != nil {
+		return nil, err
+	}
+	cmds := []cmd{command("HSET", key, "state", state, "version", version+1)}
+	if payment != nil {
+		cmds = append(cmds, command("XADD", s.paymentsKey(id), "*",
+			"payment", payment, "time", encodeTime(time.Now())))
+	}
+	return cmds, nil
+}
+
+// modify sets fields of the channel's hash, if it exists.
+func (s *Storage) modify(ctx context.Context, id string, fields ...interface{}) error {
+	key := s.channelKey(id)
+	_, err := s.transact(ctx, []string{key}, func(conn redis.Conn) ([]cmd, error) {
+		exists, err := redis.Bool(conn.Do("EXISTS", key))
+		if err != nil {
+			return nil, err
+		} else if !exists {
+			return nil, storage.ErrNotFound
+		}
+		return []cmd{command("HSET", append([]interface{}{key}, fields...)...)}, nil
+	})
+	return err
+}
+
+func (s *Storage) ReserveKeyPath(ctx context.Context, gapLimit int) (int, error) {
+	key := s.key("keypaths")
+	var path int
+	_, err := s.transact(ctx, []string{key}, func(conn redis.Conn) ([]cmd, error) {
+		kp, err := redis.IntMap(conn.Do("HGETALL", key))
+		if err != nil {
+			return nil, err
+		}
+		counter, reuse, used := kp["counter"], kp["reuse"], kp["used"]
+
+		if gapLimit > 0 && counter-used >= gapLimit {
+			reuse = reuse%gapLimit + 1
+			path = used + reuse
+		} else {
+			counter++
+			path = counter
+		}
+		return []cmd{command("HSET", key, "counter", counter, "reuse", reuse)}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return path, nil
+}
+
+func (s *Storage) GetKeyPathCounter(ctx context.Context) (int, error) {
+	n, err := redis.Int(s.do(ctx, "HGET", s.key("keypaths"), "counter"))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return n, err
+}
+
+// listJSON decodes the JSON values of a list into the slice pointed to by
+// v.
+func listJSON(conn redis.Conn, key string, v interface{}) error {
+	values, err := redis.Strings(conn.Do("LRANGE", key, 0, -1))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte("["+strings.Join(values, ",")+"]"), v)
+}
+
+func (s *Storage) listJSON(ctx context.Context, key string, v interface{}) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return listJSON(conn, key, v)
+}
+
+func (s *Storage) pushJSON(ctx context.Context, key string, v interface{}) error {
+	buf, err := encodeJSON(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.do(ctx, "RPUSH", key, buf)
+	return err
+}
+
+func (s *Storage) ListKeyGenerations(ctx context.Context) ([]storage.KeyGeneration, error) {
+	var sl []storage.KeyGeneration
+	if err := s.listJSON(ctx, s.key("keygens"), &sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) AddKeyGeneration(ctx context.Context, xpub string) (storage.KeyGeneration, error) {
+	key := s.key("keygens")
+	var g storage.KeyGeneration
+	_, err := s.transact(ctx, []string{key}, func(conn redis.Conn) ([]cmd, error) {
+		var gens []storage.KeyGeneration
+		if err := listJSON(conn, key, &gens); err != nil {
+			return nil, err
+		}
+		for _, g := range gens {
+			if g.XPub == xpub {
+				return nil, storage.ErrDuplicateKey
+			}
+		}
+		g = storage.KeyGeneration{
+			Generation: len(gens),
+			XPub:       xpub,
+			Created:    time.Now(),
+		}
+		buf, err := encodeJSON(g)
+		if err != nil {
+			return nil, err
+		}
+		return []cmd{command("RPUSH", key, buf)}, nil
+	})
+	if err != nil {
+		return storage.KeyGeneration{}, err
+	}
+	return g, nil
+}
+
+func (s *Storage) ReserveDestinationIndex(ctx context.Context, xpub string) (uint32, error) {
+	n, err := redis.Int64(s.do(ctx, "HINCRBY", s.key("destinations"), xpub, 1))
+	if err != nil {
+		return 0, err
+	}
+	return uint32(n - 1), nil
+}
+
+// decodePayments decodes the entries of a channel's payments stream.
+func decodePayments(id string, reply interface{}) ([]storage.StoredPayment, error) {
+	sl, _, err := decodeEntries(id, reply)
+	return sl, err
+}
+
+// decodeEntries decodes the entries of a channel's payments stream, and
+// returns their entry IDs too.
+func decodeEntries(id string, reply interface{}) ([]storage.StoredPayment, []string, error) {
+	entries, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sl []storage.StoredPayment
+	var ids []string
+	for _, e := range entries {
+		entry, err := redis.Values(e, nil)
+		if err != nil || len(entry) != 2 {
+			return nil, nil, fmt.Errorf("invalid stream entry: %v", err)
+		}
+		entryID, err := redis.String(entry[0], nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		fields, err := redis.StringMap(entry[1], nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		t, err := decodeTime(fields["time"])
+		if err != nil {
+			return nil, nil, err
+		}
+		sl = append(sl, storage.StoredPayment{
+			ChannelID: id,
+			Payment:   []byte(fields["payment"]),
+			Time:      t,
+		})
+		ids = append(ids, entryID)
+	}
+	return sl, ids, nil
+}
+
+func (s *Storage) ListPayments(ctx context.Context, channelID string) ([][]byte, error) {
+	sps, err := s.ListChannelPayments(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	var sl [][]byte
+	for _, sp := range sps {
+		sl = append(sl, sp.Payment)
+	}
+	return sl, nil
+}
+
+func (s *Storage) ListChannelPayments(ctx context.Context, channelID string) ([]storage.StoredPayment, error) {
+	reply, err := s.do(ctx, "XRANGE", s.paymentsKey(channelID), "-", "+")
+	if err != nil {
+		return nil, err
+	}
+	return decodePayments(channelID, reply)
+}
+
+// ListPaymentsPage reads the channels' payments streams from the cursor, a
+// batch at a time, so a page only reads the payments it skips and returns.
+// The cursor holds the stream entry ID of the last payment.
+func (s *Storage) ListPaymentsPage(ctx context.Context, f storage.PaymentFilter, cursor string, limit int) ([]storage.StoredPayment, string, error) {
+	var after, afterEntry string
+	if cursor != "" {
+		var err error
+		if after, afterEntry, err = storage.ParsePaymentCursor(cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+
+	ids := []string{f.ChannelID}
+	if f.ChannelID == "" {
+		min := "-"
+		if after != "" {
+			min = "[" + after
+		}
+		ids, err = redis.Strings(conn.Do("ZRANGEBYLEX", s.key("channels"), min, "+"))
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	sl := []storage.StoredPayment{}
+	var next string
+	for _, id := range ids {
+		if id < after {
+			continue
+		}
+		start := "-"
+		if id == after {
+			start = "(" + afterEntry
+		}
+		for {
+			args := []interface{}{s.paymentsKey(id), start, "+"}
+			if limit > 0 {
+				args = append(args, "COUNT", pageBatch)
+			}
+			reply, err := conn.Do("XRANGE", args...)
+			if err != nil {
+				return nil, "", err
+			}
+			sps, entries, err := decodeEntries(id, reply)
+			if err != nil {
+				return nil, "", err
+			}
+			for i, sp := range sps {
+				if !f.Match(sp) {
+					continue
+				}
+				if limit > 0 && len(sl) == limit {
+					return sl, next, nil
+				}
+				sl = append(sl, sp)
+				next = storage.PaymentCursor(id, entries[i])
+			}
+			if limit == 0 || len(entries) < pageBatch {
+				break
+			}
+			start = "(" + entries[len(entries)-1]
+		}
+	}
+	return sl, "", nil
+}
+
+func (s *Storage) QueryPayments(ctx context.Context, from, to time.Time) ([]storage.StoredPayment, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ids, err := channelIDs(conn, s.key("channels"), "", 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if err := conn.Send("XRANGE", s.paymentsKey(id), "-", "+"); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	var sl []storage.StoredPayment
+	for _, id := range ids {
+		reply, err := conn.Receive()
+		if err != nil {
+			return nil, err
+		}
+		sps, err := decodePayments(id, reply)
+		if err != nil {
+			return nil, err
+		}
+		for _, sp := range sps {
+			if (!from.IsZero() && sp.Time.Before(from)) || (!to.IsZero() && !sp.Time.Before(to)) {
+				continue
+			}
+			sl = append(sl, sp)
+		}
+	}
+	sort.SliceStable(sl, func(i, j int) bool {
+		return sl[i].Time.Before(sl[j].Time)
+	})
+	return sl, nil
+}
+
+func (s *Storage) Freeze(ctx context.Context, id string, reason string) error {
+	return s.modify(ctx, id, "frozen", true, "frozen_reason", reason)
+}
+
+func (s *Storage) Suspend(ctx context.Context, id string, suspended bool, reason string) error {
+	return s.modify(ctx, id, "suspended", suspended, "suspended_reason", reason)
+}
+
+func (s *Storage) SetLabels(ctx context.Context, id string, labels map[string]string) error {
+	buf, err := encodeJSON(labels)
+	if err != nil {
+		return err
+	}
+	return s.modify(ctx, id, "labels", buf)
+}
+
+func (s *Storage) SetClosure(ctx context.Context, id string, c storage.Closure) error {
+	buf, err := encodeJSON(c)
+	if err != nil {
+		return err
+	}
+	return s.modify(ctx, id, "closure", buf)
+}
+
+// ArchiveChannel deletes the channel's logs and sets the stub's location in a
+// single MULTI, watching the channel.
+func (s *Storage) ArchiveChannel(ctx context.Context, id string, version int64, location string) error {
+	key := s.channelKey(id)
+	_, err := s.transact(ctx, []string{key}, func(conn redis.Conn) ([]cmd, error) {
+		cur, err := redis.Int64(conn.Do("HGET", key, "version"))
+		if err == redis.ErrNil {
+			exists, err := redis.Bool(conn.Do("EXISTS", key))
+			if err != nil {
+				return nil, err
+			} else if !exists {
+				return nil, storage.ErrNotFound
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		if cur != version {
+			return nil, storage.ErrConflict
+		}
+		return []cmd{
+			command("DEL", s.paymentsKey(id), s.key("revocations", id), s.key("sent", id)),
+			command("HSET", key, "archived", location),
+		}, nil
+	})
+	return err
+}
+
+func (s *Storage) getSetting(ctx context.Context, name string) (int64, error) {
+	v, err := redis.Int64(s.do(ctx, "HGET", s.key("settings"), name))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (s *Storage) setSetting(ctx context.Context, name string, v int64) error {
+	_, err := s.do(ctx, "HSET", s.key("settings"), name, v)
+	return err
+}
+
+const (
+	settingWatchHeight   = "watch_height"
+	settingAllowlistOnly = "allowlist_only"
+)
+
+func (s *Storage) GetWatchHeight(ctx context.Context) (int64, error) {
+	return s.getSetting(ctx, settingWatchHeight)
+}
+
+func (s *Storage) SetWatchHeight(ctx context.Context, height int64) error {
+	return s.setSetting(ctx, settingWatchHeight, height)
+}
+
+func (s *Storage) GetAllowlistOnly(ctx context.Context) (bool, error) {
+	v, err := s.getSetting(ctx, settingAllowlistOnly)
+	return v != 0, err
+}
+
+func (s *Storage) SetAllowlistOnly(ctx context.Context, on bool) error {
+	var v int64
+	if on {
+		v = 1
+	}
+	return s.setSetting(ctx, settingAllowlistOnly, v)
+}
+
+// ifChannel runs c if the channel exists, and returns ErrNotFound if it
+// doesn't.
+func (s *Storage) ifChannel(ctx context.Context, id string, c cmd) (interface{}, error) {
+	key := s.channelKey(id)
+	replies, err := s.transact(ctx, []string{key}, func(conn redis.Conn) ([]cmd, error) {
+		exists, err := redis.Bool(conn.Do("EXISTS", key))
+		if err != nil {
+			return nil, err
+		} else if !exists {
+			return nil, storage.ErrNotFound
+		}
+		return []cmd{c}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+func (s *Storage) AddRevocationSecret(ctx context.Context, channelID string, secret []byte) error {
+	_, err := s.ifChannel(ctx, channelID, command("RPUSH", s.key("revocations", channelID), secret))
+	return err
+}
+
+func (s *Storage) ListRevocationSecrets(ctx context.Context, channelID string) ([][]byte, error) {
+	sl, err := redis.ByteSlices(s.do(ctx, "LRANGE", s.key("revocations", channelID), 0, -1))
+	if len(sl) == 0 {
+		return nil, err
+	}
+	return sl, err
+}
+
+func (s *Storage) AddSentPayment(ctx context.Context, channelID string, p storage.SentPayment) error {
+	buf, err := encodeJSON(p)
+	if err != nil {
+		return err
+	}
+	// Like the filesystem storage, the first payment recorded with an ID
+	// is the one returned by GetSentPayment.
+	_, err = s.ifChannel(ctx, channelID, command("HSETNX", s.key("sent", channelID), p.PaymentID, buf))
+	return err
+}
+
+func (s *Storage) GetSentPayment(ctx context.Context, channelID string, paymentID string) (*storage.SentPayment, error) {
+	buf, err := redis.Bytes(s.do(ctx, "HGET", s.key("sent", channelID), paymentID))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var p storage.SentPayment
+	if err := json.Unmarshal(buf, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *Storage) PutPending(ctx context.Context, p storage.Pending) error {
+	if p.FundingAddress == "" {
+		return errors.New("invalid funding address")
+	}
+	buf, err := encodeJSON(p)
+	if err != nil {
+		return err
+	}
+	_, err = s.do(ctx, "HSET", s.key("pending"), p.FundingAddress, buf)
+	return err
+}
+
+// hashJSON decodes the JSON values of a hash, ordered by field, into the
+// slice pointed to by v.
+func hashJSON(reply interface{}, err error, v interface{}) error {
+	m, err := redis.StringMap(reply, err)
+	if err != nil {
+		return err
+	}
+	fields := make([]string, 0, len(m))
+	for f := range m {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	values := make([]string, len(fields))
+	for i, f := range fields {
+		values[i] = m[f]
+	}
+	return json.Unmarshal([]byte("["+strings.Join(values, ",")+"]"), v)
+}
+
+func (s *Storage) ListPending(ctx context.Context) ([]storage.Pending, error) {
+	var sl []storage.Pending
+	reply, err := s.do(ctx, "HGETALL", s.key("pending"))
+	if err := hashJSON(reply, err, &sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) DeletePending(ctx context.Context, fundingAddress string) error {
+	_, err := s.do(ctx, "HDEL", s.key("pending"), fundingAddress)
+	return err
+}
+
+func (s *Storage) AddAPIKey(ctx context.Context, k storage.APIKey) error {
+	buf, err := encodeJSON(k)
+	if err != nil {
+		return err
+	}
+	added, err := redis.Bool(s.do(ctx, "HSETNX", s.key("apikeys"), k.ID, buf))
+	if err != nil {
+		return err
+	} else if !added {
+		return errors.New("api key already exists")
+	}
+	return nil
+}
+
+func (s *Storage) GetAPIKey(ctx context.Context, id string) (*storage.APIKey, error) {
+	buf, err := redis.Bytes(s.do(ctx, "HGET", s.key("apikeys"), id))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var k storage.APIKey
+	if err := json.Unmarshal(buf, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *Storage) DeleteAPIKey(ctx context.Context, id string) error {
+	_, err := s.do(ctx, "HDEL", s.key("apikeys"), id)
+	return err
+}
+
+// dailyMember is the member of the days set for a target's daily total.
+func dailyMember(day time.Time, target string) string {
+	return strconv.FormatInt(encodeTime(day), 10) + ":" + target
+}
+
+func (s *Storage) AddTargetCredit(ctx context.Context, c storage.TargetCredit) error {
+	cmds, err := s.creditCommands(c)
+	if err != nil {
+		return err
+	}
+	_, err = s.transact(ctx, nil, func(conn redis.Conn) ([]cmd, error) {
+		return cmds, nil
+	})
+	return err
+}
+
+// creditCommands returns the commands that record the credit and add it to
+// its totals.
+func (s *Storage) creditCommands(c storage.TargetCredit) ([]cmd, error) {
+	buf, err := encodeJSON(c)
+	if err != nil {
+		return nil, err
+	}
+	day := dailyMember(storage.Day(c.Time), c.Target)
+	balance, daily := s.key("balance", c.Target), s.key("daily", day)
+	return []cmd{
+		command("RPUSH", s.key("credits"), buf),
+		command("SADD", s.key("targets"), c.Target),
+		command("HINCRBY", balance, "count", 1),
+		command("HINCRBY", balance, "gross", c.Amount),
+		command("HINCRBY", balance, "commission", c.Commission),
+		command("SADD", s.key("days"), day),
+		command("HINCRBY", daily, "count", 1),
+		command("HINCRBY", daily, "gross", c.Amount),
+		command("HINCRBY", daily, "commission", c.Commission),
+	}, nil
+}
+
+func (s *Storage) ListTargetCredits(ctx context.Context, from, to time.Time) ([]storage.TargetCredit, error) {
+	var all []storage.TargetCredit
+	if err := s.listJSON(ctx, s.key("credits"), &all); err != nil {
+		return nil, err
+	}
+	var sl []storage.TargetCredit
+	for _, c := range all {
+		if (!from.IsZero() && c.Time.Before(from)) || (!to.IsZero() && !c.Time.Before(to)) {
+			continue
+		}
+		sl = append(sl, c)
+	}
+	return sl, nil
+}
+
+// getTotals gets the count, gross and commission hashes of the keys in a
+// single round trip.
+func getTotals(conn redis.Conn, keys []string) ([]map[string]int64, error) {
+	for _, k := range keys {
+		if err := conn.Send("HGETALL", k); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	sl := make([]map[string]int64, len(keys))
+	for i := range keys {
+		m, err := redis.Int64Map(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+		sl[i] = m
+	}
+	return sl, nil
+}
+
+func (s *Storage) ListTargetBalances(ctx context.Context) ([]storage.TargetBalance, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	targets, err := redis.Strings(conn.Do("SMEMBERS", s.key("targets")))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(targets)
+	keys := make([]string, len(targets))
+	for i, t := range targets {
+		keys[i] = s.key("balance", t)
+	}
+	totals, err := getTotals(conn, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var sl []storage.TargetBalance
+	for i, t := range targets {
+		sl = append(sl, storage.TargetBalance{
+			Target:     t,
+			Count:      totals[i]["count"],
+			Gross:      totals[i]["gross"],
+			Commission: totals[i]["commission"],
+		})
+	}
+	return sl, nil
+}
+
+func (s *Storage) ListDailyTotals(ctx context.Context, target string, from, to time.Time) ([]storage.DailyTotal, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	members, err := redis.Strings(conn.Do("SMEMBERS", s.key("days")))
+	if err != nil {
+		return nil, err
+	}
+	var sl []storage.DailyTotal
+	var keys []string
+	for _, m := range members {
+		i := strings.Index(m, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid daily total %q", m)
+		}
+		day, err := decodeTime(m[:i])
+		if err != nil {
+			return nil, err
+		}
+		t := storage.DailyTotal{Day: day.UTC(), Target: m[i+1:]}
+		if target != "" && t.Target != target {
+			continue
+		}
+		if (!from.IsZero() && t.Day.Before(from)) || (!to.IsZero() && !t.Day.Before(to)) {
+			continue
+		}
+		sl = append(sl, t)
+		keys = append(keys, s.key("daily", m))
+	}
+
+	totals, err := getTotals(conn, keys)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sl {
+		sl[i].Count = totals[i]["count"]
+		sl[i].Gross = totals[i]["gross"]
+		sl[i].Commission = totals[i]["commission"]
+	}
+	sort.Slice(sl, func(i, j int) bool {
+		if !sl[i].Day.Equal(sl[j].Day) {
+			return sl[i].Day.Before(sl[j].Day)
+		}
+		return sl[i].Target < sl[j].Target
+	})
+	return sl, nil
+}
+
+func (s *Storage) AddInvoice(ctx context.Context, inv storage.Invoice) error {
+	buf, err := encodeJSON(inv)
+	if err != nil {
+		return err
+	}
+	added, err := redis.Bool(s.do(ctx, "HSETNX", s.key("invoices"), inv.ID, buf))
+	if err != nil {
+		return err
+	} else if !added {
+		return errors.New("invoice already exists")
+	}
+	return nil
+}
+
+func getInvoice(conn redis.Conn, key, id string) (*storage.Invoice, error) {
+	buf, err := redis.Bytes(conn.Do("HGET", key, id))
+	if err == redis.ErrNil {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var inv storage.Invoice
+	if err := json.Unmarshal(buf, &inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (s *Storage) GetInvoice(ctx context.Context, id string) (*storage.Invoice, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return getInvoice(conn, s.key("invoices"), id)
+}
+
+func (s *Storage) AddDeadLetter(ctx context.Context, dl storage.DeadLetter) error {
+	return s.pushJSON(ctx, s.key("deadletters"), dl)
+}
+
+func (s *Storage) ListDeadLetters(ctx context.Context) ([]storage.DeadLetter, error) {
+	var sl []storage.DeadLetter
+	if err := s.listJSON(ctx, s.key("deadletters"), &sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) AddOutbox(ctx context.Context, e storage.OutboxEvent) (int64, error) {
+	id, err := redis.Int64(s.do(ctx, "INCR", s.key("outbox", "seq")))
+	if err != nil {
+		return 0, err
+	}
+	e.ID = id
+	buf, err := encodeJSON(e)
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.transact(ctx, nil, func(conn redis.Conn) ([]cmd, error) {
+		return []cmd{
+			command("HSET", s.key("outbox"), id, buf),
+			command("ZADD", s.key("outbox", "ids"), id, id),
+		}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *Storage) ListOutbox(ctx context.Context, limit int) ([]storage.OutboxEvent, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("ZRANGE", s.key("outbox", "ids"), 0, limit-1))
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	values, err := redis.Strings(conn.Do("HMGET", redis.Args{s.key("outbox")}.AddFlat(ids)...))
+	if err != nil {
+		return nil, err
+	}
+	var sl []storage.OutboxEvent
+	if err := json.Unmarshal([]byte("["+strings.Join(values, ",")+"]"), &sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) DeleteOutbox(ctx context.Context, id int64) error {
+	_, err := s.transact(ctx, nil, func(conn redis.Conn) ([]cmd, error) {
+		return []cmd{
+			command("HDEL", s.key("outbox"), id),
+			command("ZREM", s.key("outbox", "ids"), id),
+		}, nil
+	})
+	return err
+}
+
+// AcquireLease grants leases between processes sharing the database. Expiry
+// is measured with the instances' clocks, which must be roughly in sync.
+func (s *Storage) AcquireLease(ctx context.Context, id, owner string, ttl time.Duration) (int64, error) {
+	key := s.leaseKey(id)
+	var token int64
+	_, err := s.transact(ctx, []string{key}, func(conn redis.Conn) ([]cmd, error) {
+		l, err := redis.StringMap(conn.Do("HGETALL", key))
+		if err != nil {
+			return nil, err
+		}
+		expiry, err := decodeTime(l["expiry"])
+		if err != nil {
+			return nil, err
+		}
+		if l["token"] != "" {
+			if token, err = strconv.ParseInt(l["token"], 10, 64); err != nil {
+				return nil, err
+			}
+		}
+
+		now := time.Now()
+		if l["owner"] != owner && now.Before(expiry) {
+			return nil, storage.ErrLeaseHeld
+		}
+		token++
+		return []cmd{command("HSET", key, "owner", owner, "token", token,
+			"expiry", encodeTime(now.Add(ttl)))}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return token, nil
+}
+
+func (s *Storage) ReleaseLease(ctx context.Context, id, owner string, token int64) error {
+	key := s.leaseKey(id)
+	_, err := s.transact(ctx, []string{key}, func(conn redis.Conn) ([]cmd, error) {
+		l, err := redis.StringMap(conn.Do("HGETALL", key))
+		if err != nil {
+			return nil, err
+		}
+		if l["owner"] != owner || l["token"] != strconv.FormatInt(token, 10) {
+			return nil, nil
+		}
+		// The token is kept so that later leases get higher tokens.
+		return []cmd{command("HSET", key, "owner", "", "expiry", 0)}, nil
+	})
+	return err
+}
+
+// findAccessEntry returns the index and the encoding of the entry in the
+// access list, or -1 if it isn't on it.
+func findAccessEntry(conn redis.Conn, key string, list storage.AccessList, kind storage.AccessKind, value string) (int, string, error) {
+	values, err := redis.Strings(conn.Do("LRANGE", key, 0, -1))
+	if err != nil {
+		return 0, "", err
+	}
+	for i, v := range values {
+		var e storage.AccessEntry
+		if err := json.Unmarshal([]byte(v), &e); err != nil {
+			return 0, "", err
+		}
+		if e.List == list && e.Kind == kind && e.Value == value {
+			return i, v, nil
+		}
+	}
+	return -1, "", nil
+}
+
+func (s *Storage) PutAccessEntry(ctx context.Context, e storage.AccessEntry) error {
+	buf, err := encodeJSON(e)
+	if err != nil {
+		return err
+	}
+	key := s.key("access")
+	_, err = s.transact(ctx, []string{key}, func(conn redis.Conn) ([]cmd, error) {
+		i, _, err := findAccessEntry(conn, key, e.List, e.Kind, e.Value)
+		if err != nil {
+			return nil, err
+		} else if i < 0 {
+			return []cmd{command("RPUSH", key, buf)}, nil
+		}
+		return []cmd{command("LSET", key, i, buf)}, nil
+	})
+	return err
+}
+
+func (s *Storage) DeleteAccessEntry(ctx context.Context, list storage.AccessList, kind storage.AccessKind, value string) error {
+	key := s.key("access")
+	_, err := s.transact(ctx, []string{key}, func(conn redis.Conn) ([]cmd, error) {
+		i, v, err := findAccessEntry(conn, key, list, kind, value)
+		if err != nil {
+			return nil, err
+		} else if i < 0 {
+			return nil, storage.ErrNotFound
+		}
+		return []cmd{command("LREM", key, 1, v)}, nil
+	})
+	return err
+}
+
+func (s *Storage) ListAccessEntries(ctx context.Context) ([]storage.AccessEntry, error) {
+	var sl []storage.AccessEntry
+	if err := s.listJSON(ctx, s.key("access"), &sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (s *Storage) AddScreeningRecord(ctx context.Context, rec storage.ScreeningRecord) error {
+	return s.pushJSON(ctx, s.key("screening"), rec)
+}
+
+func (s *Storage) ListScreeningRecords(ctx context.Context) ([]storage.ScreeningRecord, error) {
+	var sl []storage.ScreeningRecord
+	if err := s.listJSON(ctx, s.key("screening"), &sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+// Make sure Storage implements storage.Storage.
+var _ storage.Storage = &Storage{}
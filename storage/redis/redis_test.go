@@ -0,0 +1,116 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/storagetest"
+)
+
+func open(t *testing.T, m *miniredis.Miniredis, prefix string) *Storage {
+	t.Helper()
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", m.Addr())
+		},
+	}
+	s := New(pool, prefix)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return open(t, miniredis.RunT(t), "mb:")
+	})
+}
+
+func TestUpdateConcurrent(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	prev := storagetest.Create(t, open(t, m, "mb:"), "a")
+
+	// Updates from separate pools race to replace the same state.
+	const n = 8
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		s := open(t, m, "mb:")
+		next := prev
+		next.Count++
+		next.Balance += int64(i)
+		go func() { errs <- s.Update(ctx, "a", 1, next, []byte("p")) }()
+	}
+	var ok int
+	for i := 0; i < n; i++ {
+		err := <-errs
+		if err == nil {
+			ok++
+		} else if err != storage.ErrConflict {
+			t.Error(err)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("Expected one update to succeed, got %d", ok)
+	}
+}
+
+func TestOpenRequiresAOF(t *testing.T) {
+	m := miniredis.RunT(t)
+	_, err := Open(context.Background(), "redis://"+m.Addr(), "mb:")
+	if err == nil {
+		t.Error("Expected an error without appendonly")
+	}
+}
+
+func TestRestorePrefix(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	s := open(t, m, "mb:")
+	storagetest.Create(t, s, "a")
+	snap, err := s.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys under another prefix in the same database are left alone.
+	other := open(t, m, "other:")
+	storagetest.Create(t, other, "c")
+	restored := open(t, m, "restored:")
+	storagetest.Create(t, restored, "b")
+	if err := restored.Restore(ctx, *snap); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := restored.Get(ctx, "b"); err != storage.ErrNotFound {
+		t.Errorf("Expected channel b to be replaced, got %v", err)
+	}
+	if _, err := restored.Get(ctx, "a"); err != nil {
+		t.Errorf("Expected channel a to be restored, got %v", err)
+	}
+	if _, err := other.Get(ctx, "c"); err != nil {
+		t.Errorf("Expected channel c to be kept, got %v", err)
+	}
+}
+
+func TestIndexSenders(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	s := open(t, m, "mb:")
+	storagetest.CreateSenders(t, s)
+
+	// Channels created before the index are indexed once.
+	for _, k := range m.Keys() {
+		if strings.HasPrefix(k, "mb:senders:") || k == "mb:sender_index" {
+			m.Del(k)
+		}
+	}
+	if err := s.indexSenders(ctx); err != nil {
+		t.Fatal(err)
+	}
+	storagetest.CheckSenders(t, s)
+}
@@ -0,0 +1,240 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+// snapshotData is the encoding of a snapshot: every key except the leases',
+// without the storage's prefix, so that it can be restored under another.
+type snapshotData struct {
+	Keys []snapshotKey
+}
+
+type snapshotKey struct {
+	Key  string
+	Type string
+
+	// Values are a hash's fields and values, a list's, set's or string's
+	// values, or a sorted set's members and scores.
+	Values [][]byte `json:",omitempty"`
+
+	// Entries are a stream's entries.
+	Entries []streamEntry `json:",omitempty"`
+}
+
+type streamEntry struct {
+	ID     string
+	Fields [][]byte
+}
+
+// dumpScript returns the type and contents of every key matching the
+// pattern in ARGV[1], other than those starting with ARGV[2].
+var dumpScript = redis.NewScript(0, `
+local out = {}
+for _, k in ipairs(redis.call('KEYS', ARGV[1])) do
+	if string.sub(k, 1, #ARGV[2]) ~= ARGV[2] then
+		local t = redis.call('TYPE', k)['ok']
+		local v
+		if t == 'hash' then
+			v = redis.call('HGETALL', k)
+		elseif t == 'list' then
+			v = redis.call('LRANGE', k, 0, -1)
+		elseif t == 'set' then
+			v = redis.call('SMEMBERS', k)
+		elseif t == 'zset' then
+			v = redis.call('ZRANGE', k, 0, -1, 'WITHSCORES')
+		elseif t == 'string' then
+			v = {redis.call('GET', k)}
+		elseif t == 'stream' then
+			v = redis.call('XRANGE', k, '-', '+')
+		end
+		table.insert(out, k)
+		table.insert(out, t)
+		table.insert(out, v)
+	end
+end
+return out
+`)
+
+// deleteScript deletes every key matching the pattern in ARGV[1], other
+// than those starting with ARGV[2]. It's run with EVAL so that it can be
+// queued in a transaction.
+const deleteScript = `
+for _, k in ipairs(redis.call('KEYS', ARGV[1])) do
+	if string.sub(k, 1, #ARGV[2]) ~= ARGV[2] then
+		redis.call('DEL', k)
+	end
+end
+return 0
+`
+
+// pattern matches the storage's keys.
+func (s *Storage) pattern() string {
+	var b strings.Builder
+	for _, r := range s.prefix {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('*')
+	return b.String()
+}
+
+// Snapshot copies the state in a single script, which blocks the server
+// while the keys are read.
+func (s *Storage) Snapshot(ctx context.Context) (*storage.Snapshot, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	reply, err := redis.Values(dumpScript.Do(conn, s.pattern(), s.key("lease", "")))
+	if err != nil {
+		return nil, err
+	}
+	if len(reply)%3 != 0 {
+		return nil, fmt.Errorf("invalid snapshot reply of %d values", len(reply))
+	}
+
+	var d snapshotData
+	var res storage.Snapshot
+	for i := 0; i < len(reply); i += 3 {
+		k, err := redis.String(reply[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		sk := snapshotKey{Key: strings.TrimPrefix(k, s.prefix)}
+		if sk.Type, err = redis.String(reply[i+1], nil); err != nil {
+			return nil, err
+		}
+
+		switch sk.Type {
+		case "stream":
+			entries, err := redis.Values(reply[i+2], nil)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				entry, err := redis.Values(e, nil)
+				if err != nil || len(entry) != 2 {
+					return nil, fmt.Errorf("invalid stream entry in %s: %v", k, err)
+				}
+				var se streamEntry
+				if se.ID, err = redis.String(entry[0], nil); err != nil {
+					return nil, err
+				}
+				if se.Fields, err = redis.ByteSlices(entry[1], nil); err != nil {
+					return nil, err
+				}
+				sk.Entries = append(sk.Entries, se)
+			}
+			if strings.HasPrefix(sk.Key, "payments:") {
+				res.Payments += len(sk.Entries)
+			}
+		case "hash", "list", "set", "zset", "string":
+			if sk.Values, err = redis.ByteSlices(reply[i+2], nil); err != nil {
+				return nil, err
+			}
+			if sk.Type == "hash" && strings.HasPrefix(sk.Key, "channel:") {
+				res.Channels++
+			}
+			if sk.Key == "keypaths" {
+				for j := 0; j+1 < len(sk.Values); j += 2 {
+					if string(sk.Values[j]) == "counter" {
+						res.KeyPathCounter, err = strconv.Atoi(string(sk.Values[j+1]))
+						if err != nil {
+							return nil, err
+						}
+					}
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %s key %s", sk.Type, k)
+		}
+		d.Keys = append(d.Keys, sk)
+	}
+
+	if res.Data, err = json.Marshal(d); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Restore replaces the state in a single transaction, indexing the
+// restored channels by sender pubkey again rather than trusting the
+// snapshot's index, which older snapshots don't have.
+func (s *Storage) Restore(ctx context.Context, snap storage.Snapshot) error {
+	var d snapshotData
+	if err := json.Unmarshal(snap.Data, &d); err != nil {
+		return err
+	}
+
+	cmds := []cmd{command("EVAL", deleteScript, 0, s.pattern(), s.key("lease", ""))}
+	for _, sk := range d.Keys {
+		if strings.HasPrefix(sk.Key, "senders:") || sk.Key == "sender_index" {
+			continue
+		}
+		k := s.prefix + sk.Key
+		if sk.Type == "hash" && strings.HasPrefix(sk.Key, "channel:") {
+			c, err := s.senderCommand(strings.TrimPrefix(sk.Key, "channel:"), sk.Values)
+			if err != nil {
+				return err
+			}
+			cmds = append(cmds, c)
+		}
+		switch sk.Type {
+		case "hash":
+			cmds = append(cmds, command("HSET", redis.Args{k}.AddFlat(sk.Values)...))
+		case "list":
+			cmds = append(cmds, command("RPUSH", redis.Args{k}.AddFlat(sk.Values)...))
+		case "set":
+			cmds = append(cmds, command("SADD", redis.Args{k}.AddFlat(sk.Values)...))
+		case "zset":
+			args := redis.Args{k}
+			for j := 0; j+1 < len(sk.Values); j += 2 {
+				args = append(args, sk.Values[j+1], sk.Values[j])
+			}
+			cmds = append(cmds, command("ZADD", args...))
+		case "string":
+			cmds = append(cmds, command("SET", k, sk.Values[0]))
+		case "stream":
+			for _, e := range sk.Entries {
+				cmds = append(cmds, command("XADD", redis.Args{k, e.ID}.AddFlat(e.Fields)...))
+			}
+		default:
+			return fmt.Errorf("unexpected %s key %s", sk.Type, sk.Key)
+		}
+	}
+
+	cmds = append(cmds, command("SET", s.key("sender_index"), 1))
+
+	_, err := s.transact(ctx, nil, func(conn redis.Conn) ([]cmd, error) {
+		return cmds, nil
+	})
+	return err
+}
+
+// senderCommand returns the command that indexes the channel, given its
+// hash's fields and values, by sender pubkey.
+func (s *Storage) senderCommand(id string, values [][]byte) (cmd, error) {
+	var state channels.SharedState
+	for j := 0; j+1 < len(values); j += 2 {
+		if string(values[j]) == "state" {
+			if err := json.Unmarshal(values[j+1], &state); err != nil {
+				return cmd{}, err
+			}
+		}
+	}
+	return command("ZADD", s.senderKey(state.SenderPubKey), 0, id), nil
+}
@@ -0,0 +1,22 @@
+package storage
+
+import "time"
+
+// ScreeningRecord is the audit record of an address flagged by compliance
+// screening.
+type ScreeningRecord struct {
+	Time time.Time
+
+	// Call is create, open or rescreen.
+	Call string
+
+	// ChannelID is empty for channels flagged at create.
+	ChannelID string
+
+	Address  string
+	Provider string
+	Reason   string
+
+	// Frozen is set if the channel was frozen because of the hit.
+	Frozen bool
+}
@@ -0,0 +1,13 @@
+package storage
+
+// Snapshot is a consistent copy of a storage's entire state.
+type Snapshot struct {
+	// Data is the storage's own encoding of the state. Only the same kind
+	// of storage can restore it.
+	Data []byte
+
+	// Channels, Payments and KeyPathCounter summarize the state.
+	Channels       int
+	Payments       int
+	KeyPathCounter int
+}
@@ -0,0 +1,285 @@
+package sqlite
+
+import "github.com/luno/moonbeam/storage/migrate"
+
+// migrations upgrade the database's schema one version at a time. Released
+// migrations must never change; add a new one instead.
+//
+// Times are stored as Unix nanoseconds, or NULL if they're zero, so that
+// they compare correctly. Channel states are stored as JSON, with the fields
+// that are filtered on in their own columns.
+var migrations = []migrate.Migration{{
+	Version: 1,
+	Up: `
+	CREATE TABLE channels (
+		id               TEXT PRIMARY KEY,
+		key_path         INTEGER NOT NULL,
+		key_generation   INTEGER NOT NULL DEFAULT 0,
+		status           INTEGER NOT NULL,
+		sender_pubkey    BLOB,
+		state            TEXT NOT NULL,
+		frozen           BOOLEAN NOT NULL DEFAULT FALSE,
+		frozen_reason    TEXT NOT NULL DEFAULT '',
+		suspended        BOOLEAN NOT NULL DEFAULT FALSE,
+		suspended_reason TEXT NOT NULL DEFAULT '',
+		closure          TEXT,
+		created          INTEGER,
+		account          TEXT NOT NULL DEFAULT '',
+		labels           TEXT
+	);
+
+	-- seq is the payment's index in the channel, from zero.
+	CREATE TABLE payments (
+		channel_id TEXT NOT NULL REFERENCES channels (id),
+		seq        INTEGER NOT NULL,
+		payment    BLOB NOT NULL,
+		time       INTEGER,
+		PRIMARY KEY (channel_id, seq)
+	);
+	CREATE INDEX payments_time ON payments (time);
+
+	-- key_paths holds a single row with the key path counter and the
+	-- position of reuse within the gap limit.
+	CREATE TABLE key_paths (
+		counter INTEGER NOT NULL,
+		reuse   INTEGER NOT NULL
+	);
+	INSERT INTO key_paths (counter, reuse) VALUES (0, 0);
+
+	CREATE TABLE key_generations (
+		generation INTEGER PRIMARY KEY,
+		xpub       TEXT NOT NULL UNIQUE,
+		created    INTEGER
+	);
+	CREATE TABLE destinations (
+		xpub       TEXT PRIMARY KEY,
+		next_index INTEGER NOT NULL
+	);
+
+	-- settings holds receiver-wide values, such as the watch height.
+	CREATE TABLE settings (
+		name  TEXT PRIMARY KEY,
+		value INTEGER NOT NULL
+	);
+
+	CREATE TABLE revocations (
+		seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+		channel_id TEXT NOT NULL REFERENCES channels (id),
+		secret     BLOB NOT NULL
+	);
+	CREATE TABLE sent_payments (
+		channel_id TEXT NOT NULL REFERENCES channels (id),
+		payment_id TEXT NOT NULL,
+		payment    BLOB NOT NULL,
+		response   BLOB NOT NULL,
+		PRIMARY KEY (channel_id, payment_id)
+	);
+	CREATE TABLE pending (
+		funding_address TEXT PRIMARY KEY,
+		pending         TEXT NOT NULL
+	);
+	CREATE TABLE api_keys (
+		id      TEXT PRIMARY KEY,
+		hash    BLOB NOT NULL,
+		scopes  TEXT NOT NULL,
+		created INTEGER,
+		account TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE target_credits (
+		seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+		channel_id TEXT NOT NULL,
+		target     TEXT NOT NULL,
+		amount     INTEGER NOT NULL,
+		commission INTEGER NOT NULL,
+		time       INTEGER
+	);
+	CREATE INDEX target_credits_time ON target_credits (time);
+	CREATE TABLE target_balances (
+		target     TEXT PRIMARY KEY,
+		count      INTEGER NOT NULL,
+		gross      INTEGER NOT NULL,
+		commission INTEGER NOT NULL
+	);
+	CREATE TABLE daily_totals (
+		day        INTEGER NOT NULL,
+		target     TEXT NOT NULL,
+		count      INTEGER NOT NULL,
+		gross      INTEGER NOT NULL,
+		commission INTEGER NOT NULL,
+		PRIMARY KEY (day, target)
+	);
+
+	CREATE TABLE invoices (
+		id           TEXT PRIMARY KEY,
+		amount       INTEGER NOT NULL,
+		target       TEXT NOT NULL,
+		expiry       INTEGER,
+		created      INTEGER,
+		account      TEXT NOT NULL DEFAULT '',
+		paid_channel TEXT NOT NULL DEFAULT '',
+		paid_count   INTEGER NOT NULL DEFAULT 0,
+		paid_time    INTEGER
+	);
+
+	CREATE TABLE dead_letters (
+		seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+		url        TEXT NOT NULL,
+		event      BLOB NOT NULL,
+		attempts   INTEGER NOT NULL,
+		last_error TEXT NOT NULL,
+		time       INTEGER
+	);
+	CREATE TABLE outbox (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		type       TEXT NOT NULL,
+		channel_id TEXT NOT NULL,
+		event      BLOB NOT NULL,
+		time       INTEGER
+	);
+
+	CREATE TABLE leases (
+		id     TEXT PRIMARY KEY,
+		owner  TEXT NOT NULL,
+		token  INTEGER NOT NULL,
+		expiry INTEGER
+	);
+
+	CREATE TABLE access_entries (
+		seq     INTEGER PRIMARY KEY AUTOINCREMENT,
+		list    TEXT NOT NULL,
+		kind    TEXT NOT NULL,
+		value   TEXT NOT NULL,
+		note    TEXT NOT NULL,
+		created INTEGER,
+		UNIQUE (list, kind, value)
+	);
+	CREATE TABLE screening_records (
+		seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+		time       INTEGER,
+		call       TEXT NOT NULL,
+		channel_id TEXT NOT NULL,
+		address    TEXT NOT NULL,
+		provider   TEXT NOT NULL,
+		reason     TEXT NOT NULL,
+		frozen     BOOLEAN NOT NULL
+	);
+`,
+	Down: `
+	DROP TABLE screening_records;
+	DROP TABLE access_entries;
+	DROP TABLE leases;
+	DROP TABLE outbox;
+	DROP TABLE dead_letters;
+	DROP TABLE invoices;
+	DROP TABLE daily_totals;
+	DROP TABLE target_balances;
+	DROP TABLE target_credits;
+	DROP TABLE api_keys;
+	DROP TABLE pending;
+	DROP TABLE sent_payments;
+	DROP TABLE revocations;
+	DROP TABLE settings;
+	DROP TABLE destinations;
+	DROP TABLE key_generations;
+	DROP TABLE key_paths;
+	DROP TABLE payments;
+	DROP TABLE channels;
+`,
+}, {
+	Version: 2,
+	Up: `
+	-- version is incremented by every update of the state, from one.
+	ALTER TABLE channels ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+`,
+	// This version of SQLite can't drop columns, so the table is rebuilt,
+	// with the foreign keys checked once it's back.
+	Down: `
+	PRAGMA defer_foreign_keys = ON;
+	CREATE TABLE channels_v1 (
+		id               TEXT PRIMARY KEY,
+		key_path         INTEGER NOT NULL,
+		key_generation   INTEGER NOT NULL DEFAULT 0,
+		status           INTEGER NOT NULL,
+		sender_pubkey    BLOB,
+		state            TEXT NOT NULL,
+		frozen           BOOLEAN NOT NULL DEFAULT FALSE,
+		frozen_reason    TEXT NOT NULL DEFAULT '',
+		suspended        BOOLEAN NOT NULL DEFAULT FALSE,
+		suspended_reason TEXT NOT NULL DEFAULT '',
+		closure          TEXT,
+		created          INTEGER,
+		account          TEXT NOT NULL DEFAULT '',
+		labels           TEXT
+	);
+	INSERT INTO channels_v1 SELECT id, key_path, key_generation, status,
+		sender_pubkey, state, frozen, frozen_reason, suspended,
+		suspended_reason, closure, created, account, labels FROM channels;
+	DROP TABLE channels;
+	ALTER TABLE channels_v1 RENAME TO channels;
+`,
+}, {
+	Version: 3,
+	Up: `
+	CREATE INDEX channels_sender_pubkey ON channels (sender_pubkey);
+`,
+	Down: `
+	DROP INDEX channels_sender_pubkey;
+`,
+}, {
+	Version: 4,
+	Up: `
+	-- archived is the location of the batch the channel's payments were
+	-- moved to, or empty.
+	ALTER TABLE channels ADD COLUMN archived TEXT NOT NULL DEFAULT '';
+`,
+	Down: `
+	PRAGMA defer_foreign_keys = ON;
+	CREATE TABLE channels_v3 (
+		id               TEXT PRIMARY KEY,
+		key_path         INTEGER NOT NULL,
+		key_generation   INTEGER NOT NULL DEFAULT 0,
+		status           INTEGER NOT NULL,
+		sender_pubkey    BLOB,
+		state            TEXT NOT NULL,
+		frozen           BOOLEAN NOT NULL DEFAULT FALSE,
+		frozen_reason    TEXT NOT NULL DEFAULT '',
+		suspended        BOOLEAN NOT NULL DEFAULT FALSE,
+		suspended_reason TEXT NOT NULL DEFAULT '',
+		closure          TEXT,
+		created          INTEGER,
+		account          TEXT NOT NULL DEFAULT '',
+		labels           TEXT,
+		version          INTEGER NOT NULL DEFAULT 0
+	);
+	INSERT INTO channels_v3 SELECT id, key_path, key_generation, status,
+		sender_pubkey, state, frozen, frozen_reason, suspended,
+		suspended_reason, closure, created, account, labels, version
+		FROM channels;
+	DROP TABLE channels;
+	ALTER TABLE channels_v3 RENAME TO channels;
+	CREATE INDEX channels_sender_pubkey ON channels (sender_pubkey);
+`,
+}}
+
+// stateTables are the tables replaced by Restore, in the order their rows
+// are deleted. Leases are kept so that fencing tokens never go backwards.
+var stateTables = []string{
+	"payments",
+	"revocations",
+	"sent_payments",
+	"channels",
+	"key_generations",
+	"destinations",
+	"settings",
+	"pending",
+	"api_keys",
+	"target_credits",
+	"target_balances",
+	"daily_totals",
+	"invoices",
+	"dead_letters",
+	"outbox",
+	"access_entries",
+	"screening_records",
+}
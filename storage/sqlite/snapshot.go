@@ -0,0 +1,358 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/luno/moonbeam/storage"
+)
+
+// snapshotData is the encoding of a snapshot's state. Payments are ordered
+// by channel and then by their index in the channel.
+type snapshotData struct {
+	KeyPathCounter int
+	KeyPathReuse   int
+	KeyGenerations []storage.KeyGeneration
+	Destinations   map[string]uint32
+	Channels       []storage.Record
+	Payments       []storage.StoredPayment
+	Revocations    map[string][][]byte
+	SentPayments   map[string][]storage.SentPayment
+	Pending        []storage.Pending
+	APIKeys        []storage.APIKey
+	Credits        []storage.TargetCredit
+	Balances       []storage.TargetBalance
+	Daily          []storage.DailyTotal
+	Invoices       []storage.Invoice
+	DeadLetters    []storage.DeadLetter
+	WatchHeight    int64
+	Outbox         []storage.OutboxEvent
+	Access         []storage.AccessEntry
+	AllowlistOnly  bool
+	Screening      []storage.ScreeningRecord
+}
+
+// Snapshot reads the state in a single transaction, which blocks updates
+// while it's copied.
+func (s *Storage) Snapshot(ctx context.Context) (*storage.Snapshot, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	d, err := readSnapshot(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.Snapshot{
+		Data:           buf,
+		Channels:       len(d.Channels),
+		Payments:       len(d.Payments),
+		KeyPathCounter: d.KeyPathCounter,
+	}, nil
+}
+
+func readSnapshot(ctx context.Context, tx *sql.Tx) (*snapshotData, error) {
+	var d snapshotData
+	var err error
+
+	err = tx.QueryRowContext(ctx, `SELECT counter, reuse FROM key_paths`).
+		Scan(&d.KeyPathCounter, &d.KeyPathReuse)
+	if err != nil {
+		return nil, err
+	}
+	if d.WatchHeight, err = getSetting(ctx, tx, settingWatchHeight); err != nil {
+		return nil, err
+	}
+	allowlistOnly, err := getSetting(ctx, tx, settingAllowlistOnly)
+	if err != nil {
+		return nil, err
+	}
+	d.AllowlistOnly = allowlistOnly != 0
+
+	if d.KeyGenerations, err = listKeyGenerations(ctx, tx); err != nil {
+		return nil, err
+	}
+	if d.Channels, err = listChannels(ctx, tx, `ORDER BY id`); err != nil {
+		return nil, err
+	}
+	if d.Payments, err = listPayments(ctx, tx, `ORDER BY channel_id, seq`); err != nil {
+		return nil, err
+	}
+	if d.Pending, err = listPending(ctx, tx); err != nil {
+		return nil, err
+	}
+	if d.Credits, err = listTargetCredits(ctx, tx, time.Time{}, time.Time{}); err != nil {
+		return nil, err
+	}
+	if d.Balances, err = listTargetBalances(ctx, tx); err != nil {
+		return nil, err
+	}
+	if d.Daily, err = listDailyTotals(ctx, tx, "", time.Time{}, time.Time{}); err != nil {
+		return nil, err
+	}
+	if d.DeadLetters, err = listDeadLetters(ctx, tx); err != nil {
+		return nil, err
+	}
+	if d.Outbox, err = listOutbox(ctx, tx, 0); err != nil {
+		return nil, err
+	}
+	if d.Access, err = listAccessEntries(ctx, tx); err != nil {
+		return nil, err
+	}
+	if d.Screening, err = listScreeningRecords(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	err = queryRows(ctx, tx, `SELECT xpub, next_index FROM destinations`,
+		func(rows *sql.Rows) error {
+			var xpub string
+			var next int64
+			if err := rows.Scan(&xpub, &next); err != nil {
+				return err
+			}
+			if d.Destinations == nil {
+				d.Destinations = make(map[string]uint32)
+			}
+			d.Destinations[xpub] = uint32(next)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(ctx, tx, `SELECT channel_id, secret FROM revocations
+		ORDER BY channel_id, seq`, func(rows *sql.Rows) error {
+		var id string
+		var secret []byte
+		if err := rows.Scan(&id, &secret); err != nil {
+			return err
+		}
+		if d.Revocations == nil {
+			d.Revocations = make(map[string][][]byte)
+		}
+		d.Revocations[id] = append(d.Revocations[id], secret)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(ctx, tx, `SELECT channel_id, payment_id, payment, response
+		FROM sent_payments ORDER BY channel_id, payment_id`,
+		func(rows *sql.Rows) error {
+			var id string
+			var p storage.SentPayment
+			if err := rows.Scan(&id, &p.PaymentID, &p.Payment, &p.Response); err != nil {
+				return err
+			}
+			if d.SentPayments == nil {
+				d.SentPayments = make(map[string][]storage.SentPayment)
+			}
+			d.SentPayments[id] = append(d.SentPayments[id], p)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(ctx, tx, `SELECT `+apiKeyColumns+` FROM api_keys
+		ORDER BY id`, func(rows *sql.Rows) error {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return err
+		}
+		d.APIKeys = append(d.APIKeys, *k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(ctx, tx, `SELECT `+invoiceColumns+` FROM invoices
+		ORDER BY id`, func(rows *sql.Rows) error {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return err
+		}
+		d.Invoices = append(d.Invoices, *inv)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// queryRows calls fn for each row returned by the query.
+func queryRows(ctx context.Context, q querier, query string, fn func(rows *sql.Rows) error) error {
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *Storage) Restore(ctx context.Context, snap storage.Snapshot) error {
+	var d snapshotData
+	if err := json.Unmarshal(snap.Data, &d); err != nil {
+		return err
+	}
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		for _, table := range stateTables {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table); err != nil {
+				return err
+			}
+		}
+		return writeSnapshot(ctx, tx, &d)
+	})
+}
+
+func writeSnapshot(ctx context.Context, tx *sql.Tx, d *snapshotData) error {
+	exec := func(query string, args ...interface{}) error {
+		_, err := tx.ExecContext(ctx, query, args...)
+		return err
+	}
+
+	err := exec(`UPDATE key_paths SET counter = ?, reuse = ?`,
+		d.KeyPathCounter, d.KeyPathReuse)
+	if err != nil {
+		return err
+	}
+	if err := setSetting(ctx, tx, settingWatchHeight, d.WatchHeight); err != nil {
+		return err
+	}
+	var allowlistOnly int64
+	if d.AllowlistOnly {
+		allowlistOnly = 1
+	}
+	if err := setSetting(ctx, tx, settingAllowlistOnly, allowlistOnly); err != nil {
+		return err
+	}
+
+	for _, g := range d.KeyGenerations {
+		err := exec(`INSERT INTO key_generations (generation, xpub, created)
+			VALUES (?, ?, ?)`, g.Generation, g.XPub, timeArg(g.Created))
+		if err != nil {
+			return err
+		}
+	}
+	for xpub, next := range d.Destinations {
+		err := exec(`INSERT INTO destinations (xpub, next_index)
+			VALUES (?, ?)`, xpub, int64(next))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, rec := range d.Channels {
+		if err := insertChannel(ctx, tx, rec); err != nil {
+			return err
+		}
+	}
+	seqs := make(map[string]int)
+	for _, sp := range d.Payments {
+		err := exec(`INSERT INTO payments (channel_id, seq, payment, time)
+			VALUES (?, ?, ?, ?)`,
+			sp.ChannelID, seqs[sp.ChannelID], sp.Payment, timeArg(sp.Time))
+		if err != nil {
+			return err
+		}
+		seqs[sp.ChannelID]++
+	}
+	for id, secrets := range d.Revocations {
+		for _, secret := range secrets {
+			err := exec(`INSERT INTO revocations (channel_id, secret)
+				VALUES (?, ?)`, id, secret)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for id, ps := range d.SentPayments {
+		for _, p := range ps {
+			err := exec(`INSERT INTO sent_payments (channel_id, payment_id,
+				payment, response) VALUES (?, ?, ?, ?)`,
+				id, p.PaymentID, p.Payment, p.Response)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, p := range d.Pending {
+		if err := putPending(ctx, tx, p); err != nil {
+			return err
+		}
+	}
+	for _, k := range d.APIKeys {
+		if err := insertAPIKey(ctx, tx, k); err != nil {
+			return err
+		}
+	}
+	for _, c := range d.Credits {
+		if err := insertTargetCredit(ctx, tx, c); err != nil {
+			return err
+		}
+	}
+	for _, b := range d.Balances {
+		err := exec(`INSERT INTO target_balances (target, count, gross,
+			commission) VALUES (?, ?, ?, ?)`,
+			b.Target, b.Count, b.Gross, b.Commission)
+		if err != nil {
+			return err
+		}
+	}
+	for _, t := range d.Daily {
+		err := exec(`INSERT INTO daily_totals (day, target, count, gross,
+			commission) VALUES (?, ?, ?, ?, ?)`,
+			timeArg(t.Day), t.Target, t.Count, t.Gross, t.Commission)
+		if err != nil {
+			return err
+		}
+	}
+	for _, inv := range d.Invoices {
+		if err := insertInvoice(ctx, tx, inv); err != nil {
+			return err
+		}
+	}
+	for _, dl := range d.DeadLetters {
+		if err := insertDeadLetter(ctx, tx, dl); err != nil {
+			return err
+		}
+	}
+	for _, e := range d.Outbox {
+		err := exec(`INSERT INTO outbox (id, type, channel_id, event, time)
+			VALUES (?, ?, ?, ?, ?)`,
+			e.ID, e.Type, e.ChannelID, e.Event, timeArg(e.Time))
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range d.Access {
+		if err := putAccessEntry(ctx, tx, e); err != nil {
+			return err
+		}
+	}
+	for _, rec := range d.Screening {
+		if err := insertScreeningRecord(ctx, tx, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,1228 @@
+// Package sqlite implements storage.Storage on an SQLite database file, for
+// single-node deployments and tests that don't need an external database.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/migrate"
+)
+
+// Storage is a storage.Storage backed by SQLite in WAL mode. Every
+// transaction takes the database's write lock when it begins, so Update's
+// compare-and-swap holds across connections and processes using the same
+// file, while reads outside of transactions proceed concurrently.
+type Storage struct {
+	db *sql.DB
+}
+
+// Open opens the database file at path, creating it if it doesn't exist, and
+// migrates it to the current schema.
+func Open(ctx context.Context, path string) (*Storage, error) {
+	s, err := OpenUnmigrated(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Migrate(ctx); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenUnmigrated opens the database file at path without migrating it.
+// Use CheckSchema before using it as a storage.
+func OpenUnmigrated(ctx context.Context, path string) (*Storage, error) {
+	dsn := "file:" + path + "?_journal_mode=WAL&_busy_timeout=10000" +
+		"&_txlock=immediate&_foreign_keys=1&_synchronous=FULL"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{db: db}, nil
+}
+
+// Migrate applies the migrations that haven't been applied yet.
+func (s *Storage) Migrate(ctx context.Context) error {
+	return s.MigrateTo(ctx, migrate.Latest(migrations))
+}
+
+// MigrateTo migrates the schema up or down to the version, in a single
+// transaction.
+func (s *Storage) MigrateTo(ctx context.Context, version int) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		if err := adoptUserVersion(ctx, tx); err != nil {
+			return err
+		}
+		return migrate.To(ctx, tx, migrations, version)
+	})
+}
+
+// adoptUserVersion records the schema version of a database created before
+// versions were recorded in schema_version, which kept it in PRAGMA
+// user_version.
+func adoptUserVersion(ctx context.Context, tx *sql.Tx) error {
+	var version int
+	err := tx.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version)
+	if err != nil || version == 0 {
+		return err
+	}
+	if err := migrate.Init(ctx, tx); err != nil {
+		return err
+	}
+	for v := 1; v <= version; v++ {
+		_, err := tx.ExecContext(ctx,
+			`INSERT OR IGNORE INTO schema_version (version) VALUES (?)`, v)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `PRAGMA user_version = 0`)
+	return err
+}
+
+// SchemaVersion returns the database's schema version and the latest one.
+func (s *Storage) SchemaVersion(ctx context.Context) (int, int, error) {
+	var version int
+	err := s.tx(ctx, func(tx *sql.Tx) error {
+		if err := adoptUserVersion(ctx, tx); err != nil {
+			return err
+		}
+		if err := migrate.Init(ctx, tx); err != nil {
+			return err
+		}
+		var err error
+		version, err = migrate.Version(ctx, tx)
+		return err
+	})
+	return version, migrate.Latest(migrations), err
+}
+
+// CheckSchema returns an error if the schema isn't at the latest version.
+func (s *Storage) CheckSchema(ctx context.Context) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		return migrate.Check(ctx, tx, migrations)
+	})
+}
+
+// Close closes the database.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// tx runs fn in a transaction holding the write lock.
+func (s *Storage) tx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// isConstraint reports whether err is a violation of the constraint.
+func isConstraint(err error, code sqlite3.ErrNoExtended) bool {
+	var se sqlite3.Error
+	return errors.As(err, &se) && se.ExtendedCode == code
+}
+
+func isUniqueViolation(err error) bool {
+	return isConstraint(err, sqlite3.ErrConstraintUnique) ||
+		isConstraint(err, sqlite3.ErrConstraintPrimaryKey)
+}
+
+// checkFound returns ErrNotFound if the statement didn't affect any rows.
+func checkFound(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// timeArg encodes t as Unix nanoseconds, or NULL if it's zero.
+func timeArg(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UnixNano()
+}
+
+// scanTime decodes a time encoded by timeArg.
+type scanTime struct {
+	t *time.Time
+}
+
+func (s scanTime) Scan(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		*s.t = time.Time{}
+	case int64:
+		*s.t = time.Unix(0, v)
+	default:
+		return fmt.Errorf("invalid time %T", v)
+	}
+	return nil
+}
+
+const channelColumns = `id, key_path, key_generation, state, version,
+	frozen, frozen_reason, suspended, suspended_reason, closure, created,
+	account, labels, archived`
+
+func scanChannel(row interface{ Scan(...interface{}) error }) (*storage.Record, error) {
+	var rec storage.Record
+	var state string
+	var closure, labels sql.NullString
+	err := row.Scan(&rec.ID, &rec.KeyPath, &rec.KeyGeneration, &state,
+		&rec.Version, &rec.Frozen, &rec.FrozenReason, &rec.Suspended,
+		&rec.SuspendedReason, &closure, scanTime{&rec.Created}, &rec.Account, &labels,
+		&rec.Archived)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(state), &rec.SharedState); err != nil {
+		return nil, err
+	}
+	if closure.Valid {
+		if err := json.Unmarshal([]byte(closure.String), &rec.Closure); err != nil {
+			return nil, err
+		}
+	}
+	if labels.Valid {
+		if err := json.Unmarshal([]byte(labels.String), &rec.Labels); err != nil {
+			return nil, err
+		}
+	}
+	return &rec, nil
+}
+
+func listChannels(ctx context.Context, q querier, where string, args ...interface{}) ([]storage.Record, error) {
+	rows, err := q.QueryContext(ctx, `SELECT `+channelColumns+` FROM channels `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sl []storage.Record
+	for rows.Next() {
+		rec, err := scanChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		sl = append(sl, *rec)
+	}
+	return sl, rows.Err()
+}
+
+func (s *Storage) Get(ctx context.Context, id string) (*storage.Record, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+channelColumns+` FROM channels WHERE id = ?`, id)
+	rec, err := scanChannel(row)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	return rec, err
+}
+
+func (s *Storage) List(ctx context.Context) ([]storage.Record, error) {
+	return listChannels(ctx, s.db, `ORDER BY id`)
+}
+
+func (s *Storage) GetBySenderPubKey(ctx context.Context, senderPubKey []byte) ([]storage.Record, error) {
+	return listChannels(ctx, s.db, `WHERE sender_pubkey = ? ORDER BY id`, senderPubKey)
+}
+
+// ListPage filters the channels in SQL, except by labels, which this build
+// of SQLite can't query in JSON, so they're matched a batch at a time.
+func (s *Storage) ListPage(ctx context.Context, f storage.ListFilter, cursor string, limit int) ([]storage.Record, string, error) {
+	conds := []string{"TRUE"}
+	var args []interface{}
+	add := func(cond string, a ...interface{}) {
+		conds = append(conds, cond)
+		args = append(args, a...)
+	}
+	if f.Status != 0 {
+		add("status = ?", int(f.Status))
+	}
+	if len(f.SenderPubKey) > 0 {
+		add("sender_pubkey = ?", f.SenderPubKey)
+	}
+	if !f.CreatedAfter.IsZero() {
+		add("created > ?", timeArg(f.CreatedAfter))
+	}
+	if !f.CreatedBefore.IsZero() {
+		add("created < ?", timeArg(f.CreatedBefore))
+	}
+	if f.ByAccount {
+		add("account = ?", f.Account)
+	}
+	where := "WHERE " + strings.Join(conds, " AND ") + " AND id > ? ORDER BY id"
+	if limit > 0 {
+		where += fmt.Sprintf(" LIMIT %d", limit+1)
+	}
+
+	sl := []storage.Record{}
+	for {
+		recs, err := listChannels(ctx, s.db, where, append(args, cursor)...)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, rec := range recs {
+			if !f.Match(rec) {
+				continue
+			}
+			if limit > 0 && len(sl) == limit {
+				return sl, sl[limit-1].ID, nil
+			}
+			sl = append(sl, rec)
+		}
+		if limit == 0 || len(recs) <= limit {
+			return sl, "", nil
+		}
+		cursor = recs[len(recs)-1].ID
+	}
+}
+
+func (s *Storage) Create(ctx context.Context, rec storage.Record) error {
+	if rec.ID == "" {
+		return errors.New("invalid id")
+	}
+	rec.Version = 1
+	err := insertChannel(ctx, s.db, rec)
+	if isUniqueViolation(err) {
+		return errors.New("record already exists")
+	}
+	return err
+}
+
+func insertChannel(ctx context.Context, q querier, rec storage.Record) error {
+	state, err := json.Marshal(rec.SharedState)
+	if err != nil {
+		return err
+	}
+	closure, err := json.Marshal(rec.Closure)
+	if err != nil {
+		return err
+	}
+	var labels sql.NullString
+	if len(rec.Labels) > 0 {
+		buf, err := json.Marshal(rec.Labels)
+		if err != nil {
+			return err
+		}
+		labels = sql.NullString{String: string(buf), Valid: true}
+	}
+	_, err = q.ExecContext(ctx, `INSERT INTO channels (`+channelColumns+`,
+		status, sender_pubkey) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.ID, rec.KeyPath, rec.KeyGeneration, string(state), rec.Version, rec.Frozen,
+		rec.FrozenReason, rec.Suspended, rec.SuspendedReason, string(closure),
+		timeArg(rec.Created), rec.Account, labels, rec.Archived,
+		int(rec.SharedState.Status), rec.SharedState.SenderPubKey)
+	return err
+}
+
+func (s *Storage) Update(ctx context.Context, id string, version int64, new channels.SharedState, payment []byte) error {
+	return s.Commit(ctx, storage.Tx{ChannelID: id, Version: version, State: new, Payment: payment})
+}
+
+func (s *Storage) Commit(ctx context.Context, t storage.Tx) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		if t.InvoiceID != "" {
+			var paid string
+			err := tx.QueryRowContext(ctx, `SELECT paid_channel FROM invoices
+				WHERE id = ?`, t.InvoiceID).Scan(&paid)
+			if err == sql.ErrNoRows {
+				return storage.ErrNotFound
+			} else if err != nil {
+				return err
+			}
+			if paid != "" {
+				return storage.ErrInvoicePaid
+			}
+		}
+
+		if err := update(ctx, tx, t.ChannelID, t.Version, t.State, t.Payment); err != nil {
+			return err
+		}
+
+		if t.InvoiceID != "" {
+			_, err := tx.ExecContext(ctx, `UPDATE invoices SET paid_channel = ?,
+				paid_count = ?, paid_time = ? WHERE id = ?`,
+				t.ChannelID, t.State.Count, timeArg(time.Now()), t.InvoiceID)
+			if err != nil {
+				return err
+			}
+		}
+		if t.Credit != nil {
+			return addTargetCredit(ctx, tx, *t.Credit)
+		}
+		return nil
+	})
+}
+
+// update stores the channel's new state if its version is still version,
+// and the payment, if any.
+func update(ctx context.Context, tx *sql.Tx, id string, version int64, new channels.SharedState, payment []byte) error {
+	var stored int64
+	err := tx.QueryRowContext(ctx, `SELECT version FROM channels WHERE id = ?`, id).Scan(&stored)
+	if err == sql.ErrNoRows {
+		return storage.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if token, ok := storage.FenceToken(ctx, id); ok {
+		var cur int64
+		err := tx.QueryRowContext(ctx, `SELECT token FROM leases WHERE id = ?`, id).Scan(&cur)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if token < cur {
+			return storage.ErrFenced
+		}
+	}
+
+	if stored != version {
+		return storage.ErrConflict
+	}
+
+	state, err := json.Marshal(new)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE channels SET state = ?, status = ?,
+		sender_pubkey = ?, version = version + 1 WHERE id = ?`,
+		string(state), int(new.Status), new.SenderPubKey, id)
+	if err != nil {
+		return err
+	}
+	if payment == nil {
+		return nil
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO payments (channel_id, seq,
+		payment, time) SELECT ?1, COUNT(*), ?2, ?3 FROM payments
+		WHERE channel_id = ?1`, id, payment, timeArg(time.Now()))
+	return err
+}
+
+func (s *Storage) ReserveKeyPath(ctx context.Context, gapLimit int) (int, error) {
+	var path int
+	err := s.tx(ctx, func(tx *sql.Tx) error {
+		var counter, reuse int
+		err := tx.QueryRowContext(ctx, `SELECT counter, reuse FROM key_paths`).
+			Scan(&counter, &reuse)
+		if err != nil {
+			return err
+		}
+		var used int
+		err = tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(key_path), 0)
+			FROM channels`).Scan(&used)
+		if err != nil {
+			return err
+		}
+
+		if gapLimit > 0 && counter-used >= gapLimit {
+			reuse = reuse%gapLimit + 1
+			path = used + reuse
+		} else {
+			counter++
+			path = counter
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE key_paths SET counter = ?,
+			reuse = ?`, counter, reuse)
+		return err
+	})
+	return path, err
+}
+
+func (s *Storage) GetKeyPathCounter(ctx context.Context) (int, error) {
+	var counter int
+	err := s.db.QueryRowContext(ctx, `SELECT counter FROM key_paths`).Scan(&counter)
+	return counter, err
+}
+
+func listKeyGenerations(ctx context.Context, q querier) ([]storage.KeyGeneration, error) {
+	rows, err := q.QueryContext(ctx, `SELECT generation, xpub, created
+		FROM key_generations ORDER BY generation`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sl []storage.KeyGeneration
+	for rows.Next() {
+		var g storage.KeyGeneration
+		if err := rows.Scan(&g.Generation, &g.XPub, scanTime{&g.Created}); err != nil {
+			return nil, err
+		}
+		sl = append(sl, g)
+	}
+	return sl, rows.Err()
+}
+
+func (s *Storage) ListKeyGenerations(ctx context.Context) ([]storage.KeyGeneration, error) {
+	return listKeyGenerations(ctx, s.db)
+}
+
+func (s *Storage) AddKeyGeneration(ctx context.Context, xpub string) (storage.KeyGeneration, error) {
+	g := storage.KeyGeneration{XPub: xpub, Created: time.Now()}
+	err := s.tx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM key_generations`).Scan(&g.Generation)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO key_generations (generation,
+			xpub, created) VALUES (?, ?, ?)`,
+			g.Generation, g.XPub, timeArg(g.Created))
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicateKey
+		}
+		return err
+	})
+	if err != nil {
+		return storage.KeyGeneration{}, err
+	}
+	return g, nil
+}
+
+func (s *Storage) ReserveDestinationIndex(ctx context.Context, xpub string) (uint32, error) {
+	var next int64
+	err := s.tx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO destinations (xpub,
+			next_index) VALUES (?, 1) ON CONFLICT (xpub) DO UPDATE
+			SET next_index = next_index + 1`, xpub)
+		if err != nil {
+			return err
+		}
+		return tx.QueryRowContext(ctx, `SELECT next_index FROM destinations
+			WHERE xpub = ?`, xpub).Scan(&next)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uint32(next - 1), nil
+}
+
+func listPayments(ctx context.Context, q querier, where string, args ...interface{}) ([]storage.StoredPayment, error) {
+	rows, err := q.QueryContext(ctx, `SELECT channel_id, payment, time
+		FROM payments `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sl []storage.StoredPayment
+	for rows.Next() {
+		var sp storage.StoredPayment
+		if err := rows.Scan(&sp.ChannelID, &sp.Payment, scanTime{&sp.Time}); err != nil {
+			return nil, err
+		}
+		sl = append(sl, sp)
+	}
+	return sl, rows.Err()
+}
+
+func (s *Storage) ListPayments(ctx context.Context, channelID string) ([][]byte, error) {
+	sps, err := s.ListChannelPayments(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	var sl [][]byte
+	for _, sp := range sps {
+		sl = append(sl, sp.Payment)
+	}
+	return sl, nil
+}
+
+func (s *Storage) ListChannelPayments(ctx context.Context, channelID string) ([]storage.StoredPayment, error) {
+	return listPayments(ctx, s.db, `WHERE channel_id = ? ORDER BY seq`, channelID)
+}
+
+func (s *Storage) ListPaymentsPage(ctx context.Context, f storage.PaymentFilter, cursor string, limit int) ([]storage.StoredPayment, string, error) {
+	conds := []string{"TRUE"}
+	var args []interface{}
+	add := func(cond string, a ...interface{}) {
+		conds = append(conds, cond)
+		args = append(args, a...)
+	}
+	if cursor != "" {
+		id, pos, err := storage.ParsePaymentCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		seq, err := strconv.ParseInt(pos, 10, 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid payment cursor %q", cursor)
+		}
+		add("(channel_id, seq) > (?, ?)", id, seq)
+	}
+	if f.ChannelID != "" {
+		add("channel_id = ?", f.ChannelID)
+	}
+	// Payments stored without times match neither bound, since comparisons
+	// with NULL are false.
+	if !f.From.IsZero() {
+		add("time >= ?", timeArg(f.From))
+	}
+	if !f.To.IsZero() {
+		add("time < ?", timeArg(f.To))
+	}
+	query := `SELECT channel_id, seq, payment, time FROM payments
+		WHERE ` + strings.Join(conds, " AND ") + ` ORDER BY channel_id, seq`
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit+1)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	sl := []storage.StoredPayment{}
+	var next string
+	for rows.Next() {
+		var sp storage.StoredPayment
+		var seq int64
+		if err := rows.Scan(&sp.ChannelID, &seq, &sp.Payment, scanTime{&sp.Time}); err != nil {
+			return nil, "", err
+		}
+		if limit > 0 && len(sl) == limit {
+			return sl, next, rows.Close()
+		}
+		sl = append(sl, sp)
+		next = storage.PaymentCursor(sp.ChannelID, strconv.FormatInt(seq, 10))
+	}
+	return sl, "", rows.Err()
+}
+
+func (s *Storage) QueryPayments(ctx context.Context, from, to time.Time) ([]storage.StoredPayment, error) {
+	// Payments stored without times count as the zero time, as by the
+	// filesystem storage. NULLs sort first.
+	return listPayments(ctx, s.db, `WHERE (?1 IS NULL OR time >= ?1)
+		AND (?2 IS NULL OR time IS NULL OR time < ?2)
+		ORDER BY time, channel_id, seq`, timeArg(from), timeArg(to))
+}
+
+func (s *Storage) Freeze(ctx context.Context, id string, reason string) error {
+	return checkFound(s.db.ExecContext(ctx, `UPDATE channels SET frozen = TRUE,
+		frozen_reason = ? WHERE id = ?`, reason, id))
+}
+
+func (s *Storage) Suspend(ctx context.Context, id string, suspended bool, reason string) error {
+	return checkFound(s.db.ExecContext(ctx, `UPDATE channels SET suspended = ?,
+		suspended_reason = ? WHERE id = ?`, suspended, reason, id))
+}
+
+func (s *Storage) SetLabels(ctx context.Context, id string, labels map[string]string) error {
+	var l sql.NullString
+	if len(labels) > 0 {
+		buf, err := json.Marshal(labels)
+		if err != nil {
+			return err
+		}
+		l = sql.NullString{String: string(buf), Valid: true}
+	}
+	return checkFound(s.db.ExecContext(ctx, `UPDATE channels SET labels = ?
+		WHERE id = ?`, l, id))
+}
+
+func (s *Storage) SetClosure(ctx context.Context, id string, c storage.Closure) error {
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return checkFound(s.db.ExecContext(ctx, `UPDATE channels SET closure = ?
+		WHERE id = ?`, string(buf), id))
+}
+
+func getSetting(ctx context.Context, q querier, name string) (int64, error) {
+	var v int64
+	err := q.QueryRowContext(ctx, `SELECT value FROM settings WHERE name = ?`, name).Scan(&v)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return v, err
+}
+
+func setSetting(ctx context.Context, q querier, name string, v int64) error {
+	_, err := q.ExecContext(ctx, `INSERT INTO settings (name, value)
+		VALUES (?1, ?2) ON CONFLICT (name) DO UPDATE SET value = ?2`, name, v)
+	return err
+}
+
+const (
+	settingWatchHeight   = "watch_height"
+	settingAllowlistOnly = "allowlist_only"
+)
+
+func (s *Storage) GetWatchHeight(ctx context.Context) (int64, error) {
+	return getSetting(ctx, s.db, settingWatchHeight)
+}
+
+func (s *Storage) SetWatchHeight(ctx context.Context, height int64) error {
+	return setSetting(ctx, s.db, settingWatchHeight, height)
+}
+
+func (s *Storage) GetAllowlistOnly(ctx context.Context) (bool, error) {
+	v, err := getSetting(ctx, s.db, settingAllowlistOnly)
+	return v != 0, err
+}
+
+func (s *Storage) SetAllowlistOnly(ctx context.Context, on bool) error {
+	var v int64
+	if on {
+		v = 1
+	}
+	return setSetting(ctx, s.db, settingAllowlistOnly, v)
+}
+
+func (s *Storage) ArchiveChannel(ctx context.Context, id string, version int64, location string) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		var stored int64
+		err := tx.QueryRowContext(ctx, `SELECT version FROM channels WHERE id = ?`, id).Scan(&stored)
+		if err == sql.ErrNoRows {
+			return storage.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		if stored != version {
+			return storage.ErrConflict
+		}
+
+		for _, table := range []string{"payments", "revocations", "sent_payments"} {
+			_, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE channel_id = ?`, id)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE channels SET archived = ? WHERE id = ?`, location, id)
+		return err
+	})
+}
+
+func (s *Storage) AddRevocationSecret(ctx context.Context, channelID string, secret []byte) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO revocations (channel_id,
+		secret) VALUES (?, ?)`, channelID, secret)
+	if isConstraint(err, sqlite3.ErrConstraintForeignKey) {
+		return storage.ErrNotFound
+	}
+	return err
+}
+
+func (s *Storage) ListRevocationSecrets(ctx context.Context, channelID string) ([][]byte, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT secret FROM revocations
+		WHERE channel_id = ? ORDER BY seq`, channelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sl [][]byte
+	for rows.Next() {
+		var secret []byte
+		if err := rows.Scan(&secret); err != nil {
+			return nil, err
+		}
+		sl = append(sl, secret)
+	}
+	return sl, rows.Err()
+}
+
+func (s *Storage) AddSentPayment(ctx context.Context, channelID string, p storage.SentPayment) error {
+	// Like the filesystem storage, the first payment recorded with an ID
+	// is the one returned by GetSentPayment.
+	_, err := s.db.ExecContext(ctx, `INSERT INTO sent_payments (channel_id,
+		payment_id, payment, response) VALUES (?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`, channelID, p.PaymentID, p.Payment, p.Response)
+	if isConstraint(err, sqlite3.ErrConstraintForeignKey) {
+		return storage.ErrNotFound
+	}
+	return err
+}
+
+func (s *Storage) GetSentPayment(ctx context.Context, channelID string, paymentID string) (*storage.SentPayment, error) {
+	p := storage.SentPayment{PaymentID: paymentID}
+	err := s.db.QueryRowContext(ctx, `SELECT payment, response FROM
+		sent_payments WHERE channel_id = ? AND payment_id = ?`,
+		channelID, paymentID).Scan(&p.Payment, &p.Response)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *Storage) PutPending(ctx context.Context, p storage.Pending) error {
+	if p.FundingAddress == "" {
+		return errors.New("invalid funding address")
+	}
+	return putPending(ctx, s.db, p)
+}
+
+func putPending(ctx context.Context, q querier, p storage.Pending) error {
+	buf, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, `INSERT INTO pending (funding_address,
+		pending) VALUES (?1, ?2) ON CONFLICT (funding_address)
+		DO UPDATE SET pending = ?2`, p.FundingAddress, string(buf))
+	return err
+}
+
+func listPending(ctx context.Context, q querier) ([]storage.Pending, error) {
+	rows, err := q.QueryContext(ctx, `SELECT pending FROM pending
+		ORDER BY funding_address`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sl []storage.Pending
+	for rows.Next() {
+		var buf string
+		if err := rows.Scan(&buf); err != nil {
+			return nil, err
+		}
+		var p storage.Pending
+		if err := json.Unmarshal([]byte(buf), &p); err != nil {
+			return nil, err
+		}
+		sl = append(sl, p)
+	}
+	return sl, rows.Err()
+}
+
+func (s *Storage) ListPending(ctx context.Context) ([]storage.Pending, error) {
+	return listPending(ctx, s.db)
+}
+
+func (s *Storage) DeletePending(ctx context.Context, fundingAddress string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM pending
+		WHERE funding_address = ?`, fundingAddress)
+	return err
+}
+
+func (s *Storage) AddAPIKey(ctx context.Context, k storage.APIKey) error {
+	err := insertAPIKey(ctx, s.db, k)
+	if isUniqueViolation(err) {
+		return errors.New("api key already exists")
+	}
+	return err
+}
+
+func insertAPIKey(ctx context.Context, q querier, k storage.APIKey) error {
+	_, err := q.ExecContext(ctx, `INSERT INTO api_keys (id, hash, scopes,
+		created, account) VALUES (?, ?, ?, ?, ?)`,
+		k.ID, k.Hash, strings.Join(k.Scopes, ","), timeArg(k.Created), k.Account)
+	return err
+}
+
+const apiKeyColumns = `id, hash, scopes, created, account`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*storage.APIKey, error) {
+	var k storage.APIKey
+	var scopes string
+	err := row.Scan(&k.ID, &k.Hash, &scopes, scanTime{&k.Created}, &k.Account)
+	if err != nil {
+		return nil, err
+	}
+	if scopes != "" {
+		k.Scopes = strings.Split(scopes, ",")
+	}
+	return &k, nil
+}
+
+func (s *Storage) GetAPIKey(ctx context.Context, id string) (*storage.APIKey, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys
+		WHERE id = ?`, id)
+	k, err := scanAPIKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return k, err
+}
+
+func (s *Storage) DeleteAPIKey(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM api_keys WHERE id = ?`, id)
+	return err
+}
+
+func (s *Storage) AddTargetCredit(ctx context.Context, c storage.TargetCredit) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		return addTargetCredit(ctx, tx, c)
+	})
+}
+
+// addTargetCredit records the credit and adds it to its totals.
+func addTargetCredit(ctx context.Context, tx *sql.Tx, c storage.TargetCredit) error {
+	if err := insertTargetCredit(ctx, tx, c); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO target_balances (target,
+		count, gross, commission) VALUES (?1, 1, ?2, ?3)
+		ON CONFLICT (target) DO UPDATE SET count = count + 1,
+		gross = gross + ?2, commission = commission + ?3`,
+		c.Target, c.Amount, c.Commission)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO daily_totals (day, target,
+		count, gross, commission) VALUES (?1, ?2, 1, ?3, ?4)
+		ON CONFLICT (day, target) DO UPDATE SET count = count + 1,
+		gross = gross + ?3, commission = commission + ?4`,
+		timeArg(storage.Day(c.Time)), c.Target, c.Amount, c.Commission)
+	return err
+}
+
+func insertTargetCredit(ctx context.Context, q querier, c storage.TargetCredit) error {
+	_, err := q.ExecContext(ctx, `INSERT INTO target_credits (channel_id,
+		target, amount, commission, time) VALUES (?, ?, ?, ?, ?)`,
+		c.ChannelID, c.Target, c.Amount, c.Commission, timeArg(c.Time))
+	return err
+}
+
+func listTargetCredits(ctx context.Context, q querier, from, to time.Time) ([]storage.TargetCredit, error) {
+	rows, err := q.QueryContext(ctx, `SELECT channel_id, target, amount,
+		commission, time FROM target_credits
+		WHERE (?1 IS NULL OR time >= ?1) AND (?2 IS NULL OR time < ?2)
+		ORDER BY time, seq`, timeArg(from), timeArg(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sl []storage.TargetCredit
+	for rows.Next() {
+		var c storage.TargetCredit
+		err := rows.Scan(&c.ChannelID, &c.Target, &c.Amount, &c.Commission,
+			scanTime{&c.Time})
+		if err != nil {
+			return nil, err
+		}
+		sl = append(sl, c)
+	}
+	return sl, rows.Err()
+}
+
+func (s *Storage) ListTargetCredits(ctx context.Context, from, to time.Time) ([]storage.TargetCredit, error) {
+	return listTargetCredits(ctx, s.db, from, to)
+}
+
+func listTargetBalances(ctx context.Context, q querier) ([]storage.TargetBalance, error) {
+	rows, err := q.QueryContext(ctx, `SELECT target, count, gross, commission
+		FROM target_balances ORDER BY target`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sl []storage.TargetBalance
+	for rows.Next() {
+		var b storage.TargetBalance
+		if err := rows.Scan(&b.Target, &b.Count, &b.Gross, &b.Commission); err != nil {
+			return nil, err
+		}
+		sl = append(sl, b)
+	}
+	return sl, rows.Err()
+}
+
+func (s *Storage) ListTargetBalances(ctx context.Context) ([]storage.TargetBalance, error) {
+	return listTargetBalances(ctx, s.db)
+}
+
+func listDailyTotals(ctx context.Context, q querier, target string, from, to time.Time) ([]storage.DailyTotal, error) {
+	rows, err := q.QueryContext(ctx, `SELECT day, target, count, gross,
+		commission FROM daily_totals WHERE (?1 = '' OR target = ?1)
+		AND (?2 IS NULL OR day >= ?2) AND (?3 IS NULL OR day < ?3)
+		ORDER BY day, target`, target, timeArg(from), timeArg(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sl []storage.DailyTotal
+	for rows.Next() {
+		var t storage.DailyTotal
+		err := rows.Scan(scanTime{&t.Day}, &t.Target, &t.Count, &t.Gross,
+			&t.Commission)
+		if err != nil {
+			return nil, err
+		}
+		t.Day = t.Day.UTC()
+		sl = append(sl, t)
+	}
+	return sl, rows.Err()
+}
+
+func (s *Storage) ListDailyTotals(ctx context.Context, target string, from, to time.Time) ([]storage.DailyTotal, error) {
+	return listDailyTotals(ctx, s.db, target, from, to)
+}
+
+func (s *Storage) AddInvoice(ctx context.Context, inv storage.Invoice) error {
+	err := insertInvoice(ctx, s.db, inv)
+	if isUniqueViolation(err) {
+		return errors.New("invoice already exists")
+	}
+	return err
+}
+
+func insertInvoice(ctx context.Context, q querier, inv storage.Invoice) error {
+	_, err := q.ExecContext(ctx, `INSERT INTO invoices (`+invoiceColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		inv.ID, inv.Amount, inv.Target, timeArg(inv.Expiry),
+		timeArg(inv.Created), inv.Account, inv.PaidChannel, inv.PaidCount,
+		timeArg(inv.PaidTime))
+	return err
+}
+
+const invoiceColumns = `id, amount, target, expiry, created, account,
+	paid_channel, paid_count, paid_time`
+
+func scanInvoice(row interface{ Scan(...interface{}) error }) (*storage.Invoice, error) {
+	var inv storage.Invoice
+	err := row.Scan(&inv.ID, &inv.Amount, &inv.Target, scanTime{&inv.Expiry},
+		scanTime{&inv.Created}, &inv.Account, &inv.PaidChannel, &inv.PaidCount,
+		scanTime{&inv.PaidTime})
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (s *Storage) GetInvoice(ctx context.Context, id string) (*storage.Invoice, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+invoiceColumns+` FROM invoices
+		WHERE id = ?`, id)
+	inv, err := scanInvoice(row)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	return inv, err
+}
+
+func (s *Storage) AddDeadLetter(ctx context.Context, dl storage.DeadLetter) error {
+	return insertDeadLetter(ctx, s.db, dl)
+}
+
+func insertDeadLetter(ctx context.Context, q querier, dl storage.DeadLetter) error {
+	_, err := q.ExecContext(ctx, `INSERT INTO dead_letters (url, event,
+		attempts, last_error, time) VALUES (?, ?, ?, ?, ?)`,
+		dl.URL, dl.Event, dl.Attempts, dl.LastError, timeArg(dl.Time))
+	return err
+}
+
+func listDeadLetters(ctx context.Context, q querier) ([]storage.DeadLetter, error) {
+	rows, err := q.QueryContext(ctx, `SELECT url, event, attempts, last_error,
+		time FROM dead_letters ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sl []storage.DeadLetter
+	for rows.Next() {
+		var dl storage.DeadLetter
+		err := rows.Scan(&dl.URL, &dl.Event, &dl.Attempts, &dl.LastError,
+			scanTime{&dl.Time})
+		if err != nil {
+			return nil, err
+		}
+		sl = append(sl, dl)
+	}
+	return sl, rows.Err()
+}
+
+func (s *Storage) ListDeadLetters(ctx context.Context) ([]storage.DeadLetter, error) {
+	return listDeadLetters(ctx, s.db)
+}
+
+func (s *Storage) AddOutbox(ctx context.Context, e storage.OutboxEvent) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO outbox (type, channel_id,
+		event, time) VALUES (?, ?, ?, ?)`,
+		e.Type, e.ChannelID, e.Event, timeArg(e.Time))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func listOutbox(ctx context.Context, q querier, limit int) ([]storage.OutboxEvent, error) {
+	query := `SELECT id, type, channel_id, event, time FROM outbox ORDER BY id`
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sl []storage.OutboxEvent
+	for rows.Next() {
+		var e storage.OutboxEvent
+		err := rows.Scan(&e.ID, &e.Type, &e.ChannelID, &e.Event, scanTime{&e.Time})
+		if err != nil {
+			return nil, err
+		}
+		sl = append(sl, e)
+	}
+	return sl, rows.Err()
+}
+
+func (s *Storage) ListOutbox(ctx context.Context, limit int) ([]storage.OutboxEvent, error) {
+	return listOutbox(ctx, s.db, limit)
+}
+
+func (s *Storage) DeleteOutbox(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM outbox WHERE id = ?`, id)
+	return err
+}
+
+func (s *Storage) AcquireLease(ctx context.Context, id, owner string, ttl time.Duration) (int64, error) {
+	var token int64
+	err := s.tx(ctx, func(tx *sql.Tx) error {
+		var cur string
+		var expiry time.Time
+		err := tx.QueryRowContext(ctx, `SELECT owner, token, expiry FROM leases
+			WHERE id = ?`, id).Scan(&cur, &token, scanTime{&expiry})
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		now := time.Now()
+		if cur != owner && now.Before(expiry) {
+			return storage.ErrLeaseHeld
+		}
+		token++
+		_, err = tx.ExecContext(ctx, `INSERT INTO leases (id, owner, token,
+			expiry) VALUES (?1, ?2, ?3, ?4) ON CONFLICT (id) DO UPDATE
+			SET owner = ?2, token = ?3, expiry = ?4`,
+			id, owner, token, timeArg(now.Add(ttl)))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return token, nil
+}
+
+func (s *Storage) ReleaseLease(ctx context.Context, id, owner string, token int64) error {
+	// The token is kept so that later leases get higher tokens.
+	_, err := s.db.ExecContext(ctx, `UPDATE leases SET owner = '',
+		expiry = NULL WHERE id = ? AND owner = ? AND token = ?`,
+		id, owner, token)
+	return err
+}
+
+func (s *Storage) PutAccessEntry(ctx context.Context, e storage.AccessEntry) error {
+	return putAccessEntry(ctx, s.db, e)
+}
+
+func putAccessEntry(ctx context.Context, q querier, e storage.AccessEntry) error {
+	_, err := q.ExecContext(ctx, `INSERT INTO access_entries (list, kind,
+		value, note, created) VALUES (?1, ?2, ?3, ?4, ?5)
+		ON CONFLICT (list, kind, value) DO UPDATE SET note = ?4, created = ?5`,
+		string(e.List), string(e.Kind), e.Value, e.Note, timeArg(e.Created))
+	return err
+}
+
+func (s *Storage) DeleteAccessEntry(ctx context.Context, list storage.AccessList, kind storage.AccessKind, value string) error {
+	return checkFound(s.db.ExecContext(ctx, `DELETE FROM access_entries
+		WHERE list = ? AND kind = ? AND value = ?`,
+		string(list), string(kind), value))
+}
+
+func listAccessEntries(ctx context.Context, q querier) ([]storage.AccessEntry, error) {
+	rows, err := q.QueryContext(ctx, `SELECT list, kind, value, note, created
+		FROM access_entries ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sl []storage.AccessEntry
+	for rows.Next() {
+		var e storage.AccessEntry
+		var list, kind string
+		err := rows.Scan(&list, &kind, &e.Value, &e.Note, scanTime{&e.Created})
+		if err != nil {
+			return nil, err
+		}
+		e.List, e.Kind = storage.AccessList(list), storage.AccessKind(kind)
+		sl = append(sl, e)
+	}
+	return sl, rows.Err()
+}
+
+func (s *Storage) ListAccessEntries(ctx context.Context) ([]storage.AccessEntry, error) {
+	return listAccessEntries(ctx, s.db)
+}
+
+func (s *Storage) AddScreeningRecord(ctx context.Context, rec storage.ScreeningRecord) error {
+	return insertScreeningRecord(ctx, s.db, rec)
+}
+
+func insertScreeningRecord(ctx context.Context, q querier, rec storage.ScreeningRecord) error {
+	_, err := q.ExecContext(ctx, `INSERT INTO screening_records (time, call,
+		channel_id, address, provider, reason, frozen)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		timeArg(rec.Time), rec.Call, rec.ChannelID, rec.Address, rec.Provider,
+		rec.Reason, rec.Frozen)
+	return err
+}
+
+func listScreeningRecords(ctx context.Context, q querier) ([]storage.ScreeningRecord, error) {
+	rows, err := q.QueryContext(ctx, `SELECT time, call, channel_id, address,
+		provider, reason, frozen FROM screening_records ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sl []storage.ScreeningRecord
+	for rows.Next() {
+		var r storage.ScreeningRecord
+		err := rows.Scan(scanTime{&r.Time}, &r.Call, &r.ChannelID, &r.Address,
+			&r.Provider, &r.Reason, &r.Frozen)
+		if err != nil {
+			return nil, err
+		}
+		sl = append(sl, r)
+	}
+	return sl, rows.Err()
+}
+
+func (s *Storage) ListScreeningRecords(ctx context.Context) ([]storage.ScreeningRecord, error) {
+	return listScreeningRecords(ctx, s.db)
+}
+
+// Make sure Storage implements storage.Storage.
+var _ storage.Storage = &Storage{}
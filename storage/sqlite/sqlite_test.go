@@ -0,0 +1,172 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/storage"
+	"github.com/luno/moonbeam/storage/storagetest"
+)
+
+func open(t *testing.T, path string) *Storage {
+	t.Helper()
+	s, err := Open(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return open(t, filepath.Join(t.TempDir(), "state.db"))
+	})
+}
+
+func TestListPaymentsPageWithoutTime(t *testing.T) {
+	ctx := context.Background()
+	s := open(t, filepath.Join(t.TempDir(), "state.db"))
+	state := storagetest.Create(t, s, "a")
+	state.Count++
+	if err := s.Update(ctx, "a", 1, state, []byte("a0")); err != nil {
+		t.Fatal(err)
+	}
+	// Payments stored before times were recorded have none.
+	if _, err := s.db.ExecContext(ctx, `UPDATE payments SET time = NULL`); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, f := range []storage.PaymentFilter{
+		{From: now.Add(-time.Hour)},
+		{To: now.Add(time.Hour)},
+	} {
+		sl, _, err := s.ListPaymentsPage(ctx, f, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sl) != 0 {
+			t.Errorf("Expected no payments for %+v, got %v", f, sl)
+		}
+	}
+	sl, _, err := s.ListPaymentsPage(ctx, storage.PaymentFilter{}, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sl) != 1 || !sl[0].Time.IsZero() {
+		t.Errorf("Expected one payment without a time, got %v", sl)
+	}
+}
+
+func TestUpdateConcurrent(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.db")
+	prev := storagetest.Create(t, open(t, path), "a")
+
+	// Updates from separate connections race to replace the same state.
+	const n = 8
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		s := open(t, path)
+		next := prev
+		next.Count++
+		next.Balance += int64(i)
+		go func() { errs <- s.Update(ctx, "a", 1, next, []byte("p")) }()
+	}
+	var ok int
+	for i := 0; i < n; i++ {
+		err := <-errs
+		if err == nil {
+			ok++
+		} else if err != storage.ErrConflict {
+			t.Error(err)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("Expected one update to succeed, got %d", ok)
+	}
+}
+
+func TestMigrateReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.db")
+	s := open(t, path)
+	storagetest.Create(t, s, "a")
+	if err := s.SetWatchHeight(ctx, 42); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = open(t, path)
+	h, err := s.GetWatchHeight(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != 42 {
+		t.Errorf("Expected watch height 42, got %d", h)
+	}
+	if _, err := s.Get(ctx, "a"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigrateDownUp(t *testing.T) {
+	ctx := context.Background()
+	s := open(t, filepath.Join(t.TempDir(), "state.db"))
+	if err := s.MigrateTo(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckSchema(ctx); err == nil {
+		t.Errorf("Expected error checking unmigrated schema")
+	}
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckSchema(ctx); err != nil {
+		t.Error(err)
+	}
+	storagetest.Create(t, s, "a")
+}
+
+func TestMigrateAdoptsUserVersion(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.db")
+	s := open(t, path)
+	storagetest.Create(t, s, "a")
+
+	// Databases created before schema_version recorded their version in
+	// user_version.
+	if err := s.MigrateTo(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.db.Exec(`DROP TABLE schema_version; PRAGMA user_version = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = open(t, path)
+	version, latest, err := s.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latest {
+		t.Errorf("Expected version %d, got %d", latest, version)
+	}
+	rec, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Channels created before record versions start at zero.
+	if rec.Version != 0 {
+		t.Errorf("Expected record version 0, got %d", rec.Version)
+	}
+	next := rec.SharedState
+	next.Count++
+	if err := s.Update(ctx, "a", 0, next, nil); err != nil {
+		t.Error(err)
+	}
+}
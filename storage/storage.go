@@ -1,25 +1,526 @@
 package storage
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/luno/moonbeam/channels"
 )
 
 var ErrNotFound = errors.New("record not found")
-var ErrConcurrentUpdate = errors.New("concurrent update")
+
+// ErrConflict is returned by Update when the channel's version has changed
+// since it was read.
+var ErrConflict = errors.New("concurrent update")
+
+// ErrConcurrentUpdate is ErrConflict.
+//
+// Deprecated: use ErrConflict.
+var ErrConcurrentUpdate = ErrConflict
+
+var ErrInvoicePaid = errors.New("invoice already paid")
 
 type Record struct {
 	ID          string
 	KeyPath     int
 	SharedState channels.SharedState
+
+	// Version is incremented on every write of SharedState, from one at
+	// Create. It's zero for channels created before versions were
+	// recorded, until their next update.
+	Version int64
+
+	// KeyGeneration is the generation of the receiver's extended key that
+	// the channel's key is derived from. It's zero for the original key.
+	KeyGeneration int
+
+	// Frozen channels must not be signed for or broadcast until an operator
+	// has investigated.
+	Frozen       bool
+	FrozenReason string
+
+	// Suspended channels don't accept payments because their funding is no
+	// longer sufficiently confirmed, for example after a reorg.
+	Suspended       bool
+	SuspendedReason string
+
+	// Closure is the receiver's closure transaction, set once the channel
+	// is closed by the receiver.
+	Closure Closure
+
+	// Created is the time the channel was opened. It's zero for channels
+	// opened before it was recorded.
+	Created time.Time
+
+	// Account is the ID of the account the channel belongs to. It's empty
+	// for the default account.
+	Account string
+
+	// Labels are metadata set at Create or by the operator, such as a
+	// customer ID or plan name.
+	Labels map[string]string
+
+	// Archived is the location of the batch in object storage that the
+	// channel's payments and revocation secrets were moved to, or Purged if
+	// they were deleted by a retention policy. The record is kept as a
+	// stub, without them.
+	Archived string
+}
+
+// Purged is the Archived location of a channel whose payments and
+// revocation secrets were deleted rather than archived.
+const Purged = "purged"
+
+// ListFilter selects the channels returned by ListPage. Zero fields match
+// all channels.
+type ListFilter struct {
+	Status       channels.Status
+	SenderPubKey []byte
+
+	// CreatedAfter and CreatedBefore bound the time the channel was opened.
+	// Channels without a recorded time match neither.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	// ByAccount restricts the results to channels of Account, which is
+	// empty for the default account.
+	ByAccount bool
+	Account   string
+
+	// Labels selects channels that have all of the labels.
+	Labels map[string]string
+}
+
+// Match reports whether the channel is selected by the filter.
+func (f ListFilter) Match(rec Record) bool {
+	if f.Status != 0 && rec.SharedState.Status != f.Status {
+		return false
+	}
+	if len(f.SenderPubKey) > 0 && !bytes.Equal(rec.SharedState.SenderPubKey, f.SenderPubKey) {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && !rec.Created.After(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && (rec.Created.IsZero() || !rec.Created.Before(f.CreatedBefore)) {
+		return false
+	}
+	if f.ByAccount && rec.Account != f.Account {
+		return false
+	}
+	for k, v := range f.Labels {
+		if rec.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Closure records a closure transaction and, once it has enough
+// confirmations, the block that confirmed it. The raw transaction is kept so
+// that it can be rebroadcast if it's evicted from the mempool.
+type Closure struct {
+	TxID      string
+	RawTx     []byte
+	BlockHash string
+	Height    int64
+
+	// Closed is when the channel was marked closed, whether its funding was
+	// spent by the closure or by another transaction such as the refund.
+	// It's zero for channels closed before it was recorded.
+	Closed time.Time
+}
+
+// Pending is a channel that has been created but not yet opened. Once its
+// funding address receives a sufficiently confirmed output, the output is
+// recorded so that Open doesn't need to look it up.
+type Pending struct {
+	FundingAddress string
+	Created        time.Time
+
+	// These are set once the funding is confirmed. Height is the block
+	// height at which it was found.
+	TxID          string
+	Vout          uint32
+	Value         int64
+	PkScript      []byte
+	Confirmations int
+	Height        int64
+}
+
+// Funded reports whether the pending channel's funding has been found.
+func (p Pending) Funded() bool {
+	return p.TxID != ""
+}
+
+// StoredPayment is an accepted payment together with its channel.
+type StoredPayment struct {
+	ChannelID string
+	Payment   []byte
+
+	// Time is when the payment was accepted. It's zero for payments stored
+	// before times were recorded.
+	Time time.Time
+}
+
+// PaymentFilter selects the payments returned by ListPaymentsPage. Zero
+// fields match all payments.
+type PaymentFilter struct {
+	ChannelID string
+
+	// From and To bound the time the payment was accepted. From is
+	// inclusive and To exclusive. Payments without a recorded time match
+	// neither.
+	From time.Time
+	To   time.Time
+}
+
+// Match reports whether the payment is selected by the filter.
+func (f PaymentFilter) Match(p StoredPayment) bool {
+	if f.ChannelID != "" && p.ChannelID != f.ChannelID {
+		return false
+	}
+	if !f.From.IsZero() && p.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && (p.Time.IsZero() || !p.Time.Before(f.To)) {
+		return false
+	}
+	return true
+}
+
+// PaymentCursor returns the cursor of a ListPaymentsPage page ending with a
+// payment of the channel, at a position given by the backend, such as the
+// payment's index.
+func PaymentCursor(channelID, pos string) string {
+	return channelID + "/" + pos
+}
+
+// ParsePaymentCursor splits a cursor returned by PaymentCursor.
+func ParsePaymentCursor(cursor string) (channelID, pos string, err error) {
+	i := strings.LastIndex(cursor, "/")
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid payment cursor %q", cursor)
+	}
+	return cursor[:i], cursor[i+1:], nil
+}
+
+// SentPayment is an accepted payment sent with a payment ID, together with
+// the encoded response, so that retries can be answered identically.
+type SentPayment struct {
+	PaymentID string
+	Payment   []byte
+	Response  []byte
+}
+
+// APIKey is a key authorizing access to the receiver's API. Only a hash of
+// the key's secret is stored.
+type APIKey struct {
+	ID      string
+	Hash    []byte
+	Scopes  []string
+	Created time.Time
+
+	// Account restricts the key to the channels of an account. It's empty
+	// for keys with access to all channels.
+	Account string
+}
+
+// TargetCredit is an accepted payment owed to the owner of its target, less
+// the receiver's commission.
+type TargetCredit struct {
+	ChannelID  string
+	Target     string
+	Amount     int64
+	Commission int64
+	Time       time.Time
+}
+
+// Tx is a set of writes that Commit stores in a single commit, so that
+// either all of them are stored or none are.
+type Tx struct {
+	// ChannelID's state is replaced by State if its version is still
+	// Version, as by Update, and Payment, if not nil, is appended to its
+	// payments.
+	ChannelID string
+	Version   int64
+	State     channels.SharedState
+	Payment   []byte
+
+	// InvoiceID, if not empty, is marked paid by the payment, with the
+	// channel's new payment count, if it hasn't been paid already.
+	InvoiceID string
+
+	// Credit, if not nil, is recorded as by AddTargetCredit.
+	Credit *TargetCredit
+}
+
+// TargetBalance accumulates the credits of a target.
+type TargetBalance struct {
+	Target     string
+	Count      int64
+	Gross      int64
+	Commission int64
+}
+
+// Net returns the amount owed to the target's owner.
+func (b TargetBalance) Net() int64 {
+	return b.Gross - b.Commission
+}
+
+// DailyTotal accumulates the credits of a target over a UTC day.
+type DailyTotal struct {
+	// Day is midnight UTC at the start of the day.
+	Day        time.Time
+	Target     string
+	Count      int64
+	Gross      int64
+	Commission int64
+}
+
+// Day returns midnight UTC at the start of t's day.
+func Day(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Net returns the amount owed to the target's owner for the day.
+func (t DailyTotal) Net() int64 {
+	return t.Gross - t.Commission
+}
+
+// Average returns the average payment of the day, rounded down.
+func (t DailyTotal) Average() int64 {
+	if t.Count == 0 {
+		return 0
+	}
+	return t.Gross / t.Count
+}
+
+// Invoice is a request for a payment to a target, issued by the receiver.
+type Invoice struct {
+	ID      string
+	Amount  int64
+	Target  string
+	Expiry  time.Time
+	Created time.Time
+
+	// Account is the account that issued the invoice. It's empty for the
+	// default account.
+	Account string
+
+	// These are set once the invoice is paid. PaidCount is the channel's
+	// payment count after the paying payment.
+	PaidChannel string
+	PaidCount   int
+	PaidTime    time.Time
+}
+
+// Paid reports whether the invoice has been paid.
+func (i Invoice) Paid() bool {
+	return i.PaidChannel != ""
+}
+
+// DeadLetter is a webhook event that couldn't be delivered.
+type DeadLetter struct {
+	URL       string
+	Event     []byte
+	Attempts  int
+	LastError string
+	Time      time.Time
+}
+
+// OutboxEvent is a channel event waiting to be published to a message
+// broker. IDs are assigned by AddOutbox in increasing order.
+type OutboxEvent struct {
+	ID        int64
+	Type      string
+	ChannelID string
+	Event     []byte
+	Time      time.Time
 }
 
 type Storage interface {
-	Get(id string) (*Record, error)
-	List() ([]Record, error)
-	Create(rec Record) error
-	Update(id string, prev, new channels.SharedState, payment []byte) error
-	ReserveKeyPath() (int, error)
-	ListPayments(channelID string) ([][]byte, error)
+	Get(ctx context.Context, id string) (*Record, error)
+	List(ctx context.Context) ([]Record, error)
+
+	// GetBySenderPubKey returns the channels whose state's SenderPubKey is
+	// senderPubKey, in ID order, from an index rather than a scan of every
+	// channel.
+	GetBySenderPubKey(ctx context.Context, senderPubKey []byte) ([]Record, error)
+
+	// ListPage returns up to limit channels matching f whose IDs sort after
+	// cursor, in ID order, and the cursor of the next page. The cursor is
+	// empty once there are no more channels. If limit is zero, all matching
+	// channels are returned.
+	ListPage(ctx context.Context, f ListFilter, cursor string, limit int) ([]Record, string, error)
+
+	// Create stores a new channel at version one, whatever rec.Version.
+	Create(ctx context.Context, rec Record) error
+
+	// Update stores the channel's new state and increments its version if
+	// its version is still version, the Version of the record the new
+	// state was derived from, and returns ErrConflict otherwise. It
+	// returns ErrFenced if ctx carries a fencing token of the channel that
+	// has been superseded.
+	Update(ctx context.Context, id string, version int64, new channels.SharedState, payment []byte) error
+
+	// ReserveKeyPath returns the next unused channel key path, starting at
+	// one. Once gapLimit paths beyond the highest path of a stored channel
+	// have been reserved, those paths are returned again in turn instead,
+	// so that a restore that scans paths until gapLimit consecutive ones
+	// are unused finds every channel. Zero gapLimit disables reuse.
+	ReserveKeyPath(ctx context.Context, gapLimit int) (int, error)
+
+	// GetKeyPathCounter returns the highest key path reserved so far.
+	GetKeyPathCounter(ctx context.Context) (int, error)
+
+	// ListKeyGenerations returns the generations of the receiver's
+	// extended key in order. The last is the current generation.
+	ListKeyGenerations(ctx context.Context) ([]KeyGeneration, error)
+
+	// AddKeyGeneration adds the extended public key as the next
+	// generation. It returns ErrDuplicateKey if it's already a generation.
+	AddKeyGeneration(ctx context.Context, xpub string) (KeyGeneration, error)
+
+	// Commit stores the writes of tx atomically. It returns the errors of
+	// Update and, if tx pays an invoice, ErrInvoicePaid if the invoice was
+	// already paid and ErrNotFound if it doesn't exist, in which case none
+	// of the writes are stored.
+	Commit(ctx context.Context, tx Tx) error
+
+	// ReserveDestinationIndex returns the next unused index of addresses
+	// derived from the extended public key, starting at zero.
+	ReserveDestinationIndex(ctx context.Context, xpub string) (uint32, error)
+
+	ListPayments(ctx context.Context, channelID string) ([][]byte, error)
+
+	// ListPaymentsPage returns up to limit payments matching f after
+	// cursor, and the cursor of the next page, like ListPage. Each
+	// channel's payments are returned together, in the order they were
+	// accepted. If limit is zero, all matching payments are returned.
+	ListPaymentsPage(ctx context.Context, f PaymentFilter, cursor string, limit int) ([]StoredPayment, string, error)
+
+	// ListChannelPayments returns the channel's payments in the order they
+	// were accepted, with the times they were accepted.
+	ListChannelPayments(ctx context.Context, channelID string) ([]StoredPayment, error)
+
+	// QueryPayments returns the payments of all channels accepted at or
+	// after from and before to, ordered by time. Zero bounds are ignored.
+	QueryPayments(ctx context.Context, from, to time.Time) ([]StoredPayment, error)
+
+	Freeze(ctx context.Context, id string, reason string) error
+
+	// Suspend sets or clears the channel's suspended flag.
+	Suspend(ctx context.Context, id string, suspended bool, reason string) error
+
+	// SetLabels replaces the labels of the channel.
+	SetLabels(ctx context.Context, id string, labels map[string]string) error
+
+	// GetWatchHeight returns the block height up to which the watcher has
+	// checked all channels, or zero if it hasn't been set.
+	GetWatchHeight(ctx context.Context) (int64, error)
+	SetWatchHeight(ctx context.Context, height int64) error
+
+	// SetClosure records the channel's closure transaction.
+	SetClosure(ctx context.Context, id string, c Closure) error
+
+	// ArchiveChannel sets the channel's Archived location and deletes its
+	// payments, revocation secrets and sent payments if its version is
+	// still version, and returns ErrConflict otherwise. The version isn't
+	// incremented.
+	ArchiveChannel(ctx context.Context, id string, version int64, location string) error
+
+	// AddRevocationSecret stores a revocation secret revealed by the sender
+	// of a revocable channel.
+	AddRevocationSecret(ctx context.Context, channelID string, secret []byte) error
+	ListRevocationSecrets(ctx context.Context, channelID string) ([][]byte, error)
+
+	// AddSentPayment records an accepted payment that was sent with a
+	// payment ID. GetSentPayment returns nil if no payment with the ID was
+	// recorded.
+	AddSentPayment(ctx context.Context, channelID string, p SentPayment) error
+	GetSentPayment(ctx context.Context, channelID string, paymentID string) (*SentPayment, error)
+
+	// PutPending creates or replaces the pending channel with the same
+	// funding address.
+	PutPending(ctx context.Context, p Pending) error
+	ListPending(ctx context.Context) ([]Pending, error)
+	DeletePending(ctx context.Context, fundingAddress string) error
+
+	// AddAPIKey stores a new API key. GetAPIKey returns nil if there is no
+	// key with the ID.
+	AddAPIKey(ctx context.Context, k APIKey) error
+	GetAPIKey(ctx context.Context, id string) (*APIKey, error)
+	DeleteAPIKey(ctx context.Context, id string) error
+
+	// AddTargetCredit records a credit and adds it to its target's balance
+	// and daily total.
+	AddTargetCredit(ctx context.Context, c TargetCredit) error
+
+	// ListTargetCredits returns the credits recorded at or after from and
+	// before to, ordered by time. Zero bounds are ignored.
+	ListTargetCredits(ctx context.Context, from, to time.Time) ([]TargetCredit, error)
+
+	// ListTargetBalances returns the balances of all credited targets,
+	// ordered by target.
+	ListTargetBalances(ctx context.Context) ([]TargetBalance, error)
+
+	// ListDailyTotals returns the daily totals of the target, or of all
+	// targets if it's empty, for the days starting at or after from and
+	// before to, ordered by day and target. Zero bounds are ignored.
+	ListDailyTotals(ctx context.Context, target string, from, to time.Time) ([]DailyTotal, error)
+
+	// AddInvoice stores a new invoice. GetInvoice returns ErrNotFound if
+	// there is no invoice with the ID.
+	AddInvoice(ctx context.Context, inv Invoice) error
+	GetInvoice(ctx context.Context, id string) (*Invoice, error)
+
+	// AddDeadLetter records a webhook event that couldn't be delivered.
+	AddDeadLetter(ctx context.Context, dl DeadLetter) error
+	ListDeadLetters(ctx context.Context) ([]DeadLetter, error)
+
+	// AddOutbox stores an event to be published and returns its ID.
+	// ListOutbox returns up to limit stored events in ID order, and
+	// DeleteOutbox removes an event once it has been published.
+	AddOutbox(ctx context.Context, e OutboxEvent) (int64, error)
+	ListOutbox(ctx context.Context, limit int) ([]OutboxEvent, error)
+	DeleteOutbox(ctx context.Context, id int64) error
+
+	// AcquireLease grants owner a lease on the ID for ttl and returns its
+	// fencing token. An owner may renew its own lease. It returns
+	// ErrLeaseHeld if another owner's lease hasn't expired.
+	AcquireLease(ctx context.Context, id, owner string, ttl time.Duration) (int64, error)
+
+	// ReleaseLease ends the lease with the token, if owner still holds it.
+	ReleaseLease(ctx context.Context, id, owner string, token int64) error
+
+	// PutAccessEntry adds the entry, replacing any with the same list, kind
+	// and value. DeleteAccessEntry returns ErrNotFound if there is no such
+	// entry.
+	PutAccessEntry(ctx context.Context, e AccessEntry) error
+	DeleteAccessEntry(ctx context.Context, list AccessList, kind AccessKind, value string) error
+	ListAccessEntries(ctx context.Context) ([]AccessEntry, error)
+
+	// GetAllowlistOnly reports whether only senders on the allow list may
+	// open channels.
+	GetAllowlistOnly(ctx context.Context) (bool, error)
+	SetAllowlistOnly(ctx context.Context, on bool) error
+
+	// AddScreeningRecord records a screening hit. ListScreeningRecords
+	// returns the records in the order they were added.
+	AddScreeningRecord(ctx context.Context, rec ScreeningRecord) error
+	ListScreeningRecords(ctx context.Context) ([]ScreeningRecord, error)
+
+	// Snapshot returns a consistent copy of the entire state. Updates are
+	// only blocked while it's copied.
+	Snapshot(ctx context.Context) (*Snapshot, error)
+
+	// Restore replaces the entire state with a snapshot's, atomically.
+	// Leases are kept, so that fencing tokens never go backwards.
+	Restore(ctx context.Context, s Snapshot) error
 }
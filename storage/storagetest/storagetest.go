@@ -0,0 +1,727 @@
+// Package storagetest checks that an implementation of storage.Storage
+// keeps the contract documented on the interface. Each backend's tests run
+// the same suite by calling Run with a function that opens an empty
+// storage, and add tests of their own only for what is particular to the
+// backend, such as migrations or indexing existing data.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luno/moonbeam/channels"
+	"github.com/luno/moonbeam/storage"
+)
+
+// Opener returns a new, empty storage, which is closed when the test ends.
+type Opener func(t *testing.T) storage.Storage
+
+// Run runs the conformance suite against the storages returned by open,
+// each test as a subtest of t.
+func Run(t *testing.T, open Opener) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, open Opener)
+	}{
+		{"Update", testUpdate},
+		{"UpdateConcurrent", testUpdateConcurrent},
+		{"UpdateFenced", testUpdateFenced},
+		{"Commit", testCommit},
+		{"CommitPaid", testCommitPaid},
+		{"ReserveKeyPath", testReserveKeyPath},
+		{"SnapshotRestore", testSnapshotRestore},
+		{"ListPage", testListPage},
+		{"ListPageBatches", testListPageBatches},
+		{"ListPageCreated", testListPageCreated},
+		{"ListPaymentsPage", testListPaymentsPage},
+		{"ListPaymentsPageTime", testListPaymentsPageTime},
+		{"GetBySenderPubKey", testGetBySenderPubKey},
+		{"ArchiveChannel", testArchiveChannel},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) { test.fn(t, open) })
+	}
+}
+
+// Create stores an open channel with a balance of 100 and returns its
+// state.
+func Create(t *testing.T, s storage.Storage, id string) channels.SharedState {
+	t.Helper()
+	state := channels.SharedState{Status: channels.StatusOpen, Balance: 100}
+	rec := storage.Record{ID: id, KeyPath: 1, SharedState: state, Created: time.Now()}
+	if err := s.Create(context.Background(), rec); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func testUpdate(t *testing.T, open Opener) {
+	ctx := context.Background()
+	s := open(t)
+	prev := Create(t, s, "a")
+
+	next := prev
+	next.Count++
+	next.Balance += 10
+	if err := s.Update(ctx, "a", 1, next, []byte("p1")); err != nil {
+		t.Fatal(err)
+	}
+	// The same update again was made from a stale version.
+	err := s.Update(ctx, "a", 1, next, []byte("p2"))
+	if err != storage.ErrConflict {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+	if err := s.Update(ctx, "b", 1, next, nil); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	rec, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.SharedState.Count != 1 || rec.SharedState.Balance != 110 {
+		t.Errorf("Unexpected state %+v", rec.SharedState)
+	}
+	if rec.Version != 2 {
+		t.Errorf("Expected version 2, got %d", rec.Version)
+	}
+	payments, err := s.ListChannelPayments(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 || string(payments[0].Payment) != "p1" || payments[0].Time.IsZero() {
+		t.Errorf("Unexpected payments %+v", payments)
+	}
+}
+
+func testUpdateConcurrent(t *testing.T, open Opener) {
+	ctx := context.Background()
+	s := open(t)
+	prev := Create(t, s, "a")
+
+	// Concurrent updates race to replace the same state.
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		next := prev
+		next.Count++
+		next.Balance += int64(i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.Update(ctx, "a", 1, next, []byte("p"))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var ok int
+	for err := range errs {
+		if err == nil {
+			ok++
+		} else if err != storage.ErrConflict {
+			t.Error(err)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("Expected one update to succeed, got %d", ok)
+	}
+	payments, err := s.ListPayments(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 {
+		t.Errorf("Expected one payment, got %d", len(payments))
+	}
+}
+
+func testUpdateFenced(t *testing.T, open Opener) {
+	ctx := context.Background()
+	s := open(t)
+	prev := Create(t, s, "a")
+
+	old, err := s.AcquireLease(ctx, "a", "x", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := s.AcquireLease(ctx, "a", "y", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AcquireLease(ctx, "a", "x", time.Minute); err != storage.ErrLeaseHeld {
+		t.Errorf("Expected ErrLeaseHeld, got %v", err)
+	}
+
+	next := prev
+	next.Count++
+	err = s.Update(storage.WithFence(ctx, "a", old), "a", 1, next, nil)
+	if err != storage.ErrFenced {
+		t.Errorf("Expected ErrFenced, got %v", err)
+	}
+}
+
+func testCommitPaid(t *testing.T, open Opener) {
+	ctx := context.Background()
+	s := open(t)
+	prev := Create(t, s, "a")
+	if err := s.AddInvoice(ctx, storage.Invoice{ID: "inv", Amount: 10, Target: "t"}); err != nil {
+		t.Fatal(err)
+	}
+
+	next := prev
+	next.Count++
+	tx := storage.Tx{ChannelID: "a", Version: 1, State: next, Payment: []byte("p"), InvoiceID: "missing"}
+	if err := s.Commit(ctx, tx); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	tx.InvoiceID = "inv"
+	if err := s.Commit(ctx, tx); err != nil {
+		t.Fatal(err)
+	}
+	after := next
+	after.Count++
+	tx = storage.Tx{ChannelID: "a", Version: 2, State: after, Payment: []byte("p"), InvoiceID: "inv"}
+	if err := s.Commit(ctx, tx); err != storage.ErrInvoicePaid {
+		t.Errorf("Expected ErrInvoicePaid, got %v", err)
+	}
+	rec, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Version != 2 {
+		t.Errorf("Expected version 2, got %d", rec.Version)
+	}
+
+	inv, err := s.GetInvoice(ctx, "inv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.PaidChannel != "a" || inv.PaidCount != 1 || inv.PaidTime.IsZero() {
+		t.Errorf("Unexpected invoice %+v", inv)
+	}
+}
+
+func testCommit(t *testing.T, open Opener) {
+	ctx := context.Background()
+	s := open(t)
+	prev := Create(t, s, "a")
+	if err := s.AddInvoice(ctx, storage.Invoice{ID: "inv", Amount: 10, Target: "t"}); err != nil {
+		t.Fatal(err)
+	}
+
+	next := prev
+	next.Count++
+	next.Balance += 10
+	tx := storage.Tx{
+		ChannelID: "a",
+		Version:   2,
+		State:     next,
+		Payment:   []byte("p"),
+		InvoiceID: "inv",
+		Credit:    &storage.TargetCredit{ChannelID: "a", Target: "t", Amount: 10, Time: time.Now()},
+	}
+	// Nothing is stored if the channel's version has changed.
+	if err := s.Commit(ctx, tx); err != storage.ErrConflict {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+	inv, err := s.GetInvoice(ctx, "inv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Paid() {
+		t.Errorf("Expected invoice to be unpaid")
+	}
+	balances, err := s.ListTargetBalances(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 0 {
+		t.Errorf("Expected no balances, got %+v", balances)
+	}
+
+	tx.Version = 1
+	if err := s.Commit(ctx, tx); err != nil {
+		t.Fatal(err)
+	}
+	if inv, err = s.GetInvoice(ctx, "inv"); err != nil {
+		t.Fatal(err)
+	}
+	if inv.PaidChannel != "a" || inv.PaidCount != 1 {
+		t.Errorf("Unexpected invoice %+v", inv)
+	}
+	if balances, err = s.ListTargetBalances(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 1 || balances[0].Target != "t" || balances[0].Gross != 10 {
+		t.Errorf("Unexpected balances %+v", balances)
+	}
+	payments, err := s.ListPayments(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 {
+		t.Errorf("Expected one payment, got %d", len(payments))
+	}
+}
+
+func testReserveKeyPath(t *testing.T, open Opener) {
+	ctx := context.Background()
+	s := open(t)
+
+	var got []int
+	for i := 0; i < 4; i++ {
+		path, err := s.ReserveKeyPath(ctx, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, path)
+	}
+	// With no channels using them, paths are reused within the gap limit.
+	want := []int{1, 2, 1, 2}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected paths %v, got %v", want, got)
+		}
+	}
+}
+
+func testSnapshotRestore(t *testing.T, open Opener) {
+	ctx := context.Background()
+	s := open(t)
+	prev := Create(t, s, "a")
+	next := prev
+	next.Count++
+	if err := s.Update(ctx, "a", 1, next, []byte("p1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRevocationSecret(ctx, "a", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	credit := storage.TargetCredit{ChannelID: "a", Target: "t", Amount: 10, Time: time.Now()}
+	if err := s.AddTargetCredit(ctx, credit); err != nil {
+		t.Fatal(err)
+	}
+	id, err := s.AddOutbox(ctx, storage.OutboxEvent{Type: "x", ChannelID: "a", Event: []byte("{}"), Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AcquireLease(ctx, "a", "x", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := s.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Channels != 1 || snap.Payments != 1 {
+		t.Errorf("Unexpected snapshot summary %+v", snap)
+	}
+
+	restored := open(t)
+	Create(t, restored, "b")
+	if _, err := restored.AcquireLease(ctx, "a", "y", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Restore(ctx, *snap); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := restored.Get(ctx, "b"); err != storage.ErrNotFound {
+		t.Errorf("Expected channel b to be replaced, got %v", err)
+	}
+	payments, err := restored.ListChannelPayments(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 || string(payments[0].Payment) != "p1" || payments[0].Time.IsZero() {
+		t.Errorf("Unexpected payments %+v", payments)
+	}
+	secrets, err := restored.ListRevocationSecrets(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 1 || !bytes.Equal(secrets[0], []byte("secret")) {
+		t.Errorf("Unexpected revocation secrets %q", secrets)
+	}
+	totals, err := restored.ListDailyTotals(ctx, "t", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || !totals[0].Day.Equal(storage.Day(credit.Time)) || totals[0].Gross != 10 {
+		t.Errorf("Unexpected daily totals %+v", totals)
+	}
+	next2, err := restored.AddOutbox(ctx, storage.OutboxEvent{Type: "y", ChannelID: "a", Event: []byte("{}"), Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next2 <= id {
+		t.Errorf("Expected outbox ID after %d, got %d", id, next2)
+	}
+	// Leases aren't part of the snapshot, so the restored storage's own
+	// lease is kept.
+	if _, err := restored.AcquireLease(ctx, "a", "x", time.Minute); err != storage.ErrLeaseHeld {
+		t.Errorf("Expected ErrLeaseHeld, got %v", err)
+	}
+}
+
+func testListPage(t *testing.T, open Opener) {
+	ctx := context.Background()
+	s := open(t)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		Create(t, s, id)
+	}
+	if err := s.SetLabels(ctx, "b", map[string]string{"plan": "pro"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetLabels(ctx, "d", map[string]string{"plan": "pro", "team": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	closed := channels.SharedState{Status: channels.StatusClosed}
+	if err := s.Update(ctx, "c", 1, closed, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sl, next, err := s.ListPage(ctx, storage.ListFilter{Status: channels.StatusOpen}, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sl) != 2 || sl[0].ID != "a" || sl[1].ID != "b" || next != "b" {
+		t.Errorf("Unexpected first page %v, %q", sl, next)
+	}
+	sl, next, err = s.ListPage(ctx, storage.ListFilter{Status: channels.StatusOpen}, next, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sl) != 1 || sl[0].ID != "d" || next != "" {
+		t.Errorf("Unexpected last page %v, %q", sl, next)
+	}
+
+	f := storage.ListFilter{Labels: map[string]string{"plan": "pro", "team": "x"}}
+	sl, _, err = s.ListPage(ctx, f, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sl) != 1 || sl[0].ID != "d" {
+		t.Errorf("Expected channel d, got %v", sl)
+	}
+}
+
+func testListPageBatches(t *testing.T, open Opener) {
+	ctx := context.Background()
+	s := open(t)
+	// More channels than a backend is likely to read at a time, so that
+	// pages span its batches.
+	const n = 250
+	for i := 0; i < n; i++ {
+		rec := storage.Record{
+			ID:      fmt.Sprintf("%03d", i),
+			KeyPath: i + 1,
+			SharedState: channels.SharedState{
+				Status:       channels.StatusOpen,
+				SenderPubKey: []byte{2, byte(i % 2)},
+			},
+			Created: time.Now(),
+		}
+		if err := s.Create(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"150", "205"} {
+		if err := s.SetLabels(ctx, id, map[string]string{"plan": "pro"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	var cursor string
+	f := storage.ListFilter{SenderPubKey: []byte{2, 1}}
+	for pages := 1; ; pages++ {
+		sl, next, err := s.ListPage(ctx, f, cursor, 40)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sl) > 40 {
+			t.Errorf("Expected at most 40 channels, got %d", len(sl))
+		}
+		for _, rec := range sl {
+			ids = append(ids, rec.ID)
+		}
+		if next == "" {
+			break
+		} else if pages > n {
+			t.Fatal("Too many pages")
+		}
+		cursor = next
+	}
+	if len(ids) != n/2 {
+		t.Fatalf("Expected %d channels, got %d", n/2, len(ids))
+	}
+	for i, id := range ids {
+		if want := fmt.Sprintf("%03d", 2*i+1); id != want {
+			t.Errorf("Expected channel %s at %d, got %s", want, i, id)
+		}
+	}
+
+	// The few channels that match are found across batches.
+	f = storage.ListFilter{Labels: map[string]string{"plan": "pro"}}
+	sl, next, err := s.ListPage(ctx, f, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sl) != 1 || sl[0].ID != "150" || next != "150" {
+		t.Errorf("Unexpected first page %v, %q", sl, next)
+	}
+	sl, next, err = s.ListPage(ctx, f, next, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sl) != 1 || sl[0].ID != "205" || next != "" {
+		t.Errorf("Unexpected last page %v, %q", sl, next)
+	}
+}
+
+func testListPageCreated(t *testing.T, open Opener) {
+	ctx := context.Background()
+	s := open(t)
+	start := time.Now()
+	Create(t, s, "a")
+	// Channels opened before their times were recorded have none, and
+	// match neither bound.
+	rec := storage.Record{ID: "b", KeyPath: 2, SharedState: channels.SharedState{Status: channels.StatusOpen}}
+	if err := s.Create(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []storage.ListFilter{
+		{CreatedAfter: start.Add(-time.Hour)},
+		{CreatedBefore: start.Add(time.Hour)},
+		{CreatedAfter: start.Add(-time.Hour), CreatedBefore: start.Add(time.Hour)},
+	} {
+		sl, _, err := s.ListPage(ctx, f, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sl) != 1 || sl[0].ID != "a" {
+			t.Errorf("Expected channel a for %+v, got %v", f, sl)
+		}
+	}
+}
+
+func testListPaymentsPage(t *testing.T, open Opener) {
+	ctx := context.Background()
+	s := open(t)
+	start := time.Now()
+	for id, n := range map[string]int{"a": 3, "b": 2} {
+		state := Create(t, s, id)
+		for i := 0; i < n; i++ {
+			state.Count++
+			payment := []byte(fmt.Sprintf("%s%d", id, i))
+			if err := s.Update(ctx, id, int64(i+1), state, payment); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var got []string
+	var cursor string
+	for pages := 1; ; pages++ {
+		sl, next, err := s.ListPaymentsPage(ctx, storage.PaymentFilter{}, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sp := range sl {
+			got = append(got, string(sp.Payment))
+		}
+		if next == "" {
+			if pages != 3 {
+				t.Errorf("Expected 3 pages, got %d", pages)
+			}
+			break
+		}
+		cursor = next
+	}
+	if s := strings.Join(got, ","); s != "a0,a1,a2,b0,b1" {
+		t.Errorf("Unexpected payments %s", s)
+	}
+
+	sl, next, err := s.ListPaymentsPage(ctx, storage.PaymentFilter{ChannelID: "b"}, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sl) != 1 || string(sl[0].Payment) != "b0" || next == "" {
+		t.Errorf("Unexpected first page %v, %q", sl, next)
+	}
+	sl, next, err = s.ListPaymentsPage(ctx, storage.PaymentFilter{ChannelID: "b"}, next, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sl) != 1 || string(sl[0].Payment) != "b1" || next != "" {
+		t.Errorf("Unexpected last page %v, %q", sl, next)
+	}
+
+	sl, _, err = s.ListPaymentsPage(ctx, storage.PaymentFilter{To: start}, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sl) != 0 {
+		t.Errorf("Expected no payments before the start, got %d", len(sl))
+	}
+}
+
+// testListPaymentsPageTime checks that payments are bounded by time like
+// channels are by their creation, and that records without a time match
+// neither bound of either filter.
+func testListPaymentsPageTime(t *testing.T, open Opener) {
+	ctx := context.Background()
+	s := open(t)
+	start := time.Now()
+	state := Create(t, s, "a")
+	state.Count++
+	if err := s.Update(ctx, "a", 1, state, []byte("a0")); err != nil {
+		t.Fatal(err)
+	}
+
+	before, after := start.Add(-time.Hour), start.Add(time.Hour)
+	tests := []struct {
+		name     string
+		from, to time.Time
+		match    bool
+	}{
+		{"from", before, time.Time{}, true},
+		{"to", time.Time{}, after, true},
+		{"from and to", before, after, true},
+		{"from later", after, time.Time{}, false},
+		{"to earlier", time.Time{}, before, false},
+	}
+	for _, test := range tests {
+		cf := storage.ListFilter{CreatedAfter: test.from, CreatedBefore: test.to}
+		chans, _, err := s.ListPage(ctx, cf, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pf := storage.PaymentFilter{From: test.from, To: test.to}
+		payments, _, err := s.ListPaymentsPage(ctx, pf, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (len(chans) == 1) != test.match || (len(payments) == 1) != test.match {
+			t.Errorf("%s: expected match %v, got channels %v and payments %v",
+				test.name, test.match, chans, payments)
+		}
+		if cf.Match(storage.Record{}) || pf.Match(storage.StoredPayment{}) {
+			t.Errorf("%s: expected records without a time not to match", test.name)
+		}
+	}
+}
+
+// CreateSenders stores open channels c and a of sender {2, 1} and b of
+// sender {2, 2}, for tests of GetBySenderPubKey.
+func CreateSenders(t *testing.T, s storage.Storage) {
+	t.Helper()
+	for _, c := range []struct {
+		id     string
+		sender byte
+	}{{"c", 1}, {"a", 1}, {"b", 2}} {
+		rec := storage.Record{ID: c.id, SharedState: channels.SharedState{
+			Status:       channels.StatusOpen,
+			SenderPubKey: []byte{2, c.sender},
+		}}
+		if err := s.Create(context.Background(), rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// CheckSenders checks that GetBySenderPubKey finds the channels stored by
+// CreateSenders.
+func CheckSenders(t *testing.T, s storage.Storage) {
+	t.Helper()
+	ctx := context.Background()
+	recs, err := s.GetBySenderPubKey(ctx, []byte{2, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || recs[0].ID != "a" || recs[1].ID != "c" {
+		t.Errorf("Expected channels a and c, got %v", recs)
+	}
+	if recs, err := s.GetBySenderPubKey(ctx, []byte{2, 3}); err != nil || len(recs) != 0 {
+		t.Errorf("Expected no channels, got %v %v", recs, err)
+	}
+}
+
+func testGetBySenderPubKey(t *testing.T, open Opener) {
+	ctx := context.Background()
+	s := open(t)
+	CreateSenders(t, s)
+	CheckSenders(t, s)
+
+	snap, err := s.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := open(t)
+	if err := restored.Restore(ctx, *snap); err != nil {
+		t.Fatal(err)
+	}
+	CheckSenders(t, restored)
+}
+
+func testArchiveChannel(t *testing.T, open Opener) {
+	ctx := context.Background()
+	s := open(t)
+	for _, id := range []string{"a", "b"} {
+		state := Create(t, s, id)
+		state.Count++
+		if err := s.Update(ctx, id, 1, state, []byte("p1")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AddRevocationSecret(ctx, id, []byte("secret")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AddSentPayment(ctx, id, storage.SentPayment{PaymentID: "x", Payment: []byte("p1"), Response: []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.ArchiveChannel(ctx, "a", 1, "batch"); err != storage.ErrConflict {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+	if err := s.ArchiveChannel(ctx, "a", 2, "batch"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ArchiveChannel(ctx, "c", 1, "batch"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	rec, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Archived != "batch" || rec.Version != 2 || rec.SharedState.Count != 1 {
+		t.Errorf("Expected a stub of version 2 in batch, got %+v", rec)
+	}
+	for id, n := range map[string]int{"a": 0, "b": 1} {
+		payments, err := s.ListPayments(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secrets, err := s.ListRevocationSecrets(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent, err := s.GetSentPayment(ctx, id, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(payments) != n || len(secrets) != n || (sent != nil) != (n > 0) {
+			t.Errorf("Expected %d of each log for %s, got %q %q %v", n, id, payments, secrets, sent)
+		}
+	}
+}
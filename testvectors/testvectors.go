@@ -0,0 +1,223 @@
+// Package testvectors generates deterministic test vectors for the channel
+// scripts and transactions. Alternative implementations can use them to
+// verify compatibility with the reference implementation.
+package testvectors
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/luno/moonbeam/channels"
+)
+
+// Fixed testnet keys and parameters used for all vectors. These keys must
+// never be used for real funds.
+const (
+	SenderPrivKeyWIF   = "cRTgZtoTP8ueH4w7nob5reYTKpFLHvDV9UfUfa67f3SMCaZkGB6L"
+	ReceiverPrivKeyWIF = "cUkJhR6V9Gjrw1enLJ7AHk37Bhtmfk3AyWkRLVhvHGYXSPj3mDLq"
+
+	SenderOutput   = "mrreYyaosje7fxCLi3pzknasHiSfziX9GY"
+	ReceiverOutput = "mnRYb3Zpn6CUR9TNDL6GGGNY9jjU1XURD5"
+
+	FundingTxID = "5b2c6c349612986a3e012bbc79e5e04d5ba965f0e8f968cf28c91681acbbeb34"
+	FundingVout = 1
+
+	Timeout  = 1008
+	Fee      = 125400
+	Capacity = 1000000
+)
+
+// Vector is a single test vector. Byte fields are hex encoded.
+type Vector struct {
+	Name string `json:"name"`
+
+	Net            string `json:"net"`
+	Timeout        int64  `json:"timeout"`
+	Fee            int64  `json:"fee"`
+	Capacity       int64  `json:"capacity"`
+	SenderPubKey   string `json:"senderPubKey"`
+	ReceiverPubKey string `json:"receiverPubKey"`
+	SenderOutput   string `json:"senderOutput"`
+	ReceiverOutput string `json:"receiverOutput"`
+	FundingTxID    string `json:"fundingTxID"`
+	FundingVout    uint32 `json:"fundingVout"`
+
+	Balance      int64    `json:"balance"`
+	Payments     []string `json:"payments"`
+	PaymentsHash string   `json:"paymentsHash"`
+
+	FundingScript  string `json:"fundingScript"`
+	FundingAddress string `json:"fundingAddress"`
+
+	ClosureTx       string `json:"closureTx"`
+	ClosureSigHash  string `json:"closureSigHash"`
+	SenderSig       string `json:"senderSig"`
+	ClosureTxSigned string `json:"closureTxSigned"`
+
+	RefundTxSigned string `json:"refundTxSigned"`
+}
+
+type testCase struct {
+	name     string
+	balance  int64
+	payments []string
+}
+
+var testCases = []testCase{
+	{name: "open", balance: 0},
+	{name: "one payment", balance: 1000, payments: []string{
+		`{"amount":1000,"target":"mgzdqkEjYEjR5QNdJxYFnCKZHuNYa5bUZ2+mb7vCiK@example.com"}`,
+	}},
+	{name: "sender output dust", balance: Capacity - Fee - 100, payments: []string{
+		`{"amount":874500,"target":"mgzdqkEjYEjR5QNdJxYFnCKZHuNYa5bUZ2+mb7vCiK@example.com"}`,
+	}},
+}
+
+func decodeKey(wif string) (*btcec.PrivateKey, error) {
+	w, err := btcutil.DecodeWIF(wif)
+	if err != nil {
+		return nil, err
+	}
+	return w.PrivKey, nil
+}
+
+func pubKey(privKey *btcec.PrivateKey) []byte {
+	return (*btcec.PublicKey)(&privKey.PublicKey).SerializeCompressed()
+}
+
+func serialize(tx *wire.MsgTx) (string, error) {
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// paymentsHash computes the paymentsHash as described in the specification.
+func paymentsHash(payments []string) [32]byte {
+	var h [32]byte
+	for _, p := range payments {
+		h = sha256.Sum256(append([]byte(p), h[:]...))
+	}
+	return h
+}
+
+func generate(tc testCase, senderKey, receiverKey *btcec.PrivateKey) (*Vector, error) {
+	ss := channels.SharedState{
+		Version:        channels.Version,
+		Net:            channels.NetTestnet3,
+		Timeout:        Timeout,
+		Fee:            Fee,
+		Status:         channels.StatusOpen,
+		SenderPubKey:   pubKey(senderKey),
+		ReceiverPubKey: pubKey(receiverKey),
+		SenderOutput:   SenderOutput,
+		ReceiverOutput: ReceiverOutput,
+		FundingTxID:    FundingTxID,
+		FundingVout:    FundingVout,
+		Capacity:       Capacity,
+		Balance:        tc.balance,
+		Count:          len(tc.payments),
+		PaymentsHash:   paymentsHash(tc.payments),
+	}
+
+	script, addr, err := ss.GetFundingScript()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := ss.GetClosureTx(ss.Balance, ss.PaymentsHash)
+	if err != nil {
+		return nil, err
+	}
+	closureTx, err := serialize(tx)
+	if err != nil {
+		return nil, err
+	}
+	sigHash, err := txscript.CalcSignatureHash(script, txscript.SigHashAll, tx, 0)
+	if err != nil {
+		return nil, err
+	}
+	senderSig, err := txscript.RawTxInSignature(
+		tx, 0, script, txscript.SigHashAll, senderKey)
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := ss.GetClosureTxSigned(ss.Balance, ss.PaymentsHash, senderSig, receiverKey)
+	if err != nil {
+		return nil, err
+	}
+	refund, err := ss.GetRefundTxSigned(senderKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Vector{
+		Name:            tc.name,
+		Net:             ss.Net,
+		Timeout:         ss.Timeout,
+		Fee:             ss.Fee,
+		Capacity:        ss.Capacity,
+		SenderPubKey:    hex.EncodeToString(ss.SenderPubKey),
+		ReceiverPubKey:  hex.EncodeToString(ss.ReceiverPubKey),
+		SenderOutput:    ss.SenderOutput,
+		ReceiverOutput:  ss.ReceiverOutput,
+		FundingTxID:     ss.FundingTxID,
+		FundingVout:     ss.FundingVout,
+		Balance:         ss.Balance,
+		Payments:        tc.payments,
+		PaymentsHash:    hex.EncodeToString(ss.PaymentsHash[:]),
+		FundingScript:   hex.EncodeToString(script),
+		FundingAddress:  addr,
+		ClosureTx:       closureTx,
+		ClosureSigHash:  hex.EncodeToString(sigHash),
+		SenderSig:       hex.EncodeToString(senderSig),
+		ClosureTxSigned: hex.EncodeToString(signed),
+		RefundTxSigned:  hex.EncodeToString(refund),
+	}, nil
+}
+
+// Generate returns the full set of test vectors. The output is deterministic.
+func Generate() ([]Vector, error) {
+	senderKey, err := decodeKey(SenderPrivKeyWIF)
+	if err != nil {
+		return nil, err
+	}
+	receiverKey, err := decodeKey(ReceiverPrivKeyWIF)
+	if err != nil {
+		return nil, err
+	}
+
+	var vl []Vector
+	for _, tc := range testCases {
+		v, err := generate(tc, senderKey, receiverKey)
+		if err != nil {
+			return nil, err
+		}
+		vl = append(vl, *v)
+	}
+	return vl, nil
+}
+
+// WriteJSON writes the test vectors to w as indented JSON.
+func WriteJSON(w io.Writer) error {
+	vl, err := Generate()
+	if err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(vl, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(buf, '\n'))
+	return err
+}
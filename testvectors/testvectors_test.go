@@ -0,0 +1,41 @@
+package testvectors
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestDeterministic(t *testing.T) {
+	var a, b bytes.Buffer
+	if err := WriteJSON(&a); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Errorf("Expected deterministic output")
+	}
+}
+
+func TestFundingAddress(t *testing.T) {
+	vl, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Matches the funding output used in the channels tests.
+	const expected = "fbe9351367de8e1e341ad62312f107b839bddb0a"
+	for _, v := range vl {
+		addr, err := btcutil.DecodeAddress(v.FundingAddress, &chaincfg.TestNet3Params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(addr.ScriptAddress()) != expected {
+			t.Errorf("%s: unexpected funding address: %s", v.Name, v.FundingAddress)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Package trace records spans for the operations of a receiver.
+//
+// Spans are created through a global Tracer, which does nothing by default.
+// Operators can install a Tracer that exports spans to their tracing system,
+// for example by adapting an OpenTelemetry TracerProvider, or use LogTracer
+// to log slow operations.
+package trace
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span is a timed operation. End must be called exactly once.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	SetError(err error)
+	End()
+}
+
+// Tracer starts spans. The returned context carries the new span so that
+// spans started from it are its children.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+var (
+	mu     sync.RWMutex
+	tracer Tracer = noopTracer{}
+)
+
+// SetTracer sets the global tracer.
+func SetTracer(t Tracer) {
+	mu.Lock()
+	defer mu.Unlock()
+	tracer = t
+}
+
+// Start starts a span using the global tracer.
+func Start(ctx context.Context, name string) (context.Context, Span) {
+	mu.RLock()
+	t := tracer
+	mu.RUnlock()
+	return t.Start(ctx, name)
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) SetError(err error)                         {}
+func (noopSpan) End()                                       {}
+
+// LogTracer logs root spans that take longer than Threshold, together with
+// the timings of their children.
+type LogTracer struct {
+	Threshold time.Duration
+}
+
+type logSpanKey struct{}
+
+type logSpan struct {
+	t      *LogTracer
+	name   string
+	start  time.Time
+	parent *logSpan
+
+	mu       sync.Mutex
+	attrs    []string
+	err      error
+	dur      time.Duration
+	children []*logSpan
+}
+
+func (t *LogTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &logSpan{t: t, name: name, start: time.Now()}
+	if p, ok := ctx.Value(logSpanKey{}).(*logSpan); ok {
+		s.parent = p
+	}
+	return context.WithValue(ctx, logSpanKey{}, s), s
+}
+
+func (s *logSpan) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, fmt.Sprintf("%s=%v", key, value))
+}
+
+func (s *logSpan) SetError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *logSpan) End() {
+	s.mu.Lock()
+	s.dur = time.Since(s.start)
+	s.mu.Unlock()
+
+	if s.parent != nil {
+		s.parent.mu.Lock()
+		s.parent.children = append(s.parent.children, s)
+		s.parent.mu.Unlock()
+		return
+	}
+
+	if s.dur < s.t.Threshold {
+		return
+	}
+	var b strings.Builder
+	s.format(&b, 0)
+	log.Printf("slow operation:\n%s", b.String())
+}
+
+func (s *logSpan) format(b *strings.Builder, depth int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintf(b, "%s%s %v", strings.Repeat("  ", depth), s.name, s.dur)
+	if len(s.attrs) > 0 {
+		fmt.Fprintf(b, " %s", strings.Join(s.attrs, " "))
+	}
+	if s.err != nil {
+		fmt.Fprintf(b, " error=%q", s.err.Error())
+	}
+	b.WriteString("\n")
+	for _, c := range s.children {
+		c.format(b, depth+1)
+	}
+}
@@ -0,0 +1,68 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNoop(t *testing.T) {
+	ctx := context.Background()
+	ctx2, span := Start(ctx, "op")
+	if ctx2 != ctx {
+		t.Errorf("Noop tracer shouldn't modify the context")
+	}
+	span.SetAttribute("k", "v")
+	span.SetError(errors.New("error"))
+	span.End()
+}
+
+func TestLogTracer(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	tr := &LogTracer{}
+	ctx, root := tr.Start(context.Background(), "receiver.Send")
+	root.SetAttribute("channel", "abc-0")
+
+	ctx2, child := tr.Start(ctx, "storage.get")
+	_, grandchild := tr.Start(ctx2, "fs.load")
+	grandchild.End()
+	child.SetError(errors.New("not found"))
+	child.End()
+
+	if buf.Len() != 0 {
+		t.Errorf("Child spans shouldn't be logged on their own")
+	}
+	root.End()
+
+	out := buf.String()
+	for _, s := range []string{
+		"receiver.Send", "channel=abc-0",
+		"\n  storage.get", `error="not found"`,
+		"\n    fs.load",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Expected %q in output:\n%s", s, out)
+		}
+	}
+}
+
+func TestLogTracerThreshold(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	tr := &LogTracer{Threshold: 1 << 62}
+	_, span := tr.Start(context.Background(), "fast")
+	span.End()
+
+	if buf.Len() != 0 {
+		t.Errorf("Fast span logged: %s", buf.String())
+	}
+}
@@ -0,0 +1,212 @@
+// Package zmq implements a minimal ZeroMQ SUB socket, sufficient for
+// receiving bitcoind's ZMQ notifications.
+//
+// Only ZMTP 3.0 over TCP with the NULL security mechanism is supported.
+package zmq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	flagMore    = 0x01
+	flagLong    = 0x02
+	flagCommand = 0x04
+
+	// maxFrameSize guards against allocating huge buffers for corrupt
+	// frames. Bitcoin blocks are well below this.
+	maxFrameSize = 64 << 20
+)
+
+var ErrProtocol = errors.New("zmq protocol error")
+
+// Subscriber is a SUB socket connected to a single publisher.
+type Subscriber struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func greeting() []byte {
+	g := make([]byte, 64)
+	g[0] = 0xff
+	g[9] = 0x7f
+	g[10] = 3 // major version
+	g[11] = 0 // minor version
+	copy(g[12:32], "NULL")
+	return g
+}
+
+func writeFrame(w io.Writer, flags byte, body []byte) error {
+	var hdr []byte
+	if len(body) > 255 {
+		hdr = make([]byte, 9)
+		hdr[0] = flags | flagLong
+		binary.BigEndian.PutUint64(hdr[1:], uint64(len(body)))
+	} else {
+		hdr = []byte{flags, byte(len(body))}
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	flags, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var size uint64
+	if flags&flagLong != 0 {
+		var buf [8]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(buf[:])
+	} else {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size = uint64(b)
+	}
+	if size > maxFrameSize {
+		return 0, nil, ErrProtocol
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return flags, body, nil
+}
+
+func readyCommand(socketType string) []byte {
+	var b bytes.Buffer
+	b.WriteByte(5)
+	b.WriteString("READY")
+	name := "Socket-Type"
+	b.WriteByte(byte(len(name)))
+	b.WriteString(name)
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(socketType)))
+	b.Write(size[:])
+	b.WriteString(socketType)
+	return b.Bytes()
+}
+
+// parseReady returns the socket type from a READY command.
+func parseReady(body []byte) (string, error) {
+	if len(body) < 6 || body[0] != 5 || string(body[1:6]) != "READY" {
+		return "", ErrProtocol
+	}
+	props := body[6:]
+	for len(props) > 0 {
+		n := int(props[0])
+		if len(props) < 1+n+4 {
+			return "", ErrProtocol
+		}
+		name := string(props[1 : 1+n])
+		vn := int(binary.BigEndian.Uint32(props[1+n : 1+n+4]))
+		props = props[1+n+4:]
+		if len(props) < vn {
+			return "", ErrProtocol
+		}
+		value := string(props[:vn])
+		props = props[vn:]
+		if strings.EqualFold(name, "Socket-Type") {
+			return value, nil
+		}
+	}
+	return "", ErrProtocol
+}
+
+// Dial connects to the publisher at addr, which may be given as
+// tcp://host:port or host:port, and subscribes to the topics.
+func Dial(addr string, topics ...string) (*Subscriber, error) {
+	addr = strings.TrimPrefix(addr, "tcp://")
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	s := &Subscriber{conn: conn, r: bufio.NewReader(conn)}
+	if err := s.handshake(topics); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Subscriber) handshake(topics []string) error {
+	s.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer s.conn.SetDeadline(time.Time{})
+
+	if _, err := s.conn.Write(greeting()); err != nil {
+		return err
+	}
+	var peer [64]byte
+	if _, err := io.ReadFull(s.r, peer[:]); err != nil {
+		return err
+	}
+	if peer[0] != 0xff || peer[9] != 0x7f || peer[10] < 3 {
+		return fmt.Errorf("%w: unsupported greeting", ErrProtocol)
+	}
+	if string(bytes.TrimRight(peer[12:32], "\x00")) != "NULL" {
+		return fmt.Errorf("%w: unsupported security mechanism", ErrProtocol)
+	}
+
+	if err := writeFrame(s.conn, flagCommand, readyCommand("SUB")); err != nil {
+		return err
+	}
+	flags, body, err := readFrame(s.r)
+	if err != nil {
+		return err
+	}
+	if flags&flagCommand == 0 {
+		return ErrProtocol
+	}
+	st, err := parseReady(body)
+	if err != nil {
+		return err
+	}
+	if st != "PUB" && st != "XPUB" {
+		return fmt.Errorf("%w: peer is %s, not PUB", ErrProtocol, st)
+	}
+
+	for _, t := range topics {
+		if err := writeFrame(s.conn, 0, append([]byte{1}, t...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Receive blocks until a message is received and returns its frames.
+func (s *Subscriber) Receive() ([][]byte, error) {
+	var msg [][]byte
+	for {
+		flags, body, err := readFrame(s.r)
+		if err != nil {
+			return nil, err
+		}
+		if flags&flagCommand != 0 {
+			continue
+		}
+		msg = append(msg, body)
+		if flags&flagMore == 0 {
+			return msg, nil
+		}
+	}
+}
+
+func (s *Subscriber) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,126 @@
+package zmq
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+// publish accepts one subscriber, checks its handshake and subscriptions,
+// and sends it the messages.
+func publish(t *testing.T, l net.Listener, topics []string, msgs [][][]byte) {
+	conn, err := l.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	if _, err := conn.Write(greeting()); err != nil {
+		t.Error(err)
+		return
+	}
+	var g [64]byte
+	if _, err := io.ReadFull(r, g[:]); err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(g[:], greeting()) {
+		t.Errorf("Unexpected greeting %x", g)
+	}
+
+	if err := writeFrame(conn, flagCommand, readyCommand("PUB")); err != nil {
+		t.Error(err)
+		return
+	}
+	flags, body, err := readFrame(r)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if st, err := parseReady(body); flags != flagCommand || err != nil || st != "SUB" {
+		t.Errorf("Unexpected READY: %x %v", body, err)
+	}
+
+	for _, topic := range topics {
+		_, body, err := readFrame(r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(body) != "\x01"+topic {
+			t.Errorf("Unexpected subscription %q", body)
+		}
+	}
+
+	for _, msg := range msgs {
+		for i, frame := range msg {
+			var flags byte
+			if i < len(msg)-1 {
+				flags = flagMore
+			}
+			if err := writeFrame(conn, flags, frame); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+}
+
+func TestSubscriber(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	block := bytes.Repeat([]byte{0xab}, 1000)
+	msgs := [][][]byte{
+		{[]byte("rawblock"), block, {0, 0, 0, 0}},
+		{[]byte("rawtx"), {1, 2, 3}, {1, 0, 0, 0}},
+	}
+	topics := []string{"rawblock", "rawtx"}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		publish(t, l, topics, msgs)
+	}()
+
+	s, err := Dial("tcp://"+l.Addr().String(), topics...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, expected := range msgs {
+		msg, err := s.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg) != len(expected) {
+			t.Fatalf("Expected %d frames, got %d", len(expected), len(msg))
+		}
+		for i := range msg {
+			if !bytes.Equal(msg[i], expected[i]) {
+				t.Errorf("Frame %d: expected %x, got %x", i, expected[i], msg[i])
+			}
+		}
+	}
+	<-done
+}
+
+func TestParseReadyInvalid(t *testing.T) {
+	for _, body := range [][]byte{
+		nil,
+		[]byte("\x05HELLO"),
+		[]byte("\x05READY\x0bSocket-Type\x00\x00\x00\x09PUB"),
+	} {
+		if _, err := parseReady(body); err == nil {
+			t.Errorf("Expected error for %q", body)
+		}
+	}
+}